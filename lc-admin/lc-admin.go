@@ -52,12 +52,16 @@ func (a *lcAdmin) printHelp() {
 	fmt.Printf("    Show this information\n")
 	fmt.Printf("  status\n")
 	fmt.Printf("    Get a full status snapshot of all Log Courier internals\n")
+	fmt.Printf("  files\n")
+	fmt.Printf("    Get the progress of harvesting for each file and how far behind it is\n")
 	fmt.Printf("  prospector [status | files [id]]\n")
 	fmt.Printf("    Get information on prospector state and running harvesters\n")
-	fmt.Printf("  publisher [status | endpoints [id]]\n")
+	fmt.Printf("  publisher [status | snapshot | endpoints [id]]\n")
 	fmt.Printf("    Get information on connectivity and endpoints\n")
 	fmt.Printf("  reload\n")
 	fmt.Printf("    Signals Log Courier to reload its configuration\n")
+	fmt.Printf("  reloadProcessors\n")
+	fmt.Printf("    Signals Log Courier to reload only the processors from its configuration\n")
 	fmt.Printf("  version\n")
 	fmt.Printf("    Get the remote version\n")
 	fmt.Printf("  debug\n")
@@ -21,8 +21,30 @@ package addresspool
 
 import (
 	"fmt"
+	"math/rand"
 	"net"
 	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SelectionOrdered returns the addresses from each lookup in the order they
+	// were returned
+	SelectionOrdered = "ordered"
+
+	// SelectionRandom returns the addresses from each lookup starting from a
+	// random address, so that connections are spread between them
+	SelectionRandom = "random"
+)
+
+var (
+	// DNS lookup functions, replaceable for testing
+	lookupSRV = net.LookupSRV
+	lookupIP  = net.LookupIP
+
+	// Random start selection, replaceable for testing
+	randomIntn = rand.Intn
 )
 
 // Pool looks up server addresses and manages a pool of IPs
@@ -30,10 +52,16 @@ type Pool struct {
 	server         string
 	rfc2782        bool
 	rfc2782Service string
+	refresh        time.Duration
+	selection      string
+	isSrv          bool
 	hostIsIP       bool
 	host           string
 	desc           string
 	addresses      []*net.TCPAddr
+	resolved       []*net.TCPAddr
+	resolvedTime   time.Time
+	health         health
 }
 
 // NewPool creates a new Pool instance for a server
@@ -50,25 +78,65 @@ func (p *Pool) SetRfc2782(enabled bool, service string) {
 	p.rfc2782Service = service
 }
 
+// SetRefresh sets how long the addresses from a lookup are used for before
+// the server is looked up again, even if not all of them have been returned,
+// so that changes such as new SRV targets are picked up. A refresh of 0 means
+// the server is only looked up again once all addresses have been returned
+func (p *Pool) SetRefresh(refresh time.Duration) {
+	p.refresh = refresh
+}
+
+// SetSelection sets how the first address is selected after each lookup, one
+// of SelectionOrdered or SelectionRandom. Subsequent addresses continue from
+// there in order, wrapping around, so every address is still tried in turn.
+// The targets of SRV records are always returned in the order described by
+// RFC 2782
+func (p *Pool) SetSelection(selection string) {
+	p.selection = selection
+}
+
 // IsLast returns true if the next call to Next will return the first address
 // in the pool. In other words, if the last call to Next returned the last entry
 // or has never been called
 func (p *Pool) IsLast() bool {
-	return p.addresses == nil
+	return p.addresses == nil || p.isExpired()
+}
+
+// isExpired returns true if the addresses from the last lookup are older than
+// the refresh interval
+func (p *Pool) isExpired() bool {
+	return p.refresh != 0 && !p.resolvedTime.IsZero() && time.Since(p.resolvedTime) >= p.refresh
 }
 
 // Next returns the next available IP address from the pool
-// Each time all IPs have been returned, the server is looked up again if
-// necessary and the IP addresses are returned again in order.
+// Each time all IPs have been returned, or the refresh interval has passed, the
+// server is looked up again if necessary and the IP addresses are returned
+// again in order. If the lookup fails, the addresses from the last successful
+// lookup are used again so that a DNS outage does not prevent reconnecting
 func (p *Pool) Next() (*net.TCPAddr, error) {
 	// Have we exhausted the address list we had? Look up the addresses again
-	// TODO: Should we expire the list to ensure old entries are not reused after
-	// many many hours/days?
-	if p.addresses == nil {
+	if p.addresses == nil || p.isExpired() {
 		p.addresses = make([]*net.TCPAddr, 0)
 		if err := p.populateAddresses(); err != nil {
-			p.addresses = nil
-			return nil, err
+			if p.resolved == nil {
+				p.addresses = nil
+				return nil, err
+			}
+
+			log.Warning("[%s] %s, using the addresses from the previous lookup", p.server, err)
+			p.addresses = append(p.addresses[:0], p.resolved...)
+		} else {
+			p.resolved = append([]*net.TCPAddr(nil), p.addresses...)
+		}
+
+		// The previous addresses are retried for a full refresh interval
+		p.resolvedTime = time.Now()
+
+		if p.selection == SelectionRandom && !p.isSrv && len(p.addresses) > 1 {
+			start := randomIntn(len(p.addresses))
+			rotated := make([]*net.TCPAddr, 0, len(p.addresses))
+			rotated = append(rotated, p.addresses[start:]...)
+			p.addresses = append(rotated, p.addresses[:start]...)
 		}
 	}
 
@@ -111,9 +179,11 @@ func (p *Pool) Desc() string {
 // populateAddresses performs the lookups necessary to obtain the pool of IP
 // addresses for the associated server
 func (p *Pool) populateAddresses() error {
-	// @hostname means SRV record where the host and port are in the record
-	if len(p.server) > 0 && p.server[0] == '@' {
-		srvs, err := p.processSrv(p.server[1:])
+	// @hostname means SRV record where the host and port are in the record, as
+	// does a full SRV name such as _service._tcp.hostname
+	p.isSrv = len(p.server) > 0 && (p.server[0] == '@' || p.server[0] == '_')
+	if p.isSrv {
+		srvs, err := p.processSrv(strings.TrimPrefix(p.server, "@"))
 		if err != nil {
 			return err
 		}
@@ -146,7 +216,9 @@ func (p *Pool) populateAddresses() error {
 	return nil
 }
 
-// processSrv looks up SRV records based on the SRV settings
+// processSrv looks up SRV records based on the SRV settings. The records are
+// returned sorted by priority and randomised by weight within each priority, as
+// described by RFC 2782, so the addresses are tried in that order
 // TODO: processSrv sets Host() to the SRV record name, not the target hostname,
 //       which would potentially break certificate name verification
 func (p *Pool) processSrv(server string) ([]*net.SRV, error) {
@@ -155,13 +227,15 @@ func (p *Pool) processSrv(server string) ([]*net.SRV, error) {
 	p.host = server
 	p.hostIsIP = false
 
-	if p.rfc2782 {
+	// Names that already begin with the service, such as _service._tcp.hostname,
+	// are looked up as given
+	if p.rfc2782 && !strings.HasPrefix(server, "_") {
 		service, protocol = p.rfc2782Service, "tcp"
 	} else {
 		service, protocol = "", ""
 	}
 
-	_, srvs, err := net.LookupSRV(service, protocol, p.host)
+	_, srvs, err := lookupSRV(service, protocol, p.host)
 	if err != nil {
 		return nil, fmt.Errorf("DNS SRV lookup failure \"%s\": %s", p.host, err)
 	} else if len(srvs) == 0 {
@@ -184,7 +258,7 @@ func (p *Pool) populateLookup(host string, port int) (bool, error) {
 	}
 
 	// Lookup the hostname in DNS
-	ips, err := net.LookupIP(host)
+	ips, err := lookupIP(host)
 	if err != nil {
 		return false, fmt.Errorf("DNS lookup failure \"%s\": %s", host, err)
 	} else if len(ips) == 0 {
@@ -18,9 +18,25 @@
 package addresspool
 
 import (
+  "errors"
+  "math/rand"
+  "net"
   "testing"
+  "time"
 )
 
+// stubLookupSRV replaces SRV lookups with one returning the given targets,
+// recording the service, protocol and name looked up
+func stubLookupSRV(lookups *[]string, targets ...*net.SRV) func() {
+  lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+    *lookups = append(*lookups, service+"/"+proto+"/"+name)
+    return "", targets, nil
+  }
+  return func() {
+    lookupSRV = net.LookupSRV
+  }
+}
+
 func TestPoolIP(t *testing.T) {
   pool := NewPool("127.0.0.1:1234")
   addr, err := pool.Next()
@@ -161,3 +177,121 @@ func TestPoolIsLast(t *testing.T) {
   // Hit 42 servers without hitting last
   t.Error("Address pool IsLast did not return correctly")
 }
+
+func TestPoolSrvFullName(t *testing.T) {
+  var lookups []string
+  defer stubLookupSRV(&lookups, &net.SRV{Target: "10.0.0.1", Port: 5043})()
+
+  pool := NewPool("_logstash._tcp.example.com")
+  pool.SetRfc2782(true, "courier")
+  addr, err := pool.Next()
+
+  if err != nil {
+    t.Error("Address pool did not parse SRV name correctly: ", err)
+  } else if addr.String() != "10.0.0.1:5043" {
+    t.Error("Address pool did not return correct addr: ", addr.String())
+  } else if len(lookups) != 1 || lookups[0] != "//_logstash._tcp.example.com" {
+    t.Error("Address pool did not look up SRV name as given: ", lookups)
+  }
+}
+
+func TestPoolSrvRfcPrefix(t *testing.T) {
+  var lookups []string
+  defer stubLookupSRV(&lookups, &net.SRV{Target: "10.0.0.1", Port: 5043}, &net.SRV{Target: "10.0.0.2", Port: 5044})()
+
+  pool := NewPool("@example.com")
+  pool.SetRfc2782(true, "courier")
+
+  // Targets are returned in the order of the SRV records
+  for _, expected := range []string{"10.0.0.1:5043", "10.0.0.2:5044"} {
+    addr, err := pool.Next()
+    if err != nil {
+      t.Fatal("Address pool did not parse RFC SRV correctly: ", err)
+    } else if addr.String() != expected {
+      t.Error("Address pool did not return correct addr: ", addr.String())
+    }
+  }
+
+  if len(lookups) != 1 || lookups[0] != "courier/tcp/example.com" {
+    t.Error("Address pool did not perform RFC SRV lookup: ", lookups)
+  }
+}
+
+func TestPoolRefreshFallback(t *testing.T) {
+  var lookups int
+  var fail bool
+  lookupIP = func(host string) ([]net.IP, error) {
+    lookups++
+    if fail {
+      return nil, errors.New("lookup failed")
+    }
+    return []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}, nil
+  }
+  defer func() {
+    lookupIP = net.LookupIP
+  }()
+
+  pool := NewPool("example.com:1234")
+  pool.SetRefresh(time.Hour)
+  if _, err := pool.Next(); err != nil {
+    t.Fatal("Address pool did not parse Host correctly: ", err)
+  }
+
+  // Within the refresh interval the remaining address is used
+  if addr, _ := pool.Next(); addr.String() != "10.0.0.2:1234" || lookups != 1 {
+    t.Error("Address pool looked up again within the refresh interval: ", addr, lookups)
+  }
+
+  // Once expired the host is looked up again before all addresses are used,
+  // and if the lookup fails the previous addresses are used
+  pool.Next()
+  pool.resolvedTime = time.Now().Add(-time.Hour)
+  if !pool.IsLast() {
+    t.Error("Address pool IsLast did not return correctly after expiry")
+  }
+
+  fail = true
+  addr, err := pool.Next()
+  if err != nil {
+    t.Error("Address pool did not fall back to the previous addresses: ", err)
+  } else if addr.String() != "10.0.0.1:1234" || lookups != 3 {
+    t.Error("Address pool did not look up again after expiry: ", addr, lookups)
+  }
+
+  // With no previous addresses the failure is returned
+  pool = NewPool("example.com:1234")
+  if _, err := pool.Next(); err == nil {
+    t.Error("Address pool did not return failure correctly")
+  }
+}
+
+func TestPoolSelectionRandom(t *testing.T) {
+  lookupIP = func(host string) ([]net.IP, error) {
+    return []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")}, nil
+  }
+  randomIntn = func(n int) int {
+    return 1
+  }
+  defer func() {
+    lookupIP = net.LookupIP
+    randomIntn = rand.Intn
+  }()
+
+  pool := NewPool("example.com:1234")
+  pool.SetSelection(SelectionRandom)
+
+  // Every address is returned in turn starting from the random one
+  for _, expected := range []string{"10.0.0.2:1234", "10.0.0.3:1234", "10.0.0.1:1234", "10.0.0.2:1234"} {
+    if addr, err := pool.Next(); err != nil {
+      t.Fatal("Address pool did not parse Host correctly: ", err)
+    } else if addr.String() != expected {
+      t.Error("Address pool returned incorrect address: ", addr, " expected ", expected)
+    }
+  }
+
+  pool = NewPool("example.com:1234")
+  pool.SetSelection(SelectionOrdered)
+  if addr, _ := pool.Next(); addr.String() != "10.0.0.1:1234" {
+    t.Error("Address pool did not return the first address when ordered: ", addr)
+  }
+}
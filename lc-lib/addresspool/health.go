@@ -0,0 +1,105 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package addresspool
+
+import (
+	"sync"
+	"time"
+)
+
+// health tracks consecutive failures for a server so that a server which
+// repeatedly fails can be evicted from rotation for a cooldown period
+type health struct {
+	mutex sync.RWMutex
+
+	maxFailures  int64
+	cooldown     time.Duration
+	failures     int64
+	evictedUntil time.Time
+}
+
+// SetHealthCheck configures the number of consecutive failures after which the
+// server is considered unhealthy, and how long it should then be evicted for
+// before it is probed again. A maxFailures of 0 disables health tracking
+func (p *Pool) SetHealthCheck(maxFailures int64, cooldown time.Duration) {
+	p.health.mutex.Lock()
+	defer p.health.mutex.Unlock()
+	p.health.maxFailures = maxFailures
+	p.health.cooldown = cooldown
+}
+
+// MarkFailure records a connection or acknowledgement failure for the server.
+// It returns true if this failure caused the server to be evicted
+func (p *Pool) MarkFailure() bool {
+	p.health.mutex.Lock()
+	defer p.health.mutex.Unlock()
+
+	p.health.failures++
+	if p.health.maxFailures == 0 || p.health.failures < p.health.maxFailures {
+		return false
+	}
+
+	// Each failure while unhealthy, including a failed probe after the cooldown
+	// has expired, restarts the cooldown period
+	p.health.evictedUntil = time.Now().Add(p.health.cooldown)
+	return true
+}
+
+// MarkSuccess records a successful acknowledgement from the server, resetting
+// the consecutive failure count. It returns true if the server was previously
+// unhealthy and has now recovered
+func (p *Pool) MarkSuccess() bool {
+	p.health.mutex.Lock()
+	defer p.health.mutex.Unlock()
+
+	wasUnhealthy := p.health.maxFailures != 0 && p.health.failures >= p.health.maxFailures
+	p.health.failures = 0
+	p.health.evictedUntil = time.Time{}
+	return wasUnhealthy
+}
+
+// IsHealthy returns false if the server is currently evicted due to repeated
+// failures. Once the cooldown period expires the server is reported as healthy
+// again so that it can be probed
+func (p *Pool) IsHealthy() bool {
+	return p.EvictionRemaining() == 0
+}
+
+// EvictionRemaining returns how long remains of the server's current eviction,
+// or 0 if the server is not evicted
+func (p *Pool) EvictionRemaining() time.Duration {
+	p.health.mutex.RLock()
+	defer p.health.mutex.RUnlock()
+
+	if p.health.evictedUntil.IsZero() {
+		return 0
+	}
+
+	remaining := p.health.evictedUntil.Sub(time.Now())
+	if remaining < 0 {
+		return 0
+	}
+
+	return remaining
+}
+
+// Failures returns the number of consecutive failures recorded for the server
+func (p *Pool) Failures() int64 {
+	p.health.mutex.RLock()
+	defer p.health.mutex.RUnlock()
+	return p.health.failures
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package addresspool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthEviction(t *testing.T) {
+	pool := NewPool("127.0.0.1:1234")
+	pool.SetHealthCheck(3, time.Hour)
+
+	if pool.MarkFailure() || pool.MarkFailure() {
+		t.Error("Server evicted before reaching failure threshold")
+	}
+	if !pool.IsHealthy() {
+		t.Error("Server unhealthy before reaching failure threshold")
+	}
+
+	if !pool.MarkFailure() {
+		t.Error("Server not evicted on reaching failure threshold")
+	}
+	if pool.IsHealthy() {
+		t.Error("Server still healthy after reaching failure threshold")
+	}
+	if pool.EvictionRemaining() <= 0 {
+		t.Error("Server eviction has no remaining time")
+	}
+
+	if !pool.MarkSuccess() {
+		t.Error("Server did not report recovery")
+	}
+	if !pool.IsHealthy() || pool.Failures() != 0 {
+		t.Error("Server not healthy after success")
+	}
+}
+
+func TestHealthProbeAfterCooldown(t *testing.T) {
+	pool := NewPool("127.0.0.1:1234")
+	pool.SetHealthCheck(1, 10*time.Millisecond)
+
+	if !pool.MarkFailure() {
+		t.Error("Server not evicted on reaching failure threshold")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if !pool.IsHealthy() {
+		t.Error("Server not available for probing after cooldown")
+	}
+
+	// A failed probe should evict again immediately
+	if !pool.MarkFailure() || pool.IsHealthy() {
+		t.Error("Server not evicted again after failed probe")
+	}
+}
+
+func TestHealthDisabled(t *testing.T) {
+	pool := NewPool("127.0.0.1:1234")
+	pool.SetHealthCheck(0, time.Hour)
+
+	for i := 0; i < 10; i++ {
+		if pool.MarkFailure() {
+			t.Error("Server evicted with health tracking disabled")
+		}
+	}
+
+	if !pool.IsHealthy() {
+		t.Error("Server unhealthy with health tracking disabled")
+	}
+}
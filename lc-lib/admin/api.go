@@ -39,7 +39,8 @@ var (
 	// this to automatically translate Request calls into Call calls to simplify
 	// logic in clients
 	callMap = map[string]interface{}{
-		"reload": nil,
+		"reload":           nil,
+		"reloadProcessors": nil,
 	}
 )
 
@@ -22,6 +22,10 @@ import (
 	"github.com/driskell/log-courier/lc-lib/core"
 )
 
+// ReloadFunc is called to reload the configuration when requested through the
+// API. If processorsOnly is true only the processors should be reloaded
+type ReloadFunc func(processorsOnly bool) error
+
 type apiRoot struct {
 	APINode
 	debug APINavigatable
@@ -36,17 +40,23 @@ func (r *apiRoot) Get(path string) (APINavigatable, error) {
 	return r.APINode.Get(path)
 }
 
-func newAPIRoot(reloadFunc func() error) *apiRoot {
+func newAPIRoot(reloadFunc ReloadFunc) *apiRoot {
 	root := &apiRoot{
 		debug: NewAPIDataEntry(&apiDebug{}),
 	}
 
 	root.SetEntry("version", NewAPIDataEntry(APIString(core.LogCourierVersion)))
 	root.SetEntry("reload", NewAPICallbackEntry(func(values url.Values) (string, error) {
-		if err := reloadFunc(); err != nil {
+		if err := reloadFunc(false); err != nil {
 			return "", err
 		}
 		return "Successfully reloaded configuration", nil
 	}))
+	root.SetEntry("reloadProcessors", NewAPICallbackEntry(func(values url.Values) (string, error) {
+		if err := reloadFunc(true); err != nil {
+			return "", err
+		}
+		return "Successfully reloaded processors", nil
+	}))
 	return root
 }
@@ -18,6 +18,7 @@ package admin
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/driskell/log-courier/lc-lib/config"
 )
@@ -37,7 +38,8 @@ type Config struct {
 	Enabled bool   `config:"enabled"`
 	Bind    string `config:"listen address"`
 
-	apiRoot APINavigatable
+	apiRoot  APINavigatable
+	apiMutex sync.Mutex
 }
 
 // InitDefaults initialises default values
@@ -56,6 +58,21 @@ func (c *Config) Validate() (err error) {
 	return
 }
 
+// InitAPI creates the root of the API, if it does not already exist, so that
+// pipeline segments can attach to it
+func (c *Config) InitAPI(reloadFunc ReloadFunc) {
+	if c.apiRoot == nil {
+		c.apiRoot = newAPIRoot(reloadFunc)
+	}
+}
+
+// APIEnabled returns true if the API is available for pipeline segments to
+// attach to, which is the case when the REST interface or the StatsD exporter
+// is enabled
+func (c *Config) APIEnabled() bool {
+	return c.apiRoot != nil
+}
+
 // SetEntry sets a new root API entry
 func (c *Config) SetEntry(path string, entry APINavigatable) {
 	c.apiRoot.(*apiRoot).SetEntry(path, entry)
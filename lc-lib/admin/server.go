@@ -40,12 +40,12 @@ type Server struct {
 }
 
 // NewServer creates a new admin listener on the pipeline
-func NewServer(pipeline *core.Pipeline, config *config.Config, reloadFunc func() error) (*Server, error) {
+func NewServer(pipeline *core.Pipeline, config *config.Config, reloadFunc ReloadFunc) (*Server, error) {
 	ret := &Server{
 		config: config.Get("admin").(*Config),
 	}
 
-	ret.config.apiRoot = newAPIRoot(reloadFunc)
+	ret.config.InitAPI(reloadFunc)
 
 	listener, err := ret.listen(ret.config)
 	if err != nil {
@@ -191,6 +191,10 @@ func (l *Server) handle(w http.ResponseWriter, r *http.Request) {
 		panic(ErrNotFound)
 	}
 
+	// The StatsD exporter also updates the API so requests must be serialised
+	l.config.apiMutex.Lock()
+	defer l.config.apiMutex.Unlock()
+
 	parts := strings.Split(r.URL.Path[1:], "/")
 	root := l.config.apiRoot
 
@@ -0,0 +1,270 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	// statsdMaxPacket keeps each packet within a typical network MTU
+	statsdMaxPacket = 1432
+)
+
+var (
+	// statsdCounters lists the API entries that only ever increase, which are
+	// sent as counters of the change since the last flush instead of gauges
+	statsdCounters = map[string]bool{
+		"alerts":                true,
+		"compressed_bytes":      true,
+		"droppedEvents":         true,
+		"event_count":           true,
+		"filtered_lines":        true,
+		"fullHandshakes":        true,
+		"lag_warnings":          true,
+		"memory_pauses":         true,
+		"open_files_limit_hits": true,
+		"processed_lines":       true,
+		"publishedLines":        true,
+		"rateAlerts":            true,
+		"rejected_lines":        true,
+		"rejectedConnections":   true,
+		"resumedHandshakes":     true,
+		"skippedBatches":        true,
+		"stale_events":          true,
+		"uncompressed_bytes":    true,
+		"writeFailures":         true,
+	}
+)
+
+// StatsdExporter periodically sends the numeric values in the API to a StatsD
+// server. Metrics are sent over UDP so an unreachable server never blocks the
+// pipeline, the metrics are just lost
+type StatsdExporter struct {
+	core.PipelineSegment
+
+	config   *Config
+	address  string
+	interval time.Duration
+	prefix   string
+
+	conn     net.Conn
+	failed   bool
+	counters map[string]float64
+}
+
+// NewStatsdExporter creates a new StatsD exporter on the pipeline. The API must
+// have been initialised with InitAPI
+func NewStatsdExporter(pipeline *core.Pipeline, config *config.Config) *StatsdExporter {
+	ret := &StatsdExporter{
+		config:   config.Get("admin").(*Config),
+		address:  config.General.StatsdAddress,
+		interval: config.General.StatsdInterval,
+		prefix:   config.General.StatsdPrefix,
+		counters: make(map[string]float64),
+	}
+
+	pipeline.Register(ret)
+
+	return ret
+}
+
+// Run flushes metrics at each interval until shutdown
+func (s *StatsdExporter) Run() {
+	defer func() {
+		s.Done()
+	}()
+
+	log.Info("[statsd] Sending metrics to %s every %s", s.address, s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+StatsdLoop:
+	for {
+		select {
+		case <-s.OnShutdown():
+			break StatsdLoop
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+
+	if s.conn != nil {
+		s.conn.Close()
+	}
+
+	log.Info("[statsd] Exporter exiting")
+}
+
+// flush collects the current values from the API and sends them
+func (s *StatsdExporter) flush() {
+	metrics, err := s.collect()
+	if err != nil {
+		log.Warning("[statsd] Failed to collect metrics: %s", err)
+		return
+	}
+
+	if s.conn == nil {
+		if s.conn, err = net.Dial("udp", s.address); err != nil {
+			s.conn = nil
+			s.logFailure(err)
+			return
+		}
+	}
+
+	var packet bytes.Buffer
+	for _, metric := range metrics {
+		if packet.Len() != 0 && packet.Len()+1+len(metric) > statsdMaxPacket {
+			s.send(packet.Bytes())
+			packet.Reset()
+		}
+
+		if packet.Len() != 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(metric)
+	}
+
+	if packet.Len() != 0 {
+		s.send(packet.Bytes())
+	}
+}
+
+// collect updates the API and returns its numeric values in StatsD format
+func (s *StatsdExporter) collect() ([]string, error) {
+	s.config.apiMutex.Lock()
+	err := s.config.apiRoot.Update()
+	var encoded []byte
+	if err == nil {
+		encoded, err = json.Marshal(s.config.apiRoot)
+	}
+	s.config.apiMutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+
+	var values interface{}
+	if err = decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+
+	var metrics []string
+	s.walk(s.prefix, "", values, &metrics)
+	return metrics, nil
+}
+
+// walk recurses into the API values, adding each number it finds to metrics
+func (s *StatsdExporter) walk(name string, key string, value interface{}, metrics *[]string) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for childKey, child := range value {
+			s.walk(name+"."+statsdSanitize(childKey), childKey, child, metrics)
+		}
+	case []interface{}:
+		for i, child := range value {
+			s.walk(name+"."+statsdSanitize(statsdRowName(i, child)), key, child, metrics)
+		}
+	case json.Number:
+		number, err := value.Float64()
+		if err != nil {
+			return
+		}
+
+		if statsdCounters[key] {
+			delta := number - s.counters[name]
+			if delta < 0 {
+				// Reset, such as a restarted harvester
+				delta = number
+			}
+			s.counters[name] = number
+			*metrics = append(*metrics, fmt.Sprintf("%s:%s|c", name, strconv.FormatFloat(delta, 'f', -1, 64)))
+			return
+		}
+
+		if number < 0 {
+			// A leading sign is a relative change to a gauge, so set to zero first
+			*metrics = append(*metrics, fmt.Sprintf("%s:0|g", name))
+		}
+		*metrics = append(*metrics, fmt.Sprintf("%s:%s|g", name, strconv.FormatFloat(number, 'f', -1, 64)))
+	}
+}
+
+// send writes a packet, ignoring failures other than to log them
+func (s *StatsdExporter) send(packet []byte) {
+	if _, err := s.conn.Write(packet); err != nil {
+		s.logFailure(err)
+		return
+	}
+
+	if s.failed {
+		log.Notice("[statsd] Sending metrics to %s succeeded", s.address)
+		s.failed = false
+	}
+}
+
+// logFailure logs a failure to send metrics, only warning the first time so
+// the log is not flooded while the server is unavailable
+func (s *StatsdExporter) logFailure(err error) {
+	if s.failed {
+		log.Debug("[statsd] Failed to send metrics to %s: %s", s.address, err)
+		return
+	}
+
+	log.Warning("[statsd] Failed to send metrics to %s, they will be dropped until it is available: %s", s.address, err)
+	s.failed = true
+}
+
+// statsdRowName returns the name to use for an array entry, preferring its
+// "id" or "path" so names remain stable as entries are added and removed
+func statsdRowName(row int, value interface{}) string {
+	if entry, ok := value.(map[string]interface{}); ok {
+		if id, ok := entry["id"].(string); ok && id != "" {
+			return id
+		}
+		if path, ok := entry["path"].(string); ok && path != "" {
+			return path
+		}
+	}
+
+	return strconv.Itoa(row)
+}
+
+// statsdSanitize replaces characters that have special meaning to StatsD, or
+// which are awkward in metric names, with underscores
+func statsdSanitize(name string) string {
+	sanitized := []byte(name)
+	for i, c := range sanitized {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' && c != '-' {
+			sanitized[i] = '_'
+		}
+	}
+
+	return string(sanitized)
+}
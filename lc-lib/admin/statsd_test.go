@@ -0,0 +1,78 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package admin
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func createTestStatsdExporter() (*StatsdExporter, *APIKeyValue) {
+	config := &Config{}
+	config.InitAPI(func(bool) error { return nil })
+
+	status := &APIKeyValue{}
+	node := &APINode{}
+	node.SetEntry("status", status)
+	config.SetEntry("publisher", node)
+
+	return &StatsdExporter{
+		config:   config,
+		prefix:   "test",
+		counters: make(map[string]float64),
+	}, status
+}
+
+func checkStatsdMetrics(t *testing.T, exporter *StatsdExporter, expected ...string) {
+	metrics, err := exporter.collect()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	sort.Strings(metrics)
+	sort.Strings(expected)
+	if strings.Join(metrics, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected metrics:\n%s\nExpected:\n%s", strings.Join(metrics, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestStatsdCollect(t *testing.T) {
+	exporter, status := createTestStatsdExporter()
+
+	status.SetEntry("speed", APIFloat(1.5))
+	status.SetEntry("publishedLines", APINumber(10))
+	status.SetEntry("backlog", APIString("ok"))
+	checkStatsdMetrics(t, exporter, "test.publisher.status.speed:1.5|g", "test.publisher.status.publishedLines:10|c")
+
+	// Counters are sent as the change since the last flush
+	status.SetEntry("publishedLines", APINumber(25))
+	checkStatsdMetrics(t, exporter, "test.publisher.status.speed:1.5|g", "test.publisher.status.publishedLines:15|c")
+}
+
+func TestStatsdNegativeGauge(t *testing.T) {
+	exporter, status := createTestStatsdExporter()
+
+	status.SetEntry("size", APINumber(-1))
+	checkStatsdMetrics(t, exporter, "test.publisher.status.size:0|g", "test.publisher.status.size:-1|g")
+}
+
+func TestStatsdSanitize(t *testing.T) {
+	if name := statsdSanitize("/var/log/app.log"); name != "_var_log_app_log" {
+		t.Errorf("Unexpected sanitized name: %s", name)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// EventConnected is recorded when an outbound connection is established
+	EventConnected = "connected"
+
+	// EventConnectFailed is recorded when an outbound connection could not be
+	// established, including when the TLS handshake fails
+	EventConnectFailed = "connect failed"
+
+	// EventDisconnected is recorded when an outbound connection is closed
+	EventDisconnected = "disconnected"
+
+	// EventAccepted is recorded when a receiver accepts a connection
+	EventAccepted = "accepted"
+
+	// EventClosed is recorded when a receiver connection is closed
+	EventClosed = "closed"
+)
+
+var (
+	tlsVersionNames = map[uint16]string{
+		tls.VersionTLS10: "TLS 1.0",
+		tls.VersionTLS11: "TLS 1.1",
+		tls.VersionTLS12: "TLS 1.2",
+		tls.VersionTLS13: "TLS 1.3",
+	}
+)
+
+// Entry is the structure written to the audit log for each connection event
+// Only public details of the peer certificate are recorded so that no key
+// material can appear in the audit log
+type Entry struct {
+	Timestamp   string `json:"@timestamp"`
+	Event       string `json:"event"`
+	Server      string `json:"server,omitempty"`
+	Listen      string `json:"listen,omitempty"`
+	Local       string `json:"local,omitempty"`
+	Remote      string `json:"remote,omitempty"`
+	TLSVersion  string `json:"tls_version,omitempty"`
+	CipherSuite string `json:"cipher_suite,omitempty"`
+	PeerSubject string `json:"peer_subject,omitempty"`
+	PeerIssuer  string `json:"peer_issuer,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// NewEntry creates a new entry for the given event on a connection, which may
+// be nil if the connection was never established. The TLS details are filled
+// in if the connection is a TLS connection that has completed its handshake
+func NewEntry(event string, conn net.Conn) *Entry {
+	ret := &Entry{
+		Timestamp: time.Now().Format(time.RFC3339Nano),
+		Event:     event,
+	}
+
+	if conn == nil {
+		return ret
+	}
+
+	ret.Local = conn.LocalAddr().String()
+	ret.Remote = conn.RemoteAddr().String()
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		ret.setTLS(tlsConn.ConnectionState())
+	}
+
+	return ret
+}
+
+// setTLS records the negotiated TLS parameters and the subject and issuer of
+// the peer certificate, if one was presented
+func (e *Entry) setTLS(state tls.ConnectionState) {
+	if !state.HandshakeComplete {
+		return
+	}
+
+	if name, ok := tlsVersionNames[state.Version]; ok {
+		e.TLSVersion = name
+	} else {
+		e.TLSVersion = fmt.Sprintf("0x%04x", state.Version)
+	}
+
+	e.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+
+	if len(state.PeerCertificates) != 0 {
+		e.PeerSubject = state.PeerCertificates[0].Subject.String()
+		e.PeerIssuer = state.PeerCertificates[0].Issuer.String()
+	}
+}
+
+// Write records the entry in the audit log. It does nothing if the audit log
+// is not enabled
+func (c *Config) Write(e *Entry) error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	encoded, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return c.writer.write(append(encoded, '\n'))
+}
+
+// writer appends entries to the audit log
+// The file is opened for each write as connection events are infrequent, and
+// this avoids holding a file open across configuration reloads
+type writer struct {
+	mutex sync.Mutex
+	path  string
+}
+
+func (w *writer) write(data []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
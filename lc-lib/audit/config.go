@@ -0,0 +1,46 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+// Config holds the audit log configuration
+type Config struct {
+	Path string `config:"path"`
+
+	writer writer
+}
+
+// Validate validates the config structure
+func (c *Config) Validate() (err error) {
+	c.writer.path = c.Path
+	return
+}
+
+// Enabled returns true if an audit log path is configured
+func (c *Config) Enabled() bool {
+	return c.Path != ""
+}
+
+func init() {
+	config.RegisterConfigSection("audit", func() config.Section {
+		c := &Config{}
+		return c
+	})
+}
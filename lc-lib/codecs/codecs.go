@@ -29,11 +29,23 @@ type Codec interface {
 	APIEncodable() admin.APIEncodable
 }
 
+// Flusher is implemented by codecs that buffer lines, so that the Harvester can
+// have them emit any partial event they hold when no more lines are expected,
+// such as when the file has been idle for the dead time
+type Flusher interface {
+	Flush()
+}
+
 // CallbackFunc is a callback function that a codec will call for each of its
 // "output" events. It could be called at any time by any routine (not
 // necessarily the routine providing the "input" events.)
 type CallbackFunc func(int64, int64, string)
 
+// FieldsCallbackFunc is a callback function like CallbackFunc that also
+// receives any fields the codec adds to the event, such as the number of lines
+// it collapsed. The fields will be nil if the codec added none
+type FieldsCallbackFunc func(int64, int64, string, map[string]interface{})
+
 // codecFactory is the interface that all codec factories implement. The codec
 // factory should store the codec's configuration and, when NewCodec is called,
 // return an instance of the codec that obeys that configuration
@@ -41,7 +53,26 @@ type codecFactory interface {
 	NewCodec(CallbackFunc, int64) Codec
 }
 
+// fieldsCodecFactory is implemented by codec factories whose codecs can add
+// fields to the events they emit
+type fieldsCodecFactory interface {
+	NewFieldsCodec(FieldsCallbackFunc, int64) Codec
+}
+
 // NewCodec returns a Codec interface initialised from the given Factory
 func NewCodec(factory interface{}, callbackFunc CallbackFunc, offset int64) Codec {
 	return factory.(codecFactory).NewCodec(callbackFunc, offset)
 }
+
+// NewFieldsCodec returns a Codec interface initialised from the given Factory
+// that passes any fields it adds to the events to the callback. It is used for
+// the final codec of a chain, as fields can not be passed between codecs
+func NewFieldsCodec(factory interface{}, callbackFunc FieldsCallbackFunc, offset int64) Codec {
+	if fieldsFactory, ok := factory.(fieldsCodecFactory); ok {
+		return fieldsFactory.NewFieldsCodec(callbackFunc, offset)
+	}
+
+	return NewCodec(factory, func(startOffset int64, endOffset int64, text string) {
+		callbackFunc(startOffset, endOffset, text, nil)
+	}, offset)
+}
@@ -37,6 +37,7 @@ type CodecMultilineFactory struct {
 	Match             string        `config:"match"`
 	What              string        `config:"what"`
 	PreviousTimeout   time.Duration `config:"previous timeout"`
+	MaxLines          int64         `config:"max lines"`
 	MaxMultilineBytes int64         `config:"max multiline bytes"`
 
 	patterns PatternCollection
@@ -87,6 +88,10 @@ func NewMultilineCodecFactory(config *config.Config, configPath string, unused m
 		return nil, fmt.Errorf("Unknown \"what\" value for multiline codec, '%s'.", result.What)
 	}
 
+	if result.MaxLines < 0 {
+		return nil, fmt.Errorf("max lines can not be negative")
+	}
+
 	if result.MaxMultilineBytes == 0 {
 		result.MaxMultilineBytes = config.General.SpoolMaxBytes
 	}
@@ -201,6 +206,12 @@ func (c *CodecMultiline) Event(startOffset int64, endOffset int64, text string)
 	c.bufferLines++
 	c.bufferLen += textLen
 
+	// Flush if the max lines was reached, so a runaway multiline event does not
+	// keep growing. Any further matching lines will begin a new event
+	if c.config.MaxLines != 0 && c.bufferLines >= c.config.MaxLines {
+		c.flush()
+	}
+
 	if c.config.what == codecMultilineWhatPrevious {
 		if c.config.PreviousTimeout != 0 {
 			// Reset the timer and unlock
@@ -212,6 +223,17 @@ func (c *CodecMultiline) Event(startOffset int64, endOffset int64, text string)
 	}
 }
 
+// Flush emits any buffered lines as an event, and is called by the Harvester
+// when no more lines are expected so a partial event is not held forever
+func (c *CodecMultiline) Flush() {
+	if c.config.PreviousTimeout != 0 {
+		c.timerLock.Lock()
+		defer c.timerLock.Unlock()
+	}
+
+	c.flush()
+}
+
 // flush is called internally when a multiline event is ready.
 // It combines the lines collected and passes the new event to the callback
 func (c *CodecMultiline) flush() {
@@ -364,6 +364,43 @@ func TestMultilineReset(t *testing.T) {
 	}
 }
 
+func TestMultilineFlush(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 5, "DEBUG First line\nNEXT line"},
+			{6, 9, "DEBUG Last line\nNEXT line"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"patterns": []string{"^NEXT "},
+			"what":     "previous",
+		},
+		check.EventCallback,
+		t,
+	)
+
+	codec.Event(0, 3, "DEBUG First line")
+	codec.Event(4, 5, "NEXT line")
+	codec.Event(6, 7, "DEBUG Last line")
+	codec.Event(8, 9, "NEXT line")
+
+	check.CheckCurrentCount(1, "Incorrect line count before flush")
+
+	// The partial event is emitted when flushed
+	codec.(Flusher).Flush()
+	codec.(Flusher).Flush()
+
+	check.CheckFinalCount()
+
+	offset := codec.Teardown()
+	if offset != 9 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
+
 func TestMultilineMultiplePattern(t *testing.T) {
 	check := &checkMultiline{
 		expect: []checkMultilineExpect{
@@ -424,3 +461,38 @@ func TestMultilineMultiplePatternAll(t *testing.T) {
 		t.Error("Teardown returned incorrect offset: ", offset)
 	}
 }
+
+func TestMultilineMaxLines(t *testing.T) {
+	check := &checkMultiline{
+		expect: []checkMultilineExpect{
+			{0, 28, "DEBUG First line\nsecond line"},
+			{29, 55, "third line\nfourth line"},
+			{56, 71, "DEBUG Next line"},
+		},
+		t: t,
+	}
+
+	codec := createMultilineCodec(
+		map[string]interface{}{
+			"max lines": int64(2),
+			"patterns":  []string{"!^DEBUG "},
+		},
+		check.EventCallback,
+		t,
+	)
+
+	// Send some data
+	codec.Event(0, 16, "DEBUG First line")
+	codec.Event(17, 28, "second line")
+	codec.Event(29, 39, "third line")
+	codec.Event(40, 55, "fourth line")
+	codec.Event(56, 71, "DEBUG Next line")
+	codec.(Flusher).Flush()
+
+	check.CheckFinalCount()
+
+	offset := codec.Teardown()
+	if offset != 71 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
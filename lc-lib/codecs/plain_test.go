@@ -0,0 +1,54 @@
+package codecs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// plainBenchmarkLine is a typical single field log line
+var plainBenchmarkLine = "2016/01/02 15:04:05 INFO Request completed in 42ms for " + strings.Repeat("x", 40)
+
+func createPlainCodec(callback CallbackFunc, b *testing.B) Codec {
+	factory, err := NewPlainCodecFactory(config.NewConfig(), "", map[string]interface{}{}, "plain")
+	if err != nil {
+		b.Fatalf("Failed to create plain codec: %s", err)
+	}
+
+	return NewCodec(factory, callback, 0)
+}
+
+// BenchmarkPlainCodec measures the cost of passing lines through the plain
+// codec alone
+func BenchmarkPlainCodec(b *testing.B) {
+	var offset int64
+	codec := createPlainCodec(func(startOffset int64, endOffset int64, text string) {}, b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		codec.Event(offset, offset+int64(len(plainBenchmarkLine)), plainBenchmarkLine)
+		offset += int64(len(plainBenchmarkLine)) + 1
+	}
+}
+
+// BenchmarkPlainCodecEncode measures the plain codec along with storing the
+// line in the message field and encoding the event, as happens for every line
+// shipped, for comparison with BenchmarkPlainCodec
+func BenchmarkPlainCodecEncode(b *testing.B) {
+	var offset int64
+	codec := createPlainCodec(func(startOffset int64, endOffset int64, text string) {
+		event := core.Event{}
+		event.SetPath("message", text)
+		if _, err := event.Encode("message"); err != nil {
+			b.Fatalf("Failed to encode event: %s", err)
+		}
+	}, b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		codec.Event(offset, offset+int64(len(plainBenchmarkLine)), plainBenchmarkLine)
+		offset += int64(len(plainBenchmarkLine)) + 1
+	}
+}
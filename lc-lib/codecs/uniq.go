@@ -0,0 +1,284 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package codecs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+const (
+	defaultUniqCountField string        = "repeat_count"
+	defaultUniqTimeout    time.Duration = 5 * time.Second
+)
+
+// CodecUniqFactory holds the configuration for a uniq codec
+type CodecUniqFactory struct {
+	CountField string        `config:"count field"`
+	Pattern    string        `config:"pattern"`
+	Timeout    time.Duration `config:"timeout"`
+
+	pattern *regexp.Regexp
+}
+
+// CodecUniq is an instance of a uniq codec that is used by the Harvester to
+// collapse consecutive identical lines into a single event
+type CodecUniq struct {
+	config       *CodecUniqFactory
+	lastOffset   int64
+	callbackFunc FieldsCallbackFunc
+
+	startOffset   int64
+	endOffset     int64
+	text          string
+	key           string
+	count         int64
+	collapsed     uint64
+	timerLock     sync.Mutex
+	timerStop     chan interface{}
+	timerWait     sync.WaitGroup
+	timerDeadline time.Time
+
+	meterLines     int64
+	meterBytes     int64
+	meterCollapsed uint64
+}
+
+// NewUniqCodecFactory creates a new UniqCodecFactory for a codec definition in
+// the configuration file. This factory can be used to create instances of a
+// uniq codec for use by harvesters
+func NewUniqCodecFactory(config *config.Config, configPath string, unused map[string]interface{}, name string) (interface{}, error) {
+	var err error
+
+	result := &CodecUniqFactory{}
+	if err = config.PopulateConfig(result, unused, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.CountField == "" {
+		return nil, errors.New("Uniq codec count field must not be empty.")
+	}
+
+	if result.Pattern != "" {
+		if result.pattern, err = regexp.Compile(result.Pattern); err != nil {
+			return nil, fmt.Errorf("Failed to compile uniq codec pattern, '%s': %s", result.Pattern, err)
+		}
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for a uniq codec
+func (f *CodecUniqFactory) InitDefaults() {
+	f.CountField = defaultUniqCountField
+	f.Timeout = defaultUniqTimeout
+}
+
+// key returns the part of the line that is compared to decide if lines are
+// identical. When a pattern is configured this is the first capture group, or
+// the whole match if there are no groups. Lines that do not match the pattern
+// are compared in full
+func (f *CodecUniqFactory) key(text string) string {
+	if f.pattern == nil {
+		return text
+	}
+
+	matches := f.pattern.FindStringSubmatch(text)
+	if matches == nil {
+		return text
+	}
+	if len(matches) > 1 {
+		return matches[1]
+	}
+	return matches[0]
+}
+
+// NewCodec returns a new codec instance that will send events to the callback
+// function provided upon completion of processing. The count of collapsed lines
+// is not available through this callback, so a uniq codec should be the final
+// codec of a chain
+func (f *CodecUniqFactory) NewCodec(callbackFunc CallbackFunc, offset int64) Codec {
+	return f.NewFieldsCodec(func(startOffset int64, endOffset int64, text string, fields map[string]interface{}) {
+		callbackFunc(startOffset, endOffset, text)
+	}, offset)
+}
+
+// NewFieldsCodec returns a new codec instance that will send events to the
+// callback function provided along with the count of collapsed lines
+func (f *CodecUniqFactory) NewFieldsCodec(callbackFunc FieldsCallbackFunc, offset int64) Codec {
+	c := &CodecUniq{
+		config:       f,
+		endOffset:    offset,
+		lastOffset:   offset,
+		callbackFunc: callbackFunc,
+	}
+
+	// Start the timeout routine that will auto flush at deadline
+	if f.Timeout != 0 {
+		c.timerStop = make(chan interface{})
+		c.timerWait.Add(1)
+
+		c.timerDeadline = time.Now().Add(f.Timeout)
+
+		go c.deadlineRoutine()
+	}
+	return c
+}
+
+// Teardown ends the codec and returns the last offset shipped to the callback
+func (c *CodecUniq) Teardown() int64 {
+	if c.config.Timeout != 0 {
+		close(c.timerStop)
+		c.timerWait.Wait()
+	}
+
+	return c.lastOffset
+}
+
+// Reset restores the codec to a blank state so it can be reused on a new file
+// stream
+func (c *CodecUniq) Reset() {
+	c.lastOffset = 0
+	c.text = ""
+	c.key = ""
+	c.count = 0
+}
+
+// Event is called by a Harvester when a new line event occurs on a file. A line
+// identical to the one held is collapsed into it, otherwise the held line is
+// shipped to the callback and the new line held in its place
+func (c *CodecUniq) Event(startOffset int64, endOffset int64, text string) {
+	key := c.config.key(text)
+
+	if c.config.Timeout != 0 {
+		// Prevent a flush happening while we're modifying the stored data
+		c.timerLock.Lock()
+		defer c.timerLock.Unlock()
+	}
+
+	if c.count != 0 && key == c.key {
+		c.count++
+		c.collapsed++
+		c.endOffset = endOffset
+	} else {
+		c.flush()
+
+		c.startOffset = startOffset
+		c.endOffset = endOffset
+		c.text = text
+		c.key = key
+		c.count = 1
+	}
+
+	if c.config.Timeout != 0 {
+		c.timerDeadline = time.Now().Add(c.config.Timeout)
+	}
+}
+
+// Flush emits the held line, and is called by the Harvester when no more lines
+// are expected so it is not held forever
+func (c *CodecUniq) Flush() {
+	if c.config.Timeout != 0 {
+		c.timerLock.Lock()
+		defer c.timerLock.Unlock()
+	}
+
+	c.flush()
+}
+
+// flush passes the held line to the callback. The event covers the offsets of
+// all the lines collapsed into it, and when there was more than one it carries
+// their count
+func (c *CodecUniq) flush() {
+	if c.count == 0 {
+		return
+	}
+
+	var fields map[string]interface{}
+	if c.count > 1 {
+		fields = map[string]interface{}{c.config.CountField: c.count}
+	}
+
+	text := c.text
+
+	// Set last offset - this is returned in Teardown so if we crash while holding
+	// a line we start from it again
+	c.lastOffset = c.endOffset
+	c.text = ""
+	c.key = ""
+	c.count = 0
+
+	c.callbackFunc(c.startOffset, c.endOffset, text, fields)
+}
+
+// Meter is called by the Harvester to request accounting
+func (c *CodecUniq) Meter() {
+	c.meterLines = c.count
+	c.meterBytes = c.endOffset - c.lastOffset
+	c.meterCollapsed = c.collapsed
+}
+
+// APIEncodable is called to get the codec status for the API
+func (c *CodecUniq) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("pending_lines", admin.APINumber(c.meterLines))
+	api.SetEntry("pending_bytes", admin.APINumber(c.meterBytes))
+	api.SetEntry("collapsed_lines", admin.APINumber(c.meterCollapsed))
+	return api
+}
+
+func (c *CodecUniq) deadlineRoutine() {
+	timer := time.NewTimer(0)
+
+DeadlineLoop:
+	for {
+		select {
+		case <-c.timerStop:
+			timer.Stop()
+
+			// Shutdown signal so end the routine
+			break DeadlineLoop
+		case now := <-timer.C:
+			c.timerLock.Lock()
+
+			// Have we reached the target time?
+			if !now.After(c.timerDeadline) {
+				// Deadline moved, update the timer
+				timer.Reset(c.timerDeadline.Sub(now))
+				c.timerLock.Unlock()
+				continue
+			}
+
+			c.flush()
+			timer.Reset(c.config.Timeout)
+			c.timerLock.Unlock()
+		}
+	}
+
+	c.timerWait.Done()
+}
+
+// Register the codec
+func init() {
+	config.RegisterCodec("uniq", NewUniqCodecFactory)
+}
@@ -0,0 +1,153 @@
+package codecs
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+func createUniqCodec(unused map[string]interface{}, callback FieldsCallbackFunc, t *testing.T) Codec {
+	factory, err := NewUniqCodecFactory(config.NewConfig(), "", unused, "uniq")
+	if err != nil {
+		t.Errorf("Failed to create uniq codec: %s", err)
+		t.FailNow()
+	}
+
+	return NewFieldsCodec(factory, callback, 0)
+}
+
+type checkUniqExpect struct {
+	start, end int64
+	text       string
+	count      int64
+}
+
+type checkUniq struct {
+	expect []checkUniqExpect
+	t      *testing.T
+
+	mutex sync.Mutex
+	lines int
+}
+
+func (c *checkUniq) EventCallback(startOffset int64, endOffset int64, text string, fields map[string]interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.lines >= len(c.expect) {
+		c.t.Errorf("Too many lines received: %s", text)
+		c.t.FailNow()
+	}
+
+	expect := c.expect[c.lines]
+	c.lines++
+
+	if startOffset != expect.start || endOffset != expect.end {
+		c.t.Errorf("Offsets incorrect for line %d: got %d-%d, expected %d-%d", c.lines, startOffset, endOffset, expect.start, expect.end)
+	}
+
+	if text != expect.text {
+		c.t.Errorf("Text incorrect for line %d: got [%s], expected [%s]", c.lines, text, expect.text)
+	}
+
+	if expect.count == 0 {
+		if fields != nil {
+			c.t.Errorf("Unexpected fields for line %d: %v", c.lines, fields)
+		}
+	} else if fields["repeat_count"] != expect.count {
+		c.t.Errorf("Repeat count incorrect for line %d: got %v, expected %d", c.lines, fields["repeat_count"], expect.count)
+	}
+}
+
+func (c *checkUniq) CheckCurrentCount(count int, message string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.lines != count {
+		c.t.Errorf("%s: got %d, expected %d", message, c.lines, count)
+	}
+}
+
+func TestUniq(t *testing.T) {
+	check := &checkUniq{
+		expect: []checkUniqExpect{
+			{0, 1, "first line", 0},
+			{2, 7, "still waiting", 3},
+			{8, 9, "last line", 0},
+		},
+		t: t,
+	}
+
+	codec := createUniqCodec(map[string]interface{}{}, check.EventCallback, t)
+
+	codec.Event(0, 1, "first line")
+	codec.Event(2, 3, "still waiting")
+	codec.Event(4, 5, "still waiting")
+	codec.Event(6, 7, "still waiting")
+	codec.Event(8, 9, "last line")
+
+	check.CheckCurrentCount(2, "Held line was flushed early")
+
+	if offset := codec.Teardown(); offset != 7 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+
+	codec.(Flusher).Flush()
+	check.CheckCurrentCount(3, "Flush did not emit held line")
+}
+
+func TestUniqPattern(t *testing.T) {
+	check := &checkUniq{
+		expect: []checkUniqExpect{
+			{0, 3, "12:00:01 still waiting", 2},
+			{4, 5, "12:00:03 done", 0},
+		},
+		t: t,
+	}
+
+	codec := createUniqCodec(map[string]interface{}{
+		"pattern": "^[0-9:]+ (.*)$",
+		"timeout": time.Duration(0),
+	}, check.EventCallback, t)
+
+	codec.Event(0, 1, "12:00:01 still waiting")
+	codec.Event(2, 3, "12:00:02 still waiting")
+	codec.Event(4, 5, "12:00:03 done")
+	codec.(Flusher).Flush()
+
+	check.CheckCurrentCount(2, "Incorrect line count received")
+}
+
+func TestUniqTimeout(t *testing.T) {
+	check := &checkUniq{
+		expect: []checkUniqExpect{
+			{0, 3, "still waiting", 2},
+			{4, 5, "still waiting", 0},
+		},
+		t: t,
+	}
+
+	codec := createUniqCodec(map[string]interface{}{
+		"timeout": 2 * time.Second,
+	}, check.EventCallback, t)
+
+	codec.Event(0, 1, "still waiting")
+	codec.Event(2, 3, "still waiting")
+
+	time.Sleep(time.Second)
+	check.CheckCurrentCount(0, "Timeout triggered too early")
+
+	time.Sleep(2 * time.Second)
+	check.CheckCurrentCount(1, "Timeout did not flush held line")
+
+	// A repeat after the timeout starts a new event
+	codec.Event(4, 5, "still waiting")
+	codec.(Flusher).Flush()
+	check.CheckCurrentCount(2, "Incorrect line count received")
+
+	if offset := codec.Teardown(); offset != 5 {
+		t.Error("Teardown returned incorrect offset: ", offset)
+	}
+}
@@ -21,10 +21,13 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/driskell/log-courier/lc-lib/addresspool"
@@ -41,30 +44,83 @@ var (
 )
 
 const (
+	defaultGeneralDiskSpool          bool          = false
+	defaultGeneralDiskSpoolAction    string        = "block"
+	defaultGeneralDiskSpoolMaxSize   int64         = 1073741824
+	defaultGeneralEventSequence      bool          = false
 	defaultGeneralHost               string        = "localhost.localdomain"
+	defaultGeneralImmutableAction    string        = "keep"
+	defaultGeneralLagDuration        time.Duration = 5 * time.Minute
+	defaultGeneralLagThreshold       int64         = 0
+	defaultGeneralLagWindow          time.Duration = 5 * time.Minute
 	defaultGeneralLogLevel           logging.Level = logging.INFO
 	defaultGeneralLogStdout          bool          = true
 	defaultGeneralLogSyslog          bool          = false
 	defaultGeneralLineBufferBytes    int64         = 16384
 	defaultGeneralMaxLineBytes       int64         = 1048576
+	defaultGeneralMemoryLimit        int64         = 0
+	defaultGeneralMessageField       string        = "message"
+	defaultGeneralMemoryLowWaterPct  int64         = 80
+	defaultGeneralOpenFilesBackoff   time.Duration = 30 * time.Second
+	defaultGeneralOpenFilesIdle      time.Duration = 5 * time.Minute
+	defaultGeneralPersistMode        string        = "strict"
+	defaultGeneralPersistPending     bool          = false
 	defaultGeneralProspectInterval   time.Duration = 10 * time.Second
+	defaultGeneralRateCooldown       time.Duration = 15 * time.Minute
+	defaultGeneralRateDuration       time.Duration = 1 * time.Minute
+	defaultGeneralRateThreshold      int64         = 0
+	defaultGeneralRateTimeout        time.Duration = 30 * time.Second
+	defaultGeneralRequireFiles       bool          = false
+	defaultGeneralSpoolInputBuffer   int64         = 16
 	defaultGeneralSpoolMaxBytes      int64         = 10485760
 	defaultGeneralSpoolSize          int64         = 1024
 	defaultGeneralSpoolTimeout       time.Duration = 5 * time.Second
+	defaultGeneralStatsdInterval     time.Duration = 10 * time.Second
+	defaultGeneralStatsdPrefix       string        = "log_courier"
+	defaultGeneralTeeStdout          bool          = false
+	defaultGeneralTeeStdoutSample    int64         = 100
+	defaultNetworkAlertFailures      int64         = 0
 	defaultNetworkBackoff            time.Duration = 5 * time.Second
+	defaultNetworkCanary             bool          = false
+	defaultNetworkDNSRefresh         time.Duration = 5 * time.Minute
 	defaultNetworkBackoffMax         time.Duration = 300 * time.Second
+	defaultNetworkHealthCooldown     time.Duration = 60 * time.Second
+	defaultNetworkHealthFailures     int64         = 3
+	defaultNetworkMaxPendingEvents   int64         = 0
 	defaultNetworkMaxPendingPayloads int64         = 10
 	defaultNetworkMethod             string        = "random"
 	defaultNetworkRfc2782Service     string        = "courier"
 	defaultNetworkRfc2782Srv         bool          = true
+	defaultNetworkServerSelection    string        = "ordered"
 	defaultNetworkTimeout            time.Duration = 15 * time.Second
 	defaultNetworkTransport          string        = "tls"
+	defaultStreamAddClockSkewField   bool          = false
 	defaultStreamAddHostField        bool          = true
+	defaultStreamHostField           string        = "host"
 	defaultStreamAddOffsetField      bool          = true
 	defaultStreamAddPathField        bool          = true
+	defaultStreamAddTimestampField   bool          = false
 	defaultStreamAddTimezoneField    bool          = false
 	defaultStreamCodec               string        = "plain"
 	defaultStreamDeadTime            time.Duration = 1 * time.Hour
+	defaultStreamEncoding            string        = "utf-8"
+	defaultStreamLineDelimiter       string        = "\n"
+	defaultStreamMaxLineRate         int64         = 0
+	defaultStreamRequireFieldsAction string        = "drop"
+	defaultStreamRequireNonEmpty     bool          = false
+	defaultStreamSkipEmptyLines      bool          = false
+	defaultStreamTimestampSource     string        = "read"
+	defaultFileAllowNoFiles          bool          = false
+	defaultFileStartPosition         string        = "end"
+	defaultFileStartRate             int64         = 0
+	defaultKafkaCommitInterval       time.Duration = 5 * time.Second
+	defaultKafkaSSLVerifyUsage       bool          = true
+	defaultKafkaStartPosition        string        = "end"
+	defaultKafkaTransport            string        = "tcp"
+	defaultReceiverMinTLSVersion     string        = "1.2"
+	defaultReceiverRequireBind       bool          = false
+	defaultReceiverSSLVerifyUsage    bool          = true
+	defaultReceiverTransport         string        = "tls"
 )
 
 // Section is implemented by external config structures that will be
@@ -82,62 +138,134 @@ var registeredSectionCreators = make(map[string]SectionCreator)
 
 // General holds the general configuration
 type General struct {
-	GlobalFields     map[string]interface{} `config:"global fields"`
-	Host             string                 `config:"host"`
-	LineBufferBytes  int64                  `config:"line buffer bytes"`
-	LogFile          string                 `config:"log file"`
-	LogLevel         logging.Level          `config:"log level"`
-	LogStdout        bool                   `config:"log stdout"`
-	LogSyslog        bool                   `config:"log syslog"`
-	MaxLineBytes     int64                  `config:"max line bytes"`
-	PersistDir       string                 `config:"persist directory"`
-	ProspectInterval time.Duration          `config:"prospect interval"`
-	SpoolSize        int64                  `config:"spool size"`
-	SpoolMaxBytes    int64                  `config:"spool max bytes"`
-	SpoolTimeout     time.Duration          `config:"spool timeout"`
+	DiskSpool         bool                   `config:"disk spool"`
+	DiskSpoolAction   string                 `config:"disk spool full action"`
+	DiskSpoolMaxSize  int64                  `config:"disk spool max size"`
+	EventSequence     bool                   `config:"event sequence"`
+	GlobalFields      map[string]interface{} `config:"global fields"`
+	Host              string                 `config:"host"`
+	ImmutableAction   string                 `config:"immutable sections action"`
+	ImmutableSections []string               `config:"immutable sections"`
+	LagDuration       time.Duration          `config:"lag duration"`
+	LagThreshold      int64                  `config:"lag threshold"`
+	LagWindow         time.Duration          `config:"lag window"`
+	LineBufferBytes   int64                  `config:"line buffer bytes"`
+	LogFile           string                 `config:"log file"`
+	LogLevel          logging.Level          `config:"log level"`
+	LogStdout         bool                   `config:"log stdout"`
+	LogSyslog         bool                   `config:"log syslog"`
+	MaxLineBytes      int64                  `config:"max line bytes"`
+	MemoryLimit       int64                  `config:"memory limit"`
+	MemoryLowWater    int64                  `config:"memory low water"`
+	MessageField      string                 `config:"message field"`
+	OpenFilesBackoff  time.Duration          `config:"open files backoff"`
+	OpenFilesIdle     time.Duration          `config:"open files idle"`
+	PersistCompress   bool                   `config:"persist compression"`
+	PersistDir        string                 `config:"persist directory"`
+	PersistMode       string                 `config:"persist mode"`
+	PersistPending    bool                   `config:"persist pending payloads"`
+	ProspectInterval  time.Duration          `config:"prospect interval"`
+	RateCommand       []string               `config:"rate alert command"`
+	RateCooldown      time.Duration          `config:"rate alert cooldown"`
+	RateDuration      time.Duration          `config:"rate alert duration"`
+	RateThreshold     int64                  `config:"rate alert threshold"`
+	RateTimeout       time.Duration          `config:"rate alert timeout"`
+	RequireFiles      bool                   `config:"require matching files"`
+	SpoolInputBuffer  int64                  `config:"spool input buffer"`
+	SpoolSize         int64                  `config:"spool size"`
+	SpoolMaxBytes     int64                  `config:"spool max bytes"`
+	SpoolTimeout      time.Duration          `config:"spool timeout"`
+	StatsdAddress     string                 `config:"statsd address"`
+	StatsdInterval    time.Duration          `config:"statsd interval"`
+	StatsdPrefix      string                 `config:"statsd prefix"`
+	TeeStdout         bool                   `config:"tee stdout"`
+	TeeStdoutSample   int64                  `config:"tee stdout sample"`
 }
 
 // InitDefaults initialises default values for the general configuration
 func (gc *General) InitDefaults() {
+	gc.DiskSpool = defaultGeneralDiskSpool
+	gc.DiskSpoolAction = defaultGeneralDiskSpoolAction
+	gc.DiskSpoolMaxSize = defaultGeneralDiskSpoolMaxSize
+	gc.EventSequence = defaultGeneralEventSequence
+	gc.ImmutableAction = defaultGeneralImmutableAction
+	gc.LagDuration = defaultGeneralLagDuration
+	gc.LagThreshold = defaultGeneralLagThreshold
+	gc.LagWindow = defaultGeneralLagWindow
 	gc.LineBufferBytes = defaultGeneralLineBufferBytes
 	gc.LogLevel = defaultGeneralLogLevel
 	gc.LogStdout = defaultGeneralLogStdout
 	gc.LogSyslog = defaultGeneralLogSyslog
 	gc.MaxLineBytes = defaultGeneralMaxLineBytes
+	gc.MemoryLimit = defaultGeneralMemoryLimit
+	gc.MessageField = defaultGeneralMessageField
+	gc.OpenFilesBackoff = defaultGeneralOpenFilesBackoff
+	gc.OpenFilesIdle = defaultGeneralOpenFilesIdle
 	gc.PersistDir = DefaultGeneralPersistDir
+	gc.PersistMode = defaultGeneralPersistMode
+	gc.PersistPending = defaultGeneralPersistPending
 	gc.ProspectInterval = defaultGeneralProspectInterval
+	gc.RateCooldown = defaultGeneralRateCooldown
+	gc.RateDuration = defaultGeneralRateDuration
+	gc.RateThreshold = defaultGeneralRateThreshold
+	gc.RateTimeout = defaultGeneralRateTimeout
+	gc.RequireFiles = defaultGeneralRequireFiles
+	gc.SpoolInputBuffer = defaultGeneralSpoolInputBuffer
 	gc.SpoolSize = defaultGeneralSpoolSize
 	gc.SpoolMaxBytes = defaultGeneralSpoolMaxBytes
 	gc.SpoolTimeout = defaultGeneralSpoolTimeout
+	gc.StatsdInterval = defaultGeneralStatsdInterval
+	gc.StatsdPrefix = defaultGeneralStatsdPrefix
+	gc.TeeStdout = defaultGeneralTeeStdout
+	gc.TeeStdoutSample = defaultGeneralTeeStdoutSample
 	// NOTE: Empty string for Host means calculate it automatically, so leave it
 }
 
 // Network holds network related configuration
 type Network struct {
-	Factory      interface{}
-	AddressPools []*addresspool.Pool
-
-	Backoff            time.Duration `config:"failure backoff"`
-	BackoffMax         time.Duration `config:"failure backoff max"`
-	MaxPendingPayloads int64         `config:"max pending payloads"`
-	Method             string        `config:"method"`
-	Rfc2782Service     string        `config:"rfc 2782 service"`
-	Rfc2782Srv         bool          `config:"rfc 2782 srv"`
-	Servers            []string      `config:"servers"`
-	Timeout            time.Duration `config:"timeout"`
-	Transport          string        `config:"transport"`
+	Factory       interface{}
+	AddressPools  []*addresspool.Pool
+	ServerOptions map[string]map[string]interface{}
+
+	AlertCommand       []string                 `config:"alert command"`
+	AlertFailures      int64                    `config:"alert failures"`
+	Canary             bool                     `config:"canary"`
+	DNSRefresh         time.Duration            `config:"dns refresh"`
+	Backoff            time.Duration            `config:"failure backoff"`
+	BackoffMax         time.Duration            `config:"failure backoff max"`
+	HealthCooldown     time.Duration            `config:"health cooldown"`
+	HealthFailures     int64                    `config:"health failures"`
+	MaxPendingEvents   int64                    `config:"max pending events"`
+	MaxPendingPayloads int64                    `config:"max pending payloads"`
+	MetadataField      string                   `config:"metadata field"`
+	Method             string                   `config:"method"`
+	Rfc2782Service     string                   `config:"rfc 2782 service"`
+	Rfc2782Srv         bool                     `config:"rfc 2782 srv"`
+	ServerSelection    string                   `config:"server selection"`
+	Servers            []string                 `config:"servers"`
+	ServerTimeouts     map[string]time.Duration `config:"server timeouts"`
+	ShutdownTimeout    time.Duration            `config:"shutdown timeout"`
+	Timeout            time.Duration            `config:"timeout"`
+	Transport          string                   `config:"transport"`
 
 	Unused map[string]interface{}
 }
 
 // InitDefaults initiases default values for the network configuration
 func (nc *Network) InitDefaults() {
+	nc.AlertFailures = defaultNetworkAlertFailures
 	nc.Backoff = defaultNetworkBackoff
 	nc.BackoffMax = defaultNetworkBackoffMax
+	nc.Canary = defaultNetworkCanary
+	nc.DNSRefresh = defaultNetworkDNSRefresh
+	nc.HealthCooldown = defaultNetworkHealthCooldown
+	nc.HealthFailures = defaultNetworkHealthFailures
+	nc.MaxPendingEvents = defaultNetworkMaxPendingEvents
 	nc.MaxPendingPayloads = defaultNetworkMaxPendingPayloads
 	nc.Method = defaultNetworkMethod
 	nc.Rfc2782Service = defaultNetworkRfc2782Service
 	nc.Rfc2782Srv = defaultNetworkRfc2782Srv
+	nc.ServerSelection = defaultNetworkServerSelection
 	nc.Timeout = defaultNetworkTimeout
 	nc.Transport = defaultNetworkTransport
 }
@@ -151,43 +279,185 @@ type CodecStub struct {
 	Factory interface{}
 }
 
+// ProcessorStub holds an unknown processor configuration
+// After initial parsing of configuration, these ProcessorStubs are turned into
+// real configuration blocks for the processor given by their Name field
+type ProcessorStub struct {
+	Name    string `config:"name"`
+	Unused  map[string]interface{}
+	Factory interface{}
+}
+
+// Route holds the configuration for one of the routes of a log stream, each
+// of which produces its own event for every line using its own codecs and
+// processors
+type Route struct {
+	Codecs     []CodecStub     `config:"codecs"`
+	Name       string          `config:"name"`
+	Processors []ProcessorStub `config:"processors"`
+}
+
 // Stream holds the configuration for a log stream
 type Stream struct {
-	AddHostField     bool                   `config:"add host field"`
-	AddOffsetField   bool                   `config:"add offset field"`
-	AddPathField     bool                   `config:"add path field"`
-	AddTimezoneField bool                   `config:"add timezone field"`
-	Codecs           []CodecStub            `config:"codecs"`
-	DeadTime         time.Duration          `config:"dead time"`
-	Fields           map[string]interface{} `config:"fields"`
+	AddClockSkewField   bool                   `config:"add clock skew field"`
+	AddHostField        bool                   `config:"add host field"`
+	HostField           string                 `config:"host field"`
+	AddOffsetField      bool                   `config:"add offset field"`
+	AddPathField        bool                   `config:"add path field"`
+	AddTimestampField   bool                   `config:"add timestamp field"`
+	AddTimezoneField    bool                   `config:"add timezone field"`
+	Codecs              []CodecStub            `config:"codecs"`
+	DeadTime            time.Duration          `config:"dead time"`
+	Encoding            string                 `config:"encoding"`
+	Fields              map[string]interface{} `config:"fields"`
+	LineDelimiter       string                 `config:"line delimiter"`
+	MaxLineRate         int64                  `config:"max line rate"`
+	MessageField        string                 `config:"message field"`
+	OriginalField       string                 `config:"original field"`
+	Processors          []ProcessorStub        `config:"processors"`
+	RequireFields       []string               `config:"require fields"`
+	RequireFieldsAction string                 `config:"require fields action"`
+	RequireNonEmpty     bool                   `config:"require non empty"`
+	Routes              []Route                `config:"routes"`
+	SkipEmptyLines      bool                   `config:"skip empty lines"`
+	Tags                []string               `config:"tags"`
+	TimestampSource     string                 `config:"timestamp source"`
+	Type                string                 `config:"type"`
 }
 
 // InitDefaults initialises the default configuration for a log stream
 func (sc *Stream) InitDefaults() {
+	sc.AddClockSkewField = defaultStreamAddClockSkewField
 	sc.AddHostField = defaultStreamAddHostField
+	sc.HostField = defaultStreamHostField
 	sc.AddOffsetField = defaultStreamAddOffsetField
 	sc.AddPathField = defaultStreamAddPathField
+	sc.AddTimestampField = defaultStreamAddTimestampField
 	sc.AddTimezoneField = defaultStreamAddTimezoneField
 	sc.DeadTime = defaultStreamDeadTime
+	sc.Encoding = defaultStreamEncoding
+	sc.LineDelimiter = defaultStreamLineDelimiter
+	sc.MaxLineRate = defaultStreamMaxLineRate
+	sc.RequireFieldsAction = defaultStreamRequireFieldsAction
+	sc.RequireNonEmpty = defaultStreamRequireNonEmpty
+	sc.SkipEmptyLines = defaultStreamSkipEmptyLines
+	sc.TimestampSource = defaultStreamTimestampSource
 }
 
 // File holds the configuration for a set of paths that share the same stream
 // configuration
 type File struct {
-	Paths  []string `config:"paths"`
-	Stream `config:",embed"`
+	AllowNoFiles  bool     `config:"allow no matching files"`
+	Paths         []string `config:"paths"`
+	StartPosition string   `config:"start position"`
+	StartRate     int64    `config:"start rate"`
+	Stream        `config:",embed"`
+
+	// StartLinesFromEnd is the number of lines back from the end of the file to
+	// start at when StartPosition is "end-<lines>"
+	StartLinesFromEnd int64
+}
+
+// InitDefaults initialises the default configuration for a file group
+func (fc *File) InitDefaults() {
+	fc.AllowNoFiles = defaultFileAllowNoFiles
+	fc.StartPosition = defaultFileStartPosition
+	fc.StartRate = defaultFileStartRate
+}
+
+// Receiver holds the configuration for a receiver, which accepts events from
+// other Log Courier instances so they can be relayed to the network servers
+type Receiver struct {
+	Allow              []string        `config:"allow"`
+	CipherSuites       []string        `config:"cipher suites"`
+	Deny               []string        `config:"deny"`
+	Listen             []string        `config:"listen"`
+	MetadataField      string          `config:"metadata field"`
+	MinTLSVersion      string          `config:"min tls version"`
+	Processors         []ProcessorStub `config:"processors"`
+	ReportTime         bool            `config:"report time"`
+	RequireBindAddress bool            `config:"require bind address"`
+	SSLCertificate     string          `config:"ssl certificate"`
+	SSLClientCA        string          `config:"ssl client ca"`
+	SSLKey             string          `config:"ssl key"`
+	SSLKeyPassphrase   string          `config:"ssl key passphrase"`
+	SSLVerifyUsage     bool            `config:"ssl verify key usage"`
+	StringBigNumbers   bool            `config:"string big numbers"`
+	Transport          string          `config:"transport"`
+
+	// ListenAddresses holds the network and address of each of the Listen
+	// entries once they have been validated
+	ListenAddresses []ListenAddress
+
+	// AllowNetworks and DenyNetworks hold the parsed Allow and Deny entries
+	AllowNetworks []*net.IPNet
+	DenyNetworks  []*net.IPNet
+
+	// TLSMinVersion and TLSCipherSuites hold the parsed MinTLSVersion and
+	// CipherSuites once they have been validated
+	TLSMinVersion   uint16
+	TLSCipherSuites []uint16
+}
+
+// InitDefaults initialises the default configuration for a receiver
+func (rc *Receiver) InitDefaults() {
+	rc.MinTLSVersion = defaultReceiverMinTLSVersion
+	rc.RequireBindAddress = defaultReceiverRequireBind
+	rc.SSLVerifyUsage = defaultReceiverSSLVerifyUsage
+	rc.Transport = defaultReceiverTransport
+}
+
+// Kafka holds the configuration for a Kafka consumer, which reads events from
+// Kafka topics as a member of a consumer group so they can be forwarded to the
+// network servers
+type Kafka struct {
+	Brokers          []string        `config:"brokers"`
+	CommitInterval   time.Duration   `config:"commit interval"`
+	Group            string          `config:"group"`
+	Processors       []ProcessorStub `config:"processors"`
+	SASLMechanism    string          `config:"sasl mechanism"`
+	SASLPassword     string          `config:"sasl password"`
+	SASLUsername     string          `config:"sasl username"`
+	SSLCA            string          `config:"ssl ca"`
+	SSLCertificate   string          `config:"ssl certificate"`
+	SSLKey           string          `config:"ssl key"`
+	SSLKeyPassphrase string          `config:"ssl key passphrase"`
+	SSLVerifyUsage   bool            `config:"ssl verify key usage"`
+	StartPosition    string          `config:"start position"`
+	Topics           []string        `config:"topics"`
+	Transport        string          `config:"transport"`
+}
+
+// InitDefaults initialises the default configuration for a Kafka consumer
+func (kc *Kafka) InitDefaults() {
+	kc.CommitInterval = defaultKafkaCommitInterval
+	kc.SSLVerifyUsage = defaultKafkaSSLVerifyUsage
+	kc.StartPosition = defaultKafkaStartPosition
+	kc.Transport = defaultKafkaTransport
+}
+
+// ListenAddress is an address to listen on along with the network to listen
+// with, which is one of "tcp", "tcp4" or "tcp6"
+type ListenAddress struct {
+	Network string
+	Address string
 }
 
 // Config holds all the configuration for Log Courier
 type Config struct {
-	Files    []File   `config:"files"`
-	General  General  `config:"general"`
-	Includes []string `config:"includes"`
-	Network  Network  `config:"network"`
-	Stdin    Stream   `config:"stdin"`
+	Files     []File     `config:"files"`
+	General   General    `config:"general"`
+	Includes  []string   `config:"includes"`
+	Kafka     []Kafka    `config:"kafka"`
+	Network   Network    `config:"network"`
+	Receivers []Receiver `config:"receivers"`
+	Stdin     Stream     `config:"stdin"`
 	// Dynamic sections
 	// TODO: All top level sections to use this
 	Sections map[string]Section `config:",dynamic"`
+
+	// A copy of each top level section as it was loaded
+	rawSections map[string]interface{}
 }
 
 // NewConfig creates a new, empty, configuration structure
@@ -230,11 +500,21 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 		return
 	}
 
+	c.storeRawSections(rawConfig)
+
+	// Servers can be given as a hash with options for that server
+	var serverOptions map[string]map[string]interface{}
+	if serverOptions, err = c.extractServerOptions(rawConfig); err != nil {
+		return
+	}
+
 	// Populate configuration - reporting errors on spelling mistakes etc.
 	if err = c.PopulateConfig(c, rawConfig, "/"); err != nil {
 		return
 	}
 
+	c.Network.ServerOptions = serverOptions
+
 	// Iterate includes
 	for _, glob := range c.Includes {
 		// Glob the path
@@ -250,6 +530,8 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 				return
 			}
 
+			c.storeRawIncludes(rawInclude)
+
 			// Append to configuration
 			vRawInclude := reflect.ValueOf(rawInclude)
 			if err = c.populateSlice(reflect.ValueOf(c).Elem().FieldByName("Files"), vRawInclude, fmt.Sprintf("%s/", include)); err != nil {
@@ -263,12 +545,46 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 		return
 	}
 
+	if err = c.validateImmutableSections(); err != nil {
+		return
+	}
+
+	if c.General.PersistMode != "strict" && c.General.PersistMode != "lenient" {
+		err = fmt.Errorf("/general/persist mode must be \"strict\" or \"lenient\"")
+		return
+	}
+
 	// Enforce maximum of 2 GB since event transmit length is uint32
 	if c.General.SpoolMaxBytes > 2*1024*1024*1024 {
 		err = fmt.Errorf("/general/spool max bytes can not be greater than 2 GiB")
 		return
 	}
 
+	if c.General.SpoolInputBuffer < 1 {
+		err = fmt.Errorf("/general/spool input buffer must be at least 1")
+		return
+	}
+
+	if c.General.DiskSpoolAction != "block" && c.General.DiskSpoolAction != "drop" {
+		err = fmt.Errorf("/general/disk spool full action must be \"block\" or \"drop\"")
+		return
+	}
+
+	if c.General.DiskSpoolMaxSize < c.General.SpoolMaxBytes {
+		err = fmt.Errorf("/general/disk spool max size can not be less than /general/spool max bytes")
+		return
+	}
+
+	if c.General.PersistPending && c.General.DiskSpool {
+		err = fmt.Errorf("/general/persist pending payloads can not be used with /general/disk spool")
+		return
+	}
+
+	if c.General.TeeStdoutSample < 1 || c.General.TeeStdoutSample > 100 {
+		err = fmt.Errorf("/general/tee stdout sample must be between 1 and 100")
+		return
+	}
+
 	if c.General.LineBufferBytes < 1 {
 		err = fmt.Errorf("/general/line buffer bytes must be greater than 1")
 		return
@@ -280,6 +596,71 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 		return
 	}
 
+	if c.General.OpenFilesBackoff <= 0 || c.General.OpenFilesIdle < 0 {
+		err = fmt.Errorf("/general/open files backoff must be positive and /general/open files idle can not be negative")
+		return
+	}
+
+	if c.General.LagThreshold < 0 {
+		err = fmt.Errorf("/general/lag threshold can not be negative")
+		return
+	}
+
+	for _, part := range strings.Split(c.General.MessageField, ".") {
+		if part == "" {
+			err = fmt.Errorf("/general/message field must be a field name or a dot separated path to a field")
+			return
+		}
+	}
+
+	if c.General.MemoryLimit < 0 || c.General.MemoryLowWater < 0 {
+		err = fmt.Errorf("/general/memory limit and /general/memory low water can not be negative")
+		return
+	}
+
+	if c.General.MemoryLimit != 0 {
+		if c.General.MemoryLowWater == 0 {
+			c.General.MemoryLowWater = c.General.MemoryLimit * defaultGeneralMemoryLowWaterPct / 100
+		} else if c.General.MemoryLowWater >= c.General.MemoryLimit {
+			err = fmt.Errorf("/general/memory low water must be less than /general/memory limit")
+			return
+		}
+	}
+
+	if c.General.LagThreshold != 0 && (c.General.LagWindow <= 0 || c.General.LagDuration < 0) {
+		err = fmt.Errorf("/general/lag window must be positive and /general/lag duration can not be negative")
+		return
+	}
+
+	if c.General.RateThreshold < 0 {
+		err = fmt.Errorf("/general/rate alert threshold can not be negative")
+		return
+	}
+
+	if c.General.RateCooldown < 0 || c.General.RateDuration < 0 {
+		err = fmt.Errorf("/general/rate alert cooldown and /general/rate alert duration can not be negative")
+		return
+	}
+
+	if c.General.RateTimeout <= 0 {
+		err = fmt.Errorf("/general/rate alert timeout must be positive")
+		return
+	}
+
+	if len(c.General.RateCommand) != 0 && c.General.RateCommand[0] == "" {
+		err = fmt.Errorf("/general/rate alert command must start with the program to run")
+		return
+	}
+
+	if c.General.StatsdAddress != "" && c.General.StatsdInterval <= 0 {
+		err = fmt.Errorf("/general/statsd interval must be positive")
+		return
+	}
+
+	// Allow the host to be taken from the environment, such as a Kubernetes node
+	// name, falling back to the FQDN if the variable is empty
+	c.General.Host = os.ExpandEnv(c.General.Host)
+
 	if c.General.Host == "" {
 		ret, hostErr := os.Hostname()
 		if hostErr == nil {
@@ -300,7 +681,7 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 	if c.Network.Method == "" {
 		c.Network.Method = defaultNetworkMethod
 	}
-	if c.Network.Method != "random" && c.Network.Method != "failover" && c.Network.Method != "loadbalance" {
+	if c.Network.Method != "random" && c.Network.Method != "failover" && c.Network.Method != "loadbalance" && c.Network.Method != "roundrobin" {
 		err = fmt.Errorf("The network method (/network/method) is not recognised: %s", c.Network.Method)
 		return
 	}
@@ -310,6 +691,46 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 		return
 	}
 
+	if c.Network.AlertFailures < 0 {
+		err = fmt.Errorf("/network/alert failures can not be negative")
+		return
+	}
+
+	if len(c.Network.AlertCommand) != 0 && c.Network.AlertCommand[0] == "" {
+		err = fmt.Errorf("/network/alert command must start with the program to run")
+		return
+	}
+
+	if c.Network.DNSRefresh < 0 {
+		err = fmt.Errorf("/network/dns refresh can not be negative")
+		return
+	}
+
+	if c.Network.ShutdownTimeout < 0 {
+		err = fmt.Errorf("/network/shutdown timeout can not be negative")
+		return
+	}
+
+	if c.Network.ServerSelection != addresspool.SelectionOrdered && c.Network.ServerSelection != addresspool.SelectionRandom {
+		err = fmt.Errorf("/network/server selection is not recognised: %s", c.Network.ServerSelection)
+		return
+	}
+
+	if c.Network.HealthFailures < 0 {
+		err = fmt.Errorf("/network/health failures can not be negative")
+		return
+	}
+
+	if c.Network.MaxPendingPayloads < 1 {
+		err = fmt.Errorf("/network/max pending payloads must be at least 1")
+		return
+	}
+
+	if c.Network.MaxPendingEvents < 0 {
+		err = fmt.Errorf("/network/max pending events can not be negative")
+		return
+	}
+
 	servers := make(map[string]bool)
 	c.Network.AddressPools = make([]*addresspool.Pool, len(c.Network.Servers))
 	for n, server := range c.Network.Servers {
@@ -321,6 +742,17 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 		c.Network.AddressPools[n] = addresspool.NewPool(server)
 	}
 
+	for server, timeout := range c.Network.ServerTimeouts {
+		if _, exists := servers[server]; !exists {
+			err = fmt.Errorf("/network/server timeouts/%s does not match any of the network servers (/network/servers)", server)
+			return
+		}
+		if timeout <= 0 {
+			err = fmt.Errorf("/network/server timeouts/%s must be positive", server)
+			return
+		}
+	}
+
 	if initFactories {
 		if registrarFunc, ok := registeredTransports[c.Network.Transport]; ok {
 			if c.Network.Factory, err = registrarFunc(c, "/network/", c.Network.Unused, c.Network.Transport); err != nil {
@@ -338,6 +770,15 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 			return
 		}
 
+		if err = c.initStartPosition(fmt.Sprintf("/files[%d]", k), &c.Files[k]); err != nil {
+			return
+		}
+
+		if c.Files[k].StartRate < 0 {
+			err = fmt.Errorf("/files[%d]/start rate can not be negative", k)
+			return
+		}
+
 		if err = c.initStreamConfig(fmt.Sprintf("/files[%d]", k), &c.Files[k].Stream, initFactories); err != nil {
 			return
 		}
@@ -347,6 +788,18 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 		return
 	}
 
+	for k := range c.Receivers {
+		if err = c.initReceiverConfig(fmt.Sprintf("/receivers[%d]", k), &c.Receivers[k], initFactories); err != nil {
+			return
+		}
+	}
+
+	for k := range c.Kafka {
+		if err = c.initKafkaConfig(fmt.Sprintf("/kafka[%d]", k), &c.Kafka[k], initFactories); err != nil {
+			return
+		}
+	}
+
 	// Validate the registered configurables
 	for _, section := range c.Sections {
 		if err = section.Validate(); err != nil {
@@ -357,9 +810,160 @@ func (c *Config) Load(path string, initFactories bool) (err error) {
 	return
 }
 
-// initStreamConfig initialises a stream configuration by creating the necessary
-// codec factories the harvesters will require
+// extractServerOptions replaces any network servers given as a hash with the
+// address within it, returning the remaining options for each server so that
+// the transport can apply them when connecting to that server
+func (c *Config) extractServerOptions(rawConfig map[string]interface{}) (map[string]map[string]interface{}, error) {
+	var network map[string]interface{}
+	switch rawNetwork := rawConfig["network"].(type) {
+	case map[string]interface{}:
+		network = rawNetwork
+	case map[interface{}]interface{}:
+		var err error
+		if network, err = c.fixMapInterfaceKeys("/network", rawNetwork); err != nil {
+			return nil, err
+		}
+		rawConfig["network"] = network
+	default:
+		return nil, nil
+	}
+
+	servers, ok := network["servers"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	serverOptions := make(map[string]map[string]interface{})
+	for i, server := range servers {
+		var options map[string]interface{}
+		switch rawOptions := server.(type) {
+		case map[string]interface{}:
+			options = rawOptions
+		case map[interface{}]interface{}:
+			var err error
+			if options, err = c.fixMapInterfaceKeys(fmt.Sprintf("/network/servers[%d]", i), rawOptions); err != nil {
+				return nil, err
+			}
+		case string:
+			continue
+		default:
+			return nil, fmt.Errorf("/network/servers[%d] must be a string or a hash", i)
+		}
+
+		address, ok := options["address"].(string)
+		if !ok || address == "" {
+			return nil, fmt.Errorf("/network/servers[%d]/address must be specified", i)
+		}
+
+		delete(options, "address")
+		servers[i] = address
+		if len(options) != 0 {
+			serverOptions[address] = options
+		}
+	}
+
+	return serverOptions, nil
+}
+
+// initStartPosition validates the start position of a file group and parses
+// the number of lines when it is relative to the end of the file
+func (c *Config) initStartPosition(path string, fileConfig *File) error {
+	switch {
+	case fileConfig.StartPosition == "beginning" || fileConfig.StartPosition == "end":
+		return nil
+	case strings.HasPrefix(fileConfig.StartPosition, "end-"):
+		lines, err := strconv.ParseInt(fileConfig.StartPosition[4:], 10, 64)
+		if err == nil && lines > 0 {
+			fileConfig.StartLinesFromEnd = lines
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s/start position must be \"beginning\", \"end\" or \"end-<lines>\"", path)
+}
+
+// initStreamConfig validates and initialises a stream configuration by creating
+// the necessary codec factories the harvesters will require
 func (c *Config) initStreamConfig(path string, streamConfig *Stream, initFactories bool) (err error) {
+	if len(streamConfig.LineDelimiter) != 1 {
+		return fmt.Errorf("%s/line delimiter must be a single byte", path)
+	}
+
+	streamConfig.Encoding = strings.ToLower(streamConfig.Encoding)
+	switch streamConfig.Encoding {
+	case "iso-8859-1", "utf-8", "utf-16", "utf-16be", "utf-16le":
+	default:
+		return fmt.Errorf("%s/encoding must be \"utf-8\", \"utf-16\", \"utf-16be\", \"utf-16le\" or \"iso-8859-1\"", path)
+	}
+
+	if streamConfig.MaxLineRate < 0 {
+		return fmt.Errorf("%s/max line rate can not be negative", path)
+	}
+
+	for _, part := range strings.Split(streamConfig.HostField, ".") {
+		if part == "" {
+			return fmt.Errorf("%s/host field must be a field name or a dot separated path to a field", path)
+		}
+	}
+
+	// Streams store lines in the general message field unless they set their own
+	if streamConfig.MessageField == "" {
+		streamConfig.MessageField = c.General.MessageField
+	}
+
+	for _, part := range strings.Split(streamConfig.MessageField, ".") {
+		if part == "" {
+			return fmt.Errorf("%s/message field must be a field name or a dot separated path to a field", path)
+		}
+	}
+
+	if streamConfig.OriginalField != "" {
+		for _, part := range strings.Split(streamConfig.OriginalField, ".") {
+			if part == "" {
+				return fmt.Errorf("%s/original field must be a field name or a dot separated path to a field", path)
+			}
+		}
+
+		if streamConfig.OriginalField == streamConfig.MessageField {
+			return fmt.Errorf("%s/original field can not be the same as %s/message field", path, path)
+		}
+	}
+
+	for _, field := range streamConfig.RequireFields {
+		for _, part := range strings.Split(field, ".") {
+			if part == "" {
+				return fmt.Errorf("%s/require fields must contain field names or dot separated paths to fields", path)
+			}
+		}
+	}
+
+	switch streamConfig.RequireFieldsAction {
+	case "drop", "dead letter", "tag":
+	default:
+		return fmt.Errorf("%s/require fields action must be \"drop\", \"dead letter\" or \"tag\"", path)
+	}
+
+	switch streamConfig.TimestampSource {
+	case "read":
+	case "mtime":
+		if !streamConfig.AddTimestampField {
+			return fmt.Errorf("%s/timestamp source of \"mtime\" requires add timestamp field to be enabled", path)
+		}
+	default:
+		return fmt.Errorf("%s/timestamp source must be \"read\" or \"mtime\"", path)
+	}
+
+	routeNames := make(map[string]bool)
+	for i, route := range streamConfig.Routes {
+		if route.Name == "" {
+			return fmt.Errorf("%s/routes[%d]/name must be specified", path, i)
+		}
+		if routeNames[route.Name] {
+			return fmt.Errorf("%s/routes[%d]/name \"%s\" is used by another route", path, i, route.Name)
+		}
+		routeNames[route.Name] = true
+	}
+
 	if !initFactories {
 		// Currently only codec factory is initialised, so skip if we're not doing that
 		return nil
@@ -369,8 +973,207 @@ func (c *Config) initStreamConfig(path string, streamConfig *Stream, initFactori
 		streamConfig.Codecs = []CodecStub{CodecStub{Name: defaultStreamCodec}}
 	}
 
-	for i := 0; i < len(streamConfig.Codecs); i++ {
-		codec := &streamConfig.Codecs[i]
+	if err = c.initCodecs(path, streamConfig.Codecs); err != nil {
+		return
+	}
+
+	if err = c.initProcessors(path, streamConfig.Processors, streamConfig.MessageField); err != nil {
+		return
+	}
+
+	for i := range streamConfig.Routes {
+		route := &streamConfig.Routes[i]
+		routePath := fmt.Sprintf("%s/routes[%d]", path, i)
+
+		// Routes without codecs use the codecs of the stream
+		if len(route.Codecs) == 0 {
+			route.Codecs = streamConfig.Codecs
+		} else if err = c.initCodecs(routePath, route.Codecs); err != nil {
+			return
+		}
+
+		if err = c.initProcessors(routePath, route.Processors, streamConfig.MessageField); err != nil {
+			return
+		}
+	}
+
+	// Ensure all Fields are map[string]interface{}
+	if err = c.fixMapKeys(path+"/fields", streamConfig.Fields); err != nil {
+		return
+	}
+
+	// TODO: EDGE CASE: Event transmit length is uint32, if fields length is rediculous we will fail
+
+	return nil
+}
+
+// initReceiverConfig validates and initialises a receiver configuration
+func (c *Config) initReceiverConfig(path string, receiverConfig *Receiver, initFactories bool) error {
+	if len(receiverConfig.Listen) == 0 {
+		return fmt.Errorf("No listen addresses specified for %s/", path)
+	}
+
+	receiverConfig.ListenAddresses = make([]ListenAddress, len(receiverConfig.Listen))
+	for i, listen := range receiverConfig.Listen {
+		address, err := parseListenAddress(listen, receiverConfig.RequireBindAddress)
+		if err != nil {
+			return fmt.Errorf("%s/listen[%d] is not valid: %s", path, i, err)
+		}
+		receiverConfig.ListenAddresses[i] = address
+	}
+
+	var err error
+	if receiverConfig.AllowNetworks, err = parseNetworks(receiverConfig.Allow); err != nil {
+		return fmt.Errorf("%s/allow is not valid: %s", path, err)
+	}
+	if receiverConfig.DenyNetworks, err = parseNetworks(receiverConfig.Deny); err != nil {
+		return fmt.Errorf("%s/deny is not valid: %s", path, err)
+	}
+
+	switch receiverConfig.Transport {
+	case "tcp":
+		if receiverConfig.SSLCertificate != "" || receiverConfig.SSLKey != "" || receiverConfig.SSLKeyPassphrase != "" || receiverConfig.SSLClientCA != "" {
+			return fmt.Errorf("%s/ssl options are only valid when transport is \"tls\"", path)
+		}
+		if len(receiverConfig.CipherSuites) != 0 {
+			return fmt.Errorf("%s/cipher suites is only valid when transport is \"tls\"", path)
+		}
+	case "tls":
+		if receiverConfig.SSLCertificate == "" || receiverConfig.SSLKey == "" {
+			return fmt.Errorf("%s/ssl certificate and %s/ssl key are required when transport is \"tls\"", path, path)
+		}
+		if receiverConfig.TLSMinVersion, err = ParseTLSVersion(receiverConfig.MinTLSVersion); err != nil {
+			return fmt.Errorf("%s/%s", path, err)
+		}
+		if receiverConfig.TLSCipherSuites, err = ParseCipherSuites(receiverConfig.CipherSuites); err != nil {
+			return fmt.Errorf("%s/cipher suites is not valid: %s", path, err)
+		}
+	default:
+		return fmt.Errorf("%s/transport must be \"tcp\" or \"tls\"", path)
+	}
+
+	if !initFactories {
+		return nil
+	}
+
+	return c.initProcessors(path, receiverConfig.Processors, c.General.MessageField)
+}
+
+// initKafkaConfig validates and initialises a Kafka consumer configuration
+func (c *Config) initKafkaConfig(path string, kafkaConfig *Kafka, initFactories bool) error {
+	if len(kafkaConfig.Brokers) == 0 {
+		return fmt.Errorf("No brokers specified for %s/", path)
+	}
+
+	if len(kafkaConfig.Topics) == 0 {
+		return fmt.Errorf("No topics specified for %s/", path)
+	}
+
+	if kafkaConfig.Group == "" {
+		return fmt.Errorf("%s/group is required", path)
+	}
+
+	if kafkaConfig.CommitInterval <= 0 {
+		return fmt.Errorf("%s/commit interval must be greater than 0", path)
+	}
+
+	switch kafkaConfig.StartPosition {
+	case "beginning", "end":
+	default:
+		return fmt.Errorf("%s/start position must be \"beginning\" or \"end\"", path)
+	}
+
+	switch kafkaConfig.Transport {
+	case "tcp":
+		if kafkaConfig.SSLCA != "" || kafkaConfig.SSLCertificate != "" || kafkaConfig.SSLKey != "" || kafkaConfig.SSLKeyPassphrase != "" {
+			return fmt.Errorf("%s/ssl options are only valid when transport is \"tls\"", path)
+		}
+	case "tls":
+		if (kafkaConfig.SSLCertificate == "") != (kafkaConfig.SSLKey == "") {
+			return fmt.Errorf("%s/ssl certificate and %s/ssl key must be specified together", path, path)
+		}
+	default:
+		return fmt.Errorf("%s/transport must be \"tcp\" or \"tls\"", path)
+	}
+
+	switch kafkaConfig.SASLMechanism {
+	case "":
+		if kafkaConfig.SASLUsername != "" || kafkaConfig.SASLPassword != "" {
+			return fmt.Errorf("%s/sasl username and %s/sasl password require %s/sasl mechanism", path, path, path)
+		}
+	case "plain", "scram-sha-256", "scram-sha-512":
+		if kafkaConfig.SASLUsername == "" {
+			return fmt.Errorf("%s/sasl username is required when sasl mechanism is specified", path)
+		}
+	default:
+		return fmt.Errorf("%s/sasl mechanism must be one of \"plain\", \"scram-sha-256\" or \"scram-sha-512\"", path)
+	}
+
+	if !initFactories {
+		return nil
+	}
+
+	return c.initProcessors(path, kafkaConfig.Processors, c.General.MessageField)
+}
+
+// parseListenAddress parses a listen address in the format "network:host:port"
+// where the network is optional and one of "tcp", "tcp4" or "tcp6". The address
+// is resolved so that it is validated during a configuration test. If
+// requireBind is true, addresses that would listen on all interfaces are
+// rejected
+func parseListenAddress(listen string, requireBind bool) (ListenAddress, error) {
+	address := ListenAddress{Network: "tcp", Address: listen}
+	for _, network := range []string{"tcp", "tcp4", "tcp6"} {
+		if strings.HasPrefix(listen, network+":") {
+			address.Network, address.Address = network, listen[len(network)+1:]
+			break
+		}
+	}
+
+	tcpAddr, err := net.ResolveTCPAddr(address.Network, address.Address)
+	if err != nil {
+		return address, err
+	}
+
+	if requireBind && (tcpAddr.IP == nil || tcpAddr.IP.IsUnspecified()) {
+		return address, fmt.Errorf("%s listens on all interfaces but require bind address is enabled", listen)
+	}
+
+	return address, nil
+}
+
+// parseNetworks parses a list of networks in CIDR notation, such as
+// "10.0.0.0/8" or "fd00::/8". A single IPv4 or IPv6 address is also accepted
+// and matches only that address
+func parseNetworks(networks []string) ([]*net.IPNet, error) {
+	var ret []*net.IPNet
+	for _, network := range networks {
+		if !strings.Contains(network, "/") {
+			ip := net.ParseIP(network)
+			if ip == nil {
+				return nil, fmt.Errorf("%s is not an IP address or network", network)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an IP address or network", network)
+		}
+		ret = append(ret, ipNet)
+	}
+
+	return ret, nil
+}
+
+// initCodecs creates the codec factories for a list of codecs
+func (c *Config) initCodecs(path string, codecs []CodecStub) (err error) {
+	for i := 0; i < len(codecs); i++ {
+		codec := &codecs[i]
 		if registrarFunc, ok := registeredCodecs[codec.Name]; ok {
 			if codec.Factory, err = registrarFunc(c, path, codec.Unused, codec.Name); err != nil {
 				return
@@ -380,16 +1183,33 @@ func (c *Config) initStreamConfig(path string, streamConfig *Stream, initFactori
 		}
 	}
 
-	// Ensure all Fields are map[string]interface{}
-	if err = c.fixMapKeys(path+"/fields", streamConfig.Fields); err != nil {
-		return
-	}
+	return nil
+}
 
-	// TODO: EDGE CASE: Event transmit length is uint32, if fields length is rediculous we will fail
+// initProcessors creates the processor factories for a list of processors,
+// giving them the field that contains the message of the events they receive
+func (c *Config) initProcessors(path string, processors []ProcessorStub, messageField string) (err error) {
+	for i := 0; i < len(processors); i++ {
+		processor := &processors[i]
+		if registrarFunc, ok := registeredProcessors[processor.Name]; ok {
+			if processor.Factory, err = registrarFunc(c, fmt.Sprintf("%s/processors[%d]/", path, i), processor.Unused, processor.Name, messageField); err != nil {
+				return
+			}
+		} else {
+			return fmt.Errorf("Unrecognised processor '%s' for %s", processor.Name, path)
+		}
+	}
 
 	return nil
 }
 
+// InitProcessors creates the processor factories for a list of processors
+// nested within the configuration of another processor, which receive events
+// with the message in the given field
+func (c *Config) InitProcessors(path string, processors []ProcessorStub, messageField string) error {
+	return c.initProcessors(path, processors, messageField)
+}
+
 // Get returns the requested dynamic configuration entry
 func (c *Config) Get(name string) interface{} {
 	ret, ok := c.Sections[name]
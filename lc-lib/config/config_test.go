@@ -0,0 +1,68 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"testing"
+)
+
+func TestStreamMessageField(t *testing.T) {
+	config := NewConfig()
+	config.General.MessageField = "event.original"
+
+	inherited := &Stream{}
+	inherited.InitDefaults()
+	if err := config.initStreamConfig("/files[0]", inherited, false); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if inherited.MessageField != "event.original" {
+		t.Errorf("Stream did not use the general message field: %s", inherited.MessageField)
+	}
+
+	explicit := &Stream{}
+	explicit.InitDefaults()
+	explicit.MessageField = "raw"
+	if err := config.initStreamConfig("/files[1]", explicit, false); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if explicit.MessageField != "raw" {
+		t.Errorf("Stream message field was replaced: %s", explicit.MessageField)
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := parseNetworks([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := []string{"10.0.0.0/8", "192.0.2.1/32", "2001:db8::/32", "2001:db8::1/128"}
+	if len(networks) != len(expected) {
+		t.Fatalf("Unexpected number of networks: %d", len(networks))
+	}
+	for i, network := range networks {
+		if network.String() != expected[i] {
+			t.Errorf("Unexpected network: %s != %s", network, expected[i])
+		}
+	}
+
+	for _, network := range []string{"10.0.0.0/33", "example.com", ""} {
+		if _, err := parseNetworks([]string{network}); err == nil {
+			t.Errorf("Invalid network was accepted: %s", network)
+		}
+	}
+}
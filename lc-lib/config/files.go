@@ -0,0 +1,74 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CheckMatchingFiles returns an error naming every file group whose paths do
+// not match any files, if /general/require matching files is enabled. File
+// groups with allow no matching files enabled are not checked
+func (c *Config) CheckMatchingFiles() error {
+	if !c.General.RequireFiles {
+		return nil
+	}
+
+	var unmatched []string
+	for k := range c.Files {
+		if c.Files[k].AllowNoFiles {
+			continue
+		}
+
+		matched, err := c.Files[k].hasMatchingFiles()
+		if err != nil {
+			return fmt.Errorf("/files[%d]/paths is invalid: %s", k, err)
+		}
+
+		if !matched {
+			unmatched = append(unmatched, fmt.Sprintf("/files[%d]/ (%s)", k, strings.Join(c.Files[k].Paths, ", ")))
+		}
+	}
+
+	if len(unmatched) != 0 {
+		return fmt.Errorf("No files match the paths of %s", strings.Join(unmatched, ", "))
+	}
+
+	return nil
+}
+
+// hasMatchingFiles returns true if any of the paths of the file group match a
+// file. Directories are not harvested so matching them does not count
+func (fc *File) hasMatchingFiles() (bool, error) {
+	for _, path := range fc.Paths {
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return false, err
+		}
+
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && !info.IsDir() {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
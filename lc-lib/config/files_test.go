@@ -0,0 +1,65 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckMatchingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.log"), []byte{}, 0600); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "dir.log"), 0700); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+
+	config := NewConfig()
+	config.Files = []File{
+		{Paths: []string{filepath.Join(dir, "*.log")}},
+		{Paths: []string{filepath.Join(dir, "missing*.log")}},
+		{Paths: []string{filepath.Join(dir, "dir.log")}},
+		{Paths: []string{filepath.Join(dir, "later*.log")}, AllowNoFiles: true},
+	}
+
+	if err := config.CheckMatchingFiles(); err != nil {
+		t.Errorf("Unexpected error when not required: %s", err)
+	}
+
+	config.General.RequireFiles = true
+
+	err = config.CheckMatchingFiles()
+	if err == nil {
+		t.Fatal("Unmatched file groups were not reported")
+	}
+	if !strings.Contains(err.Error(), "/files[1]/") || !strings.Contains(err.Error(), "/files[2]/") {
+		t.Errorf("Unmatched file groups missing from error: %s", err)
+	}
+	if strings.Contains(err.Error(), "/files[0]/") || strings.Contains(err.Error(), "/files[3]/") {
+		t.Errorf("Matched or allowed file groups included in error: %s", err)
+	}
+}
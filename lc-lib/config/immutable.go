@@ -0,0 +1,138 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var (
+	// builtinSections lists the top level sections of the configuration file
+	// that can be made immutable, in addition to registered sections
+	builtinSections = map[string]bool{
+		"files":     true,
+		"general":   true,
+		"kafka":     true,
+		"network":   true,
+		"receivers": true,
+		"stdin":     true,
+	}
+)
+
+// validateImmutableSections checks the sections listed in
+// /general/immutable sections exist
+func (c *Config) validateImmutableSections() error {
+	if c.General.ImmutableAction != "keep" && c.General.ImmutableAction != "reject" {
+		return fmt.Errorf("/general/immutable sections action must be \"keep\" or \"reject\"")
+	}
+
+	for _, section := range c.General.ImmutableSections {
+		if _, ok := c.Sections[section]; !ok && !builtinSections[section] {
+			return fmt.Errorf("/general/immutable sections contains an unknown section: \"%s\"", section)
+		}
+	}
+
+	return nil
+}
+
+// storeRawSections keeps a copy of each top level section of the configuration
+// file as it was loaded, so that a later configuration can tell which sections
+// have changed. It must be called before the raw configuration is populated,
+// as population removes the values it uses
+func (c *Config) storeRawSections(rawConfig map[string]interface{}) {
+	c.rawSections = make(map[string]interface{}, len(rawConfig))
+	for name, value := range rawConfig {
+		c.rawSections[name] = copyRawValue(value)
+	}
+}
+
+// storeRawIncludes adds the file groups loaded from an include to the copy of
+// the files section, so that changes to includes count as changes to files
+func (c *Config) storeRawIncludes(rawInclude []interface{}) {
+	files, _ := c.rawSections["files"].([]interface{})
+	for _, value := range rawInclude {
+		files = append(files, copyRawValue(value))
+	}
+	c.rawSections["files"] = files
+}
+
+// copyRawValue returns a deep copy of a value from the raw configuration
+func copyRawValue(value interface{}) interface{} {
+	switch vt := value.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(vt))
+		for k, v := range vt {
+			ret[k] = copyRawValue(v)
+		}
+		return ret
+	case map[interface{}]interface{}:
+		ret := make(map[interface{}]interface{}, len(vt))
+		for k, v := range vt {
+			ret[k] = copyRawValue(v)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(vt))
+		for i, v := range vt {
+			ret[i] = copyRawValue(v)
+		}
+		return ret
+	}
+
+	return value
+}
+
+// ChangedImmutableSections returns the sections that were immutable in the
+// previous configuration and have been changed in this one. The previous
+// configuration decides which sections are immutable so that the list can only
+// be changed with a restart
+func (c *Config) ChangedImmutableSections(previous *Config) []string {
+	var changed []string
+	for _, section := range previous.General.ImmutableSections {
+		if !reflect.DeepEqual(c.rawSections[section], previous.rawSections[section]) {
+			changed = append(changed, section)
+		}
+	}
+
+	return changed
+}
+
+// KeepSections replaces the given sections of this configuration with those of
+// the previous configuration
+func (c *Config) KeepSections(previous *Config, sections []string) {
+	for _, section := range sections {
+		switch section {
+		case "files":
+			c.Files = previous.Files
+		case "general":
+			c.General = previous.General
+		case "kafka":
+			c.Kafka = previous.Kafka
+		case "network":
+			c.Network = previous.Network
+		case "receivers":
+			c.Receivers = previous.Receivers
+		case "stdin":
+			c.Stdin = previous.Stdin
+		default:
+			c.Sections[section] = previous.Sections[section]
+		}
+
+		c.rawSections[section] = previous.rawSections[section]
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"testing"
+)
+
+func createImmutableConfig(rawConfig map[string]interface{}, immutable ...string) *Config {
+	config := NewConfig()
+	config.General.ImmutableSections = immutable
+	config.storeRawSections(rawConfig)
+	return config
+}
+
+func TestChangedImmutableSections(t *testing.T) {
+	rawConfig := map[string]interface{}{
+		"network": map[string]interface{}{
+			"transport": "tls",
+			"servers":   []interface{}{"first:1234"},
+		},
+		"files": []interface{}{
+			map[string]interface{}{"paths": []interface{}{"/var/log/app.log"}},
+		},
+	}
+	previous := createImmutableConfig(rawConfig, "network", "receivers")
+
+	// Population removes used values, which must not affect the stored copy
+	delete(rawConfig["network"].(map[string]interface{}), "transport")
+
+	unchanged := createImmutableConfig(map[string]interface{}{
+		"network": map[string]interface{}{
+			"transport": "tls",
+			"servers":   []interface{}{"first:1234"},
+		},
+		"files": []interface{}{
+			map[string]interface{}{"paths": []interface{}{"/var/log/other.log"}},
+		},
+	})
+	if changed := unchanged.ChangedImmutableSections(previous); len(changed) != 0 {
+		t.Errorf("Unexpected changed sections: %v", changed)
+	}
+
+	changedConfig := createImmutableConfig(map[string]interface{}{
+		"network": map[string]interface{}{
+			"transport": "tcp",
+			"servers":   []interface{}{"first:1234"},
+		},
+		"receivers": []interface{}{
+			map[string]interface{}{"listen": []interface{}{"tcp:0.0.0.0:5000"}},
+		},
+	})
+	changedConfig.Network.Transport = "tcp"
+
+	changed := changedConfig.ChangedImmutableSections(previous)
+	if len(changed) != 2 || changed[0] != "network" || changed[1] != "receivers" {
+		t.Fatalf("Incorrect changed sections: %v", changed)
+	}
+
+	previous.Network.Transport = "tls"
+	changedConfig.KeepSections(previous, changed)
+	if changedConfig.Network.Transport != "tls" || len(changedConfig.Receivers) != 0 {
+		t.Errorf("Immutable sections were not kept: %v %v", changedConfig.Network.Transport, changedConfig.Receivers)
+	}
+	if changed := changedConfig.ChangedImmutableSections(previous); len(changed) != 0 {
+		t.Errorf("Kept sections still reported as changed: %v", changed)
+	}
+}
+
+func TestChangedImmutableIncludes(t *testing.T) {
+	previous := createImmutableConfig(map[string]interface{}{}, "files")
+	previous.storeRawIncludes([]interface{}{
+		map[string]interface{}{"paths": []interface{}{"/var/log/app.log"}},
+	})
+
+	config := createImmutableConfig(map[string]interface{}{})
+	config.storeRawIncludes([]interface{}{
+		map[string]interface{}{"paths": []interface{}{"/var/log/other.log"}},
+	})
+
+	if changed := config.ChangedImmutableSections(previous); len(changed) != 1 || changed[0] != "files" {
+		t.Errorf("Change to included files was not detected: %v", changed)
+	}
+}
+
+func TestValidateImmutableSections(t *testing.T) {
+	config := NewConfig()
+	config.General.InitDefaults()
+	config.General.ImmutableSections = []string{"network", "receivers"}
+	if err := config.validateImmutableSections(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	config.General.ImmutableSections = []string{"network", "unknown"}
+	if err := config.validateImmutableSections(); err == nil {
+		t.Error("Unknown section was accepted")
+	}
+
+	config.General.ImmutableSections = nil
+	config.General.ImmutableAction = "ignore"
+	if err := config.validateImmutableSections(); err == nil {
+		t.Error("Invalid action was accepted")
+	}
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"testing"
+)
+
+func checkListenAddress(t *testing.T, listen string, requireBind bool, network string, address string) {
+	parsed, err := parseListenAddress(listen, requireBind)
+	if err != nil {
+		t.Errorf("Unexpected error for %s: %s", listen, err)
+	} else if parsed.Network != network || parsed.Address != address {
+		t.Errorf("Incorrect parse of %s: %s %s", listen, parsed.Network, parsed.Address)
+	}
+}
+
+func TestParseListenAddress(t *testing.T) {
+	checkListenAddress(t, "127.0.0.1:12345", false, "tcp", "127.0.0.1:12345")
+	checkListenAddress(t, "tcp4:10.0.0.1:12345", false, "tcp4", "10.0.0.1:12345")
+	checkListenAddress(t, "tcp6:[::1]:12345", false, "tcp6", "[::1]:12345")
+	checkListenAddress(t, ":12345", false, "tcp", ":12345")
+	checkListenAddress(t, "tcp4:10.0.0.1:12345", true, "tcp4", "10.0.0.1:12345")
+}
+
+func TestParseListenAddressInvalid(t *testing.T) {
+	for _, listen := range []string{"127.0.0.1", "tcp4:[::1]:12345", "tcp6:127.0.0.1:12345", "10.0.0.300:12345"} {
+		if _, err := parseListenAddress(listen, false); err == nil {
+			t.Errorf("Invalid address was accepted: %s", listen)
+		}
+	}
+
+	for _, listen := range []string{":12345", "0.0.0.0:12345", "tcp6:[::]:12345"} {
+		if _, err := parseListenAddress(listen, true); err == nil {
+			t.Errorf("Address on all interfaces was accepted: %s", listen)
+		}
+	}
+}
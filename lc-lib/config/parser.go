@@ -225,6 +225,13 @@ func (c *Config) populateValue(vField reflect.Value, vValue reflect.Value, confi
 			vItem := vValue.MapIndex(vKey)
 			if vItem.Elem().Type().AssignableTo(vField.Type().Elem()) {
 				vField.SetMapIndex(vKey, vItem.Elem())
+			} else if vField.Type().Elem().String() == "time.Duration" {
+				// Durations need conversion, so populate them as a standalone value
+				vElem := reflect.New(vField.Type().Elem()).Elem()
+				if err = c.populateValue(vElem, vItem.Elem(), fmt.Sprintf("%s%s/", configPath, tag), vKey.String()); err != nil {
+					return
+				}
+				vField.SetMapIndex(vKey, vElem)
 			} else {
 				err = fmt.Errorf("Option %s%s must be %s or similar", fmt.Sprintf("%s%s/", configPath, tag), vKey.String(), vField.Type().Elem())
 				return
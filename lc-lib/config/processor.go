@@ -0,0 +1,42 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+// ProcessorRegistrarFunc is a callback that can be registered that will
+// validate the configuration settings for a processor registered via
+// RegisterProcessor. The final argument is the field that contains the message
+// of the events the processor will receive, for processors that operate on the
+// message by default
+type ProcessorRegistrarFunc func(*Config, string, map[string]interface{}, string, string) (interface{}, error)
+
+var registeredProcessors = make(map[string]ProcessorRegistrarFunc)
+
+// RegisterProcessor registers a new processor with the configuration module,
+// with a callback that can be used to validate its configuration
+func RegisterProcessor(processor string, registrarFunc ProcessorRegistrarFunc) {
+	registeredProcessors[processor] = registrarFunc
+}
+
+// AvailableProcessors returns the list of registered processors available for
+// use
+func AvailableProcessors() (ret []string) {
+	ret = make([]string, 0, len(registeredProcessors))
+	for k := range registeredProcessors {
+		ret = append(ret, k)
+	}
+	return
+}
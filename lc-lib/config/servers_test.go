@@ -0,0 +1,64 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"testing"
+)
+
+func TestExtractServerOptions(t *testing.T) {
+	rawConfig := map[string]interface{}{
+		"network": map[interface{}]interface{}{
+			"servers": []interface{}{
+				"first:1234",
+				map[interface{}]interface{}{"address": "second:1234", "ssl ca": "second.crt"},
+				map[string]interface{}{"address": "third:1234"},
+			},
+		},
+	}
+
+	serverOptions, err := NewConfig().extractServerOptions(rawConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	servers := rawConfig["network"].(map[string]interface{})["servers"].([]interface{})
+	if servers[0] != "first:1234" || servers[1] != "second:1234" || servers[2] != "third:1234" {
+		t.Errorf("Servers were not replaced with their addresses: %v", servers)
+	}
+
+	if len(serverOptions) != 1 || serverOptions["second:1234"]["ssl ca"] != "second.crt" {
+		t.Errorf("Unexpected server options: %v", serverOptions)
+	}
+}
+
+func TestExtractServerOptionsInvalid(t *testing.T) {
+	for _, server := range []interface{}{
+		map[string]interface{}{"ssl ca": "missing.crt"},
+		1234,
+	} {
+		rawConfig := map[string]interface{}{
+			"network": map[string]interface{}{
+				"servers": []interface{}{server},
+			},
+		}
+
+		if _, err := NewConfig().extractServerOptions(rawConfig); err == nil {
+			t.Errorf("Invalid server was accepted: %v", server)
+		}
+	}
+}
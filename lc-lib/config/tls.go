@@ -0,0 +1,75 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// tlsVersions maps the names accepted by the "min tls version" options to the
+// TLS version
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion returns the TLS version for the given "min tls version"
+// option value
+func ParseTLSVersion(name string) (uint16, error) {
+	version, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("min tls version must be \"1.0\", \"1.1\", \"1.2\" or \"1.3\"")
+	}
+	return version, nil
+}
+
+// ParseCipherSuites returns the IDs of the given cipher suite names, returning
+// nil if none are given so that the Go defaults are used. Only cipher suites
+// that are considered secure and are used by TLS 1.2 and earlier are accepted,
+// as the cipher suites of TLS 1.3 can not be configured
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	supported := make(map[string]uint16)
+	var supportedNames []string
+	for _, suite := range tls.CipherSuites() {
+		for _, version := range suite.SupportedVersions {
+			if version < tls.VersionTLS13 {
+				supported[suite.Name] = suite.ID
+				supportedNames = append(supportedNames, suite.Name)
+				break
+			}
+		}
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := supported[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite \"%s\", supported cipher suites are: %s", name, strings.Join(supportedNames, ", "))
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	if version, err := ParseTLSVersion("1.2"); err != nil || version != tls.VersionTLS12 {
+		t.Errorf("Unexpected result: %d, %v", version, err)
+	}
+
+	if _, err := ParseTLSVersion("1.4"); err == nil {
+		t.Error("Unknown TLS version was accepted")
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(suites) != 2 || suites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 || suites[1] != tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384 {
+		t.Errorf("Unexpected cipher suites: %v", suites)
+	}
+
+	if suites, err := ParseCipherSuites(nil); err != nil || suites != nil {
+		t.Errorf("Unexpected result for no cipher suites: %v, %v", suites, err)
+	}
+
+	// The error lists the supported names
+	_, err = ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"})
+	if err == nil {
+		t.Fatal("Insecure cipher suite was accepted")
+	}
+	if !strings.Contains(err.Error(), "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256") {
+		t.Errorf("Error does not list supported cipher suites: %s", err)
+	}
+
+	// TLS 1.3 cipher suites can not be configured
+	if _, err := ParseCipherSuites([]string{"TLS_AES_128_GCM_SHA256"}); err == nil {
+		t.Error("TLS 1.3 cipher suite was accepted")
+	}
+}
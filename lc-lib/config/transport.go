@@ -37,3 +37,21 @@ func AvailableTransports() (ret []string) {
 	}
 	return
 }
+
+var registeredTransportProtocols = make(map[string]string)
+
+// RegisterTransportProtocol registers a protocol that transports can be
+// configured to use, along with a short description of it
+func RegisterTransportProtocol(protocol string, description string) {
+	registeredTransportProtocols[protocol] = description
+}
+
+// AvailableTransportProtocols returns the list of registered transport
+// protocols, each followed by its description
+func AvailableTransportProtocols() (ret []string) {
+	ret = make([]string, 0, len(registeredTransportProtocols))
+	for k, description := range registeredTransportProtocols {
+		ret = append(ret, k+": "+description)
+	}
+	return
+}
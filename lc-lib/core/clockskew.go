@@ -0,0 +1,45 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var (
+	clockSkew      int64
+	clockSkewKnown int32
+)
+
+// SetClockSkew records the most recently measured difference between the
+// clock of a remote and the local clock, which is positive when the remote
+// clock is ahead
+func SetClockSkew(skew time.Duration) {
+	atomic.StoreInt64(&clockSkew, int64(skew))
+	atomic.StoreInt32(&clockSkewKnown, 1)
+}
+
+// ClockSkew returns the most recently measured clock skew, and whether one has
+// been measured at all
+func ClockSkew() (time.Duration, bool) {
+	if atomic.LoadInt32(&clockSkewKnown) == 0 {
+		return 0, false
+	}
+
+	return time.Duration(atomic.LoadInt64(&clockSkew)), true
+}
@@ -16,7 +16,21 @@
 
 package core
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	encodeFailureTag = "_encodefailure"
+
+	// maxExactNumber is the largest integer a float64 can represent exactly, and
+	// so the largest integer that many JSON implementations can handle
+	maxExactNumber = 1 << 53
+)
 
 // Event holds a key-value map that represents a single log event
 type Event map[string]interface{}
@@ -29,7 +43,214 @@ type EventDescriptor struct {
 	Event  []byte
 }
 
+// AddTags adds tags to the "tags" field of the Event, creating it if necessary
+// If the "tags" field exists but is not an array it is left untouched
+// The existing array is always copied as it may be shared with the
+// configuration of the stream
+func (e Event) AddTags(tags ...string) {
+	value, ok := e["tags"]
+	if !ok {
+		newTags := make([]string, len(tags))
+		copy(newTags, tags)
+		e["tags"] = newTags
+		return
+	}
+
+	switch existing := value.(type) {
+	case []string:
+		newTags := make([]string, len(existing), len(existing)+len(tags))
+		copy(newTags, existing)
+		e["tags"] = append(newTags, tags...)
+	case []interface{}:
+		newTags := make([]interface{}, len(existing), len(existing)+len(tags))
+		copy(newTags, existing)
+		for _, tag := range tags {
+			newTags = append(newTags, tag)
+		}
+		e["tags"] = newTags
+	}
+}
+
+// GetPath returns a field of the Event using a dot separated path, such as
+// "host.name", and whether it was present
+func (e Event) GetPath(path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	current := map[string]interface{}(e)
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	value, ok := current[parts[len(parts)-1]]
+	return value, ok
+}
+
+// SetPath sets a field of the Event using a dot separated path, such as
+// "host.name", creating nested maps as necessary. Any existing value along the
+// path that is not a map is replaced
+func (e Event) SetPath(path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	current := map[string]interface{}(e)
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+}
+
+// Timestamp returns the canonical timestamp of the Event from its "@timestamp"
+// field, which may be a time or an RFC3339 string, and whether it was present
+func (e Event) Timestamp() (time.Time, bool) {
+	switch value := e["@timestamp"].(type) {
+	case time.Time:
+		return value, true
+	case string:
+		if timestamp, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return timestamp, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// DecodeEvent decodes an Event from JSON format
+//
+// Numbers are decoded as json.Number rather than float64, so that large
+// integers such as 64-bit IDs are not rounded, and so they are encoded again
+// exactly as they were received
+func DecodeEvent(data []byte) (Event, error) {
+	var event Event
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&event); err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// StringifyBigNumbers converts any integers in the Event that are too large to
+// be represented exactly by a float64 into strings, for the benefit of systems
+// that would otherwise lose their precision
+func (e Event) StringifyBigNumbers() {
+	stringifyMap(e)
+}
+
+// stringifyMap converts big numbers within a map, recursing into nested maps
+// and arrays
+func stringifyMap(value map[string]interface{}) {
+	for key, child := range value {
+		value[key] = stringifyValue(child)
+	}
+}
+
+// stringifyValue returns the value with big numbers converted to strings
+func stringifyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if isBigNumber(value) {
+			return value.String()
+		}
+	case map[string]interface{}:
+		stringifyMap(value)
+	case []interface{}:
+		for i, child := range value {
+			value[i] = stringifyValue(child)
+		}
+	}
+
+	return value
+}
+
+// isBigNumber returns true if the number is an integer that can not be
+// represented exactly by a float64
+func isBigNumber(number json.Number) bool {
+	if strings.ContainsAny(number.String(), ".eE") {
+		return false
+	}
+
+	integer, err := strconv.ParseInt(number.String(), 10, 64)
+	if err != nil {
+		// Out of range of int64 entirely
+		return true
+	}
+
+	return integer > maxExactNumber || integer < -maxExactNumber
+}
+
 // Encode returns the Event in JSON format
-func (e Event) Encode() ([]byte, error) {
-	return json.Marshal(e)
+//
+// If the Event can not be encoded, such as when a processor has set a field to
+// a value JSON does not support, the offending values are removed and the Event
+// is tagged with "_encodefailure" so that it is not lost. If it still can not be
+// encoded it is replaced with a minimal Event containing only the message, from
+// the given message field, and tags. Invalid UTF-8 is always replaced with the
+// Unicode replacement character
+func (e Event) Encode(messageField string) ([]byte, error) {
+	encoded, err := json.Marshal(e)
+	if err == nil {
+		return encoded, nil
+	}
+
+	log.Warning("Removing values from event that could not be encoded: %s", err)
+
+	sanitizeMap(e)
+	e.AddTags(encodeFailureTag)
+	if encoded, err = json.Marshal(e); err == nil {
+		return encoded, nil
+	}
+
+	minimal := Event{}
+	if message, ok := e.GetPath(messageField); ok {
+		if message, ok := message.(string); ok {
+			minimal.SetPath(messageField, message)
+		}
+	}
+	minimal.AddTags(encodeFailureTag)
+	return json.Marshal(minimal)
+}
+
+// sanitizeMap removes values from a map that can not be encoded, recursing into
+// nested maps and arrays so that as much of the data is kept as possible
+func sanitizeMap(value map[string]interface{}) {
+	for key, child := range value {
+		if sanitized, ok := sanitizeValue(child); ok {
+			value[key] = sanitized
+		} else {
+			delete(value, key)
+		}
+	}
+}
+
+// sanitizeValue returns the value with anything that can not be encoded
+// removed, and false if the value itself can not be encoded
+func sanitizeValue(value interface{}) (interface{}, bool) {
+	switch value := value.(type) {
+	case string:
+		return strings.ToValidUTF8(value, "\uFFFD"), true
+	case map[string]interface{}:
+		sanitizeMap(value)
+		return value, true
+	case []interface{}:
+		sanitized := make([]interface{}, 0, len(value))
+		for _, child := range value {
+			if child, ok := sanitizeValue(child); ok {
+				sanitized = append(sanitized, child)
+			}
+		}
+		return sanitized, true
+	}
+
+	if _, err := json.Marshal(value); err != nil {
+		return nil, false
+	}
+
+	return value, true
 }
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"testing"
+)
+
+const bigNumberTestEvent = `{"id":1234567890123456789,"nested":{"ids":[9007199254740993,42]},"ratio":0.5,"small":-9007199254740992}`
+
+func TestEventBigNumberRoundTrip(t *testing.T) {
+	event, err := DecodeEvent([]byte(bigNumberTestEvent))
+	if err != nil {
+		t.Fatalf("Failed to decode event: %s", err)
+	}
+
+	encoded, err := event.Encode("message")
+	if err != nil {
+		t.Fatalf("Failed to encode event: %s", err)
+	}
+
+	if string(encoded) != bigNumberTestEvent {
+		t.Errorf("Event did not round-trip exactly: %s", encoded)
+	}
+}
+
+func TestEventStringifyBigNumbers(t *testing.T) {
+	event, err := DecodeEvent([]byte(bigNumberTestEvent))
+	if err != nil {
+		t.Fatalf("Failed to decode event: %s", err)
+	}
+
+	event.StringifyBigNumbers()
+
+	encoded, err := event.Encode("message")
+	if err != nil {
+		t.Fatalf("Failed to encode event: %s", err)
+	}
+
+	expected := `{"id":"1234567890123456789","nested":{"ids":["9007199254740993",42]},"ratio":0.5,"small":-9007199254740992}`
+	if string(encoded) != expected {
+		t.Errorf("Big numbers were not converted to strings: %s", encoded)
+	}
+}
+
+func TestEventDecodeInvalid(t *testing.T) {
+	if _, err := DecodeEvent([]byte(`{"message":`)); err == nil {
+		t.Error("Invalid event was decoded")
+	}
+}
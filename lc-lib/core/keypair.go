@@ -0,0 +1,287 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrIncorrectPassphrase is returned by LoadX509KeyPair when the private key
+	// could not be decrypted with the given passphrase
+	ErrIncorrectPassphrase = errors.New("incorrect passphrase")
+
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+
+	secretVariablePattern = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+)
+
+// ExpandSecret returns the value of the named environment variable when the
+// secret is entirely of the form "${VAR}", so that it does not need to be
+// stored in the configuration file. Any other value is returned unchanged so
+// that literal secrets containing "$" are not altered
+func ExpandSecret(secret string) string {
+	if match := secretVariablePattern.FindStringSubmatch(secret); match != nil {
+		return os.Getenv(match[1])
+	}
+	return secret
+}
+
+// encryptedPrivateKeyInfo is the PKCS#8 EncryptedPrivateKeyInfo structure
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params is the PKCS#5 PBES2-params structure
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params is the PKCS#5 PBKDF2-params structure
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// LoadX509KeyPair reads a certificate and private key from a pair of PEM
+// files, as tls.LoadX509KeyPair does, decrypting the private key with the
+// given passphrase if it is encrypted. Both legacy PEM encryption and PKCS#8
+// encryption using PBES2 are supported. The certificate file may contain
+// intermediate certificates following the leaf, and these must be in order
+func LoadX509KeyPair(certFile string, keyFile string, passphrase string) (tls.Certificate, error) {
+	certPEMBlock, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	keyPEMBlock, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if passphrase != "" {
+		if keyPEMBlock, err = decryptKeyPEM(keyPEMBlock, passphrase); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	certificate, err := tls.X509KeyPair(certPEMBlock, keyPEMBlock)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if err = verifyCertificateChain(&certificate); err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return certificate, nil
+}
+
+// verifyCertificateChain checks that each certificate in the chain is signed by
+// the certificate that follows it, so that the leaf and any intermediates are
+// presented in the order the remote expects. The private key is already known
+// to match the leaf as tls.X509KeyPair checks it
+func verifyCertificateChain(certificate *tls.Certificate) error {
+	chain := make([]*x509.Certificate, len(certificate.Certificate))
+	for i, der := range certificate.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate %d: %s", i+1, err)
+		}
+		chain[i] = cert
+	}
+
+	for i := 0; i < len(chain)-1; i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			return fmt.Errorf("certificate chain is out of order: \"%s\" is not signed by \"%s\" which follows it", chain[i].Subject.CommonName, chain[i+1].Subject.CommonName)
+		}
+	}
+
+	certificate.Leaf = chain[0]
+	return nil
+}
+
+// decryptKeyPEM finds the private key block within the PEM data and returns
+// it as an unencrypted PEM block
+func decryptKeyPEM(data []byte, passphrase string) ([]byte, error) {
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return nil, errors.New("failed to find any PEM data in key input")
+		}
+
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			der, err := decryptPKCS8(block.Bytes, []byte(passphrase))
+			if err != nil {
+				return nil, err
+			}
+
+			return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+		}
+
+		if block.Type == "PRIVATE KEY" || strings.HasSuffix(block.Type, " PRIVATE KEY") {
+			if !x509.IsEncryptedPEMBlock(block) {
+				return pem.EncodeToMemory(block), nil
+			}
+
+			der, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+			if err == x509.IncorrectPasswordError || (err == nil && !isPrivateKey(der)) {
+				return nil, ErrIncorrectPassphrase
+			} else if err != nil {
+				return nil, err
+			}
+
+			return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+		}
+	}
+}
+
+// decryptPKCS8 decrypts a PKCS#8 EncryptedPrivateKeyInfo structure, returning
+// the DER encoded PKCS#8 PrivateKeyInfo
+func decryptPKCS8(der []byte, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted private key: %s", err)
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption algorithm: %s", info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse private key encryption parameters: %s", err)
+	}
+
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported private key derivation function: %s", params.KeyDerivationFunc.Algorithm)
+	}
+
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, fmt.Errorf("failed to parse private key derivation parameters: %s", err)
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case kdfParams.PRF.Algorithm == nil, kdfParams.PRF.Algorithm.Equal(oidHMACSHA1):
+		prf = sha1.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACSHA256):
+		prf = sha256.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACSHA384):
+		prf = sha512.New384
+	case kdfParams.PRF.Algorithm.Equal(oidHMACSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported private key derivation PRF: %s", kdfParams.PRF.Algorithm)
+	}
+
+	var (
+		keyLength int
+		newCipher func([]byte) (cipher.Block, error)
+		iv        []byte
+	)
+	scheme := params.EncryptionScheme.Algorithm
+	switch {
+	case scheme.Equal(oidAES128CBC):
+		keyLength, newCipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keyLength, newCipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keyLength, newCipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLength, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("unsupported private key encryption scheme: %s", scheme)
+	}
+
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("failed to parse private key encryption IV: %s", err)
+	}
+
+	key := PBKDF2Key(passphrase, kdfParams.Salt, kdfParams.IterationCount, keyLength, prf)
+
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := info.EncryptedData
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("encrypted private key is corrupt")
+	}
+
+	decrypted := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, data)
+
+	// Invalid padding or an unparseable key indicates the passphrase was wrong
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > block.BlockSize() || !bytes.Equal(decrypted[len(decrypted)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, ErrIncorrectPassphrase
+	}
+	decrypted = decrypted[:len(decrypted)-padding]
+
+	if !isPrivateKey(decrypted) {
+		return nil, ErrIncorrectPassphrase
+	}
+
+	return decrypted, nil
+}
+
+// isPrivateKey returns true if the DER data parses as a PKCS#1, PKCS#8 or EC
+// private key
+func isPrivateKey(der []byte) bool {
+	if _, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return true
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return true
+	}
+	_, err := x509.ParseECPrivateKey(der)
+	return err == nil
+}
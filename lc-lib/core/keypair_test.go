@@ -0,0 +1,253 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createTestKeyPair(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+}
+
+func encryptTestPKCS8(t *testing.T, key *ecdsa.PrivateKey, passphrase string) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %s", err)
+	}
+
+	salt, iv := make([]byte, 8), make([]byte, aes.BlockSize)
+	rand.Read(salt)
+	rand.Read(iv)
+
+	derived := PBKDF2Key([]byte(passphrase), salt, 2048, 32, sha256.New)
+
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	for i := 0; i < padding; i++ {
+		der = append(der, byte(padding))
+	}
+
+	block, _ := aes.NewCipher(derived)
+	encrypted := make([]byte, len(der))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, der)
+
+	marshal := func(value interface{}) asn1.RawValue {
+		data, err := asn1.Marshal(value)
+		if err != nil {
+			t.Fatalf("Failed to marshal: %s", err)
+		}
+		return asn1.RawValue{FullBytes: data}
+	}
+
+	info := encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm: oidPBES2,
+			Parameters: marshal(pbes2Params{
+				KeyDerivationFunc: pkix.AlgorithmIdentifier{
+					Algorithm: oidPBKDF2,
+					Parameters: marshal(pbkdf2Params{
+						Salt:           salt,
+						IterationCount: 2048,
+						PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACSHA256, Parameters: asn1.NullRawValue},
+					}),
+				},
+				EncryptionScheme: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: marshal(iv)},
+			}),
+		},
+		EncryptedData: encrypted,
+	}
+
+	data, err := asn1.Marshal(info)
+	if err != nil {
+		t.Fatalf("Failed to marshal encrypted key: %s", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: data})
+}
+
+func checkLoadKeyPair(t *testing.T, certPEM []byte, keyPEM []byte, passphrase string, expected error) {
+	dir, err := ioutil.TempDir("", "keypair")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, certPEM, 0600)
+	ioutil.WriteFile(keyFile, keyPEM, 0600)
+
+	certificate, err := LoadX509KeyPair(certFile, keyFile, passphrase)
+	if err != expected {
+		t.Fatalf("Unexpected error: %v != %v", err, expected)
+	}
+
+	if expected == nil && certificate.PrivateKey == nil {
+		t.Error("Private key was not loaded")
+	}
+}
+
+func TestLoadKeyPairUnencrypted(t *testing.T) {
+	key, certPEM := createTestKeyPair(t)
+
+	der, _ := x509.MarshalECPrivateKey(key)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	checkLoadKeyPair(t, certPEM, keyPEM, "", nil)
+	checkLoadKeyPair(t, certPEM, keyPEM, "unused", nil)
+}
+
+func TestLoadKeyPairLegacyEncrypted(t *testing.T) {
+	key, certPEM := createTestKeyPair(t)
+
+	der, _ := x509.MarshalECPrivateKey(key)
+	block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatalf("Failed to encrypt key: %s", err)
+	}
+	keyPEM := pem.EncodeToMemory(block)
+
+	checkLoadKeyPair(t, certPEM, keyPEM, "secret", nil)
+	checkLoadKeyPair(t, certPEM, keyPEM, "wrong", ErrIncorrectPassphrase)
+}
+
+func TestLoadKeyPairPKCS8Encrypted(t *testing.T) {
+	key, certPEM := createTestKeyPair(t)
+	keyPEM := encryptTestPKCS8(t, key, "secret")
+
+	checkLoadKeyPair(t, certPEM, keyPEM, "secret", nil)
+	checkLoadKeyPair(t, certPEM, keyPEM, "wrong", ErrIncorrectPassphrase)
+}
+
+func createTestCertificate(t *testing.T, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestLoadKeyPairChain(t *testing.T) {
+	root, rootKey, _ := createTestCertificate(t, "root", true, nil, nil)
+	intermediate, intermediateKey, intermediatePEM := createTestCertificate(t, "intermediate", true, root, rootKey)
+	_, leafKey, leafPEM := createTestCertificate(t, "leaf", false, intermediate, intermediateKey)
+	_, _, otherPEM := createTestCertificate(t, "other", true, nil, nil)
+
+	der, _ := x509.MarshalECPrivateKey(leafKey)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	dir, err := ioutil.TempDir("", "keypair")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	load := func(certPEM []byte) (tls.Certificate, error) {
+		certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+		ioutil.WriteFile(certFile, certPEM, 0600)
+		ioutil.WriteFile(keyFile, keyPEM, 0600)
+		return LoadX509KeyPair(certFile, keyFile, "")
+	}
+
+	certificate, err := load(append(append([]byte{}, leafPEM...), intermediatePEM...))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(certificate.Certificate) != 2 {
+		t.Errorf("Intermediate certificate was not loaded: %d certificates", len(certificate.Certificate))
+	}
+	if certificate.Leaf == nil || certificate.Leaf.Subject.CommonName != "leaf" {
+		t.Errorf("Incorrect leaf certificate: %v", certificate.Leaf)
+	}
+
+	// The key must match the first certificate
+	if _, err := load(append(append([]byte{}, intermediatePEM...), leafPEM...)); err == nil {
+		t.Error("Chain with the leaf last was accepted")
+	}
+
+	// Each certificate must be signed by the next
+	if _, err := load(append(append([]byte{}, leafPEM...), otherPEM...)); err == nil {
+		t.Error("Chain with an unrelated certificate was accepted")
+	}
+}
+
+func TestExpandSecret(t *testing.T) {
+	os.Setenv("LC_TEST_PASSPHRASE", "from$env")
+	defer os.Unsetenv("LC_TEST_PASSPHRASE")
+
+	if secret := ExpandSecret("${LC_TEST_PASSPHRASE}"); secret != "from$env" {
+		t.Errorf("Environment variable was not used: %s", secret)
+	}
+
+	// Literal values are never altered, even where they look like variables
+	for _, literal := range []string{"pa$$word", "$LC_TEST_PASSPHRASE", "x${LC_TEST_PASSPHRASE}", "${LC_TEST_PASSPHRASE}x", "${}"} {
+		if secret := ExpandSecret(literal); secret != literal {
+			t.Errorf("Literal %q was altered: %s", literal, secret)
+		}
+	}
+}
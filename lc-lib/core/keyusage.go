@@ -0,0 +1,55 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"crypto/x509"
+	"fmt"
+)
+
+// VerifyExtKeyUsage returns a function for use as the VerifyPeerCertificate of
+// a tls.Config that rejects peer certificates that do not explicitly permit the
+// given extended key usage. The standard verification accepts certificates
+// that have no extended key usages at all, which would otherwise allow a
+// certificate intended for a different purpose to be used
+func VerifyExtKeyUsage(usage x509.ExtKeyUsage) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			return nil
+		}
+
+		cert := verifiedChains[0][0]
+		for _, certUsage := range cert.ExtKeyUsage {
+			if certUsage == usage || certUsage == x509.ExtKeyUsageAny {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("certificate with common name '%s' does not permit the %s extended key usage", cert.Subject.CommonName, extKeyUsageName(usage))
+	}
+}
+
+// extKeyUsageName returns the name of an extended key usage for error messages
+func extKeyUsageName(usage x509.ExtKeyUsage) string {
+	switch usage {
+	case x509.ExtKeyUsageServerAuth:
+		return "serverAuth"
+	case x509.ExtKeyUsageClientAuth:
+		return "clientAuth"
+	}
+	return fmt.Sprintf("%d", usage)
+}
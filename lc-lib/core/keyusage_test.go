@@ -0,0 +1,138 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createKeyUsageCertificate(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, usages []x509.ExtKeyUsage) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  usages,
+	}
+
+	if parent == nil {
+		template.Subject.CommonName = "Test CA"
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %s", err)
+	}
+
+	return cert, key
+}
+
+// checkKeyUsageHandshake performs a handshake with a server presenting a
+// certificate with the given usages, returning the client's error
+func checkKeyUsageHandshake(t *testing.T, usages []x509.ExtKeyUsage) error {
+	ca, caKey := createKeyUsageCertificate(t, nil, nil, nil)
+	cert, key := createKeyUsageCertificate(t, ca, caKey, usages)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		server, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		tlsServer := tls.Server(server, &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}},
+		})
+		tlsServer.Handshake()
+		tlsServer.Close()
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer client.Close()
+
+	tlsClient := tls.Client(client, &tls.Config{
+		RootCAs:               roots,
+		ServerName:            "localhost",
+		VerifyPeerCertificate: VerifyExtKeyUsage(x509.ExtKeyUsageServerAuth),
+	})
+	tlsClient.SetDeadline(time.Now().Add(5 * time.Second))
+	return tlsClient.Handshake()
+}
+
+func TestVerifyExtKeyUsage(t *testing.T) {
+	if err := checkKeyUsageHandshake(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}); err != nil {
+		t.Errorf("Certificate with serverAuth was rejected: %s", err)
+	}
+
+	if err := checkKeyUsageHandshake(t, []x509.ExtKeyUsage{x509.ExtKeyUsageAny}); err != nil {
+		t.Errorf("Certificate with any usage was rejected: %s", err)
+	}
+}
+
+func TestVerifyExtKeyUsageMissing(t *testing.T) {
+	// Go's own verification accepts a certificate without any extended key
+	// usages, so this must be rejected by the callback
+	err := checkKeyUsageHandshake(t, nil)
+	if err == nil || !strings.Contains(err.Error(), "does not permit the serverAuth extended key usage") {
+		t.Errorf("Certificate without extended key usages was not rejected: %v", err)
+	}
+}
+
+func TestVerifyExtKeyUsageWrong(t *testing.T) {
+	ca, caKey := createKeyUsageCertificate(t, nil, nil, nil)
+	cert, _ := createKeyUsageCertificate(t, ca, caKey, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
+
+	verify := VerifyExtKeyUsage(x509.ExtKeyUsageClientAuth)
+	if err := verify(nil, [][]*x509.Certificate{{cert, ca}}); err == nil {
+		t.Error("Certificate with only serverAuth was accepted for clientAuth")
+	}
+}
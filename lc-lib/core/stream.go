@@ -22,3 +22,10 @@ import "os"
 type Stream interface {
 	Info() (string, os.FileInfo)
 }
+
+// AckStream is a Stream that must be told when its events are acknowledged,
+// such as events received from a remote Log Courier that is relaying through us
+type AckStream interface {
+	Stream
+	Ack(offset int64)
+}
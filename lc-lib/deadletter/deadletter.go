@@ -0,0 +1,119 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// entry is the structure written to the dead letter file for each event
+type entry struct {
+	Timestamp string      `json:"@timestamp"`
+	Reason    string      `json:"reason"`
+	Source    string      `json:"source"`
+	Path      string      `json:"path"`
+	Offset    int64       `json:"offset"`
+	Event     interface{} `json:"event"`
+}
+
+// writer appends entries to the dead letter file
+// The file is opened for each write as dead letters are expected to be rare,
+// and this avoids holding a file open across configuration reloads
+type writer struct {
+	mutex sync.Mutex
+	path  string
+}
+
+// Write records an event that could not be shipped in the dead letter file,
+// along with the reason and the name of the component that rejected it. If the
+// event itself could not be encoded, a string representation is written
+func (c *Config) Write(source string, reason string, path string, offset int64, event interface{}) error {
+	e := &entry{
+		Timestamp: time.Now().Format(time.RFC3339),
+		Reason:    reason,
+		Source:    source,
+		Path:      path,
+		Offset:    offset,
+		Event:     event,
+	}
+
+	encoded, err := json.Marshal(e)
+	if err != nil {
+		e.Event = fmt.Sprintf("%v", event)
+		if encoded, err = json.Marshal(e); err != nil {
+			return err
+		}
+	}
+
+	return c.writer.write(append(encoded, '\n'))
+}
+
+// MatchTags returns the first of the configured tags that the given event
+// tags contain, or an empty string if there is no match
+func (c *Config) MatchTags(tags interface{}) string {
+	if len(c.Tags) == 0 {
+		return ""
+	}
+
+	switch value := tags.(type) {
+	case []string:
+		for _, tag := range value {
+			if c.hasTag(tag) {
+				return tag
+			}
+		}
+	case []interface{}:
+		for _, tag := range value {
+			if tagString, ok := tag.(string); ok && c.hasTag(tagString) {
+				return tagString
+			}
+		}
+	}
+
+	return ""
+}
+
+// hasTag returns true if the given tag is one of the configured tags
+func (c *Config) hasTag(tag string) bool {
+	for _, configTag := range c.Tags {
+		if configTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *writer) write(data []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
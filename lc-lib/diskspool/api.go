@@ -0,0 +1,52 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package diskspool
+
+import (
+	"github.com/driskell/log-courier/lc-lib/admin"
+)
+
+type apiStatus struct {
+	admin.APIKeyValue
+
+	d *DiskSpool
+}
+
+// Update updates the disk spool status information
+func (a *apiStatus) Update() error {
+	// Update the values and pass through to node
+	a.d.mutex.Lock()
+	a.SetEntry("size", admin.APINumber(a.d.queue.used()))
+	a.SetEntry("maxSize", admin.APINumber(a.d.config.DiskSpoolMaxSize))
+	a.SetEntry("droppedEvents", admin.APINumber(a.d.dropped))
+	a.d.mutex.Unlock()
+
+	return nil
+}
+
+// initAPI sets up admin connectivity
+func (d *DiskSpool) initAPI() {
+	// Is admin loaded into the pipeline?
+	if !d.adminConfig.APIEnabled() {
+		return
+	}
+
+	diskSpoolAPI := &admin.APINode{}
+	diskSpoolAPI.SetEntry("status", &apiStatus{d: d})
+
+	d.adminConfig.SetEntry("diskSpool", diskSpoolAPI)
+}
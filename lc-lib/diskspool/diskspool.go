@@ -0,0 +1,260 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package diskspool
+
+import (
+	"os"
+	"sync"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/publisher"
+	"github.com/driskell/log-courier/lc-lib/registrar"
+)
+
+// DiskSpool sits between the Spooler and the Publisher and writes all events
+// to disk before they are published, so that events waiting to be sent survive
+// a restart. Events are acknowledged to the Registrar as soon as they are on
+// disk, and removed from disk once the Publisher has acknowledged them
+type DiskSpool struct {
+	core.PipelineSegment
+	core.PipelineConfigReceiver
+
+	mutex sync.Mutex
+
+	config         *config.General
+	adminConfig    *admin.Config
+	queue          *queue
+	input          chan []*core.EventDescriptor
+	output         chan<- []*core.EventDescriptor
+	registrarSpool registrar.EventSpooler
+	next           []*core.EventDescriptor
+	held           []*core.EventDescriptor
+	dropped        uint64
+
+	// Protected by the mutex, updated as the Publisher acknowledges events
+	acked   int64
+	ackChan chan struct{}
+	closed  bool
+}
+
+// NewDiskSpool creates a new disk spool, recovering any events left on disk by
+// a previous run so that they are published first
+func NewDiskSpool(pipeline *core.Pipeline, config *config.Config, registrarImp registrar.Registrator, publisherImp *publisher.Publisher) (*DiskSpool, error) {
+	ret := &DiskSpool{
+		config:         &config.General,
+		adminConfig:    config.Get("admin").(*admin.Config),
+		input:          make(chan []*core.EventDescriptor, 1),
+		output:         publisherImp.Connect(),
+		registrarSpool: registrarImp.Connect(),
+		ackChan:        make(chan struct{}, 1),
+	}
+
+	var err error
+	if ret.queue, err = openQueue(config.General.PersistDir, &stream{spool: ret}); err != nil {
+		ret.registrarSpool.Close()
+		return nil, err
+	}
+
+	if used := ret.queue.used(); used != 0 {
+		log.Notice("Recovered %d bytes of unpublished events from the disk spool", used)
+	}
+
+	ret.initAPI()
+
+	pipeline.Register(ret)
+
+	return ret, nil
+}
+
+// Connect is used by the Spooler
+func (d *DiskSpool) Connect() chan<- []*core.EventDescriptor {
+	return d.input
+}
+
+// Run writes spools received from the Spooler to disk and forwards events from
+// disk to the Publisher as it is ready to receive them
+func (d *DiskSpool) Run() {
+	defer func() {
+		d.Done()
+	}()
+
+DiskSpoolLoop:
+	for {
+		if d.next == nil {
+			d.readNext()
+		}
+
+		var output chan<- []*core.EventDescriptor
+		if d.next != nil {
+			output = d.output
+		}
+
+		var input <-chan []*core.EventDescriptor
+		if d.held == nil {
+			input = d.input
+		}
+
+		select {
+		case spool := <-input:
+			if !d.write(spool) {
+				break DiskSpoolLoop
+			}
+		case output <- d.next:
+			d.next = nil
+		case <-d.ackChan:
+			d.processAcks()
+			if d.held != nil {
+				held := d.held
+				d.held = nil
+				if !d.write(held) {
+					break DiskSpoolLoop
+				}
+			}
+		case <-d.OnShutdown():
+			break DiskSpoolLoop
+		case config := <-d.OnConfig():
+			d.mutex.Lock()
+			d.config = &config.General
+			d.mutex.Unlock()
+		}
+	}
+
+	// The Publisher continues to receive acknowledgements while it shuts down,
+	// so from now on they are saved directly by the stream
+	d.mutex.Lock()
+	if err := d.queue.ack(d.acked); err != nil {
+		log.Error("Failed to save disk spool state: %s", err)
+	}
+	if err := d.queue.close(); err != nil {
+		log.Error("Failed to save disk spool state: %s", err)
+	}
+	d.closed = true
+	d.mutex.Unlock()
+
+	d.registrarSpool.Close()
+
+	log.Info("Disk spool exiting")
+}
+
+// readNext reads the next events from disk to forward to the Publisher
+func (d *DiskSpool) readNext() {
+	events, err := d.queue.read(d.config.SpoolSize, d.config.SpoolMaxBytes)
+	if err != nil {
+		log.Error("Failed to read from the disk spool, skipping the remainder of the segment: %s", err)
+		d.queue.skipSegment()
+		return
+	}
+
+	if len(events) != 0 {
+		d.next = events
+	}
+}
+
+// write stores a spool on disk and acknowledges it to the Registrar. If the
+// disk spool is full the spool is held until space is available, or dropped,
+// depending on the configuration. Returns false if shutdown was requested
+func (d *DiskSpool) write(spool []*core.EventDescriptor) bool {
+	d.mutex.Lock()
+	used := d.queue.used()
+	if used != 0 && used+recordsSize(spool) > d.config.DiskSpoolMaxSize {
+		if d.config.DiskSpoolAction == "drop" {
+			d.dropped += uint64(len(spool))
+			d.mutex.Unlock()
+			log.Warning("Disk spool is full, dropping %d events", len(spool))
+			d.ackRegistrar(spool)
+			return true
+		}
+
+		d.mutex.Unlock()
+		if d.held == nil {
+			log.Warning("Disk spool is full, holding new events until space is available")
+		}
+		d.held = spool
+		return true
+	}
+
+	err := d.queue.append(spool)
+	d.mutex.Unlock()
+
+	if err != nil {
+		// Pass the events straight through so they are still delivered, they
+		// will be acknowledged to the Registrar once published as usual
+		log.Error("Failed to write to the disk spool, publishing %d events without it: %s", len(spool), err)
+		select {
+		case <-d.OnShutdown():
+			return false
+		case d.output <- spool:
+		}
+		return true
+	}
+
+	d.ackRegistrar(spool)
+	return true
+}
+
+// ackRegistrar acknowledges events to the Registrar so their offsets are saved
+func (d *DiskSpool) ackRegistrar(spool []*core.EventDescriptor) {
+	d.registrarSpool.Add(registrar.NewAckEvent(spool))
+	d.registrarSpool.Send()
+}
+
+// processAcks removes events the Publisher has acknowledged from disk
+func (d *DiskSpool) processAcks() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if err := d.queue.ack(d.acked); err != nil {
+		log.Error("Failed to save disk spool state: %s", err)
+	}
+}
+
+// stream is the Stream of events read from disk. When the Publisher
+// acknowledges them they are removed from disk
+type stream struct {
+	spool *DiskSpool
+}
+
+// Info returns the description of the stream
+func (s *stream) Info() (string, os.FileInfo) {
+	return "disk spool", nil
+}
+
+// Ack records that the events up to the given position have been acknowledged
+// and wakes the disk spool to remove them. It is called by the Registrar. Once
+// the disk spool has stopped they are removed immediately, so that events
+// acknowledged during shutdown are not sent again after a restart
+func (s *stream) Ack(offset int64) {
+	s.spool.mutex.Lock()
+	if offset > s.spool.acked {
+		s.spool.acked = offset
+	}
+	if s.spool.closed {
+		if err := s.spool.queue.ack(s.spool.acked); err != nil {
+			log.Error("Failed to save disk spool state: %s", err)
+		}
+		s.spool.mutex.Unlock()
+		return
+	}
+	s.spool.mutex.Unlock()
+
+	select {
+	case s.spool.ackChan <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package diskspool
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestAckAfterClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskspool")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool := &DiskSpool{ackChan: make(chan struct{}, 1)}
+	ackStream := &stream{spool: spool}
+	if spool.queue, err = openQueue(dir, ackStream); err != nil {
+		t.Fatalf("Failed to open queue: %s", err)
+	}
+
+	appendTestEvents(t, spool.queue, "one", "two")
+	events := readTestEvents(t, spool.queue, 2, "one", "two")
+
+	// Simulate the disk spool stopping before the Publisher has received the
+	// acknowledgement
+	spool.queue.close()
+	spool.closed = true
+
+	ackStream.Ack(events[1].Offset)
+
+	q := createTestQueue(t, dir)
+	defer q.close()
+
+	if q.used() != 0 {
+		t.Errorf("Events acknowledged after close were not removed: %d", q.used())
+	}
+	readTestEvents(t, q, 2)
+}
@@ -0,0 +1,505 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package diskspool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	// segmentPrefix is the prefix of the segment file names, which are followed
+	// by the segment identifier as 16 hexadecimal digits
+	segmentPrefix = ".log-courier.spool."
+
+	// ackFile holds the position up to which events have been acknowledged
+	ackFile = ".log-courier.spool.acked"
+
+	// segmentMaxSize is the size at which a new segment is started. Segments are
+	// removed once all of their events are acknowledged
+	segmentMaxSize = 64 * 1024 * 1024
+
+	// recordHeaderSize is the size of the length and checksum that precede each
+	// event in a segment
+	recordHeaderSize = 8
+)
+
+var (
+	errRecordCorrupt = errors.New("record checksum mismatch")
+)
+
+// position combines a segment identifier and an offset within it into a single
+// value that increases across segments, used as the offset of queued events
+func position(id uint64, offset int64) int64 {
+	return int64(id<<32) | offset
+}
+
+// positionSegment returns the segment identifier of a position
+func positionSegment(pos int64) uint64 {
+	return uint64(pos) >> 32
+}
+
+// positionOffset returns the offset within the segment of a position
+func positionOffset(pos int64) int64 {
+	return pos & 0xffffffff
+}
+
+// segment is a single file of the queue
+type segment struct {
+	id     uint64
+	size   int64
+	sealed bool
+}
+
+// queue is a write-ahead log of events stored as a series of segment files.
+// Events are appended to the newest segment and read back in order, and
+// segments are removed once all of their events are acknowledged
+type queue struct {
+	dir      string
+	segments []*segment
+	nextID   uint64
+	size     int64
+	acked    int64
+	readPos  int64
+	writer   *os.File
+	reader   *os.File
+	buffer   *bufio.Reader
+	stream   core.Stream
+}
+
+// openQueue opens the queue in the given directory, recovering any segments
+// left from a previous run. Events that were not acknowledged are read again
+func openQueue(dir string, stream core.Stream) (*queue, error) {
+	q := &queue{
+		dir:    dir,
+		nextID: 1,
+		stream: stream,
+	}
+
+	if err := q.loadAcked(); err != nil {
+		return nil, fmt.Errorf("Failed to load disk spool state: %s", err)
+	}
+
+	if err := q.loadSegments(); err != nil {
+		return nil, err
+	}
+
+	if positionSegment(q.acked) >= q.nextID {
+		q.nextID = positionSegment(q.acked) + 1
+	}
+
+	q.readPos = q.acked
+	if len(q.segments) != 0 && q.readPos < position(q.segments[0].id, 0) {
+		q.readPos = position(q.segments[0].id, 0)
+	}
+
+	q.removeAcked()
+
+	return q, nil
+}
+
+// loadAcked reads the acknowledged position
+func (q *queue) loadAcked() error {
+	data, err := ioutil.ReadFile(filepath.Join(q.dir, ackFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	q.acked, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return err
+}
+
+// saveAcked writes the acknowledged position, replacing the file atomically
+func (q *queue) saveAcked() error {
+	name := filepath.Join(q.dir, ackFile)
+	tname := name + ".new"
+	if err := ioutil.WriteFile(tname, []byte(strconv.FormatInt(q.acked, 10)+"\n"), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tname, name)
+}
+
+// loadSegments finds the existing segments, truncating any incomplete record
+// left at the end of one by a crash
+func (q *queue) loadSegments() error {
+	matches, err := filepath.Glob(filepath.Join(q.dir, segmentPrefix+"*"))
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(filepath.Base(match), segmentPrefix)
+		if len(suffix) != 16 {
+			continue
+		}
+
+		id, err := strconv.ParseUint(suffix, 16, 64)
+		if err != nil {
+			continue
+		}
+
+		q.segments = append(q.segments, &segment{id: id})
+	}
+
+	sort.Slice(q.segments, func(i, j int) bool {
+		return q.segments[i].id < q.segments[j].id
+	})
+
+	for _, seg := range q.segments {
+		if err := q.recoverSegment(seg); err != nil {
+			return fmt.Errorf("Failed to recover disk spool segment %s: %s", q.segmentPath(seg.id), err)
+		}
+
+		q.size += seg.size
+		q.nextID = seg.id + 1
+	}
+
+	return nil
+}
+
+// recoverSegment determines the size of the valid records in a segment and
+// truncates anything after them
+func (q *queue) recoverSegment(seg *segment) error {
+	file, err := os.OpenFile(q.segmentPath(seg.id), os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		length, err := readRecord(reader, info.Size()-seg.size, nil)
+		if err != nil {
+			break
+		}
+		seg.size += length
+	}
+
+	if seg.size != info.Size() {
+		log.Warning("Discarding %d bytes of incomplete events at the end of disk spool segment %s", info.Size()-seg.size, q.segmentPath(seg.id))
+		return file.Truncate(seg.size)
+	}
+
+	return nil
+}
+
+// segmentPath returns the path to a segment file
+func (q *queue) segmentPath(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%s%016x", segmentPrefix, id))
+}
+
+// used returns the number of bytes in the queue that are not yet acknowledged
+func (q *queue) used() int64 {
+	if len(q.segments) != 0 && positionSegment(q.acked) == q.segments[0].id {
+		return q.size - positionOffset(q.acked)
+	}
+	return q.size
+}
+
+// recordsSize returns the number of bytes the events will use in the queue
+func recordsSize(events []*core.EventDescriptor) int64 {
+	var size int64
+	for _, event := range events {
+		size += recordHeaderSize + int64(len(event.Event))
+	}
+	return size
+}
+
+// append writes events to the newest segment and syncs it to disk, starting a
+// new segment if the newest has reached the maximum size. If the write fails
+// the segment is truncated back so no partial events remain
+func (q *queue) append(events []*core.EventDescriptor) error {
+	if q.writer == nil || q.segments[len(q.segments)-1].size >= segmentMaxSize || q.segments[len(q.segments)-1].sealed {
+		if err := q.startSegment(); err != nil {
+			return err
+		}
+	}
+
+	seg := q.segments[len(q.segments)-1]
+
+	data := make([]byte, 0, recordsSize(events))
+	var header [recordHeaderSize]byte
+	for _, event := range events {
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(event.Event)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(event.Event))
+		data = append(data, header[:]...)
+		data = append(data, event.Event...)
+	}
+
+	_, err := q.writer.Write(data)
+	if err == nil {
+		err = q.writer.Sync()
+	}
+	if err != nil {
+		q.writer.Truncate(seg.size)
+		q.writer.Seek(seg.size, io.SeekStart)
+		return err
+	}
+
+	seg.size += int64(len(data))
+	q.size += int64(len(data))
+
+	return nil
+}
+
+// startSegment closes the current segment for writing and creates a new one
+func (q *queue) startSegment() error {
+	if q.writer != nil {
+		q.writer.Close()
+		q.writer = nil
+	}
+
+	id := q.nextID
+	if len(q.segments) != 0 && !q.segments[len(q.segments)-1].sealed && q.segments[len(q.segments)-1].size < segmentMaxSize {
+		// Continue the last segment left from a previous run
+		id = q.segments[len(q.segments)-1].id
+	}
+
+	writer, err := os.OpenFile(q.segmentPath(id), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	q.writer = writer
+	if id == q.nextID {
+		q.segments = append(q.segments, &segment{id: id})
+		q.nextID++
+	}
+
+	return nil
+}
+
+// read returns the next events in the queue, up to the given number of events
+// and bytes but always at least one event if any are available. Events do not
+// span segments so fewer may be returned than are available
+func (q *queue) read(maxEvents int64, maxBytes int64) ([]*core.EventDescriptor, error) {
+	seg := q.readSegment()
+	if seg == nil {
+		return nil, nil
+	}
+
+	if q.reader == nil {
+		reader, err := os.Open(q.segmentPath(seg.id))
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := reader.Seek(positionOffset(q.readPos), io.SeekStart); err != nil {
+			reader.Close()
+			return nil, err
+		}
+
+		q.reader = reader
+		q.buffer = bufio.NewReader(reader)
+	}
+
+	var events []*core.EventDescriptor
+	var size int64
+	offset := positionOffset(q.readPos)
+	for offset < seg.size && int64(len(events)) < maxEvents {
+		var data []byte
+		length, err := readRecord(q.buffer, seg.size-offset, &data)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(events) != 0 && size+length > maxBytes {
+			// Put it back by re-reading from this position next time
+			q.closeReader()
+			break
+		}
+
+		offset += length
+		size += length
+		events = append(events, &core.EventDescriptor{
+			Stream: q.stream,
+			Offset: position(seg.id, offset),
+			Event:  data,
+		})
+	}
+
+	q.readPos = position(seg.id, offset)
+
+	return events, nil
+}
+
+// readSegment returns the segment containing the read position, moving to the
+// next segment if the current one has been read completely, or nil if there is
+// nothing left to read
+func (q *queue) readSegment() *segment {
+	for i, seg := range q.segments {
+		if seg.id < positionSegment(q.readPos) {
+			continue
+		}
+
+		if seg.id > positionSegment(q.readPos) {
+			q.closeReader()
+			q.readPos = position(seg.id, 0)
+		}
+
+		if positionOffset(q.readPos) < seg.size {
+			return seg
+		}
+
+		if i == len(q.segments)-1 {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// skipSegment abandons the rest of the segment being read, used when it can
+// not be read so that the events after it are not held up indefinitely. The
+// segment is sealed so that new events are written to a new segment
+func (q *queue) skipSegment() {
+	q.closeReader()
+
+	for _, seg := range q.segments {
+		if seg.id == positionSegment(q.readPos) {
+			seg.sealed = true
+		}
+	}
+
+	if q.writer != nil && q.segments[len(q.segments)-1].sealed {
+		q.writer.Close()
+		q.writer = nil
+	}
+
+	q.readPos = position(positionSegment(q.readPos)+1, 0)
+}
+
+// closeReader closes the segment being read
+func (q *queue) closeReader() {
+	if q.reader != nil {
+		q.reader.Close()
+		q.reader = nil
+		q.buffer = nil
+	}
+}
+
+// ack records that events up to the given position are acknowledged, removing
+// any segments that are now completely acknowledged
+func (q *queue) ack(pos int64) error {
+	if pos <= q.acked {
+		return nil
+	}
+
+	q.acked = pos
+	q.removeAcked()
+
+	return q.saveAcked()
+}
+
+// removeAcked removes segments whose events are all acknowledged. The newest
+// segment is only removed once everything written to it has also been read
+func (q *queue) removeAcked() {
+	for len(q.segments) != 0 {
+		seg := q.segments[0]
+		if position(seg.id, seg.size) > q.acked {
+			return
+		}
+
+		if len(q.segments) == 1 {
+			if q.readPos < position(seg.id, seg.size) {
+				return
+			}
+
+			if q.writer != nil {
+				q.writer.Close()
+				q.writer = nil
+			}
+		}
+
+		if positionSegment(q.readPos) == seg.id {
+			q.closeReader()
+		}
+
+		if err := os.Remove(q.segmentPath(seg.id)); err != nil && !os.IsNotExist(err) {
+			log.Error("Failed to remove disk spool segment %s: %s", q.segmentPath(seg.id), err)
+		}
+
+		q.size -= seg.size
+		q.segments = q.segments[1:]
+	}
+}
+
+// close closes the open segments and saves the acknowledged position
+func (q *queue) close() error {
+	q.closeReader()
+
+	if q.writer != nil {
+		q.writer.Close()
+		q.writer = nil
+	}
+
+	return q.saveAcked()
+}
+
+// readRecord reads a single record, returning its size in the segment. The
+// record must fit within the given number of bytes remaining in the segment.
+// If data is not nil it is set to the event the record contains
+func readRecord(reader *bufio.Reader, remaining int64, data *[]byte) (int64, error) {
+	var header [recordHeaderSize]byte
+	if remaining < recordHeaderSize {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, err
+	}
+
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	if recordHeaderSize+length > remaining {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	event := make([]byte, length)
+	if _, err := io.ReadFull(reader, event); err != nil {
+		return 0, err
+	}
+
+	if crc32.ChecksumIEEE(event) != binary.BigEndian.Uint32(header[4:8]) {
+		return 0, errRecordCorrupt
+	}
+
+	if data != nil {
+		*data = event
+	}
+
+	return recordHeaderSize + int64(len(event)), nil
+}
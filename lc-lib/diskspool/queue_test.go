@@ -0,0 +1,185 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package diskspool
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createTestQueue(t *testing.T, dir string) *queue {
+	q, err := openQueue(dir, nil)
+	if err != nil {
+		t.Fatalf("Failed to open queue: %s", err)
+	}
+	return q
+}
+
+func appendTestEvents(t *testing.T, q *queue, events ...string) {
+	var spool []*core.EventDescriptor
+	for _, event := range events {
+		spool = append(spool, &core.EventDescriptor{Event: []byte(event)})
+	}
+
+	if err := q.append(spool); err != nil {
+		t.Fatalf("Failed to append events: %s", err)
+	}
+}
+
+func readTestEvents(t *testing.T, q *queue, maxEvents int64, expected ...string) []*core.EventDescriptor {
+	events, err := q.read(maxEvents, 1024)
+	if err != nil {
+		t.Fatalf("Failed to read events: %s", err)
+	}
+
+	if len(events) != len(expected) {
+		t.Fatalf("Unexpected number of events: %d != %d", len(events), len(expected))
+	}
+
+	for i, event := range events {
+		if string(event.Event) != expected[i] {
+			t.Errorf("Unexpected event %d: %s != %s", i, event.Event, expected[i])
+		}
+	}
+
+	return events
+}
+
+func TestQueueReadAck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskspool")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := createTestQueue(t, dir)
+	appendTestEvents(t, q, "one", "two", "three")
+
+	readTestEvents(t, q, 2, "one", "two")
+	events := readTestEvents(t, q, 2, "three")
+	readTestEvents(t, q, 2)
+
+	if q.used() != 3*recordHeaderSize+11 {
+		t.Errorf("Unexpected used size: %d", q.used())
+	}
+
+	if err := q.ack(events[0].Offset); err != nil {
+		t.Fatalf("Failed to ack: %s", err)
+	}
+
+	if q.used() != 0 {
+		t.Errorf("Queue not empty after ack: %d", q.used())
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(dir, segmentPrefix+"*")); len(matches) != 1 || filepath.Base(matches[0]) != ackFile {
+		t.Errorf("Acknowledged segment was not removed: %v", matches)
+	}
+
+	appendTestEvents(t, q, "four")
+	readTestEvents(t, q, 2, "four")
+	q.close()
+}
+
+func TestQueueRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskspool")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := createTestQueue(t, dir)
+	appendTestEvents(t, q, "one", "two", "three")
+	events := readTestEvents(t, q, 1, "one")
+	q.ack(events[0].Offset)
+	q.close()
+
+	// Simulate a crash part way through writing an event
+	file, err := os.OpenFile(q.segmentPath(1), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %s", err)
+	}
+	file.Write([]byte{0, 0, 0, 10, 1, 2})
+	file.Close()
+
+	q = createTestQueue(t, dir)
+	readTestEvents(t, q, 10, "two", "three")
+
+	appendTestEvents(t, q, "four")
+	readTestEvents(t, q, 10, "four")
+	q.close()
+}
+
+func TestQueueSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskspool")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := createTestQueue(t, dir)
+	appendTestEvents(t, q, "one")
+	q.segments[0].size = segmentMaxSize
+	appendTestEvents(t, q, "two")
+
+	if len(q.segments) != 2 {
+		t.Fatalf("New segment was not started: %d", len(q.segments))
+	}
+
+	// Restore the real size so the first segment can be read
+	q.segments[0].size = recordHeaderSize + 3
+
+	first := readTestEvents(t, q, 10, "one")
+	second := readTestEvents(t, q, 10, "two")
+	if first[0].Offset >= second[0].Offset {
+		t.Errorf("Offsets do not increase across segments: %d >= %d", first[0].Offset, second[0].Offset)
+	}
+
+	q.ack(first[0].Offset)
+	if len(q.segments) != 1 {
+		t.Errorf("First segment was not removed: %d", len(q.segments))
+	}
+	if _, err := os.Stat(q.segmentPath(1)); !os.IsNotExist(err) {
+		t.Errorf("First segment file still exists: %v", err)
+	}
+	q.close()
+}
+
+func TestQueueMaxBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskspool")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := createTestQueue(t, dir)
+	for i := 0; i < 5; i++ {
+		appendTestEvents(t, q, fmt.Sprintf("event%d", i))
+	}
+
+	events, err := q.read(10, 2*(recordHeaderSize+6))
+	if err != nil || len(events) != 2 {
+		t.Fatalf("Unexpected read: %d %v", len(events), err)
+	}
+
+	readTestEvents(t, q, 10, "event2", "event3", "event4")
+	q.close()
+}
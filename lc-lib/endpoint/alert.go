@@ -0,0 +1,70 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package endpoint
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// raiseAlert escalates a sustained failure of the endpoint once the configured
+// number of consecutive failures is reached. Shipping continues regardless, the
+// alert only makes the failure clearly visible
+func (e *Endpoint) raiseAlert(failures int64) {
+	e.mutex.Lock()
+	e.alerts++
+	e.alerting = true
+	e.mutex.Unlock()
+
+	log.Critical("[%s] Endpoint has failed %d consecutive times, events are not being delivered", e.Server(), failures)
+
+	if len(e.sink.config.AlertCommand) == 0 {
+		return
+	}
+
+	// Run the command in the background so a slow command can never hold up
+	// the publisher
+	go runAlertCommand(e.sink.config.AlertCommand, e.Server(), failures)
+}
+
+// clearAlert logs the recovery of an endpoint that had raised an alert
+func (e *Endpoint) clearAlert() {
+	e.mutex.Lock()
+	alerting := e.alerting
+	e.alerting = false
+	e.mutex.Unlock()
+
+	if alerting {
+		log.Notice("[%s] Endpoint is delivering events again", e.Server())
+	}
+}
+
+// runAlertCommand runs the configured alert command, passing the server and the
+// number of consecutive failures in the environment
+func runAlertCommand(command []string, server string, failures int64) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(
+		os.Environ(),
+		"LOG_COURIER_SERVER="+server,
+		fmt.Sprintf("LOG_COURIER_FAILURES=%d", failures),
+	)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		log.Error("[%s] Alert command failed: %s: %s", server, err, output)
+	}
+}
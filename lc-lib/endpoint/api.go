@@ -17,6 +17,7 @@
 package endpoint
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/driskell/log-courier/lc-lib/admin"
@@ -36,7 +37,18 @@ func (a *apiEndpoint) Update() error {
 	a.SetEntry("pendingPayloads", admin.APINumber(a.e.NumPending()))
 	a.SetEntry("publishedLines", admin.APINumber(a.e.LineCount()))
 	a.SetEntry("averageLatency", admin.APIFloat(a.e.AverageLatency()/time.Millisecond))
+	a.SetEntry("consecutiveFailures", admin.APINumber(a.e.addressPool.Failures()))
+	a.SetEntry("alerts", admin.APINumber(a.e.alerts))
+	if a.e.addressPool.IsHealthy() {
+		a.SetEntry("health", admin.APIString("Healthy"))
+	} else {
+		a.SetEntry("health", admin.APIString(fmt.Sprintf("Evicted (%v remaining)", a.e.addressPool.EvictionRemaining())))
+	}
 	a.e.mutex.RUnlock()
 
+	if transportAPI := a.e.transport.APIEncodable(); transportAPI != nil {
+		a.SetEntry("transport", transportAPI)
+	}
+
 	return nil
 }
@@ -64,6 +64,8 @@ type Endpoint struct {
 	estDelTime        time.Time
 	warming           bool
 	backoff           *core.ExpBackoff
+	alerts            int64
+	alerting          bool
 }
 
 // Init prepares the internal Element structures for InternalList and prepares
@@ -71,6 +73,10 @@ type Endpoint struct {
 func (e *Endpoint) Init() {
 	e.warming = true
 	e.backoff = core.NewExpBackoff(e.server+" Failure", e.sink.config.Backoff, e.sink.config.BackoffMax)
+	e.addressPool.SetHealthCheck(e.sink.config.HealthFailures, e.sink.config.HealthCooldown)
+	e.addressPool.SetRfc2782(e.sink.config.Rfc2782Srv, e.sink.config.Rfc2782Service)
+	e.addressPool.SetRefresh(e.sink.config.DNSRefresh)
+	e.addressPool.SetSelection(e.sink.config.ServerSelection)
 
 	e.readyElement.Value = e
 	e.failedElement.Value = e
@@ -118,6 +124,15 @@ func (e *Endpoint) Server() string {
 	return e.server
 }
 
+// NetworkTimeout returns the time to wait for a response from this endpoint,
+// which is the network timeout unless overridden for this server
+func (e *Endpoint) NetworkTimeout() time.Duration {
+	if timeout, ok := e.sink.config.ServerTimeouts[e.server]; ok {
+		return timeout
+	}
+	return e.sink.config.Timeout
+}
+
 // queuePayload registers a payload with the endpoint and sends it to the
 // transport
 func (e *Endpoint) queuePayload(payload *payload.Payload) error {
@@ -158,7 +173,7 @@ func (e *Endpoint) queuePayload(payload *payload.Payload) error {
 		log.Debug("[%s] Sending payload %x (%d events)", e.Server(), payload.Nonce, payload.Size())
 	}
 
-	if err := e.transport.Write(payload.Nonce, payload.Events()); err != nil {
+	if err := e.transport.Write(payload.Nonce, payload.Metadata, payload.Events()); err != nil {
 		return err
 	}
 
@@ -168,8 +183,8 @@ func (e *Endpoint) queuePayload(payload *payload.Payload) error {
 // generateNonce creates a random string for payload identification
 func (e *Endpoint) generateNonce() string {
 	// This could maybe be made a bit more efficient
-	nonce := make([]byte, 16)
-	for i := 0; i < 16; i++ {
+	nonce := make([]byte, transports.LegacyNonceLength)
+	for i := range nonce {
 		nonce[i] = byte(rand.Intn(255))
 	}
 	return string(nonce)
@@ -274,6 +289,11 @@ func (e *Endpoint) processAck(ack *transports.AckEvent, observer Observer) bool
 		// Reset backoff now we finished a whole payload - and reset warming flag
 		e.warming = false
 		e.backoff.Reset()
+
+		if e.addressPool.MarkSuccess() {
+			log.Notice("[%s] Endpoint is healthy again", e.Server())
+		}
+		e.clearAlert()
 	} else {
 		e.mutex.Lock()
 		e.lineCount += int64(lineCount)
@@ -297,6 +317,19 @@ func (e *Endpoint) processPong(observer Observer) {
 	observer.OnPong(e)
 }
 
+// recordFailure feeds a failure back into the address pool's health tracking,
+// logging a warning if the server is now evicted from rotation, and raising an
+// alert if the number of consecutive failures has reached the alert threshold
+func (e *Endpoint) recordFailure() {
+	if e.addressPool.MarkFailure() {
+		log.Warning("[%s] Endpoint is unhealthy after %d consecutive failures, evicting for %v", e.Server(), e.addressPool.Failures(), e.sink.config.HealthCooldown)
+	}
+
+	if failures := e.addressPool.Failures(); e.sink.config.AlertFailures != 0 && failures == e.sink.config.AlertFailures {
+		e.raiseAlert(failures)
+	}
+}
+
 // IsWarming returns whether the endpoint is warming up or not (slow-start)
 func (e *Endpoint) IsWarming() bool {
 	return e.warming && e.numPayloads != 0
@@ -74,6 +74,48 @@ func (s *Sink) QueuePayload(payload *payload.Payload) (*Endpoint, error) {
 	return bestEndpoint, bestEndpoint.queuePayload(payload)
 }
 
+// QueuePayloadAfter queues the events on the next ready endpoint following the
+// given endpoint, wrapping around to the first, so that payloads are sent to
+// each endpoint in turn. The first ready endpoint is used if the given endpoint
+// is nil or no longer ready. Warming endpoints are skipped unless there is no
+// other endpoint available.
+// Returns the chosen endpoint and any error that occurred sending the events.
+func (s *Sink) QueuePayloadAfter(after *Endpoint, payload *payload.Payload) (*Endpoint, error) {
+	if s.readyList.Len() == 0 {
+		return nil, nil
+	}
+
+	entry := s.readyList.Front()
+	if after != nil && after.IsActive() && after.readyElement.Next() != nil {
+		entry = after.readyElement.Next()
+	}
+
+	var chosen *Endpoint
+	for i := 0; i < s.readyList.Len(); i++ {
+		endpoint := entry.Value.(*Endpoint)
+		if chosen == nil {
+			chosen = endpoint
+		}
+
+		if !endpoint.IsWarming() {
+			chosen = endpoint
+			break
+		}
+
+		if entry = entry.Next(); entry == nil {
+			entry = s.readyList.Front()
+		}
+	}
+
+	return chosen, chosen.queuePayload(payload)
+}
+
+// QueuePayloadOn queues the events on a specific endpoint, rather than locating
+// the best endpoint, returning any error that occurred sending the events.
+func (s *Sink) QueuePayloadOn(endpoint *Endpoint, payload *payload.Payload) error {
+	return endpoint.queuePayload(payload)
+}
+
 // ForceFailure forces an endpoint to fail
 func (s *Sink) ForceFailure(endpoint *Endpoint) {
 	if endpoint.IsFailed() {
@@ -67,6 +67,7 @@ func (s *Sink) ProcessEvent(event transports.Event, observer Observer) {
 func (s *Sink) processStatusChange(status *transports.StatusEvent, endpoint *Endpoint, observer Observer) {
 	switch status.StatusChange() {
 	case transports.Failed:
+		endpoint.recordFailure()
 		s.moveFailed(endpoint, observer)
 	case transports.Started:
 		if endpoint.IsFailed() {
@@ -77,6 +78,12 @@ func (s *Sink) processStatusChange(status *transports.StatusEvent, endpoint *End
 		// Mark as active
 		s.markActive(endpoint, observer)
 	case transports.Finished:
+		// Transports that finish on failure do not send a Failed status, so a
+		// finish that was not requested is recorded as a failure
+		if !endpoint.IsClosing() {
+			endpoint.recordFailure()
+		}
+
 		server := endpoint.Server()
 		s.removeEndpoint(server)
 
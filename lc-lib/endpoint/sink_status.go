@@ -84,6 +84,13 @@ func (s *Sink) recoverFailed(endpoint *Endpoint, observer Observer) {
 	s.failedList.Remove(&endpoint.failedElement)
 
 	backoff := endpoint.backoff.Trigger()
+
+	// Keep an unhealthy endpoint out of rotation until its eviction expires, at
+	// which point it will be probed again
+	if eviction := endpoint.addressPool.EvictionRemaining(); eviction > backoff {
+		backoff = eviction
+	}
+
 	log.Info("[%s] Endpoint has recovered - will resume in %v", endpoint.Server(), backoff)
 
 	// Backoff before allowing recovery
@@ -0,0 +1,117 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package harvester
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// byteOrderMark is the byte order mark as it appears once decoded, and which
+// is stripped from the start of a file
+const byteOrderMark = "\uFEFF"
+
+// LineEncoding transcodes lines read from a file in a specific character
+// encoding to UTF-8. Lines are located using the encoded form of the delimiter
+// so that offsets always remain byte offsets into the original file
+type LineEncoding interface {
+	// Delimiter returns the encoded form of the given line delimiter. Its length
+	// is the width of a code unit, and delimiters are only matched at code unit
+	// boundaries
+	Delimiter(delimiter byte) []byte
+
+	// Decode transcodes a line, excluding its delimiter, to UTF-8
+	Decode(line []byte) string
+}
+
+// NewLineEncoding returns the LineEncoding for the given encoding name, which
+// must have been validated by the configuration. If the encoding is "utf-16"
+// the byte order is detected from the byte order mark at the start of the
+// given file, and is big endian if there is no byte order mark or the file is
+// nil, as it is for streams
+func NewLineEncoding(name string, file io.ReaderAt) LineEncoding {
+	switch name {
+	case "iso-8859-1":
+		return latin1Encoding{}
+	case "utf-16":
+		var bom [2]byte
+		if file != nil {
+			if _, err := file.ReadAt(bom[:], 0); err == nil && bom[0] == 0xFF && bom[1] == 0xFE {
+				return utf16Encoding{order: binary.LittleEndian}
+			}
+		}
+		return utf16Encoding{order: binary.BigEndian}
+	case "utf-16be":
+		return utf16Encoding{order: binary.BigEndian}
+	case "utf-16le":
+		return utf16Encoding{order: binary.LittleEndian}
+	}
+
+	return utf8Encoding{}
+}
+
+// utf8Encoding passes lines through unchanged
+type utf8Encoding struct{}
+
+func (utf8Encoding) Delimiter(delimiter byte) []byte {
+	return []byte{delimiter}
+}
+
+func (utf8Encoding) Decode(line []byte) string {
+	// We use string() to copy the memory, which is a slice of the line buffer
+	// the reader will re-use
+	return string(line)
+}
+
+// latin1Encoding decodes ISO-8859-1, where every byte is the code point of the
+// same value
+type latin1Encoding struct{}
+
+func (latin1Encoding) Delimiter(delimiter byte) []byte {
+	return []byte{delimiter}
+}
+
+func (latin1Encoding) Decode(line []byte) string {
+	var builder strings.Builder
+	builder.Grow(len(line))
+	for _, char := range line {
+		builder.WriteRune(rune(char))
+	}
+	return builder.String()
+}
+
+// utf16Encoding decodes UTF-16 in the given byte order. Unpaired surrogates
+// are replaced with the Unicode replacement character
+type utf16Encoding struct {
+	order binary.ByteOrder
+}
+
+func (e utf16Encoding) Delimiter(delimiter byte) []byte {
+	encoded := make([]byte, 2)
+	e.order.PutUint16(encoded, uint16(delimiter))
+	return encoded
+}
+
+func (e utf16Encoding) Decode(line []byte) string {
+	units := make([]uint16, len(line)/2)
+	for i := range units {
+		units[i] = e.order.Uint16(line[i*2:])
+	}
+	return string(utf16.Decode(units))
+}
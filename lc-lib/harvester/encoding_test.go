@@ -0,0 +1,78 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+func checkDecode(t *testing.T, encoding LineEncoding, data []byte, expected string) {
+	if decoded := encoding.Decode(data); decoded != expected {
+		t.Errorf("Incorrect decoding: found %q != expected %q", decoded, expected)
+	}
+}
+
+func TestEncodingDecode(t *testing.T) {
+	checkDecode(t, NewLineEncoding("utf-8", nil), []byte("caf\xc3\xa9"), "caf\u00e9")
+	checkDecode(t, NewLineEncoding("iso-8859-1", nil), []byte("caf\xe9"), "caf\u00e9")
+	checkDecode(t, NewLineEncoding("utf-16le", nil), []byte("c\x00a\x00f\x00\xe9\x00=\xd8\x00\xde"), "caf\u00e9\U0001F600")
+	checkDecode(t, NewLineEncoding("utf-16be", nil), []byte("\x00c\x00a\x00f\x00\xe9\xd8=\xde\x00"), "caf\u00e9\U0001F600")
+
+	// Unpaired surrogates are replaced
+	checkDecode(t, NewLineEncoding("utf-16le", nil), []byte("=\xd8a\x00"), "\uFFFDa")
+}
+
+func TestEncodingDetectByteOrder(t *testing.T) {
+	little := NewLineEncoding("utf-16", bytes.NewReader([]byte("\xff\xfea\x00")))
+	if delimiter := little.Delimiter('\n'); !bytes.Equal(delimiter, []byte("\n\x00")) {
+		t.Errorf("Incorrect little endian delimiter: %q", delimiter)
+	}
+
+	big := NewLineEncoding("utf-16", bytes.NewReader([]byte("\x00a")))
+	if delimiter := big.Delimiter('\n'); !bytes.Equal(delimiter, []byte("\x00\n")) {
+		t.Errorf("Incorrect big endian delimiter: %q", delimiter)
+	}
+}
+
+func TestEncodingReadline(t *testing.T) {
+	data := []byte("\xff\xfea\x00\r\x00\n\x00\xff\xfeb\x00\n\x00")
+	encoding := NewLineEncoding("utf-16", bytes.NewReader(data))
+
+	h := &Harvester{
+		streamConfig:   &config.Stream{LineDelimiter: "\n"},
+		encoding:       encoding,
+		lineEnding:     encoding.Delimiter('\n'),
+		carriageReturn: encoding.Delimiter('\r'),
+	}
+	h.reader = NewEncodedLineReader(bytes.NewReader(data), 100, 100, h.lineEnding)
+
+	// Byte order mark is only stripped from the start of the file, and lengths
+	// are of the original bytes
+	text, length, err := h.readline()
+	if err != nil || text != "a" || length != 8 {
+		t.Errorf("Incorrect first line: %q %d %v", text, length, err)
+	}
+
+	h.offset += int64(length)
+	text, length, err = h.readline()
+	if err != nil || text != "\uFEFFb" || length != 6 {
+		t.Errorf("Incorrect second line: %q %d %v", text, length, err)
+	}
+}
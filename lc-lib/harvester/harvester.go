@@ -20,25 +20,39 @@
 package harvester
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/driskell/log-courier/lc-lib/admin"
-	"github.com/driskell/log-courier/lc-lib/codecs"
 	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/deadletter"
+	"github.com/driskell/log-courier/lc-lib/hostmeta"
+	"github.com/driskell/log-courier/lc-lib/processors"
+	"github.com/driskell/log-courier/lc-lib/registrar"
 )
 
 var (
 	// Stdin is the filename that represents stdin
 	Stdin = "stdin"
 
+	// ErrTooManyOpenFiles is the error a harvester finishes with when it could
+	// not open its file because the open files limit has been reached
+	ErrTooManyOpenFiles = errors.New("Too many open files")
+
 	errFileTruncated = errors.New("File truncation detected")
 	errStopRequested = errors.New("Stop requested")
+	errEndOfStream   = errors.New("End of stream")
+
+	// statCheckInterval is how often the file is checked for truncation and
+	// whether it has reached the dead time
+	statCheckInterval = 10 * time.Second
 )
 
 // FinishStatus contains the final file state, and any errors, from the point the
@@ -48,6 +62,13 @@ type FinishStatus struct {
 	LastReadOffset  int64
 	Error           error
 	LastStat        os.FileInfo
+	LastLineTime    time.Time
+
+	// The offset following the last event passed to the output. Lines after it
+	// that were discarded, such as empty lines or lines dropped by processors,
+	// are never acknowledged, so this is the offset to wait for when waiting for
+	// everything read to be acknowledged
+	LastShippedOffset int64
 }
 
 // Harvester reads from a file, passes lines through a codec, and sends them
@@ -56,55 +77,75 @@ type Harvester struct {
 	mutex sync.RWMutex
 
 	stopChan        chan interface{}
+	stopOnce        sync.Once
 	returnChan      chan *FinishStatus
 	stream          core.Stream
 	fileinfo        os.FileInfo
 	path            string
+	open            func(string) (*os.File, error)
 	config          *config.Config
 	streamConfig    *config.Stream
+	deadLetter      *deadletter.Config
+	sequence        *registrar.Sequence
 	offset          int64
+	shippedOffset   int64
 	output          chan<- *core.EventDescriptor
-	codec           codecs.Codec
-	codecChain      []codecs.Codec
+	routes          []*harvesterRoute
+	routeStubs      [][]config.ProcessorStub
+	processors      []processors.Processor
+	processorStubs  []config.ProcessorStub
+	metrics         *processors.Metrics
 	file            *os.File
 	backOffTimer    *time.Timer
 	meterTimer      *time.Timer
 	split           bool
 	timezone        string
 	reader          *LineReader
+	encoding        LineEncoding
+	lineEnding      []byte
+	carriageReturn  []byte
+	limiter         *lineLimiter
 	staleOffset     int64
 	staleBytes      int64
 	lastStaleOffset int64
 	isStream        bool
+	stopAtEOF       bool
 
 	lastReadTime         time.Time
 	lastMeasurement      time.Time
 	lastCheck            time.Time
 	lastLineCount        uint64
 	lastByteCount        uint64
+	lastThrottleTime     time.Duration
 	secondsWithoutEvents int
 
 	lineSpeed  float64
 	byteSpeed  float64
 	lineCount  uint64
 	byteCount  uint64
+	rejected   uint64
 	lastEOFOff *int64
 	lastEOF    *time.Time
 	lastSize   int64
 	lastOffset int64
+	lastLine   time.Time
+	throttle   time.Duration
+	throttled  bool
 }
 
 // NewHarvester creates a new harvester with the given configuration for the given stream identifier
-func NewHarvester(stream core.Stream, config *config.Config, streamConfig *config.Stream, offset int64) *Harvester {
+// If sequence is not nil each event is stamped with the next sequence number
+func NewHarvester(stream core.Stream, config *config.Config, streamConfig *config.Stream, sequence *registrar.Sequence, offset int64) *Harvester {
 	ret := &Harvester{
 		stopChan:     make(chan interface{}),
 		stream:       stream,
 		config:       config,
 		streamConfig: streamConfig,
+		deadLetter:   config.Get("dead letter").(*deadletter.Config),
+		sequence:     sequence,
 		offset:       offset,
 		timezone:     time.Now().Format("-0700 MST"),
 		lastEOF:      nil,
-		codecChain:   make([]codecs.Codec, len(streamConfig.Codecs)-1),
 		backOffTimer: time.NewTimer(0),
 		// TODO: Configurable meter timer? Use same as statCheck timer
 		meterTimer: time.NewTimer(10 * time.Second),
@@ -112,6 +153,12 @@ func NewHarvester(stream core.Stream, config *config.Config, streamConfig *confi
 
 	ret.backOffTimer.Stop()
 
+	if streamConfig.MaxLineRate != 0 {
+		ret.limiter = newLineLimiter(streamConfig.MaxLineRate)
+	}
+
+	ret.open = ret.openFile
+
 	if stream != nil {
 		// Grab now so we can safely use them even if prospector changes them
 		ret.path, ret.fileinfo = stream.Info()
@@ -123,21 +170,81 @@ func NewHarvester(stream core.Stream, config *config.Config, streamConfig *confi
 		ret.isStream = true
 	}
 
-	// Build the codec chain
-	var entry codecs.Codec
-	callback := ret.eventCallback
-	for i := len(streamConfig.Codecs) - 1; i >= 0; i-- {
-		entry = codecs.NewCodec(streamConfig.Codecs[i].Factory, callback, ret.offset)
-		callback = entry.Event
-		if i != 0 {
-			ret.codecChain[i-1] = entry
-		}
+	ret.routeStubs = routeProcessors(streamConfig.Routes)
+	ret.buildRoutes()
+
+	ret.processorStubs = streamConfig.Processors
+	ret.processors = newProcessors(streamConfig.Processors)
+	ret.metrics = processors.NewMetrics(len(streamConfig.Processors))
+
+	return ret
+}
+
+// buildRoutes builds the codec chain of each route, starting at the current
+// offset
+func (h *Harvester) buildRoutes() {
+	if len(h.streamConfig.Routes) == 0 {
+		h.routes = []*harvesterRoute{newHarvesterRoute("", h.streamConfig.Codecs, nil, h.eventCallback, h.offset)}
+		return
+	}
+
+	h.routes = nil
+	for i, route := range h.streamConfig.Routes {
+		h.routes = append(h.routes, newHarvesterRoute(route.Name, route.Codecs, h.routeStubs[i], h.eventCallback, h.offset))
 	}
-	ret.codec = entry
+}
 
+// routeProcessors returns the processors of each of the given routes, which
+// the harvester keeps its own copy of so they can be replaced on reload
+func routeProcessors(routes []config.Route) [][]config.ProcessorStub {
+	ret := make([][]config.ProcessorStub, len(routes))
+	for i := range routes {
+		ret[i] = routes[i].Processors
+	}
 	return ret
 }
 
+// newProcessors creates the processor instances for a list of processors
+func newProcessors(stubs []config.ProcessorStub) []processors.Processor {
+	ret := make([]processors.Processor, len(stubs))
+	for i := range stubs {
+		ret[i] = processors.NewProcessor(stubs[i].Factory)
+	}
+	return ret
+}
+
+// SetProcessors replaces the processors the harvester runs events through,
+// along with those of each of its routes, taking effect from the next event, so
+// that the processor configuration can be reloaded without restarting the
+// harvester. The routes given must match those of the harvester other than in
+// their processors. Processing metrics restart from zero
+func (h *Harvester) SetProcessors(stubs []config.ProcessorStub, routes []config.Route) {
+	instances := newProcessors(stubs)
+	metrics := processors.NewMetrics(len(stubs))
+
+	routeStubs := routeProcessors(routes)
+	routeInstances := make([][]processors.Processor, len(routes))
+	routeMetrics := make([]*processors.Metrics, len(routes))
+	for i := range routeStubs {
+		routeInstances[i] = newProcessors(routeStubs[i])
+		routeMetrics[i] = processors.NewMetrics(len(routeStubs[i]))
+	}
+
+	h.mutex.Lock()
+	h.processorStubs = stubs
+	h.processors = instances
+	h.metrics = metrics
+	if len(routes) != 0 {
+		h.routeStubs = routeStubs
+		for i, route := range h.routes {
+			route.stubs = routeStubs[i]
+			route.processors = routeInstances[i]
+			route.metrics = routeMetrics[i]
+		}
+	}
+	h.mutex.Unlock()
+}
+
 // Start runs the harvester, sending events to the output given, and returns
 // immediately
 func (h *Harvester) Start(output chan<- *core.EventDescriptor) {
@@ -152,15 +259,30 @@ func (h *Harvester) Start(output chan<- *core.EventDescriptor) {
 		status := &FinishStatus{}
 		status.LastEventOffset, status.Error = h.harvest(output)
 		status.LastReadOffset = h.offset
+		h.mutex.RLock()
+		status.LastShippedOffset = h.shippedOffset
+		h.mutex.RUnlock()
 		status.LastStat = h.fileinfo
+		if h.lineCount != 0 {
+			status.LastLineTime = h.lastReadTime
+		}
 		h.returnChan <- status
 		close(h.returnChan)
 	}()
 }
 
-// Stop requests the harvester to stop
+// StopAtEOF requests the harvester to stop when it reaches the end of the file
+// rather than waiting for more data until the dead time, such as when the file
+// is known to have been rotated. It must be called before Start
+func (h *Harvester) StopAtEOF() {
+	h.stopAtEOF = true
+}
+
+// Stop requests the harvester to stop. It may be called more than once
 func (h *Harvester) Stop() {
-	close(h.stopChan)
+	h.stopOnce.Do(func() {
+		close(h.stopChan)
+	})
 }
 
 // OnFinish returns a channel which will receive a FinishStatus structure when
@@ -169,13 +291,17 @@ func (h *Harvester) OnFinish() <-chan *FinishStatus {
 	return h.returnChan
 }
 
-// codecTeardown shuts down all codecs in the order they are used
+// codecTeardown shuts down the codecs of all routes and returns the offset
+// that all routes have completely processed up to
 func (h *Harvester) codecTeardown() int64 {
-	for _, codec := range h.codecChain {
-		codec.Teardown()
+	offset := h.routes[0].teardown()
+	for _, route := range h.routes[1:] {
+		if routeOffset := route.teardown(); routeOffset < offset {
+			offset = routeOffset
+		}
 	}
 
-	return h.codec.Teardown()
+	return offset
 }
 
 // harvest runs in its own routine, opening the file and starting the read loop
@@ -208,8 +334,22 @@ func (h *Harvester) harvest(output chan<- *core.EventDescriptor) (int64, error)
 		h.offset = offset
 	}
 
+	h.mutex.Lock()
+	h.shippedOffset = h.offset
+	h.mutex.Unlock()
+
+	// Lines are split using the encoded delimiter and transcoded afterwards so
+	// that offsets remain byte offsets into the file
+	var encodedFile io.ReaderAt
+	if !h.isStream {
+		encodedFile = h.file
+	}
+	h.encoding = NewLineEncoding(h.streamConfig.Encoding, encodedFile)
+	h.lineEnding = h.encoding.Delimiter(h.streamConfig.LineDelimiter[0])
+	h.carriageReturn = h.encoding.Delimiter('\r')
+
 	// The buffer size limits the maximum line length we can read, including terminator
-	h.reader = NewLineReader(h.file, int(h.config.General.LineBufferBytes), int(h.config.General.MaxLineBytes))
+	h.reader = NewEncodedLineReader(h.file, int(h.config.General.LineBufferBytes), int(h.config.General.MaxLineBytes), h.lineEnding)
 
 	// Prepare internal data
 	h.lastReadTime = time.Now()
@@ -218,6 +358,14 @@ func (h *Harvester) harvest(output chan<- *core.EventDescriptor) (int64, error)
 
 	for {
 		if err := h.performRead(); err != nil {
+			if err == errEndOfStream {
+				// No more lines are expected, so emit any partial events the codecs
+				// are holding, such as the last lines of a multiline event
+				for _, route := range h.routes {
+					route.flush()
+				}
+				break
+			}
 			if err == errStopRequested {
 				break
 			}
@@ -231,6 +379,12 @@ func (h *Harvester) harvest(output chan<- *core.EventDescriptor) (int64, error)
 
 // performRead performs a single read operation
 func (h *Harvester) performRead() error {
+	if h.limiter != nil {
+		if err := h.throttleRead(); err != nil {
+			return err
+		}
+	}
+
 	text, bytesread, err := h.readline()
 
 	// Is a measurement due?
@@ -245,15 +399,7 @@ func (h *Harvester) performRead() error {
 	}
 
 	if err == nil {
-		lineOffset := h.offset
-		h.offset += int64(bytesread)
-
-		// Codec is last - it forwards harvester state for us such as offset for resume
-		h.codec.Event(lineOffset, h.offset, text)
-
-		h.lastReadTime = time.Now()
-		h.lineCount++
-		h.byteCount += uint64(bytesread)
+		h.handleLine(text, bytesread)
 		return nil
 	}
 
@@ -262,10 +408,17 @@ func (h *Harvester) performRead() error {
 		return err
 	}
 
-	if h.isStream {
-		// Stream has finished
+	if h.isStream || h.stopAtEOF {
+		// Stream has finished. Nothing more can be written to a stream so a final
+		// line without a line ending is complete, whereas for a file it may still
+		// be being written and is left to be read in full later
+		if h.isStream {
+			if text, length := h.readRemaining(); length != 0 {
+				h.handleLine(text, length)
+			}
+		}
 		log.Info("Stopping harvest of %s; EOF reached", h.path)
-		return errStopRequested
+		return errEndOfStream
 	}
 
 	h.mutex.Lock()
@@ -287,6 +440,28 @@ func (h *Harvester) performRead() error {
 	return nil
 }
 
+// throttleRead waits until the line rate limit allows another line to be read.
+// Lines are never dropped, so a file exceeding the limit is simply read slower
+func (h *Harvester) throttleRead() error {
+	delay := h.limiter.Delay(time.Now())
+	if delay == 0 {
+		return nil
+	}
+
+	h.backOffTimer.Reset(delay)
+	select {
+	case <-h.stopChan:
+		return errStopRequested
+	case <-h.backOffTimer.C:
+	}
+
+	h.mutex.Lock()
+	h.throttle += delay
+	h.mutex.Unlock()
+
+	return nil
+}
+
 func (h *Harvester) handleTruncation() {
 	log.Warning("Unexpected file truncation, seeking to beginning: %s", h.path)
 
@@ -295,6 +470,10 @@ func (h *Harvester) handleTruncation() {
 	h.staleOffset = 0
 	h.lastStaleOffset = 0
 
+	h.mutex.Lock()
+	h.shippedOffset = 0
+	h.mutex.Unlock()
+
 	// TODO: Should we be allowing truncation to lose buffer data? Or should
 	//       we be flushing what we have?
 	if h.reader.BufferedLen() != 0 {
@@ -303,7 +482,9 @@ func (h *Harvester) handleTruncation() {
 
 	// Reset line buffer and codec buffers
 	h.reader.Reset()
-	h.codec.Reset()
+	for _, route := range h.routes {
+		route.reset()
+	}
 }
 
 func (h *Harvester) takeMeasurements(duration time.Duration, isPipelineBlocked bool) error {
@@ -313,7 +494,7 @@ func (h *Harvester) takeMeasurements(duration time.Duration, isPipelineBlocked b
 	// TODO: Make time configurable? Bear in mind this does a stale buffer check
 	//       and reports an error saying "stale data for more than 10s"
 	doChecks := false
-	if checksDuration := time.Since(h.lastCheck); checksDuration >= 10*time.Second {
+	if checksDuration := time.Since(h.lastCheck); checksDuration >= statCheckInterval {
 		h.lastCheck = h.lastMeasurement
 		doChecks = true
 	}
@@ -343,10 +524,15 @@ func (h *Harvester) takeMeasurements(duration time.Duration, isPipelineBlocked b
 	}
 
 	h.mutex.Lock()
+	if h.lineCount != h.lastLineCount {
+		h.lastLine = h.lastReadTime
+	}
 	h.lineSpeed = core.CalculateSpeed(duration, h.lineSpeed, float64(h.lineCount-h.lastLineCount), &h.secondsWithoutEvents)
 	h.byteSpeed = core.CalculateSpeed(duration, h.byteSpeed, float64(h.byteCount-h.lastByteCount), &h.secondsWithoutEvents)
 	h.lastByteCount = h.byteCount
 	h.lastLineCount = h.lineCount
+	h.throttled = h.throttle != h.lastThrottleTime
+	h.lastThrottleTime = h.throttle
 	h.lastOffset = h.offset
 	if h.fileinfo != nil {
 		h.lastSize = h.fileinfo.Size()
@@ -359,9 +545,8 @@ func (h *Harvester) takeMeasurements(duration time.Duration, isPipelineBlocked b
 	} else {
 		h.staleBytes = 0
 	}
-	h.codec.Meter()
-	for _, codec := range h.codecChain {
-		codec.Meter()
+	for _, route := range h.routes {
+		route.meter()
 	}
 	h.mutex.Unlock()
 
@@ -395,7 +580,7 @@ func (h *Harvester) statCheck() error {
 	if age := time.Since(h.lastReadTime); age > h.streamConfig.DeadTime && h.fileinfo.ModTime() == info.ModTime() {
 		log.Info("Stopping harvest of %s; last change was %v ago", h.path, age-(age%time.Second))
 		// TODO: dead_action implementation here
-		return errStopRequested
+		return errEndOfStream
 	}
 
 	// Store latest stat()
@@ -404,14 +589,62 @@ func (h *Harvester) statCheck() error {
 	return nil
 }
 
-// eventCallback receives events from the final codec and ships them to the output
-func (h *Harvester) eventCallback(startOffset int64, endOffset int64, text string) {
-	event := core.Event{
-		"message": text,
+// eventTimestamp returns the timestamp for a new event, which is the time it
+// was read unless the stream is configured to use the modification time of the
+// file as of the last time it was checked. Stdin has no modification time so
+// always uses the read time
+func (h *Harvester) eventTimestamp() time.Time {
+	if h.streamConfig.TimestampSource == "mtime" && h.fileinfo != nil {
+		return h.fileinfo.ModTime()
+	}
+	return time.Now()
+}
+
+// routeOffset records the progress of a route and returns the offset that all
+// routes have completely processed up to, which is the offset that can be
+// safely acknowledged once the event is shipped
+func (h *Harvester) routeOffset(route *harvesterRoute, endOffset int64) int64 {
+	if len(h.routes) == 1 {
+		return endOffset
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	route.progress = endOffset
+	for _, other := range h.routes {
+		if other.progress < endOffset {
+			endOffset = other.progress
+		}
+	}
+
+	return endOffset
+}
+
+// eventCallback receives events from the final codec of a route and ships them
+// to the output
+func (h *Harvester) eventCallback(route *harvesterRoute, startOffset int64, endOffset int64, text string, fields map[string]interface{}) {
+	endOffset = h.routeOffset(route, endOffset)
+
+	if h.isSkippedLine(text) {
+		// The offset of the next event shipped will include this line
+		return
+	}
+
+	event := core.Event{}
+	event.SetPath(h.streamConfig.MessageField, text)
+
+	// Keep the line as it was output by the codecs, before any processor can
+	// replace or modify it
+	if h.streamConfig.OriginalField != "" {
+		event.SetPath(h.streamConfig.OriginalField, text)
 	}
 
 	if h.streamConfig.AddHostField {
-		event["host"] = h.config.General.Host
+		event.SetPath(h.streamConfig.HostField, h.config.General.Host)
+	}
+	if prefix, fields := hostmeta.Fields(); fields != nil {
+		event.SetPath(prefix, fields)
 	}
 	if h.streamConfig.AddPathField {
 		event["path"] = h.path
@@ -419,9 +652,22 @@ func (h *Harvester) eventCallback(startOffset int64, endOffset int64, text strin
 	if h.streamConfig.AddOffsetField {
 		event["offset"] = startOffset
 	}
+	if h.streamConfig.AddTimestampField {
+		// Always UTC so events from different hosts can be compared directly, and
+		// kept as a time.Time so nanosecond precision is retained when encoded
+		event["@timestamp"] = h.eventTimestamp().UTC()
+	}
 	if h.streamConfig.AddTimezoneField {
 		event["timezone"] = h.timezone
 	}
+	if h.streamConfig.AddClockSkewField {
+		if skew, ok := core.ClockSkew(); ok {
+			event["clock_skew"] = skew.Seconds()
+		}
+	}
+	if h.streamConfig.Type != "" {
+		event["type"] = h.streamConfig.Type
+	}
 
 	for k := range h.config.General.GlobalFields {
 		event[k] = h.config.General.GlobalFields[k]
@@ -431,24 +677,72 @@ func (h *Harvester) eventCallback(startOffset int64, endOffset int64, text strin
 		event[k] = h.streamConfig.Fields[k]
 	}
 
+	// Fields added by the codecs, such as the count of collapsed lines
+	for k := range fields {
+		event[k] = fields[k]
+	}
+
+	if len(h.streamConfig.Tags) != 0 {
+		event.AddTags(h.streamConfig.Tags...)
+	}
+
 	// If we split any of the line data, tag it
 	if h.split {
-		if v, ok := event["tags"]; ok {
-			va, ok := v.([]string)
-			if ok {
-				va = append(va, "splitline")
-				event["tags"] = va
-			}
-		} else {
-			event["tags"] = []string{"splitline"}
-		}
+		event.AddTags("splitline")
 		h.split = false
 	}
 
-	encoded, err := event.Encode()
+	h.mutex.RLock()
+	instances := h.processors
+	metrics := h.metrics
+	routeInstances := route.processors
+	routeMetrics := route.metrics
+	h.mutex.RUnlock()
+
+	if route.name != "" {
+		event["route"] = route.name
+	}
+
+	if event = metrics.Process(instances, event); event == nil {
+		// Dropped by a processor
+		return
+	}
+
+	if event = routeMetrics.Process(routeInstances, event); event == nil {
+		return
+	}
+
+	if field := h.missingField(event); field != "" {
+		h.mutex.Lock()
+		h.rejected++
+		h.mutex.Unlock()
+
+		switch h.streamConfig.RequireFieldsAction {
+		case "tag":
+			event.AddTags("_missingfields")
+		case "dead letter":
+			h.writeDeadLetter("require fields", fmt.Sprintf("Missing required field %s", field), startOffset, event)
+			return
+		default:
+			log.Debug("Dropping line in %s at offset %d as it is missing required field %s", h.path, startOffset, field)
+			return
+		}
+	}
+
+	if tag := h.deadLetter.MatchTags(event["tags"]); tag != "" {
+		h.writeDeadLetter("processors", fmt.Sprintf("Event tagged with %s", tag), startOffset, event)
+		return
+	}
+
+	if h.sequence != nil {
+		event.SetPath("event.sequence", h.sequence.Next())
+	}
+
+	encoded, err := event.Encode(h.streamConfig.MessageField)
 	if err != nil {
 		// This should never happen - log and skip if it does
 		log.Warning("Skipping line in %s at offset %d due to encoding failure: %s", h.path, startOffset, err)
+		h.writeDeadLetter("harvester", fmt.Sprintf("Encoding failure: %s", err), startOffset, event)
 		return
 	}
 
@@ -464,6 +758,11 @@ EventLoop:
 		case <-h.stopChan:
 			break EventLoop
 		case h.output <- desc:
+			h.mutex.Lock()
+			if endOffset > h.shippedOffset {
+				h.shippedOffset = endOffset
+			}
+			h.mutex.Unlock()
 			break EventLoop
 		case <-h.meterTimer.C:
 			// TODO: Configurable meter timer? Same as statCheck?
@@ -471,7 +770,7 @@ EventLoop:
 
 			// Take measurements if enough time has elapsed since the last measurement
 			if duration := time.Since(h.lastMeasurement); duration >= time.Second {
-				if measureErr := h.takeMeasurements(duration, true); measureErr == errStopRequested {
+				if measureErr := h.takeMeasurements(duration, true); measureErr == errStopRequested || measureErr == errEndOfStream {
 					break EventLoop
 				}
 			}
@@ -479,6 +778,65 @@ EventLoop:
 	}
 }
 
+// isSkippedLine returns true if the text from the codec should be discarded
+// because it is empty or contains only whitespace and skip empty lines is
+// enabled. This happens after the codecs so blank lines within a multiline
+// event are preserved
+func (h *Harvester) isSkippedLine(text string) bool {
+	return h.streamConfig.SkipEmptyLines && strings.TrimSpace(text) == ""
+}
+
+// missingField returns the first of the required fields that is missing from
+// the event, or an empty string if all are present. When non-empty values are
+// required, fields that are null or empty are treated as missing
+func (h *Harvester) missingField(event core.Event) string {
+	for _, field := range h.streamConfig.RequireFields {
+		value, ok := event.GetPath(field)
+		if !ok {
+			return field
+		}
+
+		if !h.streamConfig.RequireNonEmpty {
+			continue
+		}
+
+		switch value := value.(type) {
+		case nil:
+			return field
+		case string:
+			if value == "" {
+				return field
+			}
+		case []interface{}:
+			if len(value) == 0 {
+				return field
+			}
+		case []string:
+			if len(value) == 0 {
+				return field
+			}
+		case map[string]interface{}:
+			if len(value) == 0 {
+				return field
+			}
+		}
+	}
+
+	return ""
+}
+
+// writeDeadLetter writes an event that will not be shipped to the dead letter
+// file, if one is configured
+func (h *Harvester) writeDeadLetter(source string, reason string, offset int64, event core.Event) {
+	if !h.deadLetter.Enabled() {
+		return
+	}
+
+	if err := h.deadLetter.Write(source, reason, h.path, offset, event); err != nil {
+		log.Warning("Failed to write dead letter for %s at offset %d: %s", h.path, offset, err)
+	}
+}
+
 func (h *Harvester) prepareHarvester() error {
 	// Streams don't need opening or checking
 	if h.isStream {
@@ -486,8 +844,12 @@ func (h *Harvester) prepareHarvester() error {
 	}
 
 	var err error
-	h.file, err = h.openFile(h.path)
+	h.file, err = h.open(h.path)
 	if err != nil {
+		if isTooManyOpenFiles(err) {
+			// Prospector will log this and hold back new harvesters
+			return ErrTooManyOpenFiles
+		}
 		log.Errorf("Failed opening %s: %s", h.path, err)
 		return err
 	}
@@ -507,12 +869,62 @@ func (h *Harvester) prepareHarvester() error {
 	// Store latest stat()
 	h.fileinfo = info
 
+	// The file may have been truncated since the offset was saved, in which case
+	// it is still the same file but everything in it is new
+	if info.Size() < h.offset {
+		log.Warning("File truncation detected, %s is now %d bytes which is before the resume offset of %d, starting from the beginning", h.path, info.Size(), h.offset)
+		h.mutex.Lock()
+		h.offset = 0
+		h.buildRoutes()
+		h.mutex.Unlock()
+	}
+
 	// TODO: Check error?
 	h.file.Seek(h.offset, os.SEEK_SET)
 
 	return nil
 }
 
+// handleLine passes a line read from the file to the codecs of each route and
+// updates the read statistics
+func (h *Harvester) handleLine(text string, length int) {
+	lineOffset := h.offset
+	h.offset += int64(length)
+
+	// Codec is last - it forwards harvester state for us such as offset for resume
+	for _, route := range h.routes {
+		route.event(lineOffset, h.offset, text)
+	}
+
+	h.lastReadTime = time.Now()
+	h.lineCount++
+	h.byteCount += uint64(length)
+	if h.limiter != nil {
+		h.limiter.Take()
+	}
+}
+
+// readRemaining returns the data left after the last line read, decoded, along
+// with its length in bytes. A trailing carriage return is removed when the
+// delimiter is a new line, as it would be for a complete line
+func (h *Harvester) readRemaining() (string, int) {
+	line := h.reader.ReadRemaining()
+	if len(line) == 0 {
+		return "", 0
+	}
+
+	length := len(line)
+	if h.streamConfig.LineDelimiter == "\n" {
+		line = bytes.TrimSuffix(line, h.carriageReturn)
+	}
+
+	text := h.encoding.Decode(line)
+	if h.offset == 0 {
+		text = strings.TrimPrefix(text, byteOrderMark)
+	}
+	return text, length
+}
+
 // readline reads a single line from the file, handling mixed line endings
 // and detecting where lines were split due to being too big for the buffer
 func (h *Harvester) readline() (string, int, error) {
@@ -522,11 +934,11 @@ func (h *Harvester) readline() (string, int, error) {
 
 	if line != nil {
 		if err == nil {
-			// Line will always end in '\n' if no error, but check also for CR
-			if len(line) > 1 && line[len(line)-2] == '\r' {
-				newline = 2
-			} else {
-				newline = 1
+			// Line will always end in the delimiter if no error, but check also for
+			// CR when the delimiter is a new line
+			newline = len(h.lineEnding)
+			if h.streamConfig.LineDelimiter == "\n" && bytes.HasSuffix(line[:len(line)-newline], h.carriageReturn) {
+				newline += len(h.carriageReturn)
 			}
 		} else if err == ErrLineTooLong {
 			h.split = true
@@ -535,8 +947,11 @@ func (h *Harvester) readline() (string, int, error) {
 
 		// Return the line along with the length including line ending
 		length := len(line)
-		// We use string() to copy the memory, which is a slice of the line buffer we need to re-use
-		return string(line[:length-newline]), length, err
+		text := h.encoding.Decode(line[:length-newline])
+		if h.offset == 0 {
+			text = strings.TrimPrefix(text, byteOrderMark)
+		}
+		return text, length, err
 	}
 
 	if err != nil {
@@ -556,6 +971,27 @@ func (h *Harvester) readline() (string, int, error) {
 	return "", 0, io.EOF
 }
 
+// Progress returns the offset, the last known size of the file and the time a
+// line was last read, as of the last measurement. The time is zero if no lines
+// have been read
+func (h *Harvester) Progress() (int64, int64, time.Time) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.lastOffset, h.lastSize, h.lastLine
+}
+
+// IdleSince returns the time a line was last read if the harvester is waiting
+// at the end of the file for more data, as of the last measurement. It returns
+// false if the harvester is not currently waiting
+func (h *Harvester) IdleSince() (time.Time, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if h.lastEOF == nil || *h.lastEOFOff < h.lastOffset {
+		return time.Time{}, false
+	}
+	return *h.lastEOF, true
+}
+
 // APIEncodable returns an admin API entry with harvester status
 func (h *Harvester) APIEncodable() admin.APIEncodable {
 	h.mutex.RLock()
@@ -564,6 +1000,7 @@ func (h *Harvester) APIEncodable() admin.APIEncodable {
 	apiEncodable.SetEntry("speed_lps", admin.APIFloat(h.lineSpeed))
 	apiEncodable.SetEntry("speed_bps", admin.APIFloat(h.byteSpeed))
 	apiEncodable.SetEntry("processed_lines", admin.APINumber(h.lineCount))
+	apiEncodable.SetEntry("rejected_lines", admin.APINumber(h.rejected))
 	apiEncodable.SetEntry("current_offset", admin.APINumber(h.lastOffset))
 	apiEncodable.SetEntry("stale_bytes", admin.APINumber(h.staleBytes))
 	apiEncodable.SetEntry("last_known_size", admin.APINumber(h.lastSize))
@@ -579,7 +1016,13 @@ func (h *Harvester) APIEncodable() admin.APIEncodable {
 	} else {
 		apiEncodable.SetEntry("last_eof_offset", admin.APINumber(*h.lastEOFOff))
 	}
-	if h.lastEOF == nil {
+	if h.limiter != nil {
+		apiEncodable.SetEntry("max_lps", admin.APINumber(h.streamConfig.MaxLineRate))
+		apiEncodable.SetEntry("throttled_seconds", admin.APIFloat(h.throttle.Seconds()))
+	}
+	if h.throttled {
+		apiEncodable.SetEntry("status", admin.APIString("throttled"))
+	} else if h.lastEOF == nil {
 		apiEncodable.SetEntry("status", admin.APIString("alive"))
 	} else {
 		apiEncodable.SetEntry("status", admin.APIString("idle"))
@@ -591,19 +1034,60 @@ func (h *Harvester) APIEncodable() admin.APIEncodable {
 	}
 
 	codecs := &admin.APIArray{}
-	i := 0
-	if encodable := h.codec.APIEncodable(); encodable != nil {
-		codecs.AddEntry(h.streamConfig.Codecs[0].Name, admin.NewAPIDataEntry(encodable))
-	}
-	for _, codec := range h.codecChain {
-		if encodable := codec.APIEncodable(); encodable != nil {
-			i++
-			codecs.AddEntry(h.streamConfig.Codecs[i].Name, admin.NewAPIDataEntry(encodable))
-		}
+	for _, route := range h.routes {
+		route.addCodecsAPI(codecs)
 	}
 	apiEncodable.SetEntry("codecs", codecs)
 
+	processorsAPI := &admin.APIArray{}
+	names := make(map[string]bool)
+	for i, processor := range h.processors {
+		if apiProcessor, ok := processor.(processors.APIProcessor); ok {
+			// Processors can repeat so qualify repeated names with their position
+			name := h.processorStubs[i].Name
+			if names[name] {
+				name = fmt.Sprintf("%s %d", name, i+1)
+			}
+			names[name] = true
+			processorsAPI.AddEntry(name, admin.NewAPIDataEntry(apiProcessor.APIEncodable()))
+		}
+	}
+	apiEncodable.SetEntry("processors", processorsAPI)
+
+	processingAPI := &admin.APIKeyValue{}
+	total := h.metrics.Total()
+	durationsAPI := &admin.APIKeyValue{}
+	addProcessorDurationsAPI(durationsAPI, "", h.processorStubs, h.metrics)
+	for _, route := range h.routes {
+		total += route.metrics.Total()
+		addProcessorDurationsAPI(durationsAPI, route.name, route.stubs, route.metrics)
+	}
+	processingAPI.SetEntry("processed_events", admin.APINumber(h.metrics.Events()))
+	processingAPI.SetEntry("processing_seconds", admin.APIFloat(total.Seconds()))
+	processingAPI.SetEntry("processor_seconds", durationsAPI)
+	apiEncodable.SetEntry("processing", processingAPI)
+
 	h.mutex.RUnlock()
 
 	return apiEncodable
 }
+
+// addProcessorDurationsAPI adds the time spent in each processor of a list to
+// the given entry, prefixing their names with the route name if there is one
+func addProcessorDurationsAPI(api *admin.APIKeyValue, routeName string, stubs []config.ProcessorStub, metrics *processors.Metrics) {
+	prefix := ""
+	if routeName != "" {
+		prefix = routeName + " "
+	}
+
+	names := make(map[string]bool)
+	for i := range stubs {
+		// Processors can repeat so qualify repeated names with their position
+		name := stubs[i].Name
+		if names[name] {
+			name = fmt.Sprintf("%s %d", name, i+1)
+		}
+		names[name] = true
+		api.SetEntry(prefix+name, admin.APIFloat(metrics.Duration(i).Seconds()))
+	}
+}
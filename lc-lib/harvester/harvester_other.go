@@ -19,9 +19,17 @@
 package harvester
 
 import (
+	"errors"
 	"os"
+	"syscall"
 )
 
 func (h *Harvester) openFile(path string) (*os.File, error) {
 	return os.Open(path)
 }
+
+// isTooManyOpenFiles returns true if the error is due to the process or system
+// limit on open files being reached
+func isTooManyOpenFiles(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
@@ -0,0 +1,723 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/codecs"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/processors"
+)
+
+func TestMissingField(t *testing.T) {
+	h := &Harvester{
+		streamConfig: &config.Stream{
+			RequireFields: []string{"app", "kubernetes.namespace"},
+		},
+	}
+
+	event := core.Event{"app": "", "kubernetes": map[string]interface{}{"namespace": "default"}}
+	if field := h.missingField(event); field != "" {
+		t.Errorf("Unexpected missing field: %s", field)
+	}
+
+	delete(event, "kubernetes")
+	if field := h.missingField(event); field != "kubernetes.namespace" {
+		t.Errorf("Missing nested field not detected: %s", field)
+	}
+}
+
+func TestMissingFieldNonEmpty(t *testing.T) {
+	h := &Harvester{
+		streamConfig: &config.Stream{
+			RequireFields:   []string{"app", "env"},
+			RequireNonEmpty: true,
+		},
+	}
+
+	if field := h.missingField(core.Event{"app": "web", "env": ""}); field != "env" {
+		t.Errorf("Empty field not detected: %s", field)
+	}
+
+	if field := h.missingField(core.Event{"app": nil, "env": "prod"}); field != "app" {
+		t.Errorf("Null field not detected: %s", field)
+	}
+
+	if field := h.missingField(core.Event{"app": "web", "env": "prod"}); field != "" {
+		t.Errorf("Unexpected missing field: %s", field)
+	}
+}
+
+func TestSkippedLine(t *testing.T) {
+	h := &Harvester{
+		streamConfig: &config.Stream{},
+	}
+
+	if h.isSkippedLine("") {
+		t.Error("Empty line skipped when skip empty lines is disabled")
+	}
+
+	h.streamConfig.SkipEmptyLines = true
+
+	for _, line := range []string{"", " ", "\t \r", "\u00a0"} {
+		if !h.isSkippedLine(line) {
+			t.Errorf("Blank line was not skipped: %q", line)
+		}
+	}
+
+	for _, line := range []string{"a", "  indented", "first\n\nthird"} {
+		if h.isSkippedLine(line) {
+			t.Errorf("Line was skipped: %q", line)
+		}
+	}
+}
+
+func TestSetProcessors(t *testing.T) {
+	factory, err := processors.NewFlattenProcessorFactory(config.NewConfig(), "", map[string]interface{}{}, "flatten", "message")
+	if err != nil {
+		t.Fatalf("Failed to create flatten processor: %s", err)
+	}
+
+	h := &Harvester{
+		streamConfig: &config.Stream{},
+	}
+
+	h.SetProcessors([]config.ProcessorStub{{Name: "flatten", Factory: factory}}, nil)
+	if len(h.processors) != 1 || len(h.processorStubs) != 1 || h.processorStubs[0].Name != "flatten" {
+		t.Fatalf("Processors were not replaced: %v", h.processorStubs)
+	}
+
+	event := h.processors[0].Process(core.Event{"a": map[string]interface{}{"b": "c"}})
+	if event["a.b"] != "c" {
+		t.Errorf("Replacement processor did not run: %v", event)
+	}
+
+	h.SetProcessors(nil, nil)
+	if len(h.processors) != 0 {
+		t.Errorf("Processors were not removed: %v", h.processors)
+	}
+}
+
+func TestEventTimestamp(t *testing.T) {
+	info, err := os.Stat(os.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to stat: %s", err)
+	}
+
+	h := &Harvester{
+		streamConfig: &config.Stream{TimestampSource: "read"},
+		fileinfo:     info,
+	}
+
+	if timestamp := h.eventTimestamp(); time.Since(timestamp) > time.Minute {
+		t.Errorf("Read timestamp is not the current time: %s", timestamp)
+	}
+
+	h.streamConfig.TimestampSource = "mtime"
+	if timestamp := h.eventTimestamp(); !timestamp.Equal(info.ModTime()) {
+		t.Errorf("Timestamp is not the modification time: %s != %s", timestamp, info.ModTime())
+	}
+
+	// Stdin has no modification time
+	h.fileinfo = nil
+	if timestamp := h.eventTimestamp(); time.Since(timestamp) > time.Minute {
+		t.Errorf("Stdin timestamp is not the current time: %s", timestamp)
+	}
+}
+
+func TestProcessingMetrics(t *testing.T) {
+	cfg := config.NewConfig()
+
+	plain, err := codecs.NewPlainCodecFactory(cfg, "", map[string]interface{}{}, "plain")
+	if err != nil {
+		t.Fatalf("Failed to create plain codec: %s", err)
+	}
+
+	drop, err := processors.NewDropProcessorFactory(cfg, "", map[string]interface{}{"patterns": []interface{}{"^drop"}}, "drop", "message")
+	if err != nil {
+		t.Fatalf("Failed to create drop processor: %s", err)
+	}
+
+	streamConfig := &config.Stream{
+		Codecs:       []config.CodecStub{{Name: "plain", Factory: plain}},
+		MessageField: "message",
+		Processors:   []config.ProcessorStub{{Name: "drop", Factory: drop}, {Name: "drop", Factory: drop}},
+	}
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(nil, cfg, streamConfig, nil, 0)
+	h.output = output
+
+	for i := 0; i < 10; i++ {
+		line := "keep"
+		if i%2 == 1 {
+			line = "drop"
+		}
+		h.routes[0].event(int64(i*5), int64(i*5+5), line)
+	}
+
+	if len(output) != 5 {
+		t.Errorf("Unexpected number of events: %d", len(output))
+	}
+
+	encoded, err := json.Marshal(h.APIEncodable())
+	if err != nil {
+		t.Fatalf("Failed to encode API: %s", err)
+	}
+
+	var api struct {
+		Processing struct {
+			ProcessedEvents   uint64             `json:"processed_events"`
+			ProcessingSeconds *float64           `json:"processing_seconds"`
+			ProcessorSeconds  map[string]float64 `json:"processor_seconds"`
+		} `json:"processing"`
+	}
+	if err := json.Unmarshal(encoded, &api); err != nil {
+		t.Fatalf("Failed to decode API: %s", err)
+	}
+
+	if api.Processing.ProcessedEvents != 10 {
+		t.Errorf("Unexpected processed events: %d", api.Processing.ProcessedEvents)
+	}
+	if api.Processing.ProcessingSeconds == nil {
+		t.Error("Processing time is missing")
+	}
+	if _, ok := api.Processing.ProcessorSeconds["drop"]; !ok {
+		t.Errorf("Processor time is missing: %s", encoded)
+	}
+	if _, ok := api.Processing.ProcessorSeconds["drop 2"]; !ok {
+		t.Errorf("Repeated processor time is missing: %s", encoded)
+	}
+
+	// Reloading the processors restarts the metrics
+	h.SetProcessors(nil, nil)
+	if events := h.metrics.Events(); events != 0 {
+		t.Errorf("Metrics were not reset: %d", events)
+	}
+}
+
+func TestMessageField(t *testing.T) {
+	cfg := config.NewConfig()
+
+	plain, err := codecs.NewPlainCodecFactory(cfg, "", map[string]interface{}{}, "plain")
+	if err != nil {
+		t.Fatalf("Failed to create plain codec: %s", err)
+	}
+
+	streamConfig := &config.Stream{
+		Codecs:       []config.CodecStub{{Name: "plain", Factory: plain}},
+		MessageField: "event.original",
+	}
+
+	output := make(chan *core.EventDescriptor, 1)
+	h := NewHarvester(nil, cfg, streamConfig, nil, 0)
+	h.output = output
+
+	h.routes[0].event(0, 5, "line")
+
+	var event map[string]interface{}
+	if err := json.Unmarshal((<-output).Event, &event); err != nil {
+		t.Fatalf("Failed to decode event: %s", err)
+	}
+
+	if _, ok := event["message"]; ok {
+		t.Errorf("Message field was set: %v", event)
+	}
+	if nested, ok := event["event"].(map[string]interface{}); !ok || nested["original"] != "line" {
+		t.Errorf("Line was not stored in the configured field: %v", event)
+	}
+}
+
+func TestOriginalField(t *testing.T) {
+	cfg := config.NewConfig()
+
+	plain, err := codecs.NewPlainCodecFactory(cfg, "", map[string]interface{}{}, "plain")
+	if err != nil {
+		t.Fatalf("Failed to create plain codec: %s", err)
+	}
+
+	syslog, err := processors.NewSyslogProcessorFactory(cfg, "", map[string]interface{}{}, "syslog", "message")
+	if err != nil {
+		t.Fatalf("Failed to create syslog processor: %s", err)
+	}
+
+	streamConfig := &config.Stream{
+		Codecs:        []config.CodecStub{{Name: "plain", Factory: plain}},
+		MessageField:  "message",
+		OriginalField: "event.original",
+		Processors:    []config.ProcessorStub{{Name: "syslog", Factory: syslog}},
+	}
+
+	output := make(chan *core.EventDescriptor, 1)
+	h := NewHarvester(nil, cfg, streamConfig, nil, 0)
+	h.output = output
+
+	line := "<34>Oct 11 22:14:15 mymachine su: 'su root' failed"
+	h.routes[0].event(0, int64(len(line)), line)
+
+	var event map[string]interface{}
+	if err := json.Unmarshal((<-output).Event, &event); err != nil {
+		t.Fatalf("Failed to decode event: %s", err)
+	}
+
+	if event["message"] != "'su root' failed" {
+		t.Errorf("Message was not parsed: %v", event)
+	}
+	if nested, ok := event["event"].(map[string]interface{}); !ok || nested["original"] != line {
+		t.Errorf("Original line was not preserved: %v", event)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	cfg := config.NewConfig()
+
+	plain, err := codecs.NewPlainCodecFactory(cfg, "", map[string]interface{}{}, "plain")
+	if err != nil {
+		t.Fatalf("Failed to create plain codec: %s", err)
+	}
+
+	filter, err := codecs.NewFilterCodecFactory(cfg, "", map[string]interface{}{"patterns": []interface{}{"keep"}}, "filter")
+	if err != nil {
+		t.Fatalf("Failed to create filter codec: %s", err)
+	}
+
+	streamConfig := &config.Stream{
+		MessageField: "message",
+		Routes: []config.Route{
+			{Name: "raw", Codecs: []config.CodecStub{{Name: "plain", Factory: plain}}},
+			{Name: "filtered", Codecs: []config.CodecStub{{Name: "filter", Factory: filter}}},
+		},
+	}
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(nil, cfg, streamConfig, nil, 0)
+	h.output = output
+
+	offset := int64(0)
+	for _, line := range []string{"a keep", "b", "c"} {
+		start := offset
+		offset += int64(len(line)) + 1
+		for _, route := range h.routes {
+			route.event(start, offset, line)
+		}
+	}
+
+	// Offsets are held back until every route has processed the line
+	expected := []struct {
+		route   string
+		message string
+		offset  int64
+	}{
+		{"raw", "a keep", 0},
+		{"filtered", "a keep", 7},
+		{"raw", "b", 7},
+		{"raw", "c", 7},
+	}
+
+	for _, entry := range expected {
+		var desc *core.EventDescriptor
+		select {
+		case desc = <-output:
+		default:
+			t.Fatalf("Missing event for route %s: %s", entry.route, entry.message)
+		}
+
+		var event map[string]interface{}
+		if err := json.Unmarshal(desc.Event, &event); err != nil {
+			t.Fatalf("Failed to decode event: %s", err)
+		}
+
+		if event["route"] != entry.route || event["message"] != entry.message || desc.Offset != entry.offset {
+			t.Errorf("Unexpected event at offset %d: %s", desc.Offset, desc.Event)
+		}
+	}
+
+	if len(output) != 0 {
+		t.Errorf("Unexpected additional events: %d", len(output))
+	}
+
+	if last := h.codecTeardown(); last != offset {
+		t.Errorf("Incorrect final offset: %d != %d", last, offset)
+	}
+}
+
+func TestSetRouteProcessors(t *testing.T) {
+	cfg := config.NewConfig()
+
+	plain, err := codecs.NewPlainCodecFactory(cfg, "", map[string]interface{}{}, "plain")
+	if err != nil {
+		t.Fatalf("Failed to create plain codec: %s", err)
+	}
+
+	drop, err := processors.NewDropProcessorFactory(cfg, "", map[string]interface{}{"patterns": []interface{}{"^drop"}}, "drop", "message")
+	if err != nil {
+		t.Fatalf("Failed to create drop processor: %s", err)
+	}
+
+	routes := []config.Route{
+		{Name: "first", Codecs: []config.CodecStub{{Name: "plain", Factory: plain}}},
+		{Name: "second", Codecs: []config.CodecStub{{Name: "plain", Factory: plain}}},
+	}
+
+	streamConfig := &config.Stream{
+		MessageField: "message",
+		Routes:       routes,
+	}
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(nil, cfg, streamConfig, nil, 0)
+	h.output = output
+
+	for _, route := range h.routes {
+		route.event(0, 5, "drop")
+	}
+	if len(output) != 2 {
+		t.Fatalf("Unexpected number of events before reload: %d", len(output))
+	}
+	<-output
+	<-output
+
+	reloaded := []config.Route{routes[0], routes[1]}
+	reloaded[1].Processors = []config.ProcessorStub{{Name: "drop", Factory: drop}}
+	h.SetProcessors(nil, reloaded)
+
+	for _, route := range h.routes {
+		route.event(5, 10, "drop")
+	}
+	if len(output) != 1 {
+		t.Fatalf("Route processors were not replaced: %d events", len(output))
+	}
+
+	var event map[string]interface{}
+	if err := json.Unmarshal((<-output).Event, &event); err != nil {
+		t.Fatalf("Failed to decode event: %s", err)
+	}
+	if event["route"] != "first" {
+		t.Errorf("Unexpected event: %v", event)
+	}
+
+	if len(h.routes[1].stubs) != 1 || h.routes[1].metrics.Events() != 1 {
+		t.Errorf("Route metrics were not replaced: %v", h.routes[1].stubs)
+	}
+
+	// Rebuilding the routes, such as after truncation, keeps the new processors
+	h.buildRoutes()
+	if len(h.routes[1].processors) != 1 {
+		t.Errorf("Route processors were lost when rebuilding: %v", h.routes[1].processors)
+	}
+}
+
+type testStream struct {
+	path     string
+	fileinfo os.FileInfo
+}
+
+func (s *testStream) Info() (string, os.FileInfo) {
+	return s.path, s.fileinfo
+}
+
+func TestTooManyOpenFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("EMFILE is not returned on Windows")
+	}
+
+	fileinfo, err := os.Stat(os.Args[0])
+	if err != nil {
+		t.Fatalf("Failed to stat test file: %s", err)
+	}
+
+	cfg := config.NewConfig()
+	plain, err := codecs.NewPlainCodecFactory(cfg, "", map[string]interface{}{}, "plain")
+	if err != nil {
+		t.Fatalf("Failed to create plain codec: %s", err)
+	}
+
+	streamConfig := &config.Stream{Codecs: []config.CodecStub{{Name: "plain", Factory: plain}}}
+	h := NewHarvester(&testStream{path: os.Args[0], fileinfo: fileinfo}, cfg, streamConfig, nil, 0)
+	h.open = func(path string) (*os.File, error) {
+		return nil, &os.PathError{Op: "open", Path: path, Err: syscall.EMFILE}
+	}
+
+	h.Start(make(chan *core.EventDescriptor))
+
+	select {
+	case status := <-h.OnFinish():
+		if status.Error != ErrTooManyOpenFiles {
+			t.Errorf("Unexpected finish error: %v", status.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Harvester did not finish")
+	}
+
+	// Stopping a finished harvester more than once is harmless
+	h.Stop()
+	h.Stop()
+}
+
+func TestMultilineFlushedAtDeadTime(t *testing.T) {
+	file, err := ioutil.TempFile("", "harvester")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(file.Name())
+
+	// A multiline event that is never followed by the start of another
+	data := "Exception in thread main\n  at Main.run\n"
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatalf("Failed to write temporary file: %s", err)
+	}
+	file.Close()
+
+	fileinfo, err := os.Stat(file.Name())
+	if err != nil {
+		t.Fatalf("Failed to stat temporary file: %s", err)
+	}
+
+	defer func(interval time.Duration) {
+		statCheckInterval = interval
+	}(statCheckInterval)
+	statCheckInterval = 100 * time.Millisecond
+
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	multiline, err := codecs.NewMultilineCodecFactory(cfg, "", map[string]interface{}{"patterns": []interface{}{"^\\s"}}, "multiline")
+	if err != nil {
+		t.Fatalf("Failed to create multiline codec: %s", err)
+	}
+
+	streamConfig := &config.Stream{}
+	streamConfig.InitDefaults()
+	streamConfig.MessageField = "message"
+	streamConfig.Codecs = []config.CodecStub{{Name: "multiline", Factory: multiline}}
+	streamConfig.DeadTime = 500 * time.Millisecond
+
+	output := make(chan *core.EventDescriptor, 1)
+	h := NewHarvester(&testStream{path: file.Name(), fileinfo: fileinfo}, cfg, streamConfig, nil, 0)
+	h.Start(output)
+	defer h.Stop()
+
+	select {
+	case desc := <-output:
+		var event map[string]interface{}
+		if err := json.Unmarshal(desc.Event, &event); err != nil {
+			t.Fatalf("Failed to decode event: %s", err)
+		}
+		if event["message"] != "Exception in thread main\n  at Main.run" || desc.Offset != int64(len(data)) {
+			t.Errorf("Unexpected event at offset %d: %s", desc.Offset, desc.Event)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Partial multiline event was not flushed")
+	}
+
+	select {
+	case status := <-h.OnFinish():
+		if status.Error != nil || status.LastEventOffset != int64(len(data)) {
+			t.Errorf("Unexpected finish status: %v at %d", status.Error, status.LastEventOffset)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Harvester did not stop at the dead time")
+	}
+}
+
+func TestStreamFinalLine(t *testing.T) {
+	file, err := ioutil.TempFile("", "harvester")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(file.Name())
+
+	// The final line has no line ending
+	data := "first\r\nsecond\r\nfinal"
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatalf("Failed to write temporary file: %s", err)
+	}
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		t.Fatalf("Failed to seek temporary file: %s", err)
+	}
+
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	plain, err := codecs.NewPlainCodecFactory(cfg, "", map[string]interface{}{}, "plain")
+	if err != nil {
+		t.Fatalf("Failed to create plain codec: %s", err)
+	}
+
+	streamConfig := &config.Stream{}
+	streamConfig.InitDefaults()
+	streamConfig.MessageField = "message"
+	streamConfig.Codecs = []config.CodecStub{{Name: "plain", Factory: plain}}
+
+	// A harvester without a stream reads stdin, which is replaced with the file
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(nil, cfg, streamConfig, nil, 0)
+	h.file = file
+	h.Start(output)
+	defer h.Stop()
+
+	select {
+	case status := <-h.OnFinish():
+		if status.Error != nil || status.LastEventOffset != int64(len(data)) {
+			t.Errorf("Unexpected finish status: %v at %d", status.Error, status.LastEventOffset)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Harvester did not stop at the end of the stream")
+	}
+
+	for _, expected := range []string{"first", "second", "final"} {
+		select {
+		case desc := <-output:
+			var event map[string]interface{}
+			if err := json.Unmarshal(desc.Event, &event); err != nil {
+				t.Fatalf("Failed to decode event: %s", err)
+			}
+			if event["message"] != expected {
+				t.Errorf("Unexpected event: %s", desc.Event)
+			}
+		default:
+			t.Fatalf("Missing event: %s", expected)
+		}
+	}
+}
+
+func TestStreamSkippedFinalLines(t *testing.T) {
+	file, err := ioutil.TempFile("", "harvester")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(file.Name())
+
+	// The final lines are skipped so no event carries the end of the stream
+	data := "first\nsecond\n\n  \n"
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatalf("Failed to write temporary file: %s", err)
+	}
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		t.Fatalf("Failed to seek temporary file: %s", err)
+	}
+
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	plain, err := codecs.NewPlainCodecFactory(cfg, "", map[string]interface{}{}, "plain")
+	if err != nil {
+		t.Fatalf("Failed to create plain codec: %s", err)
+	}
+
+	streamConfig := &config.Stream{}
+	streamConfig.InitDefaults()
+	streamConfig.MessageField = "message"
+	streamConfig.SkipEmptyLines = true
+	streamConfig.Codecs = []config.CodecStub{{Name: "plain", Factory: plain}}
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := NewHarvester(nil, cfg, streamConfig, nil, 0)
+	h.file = file
+	h.Start(output)
+	defer h.Stop()
+
+	select {
+	case status := <-h.OnFinish():
+		if status.Error != nil || status.LastEventOffset != int64(len(data)) {
+			t.Errorf("Unexpected finish status: %v at %d", status.Error, status.LastEventOffset)
+		}
+		if status.LastShippedOffset != int64(len("first\nsecond\n")) {
+			t.Errorf("Incorrect last shipped offset: %d", status.LastShippedOffset)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Harvester did not stop at the end of the stream")
+	}
+
+	if len(output) != 2 {
+		t.Errorf("Incorrect number of events: %d != 2", len(output))
+	}
+}
+
+func TestTruncatedBeforeResume(t *testing.T) {
+	file, err := ioutil.TempFile("", "harvester")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(file.Name())
+
+	// Record the offset after the original contents, then truncate in place and
+	// write new contents that are shorter
+	original := "first line\nsecond line\n"
+	if _, err := file.WriteString(original); err != nil {
+		t.Fatalf("Failed to write temporary file: %s", err)
+	}
+	offset := int64(len(original))
+
+	if err := file.Truncate(0); err != nil {
+		t.Fatalf("Failed to truncate temporary file: %s", err)
+	}
+	if _, err := file.WriteAt([]byte("new\n"), 0); err != nil {
+		t.Fatalf("Failed to write temporary file: %s", err)
+	}
+	file.Close()
+
+	fileinfo, err := os.Stat(file.Name())
+	if err != nil {
+		t.Fatalf("Failed to stat temporary file: %s", err)
+	}
+
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	plain, err := codecs.NewPlainCodecFactory(cfg, "", map[string]interface{}{}, "plain")
+	if err != nil {
+		t.Fatalf("Failed to create plain codec: %s", err)
+	}
+
+	streamConfig := &config.Stream{}
+	streamConfig.InitDefaults()
+	streamConfig.MessageField = "message"
+	streamConfig.Codecs = []config.CodecStub{{Name: "plain", Factory: plain}}
+
+	output := make(chan *core.EventDescriptor, 1)
+	h := NewHarvester(&testStream{path: file.Name(), fileinfo: fileinfo}, cfg, streamConfig, nil, offset)
+	h.StopAtEOF()
+	h.Start(output)
+	defer h.Stop()
+
+	select {
+	case desc := <-output:
+		var event map[string]interface{}
+		if err := json.Unmarshal(desc.Event, &event); err != nil {
+			t.Fatalf("Failed to decode event: %s", err)
+		}
+		if event["message"] != "new" || desc.Offset != 4 {
+			t.Errorf("Unexpected event at offset %d: %s", desc.Offset, desc.Event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Harvester did not resume from the beginning of the truncated file")
+	}
+
+	select {
+	case status := <-h.OnFinish():
+		if status.Error != nil || status.LastEventOffset != 4 {
+			t.Errorf("Unexpected finish status: %v at %d", status.Error, status.LastEventOffset)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Harvester did not stop at the end of the file")
+	}
+}
@@ -17,10 +17,14 @@
 package harvester
 
 import (
+	"errors"
 	"os"
 	"syscall"
 )
 
+// errorTooManyOpenFiles is ERROR_TOO_MANY_OPEN_FILES
+const errorTooManyOpenFiles syscall.Errno = 4
+
 func (h *Harvester) openFile(path string) (*os.File, error) {
 	// We will call CreateFile directly so we can pass in FILE_SHARE_DELETE
 	// This ensures that a program can still rotate the file even though we have it open
@@ -40,3 +44,9 @@ func (h *Harvester) openFile(path string) (*os.File, error) {
 
 	return os.NewFile(uintptr(handle), path), nil
 }
+
+// isTooManyOpenFiles returns true if the error is due to the process limit on
+// open handles being reached
+func isTooManyOpenFiles(err error) bool {
+	return errors.Is(err, errorTooManyOpenFiles)
+}
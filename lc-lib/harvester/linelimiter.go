@@ -0,0 +1,63 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package harvester
+
+import (
+	"time"
+)
+
+// lineLimiter is a token bucket that limits the rate at which lines are read
+// by a harvester. The bucket holds up to one second's worth of lines so short
+// bursts are permitted after the harvester has been idle
+type lineLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newLineLimiter creates a new lineLimiter allowing the given number of lines
+// per second
+func newLineLimiter(rate int64) *lineLimiter {
+	return &lineLimiter{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// Delay refills the bucket and returns how long to wait before another line
+// can be read, or zero if one can be read now
+func (l *lineLimiter) Delay(now time.Time) time.Duration {
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Take removes a line from the bucket
+func (l *lineLimiter) Take() {
+	l.tokens--
+}
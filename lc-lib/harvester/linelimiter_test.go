@@ -0,0 +1,66 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLineLimiter(t *testing.T) {
+	limiter := newLineLimiter(10)
+	now := limiter.last
+
+	// A full second's worth should be available immediately
+	for i := 0; i < 10; i++ {
+		if delay := limiter.Delay(now); delay != 0 {
+			t.Fatalf("Unexpected delay for line %d: %s", i, delay)
+		}
+		limiter.Take()
+	}
+
+	if delay := limiter.Delay(now); delay != 100*time.Millisecond {
+		t.Errorf("Unexpected delay when empty: %s", delay)
+	}
+
+	now = now.Add(50 * time.Millisecond)
+	if delay := limiter.Delay(now); delay != 50*time.Millisecond {
+		t.Errorf("Unexpected delay after partial refill: %s", delay)
+	}
+
+	now = now.Add(50 * time.Millisecond)
+	if delay := limiter.Delay(now); delay != 0 {
+		t.Errorf("Unexpected delay after refill: %s", delay)
+	}
+}
+
+func TestLineLimiterBurst(t *testing.T) {
+	limiter := newLineLimiter(5)
+
+	// Idle time must not accumulate more than a second's worth
+	now := limiter.last.Add(time.Minute)
+	for i := 0; i < 5; i++ {
+		if delay := limiter.Delay(now); delay != 0 {
+			t.Fatalf("Unexpected delay for line %d: %s", i, delay)
+		}
+		limiter.Take()
+	}
+
+	if delay := limiter.Delay(now); delay == 0 {
+		t.Error("Burst exceeded the line rate")
+	}
+}
@@ -30,31 +30,46 @@ var (
 
 // LineReader is a read interface that tails and returns lines
 type LineReader struct {
-	rd       io.Reader
-	buf      []byte
-	overflow [][]byte
-	size     int
-	maxLine  int
-	curMax   int
-	start    int
-	end      int
-	err      error
+	rd        io.Reader
+	delimiter []byte
+	buf       []byte
+	overflow  [][]byte
+	size      int
+	maxLine   int
+	curMax    int
+	start     int
+	end       int
+	err       error
 }
 
 // NewLineReader creates a new line reader structure reading from the given
-// io.Reader with the given size buffer and the given maximum line size
+// io.Reader with the given size buffer and the given maximum line size, with
+// lines terminated by the given delimiter
 // If a line exceeds the maxmium line size it will be cut into segments, see
 // ReadSlice. If the maximum line size is greater than the given buffer size,
 // lines that are larger than the buffer will overflow into additional
 // memory allocations. Therefore, the buffer size should be sized to handle the
 // most common line lengths.
-func NewLineReader(rd io.Reader, size int, maxLine int) *LineReader {
+func NewLineReader(rd io.Reader, size int, maxLine int, delimiter byte) *LineReader {
+	return NewEncodedLineReader(rd, size, maxLine, []byte{delimiter})
+}
+
+// NewEncodedLineReader creates a new line reader as NewLineReader does, but
+// with lines terminated by an encoded delimiter that may be wider than a single
+// byte. The delimiter is only matched at offsets that are a multiple of its
+// length, and the buffer size and maximum line size are rounded down to a
+// multiple of its length, so that lines are always whole code units
+func NewEncodedLineReader(rd io.Reader, size int, maxLine int, delimiter []byte) *LineReader {
+	size -= size % len(delimiter)
+	maxLine -= maxLine % len(delimiter)
+
 	lr := &LineReader{
-		rd:      rd,
-		buf:     make([]byte, size),
-		size:    size,
-		maxLine: maxLine,
-		curMax:  maxLine,
+		rd:        rd,
+		delimiter: delimiter,
+		buf:       make([]byte, size),
+		size:      size,
+		maxLine:   maxLine,
+		curMax:    maxLine,
 	}
 
 	return lr
@@ -73,6 +88,23 @@ func (lr *LineReader) BufferedLen() int {
 	return lr.end - lr.start
 }
 
+// ReadRemaining returns the data buffered after the last line returned,
+// including any of it that overflowed the buffer, and empties the buffer. It is
+// used at the end of a stream whose final line has no delimiter
+func (lr *LineReader) ReadRemaining() []byte {
+	line := lr.buf[lr.start:lr.end]
+	lr.start, lr.end = 0, 0
+
+	if lr.overflow != nil {
+		lr.overflow = append(lr.overflow, line)
+		line = bytes.Join(lr.overflow, []byte{})
+		lr.overflow = nil
+		lr.curMax = lr.maxLine
+	}
+
+	return line
+}
+
 // ReadSlice returns a line as a byte slice
 // Returns ErrLineTooLong if the line was cut short because it was longer than
 // the maximum line length allowed. Subsequent returned lines will be a
@@ -86,9 +118,9 @@ func (lr *LineReader) ReadSlice() ([]byte, error) {
 	}
 
 	for {
-		if n := bytes.IndexByte(lr.buf[lr.start:lr.end], '\n'); n >= 0 && n < lr.curMax {
-			line = lr.buf[lr.start : lr.start+n+1]
-			lr.start += n + 1
+		if n := lr.indexDelimiter(lr.buf[lr.start:lr.end]); n >= 0 && n < lr.curMax {
+			line = lr.buf[lr.start : lr.start+n+len(lr.delimiter)]
+			lr.start += n + len(lr.delimiter)
 			err = nil
 			break
 		}
@@ -126,6 +158,22 @@ func (lr *LineReader) ReadSlice() ([]byte, error) {
 	return line, err
 }
 
+// indexDelimiter returns the offset of the first delimiter in the given buffer
+// that is aligned to the width of the delimiter, or -1 if there is none
+func (lr *LineReader) indexDelimiter(buf []byte) int {
+	if len(lr.delimiter) == 1 {
+		return bytes.IndexByte(buf, lr.delimiter[0])
+	}
+
+	for n := 0; n+len(lr.delimiter) <= len(buf); n += len(lr.delimiter) {
+		if bytes.Equal(buf[n:n+len(lr.delimiter)], lr.delimiter) {
+			return n
+		}
+	}
+
+	return -1
+}
+
 // fill reads from the reader and fills the buffer, shifting all unread bytes to
 // the front of the buffer to make room
 func (lr *LineReader) fill() error {
@@ -48,7 +48,7 @@ func TestLineRead(t *testing.T) {
 	data := bytes.NewBufferString("12345678901234567890\n12345678901234567890\n")
 
 	// New line read with 100 bytes, enough for the above
-	reader := NewLineReader(data, 100, 100)
+	reader := NewLineReader(data, 100, 100, '\n')
 
 	checkLine(t, reader, []byte("12345678901234567890\n"), nil)
 	checkLine(t, reader, []byte("12345678901234567890\n"), nil)
@@ -60,7 +60,7 @@ func TestLineReadEmpty(t *testing.T) {
 	data := bytes.NewBufferString("\n12345678901234567890\n")
 
 	// New line read with 100 bytes, enough for the above
-	reader := NewLineReader(data, 100, 100)
+	reader := NewLineReader(data, 100, 100, '\n')
 
 	checkLine(t, reader, []byte("\n"), nil)
 	checkLine(t, reader, []byte("12345678901234567890\n"), nil)
@@ -72,7 +72,7 @@ func TestLineReadIncomplete(t *testing.T) {
 	data := bytes.NewBufferString("\n12345678901234567890\n123456")
 
 	// New line read with 100 bytes, enough for the above
-	reader := NewLineReader(data, 100, 100)
+	reader := NewLineReader(data, 100, 100, '\n')
 
 	checkLine(t, reader, []byte("\n"), nil)
 	checkLine(t, reader, []byte("12345678901234567890\n"), nil)
@@ -80,11 +80,30 @@ func TestLineReadIncomplete(t *testing.T) {
 	checkBufferedLen(t, reader, 6)
 }
 
+func TestLineReadRemaining(t *testing.T) {
+	data := bytes.NewBufferString("12345678901234567890\n123456789012345678901234567890")
+
+	// New line read with 21 bytes buffer so the remaining data overflows
+	reader := NewLineReader(data, 21, 100, '\n')
+
+	checkLine(t, reader, []byte("12345678901234567890\n"), nil)
+	checkLine(t, reader, nil, io.EOF)
+
+	if remaining := reader.ReadRemaining(); !bytes.Equal(remaining, []byte("123456789012345678901234567890")) {
+		t.Errorf("Remaining data incorrect: [% X]", remaining)
+	}
+	checkBufferedLen(t, reader, 0)
+
+	if remaining := reader.ReadRemaining(); len(remaining) != 0 {
+		t.Errorf("Remaining data was returned twice: [% X]", remaining)
+	}
+}
+
 func TestLineReadOverflow(t *testing.T) {
 	data := bytes.NewBufferString("12345678901234567890\n123456789012345678901234567890\n12345678901234567890\n")
 
 	// New line read with 21 bytes buffer but 100 max line to trigger overflow
-	reader := NewLineReader(data, 21, 100)
+	reader := NewLineReader(data, 21, 100, '\n')
 
 	checkLine(t, reader, []byte("12345678901234567890\n"), nil)
 	checkLine(t, reader, []byte("123456789012345678901234567890\n"), nil)
@@ -100,7 +119,7 @@ func TestLineReadOverflowTooLong(t *testing.T) {
 	// This test regression tests a really old bug where when a too long line
 	// was received that overflowed in a way specific to these values it would
 	// corrupt the entry
-	reader := NewLineReader(data, 10, 21)
+	reader := NewLineReader(data, 10, 21, '\n')
 
 	checkLine(t, reader, []byte("12345678901234567890\n"), nil)
 	checkLine(t, reader, []byte("123456789012345678901"), ErrLineTooLong)
@@ -114,7 +133,7 @@ func TestLineReadTooLong(t *testing.T) {
 	data := bytes.NewBufferString("12345678901234567890\n123456789012345678901234567890\n12345678901234567890\n")
 
 	// New line read with ample buffer and 21 max line length
-	reader := NewLineReader(data, 100, 21)
+	reader := NewLineReader(data, 100, 21, '\n')
 
 	checkLine(t, reader, []byte("12345678901234567890\n"), nil)
 	checkLine(t, reader, []byte("123456789012345678901"), ErrLineTooLong)
@@ -123,3 +142,39 @@ func TestLineReadTooLong(t *testing.T) {
 	checkLine(t, reader, nil, io.EOF)
 	checkBufferedLen(t, reader, 0)
 }
+
+func TestLineReadDelimiter(t *testing.T) {
+	data := bytes.NewBufferString("12345\x0012345\n67890\x00")
+
+	// Custom delimiter so the new line is part of the line
+	reader := NewLineReader(data, 100, 100, 0)
+
+	checkLine(t, reader, []byte("12345\x00"), nil)
+	checkLine(t, reader, []byte("12345\n67890\x00"), nil)
+	checkLine(t, reader, nil, io.EOF)
+	checkBufferedLen(t, reader, 0)
+}
+
+func TestLineReadEncodedDelimiter(t *testing.T) {
+	// UTF-16LE where "\n\x00" also appears misaligned within U+0A41 U+0100
+	data := bytes.NewBufferString("a\x00\n\x00\x41\x0a\x00\x01\n\x00b\x00")
+
+	reader := NewEncodedLineReader(data, 100, 100, []byte("\n\x00"))
+
+	checkLine(t, reader, []byte("a\x00\n\x00"), nil)
+	checkLine(t, reader, []byte("\x41\x0a\x00\x01\n\x00"), nil)
+	checkLine(t, reader, nil, io.EOF)
+	checkBufferedLen(t, reader, 2)
+}
+
+func TestLineReadEncodedTooLong(t *testing.T) {
+	data := bytes.NewBufferString("a\x00b\x00c\x00\n\x00")
+
+	// Maximum line length is rounded down so lines are cut at a code unit
+	reader := NewEncodedLineReader(data, 100, 5, []byte("\n\x00"))
+
+	checkLine(t, reader, []byte("a\x00b\x00"), ErrLineTooLong)
+	checkLine(t, reader, []byte("c\x00\n\x00"), nil)
+	checkLine(t, reader, nil, io.EOF)
+	checkBufferedLen(t, reader, 0)
+}
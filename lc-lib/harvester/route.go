@@ -0,0 +1,140 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package harvester
+
+import (
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/codecs"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/processors"
+)
+
+// routeCallbackFunc receives the events from the final codec of a route
+type routeCallbackFunc func(*harvesterRoute, int64, int64, string, map[string]interface{})
+
+// harvesterRoute is a single route that every line read by a harvester is
+// passed through, with its own codec chain and processors. Streams without
+// routes have a single unnamed route using the stream's codecs
+type harvesterRoute struct {
+	name       string
+	codecStubs []config.CodecStub
+	codec      codecs.Codec
+	codecChain []codecs.Codec
+
+	// The processors of the route, protected by the harvester mutex as they
+	// are replaced when the processors are reloaded
+	processors []processors.Processor
+	metrics    *processors.Metrics
+	stubs      []config.ProcessorStub
+
+	// The end offset of the last event from the codecs, protected by the
+	// harvester mutex
+	progress int64
+}
+
+// newHarvesterRoute creates a route and its codec chain, starting at the given
+// offset
+func newHarvesterRoute(name string, codecStubs []config.CodecStub, processorStubs []config.ProcessorStub, callback routeCallbackFunc, offset int64) *harvesterRoute {
+	ret := &harvesterRoute{
+		name:       name,
+		codecStubs: codecStubs,
+		codecChain: make([]codecs.Codec, len(codecStubs)-1),
+		processors: newProcessors(processorStubs),
+		metrics:    processors.NewMetrics(len(processorStubs)),
+		stubs:      processorStubs,
+		progress:   offset,
+	}
+
+	// Only the final codec can add fields to the events
+	last := len(codecStubs) - 1
+	entry := codecs.NewFieldsCodec(codecStubs[last].Factory, func(startOffset int64, endOffset int64, text string, fields map[string]interface{}) {
+		callback(ret, startOffset, endOffset, text, fields)
+	}, offset)
+	if last != 0 {
+		ret.codecChain[last-1] = entry
+	}
+
+	for i := last - 1; i >= 0; i-- {
+		entry = codecs.NewCodec(codecStubs[i].Factory, entry.Event, offset)
+		if i != 0 {
+			ret.codecChain[i-1] = entry
+		}
+	}
+	ret.codec = entry
+
+	return ret
+}
+
+// event passes a line to the first codec of the route
+func (r *harvesterRoute) event(startOffset int64, endOffset int64, text string) {
+	r.codec.Event(startOffset, endOffset, text)
+}
+
+// teardown shuts down all codecs in the order they are used and returns the
+// offset the route has completely processed up to
+func (r *harvesterRoute) teardown() int64 {
+	for _, codec := range r.codecChain {
+		codec.Teardown()
+	}
+
+	return r.codec.Teardown()
+}
+
+// flush has the codecs emit any partial events they hold, in the order they
+// are used so that events flushed by one codec reach the next before it is
+// flushed
+func (r *harvesterRoute) flush() {
+	if flusher, ok := r.codec.(codecs.Flusher); ok {
+		flusher.Flush()
+	}
+	for _, codec := range r.codecChain {
+		if flusher, ok := codec.(codecs.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
+
+// reset resets the first codec so it can be reused after truncation
+func (r *harvesterRoute) reset() {
+	r.codec.Reset()
+}
+
+// meter requests accounting from the codecs
+func (r *harvesterRoute) meter() {
+	r.codec.Meter()
+	for _, codec := range r.codecChain {
+		codec.Meter()
+	}
+}
+
+// addCodecsAPI adds the status of the codecs to the given array, prefixing
+// their names with the route name if the route is named
+func (r *harvesterRoute) addCodecsAPI(api *admin.APIArray) {
+	prefix := ""
+	if r.name != "" {
+		prefix = r.name + " "
+	}
+
+	if encodable := r.codec.APIEncodable(); encodable != nil {
+		api.AddEntry(prefix+r.codecStubs[0].Name, admin.NewAPIDataEntry(encodable))
+	}
+	for i, codec := range r.codecChain {
+		if encodable := codec.APIEncodable(); encodable != nil {
+			api.AddEntry(prefix+r.codecStubs[i+1].Name, admin.NewAPIDataEntry(encodable))
+		}
+	}
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hostmeta
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// cloudMaxResponse limits how much of a metadata response is read, as all
+	// of the values requested are small
+	cloudMaxResponse = 64 * 1024
+)
+
+// cloudProviders lists the metadata service of each supported provider
+var cloudProviders = []cloudProvider{
+	{lookup: lookupAWS, url: "http://169.254.169.254"},
+	{lookup: lookupAzure, url: "http://169.254.169.254"},
+	{lookup: lookupGCP, url: "http://metadata.google.internal"},
+}
+
+// cloudInstance holds the details of the cloud instance the host is running on
+type cloudInstance struct {
+	provider         string
+	instanceID       string
+	region           string
+	availabilityZone string
+}
+
+// fields returns the instance details as event fields
+func (i *cloudInstance) fields() map[string]interface{} {
+	ret := map[string]interface{}{
+		"provider":    i.provider,
+		"instance_id": i.instanceID,
+	}
+	if i.region != "" {
+		ret["region"] = i.region
+	}
+	if i.availabilityZone != "" {
+		ret["availability_zone"] = i.availabilityZone
+	}
+	return ret
+}
+
+// cloudLookup queries a single provider's metadata service at the given base
+// URL, abandoning any request when the context is done
+type cloudLookup func(ctx context.Context, client *http.Client, baseURL string) (*cloudInstance, error)
+
+// cloudProvider is a provider's lookup along with its metadata service URL
+type cloudProvider struct {
+	lookup cloudLookup
+	url    string
+}
+
+// lookupCloud queries the metadata service of each of the given providers in
+// parallel, returning the first to respond. Off cloud every lookup will fail
+// or time out. Lookups still outstanding when one succeeds or the timeout
+// expires are cancelled and waited for, so that none outlive the call and the
+// lookup never delays collection for much longer than the timeout
+func lookupCloud(providers []cloudProvider, timeout time.Duration) (*cloudInstance, error) {
+	client := &http.Client{
+		// Never follow redirects away from the metadata service
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	var wait sync.WaitGroup
+	defer wait.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	results := make(chan *cloudInstance, len(providers))
+	for _, provider := range providers {
+		wait.Add(1)
+		go func(provider cloudProvider) {
+			defer wait.Done()
+			instance, err := provider.lookup(ctx, client, provider.url)
+			if err != nil {
+				log.Debug("Cloud metadata lookup failed: %s", err)
+			}
+			results <- instance
+		}(provider)
+	}
+
+	for range providers {
+		select {
+		case instance := <-results:
+			if instance != nil {
+				return instance, nil
+			}
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no cloud metadata service responded within %s", timeout)
+		}
+	}
+
+	return nil, errors.New("no cloud metadata service responded")
+}
+
+// cloudGet performs a request against a metadata service and returns the body
+func cloudGet(ctx context.Context, client *http.Client, method string, url string, headers map[string]string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, cloudMaxResponse))
+	if err != nil {
+		return "", err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned status %d", url, response.StatusCode)
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
+
+// lookupAWS queries the EC2 instance metadata service, using a session token
+// if IMDSv2 is available and falling back to IMDSv1 if it is not
+func lookupAWS(ctx context.Context, client *http.Client, baseURL string) (*cloudInstance, error) {
+	headers := map[string]string{}
+
+	token, err := cloudGet(ctx, client, "PUT", baseURL+"/latest/api/token", map[string]string{
+		"X-aws-ec2-metadata-token-ttl-seconds": "60",
+	})
+	if err == nil {
+		headers["X-aws-ec2-metadata-token"] = token
+	}
+
+	instance := &cloudInstance{provider: "aws"}
+	if instance.instanceID, err = cloudGet(ctx, client, "GET", baseURL+"/latest/meta-data/instance-id", headers); err != nil {
+		return nil, err
+	}
+
+	// Region and zone are optional extras
+	instance.availabilityZone, _ = cloudGet(ctx, client, "GET", baseURL+"/latest/meta-data/placement/availability-zone", headers)
+	instance.region, _ = cloudGet(ctx, client, "GET", baseURL+"/latest/meta-data/placement/region", headers)
+
+	return instance, nil
+}
+
+// lookupAzure queries the Azure instance metadata service
+func lookupAzure(ctx context.Context, client *http.Client, baseURL string) (*cloudInstance, error) {
+	body, err := cloudGet(ctx, client, "GET", baseURL+"/metadata/instance/compute?api-version=2021-02-01&format=json", map[string]string{
+		"Metadata": "true",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var compute struct {
+		VMID     string `json:"vmId"`
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+	}
+	if err = json.Unmarshal([]byte(body), &compute); err != nil {
+		return nil, fmt.Errorf("invalid Azure metadata response: %s", err)
+	}
+
+	if compute.VMID == "" {
+		return nil, errors.New("Azure metadata response did not contain a VM ID")
+	}
+
+	return &cloudInstance{
+		provider:         "azure",
+		instanceID:       compute.VMID,
+		region:           compute.Location,
+		availabilityZone: compute.Zone,
+	}, nil
+}
+
+// lookupGCP queries the Google Compute Engine metadata server
+func lookupGCP(ctx context.Context, client *http.Client, baseURL string) (*cloudInstance, error) {
+	headers := map[string]string{"Metadata-Flavor": "Google"}
+
+	instanceID, err := cloudGet(ctx, client, "GET", baseURL+"/computeMetadata/v1/instance/id", headers)
+	if err != nil {
+		return nil, err
+	}
+
+	instance := &cloudInstance{provider: "gcp", instanceID: instanceID}
+
+	// The zone is returned as projects/<project>/zones/<zone> and the region is
+	// the zone without its final component, such as us-central1 for
+	// us-central1-a
+	if zone, err := cloudGet(ctx, client, "GET", baseURL+"/computeMetadata/v1/instance/zone", headers); err == nil {
+		instance.availabilityZone = zone[strings.LastIndex(zone, "/")+1:]
+		if dash := strings.LastIndex(instance.availabilityZone, "-"); dash > 0 {
+			instance.region = instance.availabilityZone[:dash]
+		}
+	}
+
+	return instance, nil
+}
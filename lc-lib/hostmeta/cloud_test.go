@@ -0,0 +1,188 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hostmeta
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testCloudProviders returns the providers with each metadata service at the
+// given server, or at a closed server if nil so that lookups fail
+func testCloudProviders(aws, azure, gcp *httptest.Server) []cloudProvider {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+
+	urlFor := func(server *httptest.Server) string {
+		if server == nil {
+			return closedURL
+		}
+		return server.URL
+	}
+
+	return []cloudProvider{
+		{lookup: lookupAWS, url: urlFor(aws)},
+		{lookup: lookupAzure, url: urlFor(azure)},
+		{lookup: lookupGCP, url: urlFor(gcp)},
+	}
+}
+
+func checkInstance(t *testing.T, instance *cloudInstance, err error, expected cloudInstance) {
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if *instance != expected {
+		t.Errorf("Incorrect instance: %+v != expected %+v", *instance, expected)
+	}
+}
+
+func TestCloudAWS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			if r.Method != "PUT" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			fmt.Fprint(w, "token")
+			return
+		}
+
+		if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/latest/meta-data/instance-id":
+			fmt.Fprint(w, "i-0123456789abcdef0")
+		case "/latest/meta-data/placement/availability-zone":
+			fmt.Fprint(w, "eu-west-2a")
+		case "/latest/meta-data/placement/region":
+			fmt.Fprint(w, "eu-west-2")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	providers := testCloudProviders(server, nil, nil)
+
+	instance, err := lookupCloud(providers, time.Second)
+	checkInstance(t, instance, err, cloudInstance{
+		provider:         "aws",
+		instanceID:       "i-0123456789abcdef0",
+		region:           "eu-west-2",
+		availabilityZone: "eu-west-2a",
+	})
+}
+
+func TestCloudAWSWithoutToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/meta-data/instance-id" {
+			fmt.Fprint(w, "i-0123456789abcdef0")
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	providers := testCloudProviders(server, nil, nil)
+
+	instance, err := lookupCloud(providers, time.Second)
+	checkInstance(t, instance, err, cloudInstance{
+		provider:   "aws",
+		instanceID: "i-0123456789abcdef0",
+	})
+}
+
+func TestCloudAzure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metadata/instance/compute" || r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"location":"westeurope","vmId":"02aab8a4-74ef-476e-8182-f6d2ba4166a6","zone":"1"}`)
+	}))
+	defer server.Close()
+	providers := testCloudProviders(nil, server, nil)
+
+	instance, err := lookupCloud(providers, time.Second)
+	checkInstance(t, instance, err, cloudInstance{
+		provider:         "azure",
+		instanceID:       "02aab8a4-74ef-476e-8182-f6d2ba4166a6",
+		region:           "westeurope",
+		availabilityZone: "1",
+	})
+}
+
+func TestCloudGCP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/id":
+			fmt.Fprint(w, "4520031799277581759")
+		case "/computeMetadata/v1/instance/zone":
+			fmt.Fprint(w, "projects/123456789/zones/us-central1-a")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	providers := testCloudProviders(nil, nil, server)
+
+	instance, err := lookupCloud(providers, time.Second)
+	checkInstance(t, instance, err, cloudInstance{
+		provider:         "gcp",
+		instanceID:       "4520031799277581759",
+		region:           "us-central1",
+		availabilityZone: "us-central1-a",
+	})
+}
+
+func TestCloudUnavailable(t *testing.T) {
+	providers := testCloudProviders(nil, nil, nil)
+
+	if instance, err := lookupCloud(providers, time.Second); err == nil {
+		t.Errorf("Unexpected instance found: %+v", *instance)
+	}
+}
+
+func TestCloudTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	providers := testCloudProviders(server, server, server)
+
+	start := time.Now()
+	if instance, err := lookupCloud(providers, 100*time.Millisecond); err == nil {
+		t.Errorf("Unexpected instance found: %+v", *instance)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Lookup did not time out promptly: took %s", elapsed)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hostmeta
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+var (
+	// The most recently collected metadata, which harvesters add to each event
+	currentMutex  sync.RWMutex
+	currentPrefix string
+	currentFields map[string]interface{}
+)
+
+// Fields returns the field path under which host metadata should be added to
+// events along with a copy of the metadata, or nil if there is none
+func Fields() (string, map[string]interface{}) {
+	currentMutex.RLock()
+	defer currentMutex.RUnlock()
+
+	if currentFields == nil {
+		return "", nil
+	}
+
+	// Processors may modify the event, so each event needs its own copy
+	return currentPrefix, copyFields(currentFields)
+}
+
+// setFields replaces the current metadata
+func setFields(prefix string, fields map[string]interface{}) {
+	currentMutex.Lock()
+	defer currentMutex.Unlock()
+
+	currentPrefix, currentFields = prefix, fields
+}
+
+// copyFields returns a deep copy of the metadata
+func copyFields(fields map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		if child, ok := value.(map[string]interface{}); ok {
+			value = copyFields(child)
+		}
+		ret[key] = value
+	}
+	return ret
+}
+
+// Collector gathers metadata about the host when it is created and refreshes
+// it periodically, so harvesters can add it to every event
+type Collector struct {
+	core.PipelineSegment
+	core.PipelineConfigReceiver
+
+	config *Config
+
+	// The last instance details found, kept so that a transient failure of the
+	// metadata service does not remove them from events
+	cloud *cloudInstance
+}
+
+// NewCollector creates a new collector on the pipeline. Metadata is collected
+// immediately so it is available to the very first events
+func NewCollector(pipeline *core.Pipeline, config *config.Config) *Collector {
+	ret := &Collector{
+		config: config.Get("host metadata").(*Config),
+	}
+
+	ret.collect()
+
+	pipeline.Register(ret)
+
+	return ret
+}
+
+// Run refreshes the metadata at each interval until shutdown
+func (c *Collector) Run() {
+	defer func() {
+		c.Done()
+	}()
+
+	ticker := time.NewTicker(c.config.RefreshInterval)
+
+CollectorLoop:
+	for {
+		select {
+		case <-c.OnShutdown():
+			break CollectorLoop
+		case <-ticker.C:
+			c.collect()
+		case config := <-c.OnConfig():
+			c.config = config.Get("host metadata").(*Config)
+			c.collect()
+
+			ticker.Stop()
+			ticker = time.NewTicker(c.config.RefreshInterval)
+		}
+	}
+
+	ticker.Stop()
+
+	log.Info("Host metadata collector exiting")
+}
+
+// collect gathers each enabled category of metadata and publishes the result
+func (c *Collector) collect() {
+	if !c.config.Enabled() {
+		setFields("", nil)
+		return
+	}
+
+	fields := map[string]interface{}{}
+
+	if c.config.FQDN {
+		if fqdn, err := lookupFQDN(); err != nil {
+			log.Warning("Failed to determine the FQDN for host metadata: %s", err)
+		} else {
+			fields["fqdn"] = fqdn
+		}
+	}
+
+	if c.config.OS {
+		fields["os"] = runtime.GOOS
+		fields["architecture"] = runtime.GOARCH
+	}
+
+	if c.config.Kernel {
+		if kernel, err := kernelVersion(); err != nil {
+			log.Warning("Failed to determine the kernel version for host metadata: %s", err)
+		} else {
+			fields["kernel"] = kernel
+		}
+	}
+
+	if c.config.Cloud {
+		if instance, err := lookupCloud(cloudProviders, c.config.CloudTimeout); err != nil {
+			if c.cloud == nil {
+				log.Info("No cloud instance metadata available: %s", err)
+			} else {
+				log.Warning("Failed to refresh cloud instance metadata, the previous details will be kept: %s", err)
+			}
+		} else {
+			if c.cloud == nil || *c.cloud != *instance {
+				log.Info("Running on %s cloud instance %s", instance.provider, instance.instanceID)
+			}
+			c.cloud = instance
+		}
+
+		if c.cloud != nil {
+			fields["cloud"] = c.cloud.fields()
+		}
+	}
+
+	if len(c.config.Environment) != 0 {
+		env := map[string]interface{}{}
+		for _, name := range c.config.Environment {
+			if value, ok := os.LookupEnv(name); ok {
+				env[name] = value
+			}
+		}
+		if len(env) != 0 {
+			fields["env"] = env
+		}
+	}
+
+	setFields(c.config.Prefix, fields)
+}
+
+// lookupFQDN returns the fully qualified domain name of the host, which is the
+// canonical name of the hostname if it can be resolved, otherwise the hostname
+func lookupFQDN() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+
+	cname, err := net.LookupCNAME(hostname)
+	if err != nil || cname == "" || cname == "." {
+		return hostname, nil
+	}
+
+	return strings.TrimSuffix(cname, "."), nil
+}
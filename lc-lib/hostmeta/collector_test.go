@@ -0,0 +1,90 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hostmeta
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createCollector(t *testing.T, configure func(*Config)) *Collector {
+	cfg := config.NewConfig()
+	section := cfg.Get("host metadata").(*Config)
+	section.InitDefaults()
+	configure(section)
+	if err := section.Validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %s", err)
+	}
+
+	return NewCollector(core.NewPipeline(), cfg)
+}
+
+func TestCollectorDisabled(t *testing.T) {
+	createCollector(t, func(c *Config) {})
+
+	if prefix, fields := Fields(); fields != nil {
+		t.Errorf("Unexpected fields at %s: %v", prefix, fields)
+	}
+}
+
+func TestCollectorFields(t *testing.T) {
+	os.Setenv("LC_HOSTMETA_TEST", "value")
+	defer os.Unsetenv("LC_HOSTMETA_TEST")
+	defer setFields("", nil)
+
+	createCollector(t, func(c *Config) {
+		c.OS = true
+		c.Environment = []string{"LC_HOSTMETA_TEST", "LC_HOSTMETA_TEST_UNSET"}
+		c.Prefix = "meta.host"
+	})
+
+	prefix, fields := Fields()
+	if prefix != "meta.host" {
+		t.Errorf("Incorrect prefix: %s", prefix)
+	}
+	if fields["os"] != runtime.GOOS || fields["architecture"] != runtime.GOARCH {
+		t.Errorf("Incorrect OS fields: %v", fields)
+	}
+	if _, ok := fields["fqdn"]; ok {
+		t.Error("Disabled FQDN was collected")
+	}
+
+	env, ok := fields["env"].(map[string]interface{})
+	if !ok || len(env) != 1 || env["LC_HOSTMETA_TEST"] != "value" {
+		t.Errorf("Incorrect environment fields: %v", fields["env"])
+	}
+
+	// Each call must return an independent copy
+	env["LC_HOSTMETA_TEST"] = "modified"
+	_, fields = Fields()
+	if fields["env"].(map[string]interface{})["LC_HOSTMETA_TEST"] != "value" {
+		t.Error("Modifying returned fields modified the collected metadata")
+	}
+}
+
+func TestValidateInvalidPrefix(t *testing.T) {
+	section := &Config{}
+	section.InitDefaults()
+	section.Prefix = "host..meta"
+	if err := section.Validate(); err == nil {
+		t.Error("Invalid prefix was accepted")
+	}
+}
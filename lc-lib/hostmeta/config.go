@@ -0,0 +1,91 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hostmeta
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+const (
+	defaultCloudTimeout    time.Duration = 1 * time.Second
+	defaultPrefix          string        = "host_metadata"
+	defaultRefreshInterval time.Duration = 1 * time.Hour
+)
+
+// Config holds the host metadata configuration
+type Config struct {
+	Cloud           bool          `config:"cloud"`
+	CloudTimeout    time.Duration `config:"cloud timeout"`
+	Environment     []string      `config:"environment"`
+	FQDN            bool          `config:"fqdn"`
+	Kernel          bool          `config:"kernel"`
+	OS              bool          `config:"os"`
+	Prefix          string        `config:"prefix"`
+	RefreshInterval time.Duration `config:"refresh interval"`
+}
+
+// InitDefaults initialises default values
+func (c *Config) InitDefaults() {
+	c.CloudTimeout = defaultCloudTimeout
+	c.Prefix = defaultPrefix
+	c.RefreshInterval = defaultRefreshInterval
+}
+
+// Validate validates the config structure
+func (c *Config) Validate() (err error) {
+	for _, part := range strings.Split(c.Prefix, ".") {
+		if part == "" {
+			err = fmt.Errorf("/host metadata/prefix must be a field name or a dot separated path to a field")
+			return
+		}
+	}
+
+	for _, name := range c.Environment {
+		if name == "" || strings.ContainsAny(name, "=.") {
+			err = fmt.Errorf("/host metadata/environment contains an invalid variable name: \"%s\"", name)
+			return
+		}
+	}
+
+	if c.CloudTimeout <= 0 {
+		err = fmt.Errorf("/host metadata/cloud timeout must be greater than zero")
+		return
+	}
+
+	if c.RefreshInterval <= 0 {
+		err = fmt.Errorf("/host metadata/refresh interval must be greater than zero")
+		return
+	}
+
+	return
+}
+
+// Enabled returns true if any category of host metadata is enabled
+func (c *Config) Enabled() bool {
+	return c.Cloud || c.FQDN || c.Kernel || c.OS || len(c.Environment) != 0
+}
+
+func init() {
+	config.RegisterConfigSection("host metadata", func() config.Section {
+		c := &Config{}
+		return c
+	})
+}
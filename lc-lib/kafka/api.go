@@ -0,0 +1,50 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"github.com/driskell/log-courier/lc-lib/admin"
+)
+
+type apiStatus struct {
+	admin.APIKeyValue
+
+	c *Consumer
+}
+
+// Update updates the consumer status information
+func (a *apiStatus) Update() error {
+	// Update the values and pass through to node
+	a.c.mutex.Lock()
+	a.SetEntry("skippedBatches", admin.APINumber(a.c.skippedBatches))
+	a.c.mutex.Unlock()
+
+	return nil
+}
+
+// initAPI sets up admin connectivity
+func (c *Consumer) initAPI() {
+	// Is admin loaded into the pipeline?
+	if !c.adminConfig.APIEnabled() {
+		return
+	}
+
+	consumerAPI := &admin.APINode{}
+	consumerAPI.SetEntry("status", &apiStatus{c: c})
+
+	c.adminConfig.SetEntry("kafka "+c.kafkaConfig.Group, consumerAPI)
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	apiFetch            int16 = 1
+	apiListOffsets      int16 = 2
+	apiMetadata         int16 = 3
+	apiOffsetCommit     int16 = 8
+	apiOffsetFetch      int16 = 9
+	apiFindCoordinator  int16 = 10
+	apiJoinGroup        int16 = 11
+	apiHeartbeat        int16 = 12
+	apiLeaveGroup       int16 = 13
+	apiSyncGroup        int16 = 14
+	apiSaslHandshake    int16 = 17
+	apiSaslAuthenticate int16 = 36
+
+	// maxResponseSize is the largest response accepted from a broker
+	maxResponseSize = 104857600
+)
+
+// kafkaError is an error code returned by a broker
+type kafkaError int16
+
+const (
+	errOffsetOutOfRange          kafkaError = 1
+	errUnknownTopicOrPartition   kafkaError = 3
+	errLeaderNotAvailable        kafkaError = 5
+	errNotLeaderForPartition     kafkaError = 6
+	errCoordinatorLoadInProgress kafkaError = 14
+	errCoordinatorNotAvailable   kafkaError = 15
+	errNotCoordinator            kafkaError = 16
+	errIllegalGeneration         kafkaError = 22
+	errUnknownMemberID           kafkaError = 25
+	errRebalanceInProgress       kafkaError = 27
+)
+
+var kafkaErrorNames = map[kafkaError]string{
+	errOffsetOutOfRange:          "OFFSET_OUT_OF_RANGE",
+	errUnknownTopicOrPartition:   "UNKNOWN_TOPIC_OR_PARTITION",
+	errLeaderNotAvailable:        "LEADER_NOT_AVAILABLE",
+	errNotLeaderForPartition:     "NOT_LEADER_OR_FOLLOWER",
+	errCoordinatorLoadInProgress: "COORDINATOR_LOAD_IN_PROGRESS",
+	errCoordinatorNotAvailable:   "COORDINATOR_NOT_AVAILABLE",
+	errNotCoordinator:            "NOT_COORDINATOR",
+	errIllegalGeneration:         "ILLEGAL_GENERATION",
+	errUnknownMemberID:           "UNKNOWN_MEMBER_ID",
+	errRebalanceInProgress:       "REBALANCE_IN_PROGRESS",
+}
+
+// Error returns the name of the error code where it is known
+func (e kafkaError) Error() string {
+	if name, ok := kafkaErrorNames[e]; ok {
+		return fmt.Sprintf("Kafka error %d (%s)", int16(e), name)
+	}
+	return fmt.Sprintf("Kafka error %d", int16(e))
+}
+
+// errorCode returns the error for a response error code, or nil if there was
+// no error
+func errorCode(code int16) error {
+	if code == 0 {
+		return nil
+	}
+	return kafkaError(code)
+}
+
+// broker is a connection to a single Kafka broker. Requests are sent one at a
+// time, waiting for the response before the next is sent
+type broker struct {
+	addr          string
+	clientID      string
+	conn          net.Conn
+	mutex         sync.Mutex
+	correlationID int32
+}
+
+// dialBroker connects to a broker, completing the TLS handshake and SASL
+// authentication if they are configured
+func dialBroker(consumer *Consumer, addr string) (*broker, error) {
+	conn, err := net.DialTimeout("tcp", addr, requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if consumer.tlsConfig != nil {
+		tlsConfig := consumer.tlsConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			if tlsConfig.ServerName, _, err = net.SplitHostPort(addr); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(requestTimeout))
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake failure: %s", err)
+		}
+		tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+
+	ret := &broker{
+		addr:     addr,
+		clientID: consumer.clientID,
+		conn:     conn,
+	}
+
+	if consumer.kafkaConfig.SASLMechanism != "" {
+		if err = ret.authenticate(consumer.kafkaConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("SASL authentication failure: %s", err)
+		}
+	}
+
+	return ret, nil
+}
+
+// Close closes the connection to the broker
+func (b *broker) Close() {
+	b.conn.Close()
+}
+
+// request sends a request and waits for the response, returning a decoder for
+// the body of the response. Any error leaves the connection unusable
+func (b *broker) request(apiKey int16, version int16, body *encoder, timeout time.Duration) (*decoder, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.correlationID++
+
+	header := &encoder{data: make([]byte, 4, 18+len(b.clientID)+len(body.data))}
+	header.putInt16(apiKey)
+	header.putInt16(version)
+	header.putInt32(b.correlationID)
+	header.putString(b.clientID)
+	message := append(header.data, body.data...)
+	binary.BigEndian.PutUint32(message[0:4], uint32(len(message)-4))
+
+	b.conn.SetDeadline(time.Now().Add(timeout))
+	defer b.conn.SetDeadline(time.Time{})
+
+	if _, err := b.conn.Write(message); err != nil {
+		return nil, err
+	}
+
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(b.conn, size); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(size)
+	if length < 4 || length > maxResponseSize {
+		return nil, fmt.Errorf("invalid response size (%d)", length)
+	}
+
+	response := make([]byte, length)
+	if _, err := io.ReadFull(b.conn, response); err != nil {
+		return nil, err
+	}
+
+	if correlationID := int32(binary.BigEndian.Uint32(response[0:4])); correlationID != b.correlationID {
+		return nil, fmt.Errorf("response for request %d received when expecting %d", correlationID, b.correlationID)
+	}
+
+	return &decoder{data: response[4:]}, nil
+}
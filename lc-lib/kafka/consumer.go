@@ -0,0 +1,313 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/processors"
+	"github.com/driskell/log-courier/lc-lib/spooler"
+)
+
+const (
+	// requestTimeout is how long to wait for a broker to respond to a request
+	requestTimeout = 30 * time.Second
+
+	// retryInterval is how long to wait before rejoining the group after a
+	// failure
+	retryInterval = 5 * time.Second
+)
+
+// Consumer reads events from Kafka topics as a member of a consumer group and
+// passes them to the spooler so they are forwarded to the network servers.
+// Offsets are only committed once the network servers have acknowledged the
+// events, so delivery is at-least-once end to end
+type Consumer struct {
+	core.PipelineSegment
+
+	kafkaConfig  *config.Kafka
+	adminConfig  *admin.Config
+	tlsConfig    *tls.Config
+	clientID     string
+	messageField string
+	output       chan<- *core.EventDescriptor
+	shutdown     chan struct{}
+
+	// The member ID assigned by the group coordinator, kept so that rejoining
+	// after a failure does not leave a stale member in the group
+	memberID string
+
+	mutex      sync.Mutex
+	processors []config.ProcessorStub
+
+	// The number of record batches skipped as their compression codec is not
+	// supported
+	skippedBatches uint64
+}
+
+// NewConsumer creates a new Consumer for the given Kafka configuration
+func NewConsumer(pipeline *core.Pipeline, config *config.Config, kafkaConfig *config.Kafka, spoolerImp *spooler.Spooler) (*Consumer, error) {
+	ret := &Consumer{
+		kafkaConfig:  kafkaConfig,
+		adminConfig:  config.Get("admin").(*admin.Config),
+		clientID:     fmt.Sprintf("log-courier-%s", core.LogCourierVersion),
+		messageField: config.General.MessageField,
+		output:       spoolerImp.Connect(),
+		shutdown:     make(chan struct{}),
+		processors:   kafkaConfig.Processors,
+	}
+
+	if kafkaConfig.Transport == "tls" {
+		var err error
+		if ret.tlsConfig, err = ret.loadTLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	ret.initAPI()
+
+	pipeline.Register(ret)
+
+	return ret, nil
+}
+
+// ReloadProcessors replaces the processors that are run over the events. The
+// current group membership keeps the processors it started with, and the new
+// processors are used from the next rebalance
+func (c *Consumer) ReloadProcessors(processors []config.ProcessorStub) {
+	c.mutex.Lock()
+	c.processors = processors
+	c.mutex.Unlock()
+}
+
+// currentProcessors returns new instances of the current processors
+func (c *Consumer) currentProcessors() []processors.Processor {
+	c.mutex.Lock()
+	stubs := c.processors
+	c.mutex.Unlock()
+
+	ret := make([]processors.Processor, len(stubs))
+	for i := range stubs {
+		ret[i] = processors.NewProcessor(stubs[i].Factory)
+	}
+	return ret
+}
+
+// skipBatch records that a record batch was skipped as its compression codec
+// is not supported
+func (c *Consumer) skipBatch() {
+	c.mutex.Lock()
+	c.skippedBatches++
+	c.mutex.Unlock()
+}
+
+// loadTLSConfig loads the CA used to verify the brokers and, if one is
+// configured, the client certificate
+func (c *Consumer) loadTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if c.kafkaConfig.SSLCertificate != "" {
+		certificate, err := core.LoadX509KeyPair(c.kafkaConfig.SSLCertificate, c.kafkaConfig.SSLKey, core.ExpandSecret(c.kafkaConfig.SSLKeyPassphrase))
+		if err == core.ErrIncorrectPassphrase {
+			return nil, fmt.Errorf("Failed loading kafka ssl certificate: ssl key passphrase is incorrect for %s", c.kafkaConfig.SSLKey)
+		} else if err != nil {
+			return nil, fmt.Errorf("Failed loading kafka ssl certificate: %s", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if c.kafkaConfig.SSLCA != "" {
+		pemdata, err := ioutil.ReadFile(c.kafkaConfig.SSLCA)
+		if err != nil {
+			return nil, fmt.Errorf("Failure reading kafka CA certificate: %s", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pemdata) {
+			return nil, fmt.Errorf("No certificates found in kafka CA: %s", c.kafkaConfig.SSLCA)
+		}
+	}
+
+	if c.kafkaConfig.SSLVerifyUsage {
+		tlsConfig.VerifyPeerCertificate = core.VerifyExtKeyUsage(x509.ExtKeyUsageServerAuth)
+	}
+
+	return tlsConfig, nil
+}
+
+// Run consumes from the topics until shutdown
+func (c *Consumer) Run() {
+	defer func() {
+		c.Done()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.consume()
+	}()
+
+	<-c.OnShutdown()
+
+	close(c.shutdown)
+	<-done
+
+	log.Info("[%s] Kafka consumer exiting", c.kafkaConfig.Group)
+}
+
+// consume joins the group and consumes the assigned partitions, rejoining
+// whenever the group rebalances or a failure occurs
+func (c *Consumer) consume() {
+	for {
+		err := newSession(c).run()
+
+		select {
+		case <-c.shutdown:
+			return
+		default:
+		}
+
+		if err == nil {
+			continue
+		}
+
+		log.Warning("[%s] Kafka consumer failed, retrying in %v: %s", c.kafkaConfig.Group, retryInterval, err)
+
+		select {
+		case <-c.shutdown:
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// newEvent creates the event for a record. Records containing a JSON object
+// are used as the event, and any other record becomes the message of a new
+// event, stored in the given message field. The topic, partition, offset and
+// key of the record are added in the "kafka" field
+func newEvent(p *partition, rec record, messageField string) core.Event {
+	event, err := core.DecodeEvent(rec.value)
+	if err != nil || event == nil {
+		event = core.Event{}
+		event.SetPath(messageField, string(rec.value))
+	}
+
+	metadata := map[string]interface{}{
+		"topic":     p.topic,
+		"partition": p.partition,
+		"offset":    rec.offset,
+	}
+	if rec.key != nil {
+		metadata["key"] = string(rec.key)
+	}
+	event["kafka"] = metadata
+
+	if _, ok := event["@timestamp"]; !ok && rec.timestamp.UnixNano() >= 0 {
+		event["@timestamp"] = rec.timestamp.UTC()
+	}
+
+	return event
+}
+
+// partition is a partition assigned to this consumer, and is the stream for
+// the events consumed from it so that their acknowledgements can be committed
+type partition struct {
+	topic     string
+	partition int32
+	leader    int32
+
+	// The next offset to fetch, used only by the session
+	offset int64
+
+	// The last committed offset, used only by the session
+	committed int64
+
+	mutex sync.Mutex
+	// The next offset after the last event passed to the spooler
+	sent int64
+	// The next offset after any records dropped by the processors after sent
+	skipped int64
+	// The next offset after the last acknowledged event, which is committed
+	acked int64
+}
+
+// start sets the offset the partition starts consuming from
+func (p *partition) start(offset int64) {
+	p.offset, p.committed = offset, offset
+
+	p.mutex.Lock()
+	p.sent, p.skipped, p.acked = offset, offset, offset
+	p.mutex.Unlock()
+}
+
+// Info returns the topic and partition as the path of the stream
+func (p *partition) Info() (string, os.FileInfo) {
+	return fmt.Sprintf("%s[%d]", p.topic, p.partition), nil
+}
+
+// Ack records that the events up to the given offset have been acknowledged.
+// Once all sent events are acknowledged, any records dropped after them are
+// acknowledged too
+func (p *partition) Ack(offset int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if offset >= p.sent {
+		offset = p.skipped
+	}
+	if offset > p.acked {
+		p.acked = offset
+	}
+}
+
+// markSent records the offset of an event about to be sent to the spooler
+func (p *partition) markSent(offset int64) {
+	p.mutex.Lock()
+	p.sent, p.skipped = offset, offset
+	p.mutex.Unlock()
+}
+
+// markSkipped records that records up to the given offset were dropped by the
+// processors, acknowledging them immediately if there are no events waiting
+// to be acknowledged
+func (p *partition) markSkipped(offset int64) {
+	p.mutex.Lock()
+	p.skipped = offset
+	if p.acked >= p.sent {
+		p.acked = offset
+	}
+	p.mutex.Unlock()
+}
+
+// ackedOffset returns the offset that can be committed
+func (p *partition) ackedOffset() int64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.acked
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	errTruncated = errors.New("truncated response")
+)
+
+// encoder builds the body of a Kafka request
+type encoder struct {
+	data []byte
+}
+
+func (e *encoder) putInt8(value int8) {
+	e.data = append(e.data, byte(value))
+}
+
+func (e *encoder) putInt16(value int16) {
+	e.data = append(e.data, 0, 0)
+	binary.BigEndian.PutUint16(e.data[len(e.data)-2:], uint16(value))
+}
+
+func (e *encoder) putInt32(value int32) {
+	e.data = append(e.data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(e.data[len(e.data)-4:], uint32(value))
+}
+
+func (e *encoder) putInt64(value int64) {
+	e.data = append(e.data, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(e.data[len(e.data)-8:], uint64(value))
+}
+
+func (e *encoder) putString(value string) {
+	e.putInt16(int16(len(value)))
+	e.data = append(e.data, value...)
+}
+
+func (e *encoder) putBytes(value []byte) {
+	e.putInt32(int32(len(value)))
+	e.data = append(e.data, value...)
+}
+
+func (e *encoder) putArrayLength(length int) {
+	e.putInt32(int32(length))
+}
+
+func (e *encoder) putStrings(values []string) {
+	e.putArrayLength(len(values))
+	for _, value := range values {
+		e.putString(value)
+	}
+}
+
+func (e *encoder) putInt32s(values []int32) {
+	e.putArrayLength(len(values))
+	for _, value := range values {
+		e.putInt32(value)
+	}
+}
+
+// decoder reads the body of a Kafka response. The first error encountered is
+// remembered and all subsequent reads return zero values, so that a response
+// can be decoded in full before checking for an error
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) take(length int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if length < 0 || length > len(d.data) {
+		d.err = errTruncated
+		d.data = nil
+		return nil
+	}
+
+	ret := d.data[:length]
+	d.data = d.data[length:]
+	return ret
+}
+
+func (d *decoder) getInt8() int8 {
+	if data := d.take(1); data != nil {
+		return int8(data[0])
+	}
+	return 0
+}
+
+func (d *decoder) getInt16() int16 {
+	if data := d.take(2); data != nil {
+		return int16(binary.BigEndian.Uint16(data))
+	}
+	return 0
+}
+
+func (d *decoder) getInt32() int32 {
+	if data := d.take(4); data != nil {
+		return int32(binary.BigEndian.Uint32(data))
+	}
+	return 0
+}
+
+func (d *decoder) getInt64() int64 {
+	if data := d.take(8); data != nil {
+		return int64(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+// getString reads a string, returning an empty string for a null string
+func (d *decoder) getString() string {
+	length := d.getInt16()
+	if length < 0 {
+		return ""
+	}
+	return string(d.take(int(length)))
+}
+
+// getBytes reads a byte array, returning nil for a null byte array
+func (d *decoder) getBytes() []byte {
+	length := d.getInt32()
+	if length < 0 {
+		return nil
+	}
+	return d.take(int(length))
+}
+
+// getArrayLength reads the length of an array, returning 0 for a null array
+// An invalid length stops decoding so that callers can safely loop over it
+func (d *decoder) getArrayLength() int {
+	length := d.getInt32()
+	if length < 0 {
+		return 0
+	}
+	if int(length) > len(d.data) {
+		d.err = errTruncated
+		d.data = nil
+		return 0
+	}
+	return int(length)
+}
+
+func (d *decoder) getInt32s() []int32 {
+	length := d.getArrayLength()
+	values := make([]int32, 0, length)
+	for i := 0; i < length; i++ {
+		values = append(values, d.getInt32())
+	}
+	return values
+}
+
+// getVarint reads a zig-zag encoded variable length integer, as used within
+// record batches
+func (d *decoder) getVarint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	value, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errTruncated
+		d.data = nil
+		return 0
+	}
+
+	d.data = d.data[n:]
+	return value
+}
+
+// getVarBytes reads a byte array prefixed by a varint length, returning nil
+// for a null byte array
+func (d *decoder) getVarBytes() []byte {
+	length := d.getVarint()
+	if length < 0 {
+		return nil
+	}
+	return d.take(int(length))
+}
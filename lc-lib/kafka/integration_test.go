@@ -0,0 +1,403 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// The integration tests run against a real Kafka cluster, and are skipped
+// unless KAFKA_TEST_BROKERS is set to a comma separated list of its brokers.
+// The cluster must allow topics to be created and have no authentication
+
+const (
+	// integrationTimeout is how long to wait for the cluster to do something
+	integrationTimeout = 90 * time.Second
+
+	apiProduce      int16 = 0
+	apiCreateTopics int16 = 19
+
+	errTopicAlreadyExists kafkaError = 36
+	errNotController      kafkaError = 41
+)
+
+func integrationBrokers(t *testing.T) []string {
+	brokers := os.Getenv("KAFKA_TEST_BROKERS")
+	if brokers == "" {
+		t.Skip("KAFKA_TEST_BROKERS is not set")
+	}
+	return strings.Split(brokers, ",")
+}
+
+func createIntegrationConsumer(brokers []string, group string, topics []string) *Consumer {
+	kafkaConfig := &config.Kafka{}
+	kafkaConfig.InitDefaults()
+	kafkaConfig.Brokers = brokers
+	kafkaConfig.Group = group
+	kafkaConfig.Topics = topics
+	kafkaConfig.StartPosition = "beginning"
+	kafkaConfig.CommitInterval = 500 * time.Millisecond
+
+	return &Consumer{
+		kafkaConfig:  kafkaConfig,
+		clientID:     "log-courier-test",
+		messageField: "message",
+		shutdown:     make(chan struct{}),
+	}
+}
+
+// runIntegrationConsumer starts a consumer, returning its events and a
+// function that shuts it down, leaving the group
+func runIntegrationConsumer(brokers []string, group string, topic string) (chan *core.EventDescriptor, func()) {
+	output := make(chan *core.EventDescriptor, 100)
+	consumer := createIntegrationConsumer(brokers, group, []string{topic})
+	consumer.output = output
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.consume()
+	}()
+
+	return output, func() {
+		close(consumer.shutdown)
+		<-done
+	}
+}
+
+// connectIntegrationSession connects a session used by the tests to create
+// topics, produce records and check committed offsets, retrying while the
+// cluster starts
+func connectIntegrationSession(t *testing.T, brokers []string, group string) *session {
+	deadline := time.Now().Add(integrationTimeout)
+	for {
+		s := newSession(createIntegrationConsumer(brokers, group, nil))
+		err := s.connect()
+		if err == nil {
+			return s
+		}
+		s.close()
+		if time.Now().After(deadline) {
+			t.Fatalf("Failed to connect to the cluster: %s", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// createIntegrationTopic creates a topic and waits for each of its partitions
+// to have a leader, returning the partitions
+func createIntegrationTopic(t *testing.T, s *session, topic string, partitions int) []*partition {
+	body := &encoder{}
+	body.putArrayLength(1)
+	body.putString(topic)
+	body.putInt32(int32(partitions))
+	body.putInt16(1)
+	body.putArrayLength(0)
+	body.putArrayLength(0)
+	body.putInt32(int32(requestTimeout / time.Millisecond))
+
+	// Topics can only be created by the controller, so try each broker
+	created := false
+	for nodeID := range s.addresses {
+		b, err := s.broker(nodeID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		response, err := b.request(apiCreateTopics, 0, body, requestTimeout)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		response.getArrayLength()
+		response.getString()
+		code := kafkaError(response.getInt16())
+		if response.err != nil {
+			t.Fatalf("Unexpected error: %s", response.err)
+		}
+		if code == errNotController {
+			continue
+		}
+		if code != 0 && code != errTopicAlreadyExists {
+			t.Fatalf("Failed to create topic %s: %s", topic, code)
+		}
+		created = true
+		break
+	}
+	if !created {
+		t.Fatalf("Failed to create topic %s: controller not found", topic)
+	}
+
+	s.partitions = make([]*partition, partitions)
+	for i := range s.partitions {
+		s.partitions[i] = &partition{topic: topic, partition: int32(i), leader: -1}
+	}
+
+	deadline := time.Now().Add(integrationTimeout)
+	for {
+		if err := s.refreshMetadata([]string{topic}); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		ready := true
+		for _, p := range s.partitions {
+			if p.leader < 0 {
+				ready = false
+			}
+		}
+		if ready {
+			return s.partitions
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for topic %s to have leaders", topic)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// produceIntegrationRecords produces an uncompressed record batch containing
+// the given values to a partition
+func produceIntegrationRecords(t *testing.T, s *session, p *partition, values ...string) {
+	batch := createTestBatch(0, 0, values...)
+
+	// Use the current time so the broker's retention does not remove the records
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	binary.BigEndian.PutUint64(batch[27:35], uint64(now))
+	binary.BigEndian.PutUint64(batch[35:43], uint64(now))
+	binary.BigEndian.PutUint32(batch[17:21], crc32.Checksum(batch[21:], crc32c))
+
+	b, err := s.broker(p.leader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	body := &encoder{}
+	body.putInt16(-1)
+	body.putInt16(-1)
+	body.putInt32(int32(requestTimeout / time.Millisecond))
+	body.putArrayLength(1)
+	body.putString(p.topic)
+	body.putArrayLength(1)
+	body.putInt32(p.partition)
+	body.putBytes(batch)
+
+	response, err := b.request(apiProduce, 3, body, requestTimeout)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	response.getArrayLength()
+	response.getString()
+	response.getArrayLength()
+	response.getInt32()
+	code := response.getInt16()
+	if response.err != nil {
+		t.Fatalf("Unexpected error: %s", response.err)
+	}
+	if code != 0 {
+		t.Fatalf("Failed to produce to %s[%d]: %s", p.topic, p.partition, kafkaError(code))
+	}
+}
+
+// committedIntegrationOffset returns the offset committed by the group for a
+// partition, or -1 if there is none
+func committedIntegrationOffset(t *testing.T, s *session, p *partition) int64 {
+	if s.coordinator == nil {
+		if err := s.findCoordinator(); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+
+	body := &encoder{}
+	body.putString(s.group)
+	body.putArrayLength(1)
+	body.putString(p.topic)
+	body.putArrayLength(1)
+	body.putInt32(p.partition)
+
+	response, err := s.coordinator.request(apiOffsetFetch, 2, body, requestTimeout)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	response.getArrayLength()
+	response.getString()
+	response.getArrayLength()
+	response.getInt32()
+	offset := response.getInt64()
+	response.getString()
+	code := response.getInt16()
+	if response.err != nil {
+		t.Fatalf("Unexpected error: %s", response.err)
+	}
+	if code != 0 {
+		t.Fatalf("Failed to fetch committed offset: %s", kafkaError(code))
+	}
+	return offset
+}
+
+func waitCommittedOffset(t *testing.T, s *session, p *partition, expected int64) {
+	deadline := time.Now().Add(integrationTimeout)
+	for {
+		offset := committedIntegrationOffset(t, s, p)
+		if offset == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for committed offset %d: %d", expected, offset)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func receiveIntegrationEvent(t *testing.T, output chan *core.EventDescriptor) (*core.EventDescriptor, string) {
+	select {
+	case desc := <-output:
+		event, err := core.DecodeEvent(desc.Event)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		message, _ := event["message"].(string)
+		return desc, message
+	case <-time.After(integrationTimeout):
+		t.Fatal("Timed out waiting for event")
+	}
+	return nil, ""
+}
+
+func integrationName(prefix string) string {
+	return fmt.Sprintf("log-courier-test-%s-%d", prefix, time.Now().UnixNano())
+}
+
+func TestIntegrationCommitAfterAck(t *testing.T) {
+	brokers := integrationBrokers(t)
+	topic, group := integrationName("commit"), integrationName("commit")
+
+	s := connectIntegrationSession(t, brokers, group)
+	defer s.close()
+	p := createIntegrationTopic(t, s, topic, 1)[0]
+	produceIntegrationRecords(t, s, p, "one", "two", "three")
+
+	output, stop := runIntegrationConsumer(brokers, group, topic)
+	var descs []*core.EventDescriptor
+	for _, expected := range []string{"one", "two", "three"} {
+		desc, message := receiveIntegrationEvent(t, output)
+		if message != expected {
+			stop()
+			t.Fatalf("Unexpected event: %s", desc.Event)
+		}
+		descs = append(descs, desc)
+	}
+
+	// Nothing is committed until the events are acknowledged
+	time.Sleep(2 * time.Second)
+	if offset := committedIntegrationOffset(t, s, p); offset != -1 {
+		stop()
+		t.Fatalf("Offset %d was committed before events were acknowledged", offset)
+	}
+
+	descs[0].Stream.(*partition).Ack(descs[0].Offset)
+	waitCommittedOffset(t, s, p, 1)
+	stop()
+
+	// Events that were not acknowledged are consumed again
+	output, stop = runIntegrationConsumer(brokers, group, topic)
+	defer stop()
+	descs = nil
+	for _, expected := range []string{"two", "three"} {
+		desc, message := receiveIntegrationEvent(t, output)
+		if message != expected {
+			t.Fatalf("Unexpected event: %s", desc.Event)
+		}
+		descs = append(descs, desc)
+	}
+
+	for _, desc := range descs {
+		desc.Stream.(*partition).Ack(desc.Offset)
+	}
+	waitCommittedOffset(t, s, p, 3)
+}
+
+func TestIntegrationRebalance(t *testing.T) {
+	brokers := integrationBrokers(t)
+	topic, group := integrationName("rebalance"), integrationName("rebalance")
+
+	s := connectIntegrationSession(t, brokers, group)
+	defer s.close()
+	partitions := createIntegrationTopic(t, s, topic, 2)
+
+	// A single member is assigned all partitions
+	first, stopFirst := runIntegrationConsumer(brokers, group, topic)
+	defer stopFirst()
+	produceIntegrationRecords(t, s, partitions[0], "zero")
+	produceIntegrationRecords(t, s, partitions[1], "one")
+	received := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		desc, message := receiveIntegrationEvent(t, first)
+		desc.Stream.(*partition).Ack(desc.Offset)
+		received[message] = true
+	}
+	if !received["zero"] || !received["one"] {
+		t.Fatalf("First member did not receive from all partitions: %v", received)
+	}
+
+	// Once a second member joins each is assigned one partition, which can take
+	// a few heartbeats, so keep producing until the records are split
+	second, stopSecond := runIntegrationConsumer(brokers, group, topic)
+	defer stopSecond()
+
+	deadline := time.Now().Add(integrationTimeout)
+	for round := 0; time.Now().Before(deadline); round++ {
+		values := []string{fmt.Sprintf("round %d zero", round), fmt.Sprintf("round %d one", round)}
+		produceIntegrationRecords(t, s, partitions[0], values[0])
+		produceIntegrationRecords(t, s, partitions[1], values[1])
+
+		members := make(map[string]int)
+		timeout := time.After(5 * time.Second)
+	Collect:
+		for len(members) < 2 {
+			var desc *core.EventDescriptor
+			member := 0
+			select {
+			case desc = <-first:
+			case desc = <-second:
+				member = 1
+			case <-timeout:
+				break Collect
+			}
+
+			desc.Stream.(*partition).Ack(desc.Offset)
+			event, _ := core.DecodeEvent(desc.Event)
+			if message, _ := event["message"].(string); message == values[0] || message == values[1] {
+				members[message] = member
+			}
+		}
+
+		if len(members) == 2 && members[values[0]] != members[values[1]] {
+			return
+		}
+	}
+
+	t.Fatal("Timed out waiting for partitions to be shared by the members")
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	// recordBatchHeaderSize is the size of the record batch header following the
+	// base offset and batch length
+	recordBatchHeaderSize = 49
+
+	recordCompressionMask = 0x07
+	recordCompressionNone = 0
+	recordCompressionGzip = 1
+	recordLogAppendTime   = 0x08
+	recordControl         = 0x20
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// record is a single message consumed from a partition
+type record struct {
+	offset    int64
+	timestamp time.Time
+	key       []byte
+	value     []byte
+}
+
+// recordSet holds the records decoded from the record batches of a fetch
+type recordSet struct {
+	records []record
+
+	// The offset following the last complete batch, which the next fetch must
+	// start from. This moves past control batches, records before the fetch
+	// offset and skipped batches even when there are no records
+	next int64
+
+	// The base offset and codec of each batch skipped as its compression codec
+	// is not supported
+	unsupported []unsupportedBatch
+}
+
+// unsupportedBatch is a record batch skipped as its compression codec is not
+// supported
+type unsupportedBatch struct {
+	offset int64
+	codec  uint16
+}
+
+// decodeRecords decodes the record batches returned for a partition by a
+// fetch, skipping records before the fetch offset as compressed batches are
+// always returned whole. A partial batch at the end is ignored, as it means
+// the response reached the size limit of the fetch. Batches using a compression
+// codec that is not supported are skipped so that the partition does not stall
+func decodeRecords(data []byte, fetchOffset int64) (*recordSet, error) {
+	set := &recordSet{next: fetchOffset}
+
+	for len(data) >= 12 {
+		baseOffset := int64(binary.BigEndian.Uint64(data[0:8]))
+		length := int(int32(binary.BigEndian.Uint32(data[8:12])))
+		if length < 0 || 12+length > len(data) {
+			break
+		}
+
+		batch := data[12 : 12+length]
+		data = data[12+length:]
+
+		if length < 5 {
+			return nil, errTruncated
+		}
+		if batch[4] != 2 {
+			return nil, fmt.Errorf("unsupported message format version %d", batch[4])
+		}
+		if length < recordBatchHeaderSize {
+			return nil, errTruncated
+		}
+		if crc32.Checksum(batch[9:], crc32c) != binary.BigEndian.Uint32(batch[5:9]) {
+			return nil, fmt.Errorf("record batch at offset %d is corrupt", baseOffset)
+		}
+
+		attributes := binary.BigEndian.Uint16(batch[9:11])
+		lastOffsetDelta := int64(int32(binary.BigEndian.Uint32(batch[11:15])))
+		if next := baseOffset + lastOffsetDelta + 1; next > set.next {
+			set.next = next
+		}
+
+		if attributes&recordControl != 0 {
+			continue
+		}
+
+		if codec := attributes & recordCompressionMask; codec != recordCompressionNone && codec != recordCompressionGzip {
+			set.unsupported = append(set.unsupported, unsupportedBatch{offset: baseOffset, codec: codec})
+			continue
+		}
+
+		batchRecords, err := decodeRecordBatch(baseOffset, attributes, batch)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rec := range batchRecords {
+			if rec.offset >= fetchOffset {
+				set.records = append(set.records, rec)
+			}
+		}
+	}
+
+	return set, nil
+}
+
+// compressionCodecName returns the name of a record batch compression codec
+func compressionCodecName(codec uint16) string {
+	switch codec {
+	case 2:
+		return "snappy"
+	case 3:
+		return "lz4"
+	case 4:
+		return "zstd"
+	}
+	return fmt.Sprintf("%d", codec)
+}
+
+// decodeRecordBatch decodes the records within a single record batch
+func decodeRecordBatch(baseOffset int64, attributes uint16, batch []byte) ([]record, error) {
+	baseTimestamp := int64(binary.BigEndian.Uint64(batch[15:23]))
+	maxTimestamp := int64(binary.BigEndian.Uint64(batch[23:31]))
+	count := int(int32(binary.BigEndian.Uint32(batch[45:49])))
+	data := batch[recordBatchHeaderSize:]
+
+	switch attributes & recordCompressionMask {
+	case recordCompressionNone:
+	case recordCompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("record batch at offset %d is corrupt: %s", baseOffset, err)
+		}
+		if data, err = ioutil.ReadAll(reader); err != nil {
+			return nil, fmt.Errorf("record batch at offset %d is corrupt: %s", baseOffset, err)
+		}
+	}
+
+	if count < 0 || count > len(data) {
+		return nil, errTruncated
+	}
+
+	records := make([]record, 0, count)
+	reader := &decoder{data: data}
+	for i := 0; i < count; i++ {
+		recordData := reader.take(int(reader.getVarint()))
+		if reader.err != nil {
+			return nil, reader.err
+		}
+
+		fields := &decoder{data: recordData}
+		fields.getInt8()
+		timestampDelta := fields.getVarint()
+		offsetDelta := fields.getVarint()
+		rec := record{
+			offset: baseOffset + offsetDelta,
+			key:    fields.getVarBytes(),
+			value:  fields.getVarBytes(),
+		}
+		if fields.err != nil {
+			return nil, fields.err
+		}
+
+		if attributes&recordLogAppendTime != 0 {
+			rec.timestamp = time.Unix(0, maxTimestamp*int64(time.Millisecond))
+		} else {
+			rec.timestamp = time.Unix(0, (baseTimestamp+timestampDelta)*int64(time.Millisecond))
+		}
+
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,204 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+func putVarint(data []byte, value int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(data, buf[:binary.PutVarint(buf, value)]...)
+}
+
+func createTestBatch(baseOffset int64, attributes uint16, values ...string) []byte {
+	var records []byte
+	for i, value := range values {
+		var rec []byte
+		rec = append(rec, 0)
+		rec = putVarint(rec, int64(i)*10)
+		rec = putVarint(rec, int64(i))
+		rec = putVarint(rec, -1)
+		rec = putVarint(rec, int64(len(value)))
+		rec = append(rec, value...)
+		rec = putVarint(rec, 0)
+
+		records = putVarint(records, int64(len(rec)))
+		records = append(records, rec...)
+	}
+
+	if attributes&recordCompressionMask == recordCompressionGzip {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(records)
+		writer.Close()
+		records = compressed.Bytes()
+	}
+
+	batch := &encoder{}
+	batch.putInt64(baseOffset)
+	batch.putInt32(int32(recordBatchHeaderSize + len(records)))
+	batch.putInt32(0)
+	batch.putInt8(2)
+	batch.putInt32(0)
+	batch.putInt16(int16(attributes))
+	batch.putInt32(int32(len(values) - 1))
+	batch.putInt64(1500000000000)
+	batch.putInt64(1500000000000 + int64(len(values)-1)*10)
+	batch.putInt64(-1)
+	batch.putInt16(-1)
+	batch.putInt32(-1)
+	batch.putInt32(int32(len(values)))
+	data := append(batch.data, records...)
+
+	binary.BigEndian.PutUint32(data[17:21], crc32.Checksum(data[21:], crc32c))
+	return data
+}
+
+func checkRecords(t *testing.T, records []record, offset int64, values ...string) {
+	if len(records) != len(values) {
+		t.Fatalf("Incorrect number of records: %d != %d", len(records), len(values))
+	}
+
+	for i, rec := range records {
+		if rec.offset != offset+int64(i) {
+			t.Errorf("Incorrect offset for record %d: %d", i, rec.offset)
+		}
+		if string(rec.value) != values[i] {
+			t.Errorf("Incorrect value for record %d: %q", i, rec.value)
+		}
+		if rec.key != nil {
+			t.Errorf("Null key was not decoded as nil for record %d", i)
+		}
+	}
+}
+
+func TestDecodeRecords(t *testing.T) {
+	data := createTestBatch(100, 0, "one", "two", "three")
+
+	set, err := decodeRecords(data, 100)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	checkRecords(t, set.records, 100, "one", "two", "three")
+	if set.next != 103 {
+		t.Errorf("Incorrect next offset: %d", set.next)
+	}
+	if set.records[2].timestamp.UnixNano() != (1500000000000+20)*1000000 {
+		t.Errorf("Incorrect timestamp: %s", set.records[2].timestamp)
+	}
+}
+
+func TestDecodeRecordsGzip(t *testing.T) {
+	data := createTestBatch(100, recordCompressionGzip, "one", "two", "three")
+
+	// Compressed batches are returned whole so earlier records are skipped
+	set, err := decodeRecords(data, 101)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	checkRecords(t, set.records, 101, "two", "three")
+}
+
+func TestDecodeRecordsPartial(t *testing.T) {
+	data := createTestBatch(100, 0, "one")
+	data = append(data, createTestBatch(101, 0, "two")...)
+
+	set, err := decodeRecords(data[:len(data)-5], 100)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	checkRecords(t, set.records, 100, "one")
+	if set.next != 101 {
+		t.Errorf("Incorrect next offset: %d", set.next)
+	}
+}
+
+func TestDecodeRecordsControl(t *testing.T) {
+	data := createTestBatch(100, recordControl, "marker")
+	data = append(data, createTestBatch(101, 0, "two")...)
+
+	set, err := decodeRecords(data, 100)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	checkRecords(t, set.records, 101, "two")
+}
+
+func TestDecodeRecordsControlOnly(t *testing.T) {
+	data := createTestBatch(100, recordControl, "marker")
+
+	// Control batches contain no records but must still be moved past
+	set, err := decodeRecords(data, 100)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	checkRecords(t, set.records, 100)
+	if set.next != 101 {
+		t.Errorf("Control batch did not advance next offset: %d", set.next)
+	}
+}
+
+func TestDecodeRecordsBeforeOffset(t *testing.T) {
+	data := createTestBatch(100, recordCompressionGzip, "one", "two", "three")
+
+	set, err := decodeRecords(data, 103)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	checkRecords(t, set.records, 103)
+	if set.next != 103 {
+		t.Errorf("Incorrect next offset: %d", set.next)
+	}
+}
+
+func TestDecodeRecordsUnsupported(t *testing.T) {
+	data := createTestBatch(100, 2, "one", "two")
+	data = append(data, createTestBatch(102, 0, "three")...)
+
+	set, err := decodeRecords(data, 100)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	checkRecords(t, set.records, 102, "three")
+	if set.next != 103 {
+		t.Errorf("Incorrect next offset: %d", set.next)
+	}
+	if len(set.unsupported) != 1 || set.unsupported[0].offset != 100 || compressionCodecName(set.unsupported[0].codec) != "snappy" {
+		t.Errorf("Unsupported batch was not reported: %v", set.unsupported)
+	}
+}
+
+func TestDecodeRecordsCorrupt(t *testing.T) {
+	data := createTestBatch(100, 0, "one")
+	data[len(data)-2] ^= 0xff
+
+	if _, err := decodeRecords(data, 100); err == nil {
+		t.Error("Corrupt batch was not detected")
+	}
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// authenticate performs SASL authentication with the broker using the
+// configured mechanism
+func (b *broker) authenticate(kafkaConfig *config.Kafka) error {
+	mechanism := strings.ToUpper(kafkaConfig.SASLMechanism)
+	password := core.ExpandSecret(kafkaConfig.SASLPassword)
+
+	body := &encoder{}
+	body.putString(mechanism)
+	response, err := b.request(apiSaslHandshake, 1, body, requestTimeout)
+	if err != nil {
+		return err
+	}
+
+	code := response.getInt16()
+	var mechanisms []string
+	for i, length := 0, response.getArrayLength(); i < length; i++ {
+		mechanisms = append(mechanisms, response.getString())
+	}
+	if response.err != nil {
+		return response.err
+	}
+	if code != 0 {
+		return fmt.Errorf("broker does not support %s (supported: %s)", mechanism, strings.Join(mechanisms, ", "))
+	}
+
+	switch kafkaConfig.SASLMechanism {
+	case "plain":
+		_, err = b.saslAuthenticate([]byte("\x00" + kafkaConfig.SASLUsername + "\x00" + password))
+		return err
+	case "scram-sha-256":
+		return b.scramAuthenticate(newScramClient(sha256.New, kafkaConfig.SASLUsername, password))
+	case "scram-sha-512":
+		return b.scramAuthenticate(newScramClient(sha512.New, kafkaConfig.SASLUsername, password))
+	}
+
+	return fmt.Errorf("unknown mechanism %s", mechanism)
+}
+
+// saslAuthenticate sends a SASL token to the broker and returns its response
+func (b *broker) saslAuthenticate(token []byte) ([]byte, error) {
+	body := &encoder{}
+	body.putBytes(token)
+	response, err := b.request(apiSaslAuthenticate, 0, body, requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	code := response.getInt16()
+	message := response.getString()
+	data := response.getBytes()
+	if response.err != nil {
+		return nil, response.err
+	}
+	if code != 0 {
+		if message != "" {
+			return nil, errors.New(message)
+		}
+		return nil, kafkaError(code)
+	}
+
+	return data, nil
+}
+
+// scramAuthenticate performs the SCRAM exchange with the broker
+func (b *broker) scramAuthenticate(client *scramClient) error {
+	serverFirst, err := b.saslAuthenticate([]byte(client.firstMessage()))
+	if err != nil {
+		return err
+	}
+
+	final, err := client.finalMessage(string(serverFirst))
+	if err != nil {
+		return err
+	}
+
+	serverFinal, err := b.saslAuthenticate([]byte(final))
+	if err != nil {
+		return err
+	}
+
+	return client.verifyServer(string(serverFinal))
+}
+
+// scramClient implements the client side of SCRAM authentication as described
+// by RFC 5802
+type scramClient struct {
+	hash        func() hash.Hash
+	username    string
+	password    string
+	nonce       string
+	firstBare   string
+	authMessage string
+	salted      []byte
+}
+
+func newScramClient(hash func() hash.Hash, username string, password string) *scramClient {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+
+	return &scramClient{
+		hash:     hash,
+		username: username,
+		password: password,
+		nonce:    base64.RawStdEncoding.EncodeToString(nonce),
+	}
+}
+
+// firstMessage returns the client-first-message
+func (c *scramClient) firstMessage() string {
+	name := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(c.username)
+	c.firstBare = "n=" + name + ",r=" + c.nonce
+	return "n,," + c.firstBare
+}
+
+// finalMessage processes the server-first-message and returns the
+// client-final-message containing the proof
+func (c *scramClient) finalMessage(serverFirst string) (string, error) {
+	var nonce, salt string
+	iterations := 0
+	for _, attr := range strings.Split(serverFirst, ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			continue
+		}
+		switch attr[0] {
+		case 'r':
+			nonce = attr[2:]
+		case 's':
+			salt = attr[2:]
+		case 'i':
+			iterations, _ = strconv.Atoi(attr[2:])
+		}
+	}
+
+	if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+		return "", errors.New("invalid SCRAM nonce from server")
+	}
+
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil || len(saltBytes) == 0 || iterations <= 0 {
+		return "", errors.New("invalid SCRAM parameters from server")
+	}
+
+	c.salted = core.PBKDF2Key([]byte(c.password), saltBytes, iterations, c.hash().Size(), c.hash)
+
+	withoutProof := "c=biws,r=" + nonce
+	c.authMessage = c.firstBare + "," + serverFirst + "," + withoutProof
+
+	clientKey := c.hmac(c.salted, "Client Key")
+	storedKey := c.hash()
+	storedKey.Write(clientKey)
+	signature := c.hmac(storedKey.Sum(nil), c.authMessage)
+
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verifyServer checks the server signature in the server-final-message
+func (c *scramClient) verifyServer(serverFinal string) error {
+	if strings.HasPrefix(serverFinal, "e=") {
+		return errors.New(serverFinal[2:])
+	}
+
+	if !strings.HasPrefix(serverFinal, "v=") {
+		return errors.New("invalid SCRAM response from server")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(serverFinal[2:])
+	if err != nil {
+		return errors.New("invalid SCRAM response from server")
+	}
+
+	expected := c.hmac(c.hmac(c.salted, "Server Key"), c.authMessage)
+	if !hmac.Equal(signature, expected) {
+		return errors.New("SCRAM server signature is incorrect")
+	}
+
+	return nil
+}
+
+func (c *scramClient) hmac(key []byte, data string) []byte {
+	mac := hmac.New(c.hash, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,1022 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/processors"
+)
+
+const (
+	// sessionTimeout is how long the coordinator waits for a heartbeat before
+	// removing the consumer from the group
+	sessionTimeout = 30 * time.Second
+
+	// rebalanceTimeout is how long the coordinator waits for all members to
+	// rejoin during a rebalance
+	rebalanceTimeout = 60 * time.Second
+
+	// heartbeatInterval is how often heartbeats are sent to the coordinator
+	heartbeatInterval = 3 * time.Second
+
+	// fetchMaxWait is how long a broker may wait for new records before
+	// responding to a fetch
+	fetchMaxWait = 500 * time.Millisecond
+
+	// fetchMaxBytes and fetchPartitionMaxBytes limit the size of a fetch
+	fetchMaxBytes          = 52428800
+	fetchPartitionMaxBytes = 1048576
+
+	// rangeAssignor is the only partition assignment strategy supported
+	rangeAssignor = "range"
+)
+
+var (
+	errRebalance = errors.New("group is rebalancing")
+	errShutdown  = errors.New("shutdown requested")
+)
+
+// session is a single generation of the consumer's membership in the group,
+// which ends when the group rebalances or a failure occurs
+type session struct {
+	consumer   *Consumer
+	group      string
+	processors []processors.Processor
+
+	bootstrap   *broker
+	brokers     map[int32]*broker
+	addresses   map[int32]string
+	coordinator *broker
+	generation  int32
+	partitions  []*partition
+
+	// partitionCounts is the number of partitions of each topic known from the
+	// last metadata request
+	partitionCounts map[string]int
+
+	rebalance     chan struct{}
+	rebalanceOnce sync.Once
+}
+
+// newSession creates a new session for the consumer
+func newSession(consumer *Consumer) *session {
+	return &session{
+		consumer:        consumer,
+		group:           consumer.kafkaConfig.Group,
+		processors:      consumer.currentProcessors(),
+		brokers:         make(map[int32]*broker),
+		addresses:       make(map[int32]string),
+		partitionCounts: make(map[string]int),
+		rebalance:       make(chan struct{}),
+	}
+}
+
+// run joins the group and consumes the assigned partitions until the group
+// rebalances, returning nil, or a failure occurs
+func (s *session) run() error {
+	defer s.close()
+
+	if err := s.connect(); err != nil {
+		return err
+	}
+
+	if err := s.join(); err != nil {
+		return err
+	}
+
+	if err := s.fetchOffsets(); err != nil {
+		s.leave()
+		return err
+	}
+
+	stopHeartbeat := make(chan struct{})
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		s.heartbeat(stopHeartbeat)
+	}()
+
+	err := s.fetchLoop()
+
+	close(stopHeartbeat)
+	<-heartbeatDone
+
+	// Commit what has been acknowledged so far, so that whoever is assigned the
+	// partitions next does not resend more than necessary
+	if commitErr := s.commit(); commitErr != nil {
+		log.Warning("[%s] Failed to commit offsets: %s", s.group, commitErr)
+	}
+
+	if err == errShutdown {
+		s.leave()
+		return nil
+	}
+	if err == errRebalance {
+		log.Info("[%s] Group is rebalancing, rejoining", s.group)
+		return nil
+	}
+
+	s.leave()
+	return err
+}
+
+// close closes all broker connections
+func (s *session) close() {
+	if s.bootstrap != nil {
+		s.bootstrap.Close()
+	}
+	for _, b := range s.brokers {
+		b.Close()
+	}
+	if s.coordinator != nil {
+		s.coordinator.Close()
+	}
+}
+
+// connect connects to the first available configured broker and loads the
+// cluster metadata from it
+func (s *session) connect() error {
+	var lastErr error
+	for _, addr := range s.consumer.kafkaConfig.Brokers {
+		b, err := dialBroker(s.consumer, addr)
+		if err != nil {
+			log.Warning("[%s] Failed to connect to broker %s: %s", s.group, addr, err)
+			lastErr = err
+			continue
+		}
+
+		s.bootstrap = b
+		return s.refreshMetadata(s.consumer.kafkaConfig.Topics)
+	}
+
+	return fmt.Errorf("no brokers available: %s", lastErr)
+}
+
+// anyBroker returns a connected broker to send cluster requests to
+func (s *session) anyBroker() (*broker, error) {
+	if s.bootstrap != nil {
+		return s.bootstrap, nil
+	}
+
+	for _, b := range s.brokers {
+		return b, nil
+	}
+
+	for nodeID := range s.addresses {
+		return s.broker(nodeID)
+	}
+
+	return nil, errors.New("no brokers available")
+}
+
+// broker returns the connection to the given node, connecting if necessary
+func (s *session) broker(nodeID int32) (*broker, error) {
+	if b, ok := s.brokers[nodeID]; ok {
+		return b, nil
+	}
+
+	if nodeID < 0 {
+		return nil, errLeaderNotAvailable
+	}
+
+	addr, ok := s.addresses[nodeID]
+	if !ok {
+		return nil, fmt.Errorf("broker %d is not known", nodeID)
+	}
+
+	b, err := dialBroker(s.consumer, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to broker %s: %s", addr, err)
+	}
+
+	s.brokers[nodeID] = b
+	return b, nil
+}
+
+// dropBroker closes the connection to a node after a failure
+func (s *session) dropBroker(nodeID int32) {
+	if b, ok := s.brokers[nodeID]; ok {
+		b.Close()
+		delete(s.brokers, nodeID)
+	}
+}
+
+// refreshMetadata loads the brokers and the partitions of the given topics,
+// updating the leaders of the assigned partitions
+func (s *session) refreshMetadata(topics []string) error {
+	b, err := s.anyBroker()
+	if err != nil {
+		return err
+	}
+
+	body := &encoder{}
+	body.putStrings(topics)
+	body.putInt8(0)
+	response, err := b.request(apiMetadata, 4, body, requestTimeout)
+	if err != nil {
+		s.dropConnection(b)
+		return err
+	}
+
+	response.getInt32()
+	for i, length := 0, response.getArrayLength(); i < length; i++ {
+		nodeID := response.getInt32()
+		host := response.getString()
+		port := response.getInt32()
+		response.getString()
+		s.addresses[nodeID] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	response.getString()
+	response.getInt32()
+
+	leaders := make(map[string]map[int32]int32)
+	for i, length := 0, response.getArrayLength(); i < length; i++ {
+		code := response.getInt16()
+		topic := response.getString()
+		response.getInt8()
+
+		leaders[topic] = make(map[int32]int32)
+		partitions := response.getArrayLength()
+		for j := 0; j < partitions; j++ {
+			response.getInt16()
+			index := response.getInt32()
+			leaders[topic][index] = response.getInt32()
+			response.getInt32s()
+			response.getInt32s()
+		}
+
+		if code != 0 {
+			log.Warning("[%s] Failed to load metadata for topic %s: %s", s.group, topic, kafkaError(code))
+			continue
+		}
+		s.partitionCounts[topic] = partitions
+	}
+	if response.err != nil {
+		return response.err
+	}
+
+	for _, p := range s.partitions {
+		if leader, ok := leaders[p.topic][p.partition]; ok {
+			p.leader = leader
+		}
+	}
+
+	return nil
+}
+
+// dropConnection closes a broker connection after a failure, whether it is the
+// bootstrap connection or the connection to a known node
+func (s *session) dropConnection(b *broker) {
+	if b == s.bootstrap {
+		b.Close()
+		s.bootstrap = nil
+		return
+	}
+
+	for nodeID, other := range s.brokers {
+		if other == b {
+			s.dropBroker(nodeID)
+			return
+		}
+	}
+}
+
+// findCoordinator connects to the coordinator of the group
+func (s *session) findCoordinator() error {
+	b, err := s.anyBroker()
+	if err != nil {
+		return err
+	}
+
+	body := &encoder{}
+	body.putString(s.group)
+	body.putInt8(0)
+	response, err := b.request(apiFindCoordinator, 1, body, requestTimeout)
+	if err != nil {
+		s.dropConnection(b)
+		return err
+	}
+
+	response.getInt32()
+	code := response.getInt16()
+	response.getString()
+	response.getInt32()
+	host := response.getString()
+	port := response.getInt32()
+	if response.err != nil {
+		return response.err
+	}
+	if err = errorCode(code); err != nil {
+		return fmt.Errorf("failed to find group coordinator: %s", err)
+	}
+
+	// The coordinator has its own connection so heartbeats are not held up by
+	// fetches from the same broker
+	if s.coordinator, err = dialBroker(s.consumer, net.JoinHostPort(host, strconv.Itoa(int(port)))); err != nil {
+		return fmt.Errorf("failed to connect to group coordinator: %s", err)
+	}
+
+	return nil
+}
+
+// join joins the group, performing the partition assignment if this consumer
+// is elected leader, and creates the assigned partitions
+func (s *session) join() error {
+	if err := s.findCoordinator(); err != nil {
+		return err
+	}
+
+	subscription := &encoder{}
+	subscription.putInt16(0)
+	subscription.putStrings(s.consumer.kafkaConfig.Topics)
+	subscription.putBytes(nil)
+
+	body := &encoder{}
+	body.putString(s.group)
+	body.putInt32(int32(sessionTimeout / time.Millisecond))
+	body.putInt32(int32(rebalanceTimeout / time.Millisecond))
+	body.putString(s.consumer.memberID)
+	body.putString("consumer")
+	body.putArrayLength(1)
+	body.putString(rangeAssignor)
+	body.putBytes(subscription.data)
+
+	response, err := s.coordinator.request(apiJoinGroup, 2, body, rebalanceTimeout+requestTimeout)
+	if err != nil {
+		return err
+	}
+
+	response.getInt32()
+	code := response.getInt16()
+	s.generation = response.getInt32()
+	protocol := response.getString()
+	leader := response.getString()
+	memberID := response.getString()
+
+	members := make(map[string][]string)
+	for i, length := 0, response.getArrayLength(); i < length; i++ {
+		member := response.getString()
+		metadata := &decoder{data: response.getBytes()}
+		metadata.getInt16()
+		var topics []string
+		for j, count := 0, metadata.getArrayLength(); j < count; j++ {
+			topics = append(topics, metadata.getString())
+		}
+		members[member] = topics
+	}
+	if response.err != nil {
+		return response.err
+	}
+
+	if err = errorCode(code); err != nil {
+		if err == errUnknownMemberID {
+			s.consumer.memberID = ""
+		}
+		return fmt.Errorf("failed to join group: %s", err)
+	}
+	if protocol != rangeAssignor {
+		return fmt.Errorf("group is using unsupported assignment strategy %s", protocol)
+	}
+
+	s.consumer.memberID = memberID
+
+	var assignments map[string]map[string][]int32
+	if leader == memberID {
+		if assignments, err = s.assign(members); err != nil {
+			return err
+		}
+	}
+
+	return s.sync(assignments)
+}
+
+// assign assigns the partitions of the subscribed topics to the members of the
+// group using the range strategy, where each member is given a contiguous
+// range of the partitions of each topic it subscribes to
+func (s *session) assign(members map[string][]string) (map[string]map[string][]int32, error) {
+	var missing []string
+	subscribers := make(map[string][]string)
+	for member, topics := range members {
+		for _, topic := range topics {
+			if _, ok := subscribers[topic]; !ok {
+				if _, ok := s.partitionCounts[topic]; !ok {
+					missing = append(missing, topic)
+				}
+			}
+			subscribers[topic] = append(subscribers[topic], member)
+		}
+	}
+
+	if len(missing) != 0 {
+		if err := s.refreshMetadata(missing); err != nil {
+			return nil, err
+		}
+	}
+
+	return rangeAssign(subscribers, s.partitionCounts), nil
+}
+
+// rangeAssign performs the range assignment given the members subscribed to
+// each topic and the number of partitions in each topic
+func rangeAssign(subscribers map[string][]string, partitionCounts map[string]int) map[string]map[string][]int32 {
+	assignments := make(map[string]map[string][]int32)
+	for topic, members := range subscribers {
+		sort.Strings(members)
+
+		count := partitionCounts[topic]
+		per, extra := count/len(members), count%len(members)
+		next := 0
+		for i, member := range members {
+			length := per
+			if i < extra {
+				length++
+			}
+			if length == 0 {
+				continue
+			}
+
+			if assignments[member] == nil {
+				assignments[member] = make(map[string][]int32)
+			}
+			for j := 0; j < length; j++ {
+				assignments[member][topic] = append(assignments[member][topic], int32(next+j))
+			}
+			next += length
+		}
+	}
+
+	return assignments
+}
+
+// sync sends the assignments, if this consumer is the leader, and receives the
+// assignment for this consumer
+func (s *session) sync(assignments map[string]map[string][]int32) error {
+	body := &encoder{}
+	body.putString(s.group)
+	body.putInt32(s.generation)
+	body.putString(s.consumer.memberID)
+	body.putArrayLength(len(assignments))
+	for member, topics := range assignments {
+		assignment := &encoder{}
+		assignment.putInt16(0)
+		assignment.putArrayLength(len(topics))
+		for topic, partitions := range topics {
+			assignment.putString(topic)
+			assignment.putInt32s(partitions)
+		}
+		assignment.putBytes(nil)
+
+		body.putString(member)
+		body.putBytes(assignment.data)
+	}
+
+	response, err := s.coordinator.request(apiSyncGroup, 1, body, rebalanceTimeout+requestTimeout)
+	if err != nil {
+		return err
+	}
+
+	response.getInt32()
+	code := response.getInt16()
+	assignment := &decoder{data: response.getBytes()}
+	if response.err != nil {
+		return response.err
+	}
+	if err = errorCode(code); err != nil {
+		return fmt.Errorf("failed to sync group: %s", err)
+	}
+
+	s.partitions = nil
+	if len(assignment.data) != 0 {
+		assignment.getInt16()
+		for i, length := 0, assignment.getArrayLength(); i < length; i++ {
+			topic := assignment.getString()
+			for _, index := range assignment.getInt32s() {
+				s.partitions = append(s.partitions, &partition{topic: topic, partition: index, leader: -1})
+			}
+		}
+		if assignment.err != nil {
+			return assignment.err
+		}
+	}
+
+	log.Notice("[%s] Joined group generation %d with %d assigned partitions", s.group, s.generation, len(s.partitions))
+
+	return s.refreshMetadata(s.consumer.kafkaConfig.Topics)
+}
+
+// partitionsByTopic groups the given partitions by topic
+func partitionsByTopic(partitions []*partition) map[string][]*partition {
+	ret := make(map[string][]*partition)
+	for _, p := range partitions {
+		ret[p.topic] = append(ret[p.topic], p)
+	}
+	return ret
+}
+
+// fetchOffsets loads the committed offsets of the assigned partitions,
+// resetting any partitions without one to the configured start position
+func (s *session) fetchOffsets() error {
+	if len(s.partitions) == 0 {
+		return nil
+	}
+
+	byTopic := partitionsByTopic(s.partitions)
+
+	body := &encoder{}
+	body.putString(s.group)
+	body.putArrayLength(len(byTopic))
+	for topic, partitions := range byTopic {
+		body.putString(topic)
+		body.putArrayLength(len(partitions))
+		for _, p := range partitions {
+			body.putInt32(p.partition)
+		}
+	}
+
+	response, err := s.coordinator.request(apiOffsetFetch, 2, body, requestTimeout)
+	if err != nil {
+		return err
+	}
+
+	offsets := make(map[string]map[int32]int64)
+	for i, length := 0, response.getArrayLength(); i < length; i++ {
+		topic := response.getString()
+		offsets[topic] = make(map[int32]int64)
+		for j, count := 0, response.getArrayLength(); j < count; j++ {
+			index := response.getInt32()
+			offset := response.getInt64()
+			response.getString()
+			if code := response.getInt16(); code != 0 {
+				return fmt.Errorf("failed to fetch committed offset for %s[%d]: %s", topic, index, kafkaError(code))
+			}
+			offsets[topic][index] = offset
+		}
+	}
+	code := response.getInt16()
+	if response.err != nil {
+		return response.err
+	}
+	if err = errorCode(code); err != nil {
+		return fmt.Errorf("failed to fetch committed offsets: %s", err)
+	}
+
+	var reset []*partition
+	for _, p := range s.partitions {
+		if offset, ok := offsets[p.topic][p.partition]; ok && offset >= 0 {
+			p.start(offset)
+		} else {
+			reset = append(reset, p)
+		}
+	}
+
+	return s.resetOffsets(reset)
+}
+
+// resetOffsets sets the offset of the given partitions to the beginning or
+// end, depending on the configured start position
+func (s *session) resetOffsets(partitions []*partition) error {
+	timestamp := int64(-1)
+	if s.consumer.kafkaConfig.StartPosition == "beginning" {
+		timestamp = -2
+	}
+
+	byLeader := make(map[int32][]*partition)
+	for _, p := range partitions {
+		byLeader[p.leader] = append(byLeader[p.leader], p)
+	}
+
+	for leader, partitions := range byLeader {
+		b, err := s.broker(leader)
+		if err != nil {
+			return err
+		}
+
+		byTopic := partitionsByTopic(partitions)
+
+		body := &encoder{}
+		body.putInt32(-1)
+		body.putArrayLength(len(byTopic))
+		for topic, topicPartitions := range byTopic {
+			body.putString(topic)
+			body.putArrayLength(len(topicPartitions))
+			for _, p := range topicPartitions {
+				body.putInt32(p.partition)
+				body.putInt64(timestamp)
+			}
+		}
+
+		response, err := b.request(apiListOffsets, 1, body, requestTimeout)
+		if err != nil {
+			s.dropBroker(leader)
+			return err
+		}
+
+		offsets := make(map[string]map[int32]int64)
+		for i, length := 0, response.getArrayLength(); i < length; i++ {
+			topic := response.getString()
+			offsets[topic] = make(map[int32]int64)
+			for j, count := 0, response.getArrayLength(); j < count; j++ {
+				index := response.getInt32()
+				code := response.getInt16()
+				response.getInt64()
+				offset := response.getInt64()
+				if code != 0 {
+					return fmt.Errorf("failed to list offsets for %s[%d]: %s", topic, index, kafkaError(code))
+				}
+				offsets[topic][index] = offset
+			}
+		}
+		if response.err != nil {
+			return response.err
+		}
+
+		for _, p := range partitions {
+			offset, ok := offsets[p.topic][p.partition]
+			if !ok {
+				return fmt.Errorf("no offset returned for %s[%d]", p.topic, p.partition)
+			}
+			log.Info("[%s] Starting %s[%d] at offset %d", s.group, p.topic, p.partition, offset)
+			p.start(offset)
+		}
+	}
+
+	return nil
+}
+
+// heartbeat sends heartbeats to the coordinator until stopped, signalling a
+// rebalance if the coordinator reports one or stops responding
+func (s *session) heartbeat(stop <-chan struct{}) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		body := &encoder{}
+		body.putString(s.group)
+		body.putInt32(s.generation)
+		body.putString(s.consumer.memberID)
+
+		response, err := s.coordinator.request(apiHeartbeat, 1, body, requestTimeout)
+		if err == nil {
+			response.getInt32()
+			code := response.getInt16()
+			if err = response.err; err == nil {
+				err = errorCode(code)
+			}
+		}
+
+		if err != nil {
+			if err != errRebalanceInProgress {
+				log.Warning("[%s] Heartbeat failed: %s", s.group, err)
+			}
+			s.rebalanceOnce.Do(func() {
+				close(s.rebalance)
+			})
+			return
+		}
+	}
+}
+
+// commit commits the acknowledged offsets of the assigned partitions
+func (s *session) commit() error {
+	var pending []*partition
+	offsets := make(map[*partition]int64)
+	for _, p := range s.partitions {
+		if offset := p.ackedOffset(); offset > p.committed {
+			pending = append(pending, p)
+			offsets[p] = offset
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	byTopic := partitionsByTopic(pending)
+
+	body := &encoder{}
+	body.putString(s.group)
+	body.putInt32(s.generation)
+	body.putString(s.consumer.memberID)
+	body.putInt64(-1)
+	body.putArrayLength(len(byTopic))
+	for topic, partitions := range byTopic {
+		body.putString(topic)
+		body.putArrayLength(len(partitions))
+		for _, p := range partitions {
+			body.putInt32(p.partition)
+			body.putInt64(offsets[p])
+			body.putString("")
+		}
+	}
+
+	response, err := s.coordinator.request(apiOffsetCommit, 2, body, requestTimeout)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for i, length := 0, response.getArrayLength(); i < length; i++ {
+		response.getString()
+		for j, count := 0, response.getArrayLength(); j < count; j++ {
+			response.getInt32()
+			if code := response.getInt16(); code != 0 && firstErr == nil {
+				firstErr = kafkaError(code)
+			}
+		}
+	}
+	if response.err != nil {
+		return response.err
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	for _, p := range pending {
+		p.committed = offsets[p]
+	}
+
+	log.Debug("[%s] Committed offsets for %d partitions", s.group, len(pending))
+	return nil
+}
+
+// leave leaves the group so that its partitions are reassigned immediately
+// rather than after the session timeout
+func (s *session) leave() {
+	if s.coordinator == nil || s.consumer.memberID == "" {
+		return
+	}
+
+	body := &encoder{}
+	body.putString(s.group)
+	body.putString(s.consumer.memberID)
+	if _, err := s.coordinator.request(apiLeaveGroup, 1, body, requestTimeout); err != nil {
+		log.Warning("[%s] Failed to leave group: %s", s.group, err)
+	}
+
+	s.consumer.memberID = ""
+}
+
+// fetchLoop fetches records from the leaders of the assigned partitions and
+// passes them to the spooler, committing acknowledged offsets periodically
+func (s *session) fetchLoop() error {
+	commitTicker := time.NewTicker(s.consumer.kafkaConfig.CommitInterval)
+	defer commitTicker.Stop()
+
+	for {
+		select {
+		case <-s.consumer.shutdown:
+			return errShutdown
+		case <-s.rebalance:
+			return errRebalance
+		case <-commitTicker.C:
+			if err := s.commit(); err != nil {
+				log.Warning("[%s] Failed to commit offsets: %s", s.group, err)
+			}
+		default:
+		}
+
+		if len(s.partitions) == 0 {
+			// Nothing assigned, so just wait for the next rebalance
+			select {
+			case <-s.consumer.shutdown:
+				return errShutdown
+			case <-s.rebalance:
+				return errRebalance
+			}
+		}
+
+		byLeader := make(map[int32][]*partition)
+		for _, p := range s.partitions {
+			byLeader[p.leader] = append(byLeader[p.leader], p)
+		}
+
+		for leader, partitions := range byLeader {
+			if err := s.fetch(leader, partitions); err != nil {
+				if err == errShutdown || err == errRebalance {
+					return err
+				}
+
+				log.Warning("[%s] Fetch from broker %d failed, refreshing metadata: %s", s.group, leader, err)
+				s.dropBroker(leader)
+				if err = s.refreshMetadata(s.consumer.kafkaConfig.Topics); err != nil {
+					return err
+				}
+
+				// Leader elections take a moment, so avoid refreshing repeatedly
+				select {
+				case <-s.consumer.shutdown:
+					return errShutdown
+				case <-s.rebalance:
+					return errRebalance
+				case <-time.After(time.Second):
+				}
+				break
+			}
+		}
+	}
+}
+
+// fetch fetches records for the given partitions from their leader
+func (s *session) fetch(leader int32, partitions []*partition) error {
+	b, err := s.broker(leader)
+	if err != nil {
+		return err
+	}
+
+	byTopic := partitionsByTopic(partitions)
+
+	body := &encoder{}
+	body.putInt32(-1)
+	body.putInt32(int32(fetchMaxWait / time.Millisecond))
+	body.putInt32(1)
+	body.putInt32(fetchMaxBytes)
+	body.putInt8(0)
+	body.putArrayLength(len(byTopic))
+	for topic, topicPartitions := range byTopic {
+		body.putString(topic)
+		body.putArrayLength(len(topicPartitions))
+		for _, p := range topicPartitions {
+			body.putInt32(p.partition)
+			body.putInt64(p.offset)
+			body.putInt32(fetchPartitionMaxBytes)
+		}
+	}
+
+	response, err := b.request(apiFetch, 4, body, fetchMaxWait+requestTimeout)
+	if err != nil {
+		return err
+	}
+
+	var reset []*partition
+	var leaderErr error
+
+	response.getInt32()
+	for i, length := 0, response.getArrayLength(); i < length; i++ {
+		topic := response.getString()
+		for j, count := 0, response.getArrayLength(); j < count; j++ {
+			index := response.getInt32()
+			code := response.getInt16()
+			response.getInt64()
+			response.getInt64()
+			for k, aborted := 0, response.getArrayLength(); k < aborted; k++ {
+				response.getInt64()
+				response.getInt64()
+			}
+			data := response.getBytes()
+			if response.err != nil {
+				return response.err
+			}
+
+			p := s.findPartition(topic, index)
+			if p == nil {
+				continue
+			}
+
+			switch kafkaError(code) {
+			case 0:
+			case errOffsetOutOfRange:
+				log.Warning("[%s] Offset %d for %s[%d] is out of range, resetting to the %s", s.group, p.offset, topic, index, s.consumer.kafkaConfig.StartPosition)
+				reset = append(reset, p)
+				continue
+			default:
+				leaderErr = fmt.Errorf("%s[%d]: %s", topic, index, kafkaError(code))
+				continue
+			}
+
+			set, err := decodeRecords(data, p.offset)
+			if err != nil {
+				return fmt.Errorf("%s[%d]: %s", topic, index, err)
+			}
+
+			for _, batch := range set.unsupported {
+				log.Warning("[%s] Skipping record batch at offset %d of %s[%d] as it uses unsupported compression codec %s", s.group, batch.offset, topic, index, compressionCodecName(batch.codec))
+				s.consumer.skipBatch()
+			}
+
+			if err = s.ship(p, set.records, set.next); err != nil {
+				return err
+			}
+		}
+	}
+	if response.err != nil {
+		return response.err
+	}
+
+	if len(reset) != 0 {
+		if err = s.resetOffsets(reset); err != nil {
+			return err
+		}
+	}
+
+	return leaderErr
+}
+
+// findPartition returns the assigned partition with the given topic and index
+func (s *session) findPartition(topic string, index int32) *partition {
+	for _, p := range s.partitions {
+		if p.topic == topic && p.partition == index {
+			return p
+		}
+	}
+	return nil
+}
+
+// ship runs the processors over the records of a partition and passes the
+// resulting events to the spooler, moving the partition on to the given next
+// offset. Events are acknowledged with the offset of the next record, which is
+// the offset that is committed for them
+func (s *session) ship(p *partition, records []record, next int64) error {
+	if len(records) != 0 && records[len(records)-1].offset >= next {
+		next = records[len(records)-1].offset + 1
+	}
+	if next <= p.offset {
+		return nil
+	}
+
+	p.offset = next
+
+	descs := make([]*core.EventDescriptor, 0, len(records))
+	for _, rec := range records {
+		event := s.processEvent(p, rec)
+		if event == nil {
+			continue
+		}
+
+		descs = append(descs, &core.EventDescriptor{
+			Stream: p,
+			Offset: rec.offset + 1,
+			Event:  event,
+		})
+	}
+
+	if len(descs) == 0 {
+		p.markSkipped(next)
+		return nil
+	}
+
+	// Records dropped after the last event we forward, and any control or
+	// skipped batches, are acknowledged with it
+	descs[len(descs)-1].Offset = next
+
+	for _, desc := range descs {
+		p.markSent(desc.Offset)
+
+		select {
+		case <-s.consumer.shutdown:
+			return errShutdown
+		case <-s.rebalance:
+			return errRebalance
+		case s.consumer.output <- desc:
+		}
+	}
+
+	return nil
+}
+
+// processEvent creates the event for a record and runs the processors over it,
+// returning the encoded event or nil if a processor dropped it
+func (s *session) processEvent(p *partition, rec record) []byte {
+	event := newEvent(p, rec, s.consumer.messageField)
+
+	for _, processor := range s.processors {
+		if event = processor.Process(event); event == nil {
+			return nil
+		}
+	}
+
+	encoded, err := event.Encode(s.consumer.messageField)
+	if err != nil {
+		log.Warning("[%s] Skipping record at %s[%d] offset %d as it could not be encoded: %s", s.group, p.topic, p.partition, rec.offset, err)
+		return nil
+	}
+
+	return encoded
+}
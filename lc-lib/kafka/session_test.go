@@ -0,0 +1,217 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kafka
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func TestRangeAssign(t *testing.T) {
+	assignments := rangeAssign(map[string][]string{
+		"logs":    {"c2", "c1", "c3"},
+		"metrics": {"c1", "c2"},
+	}, map[string]int{
+		"logs":    4,
+		"metrics": 1,
+	})
+
+	expected := map[string]map[string][]int32{
+		"c1": {"logs": {0, 1}, "metrics": {0}},
+		"c2": {"logs": {2}},
+		"c3": {"logs": {3}},
+	}
+
+	if len(assignments) != len(expected) {
+		t.Fatalf("Incorrect assignments: %v", assignments)
+	}
+	for member, topics := range expected {
+		for topic, partitions := range topics {
+			assigned := assignments[member][topic]
+			if len(assigned) != len(partitions) {
+				t.Errorf("Incorrect assignment for %s %s: %v", member, topic, assigned)
+				continue
+			}
+			for i := range partitions {
+				if assigned[i] != partitions[i] {
+					t.Errorf("Incorrect assignment for %s %s: %v", member, topic, assigned)
+				}
+			}
+		}
+		if len(assignments[member]) != len(topics) {
+			t.Errorf("Incorrect topics for %s: %v", member, assignments[member])
+		}
+	}
+}
+
+func TestScram(t *testing.T) {
+	// Test vector from RFC 7677
+	client := newScramClient(sha256.New, "user", "pencil")
+	client.nonce = "rOprNGfwEbeRWgbNEkqO"
+
+	if first := client.firstMessage(); first != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Errorf("Incorrect first message: %s", first)
+	}
+
+	final, err := client.finalMessage("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if final != "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=" {
+		t.Errorf("Incorrect final message: %s", final)
+	}
+
+	if err = client.verifyServer("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Errorf("Server signature was not verified: %s", err)
+	}
+	if err = client.verifyServer("v=AAAATRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err == nil {
+		t.Error("Incorrect server signature was verified")
+	}
+}
+
+func createTestSession(output chan *core.EventDescriptor) *session {
+	consumer := &Consumer{
+		kafkaConfig:  &config.Kafka{Group: "test"},
+		messageField: "message",
+		output:       output,
+		shutdown:     make(chan struct{}),
+	}
+
+	return newSession(consumer)
+}
+
+func receiveTestEvent(t *testing.T, output chan *core.EventDescriptor) *core.EventDescriptor {
+	select {
+	case desc := <-output:
+		return desc
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+	return nil
+}
+
+func TestShip(t *testing.T) {
+	output := make(chan *core.EventDescriptor, 10)
+	s := createTestSession(output)
+
+	p := &partition{topic: "logs", partition: 2}
+	p.start(10)
+
+	records := []record{
+		{offset: 10, value: []byte(`{"message":"json","level":"info"}`), timestamp: time.Unix(1500000000, 0)},
+		{offset: 11, key: []byte("key"), value: []byte("plain text")},
+	}
+	if err := s.ship(p, records, 12); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	desc := receiveTestEvent(t, output)
+	event, _ := core.DecodeEvent(desc.Event)
+	if event["level"] != "info" || event["@timestamp"] != "2017-07-14T02:40:00Z" || desc.Offset != 11 {
+		t.Errorf("Unexpected event: %s (offset %d)", desc.Event, desc.Offset)
+	}
+
+	desc = receiveTestEvent(t, output)
+	event, _ = core.DecodeEvent(desc.Event)
+	metadata, _ := event["kafka"].(map[string]interface{})
+	if event["message"] != "plain text" || metadata["topic"] != "logs" || metadata["key"] != "key" || desc.Offset != 12 {
+		t.Errorf("Unexpected event: %s (offset %d)", desc.Event, desc.Offset)
+	}
+
+	if p.offset != 12 {
+		t.Errorf("Incorrect next fetch offset: %d", p.offset)
+	}
+}
+
+func TestShipNoRecords(t *testing.T) {
+	output := make(chan *core.EventDescriptor, 10)
+	s := createTestSession(output)
+
+	p := &partition{topic: "logs", partition: 2}
+	p.start(10)
+
+	// A fetch containing only control or skipped batches still moves on
+	if err := s.ship(p, nil, 13); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if p.offset != 13 {
+		t.Errorf("Incorrect next fetch offset: %d", p.offset)
+	}
+	if offset := p.ackedOffset(); offset != 13 {
+		t.Errorf("Skipped offsets were not acknowledged: %d", offset)
+	}
+
+	select {
+	case desc := <-output:
+		t.Errorf("Unexpected event: %s", desc.Event)
+	default:
+	}
+}
+
+func TestShipSkippedAfterEvent(t *testing.T) {
+	output := make(chan *core.EventDescriptor, 10)
+	s := createTestSession(output)
+
+	p := &partition{topic: "logs", partition: 2}
+	p.start(10)
+
+	// A control batch following the last record is acknowledged with it
+	if err := s.ship(p, []record{{offset: 10, value: []byte("one")}}, 12); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if desc := receiveTestEvent(t, output); desc.Offset != 12 {
+		t.Errorf("Incorrect event offset: %d", desc.Offset)
+	}
+	if p.offset != 12 {
+		t.Errorf("Incorrect next fetch offset: %d", p.offset)
+	}
+}
+
+func TestPartitionAck(t *testing.T) {
+	p := &partition{topic: "logs"}
+	p.start(10)
+
+	// Nothing outstanding so dropped records are acknowledged immediately
+	p.markSkipped(12)
+	if offset := p.ackedOffset(); offset != 12 {
+		t.Errorf("Dropped records were not acknowledged: %d", offset)
+	}
+
+	p.markSent(13)
+	p.markSent(14)
+	p.markSkipped(16)
+	if offset := p.ackedOffset(); offset != 12 {
+		t.Errorf("Dropped records were acknowledged before earlier events: %d", offset)
+	}
+
+	p.Ack(13)
+	if offset := p.ackedOffset(); offset != 13 {
+		t.Errorf("Incorrect acknowledged offset: %d", offset)
+	}
+
+	// Dropped records following the last event are acknowledged with it
+	p.Ack(14)
+	if offset := p.ackedOffset(); offset != 16 {
+		t.Errorf("Dropped records were not acknowledged with last event: %d", offset)
+	}
+}
@@ -17,6 +17,8 @@
 package payload
 
 import (
+	"time"
+
 	"github.com/driskell/log-courier/lc-lib/core"
 	"github.com/driskell/log-courier/lc-lib/internallist"
 )
@@ -33,6 +35,8 @@ type Payload struct {
 	payload      []byte
 
 	Nonce         string
+	Metadata      string
+	Created       time.Time
 	Resending     bool
 	Element       internallist.Element
 	ResendElement internallist.Element
@@ -43,6 +47,7 @@ func NewPayload(events []*core.EventDescriptor) *Payload {
 	ret := &Payload{
 		events:      events,
 		sequenceLen: len(events),
+		Created:     time.Now(),
 	}
 
 	ret.Init()
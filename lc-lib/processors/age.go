@@ -0,0 +1,105 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultAgeAction string = "drop"
+	defaultAgeTag    string = "_stale"
+)
+
+// ProcessorAgeFactory holds the configuration for an age processor
+type ProcessorAgeFactory struct {
+	Action string        `config:"action"`
+	MaxAge time.Duration `config:"max age"`
+}
+
+// ProcessorAge is an instance of an age processor
+type ProcessorAge struct {
+	config *ProcessorAgeFactory
+	stale  uint64
+}
+
+// NewAgeProcessorFactory creates a new ProcessorAgeFactory for a processor
+// definition in the configuration file
+func NewAgeProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	result := &ProcessorAgeFactory{}
+	if err := config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.MaxAge <= 0 {
+		return nil, errors.New("Age processor max age must be specified and positive.")
+	}
+
+	if result.Action != "drop" && result.Action != "tag" {
+		return nil, errors.New("Age processor action must be \"drop\" or \"tag\".")
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for an age processor
+func (f *ProcessorAgeFactory) InitDefaults() {
+	f.Action = defaultAgeAction
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorAgeFactory) NewProcessor() Processor {
+	return &ProcessorAge{
+		config: f,
+	}
+}
+
+// Process drops the event, or tags it with "_stale", if its timestamp is older
+// than the maximum age. Events without a timestamp are left unchanged
+func (p *ProcessorAge) Process(event core.Event) core.Event {
+	timestamp, ok := event.Timestamp()
+	if !ok || time.Since(timestamp) <= p.config.MaxAge {
+		return event
+	}
+
+	atomic.AddUint64(&p.stale, 1)
+
+	if p.config.Action == "tag" {
+		event.AddTags(defaultAgeTag)
+		return event
+	}
+
+	return nil
+}
+
+// APIEncodable returns an admin API entry with the processor status
+func (p *ProcessorAge) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("stale_events", admin.APINumber(atomic.LoadUint64(&p.stale)))
+	return api
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("age", NewAgeProcessorFactory)
+}
@@ -0,0 +1,76 @@
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createAgeProcessor(unused map[string]interface{}, t *testing.T) Processor {
+	factory, err := NewAgeProcessorFactory(config.NewConfig(), "", unused, "age", "message")
+	if err != nil {
+		t.Logf("Failed to create age processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func checkStaleEvents(t *testing.T, processor Processor, expected uint64) {
+	if _, ok := processor.(APIProcessor); !ok {
+		t.Error("Age processor does not report to the API")
+	}
+
+	if stale := processor.(*ProcessorAge).stale; stale != expected {
+		t.Errorf("Incorrect stale events: %d != %d", stale, expected)
+	}
+}
+
+func TestAgeDrop(t *testing.T) {
+	processor := createAgeProcessor(map[string]interface{}{
+		"max age": "1h",
+	}, t)
+
+	if event := processor.Process(core.Event{"@timestamp": time.Now().Add(-2 * time.Hour)}); event != nil {
+		t.Errorf("Stale event was not dropped: %v", event)
+	}
+
+	if event := processor.Process(core.Event{"@timestamp": time.Now().Add(-time.Minute).Format(time.RFC3339)}); event == nil {
+		t.Error("Recent event was dropped")
+	}
+
+	if event := processor.Process(core.Event{"message": "no timestamp"}); event == nil {
+		t.Error("Event without a timestamp was dropped")
+	}
+
+	checkStaleEvents(t, processor, 1)
+}
+
+func TestAgeTag(t *testing.T) {
+	processor := createAgeProcessor(map[string]interface{}{
+		"max age": "1h",
+		"action":  "tag",
+	}, t)
+
+	event := processor.Process(core.Event{"@timestamp": "2017-03-04T10:00:00Z"})
+
+	tags, ok := event["tags"].([]string)
+	if !ok || len(tags) != 1 || tags[0] != "_stale" {
+		t.Errorf("Stale event was not tagged: %v", event["tags"])
+	}
+
+	checkStaleEvents(t, processor, 1)
+}
+
+func TestAgeInvalid(t *testing.T) {
+	for _, unused := range []map[string]interface{}{
+		{},
+		{"max age": "1h", "action": "ignore"},
+	} {
+		if _, err := NewAgeProcessorFactory(config.NewConfig(), "", unused, "age", "message"); err == nil {
+			t.Errorf("Invalid configuration was accepted: %v", unused)
+		}
+	}
+}
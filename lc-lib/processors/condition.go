@@ -0,0 +1,398 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// condition is a compiled boolean expression over the fields of an event
+type condition interface {
+	evaluate(event core.Event) bool
+}
+
+// conditionAnd is true if both sides are true
+type conditionAnd struct {
+	left, right condition
+}
+
+func (c *conditionAnd) evaluate(event core.Event) bool {
+	return c.left.evaluate(event) && c.right.evaluate(event)
+}
+
+// conditionOr is true if either side is true
+type conditionOr struct {
+	left, right condition
+}
+
+func (c *conditionOr) evaluate(event core.Event) bool {
+	return c.left.evaluate(event) || c.right.evaluate(event)
+}
+
+// conditionNot negates a condition
+type conditionNot struct {
+	inner condition
+}
+
+func (c *conditionNot) evaluate(event core.Event) bool {
+	return !c.inner.evaluate(event)
+}
+
+// conditionExists is true if the field exists and is not null
+type conditionExists struct {
+	field string
+}
+
+func (c *conditionExists) evaluate(event core.Event) bool {
+	value, ok := event.GetPath(c.field)
+	return ok && value != nil
+}
+
+// conditionEquals is true if the field is a string or number equal to the
+// value. When both the field and the value are numbers they are compared
+// numerically, so that 200 equals 200.0
+type conditionEquals struct {
+	field    string
+	value    string
+	number   float64
+	isNumber bool
+}
+
+func newConditionEquals(field string, value string) *conditionEquals {
+	ret := &conditionEquals{field: field, value: value}
+	ret.number, ret.isNumber = conditionNumber(value)
+	return ret
+}
+
+func (c *conditionEquals) evaluate(event core.Event) bool {
+	value, ok := conditionString(event, c.field)
+	if !ok {
+		return false
+	}
+
+	if c.isNumber {
+		if number, ok := conditionNumber(value); ok {
+			return number == c.number
+		}
+	}
+
+	return value == c.value
+}
+
+// conditionNumber parses a value as a number, returning false if it is not one
+func conditionNumber(value string) (float64, bool) {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, false
+	}
+	return number, true
+}
+
+// conditionMatch is true if the field is a string or number that matches the
+// regular expression
+type conditionMatch struct {
+	field   string
+	matcher *regexp.Regexp
+}
+
+func (c *conditionMatch) evaluate(event core.Event) bool {
+	value, ok := conditionString(event, c.field)
+	return ok && c.matcher.MatchString(value)
+}
+
+// conditionString returns the value of a field as a string, if it is a string
+// or number, so that it can be compared
+func conditionString(event core.Event, field string) (string, bool) {
+	value, _ := event.GetPath(field)
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case int:
+		return strconv.Itoa(value), true
+	case int64:
+		return strconv.FormatInt(value, 10), true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(value), true
+	}
+	return "", false
+}
+
+// conditionTokenKind identifies the type of a token in a condition
+type conditionTokenKind int
+
+const (
+	conditionTokenEnd conditionTokenKind = iota
+	conditionTokenWord
+	conditionTokenString
+	conditionTokenOperator
+)
+
+// conditionToken is a single token of a condition
+type conditionToken struct {
+	kind  conditionTokenKind
+	value string
+	pos   int
+}
+
+// conditionOperators are the operators, longest first so that "!=" is not read
+// as "!" followed by "="
+var conditionOperators = []string{"==", "!=", "=~", "!~", "&&", "||", "!", "(", ")"}
+
+// conditionParser compiles a condition using recursive descent, where "or"
+// binds less tightly than "and", which binds less tightly than "not"
+type conditionParser struct {
+	text   string
+	tokens []conditionToken
+	pos    int
+}
+
+// compileCondition compiles the text of a condition, such as:
+//
+//	type == "nginx" and (not tags or message =~ "^GET ")
+func compileCondition(text string) (condition, error) {
+	parser := &conditionParser{text: text}
+	if err := parser.tokenize(); err != nil {
+		return nil, err
+	}
+
+	result, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if token := parser.peek(); token.kind != conditionTokenEnd {
+		return nil, fmt.Errorf("unexpected \"%s\" at position %d", token.value, token.pos+1)
+	}
+
+	return result, nil
+}
+
+// tokenize splits the text into tokens. Words are field names, numbers and the
+// keywords "and", "or" and "not", and strings are double quoted with the same
+// escapes as Go strings
+func (p *conditionParser) tokenize() error {
+	text := p.text
+	for pos := 0; pos < len(text); {
+		switch char := text[pos]; {
+		case char == ' ' || char == '\t' || char == '\r' || char == '\n':
+			pos++
+			continue
+		case char == '"':
+			end := pos + 1
+			for ; end < len(text) && text[end] != '"'; end++ {
+				if text[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(text) {
+				return fmt.Errorf("unterminated string at position %d", pos+1)
+			}
+			value, err := strconv.Unquote(text[pos : end+1])
+			if err != nil {
+				return fmt.Errorf("invalid string at position %d: %s", pos+1, err)
+			}
+			p.tokens = append(p.tokens, conditionToken{kind: conditionTokenString, value: value, pos: pos})
+			pos = end + 1
+			continue
+		case isConditionWordChar(char):
+			end := pos
+			for end < len(text) && isConditionWordChar(text[end]) {
+				end++
+			}
+			p.tokens = append(p.tokens, conditionToken{kind: conditionTokenWord, value: text[pos:end], pos: pos})
+			pos = end
+			continue
+		}
+
+		found := false
+		for _, operator := range conditionOperators {
+			if strings.HasPrefix(text[pos:], operator) {
+				p.tokens = append(p.tokens, conditionToken{kind: conditionTokenOperator, value: operator, pos: pos})
+				pos += len(operator)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unexpected \"%c\" at position %d", text[pos], pos+1)
+		}
+	}
+
+	p.tokens = append(p.tokens, conditionToken{kind: conditionTokenEnd, value: "end of condition", pos: len(text)})
+	return nil
+}
+
+// isConditionWordChar returns true if the character can be part of a field
+// name or number
+func isConditionWordChar(char byte) bool {
+	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9') ||
+		char == '_' || char == '.' || char == '@' || char == '-' || char == '+'
+}
+
+// peek returns the next token without consuming it
+func (p *conditionParser) peek() conditionToken {
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the next token
+func (p *conditionParser) next() conditionToken {
+	token := p.tokens[p.pos]
+	if token.kind != conditionTokenEnd {
+		p.pos++
+	}
+	return token
+}
+
+// accept consumes the next token if it is one of the given keywords or
+// operators
+func (p *conditionParser) accept(values ...string) bool {
+	token := p.peek()
+	if token.kind != conditionTokenWord && token.kind != conditionTokenOperator {
+		return false
+	}
+	for _, value := range values {
+		if token.value == value {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *conditionParser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("or", "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &conditionOr{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("and", "&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &conditionAnd{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *conditionParser) parseNot() (condition, error) {
+	if p.accept("not", "!") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &conditionNot{inner: inner}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+// parsePrimary parses a parenthesised condition or a comparison of a field. A
+// field on its own tests whether it exists
+func (p *conditionParser) parsePrimary() (condition, error) {
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			token := p.peek()
+			return nil, fmt.Errorf("expected \")\" at position %d", token.pos+1)
+		}
+		return inner, nil
+	}
+
+	token := p.next()
+	if token.kind != conditionTokenWord || token.value == "and" || token.value == "or" || token.value == "not" {
+		return nil, fmt.Errorf("expected a field name at position %d, found \"%s\"", token.pos+1, token.value)
+	}
+	field := token.value
+
+	operator := p.peek()
+	if operator.kind != conditionTokenOperator {
+		return &conditionExists{field: field}, nil
+	}
+
+	switch operator.value {
+	case "==", "!=", "=~", "!~":
+		p.next()
+	default:
+		return &conditionExists{field: field}, nil
+	}
+
+	value := p.next()
+	if value.kind != conditionTokenString && value.kind != conditionTokenWord {
+		return nil, fmt.Errorf("expected a value at position %d, found \"%s\"", value.pos+1, value.value)
+	}
+
+	var result condition
+	switch operator.value {
+	case "==", "!=":
+		if value.kind == conditionTokenWord {
+			// Unquoted values must be numbers or booleans, anything else is more
+			// likely a mistake such as a field name
+			if _, err := strconv.ParseFloat(value.value, 64); err != nil && value.value != "true" && value.value != "false" {
+				return nil, fmt.Errorf("expected a quoted string or number at position %d, found \"%s\"", value.pos+1, value.value)
+			}
+		}
+		result = newConditionEquals(field, value.value)
+	default:
+		if value.kind != conditionTokenString {
+			return nil, fmt.Errorf("expected a quoted regular expression at position %d, found \"%s\"", value.pos+1, value.value)
+		}
+		matcher, err := regexp.Compile(value.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression at position %d: %s", value.pos+1, err)
+		}
+		result = &conditionMatch{field: field, matcher: matcher}
+	}
+
+	if operator.value[0] == '!' {
+		result = &conditionNot{inner: result}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultDateField     string = "timestamp"
+	defaultDateOnFailure string = "_dateparsefailure"
+	defaultDateTarget    string = "@timestamp"
+
+	dateFormatUnix   = "UNIX"
+	dateFormatUnixMs = "UNIX_MS"
+)
+
+// dateNamedFormats are the layouts of commonly used timestamp formats that can
+// be given by name instead of as a layout
+var dateNamedFormats = map[string]string{
+	"ANSIC":    time.ANSIC,
+	"httpd":    "02/Jan/2006:15:04:05 -0700",
+	"ISO8601":  "2006-01-02T15:04:05.999999999Z07:00",
+	"RFC1123":  time.RFC1123,
+	"RFC1123Z": time.RFC1123Z,
+	"RFC3339":  time.RFC3339Nano,
+	"RFC822":   time.RFC822,
+	"RFC822Z":  time.RFC822Z,
+	"syslog":   "Jan _2 15:04:05",
+	"UnixDate": time.UnixDate,
+}
+
+// ProcessorDateFactory holds the configuration for a date processor
+type ProcessorDateFactory struct {
+	Field     string   `config:"field"`
+	Formats   []string `config:"formats"`
+	OnFailure string   `config:"on failure"`
+	Target    string   `config:"target"`
+	Timezone  string   `config:"timezone"`
+
+	layouts  []string
+	location *time.Location
+}
+
+// ProcessorDate is an instance of a date processor
+type ProcessorDate struct {
+	config *ProcessorDateFactory
+}
+
+// NewDateProcessorFactory creates a new ProcessorDateFactory for a processor
+// definition in the configuration file. The formats are resolved and validated
+// here so that an invalid layout is reported when the configuration is loaded
+func NewDateProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	result := &ProcessorDateFactory{}
+	if err := config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if len(result.Formats) == 0 {
+		return nil, errors.New("Date processor formats must be specified.")
+	}
+
+	for _, format := range result.Formats {
+		layout, err := dateLayout(format)
+		if err != nil {
+			return nil, fmt.Errorf("Date processor format \"%s\" is invalid: %s", format, err)
+		}
+		result.layouts = append(result.layouts, layout)
+	}
+
+	result.location = time.Local
+	if result.Timezone != "" {
+		location, err := time.LoadLocation(result.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("Date processor timezone \"%s\" is invalid: %s", result.Timezone, err)
+		}
+		result.location = location
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for a date processor
+func (f *ProcessorDateFactory) InitDefaults() {
+	f.Field = defaultDateField
+	f.OnFailure = defaultDateOnFailure
+	f.Target = defaultDateTarget
+}
+
+// dateLayout returns the layout for a format, which is either the name of a
+// known format or a layout using the Go reference time. A layout is only
+// accepted if formatting a time with it produces something other than the
+// layout itself, meaning it has at least one element of the reference time, and
+// that result can be parsed again
+func dateLayout(format string) (string, error) {
+	if format == dateFormatUnix || format == dateFormatUnixMs {
+		return format, nil
+	}
+
+	if layout, ok := dateNamedFormats[format]; ok {
+		return layout, nil
+	}
+
+	formatted := time.Date(2017, time.March, 4, 10, 11, 12, 0, time.UTC).Format(format)
+	if formatted == format {
+		return "", errors.New("layout does not contain any elements of the reference time \"Mon Jan 2 15:04:05 MST 2006\"")
+	}
+
+	if _, err := time.Parse(format, formatted); err != nil {
+		return "", err
+	}
+
+	return format, nil
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorDateFactory) NewProcessor() Processor {
+	return &ProcessorDate{
+		config: f,
+	}
+}
+
+// Process parses the field using each format in turn, storing the first
+// successfully parsed time in the target field. If no format matches, or the
+// field is missing, the event is tagged with the "on failure" tag and the
+// target field is left unchanged
+func (p *ProcessorDate) Process(event core.Event) core.Event {
+	value, _ := event.GetPath(p.config.Field)
+
+	var source string
+	switch value := value.(type) {
+	case string:
+		source = value
+	case json.Number:
+		source = value.String()
+	case int64:
+		source = strconv.FormatInt(value, 10)
+	case float64:
+		source = strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		p.fail(event)
+		return event
+	}
+
+	for _, layout := range p.config.layouts {
+		if parsed, ok := p.parse(layout, strings.TrimSpace(source)); ok {
+			// Always UTC, matching the timestamp added by harvesters
+			event.SetPath(p.config.Target, parsed.UTC())
+			return event
+		}
+	}
+
+	p.fail(event)
+	return event
+}
+
+// parse parses the value with the given layout. Layouts without a timezone are
+// in the configured timezone, and layouts without a year, such as syslog
+// timestamps, are given the year that places them closest before now
+func (p *ProcessorDate) parse(layout string, value string) (time.Time, bool) {
+	switch layout {
+	case dateFormatUnix, dateFormatUnixMs:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return time.Time{}, false
+		}
+		if layout == dateFormatUnixMs {
+			number /= 1000
+		}
+		seconds, fraction := math.Modf(number)
+		return time.Unix(int64(seconds), int64(fraction*1e9)), true
+	}
+
+	parsed, err := time.ParseInLocation(layout, value, p.config.location)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	if parsed.Year() == 0 {
+		now := time.Now().In(p.config.location)
+		parsed = parsed.AddDate(now.Year(), 0, 0)
+		// Allow for a little clock difference before assuming the previous year
+		if parsed.Sub(now) > 24*time.Hour {
+			parsed = parsed.AddDate(-1, 0, 0)
+		}
+	}
+
+	return parsed, true
+}
+
+// fail tags the event with the "on failure" tag, if there is one
+func (p *ProcessorDate) fail(event core.Event) {
+	if p.config.OnFailure != "" {
+		event.AddTags(p.config.OnFailure)
+	}
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("date", NewDateProcessorFactory)
+}
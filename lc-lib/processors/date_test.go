@@ -0,0 +1,139 @@
+package processors
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createDateProcessor(unused map[string]interface{}, t *testing.T) Processor {
+	factory, err := NewDateProcessorFactory(config.NewConfig(), "", unused, "date", "message")
+	if err != nil {
+		t.Logf("Failed to create date processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func checkDateTimestamp(t *testing.T, event core.Event, expected time.Time) {
+	timestamp, ok := event["@timestamp"].(time.Time)
+	if !ok {
+		t.Errorf("Timestamp was not set: %v", event)
+		return
+	}
+
+	if !timestamp.Equal(expected) || timestamp.Location() != time.UTC {
+		t.Errorf("Unexpected timestamp: %s != %s", timestamp, expected)
+	}
+
+	if _, ok := event["tags"]; ok {
+		t.Errorf("Unexpected tags: %v", event["tags"])
+	}
+}
+
+func TestDateNamedFormat(t *testing.T) {
+	processor := createDateProcessor(map[string]interface{}{
+		"formats": []interface{}{"httpd"},
+	}, t)
+
+	event := processor.Process(core.Event{"timestamp": "04/Mar/2017:10:00:00 +0100"})
+	checkDateTimestamp(t, event, time.Date(2017, time.March, 4, 9, 0, 0, 0, time.UTC))
+}
+
+func TestDateLayout(t *testing.T) {
+	processor := createDateProcessor(map[string]interface{}{
+		"field":    "time",
+		"formats":  []interface{}{"2006-01-02 15:04:05.000"},
+		"timezone": "America/New_York",
+	}, t)
+
+	event := processor.Process(core.Event{"time": "2017-03-04 10:00:00.123"})
+	checkDateTimestamp(t, event, time.Date(2017, time.March, 4, 15, 0, 0, 123000000, time.UTC))
+}
+
+func TestDateMultipleFormats(t *testing.T) {
+	processor := createDateProcessor(map[string]interface{}{
+		"formats":  []interface{}{"RFC3339", "2006/01/02 15:04:05"},
+		"timezone": "UTC",
+	}, t)
+
+	event := processor.Process(core.Event{"timestamp": "2017/03/04 10:00:00"})
+	checkDateTimestamp(t, event, time.Date(2017, time.March, 4, 10, 0, 0, 0, time.UTC))
+
+	event = processor.Process(core.Event{"timestamp": "2017-03-04T10:00:00.5Z"})
+	checkDateTimestamp(t, event, time.Date(2017, time.March, 4, 10, 0, 0, 500000000, time.UTC))
+}
+
+func TestDateUnix(t *testing.T) {
+	processor := createDateProcessor(map[string]interface{}{
+		"formats": []interface{}{"UNIX"},
+	}, t)
+
+	event := processor.Process(core.Event{"timestamp": json.Number("1488621600")})
+	checkDateTimestamp(t, event, time.Date(2017, time.March, 4, 10, 0, 0, 0, time.UTC))
+
+	processor = createDateProcessor(map[string]interface{}{
+		"formats": []interface{}{"UNIX_MS"},
+	}, t)
+
+	event = processor.Process(core.Event{"timestamp": "1488621600250"})
+	checkDateTimestamp(t, event, time.Date(2017, time.March, 4, 10, 0, 0, 250000000, time.UTC))
+}
+
+func TestDateWithoutYear(t *testing.T) {
+	processor := createDateProcessor(map[string]interface{}{
+		"formats":  []interface{}{"syslog"},
+		"timezone": "UTC",
+	}, t)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	event := processor.Process(core.Event{"timestamp": now.Format(time.Stamp)})
+	checkDateTimestamp(t, event, now)
+
+	// A time well in the future is assumed to be from the previous year
+	future := now.Add(48 * time.Hour)
+	if future.Year() != now.Year() {
+		return
+	}
+	event = processor.Process(core.Event{"timestamp": future.Format(time.Stamp)})
+	checkDateTimestamp(t, event, future.AddDate(-1, 0, 0))
+}
+
+func TestDateFailure(t *testing.T) {
+	processor := createDateProcessor(map[string]interface{}{
+		"formats": []interface{}{"RFC3339"},
+	}, t)
+
+	existing := time.Date(2017, time.March, 4, 10, 0, 0, 0, time.UTC)
+	for _, event := range []core.Event{
+		{"@timestamp": existing, "timestamp": "not a date"},
+		{"@timestamp": existing},
+	} {
+		event = processor.Process(event)
+
+		if event["@timestamp"] != existing {
+			t.Errorf("Timestamp was changed: %v", event["@timestamp"])
+		}
+
+		tags, ok := event["tags"].([]string)
+		if !ok || len(tags) != 1 || tags[0] != "_dateparsefailure" {
+			t.Errorf("Event was not tagged: %v", event["tags"])
+		}
+	}
+}
+
+func TestDateInvalid(t *testing.T) {
+	for _, unused := range []map[string]interface{}{
+		{},
+		{"formats": []interface{}{"not a layout"}},
+		{"formats": []interface{}{"RFC3339"}, "timezone": "Nowhere/Special"},
+	} {
+		if _, err := NewDateProcessorFactory(config.NewConfig(), "", unused, "date", "message"); err == nil {
+			t.Errorf("Invalid date processor was accepted: %v", unused)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultDissectAppendSeparator string = " "
+	defaultDissectFailureTag      string = "_dissectfailure"
+)
+
+// dissectKey is a single %{} key within a dissect pattern along with the
+// literal delimiter that follows it
+type dissectKey struct {
+	name      string
+	skip      bool
+	append    bool
+	padded    bool
+	delimiter string
+}
+
+// ProcessorDissectFactory holds the configuration for a dissect processor
+type ProcessorDissectFactory struct {
+	AppendSeparator string `config:"append separator"`
+	Field           string `config:"field"`
+	Pattern         string `config:"pattern"`
+
+	prefix string
+	keys   []dissectKey
+}
+
+// ProcessorDissect is an instance of a dissect processor
+type ProcessorDissect struct {
+	config *ProcessorDissectFactory
+}
+
+// NewDissectProcessorFactory creates a new ProcessorDissectFactory for a
+// processor definition in the configuration file
+func NewDissectProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	var err error
+
+	result := &ProcessorDissectFactory{}
+	if err = config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.Field == "" {
+		result.Field = messageField
+	}
+
+	if result.Pattern == "" {
+		return nil, errors.New("Dissect processor pattern must be specified.")
+	}
+
+	if err = result.parsePattern(); err != nil {
+		return nil, fmt.Errorf("Dissect processor pattern is invalid: %s", err)
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for a dissect processor
+func (f *ProcessorDissectFactory) InitDefaults() {
+	f.AppendSeparator = defaultDissectAppendSeparator
+}
+
+// parsePattern splits the pattern into the literal prefix and the sequence of
+// keys, each with the literal delimiter that follows it
+func (f *ProcessorDissectFactory) parsePattern() error {
+	remaining := f.Pattern
+
+	start := strings.Index(remaining, "%{")
+	if start == -1 {
+		return errors.New("no keys found")
+	}
+
+	f.prefix = remaining[:start]
+	remaining = remaining[start:]
+
+	for remaining != "" {
+		end := strings.Index(remaining, "}")
+		if end == -1 {
+			return errors.New("unterminated key")
+		}
+
+		key := dissectKey{name: remaining[2:end]}
+		remaining = remaining[end+1:]
+
+		if strings.HasSuffix(key.name, "->") {
+			key.padded = true
+			key.name = key.name[:len(key.name)-2]
+		}
+
+		if strings.HasPrefix(key.name, "+") {
+			key.append = true
+			key.name = key.name[1:]
+		} else if strings.HasPrefix(key.name, "?") {
+			key.skip = true
+			key.name = key.name[1:]
+		}
+
+		if key.name == "" {
+			key.skip = true
+		} else if key.append && key.skip {
+			return fmt.Errorf("key \"%s\" can not be both skipped and appended", key.name)
+		}
+
+		next := strings.Index(remaining, "%{")
+		if next == -1 {
+			key.delimiter = remaining
+			remaining = ""
+		} else {
+			key.delimiter = remaining[:next]
+			remaining = remaining[next:]
+			if key.delimiter == "" {
+				return fmt.Errorf("key \"%s\" must be followed by a delimiter", key.name)
+			}
+		}
+
+		if key.padded && key.delimiter == "" {
+			return fmt.Errorf("padded key \"%s\" must be followed by a delimiter", key.name)
+		}
+
+		f.keys = append(f.keys, key)
+	}
+
+	return nil
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorDissectFactory) NewProcessor() Processor {
+	return &ProcessorDissect{
+		config: f,
+	}
+}
+
+// Process splits the source field into the keys given by the pattern. If the
+// field does not match the pattern the event is tagged with "_dissectfailure"
+// and left otherwise unchanged
+func (p *ProcessorDissect) Process(event core.Event) core.Event {
+	value, _ := event.GetPath(p.config.Field)
+	source, ok := value.(string)
+	if !ok {
+		event.AddTags(defaultDissectFailureTag)
+		return event
+	}
+
+	values, ok := p.dissect(source)
+	if !ok {
+		event.AddTags(defaultDissectFailureTag)
+		return event
+	}
+
+	for name, value := range values {
+		event[name] = value
+	}
+
+	return event
+}
+
+// dissect performs the split, returning false if the text did not match
+// Values are collected first so that a failed match leaves the event unchanged
+func (p *ProcessorDissect) dissect(text string) (map[string]string, bool) {
+	if !strings.HasPrefix(text, p.config.prefix) {
+		return nil, false
+	}
+
+	remaining := text[len(p.config.prefix):]
+	values := make(map[string]string, len(p.config.keys))
+	last := len(p.config.keys) - 1
+
+	for i, key := range p.config.keys {
+		var value string
+
+		if key.delimiter == "" {
+			// Final key with no trailing delimiter takes the remainder
+			value, remaining = remaining, ""
+		} else {
+			var end int
+			if i == last {
+				// Final key is anchored to the last occurrence so it may contain the
+				// delimiter itself, such as a quoted request containing quotes
+				end = strings.LastIndex(remaining, key.delimiter)
+			} else {
+				end = strings.Index(remaining, key.delimiter)
+			}
+			if end == -1 {
+				return nil, false
+			}
+
+			value, remaining = remaining[:end], remaining[end+len(key.delimiter):]
+
+			if key.padded {
+				for strings.HasPrefix(remaining, key.delimiter) {
+					remaining = remaining[len(key.delimiter):]
+				}
+			}
+		}
+
+		if key.skip {
+			continue
+		}
+
+		if key.append {
+			if existing, ok := values[key.name]; ok {
+				value = existing + p.config.AppendSeparator + value
+			}
+		}
+
+		values[key.name] = value
+	}
+
+	// Text after the final delimiter means the pattern did not match all of it
+	if remaining != "" {
+		return nil, false
+	}
+
+	return values, true
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("dissect", NewDissectProcessorFactory)
+}
@@ -0,0 +1,150 @@
+package processors
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const dissectTestLine = `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0"`
+
+func createDissectProcessor(unused map[string]interface{}, t testing.TB) Processor {
+	config := config.NewConfig()
+
+	factory, err := NewDissectProcessorFactory(config, "", unused, "dissect", "message")
+	if err != nil {
+		t.Logf("Failed to create dissect processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func checkField(t *testing.T, event core.Event, field string, expected string) {
+	if value, ok := event[field]; !ok {
+		t.Errorf("Field %s missing", field)
+	} else if value != expected {
+		t.Errorf("Field %s incorrect: %v != %s", field, value, expected)
+	}
+}
+
+func TestDissect(t *testing.T) {
+	processor := createDissectProcessor(map[string]interface{}{
+		"pattern": `%{ip} - %{user} [%{ts}] "%{req}"`,
+	}, t)
+
+	event := processor.Process(core.Event{"message": dissectTestLine})
+
+	checkField(t, event, "ip", "127.0.0.1")
+	checkField(t, event, "user", "frank")
+	checkField(t, event, "ts", "10/Oct/2000:13:55:36 -0700")
+	checkField(t, event, "req", "GET /apache_pb.gif HTTP/1.0")
+	if _, ok := event["tags"]; ok {
+		t.Error("Event was unexpectedly tagged")
+	}
+}
+
+func TestDissectSkipAndAppend(t *testing.T) {
+	processor := createDissectProcessor(map[string]interface{}{
+		"pattern":          `%{} - %{} [%{date} %{?tz}] "%{method} %{path} %{+method}"`,
+		"append separator": "|",
+	}, t)
+
+	event := processor.Process(core.Event{"message": dissectTestLine})
+
+	checkField(t, event, "date", "10/Oct/2000:13:55:36")
+	checkField(t, event, "method", "GET|HTTP/1.0")
+	checkField(t, event, "path", "/apache_pb.gif")
+	if _, ok := event["tz"]; ok {
+		t.Error("Skipped field tz was set")
+	}
+	if len(event) != 4 {
+		t.Errorf("Unexpected number of fields: %v", event)
+	}
+}
+
+func TestDissectPadding(t *testing.T) {
+	processor := createDissectProcessor(map[string]interface{}{
+		"pattern": `%{level->} %{msg}`,
+	}, t)
+
+	event := processor.Process(core.Event{"message": "INFO      Started"})
+
+	checkField(t, event, "level", "INFO")
+	checkField(t, event, "msg", "Started")
+}
+
+func TestDissectFailure(t *testing.T) {
+	processor := createDissectProcessor(map[string]interface{}{
+		"pattern": `%{ip} - %{user} [%{ts}]`,
+	}, t)
+
+	event := processor.Process(core.Event{"message": "not an access log", "tags": []interface{}{"existing"}})
+
+	if _, ok := event["ip"]; ok {
+		t.Error("Field was set on failed match")
+	}
+
+	tags, ok := event["tags"].([]interface{})
+	if !ok || len(tags) != 2 || tags[1] != "_dissectfailure" {
+		t.Errorf("Event was not tagged correctly: %v", event["tags"])
+	}
+}
+
+func TestDissectTrailingText(t *testing.T) {
+	processor := createDissectProcessor(map[string]interface{}{
+		"pattern": `%{a} [%{b}]`,
+	}, t)
+
+	event := processor.Process(core.Event{"message": "x [y] garbage"})
+	if _, ok := event["a"]; ok {
+		t.Errorf("Fields were set when there was trailing text: %v", event)
+	}
+
+	tags, ok := event["tags"].([]string)
+	if !ok || len(tags) != 1 || tags[0] != "_dissectfailure" {
+		t.Errorf("Event was not tagged correctly: %v", event["tags"])
+	}
+
+	event = processor.Process(core.Event{"message": "x [y]"})
+	checkField(t, event, "a", "x")
+	checkField(t, event, "b", "y")
+}
+
+func TestDissectInvalidPattern(t *testing.T) {
+	for _, pattern := range []string{"no keys", "%{a}%{b}", "%{unterminated", "%{pad->}"} {
+		_, err := NewDissectProcessorFactory(config.NewConfig(), "", map[string]interface{}{"pattern": pattern}, "dissect", "message")
+		if err == nil {
+			t.Errorf("Invalid pattern was accepted: %s", pattern)
+		}
+	}
+}
+
+func BenchmarkDissect(b *testing.B) {
+	processor := createDissectProcessor(map[string]interface{}{
+		"pattern": `%{ip} - %{user} [%{ts}] "%{req}"`,
+	}, b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		processor.Process(core.Event{"message": dissectTestLine})
+	}
+}
+
+// BenchmarkDissectRegexp performs the equivalent extraction using a regular
+// expression, as a grok pattern would, for comparison with BenchmarkDissect
+func BenchmarkDissectRegexp(b *testing.B) {
+	pattern := regexp.MustCompile(`^(?P<ip>.*?) - (?P<user>.*?) \[(?P<ts>.*?)\] "(?P<req>.*)"$`)
+	names := pattern.SubexpNames()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		event := core.Event{"message": dissectTestLine}
+		matches := pattern.FindStringSubmatch(event["message"].(string))
+		for n := 1; n < len(matches); n++ {
+			event[names[n]] = matches[n]
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/codecs"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// ProcessorDropFactory holds the configuration for a drop processor
+type ProcessorDropFactory struct {
+	Field    string   `config:"field"`
+	Match    string   `config:"match"`
+	Patterns []string `config:"patterns"`
+
+	patterns codecs.PatternCollection
+}
+
+// ProcessorDrop is an instance of a drop processor
+type ProcessorDrop struct {
+	config  *ProcessorDropFactory
+	dropped uint64
+}
+
+// NewDropProcessorFactory creates a new ProcessorDropFactory for a processor
+// definition in the configuration file
+func NewDropProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	result := &ProcessorDropFactory{}
+	if err := config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.Field == "" {
+		result.Field = messageField
+	}
+
+	if len(result.Patterns) == 0 {
+		return nil, errors.New("Drop processor patterns must be specified.")
+	}
+
+	if err := result.patterns.Set(result.Patterns, result.Match); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorDropFactory) NewProcessor() Processor {
+	return &ProcessorDrop{
+		config: f,
+	}
+}
+
+// Process drops the event if the field is a string that matches the patterns.
+// Events where the field is missing or is not a string are left unchanged
+func (p *ProcessorDrop) Process(event core.Event) core.Event {
+	value, ok := event.GetPath(p.config.Field)
+	if !ok {
+		return event
+	}
+
+	text, ok := value.(string)
+	if !ok || !p.config.patterns.Match(text) {
+		return event
+	}
+
+	atomic.AddUint64(&p.dropped, 1)
+	return nil
+}
+
+// APIEncodable returns an admin API entry with the processor status
+func (p *ProcessorDrop) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("dropped_events", admin.APINumber(atomic.LoadUint64(&p.dropped)))
+	return api
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("drop", NewDropProcessorFactory)
+}
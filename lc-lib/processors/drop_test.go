@@ -0,0 +1,90 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createDropProcessor(unused map[string]interface{}, t *testing.T) Processor {
+	factory, err := NewDropProcessorFactory(config.NewConfig(), "", unused, "drop", "message")
+	if err != nil {
+		t.Logf("Failed to create drop processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func TestDropMatching(t *testing.T) {
+	processor := createDropProcessor(map[string]interface{}{
+		"patterns": []interface{}{"GET /health"},
+	}, t)
+
+	if event := processor.Process(core.Event{"message": "GET /health HTTP/1.1"}); event != nil {
+		t.Errorf("Matching event was not dropped: %v", event)
+	}
+
+	if event := processor.Process(core.Event{"message": "GET /index.html HTTP/1.1"}); event == nil {
+		t.Error("Event that does not match was dropped")
+	}
+
+	if event := processor.Process(core.Event{"other": "GET /health HTTP/1.1"}); event == nil {
+		t.Error("Event without the field was dropped")
+	}
+
+	if _, ok := processor.(APIProcessor); !ok {
+		t.Error("Drop processor does not report to the API")
+	}
+
+	if dropped := processor.(*ProcessorDrop).dropped; dropped != 1 {
+		t.Errorf("Incorrect dropped events: %d != 1", dropped)
+	}
+}
+
+func TestDropField(t *testing.T) {
+	processor := createDropProcessor(map[string]interface{}{
+		"field":    "http.status",
+		"patterns": []interface{}{"^2"},
+	}, t)
+
+	if event := processor.Process(core.Event{"http": map[string]interface{}{"status": "200"}}); event != nil {
+		t.Errorf("Matching event was not dropped: %v", event)
+	}
+
+	if event := processor.Process(core.Event{"http": map[string]interface{}{"status": "500"}}); event == nil {
+		t.Error("Event that does not match was dropped")
+	}
+
+	if event := processor.Process(core.Event{"http": map[string]interface{}{"status": 200}}); event == nil {
+		t.Error("Event with a non-string field was dropped")
+	}
+}
+
+func TestDropMatchAll(t *testing.T) {
+	processor := createDropProcessor(map[string]interface{}{
+		"patterns": []interface{}{"^DEBUG", "!important"},
+		"match":    "all",
+	}, t)
+
+	if event := processor.Process(core.Event{"message": "DEBUG noise"}); event != nil {
+		t.Errorf("Matching event was not dropped: %v", event)
+	}
+
+	if event := processor.Process(core.Event{"message": "DEBUG important"}); event == nil {
+		t.Error("Event matching only one pattern was dropped")
+	}
+}
+
+func TestDropInvalid(t *testing.T) {
+	if _, err := NewDropProcessorFactory(config.NewConfig(), "", map[string]interface{}{}, "drop", "message"); err == nil {
+		t.Error("Drop processor without patterns was accepted")
+	}
+
+	if _, err := NewDropProcessorFactory(config.NewConfig(), "", map[string]interface{}{
+		"patterns": []interface{}{"("},
+	}, "drop", "message"); err == nil {
+		t.Error("Drop processor with an invalid pattern was accepted")
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultFlattenSeparator  string = "."
+	defaultFlattenFailureTag string = "_flattenfailure"
+)
+
+// ProcessorFlattenFactory holds the configuration for a flatten processor
+type ProcessorFlattenFactory struct {
+	Field     string `config:"field"`
+	Separator string `config:"separator"`
+}
+
+// ProcessorFlatten is an instance of a flatten processor
+type ProcessorFlatten struct {
+	config *ProcessorFlattenFactory
+}
+
+// NewFlattenProcessorFactory creates a new ProcessorFlattenFactory for a
+// processor definition in the configuration file
+func NewFlattenProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	result := &ProcessorFlattenFactory{}
+	if err := config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.Separator == "" {
+		return nil, errors.New("Flatten processor separator can not be empty.")
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for a flatten processor
+func (f *ProcessorFlattenFactory) InitDefaults() {
+	f.Separator = defaultFlattenSeparator
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorFlattenFactory) NewProcessor() Processor {
+	return &ProcessorFlatten{
+		config: f,
+	}
+}
+
+// Process replaces nested dictionaries within the event, or within the
+// configured field, with keys joined by the separator. Arrays are not
+// descended into. If a flattened key would replace an existing field, the
+// dictionary it came from is left unchanged and the event is tagged with
+// "_flattenfailure"
+func (p *ProcessorFlatten) Process(event core.Event) core.Event {
+	target := map[string]interface{}(event)
+	if p.config.Field != "" {
+		value, _ := event.GetPath(p.config.Field)
+		var ok bool
+		if target, ok = value.(map[string]interface{}); !ok {
+			return event
+		}
+	}
+
+	if !p.flatten(target) {
+		event.AddTags(defaultFlattenFailureTag)
+	}
+
+	return event
+}
+
+// flatten flattens the given dictionary in place, returning false if any keys
+// conflicted. Keys are processed in order so the result is predictable
+func (p *ProcessorFlatten) flatten(target map[string]interface{}) bool {
+	keys := make([]string, 0, len(target))
+	for key := range target {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	success := true
+	for _, key := range keys {
+		nested, ok := target[key].(map[string]interface{})
+		if !ok || len(nested) == 0 {
+			continue
+		}
+
+		flattened := make(map[string]interface{})
+		if !p.flattenInto(flattened, key, nested) {
+			success = false
+			continue
+		}
+
+		conflict := false
+		for flatKey := range flattened {
+			if _, exists := target[flatKey]; exists {
+				conflict = true
+				break
+			}
+		}
+		if conflict {
+			success = false
+			continue
+		}
+
+		delete(target, key)
+		for flatKey, value := range flattened {
+			target[flatKey] = value
+		}
+	}
+
+	return success
+}
+
+// flattenInto adds the values of the nested dictionary to the result with
+// their keys prefixed, returning false if two keys flattened to the same key
+func (p *ProcessorFlatten) flattenInto(result map[string]interface{}, prefix string, nested map[string]interface{}) bool {
+	for key, value := range nested {
+		flatKey := prefix + p.config.Separator + key
+		if child, ok := value.(map[string]interface{}); ok && len(child) != 0 {
+			if !p.flattenInto(result, flatKey, child) {
+				return false
+			}
+			continue
+		}
+
+		if _, exists := result[flatKey]; exists {
+			return false
+		}
+		result[flatKey] = value
+	}
+
+	return true
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("flatten", NewFlattenProcessorFactory)
+}
@@ -0,0 +1,90 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createFlattenProcessor(unused map[string]interface{}, t *testing.T) Processor {
+	factory, err := NewFlattenProcessorFactory(config.NewConfig(), "", unused, "flatten", "message")
+	if err != nil {
+		t.Logf("Failed to create flatten processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func TestFlatten(t *testing.T) {
+	processor := createFlattenProcessor(map[string]interface{}{}, t)
+
+	event := processor.Process(core.Event{
+		"message": "test",
+		"a":       map[string]interface{}{"b": 1, "c": map[string]interface{}{"d": "x"}},
+		"list":    []interface{}{map[string]interface{}{"e": 1}},
+		"empty":   map[string]interface{}{},
+	})
+
+	expected := core.Event{
+		"message": "test",
+		"a.b":     1,
+		"a.c.d":   "x",
+		"list":    []interface{}{map[string]interface{}{"e": 1}},
+		"empty":   map[string]interface{}{},
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event: %v", event)
+	}
+
+	// Flattening again must not change anything
+	if event = processor.Process(event); !reflect.DeepEqual(event, expected) {
+		t.Errorf("Flatten is not idempotent: %v", event)
+	}
+}
+
+func TestFlattenField(t *testing.T) {
+	processor := createFlattenProcessor(map[string]interface{}{
+		"field":     "labels",
+		"separator": "_",
+	}, t)
+
+	event := processor.Process(core.Event{
+		"labels": map[string]interface{}{"app": map[string]interface{}{"name": "web"}},
+		"other":  map[string]interface{}{"kept": true},
+	})
+
+	expected := core.Event{
+		"labels": map[string]interface{}{"app_name": "web"},
+		"other":  map[string]interface{}{"kept": true},
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event: %v", event)
+	}
+
+	// Events where the field is not a dictionary are unchanged
+	event = processor.Process(core.Event{"labels": "none"})
+	if !reflect.DeepEqual(event, core.Event{"labels": "none"}) {
+		t.Errorf("Unexpected event: %v", event)
+	}
+}
+
+func TestFlattenConflict(t *testing.T) {
+	processor := createFlattenProcessor(map[string]interface{}{}, t)
+
+	event := processor.Process(core.Event{
+		"a":   map[string]interface{}{"b": 1},
+		"a.b": 2,
+	})
+
+	expected := core.Event{
+		"a":    map[string]interface{}{"b": 1},
+		"a.b":  2,
+		"tags": []string{"_flattenfailure"},
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event: %v", event)
+	}
+}
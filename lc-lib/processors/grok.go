@@ -0,0 +1,283 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultGrokOnFailure string = "_grokparsefailure"
+
+	// grokMaxDepth is the deepest that pattern references may be nested, which
+	// also catches patterns that refer to themselves
+	grokMaxDepth = 16
+)
+
+const (
+	grokTypeString = iota
+	grokTypeInt
+	grokTypeFloat
+)
+
+var (
+	// grokReference matches a %{NAME}, %{NAME:field} or %{NAME:field:type}
+	// reference to a named pattern
+	grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@-]+))?(?::(\w+))?\}`)
+
+	// grokBuiltinPatterns are the named patterns available to every grok
+	// processor, based on the most commonly used Logstash grok patterns
+	grokBuiltinPatterns = map[string]string{
+		"DATA":              `.*?`,
+		"GREEDYDATA":        `.*`,
+		"HOSTNAME":          `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+		"HTTPDATE":          `[0-9]{2}/[A-Za-z]{3}/[0-9]{4}:[0-9]{2}:[0-9]{2}:[0-9]{2} [+-][0-9]{4}`,
+		"INT":               `[+-]?[0-9]+`,
+		"IP":                `(?:%{IPV6}|%{IPV4})`,
+		"IPORHOST":          `(?:%{IP}|%{HOSTNAME})`,
+		"IPV4":              `(?:[0-9]{1,3}\.){3}[0-9]{1,3}`,
+		"IPV6":              `(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}`,
+		"LOGLEVEL":          `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|severe|emerg(?:ency)?)`,
+		"NOTSPACE":          `\S+`,
+		"NUMBER":            `[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)`,
+		"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"`,
+		"SPACE":             `\s*`,
+		"TIMESTAMP_ISO8601": `[0-9]{4}-[0-9]{2}-[0-9]{2}[T ][0-9]{2}:[0-9]{2}(?::[0-9]{2}(?:[.,][0-9]+)?)?(?:Z|[+-][0-9]{2}:?[0-9]{2})?`,
+		"URIPATH":           `/[^\s?#]*`,
+		"URIPATHPARAM":      `/[^\s#]*`,
+		"USERNAME":          `[a-zA-Z0-9._-]+`,
+		"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+		"WORD":              `\b\w+\b`,
+	}
+)
+
+// grokCapture is a capture group of the compiled pattern, along with the field
+// to store it in and the type to convert it to
+type grokCapture struct {
+	field     string
+	valueType int
+}
+
+// ProcessorGrokFactory holds the configuration for a grok processor
+type ProcessorGrokFactory struct {
+	Definitions map[string]string `config:"definitions"`
+	Field       string            `config:"field"`
+	OnFailure   string            `config:"on failure"`
+	Pattern     string            `config:"pattern"`
+
+	matcher  *regexp.Regexp
+	captures []*grokCapture
+}
+
+// ProcessorGrok is an instance of a grok processor
+type ProcessorGrok struct {
+	config *ProcessorGrokFactory
+}
+
+// NewGrokProcessorFactory creates a new ProcessorGrokFactory for a processor
+// definition in the configuration file. The pattern is expanded and compiled
+// here so that each event only needs to be matched against it
+func NewGrokProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	result := &ProcessorGrokFactory{}
+	if err := config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.Field == "" {
+		result.Field = messageField
+	}
+
+	if result.Pattern == "" {
+		return nil, errors.New("Grok processor pattern must be specified.")
+	}
+
+	if err := result.compile(); err != nil {
+		return nil, fmt.Errorf("Grok processor pattern is invalid: %s", err)
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for a grok processor
+func (f *ProcessorGrokFactory) InitDefaults() {
+	f.OnFailure = defaultGrokOnFailure
+}
+
+// compile expands the named pattern references and compiles the result,
+// recording the field and type of each capture group
+func (f *ProcessorGrokFactory) compile() error {
+	captures := make(map[string]*grokCapture)
+
+	expanded, err := f.expand(f.Pattern, captures, 0)
+	if err != nil {
+		return err
+	}
+
+	if f.matcher, err = regexp.Compile(expanded); err != nil {
+		return err
+	}
+
+	// Named groups written directly in the pattern are stored as strings in the
+	// field of the same name
+	f.captures = make([]*grokCapture, len(f.matcher.SubexpNames()))
+	for i, name := range f.matcher.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if capture, ok := captures[name]; ok {
+			f.captures[i] = capture
+		} else {
+			f.captures[i] = &grokCapture{field: name}
+		}
+	}
+
+	return nil
+}
+
+// expand replaces the named pattern references in the given pattern with the
+// patterns they refer to. References that name a field become capture groups,
+// and all others become non-capturing groups
+func (f *ProcessorGrokFactory) expand(pattern string, captures map[string]*grokCapture, depth int) (string, error) {
+	if depth > grokMaxDepth {
+		return "", errors.New("named patterns are nested too deeply or refer to themselves")
+	}
+
+	var err error
+	expanded := grokReference.ReplaceAllStringFunc(pattern, func(reference string) string {
+		if err != nil {
+			return ""
+		}
+
+		parts := grokReference.FindStringSubmatch(reference)
+
+		definition, ok := f.Definitions[parts[1]]
+		if !ok {
+			if definition, ok = grokBuiltinPatterns[parts[1]]; !ok {
+				err = fmt.Errorf("unknown named pattern \"%s\"", parts[1])
+				return ""
+			}
+		}
+
+		var inner string
+		if inner, err = f.expand(definition, captures, depth+1); err != nil {
+			return ""
+		}
+
+		if parts[2] == "" {
+			if parts[3] != "" {
+				err = fmt.Errorf("a type was given for \"%s\" without a field", reference)
+			}
+			return "(?:" + inner + ")"
+		}
+
+		capture := &grokCapture{field: parts[2]}
+		switch parts[3] {
+		case "", "string":
+		case "int":
+			capture.valueType = grokTypeInt
+		case "float":
+			capture.valueType = grokTypeFloat
+		default:
+			err = fmt.Errorf("unknown type \"%s\" for field \"%s\", must be \"int\", \"float\" or \"string\"", parts[3], parts[2])
+			return ""
+		}
+
+		// Fields may contain characters a group name can not, so groups are
+		// given a generated name that maps back to the capture
+		name := fmt.Sprintf("grok%d", len(captures))
+		captures[name] = capture
+		return "(?P<" + name + ">" + inner + ")"
+	})
+
+	if err == nil && strings.Contains(expanded, "%{") {
+		// A literal "%{" can be written as "%\{"
+		err = errors.New("malformed named pattern reference")
+	}
+
+	return expanded, err
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorGrokFactory) NewProcessor() Processor {
+	return &ProcessorGrok{
+		config: f,
+	}
+}
+
+// Process matches the pattern against the field, storing each capture in its
+// field. Captures that did not participate in the match, such as those in an
+// optional group, are not stored. If the field does not match the event is
+// tagged with the "on failure" tag and left otherwise unchanged
+func (p *ProcessorGrok) Process(event core.Event) core.Event {
+	value, _ := event.GetPath(p.config.Field)
+	source, ok := value.(string)
+	if !ok {
+		p.fail(event)
+		return event
+	}
+
+	matches := p.config.matcher.FindStringSubmatchIndex(source)
+	if matches == nil {
+		p.fail(event)
+		return event
+	}
+
+	for i, capture := range p.config.captures {
+		if capture == nil || matches[i*2] < 0 {
+			continue
+		}
+
+		event.SetPath(capture.field, grokConvert(source[matches[i*2]:matches[i*2+1]], capture.valueType))
+	}
+
+	return event
+}
+
+// fail tags the event with the "on failure" tag, if there is one
+func (p *ProcessorGrok) fail(event core.Event) {
+	if p.config.OnFailure != "" {
+		event.AddTags(p.config.OnFailure)
+	}
+}
+
+// grokConvert converts a captured value to the given type. A value that can
+// not be converted is kept as a string
+func grokConvert(value string, valueType int) interface{} {
+	switch valueType {
+	case grokTypeInt:
+		if converted, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return converted
+		}
+	case grokTypeFloat:
+		if converted, err := strconv.ParseFloat(value, 64); err == nil {
+			return converted
+		}
+	}
+	return value
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("grok", NewGrokProcessorFactory)
+}
@@ -0,0 +1,142 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createGrokProcessor(unused map[string]interface{}, t testing.TB) Processor {
+	config := config.NewConfig()
+
+	factory, err := NewGrokProcessorFactory(config, "", unused, "grok", "message")
+	if err != nil {
+		t.Logf("Failed to create grok processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func checkGrokTag(t *testing.T, event core.Event, tag string) {
+	tags, ok := event["tags"].([]string)
+	if !ok || len(tags) != 1 || tags[0] != tag {
+		t.Errorf("Event was not tagged correctly: %v", event["tags"])
+	}
+}
+
+func TestGrok(t *testing.T) {
+	processor := createGrokProcessor(map[string]interface{}{
+		"pattern": `%{IPORHOST:client} %{WORD:method} %{URIPATHPARAM:request} %{INT:status:int} %{NUMBER:duration:float}`,
+	}, t)
+
+	event := processor.Process(core.Event{"message": "10.0.0.1 GET /index.html?a=b 200 0.043"})
+
+	checkField(t, event, "client", "10.0.0.1")
+	checkField(t, event, "method", "GET")
+	checkField(t, event, "request", "/index.html?a=b")
+	if event["status"] != int64(200) {
+		t.Errorf("Field status incorrect: %#v", event["status"])
+	}
+	if event["duration"] != 0.043 {
+		t.Errorf("Field duration incorrect: %#v", event["duration"])
+	}
+	if _, ok := event["tags"]; ok {
+		t.Error("Event was unexpectedly tagged")
+	}
+}
+
+func TestGrokPartialMatch(t *testing.T) {
+	processor := createGrokProcessor(map[string]interface{}{
+		"pattern": `user=%{USERNAME:user}(?: id=%{INT:id:int})?`,
+	}, t)
+
+	// The pattern is not anchored so it may match part of the field
+	event := processor.Process(core.Event{"message": "login ok user=frank from 10.0.0.1"})
+
+	checkField(t, event, "user", "frank")
+	if _, ok := event["id"]; ok {
+		t.Error("Capture that did not participate in the match was set")
+	}
+	if _, ok := event["tags"]; ok {
+		t.Error("Event was unexpectedly tagged")
+	}
+}
+
+func TestGrokConversionFailure(t *testing.T) {
+	processor := createGrokProcessor(map[string]interface{}{
+		"pattern": `^%{NOTSPACE:count:int}$`,
+	}, t)
+
+	// A value that can not be converted is kept as a string
+	event := processor.Process(core.Event{"message": "many"})
+
+	checkField(t, event, "count", "many")
+}
+
+func TestGrokDefinitionsAndNamedGroups(t *testing.T) {
+	processor := createGrokProcessor(map[string]interface{}{
+		"definitions": map[string]interface{}{"ORDER": `ORD-[0-9]+`},
+		"pattern":     `%{ORDER:order.id} (?P<state>\w+)`,
+	}, t)
+
+	event := processor.Process(core.Event{"message": "ORD-42 shipped"})
+
+	if value, _ := event.GetPath("order.id"); value != "ORD-42" {
+		t.Errorf("Field order.id incorrect: %v", value)
+	}
+	checkField(t, event, "state", "shipped")
+}
+
+func TestGrokFailure(t *testing.T) {
+	processor := createGrokProcessor(map[string]interface{}{
+		"pattern": `^%{INT:status}$`,
+	}, t)
+
+	event := processor.Process(core.Event{"message": "not a number"})
+	checkGrokTag(t, event, "_grokparsefailure")
+	if _, ok := event["status"]; ok {
+		t.Error("Field was set on failure")
+	}
+
+	processor = createGrokProcessor(map[string]interface{}{
+		"pattern":    `^%{INT:status}$`,
+		"on failure": "_nostatus",
+	}, t)
+
+	event = processor.Process(core.Event{"message": "not a number"})
+	checkGrokTag(t, event, "_nostatus")
+}
+
+func TestGrokInvalid(t *testing.T) {
+	for _, pattern := range []string{
+		`%{UNKNOWN:field}`,
+		`%{INT:field:bool}`,
+		`%{INT::int}`,
+		`(unclosed`,
+	} {
+		if _, err := NewGrokProcessorFactory(config.NewConfig(), "", map[string]interface{}{"pattern": pattern}, "grok", "message"); err == nil {
+			t.Errorf("Invalid pattern was accepted: %s", pattern)
+		}
+	}
+
+	// Definitions that refer to themselves are rejected
+	if _, err := NewGrokProcessorFactory(config.NewConfig(), "", map[string]interface{}{
+		"definitions": map[string]interface{}{"LOOP": `a%{LOOP}`},
+		"pattern":     `%{LOOP:field}`,
+	}, "grok", "message"); err == nil {
+		t.Error("Recursive definition was accepted")
+	}
+}
+
+func BenchmarkGrok(b *testing.B) {
+	processor := createGrokProcessor(map[string]interface{}{
+		"pattern": `%{IPORHOST:ip} - %{USERNAME:user} \[%{HTTPDATE:ts}\] "%{DATA:req}"`,
+	}, b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		processor.Process(core.Event{"message": dissectTestLine})
+	}
+}
@@ -0,0 +1,323 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultHttpdFailureTag string = "_httpdparsefailure"
+	defaultHttpdFormat     string = httpdFormatCombined
+
+	httpdFormatCommon   = "common"
+	httpdFormatCombined = "combined"
+	httpdFormatNginx    = "nginx"
+
+	// httpdNil is used in place of values that are not available
+	httpdNil = "-"
+)
+
+var (
+	errHttpdInvalid = errors.New("invalid access log line")
+)
+
+// ProcessorHttpdFactory holds the configuration for a httpd processor
+type ProcessorHttpdFactory struct {
+	Field  string `config:"field"`
+	Format string `config:"format"`
+}
+
+// ProcessorHttpd is an instance of a httpd processor
+type ProcessorHttpd struct {
+	config *ProcessorHttpdFactory
+}
+
+// NewHttpdProcessorFactory creates a new ProcessorHttpdFactory for a processor
+// definition in the configuration file
+func NewHttpdProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	result := &ProcessorHttpdFactory{}
+	if err := config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.Field == "" {
+		result.Field = messageField
+	}
+
+	switch result.Format {
+	case httpdFormatCommon, httpdFormatCombined, httpdFormatNginx:
+	default:
+		return nil, fmt.Errorf("Httpd processor format must be \"%s\", \"%s\" or \"%s\".", httpdFormatCommon, httpdFormatCombined, httpdFormatNginx)
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for a httpd processor
+func (f *ProcessorHttpdFactory) InitDefaults() {
+	f.Format = defaultHttpdFormat
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorHttpdFactory) NewProcessor() Processor {
+	return &ProcessorHttpd{
+		config: f,
+	}
+}
+
+// Process parses the field as an access log line in the configured format and
+// sets the fields that are present in the line. If the line could not be parsed
+// the event is tagged with "_httpdparsefailure" and left otherwise unchanged
+func (p *ProcessorHttpd) Process(event core.Event) core.Event {
+	value, _ := event.GetPath(p.config.Field)
+	source, ok := value.(string)
+	if !ok {
+		event.AddTags(defaultHttpdFailureTag)
+		return event
+	}
+
+	values, err := p.parse(source)
+	if err != nil {
+		event.AddTags(defaultHttpdFailureTag)
+		return event
+	}
+
+	for _, entry := range values {
+		event.SetPath(entry.path, entry.value)
+	}
+
+	return event
+}
+
+// httpdValue is a single field parsed from an access log line
+type httpdValue struct {
+	path  string
+	value interface{}
+}
+
+// parse parses an access log line, returning the fields to set. Fields are
+// collected first so that a failed parse leaves the event unchanged
+func (p *ProcessorHttpd) parse(text string) ([]httpdValue, error) {
+	var (
+		host, user, timestamp, request, status, bytes string
+		err                                           error
+	)
+
+	values := make([]httpdValue, 0, 12)
+
+	if host, text, err = httpdToken(text); err != nil {
+		return nil, err
+	}
+	if _, text, err = httpdToken(text); err != nil {
+		return nil, err
+	}
+	if user, text, err = httpdToken(text); err != nil {
+		return nil, err
+	}
+	if timestamp, text, err = httpdBracketed(text); err != nil {
+		return nil, err
+	}
+	if request, text, err = httpdQuoted(text); err != nil {
+		return nil, err
+	}
+	if status, text, err = httpdToken(text); err != nil {
+		return nil, err
+	}
+	if bytes, text, err = httpdToken(text); err != nil {
+		return nil, err
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		values = append(values, httpdValue{"source.ip", host})
+	} else if host != httpdNil {
+		values = append(values, httpdValue{"source.domain", host})
+	}
+
+	if user != httpdNil {
+		values = append(values, httpdValue{"user.name", user})
+	}
+
+	values = append(values, httpdValue{"timestamp", timestamp})
+	values = httpdRequest(values, request)
+
+	statusCode, err := strconv.Atoi(status)
+	if err != nil {
+		return nil, errHttpdInvalid
+	}
+	values = append(values, httpdValue{"http.response.status_code", statusCode})
+
+	if bytes != httpdNil {
+		bodyBytes, err := strconv.ParseInt(bytes, 10, 64)
+		if err != nil {
+			return nil, errHttpdInvalid
+		}
+		values = append(values, httpdValue{"http.response.body.bytes", bodyBytes})
+	}
+
+	if p.config.Format != httpdFormatCommon {
+		var referrer, userAgent string
+		if referrer, text, err = httpdQuoted(text); err != nil {
+			return nil, err
+		}
+		if userAgent, text, err = httpdQuoted(text); err != nil {
+			return nil, err
+		}
+
+		if referrer != httpdNil {
+			values = append(values, httpdValue{"http.request.referrer", referrer})
+		}
+		if userAgent != httpdNil {
+			values = append(values, httpdValue{"user_agent.original", userAgent})
+		}
+	}
+
+	if p.config.Format == httpdFormatNginx {
+		var forwardedFor string
+		if forwardedFor, text, err = httpdQuoted(text); err != nil {
+			return nil, err
+		}
+
+		if forwardedFor != httpdNil {
+			values = append(values, httpdValue{"http.request.x_forwarded_for", forwardedFor})
+		}
+	}
+
+	if text != "" {
+		return nil, errHttpdInvalid
+	}
+
+	return values, nil
+}
+
+// httpdRequest adds the fields for the request line. A request line that is not
+// a valid HTTP request, such as one sent by a scanner, is kept only as the
+// original URL
+func httpdRequest(values []httpdValue, request string) []httpdValue {
+	if request == httpdNil || request == "" {
+		return values
+	}
+
+	parts := strings.Split(request, " ")
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/") {
+		return append(values, httpdValue{"url.original", request})
+	}
+
+	values = append(
+		values,
+		httpdValue{"http.request.method", parts[0]},
+		httpdValue{"url.original", parts[1]},
+		httpdValue{"http.version", parts[2][len("HTTP/"):]},
+	)
+
+	if query := strings.IndexByte(parts[1], '?'); query != -1 {
+		values = append(
+			values,
+			httpdValue{"url.path", parts[1][:query]},
+			httpdValue{"url.query", parts[1][query+1:]},
+		)
+	} else {
+		values = append(values, httpdValue{"url.path", parts[1]})
+	}
+
+	return values
+}
+
+// httpdToken returns the text up to the next space, and the remaining text
+// following the space
+func httpdToken(text string) (string, string, error) {
+	end := strings.IndexByte(text, ' ')
+	if end == -1 {
+		end = len(text)
+	}
+	if end == 0 {
+		return "", "", errHttpdInvalid
+	}
+
+	return text[:end], httpdNext(text[end:]), nil
+}
+
+// httpdBracketed returns the text within square brackets, such as the time,
+// and the remaining text following it
+func httpdBracketed(text string) (string, string, error) {
+	if !strings.HasPrefix(text, "[") {
+		return "", "", errHttpdInvalid
+	}
+
+	end := strings.IndexByte(text, ']')
+	if end == -1 {
+		return "", "", errHttpdInvalid
+	}
+
+	return text[1:end], httpdNext(text[end+1:]), nil
+}
+
+// httpdQuoted returns the text within double quotes, and the remaining text
+// following it. Quotes and backslashes within the text are escaped with a
+// backslash, and the escaping is removed
+func httpdQuoted(text string) (string, string, error) {
+	if !strings.HasPrefix(text, "\"") {
+		return "", "", errHttpdInvalid
+	}
+
+	text = text[1:]
+
+	// Fast path for text without escapes
+	end := strings.IndexAny(text, "\"\\")
+	if end == -1 {
+		return "", "", errHttpdInvalid
+	}
+	if text[end] == '"' {
+		return text[:end], httpdNext(text[end+1:]), nil
+	}
+
+	value := make([]byte, 0, len(text))
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) && (text[i+1] == '"' || text[i+1] == '\\') {
+			i++
+			value = append(value, text[i])
+		} else if text[i] == '"' {
+			return string(value), httpdNext(text[i+1:]), nil
+		} else {
+			value = append(value, text[i])
+		}
+	}
+
+	return "", "", errHttpdInvalid
+}
+
+// httpdNext removes the space separating a value from the next, returning an
+// empty string if there are no more values. Any other character following a
+// value means the line is invalid, and is left for the next value to reject
+func httpdNext(text string) string {
+	if strings.HasPrefix(text, " ") {
+		return text[1:]
+	}
+	return text
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("httpd", NewHttpdProcessorFactory)
+}
@@ -0,0 +1,175 @@
+package processors
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const httpdTestLine = `93.184.216.34 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif?size=large HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`
+
+func createHttpdProcessor(unused map[string]interface{}, t testing.TB) Processor {
+	factory, err := NewHttpdProcessorFactory(config.NewConfig(), "", unused, "httpd", "message")
+	if err != nil {
+		t.Logf("Failed to create httpd processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func checkPath(t *testing.T, event core.Event, path string, expected interface{}) {
+	if value, ok := event.GetPath(path); !ok {
+		t.Errorf("Field %s missing", path)
+	} else if value != expected {
+		t.Errorf("Field %s incorrect: %v != %v", path, value, expected)
+	}
+}
+
+func checkPathMissing(t *testing.T, event core.Event, path string) {
+	if value, ok := event.GetPath(path); ok {
+		t.Errorf("Field %s unexpectedly present: %v", path, value)
+	}
+}
+
+func checkHttpdFailure(t *testing.T, processor Processor, line string) {
+	event := processor.Process(core.Event{"message": line})
+
+	tags, ok := event["tags"].([]string)
+	if !ok || len(tags) != 1 || tags[0] != "_httpdparsefailure" {
+		t.Errorf("Event was not tagged for line: %s", line)
+	}
+	if len(event) != 2 {
+		t.Errorf("Event was modified on failure: %v", event)
+	}
+}
+
+func TestHttpdCombined(t *testing.T) {
+	processor := createHttpdProcessor(map[string]interface{}{}, t)
+
+	event := processor.Process(core.Event{"message": httpdTestLine})
+
+	checkPath(t, event, "message", httpdTestLine)
+	checkPath(t, event, "source.ip", "93.184.216.34")
+	checkPath(t, event, "user.name", "frank")
+	checkPath(t, event, "timestamp", "10/Oct/2000:13:55:36 -0700")
+	checkPath(t, event, "http.request.method", "GET")
+	checkPath(t, event, "http.version", "1.0")
+	checkPath(t, event, "url.original", "/apache_pb.gif?size=large")
+	checkPath(t, event, "url.path", "/apache_pb.gif")
+	checkPath(t, event, "url.query", "size=large")
+	checkPath(t, event, "http.response.status_code", 200)
+	checkPath(t, event, "http.response.body.bytes", int64(2326))
+	checkPath(t, event, "http.request.referrer", "http://www.example.com/start.html")
+	checkPath(t, event, "user_agent.original", "Mozilla/4.08 [en] (Win98; I ;Nav)")
+}
+
+func TestHttpdCombinedMissingValues(t *testing.T) {
+	processor := createHttpdProcessor(map[string]interface{}{}, t)
+
+	event := processor.Process(core.Event{"message": `2001:db8::1 - - [05/Feb/2024:06:25:01 +0000] "HEAD / HTTP/1.1" 304 - "-" "-"`})
+
+	checkPath(t, event, "source.ip", "2001:db8::1")
+	checkPath(t, event, "url.path", "/")
+	checkPath(t, event, "http.response.status_code", 304)
+	checkPathMissing(t, event, "user.name")
+	checkPathMissing(t, event, "url.query")
+	checkPathMissing(t, event, "http.response.body.bytes")
+	checkPathMissing(t, event, "http.request.referrer")
+	checkPathMissing(t, event, "user_agent.original")
+}
+
+func TestHttpdEscapedQuotes(t *testing.T) {
+	processor := createHttpdProcessor(map[string]interface{}{}, t)
+
+	event := processor.Process(core.Event{"message": `client.example.com - - [05/Feb/2024:06:25:01 +0000] "GET /search?q=\"quoted\" HTTP/1.1" 200 512 "-" "curl/7.68.0 \"custom\" \\ agent"`})
+
+	checkPath(t, event, "source.domain", "client.example.com")
+	checkPathMissing(t, event, "source.ip")
+	checkPath(t, event, "url.query", `q="quoted"`)
+	checkPath(t, event, "user_agent.original", `curl/7.68.0 "custom" \ agent`)
+}
+
+func TestHttpdInvalidRequest(t *testing.T) {
+	processor := createHttpdProcessor(map[string]interface{}{}, t)
+
+	event := processor.Process(core.Event{"message": `10.0.0.1 - - [05/Feb/2024:06:25:01 +0000] "\x16\x03\x01\x02\x00\x01" 400 226 "-" "-"`})
+
+	checkPath(t, event, "url.original", `\x16\x03\x01\x02\x00\x01`)
+	checkPath(t, event, "http.response.status_code", 400)
+	checkPathMissing(t, event, "http.request.method")
+
+	event = processor.Process(core.Event{"message": `10.0.0.1 - - [05/Feb/2024:06:25:01 +0000] "-" 408 - "-" "-"`})
+
+	checkPath(t, event, "http.response.status_code", 408)
+	checkPathMissing(t, event, "url.original")
+}
+
+func TestHttpdCommon(t *testing.T) {
+	processor := createHttpdProcessor(map[string]interface{}{"format": "common"}, t)
+
+	event := processor.Process(core.Event{"message": `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`})
+
+	checkPath(t, event, "source.ip", "127.0.0.1")
+	checkPath(t, event, "http.response.body.bytes", int64(2326))
+	checkPathMissing(t, event, "user_agent.original")
+
+	checkHttpdFailure(t, processor, httpdTestLine)
+}
+
+func TestHttpdNginx(t *testing.T) {
+	processor := createHttpdProcessor(map[string]interface{}{"format": "nginx"}, t)
+
+	event := processor.Process(core.Event{"message": `172.17.0.1 - - [05/Feb/2024:17:32:18 +0000] "POST /api/v1/items HTTP/2.0" 201 48 "https://example.com/" "Mozilla/5.0 (X11; Linux x86_64)" "203.0.113.7, 198.51.100.2"`})
+
+	checkPath(t, event, "http.request.method", "POST")
+	checkPath(t, event, "http.version", "2.0")
+	checkPath(t, event, "http.response.status_code", 201)
+	checkPath(t, event, "http.request.x_forwarded_for", "203.0.113.7, 198.51.100.2")
+
+	checkHttpdFailure(t, processor, httpdTestLine)
+}
+
+func TestHttpdInvalid(t *testing.T) {
+	processor := createHttpdProcessor(map[string]interface{}{}, t)
+
+	checkHttpdFailure(t, processor, "not an access log line")
+	checkHttpdFailure(t, processor, `127.0.0.1 - - 10/Oct/2000:13:55:36 -0700 "GET / HTTP/1.0" 200 1 "-" "-"`)
+	checkHttpdFailure(t, processor, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" OK 1 "-" "-"`)
+	checkHttpdFailure(t, processor, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 1 "-" "unterminated`)
+	checkHttpdFailure(t, processor, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 1 "-" "-" trailing`)
+}
+
+func TestHttpdInvalidFormat(t *testing.T) {
+	if _, err := NewHttpdProcessorFactory(config.NewConfig(), "", map[string]interface{}{"format": "iis"}, "httpd", "message"); err == nil {
+		t.Error("Invalid format was accepted")
+	}
+}
+
+func BenchmarkHttpd(b *testing.B) {
+	processor := createHttpdProcessor(map[string]interface{}{}, b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		processor.Process(core.Event{"message": httpdTestLine})
+	}
+}
+
+// BenchmarkHttpdRegexp performs a comparable extraction into the same fields
+// using a regular expression, as a grok pattern would, for comparison with
+// BenchmarkHttpd
+func BenchmarkHttpdRegexp(b *testing.B) {
+	pattern := regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]*)\] "(\S+) (\S+) HTTP/([^"]*)" (\d+) (\d+|-) "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)"$`)
+	paths := []string{"", "source.ip", "user.name", "timestamp", "http.request.method", "url.original", "http.version", "http.response.status_code", "http.response.body.bytes", "http.request.referrer", "user_agent.original"}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		event := core.Event{"message": httpdTestLine}
+		matches := pattern.FindStringSubmatch(event["message"].(string))
+		for n := 1; n < len(matches); n++ {
+			event.SetPath(paths[n], matches[n])
+		}
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// ProcessorIfFactory holds the configuration for an if processor
+type ProcessorIfFactory struct {
+	Condition string                 `config:"condition"`
+	Else      []config.ProcessorStub `config:"else"`
+	Then      []config.ProcessorStub `config:"then"`
+
+	condition condition
+}
+
+// ProcessorIf is an instance of an if processor
+type ProcessorIf struct {
+	config    *ProcessorIfFactory
+	then      []Processor
+	otherwise []Processor
+	matched   uint64
+	unmatched uint64
+}
+
+// NewIfProcessorFactory creates a new ProcessorIfFactory for a processor
+// definition in the configuration file. The condition is compiled here so that
+// each event only needs to be evaluated against it
+func NewIfProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	result := &ProcessorIfFactory{}
+	if err := config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.Condition == "" {
+		return nil, errors.New("If processor condition must be specified.")
+	}
+
+	var err error
+	if result.condition, err = compileCondition(result.Condition); err != nil {
+		return nil, fmt.Errorf("If processor condition is invalid: %s", err)
+	}
+
+	if len(result.Then) == 0 && len(result.Else) == 0 {
+		return nil, errors.New("If processor then or else must be specified.")
+	}
+
+	if err = config.InitProcessors(configPath+"then", result.Then, messageField); err != nil {
+		return nil, err
+	}
+
+	if err = config.InitProcessors(configPath+"else", result.Else, messageField); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// NewProcessor returns a new processor instance, along with new instances of
+// the nested processors
+func (f *ProcessorIfFactory) NewProcessor() Processor {
+	ret := &ProcessorIf{
+		config:    f,
+		then:      make([]Processor, len(f.Then)),
+		otherwise: make([]Processor, len(f.Else)),
+	}
+
+	for i := range f.Then {
+		ret.then[i] = NewProcessor(f.Then[i].Factory)
+	}
+
+	for i := range f.Else {
+		ret.otherwise[i] = NewProcessor(f.Else[i].Factory)
+	}
+
+	return ret
+}
+
+// Process runs the "then" processors over the event if the condition is true,
+// and the "else" processors if it is not. If a nested processor drops the event
+// it is dropped
+func (p *ProcessorIf) Process(event core.Event) core.Event {
+	processors := p.otherwise
+	if p.config.condition.evaluate(event) {
+		atomic.AddUint64(&p.matched, 1)
+		processors = p.then
+	} else {
+		atomic.AddUint64(&p.unmatched, 1)
+	}
+
+	for _, processor := range processors {
+		if event = processor.Process(event); event == nil {
+			return nil
+		}
+	}
+
+	return event
+}
+
+// APIEncodable returns an admin API entry with the processor status, including
+// the status of nested processors that report one
+func (p *ProcessorIf) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("matched_events", admin.APINumber(atomic.LoadUint64(&p.matched)))
+	api.SetEntry("unmatched_events", admin.APINumber(atomic.LoadUint64(&p.unmatched)))
+	api.SetEntry("then", nestedProcessorsAPI(p.then, p.config.Then))
+	api.SetEntry("else", nestedProcessorsAPI(p.otherwise, p.config.Else))
+	return api
+}
+
+// nestedProcessorsAPI returns an admin API entry with the status of each
+// processor in a list that reports one
+func nestedProcessorsAPI(processors []Processor, stubs []config.ProcessorStub) *admin.APIArray {
+	api := &admin.APIArray{}
+	names := make(map[string]bool)
+	for i, processor := range processors {
+		if apiProcessor, ok := processor.(APIProcessor); ok {
+			// Processors can repeat so qualify repeated names with their position
+			name := stubs[i].Name
+			if names[name] {
+				name = fmt.Sprintf("%s %d", name, i+1)
+			}
+			names[name] = true
+			api.AddEntry(name, admin.NewAPIDataEntry(apiProcessor.APIEncodable()))
+		}
+	}
+	return api
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("if", NewIfProcessorFactory)
+}
@@ -0,0 +1,183 @@
+package processors
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createIfProcessor(unused map[string]interface{}, t *testing.T) Processor {
+	factory, err := NewIfProcessorFactory(config.NewConfig(), "/processors[0]/", unused, "if", "message")
+	if err != nil {
+		t.Logf("Failed to create if processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func TestCondition(t *testing.T) {
+	event := core.Event{
+		"type":    "nginx",
+		"message": "GET /index.html",
+		"status":  json.Number("200"),
+		"latency": json.Number("200.0"),
+		"code":    "007",
+		"http":    map[string]interface{}{"method": "GET"},
+		"empty":   nil,
+	}
+
+	tests := map[string]bool{
+		`type == "nginx"`:                        true,
+		`type == "apache"`:                       false,
+		`type != "apache"`:                       true,
+		`status == 200`:                          true,
+		`status == "200"`:                        true,
+		`latency == 200`:                         true,
+		`latency == 2e2`:                         true,
+		`latency != 200`:                         false,
+		`code == 7`:                              true,
+		`code == "007"`:                          true,
+		`type == 0`:                              false,
+		`http.method == "GET"`:                   true,
+		`message =~ "^GET "`:                     true,
+		`message !~ "^GET "`:                     false,
+		`type`:                                   true,
+		`missing`:                                false,
+		`empty`:                                  false,
+		`not missing`:                            true,
+		`!type`:                                  false,
+		`type == "nginx" and status == 500`:      false,
+		`type == "nginx" && missing || status`:   true,
+		`type == "apache" or message =~ "html$"`: true,
+		`not (type == "nginx" or missing)`:       false,
+		`missing == "x" or not missing == "x"`:   true,
+		`type == "a\"b" || type =~ "^ng"`:        true,
+	}
+
+	for text, expected := range tests {
+		compiled, err := compileCondition(text)
+		if err != nil {
+			t.Errorf("Failed to compile %s: %s", text, err)
+			continue
+		}
+
+		if result := compiled.evaluate(event); result != expected {
+			t.Errorf("Condition %s was %t, expected %t", text, result, expected)
+		}
+	}
+}
+
+func TestConditionInvalid(t *testing.T) {
+	for _, text := range []string{
+		``,
+		`type ==`,
+		`type == nginx`,
+		`type =~ 5`,
+		`message =~ "("`,
+		`(type`,
+		`type)`,
+		`type == "nginx" and`,
+		`"nginx"`,
+		`type == "nginx`,
+		`type # 5`,
+	} {
+		if _, err := compileCondition(text); err == nil {
+			t.Errorf("Invalid condition was accepted: %s", text)
+		}
+	}
+}
+
+func TestIfThenElse(t *testing.T) {
+	processor := createIfProcessor(map[string]interface{}{
+		"condition": `type == "nginx"`,
+		"then": []interface{}{
+			map[string]interface{}{"name": "grok", "pattern": "^%{WORD:method} "},
+		},
+		"else": []interface{}{
+			map[string]interface{}{"name": "drop", "patterns": []interface{}{"^health"}},
+		},
+	}, t)
+
+	event := processor.Process(core.Event{"type": "nginx", "message": "GET /"})
+	if event == nil || event["method"] != "GET" {
+		t.Errorf("Then processors did not run: %v", event)
+	}
+
+	event = processor.Process(core.Event{"type": "other", "message": "GET /"})
+	if event == nil || event["method"] != nil {
+		t.Errorf("Then processors ran when the condition was false: %v", event)
+	}
+
+	if event = processor.Process(core.Event{"type": "other", "message": "health check"}); event != nil {
+		t.Errorf("Else processors did not drop the event: %v", event)
+	}
+
+	if matched, unmatched := processor.(*ProcessorIf).matched, processor.(*ProcessorIf).unmatched; matched != 1 || unmatched != 2 {
+		t.Errorf("Unexpected counts: %d matched, %d unmatched", matched, unmatched)
+	}
+}
+
+func TestIfNested(t *testing.T) {
+	processor := createIfProcessor(map[string]interface{}{
+		"condition": `type == "nginx"`,
+		"then": []interface{}{
+			map[string]interface{}{"name": "grok", "pattern": "^%{WORD:method} %{NOTSPACE:path}"},
+			map[string]interface{}{
+				"name":      "if",
+				"condition": `path =~ "^/api/"`,
+				"then": []interface{}{
+					map[string]interface{}{"name": "grok", "field": "path", "pattern": "^/api/%{WORD:api}"},
+				},
+				"else": []interface{}{
+					map[string]interface{}{
+						"name":      "if",
+						"condition": `method == "POST"`,
+						"then": []interface{}{
+							map[string]interface{}{"name": "remove", "fields": []interface{}{"path"}},
+						},
+					},
+				},
+			},
+		},
+	}, t)
+
+	event := processor.Process(core.Event{"type": "nginx", "message": "GET /api/users"})
+	if event["api"] != "users" {
+		t.Errorf("Nested then processors did not run: %v", event)
+	}
+
+	event = processor.Process(core.Event{"type": "nginx", "message": "POST /login"})
+	if _, ok := event["api"]; ok {
+		t.Errorf("Nested then processors ran when the condition was false: %v", event)
+	}
+	if _, ok := event["path"]; ok {
+		t.Errorf("Nested else if processors did not run: %v", event)
+	}
+
+	event = processor.Process(core.Event{"type": "nginx", "message": "GET /login"})
+	if event["path"] != "/login" {
+		t.Errorf("Nested else if processors ran when the condition was false: %v", event)
+	}
+
+	event = processor.Process(core.Event{"type": "apache", "message": "GET /api/users"})
+	if _, ok := event["method"]; ok {
+		t.Errorf("Processors ran when the outer condition was false: %v", event)
+	}
+}
+
+func TestIfInvalid(t *testing.T) {
+	for _, unused := range []map[string]interface{}{
+		{"then": []interface{}{map[string]interface{}{"name": "remove", "fields": []interface{}{"a"}}}},
+		{"condition": "type =="},
+		{"condition": "type"},
+		{"condition": "type", "then": []interface{}{map[string]interface{}{"name": "unknown"}}},
+		{"condition": "type", "else": []interface{}{map[string]interface{}{"name": "grok"}}},
+	} {
+		if _, err := NewIfProcessorFactory(config.NewConfig(), "/processors[0]/", unused, "if", "message"); err == nil {
+			t.Errorf("Invalid if processor was accepted: %v", unused)
+		}
+	}
+}
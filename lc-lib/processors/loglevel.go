@@ -0,0 +1,192 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultLogLevelFailureTag string = "_loglevelfailure"
+	defaultLogLevelPreset     string = "standard"
+	defaultLogLevelTarget     string = "log.level"
+)
+
+var (
+	// logLevelSeverities maps each canonical level to its syslog severity
+	logLevelSeverities = map[string]int{
+		"trace": 7,
+		"debug": 7,
+		"info":  6,
+		"warn":  4,
+		"error": 3,
+		"fatal": 2,
+	}
+
+	// logLevelPresets holds the synonyms of each canonical level for each preset.
+	// The standard preset covers the common spellings and abbreviations, the
+	// syslog keywords and numeric severities, and the levels of Java logging
+	logLevelPresets = map[string]map[string][]string{
+		"none": {},
+		"standard": {
+			"trace": {"trace", "trc", "t", "finest", "finer", "verbose", "v"},
+			"debug": {"debug", "dbg", "d", "fine", "config", "7"},
+			"info":  {"info", "inf", "i", "information", "informational", "notice", "n", "6", "5"},
+			"warn":  {"warn", "wrn", "w", "warning", "4"},
+			"error": {"error", "err", "e", "severe", "3"},
+			"fatal": {"fatal", "ftl", "f", "critical", "crit", "c", "alert", "a", "emergency", "emerg", "panic", "2", "1", "0"},
+		},
+	}
+)
+
+// ProcessorLogLevelFactory holds the configuration for a loglevel processor
+type ProcessorLogLevelFactory struct {
+	Default       string            `config:"default"`
+	Field         string            `config:"field"`
+	Mappings      map[string]string `config:"mappings"`
+	Preset        string            `config:"preset"`
+	SeverityField string            `config:"severity field"`
+	Target        string            `config:"target"`
+
+	levels map[string]string
+}
+
+// ProcessorLogLevel is an instance of a loglevel processor
+type ProcessorLogLevel struct {
+	config *ProcessorLogLevelFactory
+}
+
+// NewLogLevelProcessorFactory creates a new ProcessorLogLevelFactory for a
+// processor definition in the configuration file
+func NewLogLevelProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	result := &ProcessorLogLevelFactory{}
+	if err := config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.Field == "" {
+		return nil, errors.New("Loglevel processor field must be specified.")
+	}
+
+	if result.Target == "" {
+		return nil, errors.New("Loglevel processor target can not be empty.")
+	}
+
+	preset, ok := logLevelPresets[result.Preset]
+	if !ok {
+		return nil, fmt.Errorf("Loglevel processor preset is not recognised: %s", result.Preset)
+	}
+
+	if result.Default != "" {
+		if _, ok := logLevelSeverities[result.Default]; !ok {
+			return nil, fmt.Errorf("Loglevel processor default is not a recognised level: %s", result.Default)
+		}
+	}
+
+	// Build the table once so each event needs only a single lookup
+	result.levels = make(map[string]string)
+	for level, synonyms := range preset {
+		for _, synonym := range synonyms {
+			result.levels[synonym] = level
+		}
+	}
+
+	for synonym, level := range result.Mappings {
+		if _, ok := logLevelSeverities[level]; !ok {
+			return nil, fmt.Errorf("Loglevel processor mapping for \"%s\" is not a recognised level: %s", synonym, level)
+		}
+		result.levels[strings.ToLower(synonym)] = level
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for a loglevel processor
+func (f *ProcessorLogLevelFactory) InitDefaults() {
+	f.Preset = defaultLogLevelPreset
+	f.Target = defaultLogLevelTarget
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorLogLevelFactory) NewProcessor() Processor {
+	return &ProcessorLogLevel{
+		config: f,
+	}
+}
+
+// Process normalises the level in the source field to one of trace, debug,
+// info, warn, error or fatal, writing it to the target field along with its
+// syslog severity if a severity field is configured. Unrecognised levels are
+// replaced with the default level, or if there is none the event is tagged
+// with "_loglevelfailure" and left otherwise unchanged
+func (p *ProcessorLogLevel) Process(event core.Event) core.Event {
+	value, _ := event.GetPath(p.config.Field)
+
+	level, ok := p.lookup(value)
+	if !ok {
+		if p.config.Default == "" {
+			event.AddTags(defaultLogLevelFailureTag)
+			return event
+		}
+		level = p.config.Default
+	}
+
+	event.SetPath(p.config.Target, level)
+
+	if p.config.SeverityField != "" {
+		event.SetPath(p.config.SeverityField, logLevelSeverities[level])
+	}
+
+	return event
+}
+
+// lookup returns the canonical level for the given value, which may be a
+// string or a whole number
+func (p *ProcessorLogLevel) lookup(value interface{}) (string, bool) {
+	var key string
+
+	switch vt := value.(type) {
+	case string:
+		key = strings.ToLower(strings.TrimSpace(vt))
+	case int:
+		key = strconv.Itoa(vt)
+	case int64:
+		key = strconv.FormatInt(vt, 10)
+	case float64:
+		if vt != math.Trunc(vt) {
+			return "", false
+		}
+		key = strconv.FormatFloat(vt, 'f', -1, 64)
+	default:
+		return "", false
+	}
+
+	level, ok := p.config.levels[key]
+	return level, ok
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("loglevel", NewLogLevelProcessorFactory)
+}
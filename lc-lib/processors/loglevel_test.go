@@ -0,0 +1,105 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createLogLevelProcessor(unused map[string]interface{}, t *testing.T) Processor {
+	factory, err := NewLogLevelProcessorFactory(config.NewConfig(), "", unused, "loglevel", "message")
+	if err != nil {
+		t.Logf("Failed to create loglevel processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func checkLogLevel(t *testing.T, processor Processor, value interface{}, expected string) {
+	event := processor.Process(core.Event{"level": value})
+	checkPath(t, event, "log.level", expected)
+}
+
+func TestLogLevel(t *testing.T) {
+	processor := createLogLevelProcessor(map[string]interface{}{
+		"field": "level",
+	}, t)
+
+	checkLogLevel(t, processor, "WARN", "warn")
+	checkLogLevel(t, processor, "warning", "warn")
+	checkLogLevel(t, processor, "W", "warn")
+	checkLogLevel(t, processor, " Error ", "error")
+	checkLogLevel(t, processor, "SEVERE", "error")
+	checkLogLevel(t, processor, "notice", "info")
+	checkLogLevel(t, processor, "FINEST", "trace")
+	checkLogLevel(t, processor, "emerg", "fatal")
+
+	// Numeric syslog severities, as strings and as decoded from JSON
+	checkLogLevel(t, processor, "3", "error")
+	checkLogLevel(t, processor, float64(7), "debug")
+	checkLogLevel(t, processor, 0, "fatal")
+}
+
+func TestLogLevelUnknown(t *testing.T) {
+	processor := createLogLevelProcessor(map[string]interface{}{
+		"field": "level",
+	}, t)
+
+	for _, value := range []interface{}{"loud", float64(3.5), 8, nil} {
+		event := processor.Process(core.Event{"level": value})
+
+		checkPathMissing(t, event, "log.level")
+		tags, ok := event["tags"].([]string)
+		if !ok || len(tags) != 1 || tags[0] != "_loglevelfailure" {
+			t.Errorf("Event was not tagged for value: %v", value)
+		}
+	}
+}
+
+func TestLogLevelDefault(t *testing.T) {
+	processor := createLogLevelProcessor(map[string]interface{}{
+		"field":   "level",
+		"default": "info",
+	}, t)
+
+	event := processor.Process(core.Event{"level": "loud"})
+
+	checkPath(t, event, "log.level", "info")
+	if _, ok := event["tags"]; ok {
+		t.Errorf("Event was tagged when a default was given: %v", event["tags"])
+	}
+}
+
+func TestLogLevelMappings(t *testing.T) {
+	processor := createLogLevelProcessor(map[string]interface{}{
+		"field":          "level",
+		"preset":         "none",
+		"mappings":       map[string]interface{}{"Oops": "error", "HMM": "warn"},
+		"severity field": "log.severity",
+	}, t)
+
+	event := processor.Process(core.Event{"level": "oops"})
+	checkPath(t, event, "log.level", "error")
+	checkPath(t, event, "log.severity", 3)
+
+	checkLogLevel(t, processor, "hmm", "warn")
+
+	// The standard preset is not used
+	event = processor.Process(core.Event{"level": "warn"})
+	checkPathMissing(t, event, "log.level")
+}
+
+func TestLogLevelInvalid(t *testing.T) {
+	for _, unused := range []map[string]interface{}{
+		{},
+		{"field": "level", "preset": "unknown"},
+		{"field": "level", "default": "loud"},
+		{"field": "level", "mappings": map[string]interface{}{"oops": "bad"}},
+	} {
+		if _, err := NewLogLevelProcessorFactory(config.NewConfig(), "", unused, "loglevel", "message"); err == nil {
+			t.Errorf("Invalid configuration was accepted: %v", unused)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// Metrics records how many events a list of processors has been run over and
+// the time spent in each processor. Each routine that runs processors should
+// have its own Metrics so that recording never contends, with the totals
+// summed when they are reported. All methods are safe to call from other
+// routines
+type Metrics struct {
+	events    uint64
+	durations []int64
+}
+
+// NewMetrics returns a new Metrics for a list of the given number of processors
+func NewMetrics(count int) *Metrics {
+	return &Metrics{
+		durations: make([]int64, count),
+	}
+}
+
+// Process runs the processors over the event in order, recording the time
+// spent in each, and returns the result. If a processor drops the event those
+// after it are not run and nil is returned. The processors must be the list
+// the Metrics was created for
+func (m *Metrics) Process(processors []Processor, event core.Event) core.Event {
+	atomic.AddUint64(&m.events, 1)
+
+	start := time.Now()
+	for i, processor := range processors {
+		event = processor.Process(event)
+
+		end := time.Now()
+		atomic.AddInt64(&m.durations[i], int64(end.Sub(start)))
+		start = end
+
+		if event == nil {
+			return nil
+		}
+	}
+
+	return event
+}
+
+// Events returns the number of events the processors have been run over
+func (m *Metrics) Events() uint64 {
+	return atomic.LoadUint64(&m.events)
+}
+
+// Duration returns the total time spent in the processor at the given position
+func (m *Metrics) Duration(index int) time.Duration {
+	return time.Duration(atomic.LoadInt64(&m.durations[index]))
+}
+
+// Total returns the total time spent in all of the processors
+func (m *Metrics) Total() time.Duration {
+	var total time.Duration
+	for i := range m.durations {
+		total += m.Duration(i)
+	}
+	return total
+}
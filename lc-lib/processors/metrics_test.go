@@ -0,0 +1,59 @@
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// slowProcessor takes at least the given time to process each event
+type slowProcessor struct {
+	delay time.Duration
+}
+
+func (p *slowProcessor) Process(event core.Event) core.Event {
+	time.Sleep(p.delay)
+	return event
+}
+
+func TestMetrics(t *testing.T) {
+	factory, err := NewDropProcessorFactory(config.NewConfig(), "", map[string]interface{}{
+		"patterns": []interface{}{"^drop"},
+	}, "drop", "message")
+	if err != nil {
+		t.Fatalf("Failed to create drop processor: %s", err)
+	}
+
+	processors := []Processor{NewProcessor(factory), &slowProcessor{delay: time.Millisecond}}
+	metrics := NewMetrics(len(processors))
+
+	kept := 0
+	for i := 0; i < 10; i++ {
+		message := "keep"
+		if i%2 == 1 {
+			message = "drop"
+		}
+		if event := metrics.Process(processors, core.Event{"message": message}); event != nil {
+			kept++
+		}
+	}
+
+	if kept != 5 {
+		t.Errorf("Unexpected number of events kept: %d", kept)
+	}
+
+	if events := metrics.Events(); events != 10 {
+		t.Errorf("Unexpected number of events: %d", events)
+	}
+
+	// The slow processor only runs for the events that were not dropped
+	if duration := metrics.Duration(1); duration < 5*time.Millisecond {
+		t.Errorf("Unexpected duration of the slow processor: %s", duration)
+	}
+
+	if total := metrics.Total(); total != metrics.Duration(0)+metrics.Duration(1) {
+		t.Errorf("Total does not match the processors: %s", total)
+	}
+}
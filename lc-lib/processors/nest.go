@@ -0,0 +1,167 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultNestSeparator  string = "."
+	defaultNestFailureTag string = "_nestfailure"
+)
+
+// ProcessorNestFactory holds the configuration for a nest processor
+type ProcessorNestFactory struct {
+	Field     string `config:"field"`
+	Separator string `config:"separator"`
+}
+
+// ProcessorNest is an instance of a nest processor
+type ProcessorNest struct {
+	config *ProcessorNestFactory
+}
+
+// NewNestProcessorFactory creates a new ProcessorNestFactory for a processor
+// definition in the configuration file
+func NewNestProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	result := &ProcessorNestFactory{}
+	if err := config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.Separator == "" {
+		return nil, errors.New("Nest processor separator can not be empty.")
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for a nest processor
+func (f *ProcessorNestFactory) InitDefaults() {
+	f.Separator = defaultNestSeparator
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorNestFactory) NewProcessor() Processor {
+	return &ProcessorNest{
+		config: f,
+	}
+}
+
+// Process splits keys within the event, or within the configured field, that
+// contain the separator into nested dictionaries, reversing the flatten
+// processor. Arrays are not descended into. If a key can not be nested because
+// a field already exists in its place, it is left unchanged and the event is
+// tagged with "_nestfailure"
+func (p *ProcessorNest) Process(event core.Event) core.Event {
+	target := map[string]interface{}(event)
+	if p.config.Field != "" {
+		value, _ := event.GetPath(p.config.Field)
+		var ok bool
+		if target, ok = value.(map[string]interface{}); !ok {
+			return event
+		}
+	}
+
+	if !p.nest(target) {
+		event.AddTags(defaultNestFailureTag)
+	}
+
+	return event
+}
+
+// nest nests the keys of the given dictionary in place, and those of any
+// dictionaries within it, returning false if any keys conflicted. Keys are
+// processed in order so the result is predictable
+func (p *ProcessorNest) nest(target map[string]interface{}) bool {
+	keys := make([]string, 0, len(target))
+	for key := range target {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	success := true
+	for _, key := range keys {
+		value := target[key]
+		if child, ok := value.(map[string]interface{}); ok {
+			success = p.nest(child) && success
+		}
+
+		parts := strings.Split(key, p.config.Separator)
+		if len(parts) == 1 || !validNestParts(parts) {
+			continue
+		}
+
+		// Only a missing part of the path is ever created, after which the rest
+		// of the path is also missing, so a conflict never leaves a partial path
+		current := target
+		conflict := false
+		for _, part := range parts[:len(parts)-1] {
+			existing, exists := current[part]
+			if !exists {
+				next := make(map[string]interface{})
+				current[part] = next
+				current = next
+				continue
+			}
+
+			next, ok := existing.(map[string]interface{})
+			if !ok {
+				conflict = true
+				break
+			}
+			current = next
+		}
+
+		last := parts[len(parts)-1]
+		if !conflict {
+			_, conflict = current[last]
+		}
+		if conflict {
+			success = false
+			continue
+		}
+
+		delete(target, key)
+		current[last] = value
+	}
+
+	return success
+}
+
+// validNestParts returns false if any part of a split key is empty, such as
+// when the key begins or ends with the separator, in which case the key is not
+// nested
+func validNestParts(parts []string) bool {
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("nest", NewNestProcessorFactory)
+}
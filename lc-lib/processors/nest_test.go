@@ -0,0 +1,105 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createNestProcessor(unused map[string]interface{}, t *testing.T) Processor {
+	factory, err := NewNestProcessorFactory(config.NewConfig(), "", unused, "nest", "message")
+	if err != nil {
+		t.Logf("Failed to create nest processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func TestNest(t *testing.T) {
+	processor := createNestProcessor(map[string]interface{}{}, t)
+
+	event := processor.Process(core.Event{
+		"message": "test",
+		"a.b":     1,
+		"a.c.d":   "x",
+		"a":       map[string]interface{}{"e": 2, "f.g": 3},
+		"list":    []interface{}{map[string]interface{}{"h.i": 1}},
+		".j":      4,
+	})
+
+	expected := core.Event{
+		"message": "test",
+		"a": map[string]interface{}{
+			"b": 1,
+			"c": map[string]interface{}{"d": "x"},
+			"e": 2,
+			"f": map[string]interface{}{"g": 3},
+		},
+		"list": []interface{}{map[string]interface{}{"h.i": 1}},
+		".j":   4,
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event: %v", event)
+	}
+
+	// Nesting again must not change anything
+	if event = processor.Process(event); !reflect.DeepEqual(event, expected) {
+		t.Errorf("Nest is not idempotent: %v", event)
+	}
+}
+
+func TestNestReversesFlatten(t *testing.T) {
+	original := func() core.Event {
+		return core.Event{
+			"a": map[string]interface{}{"b": 1, "c": map[string]interface{}{"d": "x"}},
+		}
+	}
+
+	event := createFlattenProcessor(map[string]interface{}{}, t).Process(original())
+	event = createNestProcessor(map[string]interface{}{}, t).Process(event)
+
+	if !reflect.DeepEqual(event, original()) {
+		t.Errorf("Unexpected event: %v", event)
+	}
+}
+
+func TestNestField(t *testing.T) {
+	processor := createNestProcessor(map[string]interface{}{
+		"field":     "labels",
+		"separator": "_",
+	}, t)
+
+	event := processor.Process(core.Event{
+		"labels":   map[string]interface{}{"app_name": "web"},
+		"app_name": "kept",
+	})
+
+	expected := core.Event{
+		"labels":   map[string]interface{}{"app": map[string]interface{}{"name": "web"}},
+		"app_name": "kept",
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event: %v", event)
+	}
+}
+
+func TestNestConflict(t *testing.T) {
+	processor := createNestProcessor(map[string]interface{}{}, t)
+
+	event := processor.Process(core.Event{
+		"a":   1,
+		"a.b": 2,
+	})
+
+	expected := core.Event{
+		"a":    1,
+		"a.b":  2,
+		"tags": []string{"_nestfailure"},
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event: %v", event)
+	}
+}
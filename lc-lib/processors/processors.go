@@ -0,0 +1,51 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// Processor is the generic interface that all processors implement
+// Process receives an event after the automatic fields have been added and
+// returns the event to ship, which may be the same event modified in place. If
+// nil is returned the event is dropped
+type Processor interface {
+	Process(core.Event) core.Event
+}
+
+// APIProcessor is implemented by processors that report their status, such as
+// counters, in the API of the harvester they belong to. APIEncodable may be
+// called from a different routine to Process
+type APIProcessor interface {
+	APIEncodable() admin.APIEncodable
+}
+
+// processorFactory is the interface that all processor factories implement.
+// The processor factory should store the processor's configuration and, when
+// NewProcessor is called, return an instance of the processor that obeys that
+// configuration
+type processorFactory interface {
+	NewProcessor() Processor
+}
+
+// NewProcessor returns a Processor interface initialised from the given
+// Factory
+func NewProcessor(factory interface{}) Processor {
+	return factory.(processorFactory).NewProcessor()
+}
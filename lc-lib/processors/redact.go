@@ -0,0 +1,244 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultRedactMask   string = "[REDACTED]"
+	defaultRedactMethod string = redactMethodMask
+
+	redactMethodMask = "mask"
+	redactMethodHash = "hash"
+)
+
+// redactPattern is a compiled pattern along with an optional validation of each
+// match, for patterns that can not be expressed accurately as a regular
+// expression alone
+type redactPattern struct {
+	regexp   *regexp.Regexp
+	validate func(string) bool
+}
+
+// redactNamedPatterns are the built in patterns for common sensitive data
+var redactNamedPatterns = map[string]redactPattern{
+	"credit card": {
+		regexp:   regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		validate: isLuhnValid,
+	},
+	"email": {
+		regexp: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	},
+	"ipv4": {
+		regexp: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`),
+	},
+	"ipv6": {
+		regexp:   regexp.MustCompile(`(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}`),
+		validate: isIPv6,
+	},
+}
+
+// ProcessorRedactFactory holds the configuration for a redact processor
+type ProcessorRedactFactory struct {
+	CustomPatterns []string `config:"custom patterns"`
+	Fields         []string `config:"fields"`
+	HashKey        string   `config:"hash key"`
+	Mask           string   `config:"mask"`
+	Method         string   `config:"method"`
+	Patterns       []string `config:"patterns"`
+
+	compiled []redactPattern
+}
+
+// ProcessorRedact is an instance of a redact processor
+type ProcessorRedact struct {
+	config *ProcessorRedactFactory
+}
+
+// NewRedactProcessorFactory creates a new ProcessorRedactFactory for a
+// processor definition in the configuration file. All patterns are compiled
+// here so that each event only needs to be matched against them
+func NewRedactProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	result := &ProcessorRedactFactory{}
+	if err := config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	// Array options are appended to rather than replaced, so the default field
+	// can only be applied here
+	if len(result.Fields) == 0 {
+		result.Fields = []string{messageField}
+	}
+
+	if result.Method != redactMethodMask && result.Method != redactMethodHash {
+		return nil, fmt.Errorf("Redact processor method must be \"%s\" or \"%s\".", redactMethodMask, redactMethodHash)
+	}
+
+	for _, name := range result.Patterns {
+		pattern, ok := redactNamedPatterns[name]
+		if !ok {
+			return nil, fmt.Errorf("Redact processor pattern \"%s\" is not known.", name)
+		}
+		result.compiled = append(result.compiled, pattern)
+	}
+
+	for _, expr := range result.CustomPatterns {
+		compiled, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("Redact processor custom pattern \"%s\" is invalid: %s", expr, err)
+		}
+		result.compiled = append(result.compiled, redactPattern{regexp: compiled})
+	}
+
+	if len(result.compiled) == 0 {
+		return nil, errors.New("Redact processor patterns or custom patterns must be specified.")
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for a redact processor
+func (f *ProcessorRedactFactory) InitDefaults() {
+	f.Mask = defaultRedactMask
+	f.Method = defaultRedactMethod
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorRedactFactory) NewProcessor() Processor {
+	return &ProcessorRedact{
+		config: f,
+	}
+}
+
+// Process replaces every match of the patterns within the configured fields.
+// Where a field is a dictionary or array, every string within it is redacted.
+// Fields that do not exist are ignored
+func (p *ProcessorRedact) Process(event core.Event) core.Event {
+	for _, field := range p.config.Fields {
+		value, ok := event.GetPath(field)
+		if !ok {
+			continue
+		}
+
+		if redacted, changed := p.redactValue(value); changed {
+			event.SetPath(field, redacted)
+		}
+	}
+
+	return event
+}
+
+// redactValue returns the redacted value and whether it needs to be stored.
+// Dictionaries and arrays are redacted in place so never need to be stored
+func (p *ProcessorRedact) redactValue(value interface{}) (interface{}, bool) {
+	switch typed := value.(type) {
+	case string:
+		redacted := p.redactString(typed)
+		return redacted, redacted != typed
+	case map[string]interface{}:
+		for key, entry := range typed {
+			if redacted, changed := p.redactValue(entry); changed {
+				typed[key] = redacted
+			}
+		}
+	case []interface{}:
+		for idx, entry := range typed {
+			if redacted, changed := p.redactValue(entry); changed {
+				typed[idx] = redacted
+			}
+		}
+	}
+
+	return value, false
+}
+
+// redactString replaces the matches of each pattern in turn
+func (p *ProcessorRedact) redactString(text string) string {
+	for _, pattern := range p.config.compiled {
+		text = pattern.regexp.ReplaceAllStringFunc(text, func(match string) string {
+			if pattern.validate != nil && !pattern.validate(match) {
+				return match
+			}
+			return p.replacement(match)
+		})
+	}
+
+	return text
+}
+
+// replacement returns the text to replace a match with. Hashes allow events
+// containing the same value to be correlated without revealing it, and should
+// use a key where the possible values are few enough to be guessed
+func (p *ProcessorRedact) replacement(match string) string {
+	if p.config.Method == redactMethodMask {
+		return p.config.Mask
+	}
+
+	if p.config.HashKey == "" {
+		sum := sha256.Sum256([]byte(match))
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, []byte(p.config.HashKey))
+	mac.Write([]byte(match))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isLuhnValid returns true if the digits in the text pass the Luhn checksum
+// used by credit card numbers, ignoring separators
+func isLuhnValid(text string) bool {
+	var sum, digits int
+	for i := len(text) - 1; i >= 0; i-- {
+		if text[i] < '0' || text[i] > '9' {
+			continue
+		}
+
+		digit := int(text[i] - '0')
+		if digits%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		digits++
+	}
+
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// isIPv6 returns true if the text is a valid IPv6 address
+func isIPv6(text string) bool {
+	ip := net.ParseIP(text)
+	return ip != nil && ip.To4() == nil
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("redact", NewRedactProcessorFactory)
+}
@@ -0,0 +1,102 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createRedactProcessor(unused map[string]interface{}, t *testing.T) Processor {
+	factory, err := NewRedactProcessorFactory(config.NewConfig(), "", unused, "redact", "message")
+	if err != nil {
+		t.Logf("Failed to create redact processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func TestRedactNamedPatterns(t *testing.T) {
+	processor := createRedactProcessor(map[string]interface{}{
+		"patterns": []interface{}{"email", "credit card", "ipv4", "ipv6"},
+	}, t)
+
+	event := processor.Process(core.Event{"message": "user bob@example.com paid with 4111 1111 1111 1111 from 192.168.0.1 and 2001:db8::1 at 10:20:30"})
+
+	checkField(t, event, "message", "user [REDACTED] paid with [REDACTED] from [REDACTED] and [REDACTED] at 10:20:30")
+}
+
+func TestRedactCreditCardLuhn(t *testing.T) {
+	processor := createRedactProcessor(map[string]interface{}{
+		"patterns": []interface{}{"credit card"},
+	}, t)
+
+	event := processor.Process(core.Event{"message": "order 4111111111111112 card 5500-0000-0000-0004"})
+
+	checkField(t, event, "message", "order 4111111111111112 card [REDACTED]")
+}
+
+func TestRedactFields(t *testing.T) {
+	processor := createRedactProcessor(map[string]interface{}{
+		"fields":          []interface{}{"user", "request.headers"},
+		"custom patterns": []interface{}{`token=\w+`},
+		"mask":            "***",
+	}, t)
+
+	event := processor.Process(core.Event{
+		"message": "token=abc",
+		"user":    "token=def",
+		"request": map[string]interface{}{
+			"headers": map[string]interface{}{
+				"cookie":  "token=ghi; other=1",
+				"forward": []interface{}{"token=jkl", 5},
+			},
+		},
+	})
+
+	checkField(t, event, "message", "token=abc")
+	checkField(t, event, "user", "***")
+
+	headers := event["request"].(map[string]interface{})["headers"].(map[string]interface{})
+	if headers["cookie"] != "***; other=1" {
+		t.Errorf("Nested field not redacted: %v", headers["cookie"])
+	}
+	if forward := headers["forward"].([]interface{}); forward[0] != "***" || forward[1] != 5 {
+		t.Errorf("Array not redacted: %v", forward)
+	}
+}
+
+func TestRedactHash(t *testing.T) {
+	processor := createRedactProcessor(map[string]interface{}{
+		"patterns": []interface{}{"email"},
+		"method":   "hash",
+	}, t)
+
+	event := processor.Process(core.Event{"message": "bob@example.com"})
+	checkField(t, event, "message", "5ff860bf1190596c7188ab851db691f0f3169c453936e9e1eba2f9a47f7a0018")
+
+	processor = createRedactProcessor(map[string]interface{}{
+		"patterns": []interface{}{"email"},
+		"method":   "hash",
+		"hash key": "secret",
+	}, t)
+
+	event = processor.Process(core.Event{"message": "bob@example.com"})
+	checkField(t, event, "message", "19d2874a5656a44394f7a94c5fa00a19a04fd9114939a49ed875ff70385f0352")
+}
+
+func TestRedactInvalid(t *testing.T) {
+	invalid := []map[string]interface{}{
+		{},
+		{"patterns": []interface{}{"unknown"}},
+		{"custom patterns": []interface{}{"("}},
+		{"patterns": []interface{}{"email"}, "method": "encrypt"},
+	}
+
+	for _, unused := range invalid {
+		if _, err := NewRedactProcessorFactory(config.NewConfig(), "", unused, "redact", "message"); err == nil {
+			t.Errorf("Invalid configuration was accepted: %v", unused)
+		}
+	}
+}
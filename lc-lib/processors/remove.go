@@ -0,0 +1,155 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// removeSegment is a single dot separated part of a field pattern
+type removeSegment struct {
+	pattern string
+	literal bool
+}
+
+// ProcessorRemoveFactory holds the configuration for a remove processor
+type ProcessorRemoveFactory struct {
+	Fields []string `config:"fields"`
+
+	patterns [][]removeSegment
+}
+
+// ProcessorRemove is an instance of a remove processor
+type ProcessorRemove struct {
+	config *ProcessorRemoveFactory
+}
+
+// NewRemoveProcessorFactory creates a new ProcessorRemoveFactory for a
+// processor definition in the configuration file
+func NewRemoveProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	result := &ProcessorRemoveFactory{}
+	if err := config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if len(result.Fields) == 0 {
+		return nil, errors.New("Remove processor fields must be specified.")
+	}
+
+	for _, field := range result.Fields {
+		segments, err := parseRemovePattern(field)
+		if err != nil {
+			return nil, fmt.Errorf("Remove processor field \"%s\" is invalid: %s", field, err)
+		}
+		result.patterns = append(result.patterns, segments)
+	}
+
+	return result, nil
+}
+
+// parseRemovePattern splits a field pattern into its segments, noting which
+// contain no wildcards so they can be looked up directly
+func parseRemovePattern(field string) ([]removeSegment, error) {
+	parts := strings.Split(field, ".")
+	segments := make([]removeSegment, 0, len(parts))
+	for _, part := range parts {
+		if part == "" {
+			return nil, errors.New("empty path segment")
+		}
+
+		if _, err := path.Match(part, ""); err != nil {
+			return nil, err
+		}
+
+		segments = append(segments, removeSegment{
+			pattern: part,
+			literal: !strings.ContainsAny(part, "*?[\\"),
+		})
+	}
+
+	return segments, nil
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorRemoveFactory) NewProcessor() Processor {
+	return &ProcessorRemove{
+		config: f,
+	}
+}
+
+// Process removes every field matching any of the configured patterns. Fields
+// that do not exist are ignored
+func (p *ProcessorRemove) Process(event core.Event) core.Event {
+	for _, segments := range p.config.patterns {
+		removeMatching(event, segments)
+	}
+
+	return event
+}
+
+// removeMatching removes the keys of the dictionary matching the remaining
+// segments. Arrays found before the final segment have the remaining segments
+// applied to each dictionary they contain
+func removeMatching(target map[string]interface{}, segments []removeSegment) {
+	segment := segments[0]
+	last := len(segments) == 1
+
+	if segment.literal {
+		if last {
+			delete(target, segment.pattern)
+		} else if value, ok := target[segment.pattern]; ok {
+			removeMatchingValue(value, segments[1:])
+		}
+		return
+	}
+
+	for key, value := range target {
+		if matched, _ := path.Match(segment.pattern, key); !matched {
+			continue
+		}
+
+		if last {
+			delete(target, key)
+		} else {
+			removeMatchingValue(value, segments[1:])
+		}
+	}
+}
+
+// removeMatchingValue descends into a dictionary, or each dictionary within an
+// array, to continue matching. Other values can not contain the fields
+func removeMatchingValue(value interface{}, segments []removeSegment) {
+	switch nested := value.(type) {
+	case map[string]interface{}:
+		removeMatching(nested, segments)
+	case []interface{}:
+		for _, entry := range nested {
+			removeMatchingValue(entry, segments)
+		}
+	}
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("remove", NewRemoveProcessorFactory)
+}
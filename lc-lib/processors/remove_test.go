@@ -0,0 +1,132 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createRemoveProcessor(fields []interface{}, t *testing.T) Processor {
+	factory, err := NewRemoveProcessorFactory(config.NewConfig(), "", map[string]interface{}{"fields": fields}, "remove", "message")
+	if err != nil {
+		t.Logf("Failed to create remove processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func TestRemoveLiteral(t *testing.T) {
+	processor := createRemoveProcessor([]interface{}{"debug", "host.ip", "missing.field"}, t)
+
+	event := processor.Process(core.Event{
+		"message": "test",
+		"debug":   map[string]interface{}{"a": 1},
+		"host":    map[string]interface{}{"name": "web", "ip": "10.0.0.1"},
+	})
+
+	expected := core.Event{
+		"message": "test",
+		"host":    map[string]interface{}{"name": "web"},
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event: %v", event)
+	}
+}
+
+func TestRemoveWildcard(t *testing.T) {
+	processor := createRemoveProcessor([]interface{}{"debug.*", "trace_?", "x[0-9]"}, t)
+
+	event := processor.Process(core.Event{
+		"message":  "test",
+		"debug":    map[string]interface{}{"a": 1, "b": map[string]interface{}{"c": 2}},
+		"trace_1":  "x",
+		"trace_10": "y",
+		"x5":       true,
+		"xa":       false,
+	})
+
+	expected := core.Event{
+		"message":  "test",
+		"debug":    map[string]interface{}{},
+		"trace_10": "y",
+		"xa":       false,
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event: %v", event)
+	}
+}
+
+func TestRemoveDeeplyNested(t *testing.T) {
+	processor := createRemoveProcessor([]interface{}{"request.*.headers.x-*"}, t)
+
+	event := processor.Process(core.Event{
+		"request": map[string]interface{}{
+			"upstream": map[string]interface{}{
+				"headers": map[string]interface{}{"x-debug": "1", "x-trace": "2", "host": "a"},
+			},
+			"retries": []interface{}{
+				map[string]interface{}{"headers": map[string]interface{}{"x-debug": "1", "accept": "b"}},
+				[]interface{}{map[string]interface{}{"headers": map[string]interface{}{"x-debug": "1"}}},
+				"not an object",
+			},
+			"client": map[string]interface{}{
+				"headers": "not an object",
+				"other":   map[string]interface{}{"x-debug": "kept"},
+			},
+		},
+	})
+
+	expected := core.Event{
+		"request": map[string]interface{}{
+			"upstream": map[string]interface{}{
+				"headers": map[string]interface{}{"host": "a"},
+			},
+			"retries": []interface{}{
+				map[string]interface{}{"headers": map[string]interface{}{"accept": "b"}},
+				[]interface{}{map[string]interface{}{"headers": map[string]interface{}{}}},
+				"not an object",
+			},
+			"client": map[string]interface{}{
+				"headers": "not an object",
+				"other":   map[string]interface{}{"x-debug": "kept"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event: %v", event)
+	}
+}
+
+func TestRemoveNoMatch(t *testing.T) {
+	processor := createRemoveProcessor([]interface{}{"debug.*", "a.b.c"}, t)
+
+	event := processor.Process(core.Event{
+		"debug":     "not an object",
+		"a":         map[string]interface{}{"b": "not an object", "c": 1},
+		"debugging": map[string]interface{}{"kept": true},
+	})
+
+	expected := core.Event{
+		"debug":     "not an object",
+		"a":         map[string]interface{}{"b": "not an object", "c": 1},
+		"debugging": map[string]interface{}{"kept": true},
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("Unexpected event: %v", event)
+	}
+}
+
+func TestRemoveInvalid(t *testing.T) {
+	for _, field := range []string{"a..b", "a.[b", ""} {
+		if _, err := NewRemoveProcessorFactory(config.NewConfig(), "", map[string]interface{}{"fields": []interface{}{field}}, "remove", "message"); err == nil {
+			t.Errorf("Invalid field was accepted: %s", field)
+		}
+	}
+
+	if _, err := NewRemoveProcessorFactory(config.NewConfig(), "", map[string]interface{}{}, "remove", "message"); err == nil {
+		t.Error("Missing fields were accepted")
+	}
+}
@@ -0,0 +1,348 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultSyslogFailureTag string = "_syslogparsefailure"
+
+	// syslogNil is the NILVALUE of RFC5424
+	syslogNil = "-"
+
+	// syslogRFC3164Stamp is the layout of an RFC3164 timestamp
+	syslogRFC3164Stamp = time.Stamp
+)
+
+var (
+	errSyslogInvalid = errors.New("invalid syslog message")
+)
+
+// syslogMessage holds the parts of a parsed syslog message
+type syslogMessage struct {
+	priority       int
+	hasPriority    bool
+	timestamp      string
+	hostname       string
+	appname        string
+	procid         string
+	msgid          string
+	structuredData map[string]interface{}
+	message        string
+}
+
+// ProcessorSyslogFactory holds the configuration for a syslog processor
+type ProcessorSyslogFactory struct {
+	Field string `config:"field"`
+}
+
+// ProcessorSyslog is an instance of a syslog processor
+type ProcessorSyslog struct {
+	config *ProcessorSyslogFactory
+}
+
+// NewSyslogProcessorFactory creates a new ProcessorSyslogFactory for a
+// processor definition in the configuration file
+func NewSyslogProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	result := &ProcessorSyslogFactory{}
+	if err := config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.Field == "" {
+		result.Field = messageField
+	}
+
+	return result, nil
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorSyslogFactory) NewProcessor() Processor {
+	return &ProcessorSyslog{
+		config: f,
+	}
+}
+
+// Process parses the field as a syslog message, detecting whether it is in
+// RFC5424 or RFC3164 format. The message field is replaced with the message
+// part of the syslog message. If the field could not be parsed the event is
+// tagged with "_syslogparsefailure" and left otherwise unchanged
+func (p *ProcessorSyslog) Process(event core.Event) core.Event {
+	value, _ := event.GetPath(p.config.Field)
+	source, ok := value.(string)
+	if !ok {
+		event.AddTags(defaultSyslogFailureTag)
+		return event
+	}
+
+	parsed, err := parseSyslog(source)
+	if err != nil {
+		event.AddTags(defaultSyslogFailureTag)
+		return event
+	}
+
+	if parsed.hasPriority {
+		event["priority"] = parsed.priority
+		event["facility"] = parsed.priority / 8
+		event["severity"] = parsed.priority % 8
+	}
+
+	setSyslogField(event, "timestamp", parsed.timestamp)
+	setSyslogField(event, "hostname", parsed.hostname)
+	setSyslogField(event, "appname", parsed.appname)
+	setSyslogField(event, "procid", parsed.procid)
+	setSyslogField(event, "msgid", parsed.msgid)
+
+	if parsed.structuredData != nil {
+		event["structured_data"] = parsed.structuredData
+	}
+
+	event.SetPath(p.config.Field, parsed.message)
+
+	return event
+}
+
+// setSyslogField sets a field if the value was present in the message
+func setSyslogField(event core.Event, field string, value string) {
+	if value != "" && value != syslogNil {
+		event[field] = value
+	}
+}
+
+// parseSyslog parses a syslog message, removing any octet-counting frame
+func parseSyslog(text string) (*syslogMessage, error) {
+	text, err := parseSyslogFrame(text)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &syslogMessage{}
+
+	if strings.HasPrefix(text, "<") {
+		end := strings.IndexByte(text, '>')
+		if end < 2 || end > 4 {
+			return nil, errSyslogInvalid
+		}
+
+		priority, err := strconv.Atoi(text[1:end])
+		if err != nil || priority > 191 {
+			return nil, errSyslogInvalid
+		}
+
+		parsed.priority = priority
+		parsed.hasPriority = true
+		text = text[end+1:]
+
+		// RFC5424 has a version number immediately after the priority
+		if strings.HasPrefix(text, "1 ") {
+			return parsed, parsed.parseRFC5424(text[2:])
+		}
+	}
+
+	return parsed, parsed.parseRFC3164(text)
+}
+
+// parseSyslogFrame removes octet-counting framing as described by RFC6587,
+// where the message is preceeded by its length and a space. The length must
+// match the remaining text exactly
+func parseSyslogFrame(text string) (string, error) {
+	space := strings.IndexByte(text, ' ')
+	if space < 1 || space+1 >= len(text) || text[space+1] != '<' {
+		return text, nil
+	}
+
+	for i := 0; i < space; i++ {
+		if text[i] < '0' || text[i] > '9' {
+			return text, nil
+		}
+	}
+
+	length, err := strconv.Atoi(text[:space])
+	if err != nil || length != len(text)-space-1 {
+		return "", errSyslogInvalid
+	}
+
+	return text[space+1:], nil
+}
+
+// parseRFC5424 parses the remainder of an RFC5424 message following the
+// version number
+func (s *syslogMessage) parseRFC5424(text string) error {
+	var fields [5]string
+	for i := range fields {
+		end := strings.IndexByte(text, ' ')
+		if end == -1 {
+			if i != len(fields)-1 {
+				return errSyslogInvalid
+			}
+			end = len(text)
+		}
+
+		fields[i] = text[:end]
+		if fields[i] == "" {
+			return errSyslogInvalid
+		}
+
+		if end == len(text) {
+			text = ""
+		} else {
+			text = text[end+1:]
+		}
+	}
+
+	s.timestamp, s.hostname, s.appname, s.procid, s.msgid = fields[0], fields[1], fields[2], fields[3], fields[4]
+
+	if strings.HasPrefix(text, syslogNil) {
+		text = text[len(syslogNil):]
+	} else if strings.HasPrefix(text, "[") {
+		var err error
+		if text, err = s.parseStructuredData(text); err != nil {
+			return err
+		}
+	} else {
+		return errSyslogInvalid
+	}
+
+	if text != "" {
+		if text[0] != ' ' {
+			return errSyslogInvalid
+		}
+		text = text[1:]
+	}
+
+	// Strip the UTF-8 byte order mark, if present
+	s.message = strings.TrimPrefix(text, "\xEF\xBB\xBF")
+	return nil
+}
+
+// parseStructuredData parses the structured data elements of an RFC5424
+// message, returning the remaining text
+func (s *syslogMessage) parseStructuredData(text string) (string, error) {
+	s.structuredData = make(map[string]interface{})
+
+	for strings.HasPrefix(text, "[") {
+		text = text[1:]
+
+		end := strings.IndexAny(text, " ]")
+		if end < 1 {
+			return "", errSyslogInvalid
+		}
+
+		params := make(map[string]interface{})
+		s.structuredData[text[:end]] = params
+		text = text[end:]
+
+		for strings.HasPrefix(text, " ") {
+			text = text[1:]
+
+			equals := strings.Index(text, "=\"")
+			if equals < 1 {
+				return "", errSyslogInvalid
+			}
+
+			name := text[:equals]
+			text = text[equals+2:]
+
+			// Values escape '"', '\' and ']' with a backslash
+			var value []byte
+			closed := false
+			for i := 0; i < len(text); i++ {
+				if text[i] == '\\' && i+1 < len(text) && (text[i+1] == '"' || text[i+1] == '\\' || text[i+1] == ']') {
+					i++
+					value = append(value, text[i])
+				} else if text[i] == '"' {
+					text = text[i+1:]
+					closed = true
+					break
+				} else {
+					value = append(value, text[i])
+				}
+			}
+			if !closed {
+				return "", errSyslogInvalid
+			}
+
+			params[name] = string(value)
+		}
+
+		if !strings.HasPrefix(text, "]") {
+			return "", errSyslogInvalid
+		}
+		text = text[1:]
+	}
+
+	return text, nil
+}
+
+// parseRFC3164 parses an RFC3164 message following the priority, if there was
+// one. As many syslog daemons do not include a hostname when writing to a log
+// file, the hostname is treated as absent if the first word after the timestamp
+// looks like a tag
+func (s *syslogMessage) parseRFC3164(text string) error {
+	if len(text) < len(syslogRFC3164Stamp)+1 || text[len(syslogRFC3164Stamp)] != ' ' {
+		return errSyslogInvalid
+	}
+
+	if _, err := time.Parse(syslogRFC3164Stamp, text[:len(syslogRFC3164Stamp)]); err != nil {
+		return errSyslogInvalid
+	}
+
+	s.timestamp = text[:len(syslogRFC3164Stamp)]
+	text = text[len(syslogRFC3164Stamp)+1:]
+
+	end := strings.IndexByte(text, ' ')
+	if end > 0 && !isSyslogTag(text[:end]) {
+		s.hostname = text[:end]
+		text = text[end+1:]
+	}
+
+	// The tag ends at a colon, and may contain the process ID in brackets
+	end = strings.Index(text, ": ")
+	if end > 0 && strings.IndexByte(text[:end], ' ') == -1 {
+		tag := text[:end]
+		text = text[end+2:]
+
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			s.procid = tag[open+1 : len(tag)-1]
+			tag = tag[:open]
+		}
+
+		s.appname = tag
+	}
+
+	s.message = text
+	return nil
+}
+
+// isSyslogTag returns true if the given word is an RFC3164 tag rather than a
+// hostname
+func isSyslogTag(word string) bool {
+	return strings.HasSuffix(word, ":")
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("syslog", NewSyslogProcessorFactory)
+}
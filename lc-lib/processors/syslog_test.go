@@ -0,0 +1,141 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createSyslogProcessor(t *testing.T) Processor {
+	factory, err := NewSyslogProcessorFactory(config.NewConfig(), "", map[string]interface{}{}, "syslog", "message")
+	if err != nil {
+		t.Logf("Failed to create syslog processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func checkSyslogFailure(t *testing.T, processor Processor, line string) {
+	event := processor.Process(core.Event{"message": line})
+
+	if event["message"] != line {
+		t.Errorf("Message was modified on failure: %s", event["message"])
+	}
+
+	tags, ok := event["tags"].([]string)
+	if !ok || len(tags) != 1 || tags[0] != "_syslogparsefailure" {
+		t.Errorf("Event was not tagged for line: %s", line)
+	}
+}
+
+func TestSyslogRFC3164(t *testing.T) {
+	processor := createSyslogProcessor(t)
+
+	event := processor.Process(core.Event{"message": "<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8"})
+
+	checkField(t, event, "message", "'su root' failed for lonvick on /dev/pts/8")
+	checkField(t, event, "timestamp", "Oct 11 22:14:15")
+	checkField(t, event, "hostname", "mymachine")
+	checkField(t, event, "appname", "su")
+	if event["priority"] != 34 || event["facility"] != 4 || event["severity"] != 2 {
+		t.Errorf("Incorrect priority: %v %v %v", event["priority"], event["facility"], event["severity"])
+	}
+}
+
+func TestSyslogRFC3164File(t *testing.T) {
+	processor := createSyslogProcessor(t)
+
+	event := processor.Process(core.Event{"message": "Feb  5 06:25:01 server1 CRON[12345]: (root) CMD (command -v debian-sa1 > /dev/null)"})
+
+	checkField(t, event, "message", "(root) CMD (command -v debian-sa1 > /dev/null)")
+	checkField(t, event, "timestamp", "Feb  5 06:25:01")
+	checkField(t, event, "hostname", "server1")
+	checkField(t, event, "appname", "CRON")
+	checkField(t, event, "procid", "12345")
+	if _, ok := event["priority"]; ok {
+		t.Error("Priority set when not present")
+	}
+}
+
+func TestSyslogRFC3164NoHostname(t *testing.T) {
+	processor := createSyslogProcessor(t)
+
+	event := processor.Process(core.Event{"message": "<13>Feb  5 17:32:18 sshd[999]: Accepted publickey for root"})
+
+	checkField(t, event, "message", "Accepted publickey for root")
+	checkField(t, event, "appname", "sshd")
+	checkField(t, event, "procid", "999")
+	if _, ok := event["hostname"]; ok {
+		t.Error("Hostname set when not present")
+	}
+}
+
+func TestSyslogRFC5424(t *testing.T) {
+	processor := createSyslogProcessor(t)
+
+	event := processor.Process(core.Event{"message": "<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\" eventID=\"1011\"][examplePriority@32473 class=\"high \\\"quoted\\\" \\]\"] \xEF\xBB\xBFAn application event log entry..."})
+
+	checkField(t, event, "message", "An application event log entry...")
+	checkField(t, event, "timestamp", "2003-10-11T22:14:15.003Z")
+	checkField(t, event, "hostname", "mymachine.example.com")
+	checkField(t, event, "appname", "evntslog")
+	checkField(t, event, "msgid", "ID47")
+	if _, ok := event["procid"]; ok {
+		t.Error("Nil procid was set")
+	}
+	if event["facility"] != 20 || event["severity"] != 5 {
+		t.Errorf("Incorrect priority: %v %v", event["facility"], event["severity"])
+	}
+
+	sd, ok := event["structured_data"].(map[string]interface{})
+	if !ok || len(sd) != 2 {
+		t.Fatalf("Incorrect structured data: %v", event["structured_data"])
+	}
+
+	example, ok := sd["exampleSDID@32473"].(map[string]interface{})
+	if !ok || example["iut"] != "3" || example["eventSource"] != "Application" || example["eventID"] != "1011" {
+		t.Errorf("Incorrect structured data element: %v", sd["exampleSDID@32473"])
+	}
+
+	priority, ok := sd["examplePriority@32473"].(map[string]interface{})
+	if !ok || priority["class"] != "high \"quoted\" ]" {
+		t.Errorf("Incorrect escaped structured data element: %v", sd["examplePriority@32473"])
+	}
+}
+
+func TestSyslogRFC5424NoMessage(t *testing.T) {
+	processor := createSyslogProcessor(t)
+
+	event := processor.Process(core.Event{"message": "<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 -"})
+
+	checkField(t, event, "message", "")
+	checkField(t, event, "appname", "su")
+	if _, ok := event["structured_data"]; ok {
+		t.Error("Nil structured data was set")
+	}
+}
+
+func TestSyslogOctetCounted(t *testing.T) {
+	processor := createSyslogProcessor(t)
+
+	line := "<34>1 2003-10-11T22:14:15.003Z host app 123 - - message"
+	event := processor.Process(core.Event{"message": "55 " + line})
+
+	checkField(t, event, "message", "message")
+	checkField(t, event, "procid", "123")
+
+	// Length mismatches are invalid frames
+	checkSyslogFailure(t, processor, "54 "+line)
+	checkSyslogFailure(t, processor, "99 "+line)
+}
+
+func TestSyslogInvalid(t *testing.T) {
+	processor := createSyslogProcessor(t)
+
+	checkSyslogFailure(t, processor, "not a syslog line")
+	checkSyslogFailure(t, processor, "<999>Oct 11 22:14:15 host app: message")
+	checkSyslogFailure(t, processor, "<34>1 2003-10-11T22:14:15.003Z host app")
+	checkSyslogFailure(t, processor, "<34>1 2003-10-11T22:14:15.003Z host app - - [unterminated a=\"b\"")
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"errors"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultTraceTarget string = "trace.id"
+)
+
+// ProcessorTraceFactory holds the configuration for a trace processor
+type ProcessorTraceFactory struct {
+	Field        string `config:"field"`
+	RoutingField string `config:"routing field"`
+	Target       string `config:"target"`
+}
+
+// ProcessorTrace is an instance of a trace processor
+type ProcessorTrace struct {
+	config *ProcessorTraceFactory
+}
+
+// NewTraceProcessorFactory creates a new ProcessorTraceFactory for a processor
+// definition in the configuration file
+func NewTraceProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	result := &ProcessorTraceFactory{}
+	if err := config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.Field == "" {
+		return nil, errors.New("Trace processor field must be specified.")
+	}
+
+	if result.Target == "" {
+		return nil, errors.New("Trace processor target can not be empty.")
+	}
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for a trace processor
+func (f *ProcessorTraceFactory) InitDefaults() {
+	f.Target = defaultTraceTarget
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorTraceFactory) NewProcessor() Processor {
+	return &ProcessorTrace{
+		config: f,
+	}
+}
+
+// Process copies the trace identifier from the configured field into the
+// target field, and the routing field if one is configured. Events without the
+// field, or where it is empty, are left unchanged
+func (p *ProcessorTrace) Process(event core.Event) core.Event {
+	value, ok := event.GetPath(p.config.Field)
+	if !ok || value == nil || value == "" {
+		return event
+	}
+
+	event.SetPath(p.config.Target, value)
+
+	if p.config.RoutingField != "" {
+		event.SetPath(p.config.RoutingField, value)
+	}
+
+	return event
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("trace", NewTraceProcessorFactory)
+}
@@ -0,0 +1,64 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createTraceProcessor(unused map[string]interface{}, t *testing.T) Processor {
+	factory, err := NewTraceProcessorFactory(config.NewConfig(), "", unused, "trace", "message")
+	if err != nil {
+		t.Logf("Failed to create trace processor: %s", err)
+		t.FailNow()
+	}
+
+	return NewProcessor(factory)
+}
+
+func TestTrace(t *testing.T) {
+	processor := createTraceProcessor(map[string]interface{}{
+		"field": "traceid",
+	}, t)
+
+	event := processor.Process(core.Event{"message": "test", "traceid": "abc123"})
+
+	trace, ok := event["trace"].(map[string]interface{})
+	if !ok || trace["id"] != "abc123" {
+		t.Errorf("Trace ID was not set: %v", event["trace"])
+	}
+	checkField(t, event, "traceid", "abc123")
+}
+
+func TestTraceRouting(t *testing.T) {
+	processor := createTraceProcessor(map[string]interface{}{
+		"field":         "request.trace",
+		"target":        "trace_id",
+		"routing field": "routing",
+	}, t)
+
+	event := processor.Process(core.Event{"request": map[string]interface{}{"trace": "abc123"}})
+
+	checkField(t, event, "trace_id", "abc123")
+	checkField(t, event, "routing", "abc123")
+}
+
+func TestTraceMissing(t *testing.T) {
+	processor := createTraceProcessor(map[string]interface{}{
+		"field":         "traceid",
+		"routing field": "routing",
+	}, t)
+
+	event := processor.Process(core.Event{"message": "test"})
+
+	if len(event) != 1 {
+		t.Errorf("Event was modified: %v", event)
+	}
+}
+
+func TestTraceNoField(t *testing.T) {
+	if _, err := NewTraceProcessorFactory(config.NewConfig(), "", map[string]interface{}{}, "trace", "message"); err == nil {
+		t.Error("Missing field was accepted")
+	}
+}
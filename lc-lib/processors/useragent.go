@@ -0,0 +1,189 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	defaultUserAgentCacheSize  int64  = 1000
+	defaultUserAgentField      string = "user_agent"
+	defaultUserAgentTarget     string = "user_agent"
+	defaultUserAgentFailureTag string = "_useragentparsefailure"
+)
+
+// ProcessorUserAgentFactory holds the configuration for a useragent processor,
+// along with the regexes database and lookup cache which are shared by all
+// instances of the processor
+type ProcessorUserAgentFactory struct {
+	CacheSize int64  `config:"cache size"`
+	Field     string `config:"field"`
+	Regexes   string `config:"regexes"`
+	Target    string `config:"target"`
+
+	regexes    *userAgentRegexes
+	cacheMutex sync.Mutex
+	cache      map[string]*list.Element
+	cacheList  list.List
+}
+
+// userAgentCacheEntry is an entry in the lookup cache
+type userAgentCacheEntry struct {
+	text   string
+	result *userAgentResult
+}
+
+// ProcessorUserAgent is an instance of a useragent processor
+type ProcessorUserAgent struct {
+	config *ProcessorUserAgentFactory
+}
+
+// NewUserAgentProcessorFactory creates a new ProcessorUserAgentFactory for a
+// processor definition in the configuration file
+func NewUserAgentProcessorFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string, messageField string) (interface{}, error) {
+	var err error
+
+	result := &ProcessorUserAgentFactory{}
+	if err = config.PopulateConfig(result, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if result.CacheSize < 0 {
+		return nil, errors.New("Useragent processor cache size can not be negative.")
+	}
+
+	if result.regexes, err = loadUserAgentRegexes(result.Regexes); err != nil {
+		return nil, fmt.Errorf("Useragent processor regexes could not be loaded: %s", err)
+	}
+
+	result.cache = make(map[string]*list.Element)
+
+	return result, nil
+}
+
+// InitDefaults initialises the default configuration for a useragent processor
+func (f *ProcessorUserAgentFactory) InitDefaults() {
+	f.CacheSize = defaultUserAgentCacheSize
+	f.Field = defaultUserAgentField
+	f.Target = defaultUserAgentTarget
+}
+
+// NewProcessor returns a new processor instance
+func (f *ProcessorUserAgentFactory) NewProcessor() Processor {
+	return &ProcessorUserAgent{
+		config: f,
+	}
+}
+
+// lookup parses a user agent, using the cache of recent lookups so that
+// repeated identical user agents are only parsed once
+func (f *ProcessorUserAgentFactory) lookup(text string) *userAgentResult {
+	if f.CacheSize == 0 {
+		return f.regexes.parse(text)
+	}
+
+	f.cacheMutex.Lock()
+	if element, ok := f.cache[text]; ok {
+		f.cacheList.MoveToFront(element)
+		f.cacheMutex.Unlock()
+		return element.Value.(*userAgentCacheEntry).result
+	}
+	f.cacheMutex.Unlock()
+
+	result := f.regexes.parse(text)
+
+	f.cacheMutex.Lock()
+	defer f.cacheMutex.Unlock()
+
+	if _, ok := f.cache[text]; ok {
+		// Another instance parsed the same user agent at the same time
+		return result
+	}
+
+	f.cache[text] = f.cacheList.PushFront(&userAgentCacheEntry{text: text, result: result})
+	if int64(f.cacheList.Len()) > f.CacheSize {
+		oldest := f.cacheList.Back()
+		f.cacheList.Remove(oldest)
+		delete(f.cache, oldest.Value.(*userAgentCacheEntry).text)
+	}
+
+	return result
+}
+
+// Process parses the user agent in the source field and stores the browser,
+// OS and device in the target field. If the field is missing or the browser is
+// not recognised the event is tagged with "_useragentparsefailure" and left
+// otherwise unchanged
+func (p *ProcessorUserAgent) Process(event core.Event) core.Event {
+	source, ok := event.GetPath(p.config.Field)
+	text, isString := source.(string)
+	if !ok || !isString {
+		event.AddTags(defaultUserAgentFailureTag)
+		return event
+	}
+
+	result := p.config.lookup(text)
+	if result.name == userAgentOther {
+		event.AddTags(defaultUserAgentFailureTag)
+		return event
+	}
+
+	// A new map is built each time as the event may be modified afterwards
+	userAgent := map[string]interface{}{
+		"original": text,
+		"name":     result.name,
+	}
+	if result.version != "" {
+		userAgent["version"] = result.version
+	}
+
+	os := map[string]interface{}{
+		"name": result.osName,
+	}
+	if result.osVersion != "" {
+		os["version"] = result.osVersion
+		os["full"] = result.osName + " " + result.osVersion
+	}
+	userAgent["os"] = os
+
+	device := map[string]interface{}{
+		"name": result.deviceName,
+	}
+	if result.deviceBrand != "" {
+		device["brand"] = result.deviceBrand
+	}
+	if result.deviceModel != "" {
+		device["model"] = result.deviceModel
+	}
+	userAgent["device"] = device
+
+	event.SetPath(p.config.Target, userAgent)
+
+	return event
+}
+
+// Register the processor
+func init() {
+	config.RegisterProcessor("useragent", NewUserAgentProcessorFactory)
+}
@@ -0,0 +1,308 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processors
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// userAgentOther is the name used when a part of a user agent is not recognised
+const userAgentOther = "Other"
+
+// userAgentReplacement matches the $1 to $9 placeholders in a replacement
+var userAgentReplacement = regexp.MustCompile(`\$[1-9]`)
+
+// userAgentRegexesFile is the structure of a regexes database, which is the
+// same format as the regexes.yaml file of the ua-parser project, so that the
+// full database from that project can be used in place of the bundled one
+type userAgentRegexesFile struct {
+	UserAgentParsers []struct {
+		Regex             string `yaml:"regex"`
+		FamilyReplacement string `yaml:"family_replacement"`
+		V1Replacement     string `yaml:"v1_replacement"`
+		V2Replacement     string `yaml:"v2_replacement"`
+		V3Replacement     string `yaml:"v3_replacement"`
+	} `yaml:"user_agent_parsers"`
+	OSParsers []struct {
+		Regex           string `yaml:"regex"`
+		OSReplacement   string `yaml:"os_replacement"`
+		OSV1Replacement string `yaml:"os_v1_replacement"`
+		OSV2Replacement string `yaml:"os_v2_replacement"`
+		OSV3Replacement string `yaml:"os_v3_replacement"`
+	} `yaml:"os_parsers"`
+	DeviceParsers []struct {
+		Regex             string `yaml:"regex"`
+		RegexFlag         string `yaml:"regex_flag"`
+		DeviceReplacement string `yaml:"device_replacement"`
+		BrandReplacement  string `yaml:"brand_replacement"`
+		ModelReplacement  string `yaml:"model_replacement"`
+	} `yaml:"device_parsers"`
+}
+
+// userAgentParser is a single compiled entry from the regexes database. The
+// replacements are the name and version replacements for user agent and OS
+// parsers, and the device, brand and model replacements for device parsers
+type userAgentParser struct {
+	regex        *regexp.Regexp
+	replacements [4]string
+}
+
+// userAgentRegexes is a compiled regexes database
+type userAgentRegexes struct {
+	userAgent []*userAgentParser
+	os        []*userAgentParser
+	device    []*userAgentParser
+}
+
+// userAgentResult holds the parts of a parsed user agent
+type userAgentResult struct {
+	name        string
+	version     string
+	osName      string
+	osVersion   string
+	deviceName  string
+	deviceBrand string
+	deviceModel string
+}
+
+// loadUserAgentRegexes loads the regexes database from the given path, or the
+// bundled database if the path is empty
+func loadUserAgentRegexes(path string) (*userAgentRegexes, error) {
+	data := []byte(defaultUserAgentRegexes)
+	if path != "" {
+		var err error
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var file userAgentRegexesFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	regexes := &userAgentRegexes{}
+
+	for i, entry := range file.UserAgentParsers {
+		parser, err := newUserAgentParser(entry.Regex, "", entry.FamilyReplacement, entry.V1Replacement, entry.V2Replacement, entry.V3Replacement)
+		if err != nil {
+			return nil, fmt.Errorf("user_agent_parsers[%d]: %s", i, err)
+		}
+		regexes.userAgent = append(regexes.userAgent, parser)
+	}
+
+	for i, entry := range file.OSParsers {
+		parser, err := newUserAgentParser(entry.Regex, "", entry.OSReplacement, entry.OSV1Replacement, entry.OSV2Replacement, entry.OSV3Replacement)
+		if err != nil {
+			return nil, fmt.Errorf("os_parsers[%d]: %s", i, err)
+		}
+		regexes.os = append(regexes.os, parser)
+	}
+
+	for i, entry := range file.DeviceParsers {
+		parser, err := newUserAgentParser(entry.Regex, entry.RegexFlag, entry.DeviceReplacement, entry.BrandReplacement, entry.ModelReplacement, "")
+		if err != nil {
+			return nil, fmt.Errorf("device_parsers[%d]: %s", i, err)
+		}
+		regexes.device = append(regexes.device, parser)
+	}
+
+	if len(regexes.userAgent) == 0 {
+		return nil, fmt.Errorf("no user_agent_parsers found")
+	}
+
+	return regexes, nil
+}
+
+// newUserAgentParser compiles a single entry of the regexes database
+func newUserAgentParser(regex string, flag string, replacements ...string) (*userAgentParser, error) {
+	if flag == "i" {
+		regex = "(?i)" + regex
+	} else if flag != "" {
+		return nil, fmt.Errorf("unsupported regex_flag \"%s\"", flag)
+	}
+
+	compiled, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &userAgentParser{regex: compiled}
+	copy(parser.replacements[:], replacements)
+	return parser, nil
+}
+
+// match returns the capture groups of the parser's regex, or nil if it does
+// not match
+func (p *userAgentParser) match(text string) []string {
+	return p.regex.FindStringSubmatch(text)
+}
+
+// value returns the replacement at the given index with any placeholders
+// substituted, or the capture group at the given index if there is no
+// replacement, as described by the ua-parser specification
+func (p *userAgentParser) value(matches []string, index int, group int) string {
+	if replacement := p.replacements[index]; replacement != "" {
+		return strings.TrimSpace(userAgentReplacement.ReplaceAllStringFunc(replacement, func(placeholder string) string {
+			n, _ := strconv.Atoi(placeholder[1:])
+			if n < len(matches) {
+				return matches[n]
+			}
+			return ""
+		}))
+	}
+
+	if group > 0 && group < len(matches) {
+		return matches[group]
+	}
+
+	return ""
+}
+
+// parse parses the given user agent
+func (r *userAgentRegexes) parse(text string) *userAgentResult {
+	result := &userAgentResult{
+		name:       userAgentOther,
+		osName:     userAgentOther,
+		deviceName: userAgentOther,
+	}
+
+	for _, parser := range r.userAgent {
+		if matches := parser.match(text); matches != nil {
+			result.name = parser.value(matches, 0, 1)
+			result.version = joinUserAgentVersion(parser.value(matches, 1, 2), parser.value(matches, 2, 3), parser.value(matches, 3, 4))
+			break
+		}
+	}
+
+	for _, parser := range r.os {
+		if matches := parser.match(text); matches != nil {
+			result.osName = parser.value(matches, 0, 1)
+			result.osVersion = joinUserAgentVersion(parser.value(matches, 1, 2), parser.value(matches, 2, 3), parser.value(matches, 3, 4))
+			break
+		}
+	}
+
+	for _, parser := range r.device {
+		if matches := parser.match(text); matches != nil {
+			result.deviceName = parser.value(matches, 0, 1)
+			result.deviceBrand = parser.value(matches, 1, -1)
+			result.deviceModel = parser.value(matches, 2, 1)
+			break
+		}
+	}
+
+	return result
+}
+
+// joinUserAgentVersion joins the parts of a version that are present
+func joinUserAgentVersion(parts ...string) string {
+	version := ""
+	for _, part := range parts {
+		if part == "" {
+			break
+		}
+		if version != "" {
+			version += "."
+		}
+		version += part
+	}
+	return version
+}
+
+// defaultUserAgentRegexes is the bundled regexes database. It recognises the
+// most common browsers, operating systems and devices, and the full database
+// from the ua-parser project can be used for more detail
+const defaultUserAgentRegexes = `
+user_agent_parsers:
+  - regex: '(Googlebot|bingbot|Baiduspider|YandexBot|DuckDuckBot|Applebot)/(\d+)\.(\d+)'
+  - regex: '(curl|Wget|python-requests|Go-http-client)/(\d+)\.(\d+)(?:\.(\d+))?'
+  - regex: '(Edg|Edge|EdgA|EdgiOS)/(\d+)\.(\d+)(?:\.(\d+))?'
+    family_replacement: 'Edge'
+  - regex: '(OPR|OPiOS)/(\d+)\.(\d+)\.(\d+)'
+    family_replacement: 'Opera'
+  - regex: '(SamsungBrowser)/(\d+)\.(\d+)'
+    family_replacement: 'Samsung Internet'
+  - regex: '(CriOS)/(\d+)\.(\d+)\.(\d+)'
+    family_replacement: 'Chrome Mobile iOS'
+  - regex: '(FxiOS)/(\d+)\.(\d+)'
+    family_replacement: 'Firefox iOS'
+  - regex: '(Chrome)/(\d+)\.(\d+)\.(\d+)[\d.]* Mobile'
+    family_replacement: 'Chrome Mobile'
+  - regex: '(Chromium|Chrome)/(\d+)\.(\d+)\.(\d+)'
+  - regex: 'Mobile;.*(Firefox)/(\d+)\.(\d+)'
+    family_replacement: 'Firefox Mobile'
+  - regex: '(Firefox)/(\d+)\.(\d+)(?:\.(\d+))?'
+  - regex: '(Version)/(\d+)\.(\d+)(?:\.(\d+))? Mobile/\S+ Safari/'
+    family_replacement: 'Mobile Safari'
+  - regex: '(Version)/(\d+)\.(\d+)(?:\.(\d+))?.* Safari/'
+    family_replacement: 'Safari'
+  - regex: '(MSIE) (\d+)\.(\d+)'
+    family_replacement: 'IE'
+  - regex: '(Trident)/7\.0;.*rv:(\d+)\.(\d+)'
+    family_replacement: 'IE'
+
+os_parsers:
+  - regex: 'Windows NT 10\.0'
+    os_replacement: 'Windows'
+    os_v1_replacement: '10'
+  - regex: 'Windows NT 6\.3'
+    os_replacement: 'Windows'
+    os_v1_replacement: '8'
+    os_v2_replacement: '1'
+  - regex: 'Windows NT 6\.2'
+    os_replacement: 'Windows'
+    os_v1_replacement: '8'
+  - regex: 'Windows NT 6\.1'
+    os_replacement: 'Windows'
+    os_v1_replacement: '7'
+  - regex: 'Windows NT 6\.0'
+    os_replacement: 'Windows'
+    os_v1_replacement: 'Vista'
+  - regex: 'Windows NT 5\.1'
+    os_replacement: 'Windows'
+    os_v1_replacement: 'XP'
+  - regex: '(Android)[ /-](\d+)(?:\.(\d+))?(?:\.(\d+))?'
+  - regex: '(CPU (?:iPhone )?OS) (\d+)_(\d+)(?:_(\d+))?'
+    os_replacement: 'iOS'
+  - regex: '(Mac OS X) (\d+)[_.](\d+)(?:[_.](\d+))?'
+  - regex: '(CrOS) \S+ (\d+)\.(\d+)\.(\d+)'
+    os_replacement: 'Chrome OS'
+  - regex: '(Ubuntu|Fedora|Debian)'
+  - regex: '(Linux)'
+
+device_parsers:
+  - regex: '(Googlebot|bingbot|Baiduspider|YandexBot|DuckDuckBot|Applebot|spider|crawler|bot\b)'
+    regex_flag: 'i'
+    device_replacement: 'Spider'
+    brand_replacement: 'Spider'
+    model_replacement: 'Desktop'
+  - regex: '(iPhone|iPad|iPod)'
+    brand_replacement: 'Apple'
+  - regex: '(Macintosh)'
+    device_replacement: 'Mac'
+    brand_replacement: 'Apple'
+    model_replacement: 'Mac'
+  - regex: 'Android[ /-][\d.]+; (?:[a-z]{2}[-_][a-zA-Z]{2}; )?([^;)]+?)(?: Build/[^;)]+)?\)'
+    brand_replacement: 'Generic_Android'
+`
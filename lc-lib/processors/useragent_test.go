@@ -0,0 +1,139 @@
+package processors
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createUserAgentProcessor(t *testing.T, unused map[string]interface{}) (*ProcessorUserAgentFactory, Processor) {
+	factory, err := NewUserAgentProcessorFactory(config.NewConfig(), "", unused, "useragent", "message")
+	if err != nil {
+		t.Logf("Failed to create useragent processor: %s", err)
+		t.FailNow()
+	}
+
+	return factory.(*ProcessorUserAgentFactory), NewProcessor(factory)
+}
+
+func checkUserAgent(t *testing.T, event core.Event, path string, expected string) {
+	if value, ok := event.GetPath(path); !ok {
+		t.Errorf("Field %s missing", path)
+	} else if value != expected {
+		t.Errorf("Field %s incorrect: %v != %s", path, value, expected)
+	}
+}
+
+func TestUserAgentDesktop(t *testing.T) {
+	_, processor := createUserAgentProcessor(t, map[string]interface{}{})
+
+	ua := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36"
+	event := processor.Process(core.Event{"user_agent": ua})
+
+	checkUserAgent(t, event, "user_agent.original", ua)
+	checkUserAgent(t, event, "user_agent.name", "Chrome")
+	checkUserAgent(t, event, "user_agent.version", "91.0.4472")
+	checkUserAgent(t, event, "user_agent.os.name", "Windows")
+	checkUserAgent(t, event, "user_agent.os.version", "10")
+	checkUserAgent(t, event, "user_agent.device.name", "Other")
+}
+
+func TestUserAgentMobile(t *testing.T) {
+	_, processor := createUserAgentProcessor(t, map[string]interface{}{})
+
+	event := processor.Process(core.Event{"user_agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 14_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1.1 Mobile/15E148 Safari/604.1"})
+
+	checkUserAgent(t, event, "user_agent.name", "Mobile Safari")
+	checkUserAgent(t, event, "user_agent.version", "14.1.1")
+	checkUserAgent(t, event, "user_agent.os.name", "iOS")
+	checkUserAgent(t, event, "user_agent.os.full", "iOS 14.6")
+	checkUserAgent(t, event, "user_agent.device.name", "iPhone")
+	checkUserAgent(t, event, "user_agent.device.brand", "Apple")
+
+	event = processor.Process(core.Event{"user_agent": "Mozilla/5.0 (Linux; Android 11; SM-G991B) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/92.0.4515.115 Mobile Safari/537.36"})
+
+	checkUserAgent(t, event, "user_agent.name", "Chrome Mobile")
+	checkUserAgent(t, event, "user_agent.os.name", "Android")
+	checkUserAgent(t, event, "user_agent.os.version", "11")
+	checkUserAgent(t, event, "user_agent.device.name", "SM-G991B")
+	checkUserAgent(t, event, "user_agent.device.model", "SM-G991B")
+}
+
+func TestUserAgentTarget(t *testing.T) {
+	_, processor := createUserAgentProcessor(t, map[string]interface{}{
+		"field":  "agent",
+		"target": "client.ua",
+	})
+
+	event := processor.Process(core.Event{"agent": "curl/7.68.0"})
+
+	checkUserAgent(t, event, "agent", "curl/7.68.0")
+	checkUserAgent(t, event, "client.ua.name", "curl")
+	checkUserAgent(t, event, "client.ua.version", "7.68.0")
+}
+
+func TestUserAgentFailure(t *testing.T) {
+	_, processor := createUserAgentProcessor(t, map[string]interface{}{})
+
+	for _, event := range []core.Event{{"user_agent": "not a browser"}, {"message": "no user agent"}} {
+		event = processor.Process(event)
+
+		if _, ok := event["user_agent"].(map[string]interface{}); ok {
+			t.Errorf("User agent was set on failure: %v", event)
+		}
+
+		tags, ok := event["tags"].([]string)
+		if !ok || len(tags) != 1 || tags[0] != "_useragentparsefailure" {
+			t.Errorf("Event was not tagged: %v", event)
+		}
+	}
+}
+
+func TestUserAgentCache(t *testing.T) {
+	factory, processor := createUserAgentProcessor(t, map[string]interface{}{"cache size": 2})
+
+	agents := []string{"curl/7.68.0", "Wget/1.20.3", "curl/7.68.0", "python-requests/2.25.1"}
+	for _, ua := range agents {
+		processor.Process(core.Event{"user_agent": ua})
+	}
+
+	if len(factory.cache) != 2 || factory.cacheList.Len() != 2 {
+		t.Fatalf("Cache was not limited: %d", len(factory.cache))
+	}
+
+	// Wget was least recently used so should have been evicted
+	if _, ok := factory.cache["Wget/1.20.3"]; ok {
+		t.Error("Least recently used entry was not evicted")
+	}
+
+	// Events must not share the cached maps
+	first := processor.Process(core.Event{"user_agent": "curl/7.68.0"})
+	first.SetPath("user_agent.name", "modified")
+	second := processor.Process(core.Event{"user_agent": "curl/7.68.0"})
+	checkUserAgent(t, second, "user_agent.name", "curl")
+}
+
+func TestUserAgentRegexesFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "regexes")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("user_agent_parsers:\n  - regex: '(MyApp)/(\\d+)'\n    family_replacement: 'My $1'\n")
+	file.Close()
+
+	_, processor := createUserAgentProcessor(t, map[string]interface{}{"regexes": file.Name()})
+
+	event := processor.Process(core.Event{"user_agent": "MyApp/3"})
+	checkUserAgent(t, event, "user_agent.name", "My MyApp")
+	checkUserAgent(t, event, "user_agent.version", "3")
+	checkUserAgent(t, event, "user_agent.os.name", "Other")
+
+	if _, err := NewUserAgentProcessorFactory(config.NewConfig(), "", map[string]interface{}{"regexes": file.Name() + ".missing"}, "useragent", "message"); err == nil {
+		t.Error("Missing regexes file was accepted")
+	}
+}
@@ -21,6 +21,7 @@ package prospector
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/driskell/log-courier/lc-lib/admin"
 )
@@ -37,6 +38,10 @@ func (a *apiStatus) Update() error {
 	a.p.mutex.RLock()
 	a.SetEntry("watchedFiles", admin.APINumber(len(a.p.prospectorindex)))
 	a.SetEntry("activeStates", admin.APINumber(len(a.p.prospectors)))
+	a.SetEntry("lag_bps", admin.APIFloat(a.p.lag.average))
+	a.SetEntry("lag_warnings", admin.APINumber(a.p.lag.warnings))
+	a.SetEntry("pendingHarvesters", admin.APINumber(a.p.starts.Len()))
+	a.SetEntry("open_files_limit_hits", admin.APINumber(a.p.openFiles.hits))
 	a.p.mutex.RUnlock()
 
 	return nil
@@ -107,6 +112,12 @@ func (a *apiFiles) processEntry(info *prospectorInfo) {
 	case statusResume:
 		status = "resuming"
 		errString = admin.APINull
+	case statusPending:
+		status = "pending"
+		errString = admin.APINull
+	case statusWaiting:
+		status = "waiting"
+		errString = admin.APINull
 	case statusFailed:
 		status = "failed"
 		errString = admin.APIString(info.err.Error())
@@ -135,6 +146,81 @@ func (a *apiFiles) processEntry(info *prospectorInfo) {
 	a.AddEntry(key, apiEntry)
 }
 
+// apiProgress summarises how far behind harvesting is for each tracked file
+type apiProgress struct {
+	admin.APIKeyValue
+
+	p *Prospector
+}
+
+// Update updates the progress information
+func (a *apiProgress) Update() error {
+	files := &admin.APIArray{}
+	totalBehind := int64(0)
+
+	a.p.mutex.RLock()
+
+	for _, info := range a.p.prospectorindex {
+		totalBehind += a.processEntry(files, info)
+	}
+
+	for _, info := range a.p.prospectors {
+		if info.orphaned == orphanedNo {
+			continue
+		}
+		totalBehind += a.processEntry(files, info)
+	}
+
+	a.p.mutex.RUnlock()
+
+	a.SetEntry("files", files)
+	a.SetEntry("total_bytes_behind", admin.APINumber(totalBehind))
+
+	return nil
+}
+
+// processEntry adds the progress of a single file and returns the number of
+// bytes it is behind
+func (a *apiProgress) processEntry(files *admin.APIArray, info *prospectorInfo) int64 {
+	if info.file == "-" || info.status == statusInvalid {
+		return 0
+	}
+
+	offset, size, lastLine := info.progress()
+
+	apiEntry := &admin.APIKeyValue{}
+	apiEntry.SetEntry("path", admin.APIString(info.file))
+	apiEntry.SetEntry("offset", admin.APINumber(offset))
+
+	if info.running {
+		apiEntry.SetEntry("harvesting", admin.APIString("yes"))
+	} else {
+		apiEntry.SetEntry("harvesting", admin.APIString("no"))
+	}
+
+	if lastLine.IsZero() {
+		apiEntry.SetEntry("last_line_time", admin.APINull)
+	} else {
+		apiEntry.SetEntry("last_line_time", admin.APIString(lastLine.Format(time.RFC3339)))
+	}
+
+	behind := int64(0)
+	if size < 0 {
+		apiEntry.SetEntry("size", admin.APINull)
+		apiEntry.SetEntry("bytes_behind", admin.APINull)
+	} else {
+		if size > offset {
+			behind = size - offset
+		}
+		apiEntry.SetEntry("size", admin.APINumber(size))
+		apiEntry.SetEntry("bytes_behind", admin.APINumber(behind))
+	}
+
+	files.AddEntry(fmt.Sprintf("%p", info), apiEntry)
+
+	return behind
+}
+
 type api struct {
 	admin.APINode
 
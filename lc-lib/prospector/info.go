@@ -18,8 +18,10 @@ package prospector
 
 import (
 	"os"
+	"time"
 
 	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/harvester"
 	"github.com/driskell/log-courier/lc-lib/registrar"
 )
@@ -29,6 +31,8 @@ const (
 	statusResume
 	statusFailed
 	statusInvalid
+	statusPending
+	statusWaiting
 )
 
 const (
@@ -45,8 +49,12 @@ type prospectorInfo struct {
 	running      bool
 	orphaned     int
 	finishOffset int64
+	lastLineTime time.Time
 	harvester    *harvester.Harvester
+	fileconfig   *config.File
 	err          error
+	stopAtEOF    bool
+	waitFor      *prospectorInfo
 }
 
 func newProspectorInfoFromFileState(file string, filestate *registrar.FileState) *prospectorInfo {
@@ -110,11 +118,29 @@ func (pi *prospectorInfo) apiEncodable() admin.APIEncodable {
 	return pi.harvester.APIEncodable()
 }
 
+// progress returns the offset and size of the file, and the time a line was
+// last read from it. If the size is not known it is returned as -1
+func (pi *prospectorInfo) progress() (int64, int64, time.Time) {
+	if pi.running {
+		return pi.harvester.Progress()
+	}
+
+	size := int64(-1)
+	if fileinfo := pi.identity.Stat(); fileinfo != nil {
+		size = fileinfo.Size()
+	}
+
+	return pi.finishOffset, size, pi.lastLineTime
+}
+
 func (pi *prospectorInfo) setHarvesterStopped(status *harvester.FinishStatus) {
 	pi.running = false
 	// Resume harvesting from the last event offset, not the last read, to allow codec to read from the last event
 	// This ensures multiline codec populates correctly on resume
 	pi.finishOffset = status.LastEventOffset
+	if !status.LastLineTime.IsZero() {
+		pi.lastLineTime = status.LastLineTime
+	}
 	if status.Error != nil {
 		pi.status = statusFailed
 		pi.err = status.Error
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"math"
+	"time"
+)
+
+// lagMonitor tracks an exponential moving average of the rate at which the
+// number of bytes waiting to be harvested grows, which is the rate bytes are
+// appended to files minus the rate they are harvested. A sustained positive
+// rate indicates harvesting is not keeping up
+type lagMonitor struct {
+	lastCheck  time.Time
+	lastBehind int64
+	average    float64
+	aboveSince time.Time
+	warning    bool
+	warnings   uint64
+}
+
+// Update records the total bytes behind at the given time, and returns true if
+// the average has now been above the threshold for the given duration, in
+// which case a warning should be raised. A warning is only raised once until
+// the average falls below the threshold again
+func (m *lagMonitor) Update(now time.Time, behind int64, threshold int64, window time.Duration, duration time.Duration) bool {
+	if m.lastCheck.IsZero() {
+		m.lastCheck, m.lastBehind = now, behind
+		return false
+	}
+
+	elapsed := now.Sub(m.lastCheck)
+	if elapsed <= 0 {
+		return false
+	}
+
+	// Weight the new rate by the time elapsed so that irregular intervals
+	// between updates are handled correctly
+	rate := float64(behind-m.lastBehind) / elapsed.Seconds()
+	alpha := 1 - math.Exp(-float64(elapsed)/float64(window))
+	m.average += alpha * (rate - m.average)
+	m.lastCheck, m.lastBehind = now, behind
+
+	if threshold == 0 || m.average < float64(threshold) {
+		m.aboveSince = time.Time{}
+		if m.warning {
+			m.warning = false
+			log.Notice("Harvesting has caught up: backlog is now growing at %.0f bytes/s", m.average)
+		}
+		return false
+	}
+
+	if m.aboveSince.IsZero() {
+		m.aboveSince = now
+	}
+
+	if m.warning || now.Sub(m.aboveSince) < duration {
+		return false
+	}
+
+	m.warning = true
+	m.warnings++
+	return true
+}
+
+// checkLag updates the lag monitor with the current backlog, logging a warning
+// if harvesting is steadily falling behind
+func (p *Prospector) checkLag() {
+	general := &p.config.General
+	if general.LagThreshold == 0 {
+		return
+	}
+
+	now := time.Now()
+	behind := p.totalBytesBehind()
+
+	p.mutex.Lock()
+	warn := p.lag.Update(now, behind, general.LagThreshold, general.LagWindow, general.LagDuration)
+	average := p.lag.average
+	p.mutex.Unlock()
+
+	if warn {
+		log.Warning("Harvesting is falling behind: backlog of %d bytes has been growing at over %d bytes/s for %v (currently %.0f bytes/s)", behind, general.LagThreshold, general.LagDuration, average)
+	}
+}
+
+// totalBytesBehind returns the total number of bytes waiting to be harvested
+// across all tracked files
+func (p *Prospector) totalBytesBehind() int64 {
+	var total int64
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for _, info := range p.prospectors {
+		if info.file == "-" || info.status == statusInvalid {
+			continue
+		}
+
+		offset, size, _ := info.progress()
+		if size > offset {
+			total += size - offset
+		}
+	}
+
+	return total
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLagMonitor(t *testing.T) {
+	monitor := &lagMonitor{}
+	start := time.Now()
+	behind := int64(0)
+
+	// Backlog growing steadily at 1000 bytes/s
+	warned := false
+	for i := 0; i <= 60; i++ {
+		now := start.Add(time.Duration(i) * 10 * time.Second)
+		if monitor.Update(now, behind, 500, time.Minute, 2*time.Minute) {
+			if warned {
+				t.Fatal("Warning was raised more than once")
+			}
+			warned = true
+
+			// EMA takes a while to cross the threshold and must then be sustained
+			if elapsed := now.Sub(start); elapsed < 2*time.Minute {
+				t.Errorf("Warning was raised too early: %v", elapsed)
+			}
+		}
+		behind += 10000
+	}
+
+	if !warned || monitor.warnings != 1 {
+		t.Fatalf("Warning was not raised: %d", monitor.warnings)
+	}
+
+	// Backlog shrinking clears the warning
+	for i := 61; i <= 120; i++ {
+		behind -= 10000
+		if monitor.Update(start.Add(time.Duration(i)*10*time.Second), behind, 500, time.Minute, 2*time.Minute) {
+			t.Fatal("Warning was raised while catching up")
+		}
+	}
+
+	if monitor.warning || monitor.average >= 0 {
+		t.Errorf("Warning was not cleared: %v %.0f", monitor.warning, monitor.average)
+	}
+}
+
+func TestLagMonitorSpike(t *testing.T) {
+	monitor := &lagMonitor{}
+	start := time.Now()
+
+	// A single jump, such as a new file being written, is not a sustained lag
+	monitor.Update(start, 0, 500, time.Minute, 2*time.Minute)
+	monitor.Update(start.Add(10*time.Second), 100000, 500, time.Minute, 2*time.Minute)
+	for i := 2; i <= 60; i++ {
+		if monitor.Update(start.Add(time.Duration(i)*10*time.Second), 100000, 500, time.Minute, 2*time.Minute) {
+			t.Fatalf("Warning was raised for a single spike after %d updates", i)
+		}
+	}
+}
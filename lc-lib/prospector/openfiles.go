@@ -0,0 +1,82 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"time"
+)
+
+// openFilesLimit tracks harvesters failing to start because the open files
+// limit has been reached, so that new harvesters can be held back for a while
+// instead of repeatedly failing
+type openFilesLimit struct {
+	until time.Time
+	hits  uint64
+}
+
+// Hit records a harvester failing to start due to the limit and holds back new
+// harvesters for the given duration. It returns true if harvesters were not
+// already being held back, in which case a warning should be raised
+func (l *openFilesLimit) Hit(now time.Time, backoff time.Duration) bool {
+	l.hits++
+	fresh := !l.BackingOff(now)
+	l.until = now.Add(backoff)
+	return fresh
+}
+
+// BackingOff returns true if new harvesters should currently be held back
+func (l *openFilesLimit) BackingOff(now time.Time) bool {
+	return now.Before(l.until)
+}
+
+// handleOpenFilesLimit is called with the mutex held when a harvester failed
+// to start because the open files limit was reached. New harvesters are held
+// back, and idle harvesters are closed to free up descriptors for files that
+// have data waiting
+func (p *Prospector) handleOpenFilesLimit(file string) {
+	general := &p.config.General
+	if !p.openFiles.Hit(time.Now(), general.OpenFilesBackoff) {
+		return
+	}
+
+	closed := 0
+	if general.OpenFilesIdle != 0 {
+		closed = p.closeIdleHarvesters(general.OpenFilesIdle)
+	}
+
+	log.Warning("Too many open files when starting harvester for %s: new harvesters will be held back for %v and %d idle harvesters have been closed. Raise the open files limit of Log Courier (such as with \"ulimit -n\") to harvest more files at once", file, general.OpenFilesBackoff, closed)
+}
+
+// closeIdleHarvesters stops harvesters that have been waiting at the end of
+// their file for at least the given duration, returning how many were stopped.
+// They are resumed as usual when their file is next modified
+func (p *Prospector) closeIdleHarvesters(idle time.Duration) int {
+	closed := 0
+	for _, info := range p.prospectors {
+		if info.file == "-" || !info.isRunning() {
+			continue
+		}
+
+		if since, ok := info.harvester.IdleSince(); ok && time.Since(since) >= idle {
+			log.Info("Closing idle harvester to free up open files: %s", info.file)
+			info.stop()
+			closed++
+		}
+	}
+
+	return closed
+}
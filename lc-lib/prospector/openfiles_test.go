@@ -0,0 +1,67 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+func TestOpenFilesLimit(t *testing.T) {
+	limit := &openFilesLimit{}
+	start := time.Now()
+
+	if limit.BackingOff(start) {
+		t.Fatal("Backing off before the limit was hit")
+	}
+
+	if !limit.Hit(start, 30*time.Second) {
+		t.Error("First hit did not request a warning")
+	}
+
+	// Further failures while backing off extend it without another warning
+	if limit.Hit(start.Add(10*time.Second), 30*time.Second) {
+		t.Error("Hit while backing off requested a warning")
+	}
+
+	if !limit.BackingOff(start.Add(39 * time.Second)) {
+		t.Error("Backoff was not extended")
+	}
+
+	if limit.BackingOff(start.Add(40 * time.Second)) {
+		t.Error("Still backing off after the backoff expired")
+	}
+
+	if !limit.Hit(start.Add(time.Minute), 30*time.Second) || limit.hits != 3 {
+		t.Errorf("Hit after the backoff expired was not counted: %d", limit.hits)
+	}
+}
+
+func TestOpenFilesLimitHoldsStarts(t *testing.T) {
+	p := &Prospector{}
+	p.openFiles.Hit(time.Now(), time.Minute)
+
+	info := &prospectorInfo{file: "test.log", status: statusFailed}
+	p.queueStart(info, &config.File{}, 100)
+
+	p.processStarts()
+	if p.starts.Len() != 1 || info.running {
+		t.Fatal("Queued harvester was started while backing off")
+	}
+}
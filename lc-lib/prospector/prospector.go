@@ -20,8 +20,10 @@
 package prospector
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sync"
 	"time"
 
@@ -50,6 +52,9 @@ type Prospector struct {
 	lastscan        time.Time
 	registrar       registrar.Registrator
 	registrarSpool  registrar.EventSpooler
+	lag             lagMonitor
+	starts          startQueue
+	openFiles       openFilesLimit
 
 	output chan<- *core.EventDescriptor
 }
@@ -152,6 +157,7 @@ func (p *Prospector) runOnce() bool {
 
 	// Clean up the prospector collections
 	p.mutex.Lock()
+	p.processStarts()
 	for _, info := range p.prospectors {
 		if info.orphaned >= orphanedMaybe {
 			if !info.isRunning() {
@@ -174,21 +180,41 @@ func (p *Prospector) runOnce() bool {
 	// Flush the accumulated registrar events
 	p.registrarSpool.Send()
 
+	p.checkLag()
+
 	p.lastscan = newlastscan
 
 	// Defer next scan for a bit
 	now := time.Now()
 	scanDeadline := now.Add(p.config.General.ProspectInterval)
 
+	// Continue starting queued harvesters while we wait
+	var startTimer <-chan time.Time
+	if p.starts.Len() != 0 {
+		startTimer = time.After(time.Second)
+	}
+
 DelayLoop:
 	for {
 		select {
 		case <-time.After(scanDeadline.Sub(now)):
 			break DelayLoop
+		case <-startTimer:
+			p.mutex.Lock()
+			p.processStarts()
+			p.mutex.Unlock()
+
+			if p.starts.Len() != 0 {
+				startTimer = time.After(time.Second)
+			} else {
+				startTimer = nil
+			}
 		case <-p.OnShutdown():
 			return true
 		case config := <-p.OnConfig():
+			p.mutex.Lock()
 			p.config = config
+			p.mutex.Unlock()
 		}
 
 		now = time.Now()
@@ -311,7 +337,7 @@ func (p *Prospector) processFile(file string, config *config.File) {
 		// Store the new entry
 		p.prospectors[info] = info
 	} else {
-		if !info.identity.SameAs(fileinfo) {
+		if !info.identity.SameAs(file, fileinfo) {
 			// Keep the old file in case we find it again shortly
 			info.orphaned = orphanedMaybe
 
@@ -328,6 +354,16 @@ func (p *Prospector) processFile(file string, config *config.File) {
 				info.file = file
 
 				p.registrarSpool.Add(registrar.NewRenamedEvent(info, file))
+			} else if rotated := p.reattachRotated(info, config); rotated != nil {
+				// The file was rotated while we were stopped, so finish the rotated
+				// file before starting the new one
+				log.Info("Waiting for rotated file to complete before launching harvester: %s", file)
+
+				info = newProspectorInfoFromFileInfo(file, fileinfo)
+				info.status = statusWaiting
+				info.waitFor = rotated
+
+				p.registrarSpool.Add(registrar.NewDiscoverEvent(info, file, 0, fileinfo))
 			} else {
 				// File is not the same file we saw previously, it must have rotated and is a new file
 				log.Info("Launching harvester on rotated file: %s", file)
@@ -347,7 +383,19 @@ func (p *Prospector) processFile(file string, config *config.File) {
 	// Resume stopped harvesters
 	resume := !info.isRunning()
 	if resume {
-		if info.status == statusResume {
+		if info.status == statusPending {
+			// Already waiting to start
+			resume = false
+		} else if info.status == statusWaiting {
+			if info.waitFor.isRunning() || info.waitFor.status == statusPending {
+				// Keep the rotated file until it completes
+				info.waitFor.lastSeen = p.iteration
+				resume = false
+			} else {
+				log.Info("Launching harvester on rotated file: %s", file)
+				info.waitFor = nil
+			}
+		} else if info.status == statusResume {
 			if info.finishOffset == fileinfo.Size() && time.Since(fileinfo.ModTime()) > config.DeadTime {
 				// Old file with an unchanged offset, skip it
 				log.Info("Skipping file (older than dead time of %v): %s", config.DeadTime, file)
@@ -358,8 +406,13 @@ func (p *Prospector) processFile(file string, config *config.File) {
 				log.Info("Resuming harvester on a previously harvested file: %s", file)
 			}
 		} else if info.status == statusFailed {
-			// Last attempt we failed to start, try again
-			log.Info("Attempting to restart failed harvester: %s", file)
+			if info.err == harvester.ErrTooManyOpenFiles {
+				// Hold back new harvesters and queue this one to retry
+				p.handleOpenFilesLimit(file)
+			} else {
+				// Last attempt we failed to start, try again
+				log.Info("Attempting to restart failed harvester: %s", file)
+			}
 		} else if info.identity.Stat().ModTime() != fileinfo.ModTime() {
 			// Resume harvesting of an old file we've stopped harvesting from
 			log.Info("Resuming harvester on an old file that was just modified: %s", file)
@@ -400,10 +453,20 @@ func (p *Prospector) flagDuplicateError(file string, info *prospectorInfo) {
 func (p *Prospector) startHarvester(info *prospectorInfo, fileconfig *config.File) {
 	var offset int64
 
-	if p.fromBeginning {
+	if p.fromBeginning || fileconfig.StartPosition == "beginning" {
 		offset = 0
 	} else {
 		offset = info.identity.Stat().Size()
+
+		if fileconfig.StartLinesFromEnd != 0 {
+			linesOffset, err := offsetLinesFromEnd(info.file, offset, fileconfig.StartLinesFromEnd, fileconfig.Encoding, fileconfig.LineDelimiter[0])
+			if err != nil {
+				log.Warning("Failed to locate start position for %s, starting from the end: %s", info.file, err)
+			} else {
+				log.Info("Starting %d lines from the end of %s at offset %d", fileconfig.StartLinesFromEnd, info.file, linesOffset)
+				offset = linesOffset
+			}
+		}
 	}
 
 	// Send a new file event to allow registrar to begin persisting for this harvester
@@ -413,15 +476,83 @@ func (p *Prospector) startHarvester(info *prospectorInfo, fileconfig *config.Fil
 }
 
 // startHarvesterWithOffset starts a new harvester against a file starting at
-// the given offset
+// the given offset, or queues it if the file group has a start rate or new
+// harvesters are being held back due to the open files limit
 func (p *Prospector) startHarvesterWithOffset(info *prospectorInfo, fileconfig *config.File, offset int64) {
+	if fileconfig.StartRate != 0 || p.openFiles.BackingOff(time.Now()) {
+		p.queueStart(info, fileconfig, offset)
+		return
+	}
+
+	p.launchHarvester(info, fileconfig, offset)
+}
+
+// launchHarvester creates and starts the harvester for a file
+func (p *Prospector) launchHarvester(info *prospectorInfo, fileconfig *config.File, offset int64) {
 	// TODO - hook in a shutdown channel
-	info.harvester = harvester.NewHarvester(info, p.config, &fileconfig.Stream, offset)
+	info.harvester = harvester.NewHarvester(info, p.config, &fileconfig.Stream, p.registrar.Sequence(), offset)
+	info.fileconfig = fileconfig
+	if info.stopAtEOF {
+		info.harvester.StopAtEOF()
+	}
 	info.running = true
 	info.status = statusOk
 	info.harvester.Start(p.output)
 }
 
+// ReloadProcessors replaces the processors of each file group and of its
+// routes with those of the given file groups, which must match the current file
+// groups, and passes them to the running harvesters so they take effect
+// without restarting them
+func (p *Prospector) ReloadProcessors(files []config.File) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(files) != len(p.config.Files) {
+		return errors.New("file groups have changed, a full reload is required")
+	}
+
+	for i := range files {
+		if !reflect.DeepEqual(files[i].Paths, p.config.Files[i].Paths) {
+			return errors.New("file groups have changed, a full reload is required")
+		}
+		if !sameRoutes(files[i].Routes, p.config.Files[i].Routes) {
+			return errors.New("routes have changed, a full reload is required")
+		}
+	}
+
+	for i := range files {
+		p.config.Files[i].Processors = files[i].Processors
+		for j := range files[i].Routes {
+			p.config.Files[i].Routes[j].Processors = files[i].Routes[j].Processors
+		}
+	}
+
+	for _, info := range p.prospectors {
+		if info.harvester != nil && info.fileconfig != nil {
+			info.harvester.SetProcessors(info.fileconfig.Processors, info.fileconfig.Routes)
+		}
+	}
+
+	return nil
+}
+
+// sameRoutes returns true if the given routes have the same names and codecs,
+// so that only their processors differ
+func sameRoutes(routes []config.Route, previous []config.Route) bool {
+	if len(routes) != len(previous) {
+		return false
+	}
+
+	for i := range routes {
+		if routes[i].Name != previous[i].Name || !reflect.DeepEqual(routes[i].Codecs, previous[i].Codecs) {
+			return false
+		}
+	}
+
+	return true
+}
+
 // lookupFileIds checks a file's filesystem identifiers against all other known
 // files so we can handle file movements and renames
 func (p *Prospector) lookupFileIds(file string, info os.FileInfo) (string, *prospectorInfo) {
@@ -434,7 +565,7 @@ func (p *Prospector) lookupFileIds(file string, info os.FileInfo) (string, *pros
 			// We already know the prospector info for this file doesn't match, so don't check again
 			continue
 		}
-		if ki.identity.SameAs(info) {
+		if ki.identity.SameAs(file, info) {
 			// Already seen?
 			if ki.lastSeen == p.iteration {
 				return ki.file, nil
@@ -457,7 +588,7 @@ func (p *Prospector) lookupFileIds(file string, info os.FileInfo) (string, *pros
 // initAPI sets up admin connectivity
 func (p *Prospector) initAPI() {
 	// Is admin loaded into the pipeline?
-	if !p.adminConfig.Enabled {
+	if !p.adminConfig.APIEnabled() {
 		return
 	}
 
@@ -465,4 +596,5 @@ func (p *Prospector) initAPI() {
 	prospectorAPI.SetEntry("status", &apiStatus{p: p})
 
 	p.adminConfig.SetEntry("prospector", prospectorAPI)
+	p.adminConfig.SetEntry("files", &apiProgress{p: p})
 }
@@ -0,0 +1,88 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"os"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/registrar"
+)
+
+// rotatedSuffixes are the suffixes that are checked when looking for a file
+// that was rotated while we were stopped. Compressed rotations are not checked
+// as compressing a file creates a new file that can never match the saved
+// identity, and offsets within it would not match either
+var rotatedSuffixes = []string{".1"}
+
+// findRotated checks the common rotation names for a file to see if any of them
+// is the file with the given identity, returning its path and information
+func findRotated(file string, identity registrar.FileIdentity) (string, os.FileInfo) {
+	for _, suffix := range rotatedSuffixes {
+		fileinfo, err := os.Stat(file + suffix)
+		if err != nil || fileinfo.IsDir() {
+			continue
+		}
+
+		if identity.SameAs(file+suffix, fileinfo) {
+			return file + suffix, fileinfo
+		}
+	}
+
+	return "", nil
+}
+
+// reattachRotated handles a file whose saved state no longer matches the file
+// at the same path on startup, which happens when the file was rotated while we
+// were stopped. If the rotated file is found and there is more to harvest from
+// it, its harvester is resumed so it is read to the end, and it is returned
+func (p *Prospector) reattachRotated(info *prospectorInfo, fileconfig *config.File) *prospectorInfo {
+	// Only saved states that have not yet been resumed are checked, as while we
+	// are running, rotations are detected by the scan when they happen
+	if info.status != statusResume {
+		return nil
+	}
+
+	rotated, fileinfo := findRotated(info.file, info.identity)
+	if rotated == "" {
+		return nil
+	}
+
+	if _, isKnown := p.prospectorindex[rotated]; isKnown {
+		// The rotated file is also being harvested, so will be matched by the scan
+		return nil
+	}
+
+	if fileinfo.Size() <= info.finishOffset {
+		// Nothing more to harvest
+		return nil
+	}
+
+	log.Info("File was rotated while stopped, resuming harvester to finish it: %s -> %s", info.file, rotated)
+
+	p.registrarSpool.Add(registrar.NewRenamedEvent(info, rotated))
+
+	info.file = rotated
+	info.orphaned = orphanedNo
+	info.stopAtEOF = true
+	info.update(fileinfo, p.iteration)
+	p.prospectorindex[rotated] = info
+
+	p.startHarvesterWithOffset(info, fileconfig, info.finishOffset)
+
+	return info
+}
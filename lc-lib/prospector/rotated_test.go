@@ -0,0 +1,65 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/registrar"
+)
+
+func TestFindRotated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotated")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(file, []byte("line1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+
+	fileinfo, err := os.Stat(file)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %s", err)
+	}
+	identity := registrar.NewFileInfo(fileinfo)
+
+	if rotated, _ := findRotated(file, identity); rotated != "" {
+		t.Errorf("Unexpected rotated file found: %s", rotated)
+	}
+
+	// Rotate and replace the file
+	if err := os.Rename(file, file+".1"); err != nil {
+		t.Fatalf("Failed to rotate file: %s", err)
+	}
+	if err := ioutil.WriteFile(file, []byte("line2\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+
+	rotated, rotatedinfo := findRotated(file, identity)
+	if rotated != file+".1" {
+		t.Fatalf("Rotated file not found: %s", rotated)
+	}
+	if rotatedinfo.Size() != 6 {
+		t.Errorf("Incorrect rotated file information: %d", rotatedinfo.Size())
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/driskell/log-courier/lc-lib/harvester"
+)
+
+// startPositionBlockSize is the size of the blocks read when scanning backwards
+// through a file
+const startPositionBlockSize = 4096
+
+// offsetLinesFromEnd scans backwards from the given size of the file to locate
+// the offset of the start of the line that is the given number of lines from
+// the end, where lines end with the given delimiter in the given encoding. The
+// delimiter terminating the final line is not counted, so that a file ending
+// with a delimiter is treated the same as one that does not. If the file has
+// fewer lines than requested, 0 is returned
+func offsetLinesFromEnd(path string, size int64, lines int64, encoding string, delimiter byte) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	// Encoded delimiters are only matched at code unit boundaries, so ignore any
+	// partially written code unit at the end of the file
+	encoded := harvester.NewLineEncoding(encoding, file).Delimiter(delimiter)
+	unit := int64(len(encoded))
+	size -= size % unit
+
+	buffer := make([]byte, startPositionBlockSize)
+	found := int64(0)
+	position := size
+
+	for position > 0 {
+		length := int64(len(buffer))
+		if position < length {
+			length = position
+		}
+		position -= length
+
+		if _, err := file.ReadAt(buffer[:length], position); err != nil {
+			return 0, err
+		}
+
+		for i := length - unit; i >= 0; i -= unit {
+			if !bytes.Equal(buffer[i:i+unit], encoded) || position+i+unit == size {
+				continue
+			}
+
+			found++
+			if found == lines {
+				return position + i + unit, nil
+			}
+		}
+	}
+
+	return 0, nil
+}
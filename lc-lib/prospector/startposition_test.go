@@ -0,0 +1,81 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func checkLinesFromEnd(t *testing.T, data []byte, encoding string, lines int64, expected int64) {
+	file, err := ioutil.TempFile("", "startposition")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		t.Fatalf("Failed to write temporary file: %s", err)
+	}
+	file.Close()
+
+	offset, err := offsetLinesFromEnd(file.Name(), int64(len(data)), lines, encoding, '\n')
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	} else if offset != expected {
+		t.Errorf("Incorrect offset for %d lines: found %d != expected %d", lines, offset, expected)
+	}
+}
+
+func TestLinesFromEnd(t *testing.T) {
+	data := []byte("line1\nline2\nline3\n")
+
+	checkLinesFromEnd(t, data, "utf-8", 1, 12)
+	checkLinesFromEnd(t, data, "utf-8", 2, 6)
+	checkLinesFromEnd(t, data, "utf-8", 3, 0)
+	checkLinesFromEnd(t, data, "utf-8", 10, 0)
+}
+
+func TestLinesFromEndPartialLine(t *testing.T) {
+	data := []byte("line1\nline2\nline3")
+
+	checkLinesFromEnd(t, data, "utf-8", 1, 12)
+	checkLinesFromEnd(t, data, "utf-8", 2, 6)
+}
+
+func TestLinesFromEndMultipleBlocks(t *testing.T) {
+	line := append(bytes.Repeat([]byte("x"), 99), '\n')
+	data := bytes.Repeat(line, 100)
+
+	checkLinesFromEnd(t, data, "utf-8", 50, 5000)
+	checkLinesFromEnd(t, data, "utf-8", 99, 100)
+}
+
+func TestLinesFromEndUTF16(t *testing.T) {
+	// "\ufeffa\nb\n\u0a41\u0100" in UTF-16LE, where the final character contains
+	// a misaligned new line
+	data := []byte("\xff\xfea\x00\n\x00b\x00\n\x00\x41\x0a\x00\x01")
+
+	checkLinesFromEnd(t, data, "utf-16", 1, 10)
+	checkLinesFromEnd(t, data, "utf-16le", 2, 6)
+	checkLinesFromEnd(t, data, "utf-16le", 3, 0)
+
+	// A partially written code unit at the end is ignored
+	checkLinesFromEnd(t, append(data, '\n'), "utf-16le", 1, 10)
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prospector
+
+import (
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+// pendingStart is a harvester that is waiting to be started due to the start
+// rate of its file group
+type pendingStart struct {
+	info       *prospectorInfo
+	fileconfig *config.File
+	offset     int64
+}
+
+// startQueue holds harvesters waiting to be started, so that discovering a
+// large number of files does not open them all at once. Files being resumed
+// from a saved offset are started before new files
+type startQueue struct {
+	resuming   []*pendingStart
+	new        []*pendingStart
+	started    map[*config.File]int64
+	lastRefill time.Time
+}
+
+// Len returns the number of harvesters waiting to be started
+func (q *startQueue) Len() int {
+	return len(q.resuming) + len(q.new)
+}
+
+// queueStart queues a harvester to be started once the start rate of its file
+// group allows
+func (p *Prospector) queueStart(info *prospectorInfo, fileconfig *config.File, offset int64) {
+	start := &pendingStart{info: info, fileconfig: fileconfig, offset: offset}
+	if info.status == statusResume {
+		p.starts.resuming = append(p.starts.resuming, start)
+	} else {
+		p.starts.new = append(p.starts.new, start)
+	}
+
+	info.status = statusPending
+	info.finishOffset = offset
+}
+
+// processStarts starts as many of the queued harvesters as the start rate of
+// their file groups allows, and must be called with the mutex held. Nothing is
+// started while harvesters are being held back due to the open files limit
+func (p *Prospector) processStarts() {
+	if p.starts.Len() == 0 || p.openFiles.BackingOff(time.Now()) {
+		return
+	}
+
+	if now := time.Now(); now.Sub(p.starts.lastRefill) >= time.Second {
+		p.starts.started = make(map[*config.File]int64)
+		p.starts.lastRefill = now
+	}
+
+	p.starts.resuming = p.launchPending(p.starts.resuming)
+	p.starts.new = p.launchPending(p.starts.new)
+
+	if p.starts.Len() != 0 {
+		log.Debug("%d harvesters are waiting to start", p.starts.Len())
+	}
+}
+
+// launchPending starts harvesters from the queue until the start rates are
+// reached, returning those that remain. File groups without a start rate are
+// only queued while harvesters are held back, and are started all at once
+func (p *Prospector) launchPending(queue []*pendingStart) []*pendingStart {
+	remaining := queue[:0]
+	for _, start := range queue {
+		if start.info.orphaned == orphanedYes {
+			// File was deleted or replaced while waiting
+			continue
+		}
+
+		if start.info.orphaned != orphanedNo || (start.fileconfig.StartRate != 0 && p.starts.started[start.fileconfig] >= start.fileconfig.StartRate) {
+			remaining = append(remaining, start)
+			continue
+		}
+
+		p.starts.started[start.fileconfig]++
+		p.launchHarvester(start.info, start.fileconfig, start.offset)
+	}
+
+	return remaining
+}
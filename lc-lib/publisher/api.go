@@ -36,7 +36,36 @@ func (a *apiStatus) Update() error {
 	a.SetEntry("speed", admin.APIFloat(a.p.lineSpeed))
 	a.SetEntry("publishedLines", admin.APINumber(a.p.lastLineCount))
 	a.SetEntry("pendingPayloads", admin.APINumber(a.p.numPayloads))
+	a.SetEntry("pendingEvents", admin.APINumber(a.p.numEvents))
+	a.SetEntry("rateAlerts", admin.APINumber(a.p.rateAlert.alerts))
+	if a.p.backlogPaused {
+		a.SetEntry("backlog", admin.APIString("paused"))
+	} else {
+		a.SetEntry("backlog", admin.APIString("ok"))
+	}
 	a.p.mutex.RUnlock()
 
 	return nil
 }
+
+type apiSnapshot struct {
+	admin.APIKeyValue
+
+	p *Publisher
+}
+
+// Update takes a new snapshot of the payloads pending acknowledgement
+func (a *apiSnapshot) Update() error {
+	snapshot, err := a.p.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	a.SetEntry("pendingPayloads", admin.APINumber(snapshot.PendingPayloads))
+	a.SetEntry("pendingEvents", admin.APINumber(snapshot.PendingEvents))
+	a.SetEntry("resendPayloads", admin.APINumber(snapshot.ResendPayloads))
+	a.SetEntry("outOfSync", admin.APINumber(snapshot.OutOfSync))
+	a.SetEntry("oldestPayloadAge", admin.APIFloat(snapshot.OldestPayloadAge.Seconds()))
+
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"os"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/endpoint"
+	"github.com/driskell/log-courier/lc-lib/payload"
+)
+
+const (
+	canaryMessage = "Log Courier canary"
+	canaryTag     = "_canary"
+)
+
+// canaryStream is the Stream of canary events. It has no source file so the
+// registrar never stores an offset for it
+type canaryStream struct{}
+
+// Info returns the name and file information for the canary stream
+func (s *canaryStream) Info() (string, os.FileInfo) {
+	return "canary", nil
+}
+
+// canary tracks a canary payload until it is acknowledged
+type canary struct {
+	server string
+	sent   time.Time
+}
+
+// sendCanary queues a synthetic canary event on a newly started endpoint so
+// that end-to-end delivery is verified immediately after connecting. The
+// canary is tracked like any other payload, so it is resent if the endpoint
+// fails and its acknowledgement is passed through to the registrar in order
+func (p *Publisher) sendCanary(endpoint *endpoint.Endpoint) bool {
+	event := core.Event{
+		"host":       p.host,
+		"@timestamp": time.Now().UTC(),
+	}
+	event.SetPath(p.general.MessageField, canaryMessage)
+	event.AddTags(canaryTag)
+
+	encoded, err := event.Encode(p.general.MessageField)
+	if err != nil {
+		log.Errorf("[%s] Failed to encode canary event: %s", endpoint.Server(), err)
+		return false
+	}
+
+	pendingPayload := payload.NewPayload([]*core.EventDescriptor{
+		&core.EventDescriptor{
+			Stream: &canaryStream{},
+			Event:  encoded,
+		},
+	})
+
+	p.payloadList.PushBack(&pendingPayload.Element)
+	p.canaries[pendingPayload] = &canary{server: endpoint.Server(), sent: time.Now()}
+
+	p.mutex.Lock()
+	p.numPayloads++
+	p.numEvents++
+	p.mutex.Unlock()
+
+	log.Info("[%s] Sending canary event", endpoint.Server())
+
+	if err := p.endpointSink.QueuePayloadOn(endpoint, pendingPayload); err != nil {
+		p.forceEndpointFailure(endpoint, err)
+		return true
+	}
+
+	p.endpointSink.RegisterTimeout(
+		&endpoint.Timeout,
+		endpoint.NetworkTimeout(),
+		func() {
+			p.timeoutPending(endpoint)
+		},
+	)
+
+	return true
+}
+
+// ackCanary checks if a completed payload is a canary, logging its delivery
+// and returning true if it was
+func (p *Publisher) ackCanary(endpoint *endpoint.Endpoint, pendingPayload *payload.Payload) bool {
+	sent, ok := p.canaries[pendingPayload]
+	if !ok {
+		return false
+	}
+
+	delete(p.canaries, pendingPayload)
+
+	if sent.server != endpoint.Server() {
+		log.Warning("[%s] Canary event for %s was acknowledged after redelivery (%v)", endpoint.Server(), sent.server, time.Since(sent.sent))
+	} else {
+		log.Info("[%s] Canary event was acknowledged within %v", endpoint.Server(), time.Since(sent.sent))
+	}
+
+	return true
+}
+
+// failCanaries logs any canary payloads that were pulled back from a failed
+// endpoint without being acknowledged
+func (p *Publisher) failCanaries(endpoint *endpoint.Endpoint, pulledBack []*payload.Payload) {
+	for _, pendingPayload := range pulledBack {
+		if sent, ok := p.canaries[pendingPayload]; ok && sent.server == endpoint.Server() {
+			log.Warning("[%s] Canary event was not acknowledged before the endpoint failed", endpoint.Server())
+		}
+	}
+}
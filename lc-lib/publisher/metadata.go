@@ -0,0 +1,92 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// heldSpool is a set of events waiting to be sent as a single payload, along
+// with the metadata value they share
+type heldSpool struct {
+	events   []*core.EventDescriptor
+	metadata string
+}
+
+// splitSpool splits a spool of events into a spool for each value of the
+// metadata field, so that each payload carries a single metadata value. The
+// spool is returned whole if no metadata field is configured
+func (p *Publisher) splitSpool(events []*core.EventDescriptor) []*heldSpool {
+	if p.config.MetadataField == "" {
+		return []*heldSpool{{events: events}}
+	}
+
+	return splitByMetadata(events, p.config.MetadataField)
+}
+
+// splitByMetadata groups events by the value of the metadata field, keeping
+// the order of the events within each group. Payloads are acknowledged to the
+// registrar in the order they are sent, so a group is only reused if doing so
+// would not place an event before an earlier event from the same stream, and
+// a new group with the same value is started otherwise
+func splitByMetadata(events []*core.EventDescriptor, field string) []*heldSpool {
+	var spools []*heldSpool
+	latest := make(map[string]int)
+	streams := make(map[core.Stream]int)
+
+	for _, event := range events {
+		value := eventMetadata(event, field)
+
+		index, ok := latest[value]
+		if !ok || index < streams[event.Stream] {
+			index = len(spools)
+			latest[value] = index
+			spools = append(spools, &heldSpool{metadata: value})
+		}
+
+		spools[index].events = append(spools[index].events, event)
+		streams[event.Stream] = index
+	}
+
+	return spools
+}
+
+// eventMetadata returns the value of the metadata field of an event, which is
+// empty if the field is missing or is not a string, number or boolean
+func eventMetadata(event *core.EventDescriptor, field string) string {
+	decoded, err := core.DecodeEvent(event.Event)
+	if err != nil {
+		return ""
+	}
+
+	value, ok := decoded.GetPath(field)
+	if !ok {
+		return ""
+	}
+
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number, bool:
+		return fmt.Sprint(value)
+	}
+
+	return ""
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"os"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+type testStream struct {
+	name string
+}
+
+func (s *testStream) Info() (string, os.FileInfo) {
+	return s.name, nil
+}
+
+func TestSplitByMetadata(t *testing.T) {
+	first, second := &testStream{"first"}, &testStream{"second"}
+
+	events := []*core.EventDescriptor{
+		{Stream: first, Offset: 1, Event: []byte(`{"pipeline":"a"}`)},
+		{Stream: second, Offset: 1, Event: []byte(`{"pipeline":"b"}`)},
+		{Stream: first, Offset: 2, Event: []byte(`{"pipeline":"a"}`)},
+		{Stream: second, Offset: 2, Event: []byte(`{"pipeline":"b"}`)},
+		{Stream: second, Offset: 3, Event: []byte(`{"message":"none"}`)},
+		{Stream: first, Offset: 3, Event: []byte(`{"pipeline":"b"}`)},
+		{Stream: first, Offset: 4, Event: []byte(`{"pipeline":"a"}`)},
+	}
+
+	spools := splitByMetadata(events, "pipeline")
+
+	// The final event of the first stream can not join the first group as it
+	// would then be acknowledged before the event preceeding it
+	expected := []struct {
+		metadata string
+		offsets  []int64
+	}{
+		{"a", []int64{1, 2}},
+		{"b", []int64{1, 2, 3}},
+		{"", []int64{3}},
+		{"a", []int64{4}},
+	}
+
+	if len(spools) != len(expected) {
+		t.Fatalf("Unexpected number of spools: %d", len(spools))
+	}
+
+	for i, spool := range spools {
+		if spool.metadata != expected[i].metadata || len(spool.events) != len(expected[i].offsets) {
+			t.Errorf("Unexpected spool %d: %q with %d events", i, spool.metadata, len(spool.events))
+			continue
+		}
+
+		for j, event := range spool.events {
+			if event.Offset != expected[i].offsets[j] {
+				t.Errorf("Unexpected event in spool %d: %d", i, event.Offset)
+			}
+		}
+	}
+}
@@ -19,6 +19,7 @@ package publisher
 import (
 	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/endpoint"
+	"github.com/driskell/log-courier/lc-lib/payload"
 )
 
 type method interface {
@@ -27,3 +28,9 @@ type method interface {
 	onStarted(*endpoint.Endpoint)
 	reloadConfig(*config.Network)
 }
+
+// methodQueuer is implemented by methods that choose the endpoint for each
+// payload themselves, rather than the sink choosing the best endpoint
+type methodQueuer interface {
+	queuePayload(*payload.Payload) (*endpoint.Endpoint, error)
+}
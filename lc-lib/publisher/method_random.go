@@ -104,6 +104,11 @@ func (m *methodRandom) connectRandom() {
 		server = m.config.Servers[0]
 		addressPool = m.config.AddressPools[0]
 		m.activeServer = 0
+	} else if healthy := m.healthyServers(); len(healthy) != 0 {
+		// Avoid servers that are evicted due to repeated failures
+		m.activeServer = healthy[m.generator.Intn(len(healthy))]
+		server = m.config.Servers[m.activeServer]
+		addressPool = m.config.AddressPools[m.activeServer]
 	} else if len(m.config.Servers) == 2 && m.activeServer != -1 {
 		m.activeServer = (m.activeServer + 1) % 2
 		server = m.config.Servers[m.activeServer]
@@ -128,6 +133,24 @@ func (m *methodRandom) connectRandom() {
 	m.sink.AddEndpoint(server, addressPool, true)
 }
 
+// healthyServers returns the indexes of servers, other than the active one,
+// that are not currently evicted. If all other servers are evicted but the
+// active one is not, the active one is returned
+func (m *methodRandom) healthyServers() []int {
+	healthy := make([]int, 0, len(m.config.Servers))
+	for n, addressPool := range m.config.AddressPools {
+		if n != m.activeServer && addressPool.IsHealthy() {
+			healthy = append(healthy, n)
+		}
+	}
+
+	if len(healthy) == 0 && m.activeServer != -1 && m.config.AddressPools[m.activeServer].IsHealthy() {
+		healthy = append(healthy, m.activeServer)
+	}
+
+	return healthy
+}
+
 func (m *methodRandom) onFail(endpoint *endpoint.Endpoint) {
 	// Should never happen - we initiate transports with finishOnFail
 	return
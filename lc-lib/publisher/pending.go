@@ -0,0 +1,208 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/payload"
+	"github.com/driskell/log-courier/lc-lib/registrar"
+)
+
+const (
+	pendingFile = ".log-courier.pending"
+)
+
+// pendingSpool is a set of unacknowledged events saved on shutdown, along with
+// the metadata value they share
+type pendingSpool struct {
+	Metadata string   `json:"metadata"`
+	Events   [][]byte `json:"events"`
+}
+
+// pendingStream is the Stream of events restored from the pending file. Once
+// the last of them is acknowledged the file is removed
+type pendingStream struct {
+	mutex sync.Mutex
+
+	file       string
+	total      int64
+	superseded bool
+}
+
+// Info returns the description of the stream
+func (s *pendingStream) Info() (string, os.FileInfo) {
+	return "pending payloads", nil
+}
+
+// Ack removes the pending file once every restored event is acknowledged. It
+// is called by the Registrar
+func (s *pendingStream) Ack(offset int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.superseded || offset < s.total {
+		return
+	}
+
+	s.superseded = true
+	if err := os.Remove(s.file); err != nil && !os.IsNotExist(err) {
+		log.Error("Failed to remove the pending payloads file: %s", err)
+	}
+}
+
+// supersede stops the stream removing the pending file, as its remaining
+// events are about to be saved again
+func (s *pendingStream) supersede() {
+	s.mutex.Lock()
+	s.superseded = true
+	s.mutex.Unlock()
+}
+
+// RestorePending enables saving of unacknowledged payloads on shutdown, and
+// loads any saved by a previous run so they are sent before any new events.
+// It must be called before Run
+func (p *Publisher) RestorePending() error {
+	p.persistPending = true
+
+	file := filepath.Join(p.general.PersistDir, pendingFile)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var spools []*pendingSpool
+	if err := json.Unmarshal(data, &spools); err != nil {
+		return fmt.Errorf("Failed to load pending payloads from %s: %s", file, err)
+	}
+
+	stream := &pendingStream{file: file}
+	for _, spool := range spools {
+		held := &heldSpool{metadata: spool.Metadata}
+		for _, event := range spool.Events {
+			stream.total++
+			held.events = append(held.events, &core.EventDescriptor{
+				Stream: stream,
+				Offset: stream.total,
+				Event:  event,
+			})
+		}
+		if len(held.events) != 0 {
+			p.nextSpools = append(p.nextSpools, held)
+		}
+	}
+
+	if stream.total == 0 {
+		return os.Remove(file)
+	}
+
+	p.restored = stream
+
+	log.Notice("Restored %d unacknowledged events from the pending payloads file", stream.total)
+
+	return nil
+}
+
+// savePending saves the events not yet acknowledged, in the order they were
+// due to be sent, so they are sent first when next started. Once saved they
+// are acknowledged to the Registrar so they are not read again from their
+// source, which keeps the saved offsets consistent
+func (p *Publisher) savePending() {
+	var spools []*pendingSpool
+	var payloads []*payload.Payload
+	total := 0
+
+	for element := p.payloadList.Front(); element != nil; element = element.Next() {
+		pendingPayload := element.Value.(*payload.Payload)
+		payloads = append(payloads, pendingPayload)
+
+		// Canaries are synthetic and are never worth sending again
+		if _, ok := p.canaries[pendingPayload]; ok {
+			continue
+		}
+
+		spool := &pendingSpool{Metadata: pendingPayload.Metadata}
+		for _, event := range pendingPayload.Events() {
+			spool.Events = append(spool.Events, event.Event)
+		}
+		if len(spool.Events) != 0 {
+			spools = append(spools, spool)
+			total += len(spool.Events)
+		}
+	}
+
+	for _, held := range p.shutdownSpools {
+		spool := &pendingSpool{Metadata: held.metadata}
+		for _, event := range held.events {
+			spool.Events = append(spool.Events, event.Event)
+		}
+		spools = append(spools, spool)
+		total += len(spool.Events)
+	}
+
+	if total == 0 {
+		return
+	}
+
+	// Any restored events still outstanding are included in the new file
+	if p.restored != nil {
+		p.restored.supersede()
+	}
+
+	if err := p.writePending(spools); err != nil {
+		log.Error("Failed to save %d unacknowledged events to the pending payloads file: %s", total, err)
+		return
+	}
+
+	for _, pendingPayload := range payloads {
+		pendingPayload.Ack(pendingPayload.Size())
+		p.registrarSpool.Add(registrar.NewAckEvent(pendingPayload.Rollup()))
+	}
+	for _, held := range p.shutdownSpools {
+		p.registrarSpool.Add(registrar.NewAckEvent(held.events))
+	}
+	p.registrarSpool.Send()
+
+	p.shutdownSpools = nil
+
+	log.Notice("Saved %d unacknowledged events to the pending payloads file", total)
+}
+
+// writePending writes the pending payloads file, replacing it atomically
+func (p *Publisher) writePending(spools []*pendingSpool) error {
+	data, err := json.Marshal(spools)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(p.general.PersistDir, pendingFile)
+	tname := name + ".new"
+	if err := ioutil.WriteFile(tname, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tname, name)
+}
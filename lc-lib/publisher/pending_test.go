@@ -0,0 +1,205 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/payload"
+	"github.com/driskell/log-courier/lc-lib/registrar"
+)
+
+// collectingEventSpool collects the acknowledgements sent to the registrar
+type collectingEventSpool struct {
+	events []registrar.EventProcessor
+	sent   []registrar.EventProcessor
+}
+
+func (s *collectingEventSpool) Close() {
+}
+
+func (s *collectingEventSpool) Add(event registrar.EventProcessor) {
+	s.events = append(s.events, event)
+}
+
+func (s *collectingEventSpool) Send() {
+	s.sent = append(s.sent, s.events...)
+	s.events = nil
+}
+
+// ackedOffsets processes the sent acknowledgements and returns the resulting
+// offset of each stream
+func (s *collectingEventSpool) ackedOffsets(streams ...core.Stream) map[core.Stream]int64 {
+	state := make(map[core.Stream]*registrar.FileState)
+	for _, stream := range streams {
+		state[stream] = &registrar.FileState{}
+	}
+
+	for _, event := range s.sent {
+		event.(*registrar.AckEvent).Process(state)
+	}
+
+	offsets := make(map[core.Stream]int64)
+	for stream, fileState := range state {
+		offsets[stream] = fileState.Offset
+	}
+	return offsets
+}
+
+func createPendingPublisher(dir string) (*Publisher, *collectingEventSpool) {
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	cfg.General.PersistDir = dir
+
+	spool := &collectingEventSpool{}
+	return &Publisher{
+		general:        &cfg.General,
+		registrarSpool: spool,
+		canaries:       make(map[*payload.Payload]*canary),
+	}, spool
+}
+
+func testEvents(stream core.Stream, lines ...string) []*core.EventDescriptor {
+	events := make([]*core.EventDescriptor, len(lines))
+	for i, line := range lines {
+		events[i] = &core.EventDescriptor{Stream: stream, Offset: int64(i + 1), Event: []byte(line)}
+	}
+	return events
+}
+
+func TestPendingSaveAndRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pending")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := &testStream{"file"}
+	events := testEvents(file, `{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`, `{"n":5}`)
+
+	p, spool := createPendingPublisher(dir)
+	if err := p.RestorePending(); err != nil {
+		t.Fatalf("Unexpected error restoring with no pending file: %s", err)
+	}
+
+	// First payload has its first event acknowledged, the rest are held
+	sent := payload.NewPayload(events[:3])
+	sent.Metadata = "a"
+	sent.Ack(1)
+	spool.Add(registrar.NewAckEvent(sent.Rollup()))
+	spool.Send()
+	p.payloadList.PushBack(&sent.Element)
+
+	canaryPayload := payload.NewPayload([]*core.EventDescriptor{{Stream: &canaryStream{}, Event: []byte(`{}`)}})
+	p.payloadList.PushBack(&canaryPayload.Element)
+	p.canaries[canaryPayload] = &canary{}
+
+	p.shutdownSpools = []*heldSpool{{events: events[3:], metadata: "b"}}
+
+	p.savePending()
+
+	if offset := spool.ackedOffsets(file)[file]; offset != 5 {
+		t.Errorf("Registrar offset after saving is %d, expected 5", offset)
+	}
+
+	restored, restoredSpool := createPendingPublisher(dir)
+	if err := restored.RestorePending(); err != nil {
+		t.Fatalf("Unexpected error restoring: %s", err)
+	}
+
+	if len(restored.nextSpools) != 2 {
+		t.Fatalf("Restored %d spools, expected 2", len(restored.nextSpools))
+	}
+
+	expected := []struct {
+		metadata string
+		events   []string
+	}{
+		{"a", []string{`{"n":2}`, `{"n":3}`}},
+		{"b", []string{`{"n":4}`, `{"n":5}`}},
+	}
+	for i, held := range restored.nextSpools {
+		if held.metadata != expected[i].metadata {
+			t.Errorf("Restored spool %d has metadata %q, expected %q", i, held.metadata, expected[i].metadata)
+		}
+		if len(held.events) != len(expected[i].events) {
+			t.Fatalf("Restored spool %d has %d events, expected %d", i, len(held.events), len(expected[i].events))
+		}
+		for j, event := range held.events {
+			if string(event.Event) != expected[i].events[j] {
+				t.Errorf("Restored spool %d event %d is %s, expected %s", i, j, event.Event, expected[i].events[j])
+			}
+		}
+	}
+
+	// The file remains until every restored event is acknowledged
+	restoredSpool.Add(registrar.NewAckEvent(restored.nextSpools[0].events))
+	restoredSpool.Send()
+	restoredSpool.ackedOffsets()
+	if _, err := os.Stat(filepath.Join(dir, pendingFile)); err != nil {
+		t.Errorf("Pending file removed before all events were acknowledged: %s", err)
+	}
+
+	restoredSpool.sent = nil
+	restoredSpool.Add(registrar.NewAckEvent(restored.nextSpools[1].events))
+	restoredSpool.Send()
+	restoredSpool.ackedOffsets()
+	if _, err := os.Stat(filepath.Join(dir, pendingFile)); !os.IsNotExist(err) {
+		t.Errorf("Pending file not removed after all events were acknowledged: %v", err)
+	}
+}
+
+func TestPendingResaveRestored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pending")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, _ := createPendingPublisher(dir)
+	p.shutdownSpools = []*heldSpool{{events: testEvents(&testStream{"file"}, `{"n":1}`, `{"n":2}`)}}
+	p.savePending()
+
+	restored, restoredSpool := createPendingPublisher(dir)
+	if err := restored.RestorePending(); err != nil {
+		t.Fatalf("Unexpected error restoring: %s", err)
+	}
+
+	// Shut down again before anything was sent, the restored events are saved
+	// again and their acknowledgement must not remove the new file
+	restored.shutdownSpools = restored.nextSpools
+	restored.nextSpools = nil
+	restored.savePending()
+	restoredSpool.ackedOffsets()
+
+	if _, err := os.Stat(filepath.Join(dir, pendingFile)); err != nil {
+		t.Fatalf("Pending file removed after saving restored events again: %s", err)
+	}
+
+	again, _ := createPendingPublisher(dir)
+	if err := again.RestorePending(); err != nil {
+		t.Fatalf("Unexpected error restoring: %s", err)
+	}
+	if len(again.nextSpools) != 1 || len(again.nextSpools[0].events) != 2 {
+		t.Errorf("Restored events were not saved again")
+	}
+}
@@ -63,37 +63,54 @@ type Publisher struct {
 	mutex sync.RWMutex
 
 	config       *config.Network
+	general      *config.General
+	host         string
 	adminConfig  *admin.Config
 	endpointSink *endpoint.Sink
 	method       method
 
 	payloadList    internallist.List
 	numPayloads    int64
+	numEvents      int64
+	backlogPaused  bool
 	outOfSync      int
 	spoolChan      chan []*core.EventDescriptor
 	registrarSpool registrar.EventSpooler
 	shuttingDown   bool
+	persistPending bool
+	restored       *pendingStream
+	shutdownSpools []*heldSpool
 
 	lineCount       int64
 	lineSpeed       float64
 	lastLineCount   int64
 	lastMeasurement time.Time
 	secondsNoAck    int
+	rateAlert       rateAlert
 
 	measurementTimer *time.Timer
 	onShutdown       <-chan interface{}
+	shutdownTimeout  <-chan time.Time
 	ifSpoolChan      <-chan []*core.EventDescriptor
-	nextSpool        []*core.EventDescriptor
+	nextSpools       []*heldSpool
 	resendList       internallist.List
+	canaries         map[*payload.Payload]*canary
+	snapshotChan     chan chan *Snapshot
+	exitChan         chan struct{}
 }
 
 // NewPublisher creates a new publisher instance on the given pipeline
 func NewPublisher(pipeline *core.Pipeline, config *config.Config, registrar registrar.Registrator) *Publisher {
 	ret := &Publisher{
 		config:       &config.Network,
+		general:      &config.General,
+		host:         config.General.Host,
 		adminConfig:  config.Get("admin").(*admin.Config),
 		spoolChan:    make(chan []*core.EventDescriptor, 1),
 		endpointSink: endpoint.NewSink(&config.Network),
+		canaries:     make(map[*payload.Payload]*canary),
+		snapshotChan: make(chan chan *Snapshot),
+		exitChan:     make(chan struct{}),
 	}
 
 	ret.initAPI()
@@ -124,6 +141,9 @@ func (p *Publisher) initMethod() {
 	case "loadbalance":
 		p.method = newMethodLoadbalance(p.endpointSink, p.config)
 		return
+	case "roundrobin":
+		p.method = newMethodRoundrobin(p.endpointSink, p.config)
+		return
 	}
 
 	panic(fmt.Sprintf("Internal error: Unknown publishing method: %s", p.config.Method))
@@ -140,7 +160,11 @@ func (p *Publisher) Connect() chan<- []*core.EventDescriptor {
 func (p *Publisher) Run() {
 	p.measurementTimer = time.NewTimer(time.Second)
 	p.onShutdown = p.OnShutdown()
-	p.ifSpoolChan = p.spoolChan
+
+	// Restored pending payloads are sent before any new spools are taken
+	if len(p.nextSpools) == 0 {
+		p.ifSpoolChan = p.spoolChan
+	}
 
 	for {
 		if p.runOnce() {
@@ -148,8 +172,15 @@ func (p *Publisher) Run() {
 		}
 	}
 
+	if p.persistPending {
+		p.savePending()
+	}
+
 	p.registrarSpool.Close()
 
+	// Fail any further snapshot requests now the loop has stopped
+	close(p.exitChan)
+
 	log.Info("Publisher exiting")
 
 	p.Done()
@@ -170,21 +201,11 @@ func (p *Publisher) runOnce() bool {
 			return true
 		}
 	case spool := <-p.ifSpoolChan:
-		if p.numPayloads >= p.config.MaxPendingPayloads {
-			log.Debug("Maximum pending payloads of %d reached, holding %d new events", p.config.MaxPendingPayloads, len(spool))
-		} else if p.resendList.Len() != 0 {
-			log.Debug("Holding %d new events until the resend queue is flushed", len(spool))
-		} else if p.endpointSink.CanQueue() {
-			if _, ok := p.sendEvents(spool); ok {
-				break
-			}
-
-			log.Debug("Holding %d new events until an endpoint is ready", len(spool))
-		}
-
-		// No ready endpoint, wait for one
-		p.nextSpool = spool
+		// Hold the spool, split by metadata, and stop taking new spools until
+		// every part of it has been queued
+		p.nextSpools = p.splitSpool(spool)
 		p.ifSpoolChan = nil
+		p.queueSpools()
 	case <-p.endpointSink.TimeoutChan():
 		// Process triggered timeouts
 		p.endpointSink.ProcessTimeouts()
@@ -193,10 +214,15 @@ func (p *Publisher) runOnce() bool {
 		p.measurementTimer.Reset(time.Second)
 	case config := <-p.OnConfig():
 		p.reloadConfig(config)
+	case reply := <-p.snapshotChan:
+		reply <- p.snapshot(time.Now())
 	case <-p.onShutdown:
 		p.onShutdown = nil
 		p.ifSpoolChan = nil
-		p.nextSpool = nil
+		if p.persistPending {
+			p.shutdownSpools = p.nextSpools
+		}
+		p.nextSpools = nil
 		p.shuttingDown = true
 
 		// If no payloads held, nothing to wait for
@@ -207,6 +233,16 @@ func (p *Publisher) runOnce() bool {
 			}
 			p.endpointSink.Shutdown()
 		}
+
+		if p.config.ShutdownTimeout != 0 {
+			p.shutdownTimeout = time.After(p.config.ShutdownTimeout)
+		}
+	case <-p.shutdownTimeout:
+		// Events not yet acknowledged have not been saved by the registrar, so
+		// are sent again when next started, either from their source or from the
+		// pending payloads file if enabled
+		log.Warning("Shutdown timeout reached, abandoning %d events that have not been acknowledged", p.numEvents)
+		return true
 	}
 
 	return false
@@ -215,6 +251,8 @@ func (p *Publisher) runOnce() bool {
 func (p *Publisher) reloadConfig(config *config.Config) {
 	oldMethod := p.config.Method
 	p.config = &config.Network
+	p.general = &config.General
+	p.host = config.General.Host
 
 	// Give sink the new config
 	p.endpointSink.ReloadConfig(&config.Network)
@@ -240,6 +278,10 @@ func (p *Publisher) OnStarted(endpoint *endpoint.Endpoint) {
 		return
 	}
 
+	if p.config.Canary && !p.shuttingDown && p.sendCanary(endpoint) {
+		return
+	}
+
 	if p.tryQueueHeld() {
 		return
 	}
@@ -285,7 +327,10 @@ func (p *Publisher) OnFail(endpoint *endpoint.Endpoint) {
 // publisher for redelivery
 func (p *Publisher) pullBackPending(endpoint *endpoint.Endpoint) {
 	// Pull back pending payloads so we can requeue them onto other endpoints
-	for _, pendingPayload := range endpoint.PullBackPending() {
+	pulledBack := endpoint.PullBackPending()
+	p.failCanaries(endpoint, pulledBack)
+
+	for _, pendingPayload := range pulledBack {
 		pendingPayload.Resending = true
 		pendingPayload.ResetSequence()
 		p.resendList.PushBack(&pendingPayload.ResendElement)
@@ -307,7 +352,7 @@ func (p *Publisher) OnAck(endpoint *endpoint.Endpoint, pendingPayload *payload.P
 	if endpoint.NumPending() > 0 {
 		p.endpointSink.RegisterTimeout(
 			&endpoint.Timeout,
-			p.config.Timeout,
+			endpoint.NetworkTimeout(),
 			func() {
 				p.timeoutPending(endpoint)
 			},
@@ -333,6 +378,12 @@ func (p *Publisher) OnAck(endpoint *endpoint.Endpoint, pendingPayload *payload.P
 		p.resendList.Remove(&pendingPayload.ResendElement)
 	}
 
+	// Canary events are not included in the published line count
+	publishedLines := int64(lineCount)
+	if complete && p.ackCanary(endpoint, pendingPayload) {
+		publishedLines--
+	}
+
 	numComplete := int64(0)
 
 	// We potentially receive out-of-order ACKs due to payloads distributed across servers
@@ -377,7 +428,8 @@ func (p *Publisher) OnAck(endpoint *endpoint.Endpoint, pendingPayload *payload.P
 	if numComplete != 0 {
 		p.numPayloads -= numComplete
 	}
-	p.lineCount += int64(lineCount)
+	p.numEvents -= int64(lineCount)
+	p.lineCount += publishedLines
 	p.mutex.Unlock()
 
 	if complete {
@@ -415,7 +467,7 @@ func (p *Publisher) forceEndpointFailure(endpoint *endpoint.Endpoint, err error)
 
 // eventsHeld returns true if there are events held waiting to be queued
 func (p *Publisher) eventsHeld() bool {
-	return p.resendList.Len() > 0 || p.nextSpool != nil
+	return p.resendList.Len() > 0 || len(p.nextSpools) != 0
 }
 
 // tryQueueHeld attempts to queue held payloads
@@ -443,26 +495,98 @@ func (p *Publisher) tryQueueHeld() bool {
 		return didSend
 	}
 
-	// Only take from nextSpool if we have space below the limit
-	if p.numPayloads < p.config.MaxPendingPayloads && p.nextSpool != nil {
-		// We have events, send it to the endpoint and wait for more
-		if _, ok := p.sendEvents(p.nextSpool); ok {
-			p.nextSpool = nil
-			p.ifSpoolChan = p.spoolChan
-			return true
+	return p.queueSpools()
+}
+
+// queueSpools queues the held spools in order until one can not be queued,
+// returning true if any were queued. Once all are queued the publisher resumes
+// taking new spools
+func (p *Publisher) queueSpools() bool {
+	queued := false
+
+	for len(p.nextSpools) != 0 {
+		spool := p.nextSpools[0]
+
+		if p.numPayloads >= p.config.MaxPendingPayloads {
+			log.Debug("Maximum pending payloads of %d reached, holding %d new events", p.config.MaxPendingPayloads, len(spool.events))
+			break
+		} else if p.backlogFull(spool.events) {
+			p.pauseBacklog(len(spool.events))
+			break
+		} else if p.resendList.Len() != 0 {
+			log.Debug("Holding %d new events until the resend queue is flushed", len(spool.events))
+			break
+		} else if !p.endpointSink.CanQueue() {
+			log.Debug("Holding %d new events until an endpoint is ready", len(spool.events))
+			break
+		}
+
+		if _, ok := p.sendEvents(spool.events, spool.metadata); !ok {
+			log.Debug("Holding %d new events until an endpoint is ready", len(spool.events))
+			break
 		}
+
+		p.nextSpools = p.nextSpools[1:]
+		queued = true
 	}
 
-	return false
+	if len(p.nextSpools) == 0 {
+		p.nextSpools = nil
+		p.ifSpoolChan = p.spoolChan
+		p.resumeBacklog()
+	}
+
+	return queued
+}
+
+// backlogFull returns true if sending the given spool would take the number of
+// pending events over the configured maximum. A spool is always allowed when
+// there are no pending events so that a spool larger than the maximum can
+// still be sent
+func (p *Publisher) backlogFull(spool []*core.EventDescriptor) bool {
+	if p.config.MaxPendingEvents == 0 || p.numEvents == 0 {
+		return false
+	}
+
+	return p.numEvents+int64(len(spool)) > p.config.MaxPendingEvents
+}
+
+// pauseBacklog records that new events are being held due to the maximum
+// pending events being reached. While held, the spooler and harvesters will
+// block, pausing harvesting until the backlog drains
+func (p *Publisher) pauseBacklog(held int) {
+	if !p.backlogPaused {
+		log.Warning("Maximum pending events of %d reached, pausing harvesting until the backlog drains", p.config.MaxPendingEvents)
+		p.mutex.Lock()
+		p.backlogPaused = true
+		p.mutex.Unlock()
+	}
+
+	log.Debug("Holding %d new events until the backlog drains (%d pending)", held, p.numEvents)
 }
 
-func (p *Publisher) sendEvents(events []*core.EventDescriptor) (*endpoint.Endpoint, bool) {
+// resumeBacklog records that events are no longer held due to the maximum
+// pending events
+func (p *Publisher) resumeBacklog() {
+	if !p.backlogPaused {
+		return
+	}
+
+	log.Notice("Pending events backlog has drained to %d, resuming harvesting", p.numEvents)
+	p.mutex.Lock()
+	p.backlogPaused = false
+	p.mutex.Unlock()
+}
+
+func (p *Publisher) sendEvents(events []*core.EventDescriptor, metadata string) (*endpoint.Endpoint, bool) {
 	pendingPayload := payload.NewPayload(events)
+	pendingPayload.Metadata = metadata
 
 	p.payloadList.PushBack(&pendingPayload.Element)
 
 	p.mutex.Lock()
 	p.numPayloads++
+	p.numEvents += int64(len(events))
 	p.mutex.Unlock()
 
 	return p.sendPayload(pendingPayload)
@@ -470,7 +594,7 @@ func (p *Publisher) sendEvents(events []*core.EventDescriptor) (*endpoint.Endpoi
 
 func (p *Publisher) sendPayload(pendingPayload *payload.Payload) (*endpoint.Endpoint, bool) {
 	// Attempt to queue the payload with the best endpoint
-	endpoint, err := p.endpointSink.QueuePayload(pendingPayload)
+	endpoint, err := p.queuePayload(pendingPayload)
 	if err != nil {
 		p.forceEndpointFailure(endpoint, err)
 		return nil, false
@@ -480,7 +604,7 @@ func (p *Publisher) sendPayload(pendingPayload *payload.Payload) (*endpoint.Endp
 	if endpoint.NumPending() == 1 {
 		p.endpointSink.RegisterTimeout(
 			&endpoint.Timeout,
-			p.config.Timeout,
+			endpoint.NetworkTimeout(),
 			func() {
 				p.timeoutPending(endpoint)
 			},
@@ -490,6 +614,16 @@ func (p *Publisher) sendPayload(pendingPayload *payload.Payload) (*endpoint.Endp
 	return endpoint, true
 }
 
+// queuePayload queues the payload on the endpoint chosen by the method, or the
+// best endpoint if the method does not choose
+func (p *Publisher) queuePayload(pendingPayload *payload.Payload) (*endpoint.Endpoint, error) {
+	if queuer, ok := p.method.(methodQueuer); ok {
+		return queuer.queuePayload(pendingPayload)
+	}
+
+	return p.endpointSink.QueuePayload(pendingPayload)
+}
+
 func (p *Publisher) timeoutPending(endpoint *endpoint.Endpoint) {
 	// Trigger a failure
 	if endpoint.IsPinging() {
@@ -504,7 +638,7 @@ func (p *Publisher) timeoutKeepalive(endpoint *endpoint.Endpoint) {
 	log.Debug("[%s] Sending PING and starting pending timeout", endpoint.Server())
 	p.endpointSink.RegisterTimeout(
 		&endpoint.Timeout,
-		p.config.Timeout,
+		endpoint.NetworkTimeout(),
 		func() {
 			p.timeoutPending(endpoint)
 		},
@@ -521,18 +655,21 @@ func (p *Publisher) takeMeasurements() {
 	p.lastLineCount = p.lineCount
 	p.lastMeasurement = time.Now()
 	p.mutex.Unlock()
+
+	p.checkRateAlert()
 }
 
 // initAPI initialises the publisher API entries
 func (p *Publisher) initAPI() {
 	// Is admin loaded into the pipeline?
-	if !p.adminConfig.Enabled {
+	if !p.adminConfig.APIEnabled() {
 		return
 	}
 
 	publisherAPI := &admin.APINode{}
 	publisherAPI.SetEntry("endpoints", p.endpointSink.APINavigatable())
 	publisherAPI.SetEntry("status", &apiStatus{p: p})
+	publisherAPI.SetEntry("snapshot", &apiSnapshot{p: p})
 
 	p.adminConfig.SetEntry("publisher", publisherAPI)
 }
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/endpoint"
+)
+
+// createShuttingDownPublisher returns a publisher that has been asked to shut
+// down while a payload is still waiting to be acknowledged
+func createShuttingDownPublisher(t *testing.T, timeout time.Duration) *Publisher {
+	cfg := config.NewConfig()
+	cfg.Network.InitDefaults()
+	cfg.Network.ShutdownTimeout = timeout
+
+	onShutdown := make(chan interface{})
+	close(onShutdown)
+
+	p := &Publisher{
+		config:           &cfg.Network,
+		endpointSink:     endpoint.NewSink(&cfg.Network),
+		measurementTimer: time.NewTimer(time.Hour),
+		onShutdown:       onShutdown,
+		numPayloads:      1,
+		numEvents:        5,
+	}
+
+	if p.runOnce() {
+		t.Fatal("Publisher finished with a payload pending")
+	}
+
+	return p
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	p := createShuttingDownPublisher(t, 50*time.Millisecond)
+
+	finished := make(chan bool, 1)
+	go func() {
+		finished <- p.runOnce()
+	}()
+
+	select {
+	case result := <-finished:
+		if !result {
+			t.Error("Publisher did not finish after the shutdown timeout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publisher did not finish after the shutdown timeout")
+	}
+}
+
+func TestShutdownWithoutTimeout(t *testing.T) {
+	p := createShuttingDownPublisher(t, 0)
+
+	// Without a timeout the publisher waits for the pending payload
+	finished := make(chan bool, 1)
+	go func() {
+		finished <- p.runOnce()
+	}()
+
+	select {
+	case <-finished:
+		t.Error("Publisher finished with a payload pending")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
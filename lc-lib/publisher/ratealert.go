@@ -0,0 +1,142 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+// rateAlert tracks how long the outbound event rate has been above the rate
+// alert threshold, so an alert is raised only when it is sustained, and no
+// more often than the cooldown allows
+type rateAlert struct {
+	aboveSince time.Time
+	lastAlert  time.Time
+	alerts     int64
+
+	// Accessed atomically, non-zero while the command is running
+	running int32
+}
+
+// Update records the current rate at the given time, and returns true if an
+// alert should now be raised
+func (a *rateAlert) Update(now time.Time, rate float64, general *config.General) bool {
+	if general.RateThreshold == 0 || rate < float64(general.RateThreshold) {
+		a.aboveSince = time.Time{}
+		return false
+	}
+
+	if a.aboveSince.IsZero() {
+		a.aboveSince = now
+	}
+
+	if now.Sub(a.aboveSince) < general.RateDuration {
+		return false
+	}
+
+	if !a.lastAlert.IsZero() && now.Sub(a.lastAlert) < general.RateCooldown {
+		return false
+	}
+
+	a.lastAlert = now
+	a.alerts++
+	return true
+}
+
+// checkRateAlert raises an alert if the outbound event rate has been above the
+// rate alert threshold for the rate alert duration
+func (p *Publisher) checkRateAlert() {
+	general := p.general
+
+	p.mutex.Lock()
+	rate := p.lineSpeed
+	alert := p.rateAlert.Update(time.Now(), rate, general)
+	p.mutex.Unlock()
+
+	if !alert {
+		return
+	}
+
+	log.Warning("Outbound event rate of %.0f events/s has been above %d events/s for %v", rate, general.RateThreshold, general.RateDuration)
+
+	if len(general.RateCommand) == 0 {
+		return
+	}
+
+	// Never run more than one command at a time, so a command that hangs until
+	// its timeout can not cause them to pile up
+	if !atomic.CompareAndSwapInt32(&p.rateAlert.running, 0, 1) {
+		log.Warning("Rate alert command is still running from a previous alert, not running it again")
+		return
+	}
+
+	// Run the command in the background so it can never hold up the publisher
+	go func() {
+		defer atomic.StoreInt32(&p.rateAlert.running, 0)
+		runRateAlertCommand(general.RateCommand, general.RateTimeout, rate, general.RateThreshold, general.RateDuration)
+	}()
+}
+
+// runRateAlertCommand runs the rate alert command, passing the rate
+// information in the environment and as JSON on stdin. The command receives
+// only a minimal environment and is killed if it does not complete within the
+// timeout
+func runRateAlertCommand(command []string, timeout time.Duration, rate float64, threshold int64, duration time.Duration) {
+	input, err := json.Marshal(map[string]interface{}{
+		"rate":      rate,
+		"threshold": threshold,
+		"duration":  duration.String(),
+	})
+	if err != nil {
+		log.Error("Rate alert command failed: %s", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = os.TempDir()
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		fmt.Sprintf("LOG_COURIER_RATE=%.0f", rate),
+		fmt.Sprintf("LOG_COURIER_THRESHOLD=%d", threshold),
+		"LOG_COURIER_DURATION=" + duration.String(),
+	}
+	cmd.Stdin = bytes.NewReader(input)
+
+	// Do not wait indefinitely for any processes the command started that are
+	// still holding its output open after it is killed
+	cmd.WaitDelay = time.Second
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			log.Error("Rate alert command did not complete within %v and was killed: %s", timeout, output)
+			return
+		}
+		log.Error("Rate alert command failed: %s: %s", err, output)
+	}
+}
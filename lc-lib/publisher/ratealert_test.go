@@ -0,0 +1,76 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+func TestRateAlert(t *testing.T) {
+	general := &config.General{
+		RateCooldown:  10 * time.Minute,
+		RateDuration:  time.Minute,
+		RateThreshold: 1000,
+	}
+
+	var alert rateAlert
+	start := time.Now()
+
+	if alert.Update(start, 5000, general) {
+		t.Error("Alert raised before the rate was sustained")
+	}
+	if !alert.Update(start.Add(time.Minute), 5000, general) {
+		t.Error("Alert not raised when the rate was sustained")
+	}
+
+	// Cooldown prevents repeated alerts
+	if alert.Update(start.Add(2*time.Minute), 5000, general) {
+		t.Error("Alert raised again within the cooldown")
+	}
+	if !alert.Update(start.Add(11*time.Minute), 5000, general) {
+		t.Error("Alert not raised again after the cooldown")
+	}
+
+	// Dropping below the threshold restarts the duration
+	alert.Update(start.Add(30*time.Minute), 500, general)
+	if alert.Update(start.Add(30*time.Minute+time.Second), 5000, general) {
+		t.Error("Alert raised before the rate was sustained again")
+	}
+
+	if alert.alerts != 2 {
+		t.Errorf("Unexpected alert count: %d", alert.alerts)
+	}
+}
+
+func TestRateAlertDisabled(t *testing.T) {
+	var alert rateAlert
+	if alert.Update(time.Now(), 1e9, &config.General{}) {
+		t.Error("Alert raised with no threshold")
+	}
+}
+
+func TestRateAlertCommandTimeout(t *testing.T) {
+	start := time.Now()
+	runRateAlertCommand([]string{"sleep", "10"}, 100*time.Millisecond, 5000, 1000, time.Minute)
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Command was not killed at the timeout: ran for %v", elapsed)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"errors"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/payload"
+)
+
+var (
+	errPublisherStopped = errors.New("Publisher is not running")
+)
+
+// Snapshot holds the state of the payloads pending acknowledgement at a point
+// in time
+type Snapshot struct {
+	// PendingPayloads is the number of payloads not yet fully acknowledged
+	PendingPayloads int64
+	// PendingEvents is the number of events within those payloads that are not
+	// yet acknowledged
+	PendingEvents int64
+	// ResendPayloads is the number of payloads held waiting to be resent
+	ResendPayloads int
+	// OutOfSync is the number of payloads that have received acknowledgements
+	// before an older payload has been fully acknowledged
+	OutOfSync int
+	// OldestPayloadAge is how long ago the oldest pending payload was created,
+	// or zero if there are none
+	OldestPayloadAge time.Duration
+}
+
+// Snapshot returns the current state of the payloads pending acknowledgement.
+// The state is collected by the publisher routine so it is consistent, and an
+// error is returned if the publisher is no longer running
+func (p *Publisher) Snapshot() (*Snapshot, error) {
+	reply := make(chan *Snapshot, 1)
+
+	select {
+	case p.snapshotChan <- reply:
+	case <-p.exitChan:
+		return nil, errPublisherStopped
+	}
+
+	return <-reply, nil
+}
+
+// snapshot collects the current state, and must only be called from the
+// publisher routine
+func (p *Publisher) snapshot(now time.Time) *Snapshot {
+	ret := &Snapshot{
+		ResendPayloads: p.resendList.Len(),
+		OutOfSync:      p.outOfSync,
+	}
+
+	p.mutex.RLock()
+	ret.PendingPayloads = p.numPayloads
+	ret.PendingEvents = p.numEvents
+	p.mutex.RUnlock()
+
+	if p.payloadList.Len() != 0 {
+		oldest := p.payloadList.Front().Value.(*payload.Payload)
+		ret.OldestPayloadAge = now.Sub(oldest.Created)
+	}
+
+	return ret
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package publisher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/payload"
+)
+
+func TestSnapshot(t *testing.T) {
+	p := &Publisher{}
+
+	if snapshot := p.snapshot(time.Now()); snapshot.PendingPayloads != 0 || snapshot.OldestPayloadAge != 0 {
+		t.Errorf("Unexpected snapshot with no payloads: %+v", snapshot)
+	}
+
+	now := time.Now()
+	for i, age := range []time.Duration{30 * time.Second, 10 * time.Second} {
+		pendingPayload := payload.NewPayload(make([]*core.EventDescriptor, i+1))
+		pendingPayload.Created = now.Add(-age)
+		p.payloadList.PushBack(&pendingPayload.Element)
+		p.numPayloads++
+		p.numEvents += int64(i + 1)
+	}
+	p.outOfSync = 1
+
+	snapshot := p.snapshot(now)
+	if snapshot.PendingPayloads != 2 {
+		t.Errorf("Unexpected pending payloads: %d", snapshot.PendingPayloads)
+	}
+	if snapshot.PendingEvents != 3 {
+		t.Errorf("Unexpected pending events: %d", snapshot.PendingEvents)
+	}
+	if snapshot.OutOfSync != 1 {
+		t.Errorf("Unexpected out of sync count: %d", snapshot.OutOfSync)
+	}
+	if snapshot.OldestPayloadAge != 30*time.Second {
+		t.Errorf("Unexpected oldest payload age: %v", snapshot.OldestPayloadAge)
+	}
+}
+
+func TestSnapshotStopped(t *testing.T) {
+	p := &Publisher{
+		snapshotChan: make(chan chan *Snapshot),
+		exitChan:     make(chan struct{}),
+	}
+
+	close(p.exitChan)
+
+	if _, err := p.Snapshot(); err != errPublisherStopped {
+		t.Errorf("Unexpected error from a stopped publisher: %v", err)
+	}
+}
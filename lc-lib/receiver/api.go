@@ -0,0 +1,51 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package receiver
+
+import (
+	"github.com/driskell/log-courier/lc-lib/admin"
+)
+
+type apiStatus struct {
+	admin.APIKeyValue
+
+	r *Receiver
+}
+
+// Update updates the receiver status information
+func (a *apiStatus) Update() error {
+	// Update the values and pass through to node
+	a.r.mutex.Lock()
+	a.SetEntry("rejectedConnections", admin.APINumber(a.r.rejected))
+	a.r.mutex.Unlock()
+
+	return nil
+}
+
+// initAPI sets up admin connectivity
+func (r *Receiver) initAPI() {
+	// Is admin loaded into the pipeline?
+	if !r.adminConfig.APIEnabled() {
+		return
+	}
+
+	receiverAPI := &admin.APINode{}
+	receiverAPI.SetEntry("status", &apiStatus{r: r})
+
+	// Receivers can not share a listen address so the first identifies it
+	r.adminConfig.SetEntry("receiver "+r.receiverConfig.Listen[0], receiverAPI)
+}
@@ -0,0 +1,519 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package receiver
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/audit"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/processors"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+const (
+	// maxMessageSize is the largest message accepted from a remote
+	maxMessageSize = 10485760
+
+	// keepaliveInterval is how often outstanding payloads are acknowledged
+	// again, so the remote does not time out while the network servers are slow
+	keepaliveInterval = 5 * time.Second
+
+	// streamCompressionZlib is the only stream compression supported
+	streamCompressionZlib = "zlib"
+)
+
+// connection handles a single remote connected to a Receiver
+type connection struct {
+	receiver   *Receiver
+	socket     net.Conn
+	reader     io.Reader
+	remote     string
+	processors []processors.Processor
+	sendChan   chan []byte
+	closed     chan struct{}
+
+	mutex   sync.Mutex
+	pending map[*payloadStream]struct{}
+
+	// Metadata from a META message, which applies to the next payload
+	metadata string
+
+	// Set once stream compression is negotiated, after which all writes must be
+	// compressed
+	writeMutex sync.Mutex
+	compressor *zlib.Writer
+}
+
+// payloadStream is the stream for the events of a single payload, allowing the
+// acknowledgements from the network servers to be returned to the remote
+type payloadStream struct {
+	conn    *connection
+	framing transports.Framing
+	nonce   string
+	length  uint64
+
+	// Protected by the connection mutex
+	sequence uint64
+}
+
+// Info returns the remote address as the path of the stream
+func (s *payloadStream) Info() (string, os.FileInfo) {
+	return s.conn.remote, nil
+}
+
+// Ack acknowledges the events in the payload up to and including the given
+// offset to the remote
+func (s *payloadStream) Ack(offset int64) {
+	s.conn.mutex.Lock()
+	s.sequence = uint64(offset)
+	if s.sequence >= s.length {
+		delete(s.conn.pending, s)
+	}
+	message := s.framing.EncodeAck(s.nonce, s.sequence)
+	s.conn.mutex.Unlock()
+
+	s.conn.send(message)
+}
+
+// newConnection creates a new connection handler for an accepted socket
+func newConnection(receiver *Receiver, socket net.Conn) *connection {
+	stubs := receiver.currentProcessors()
+	ret := &connection{
+		receiver:   receiver,
+		socket:     socket,
+		reader:     socket,
+		remote:     socket.RemoteAddr().String(),
+		processors: make([]processors.Processor, len(stubs)),
+		sendChan:   make(chan []byte, 16),
+		closed:     make(chan struct{}),
+		pending:    make(map[*payloadStream]struct{}),
+	}
+
+	for i := range stubs {
+		ret.processors[i] = processors.NewProcessor(stubs[i].Factory)
+	}
+
+	return ret
+}
+
+// run handles the connection until it is closed or shutdown is requested
+func (c *connection) run() {
+	log.Notice("[%s] Receiver connection accepted", c.remote)
+
+	if err := c.handshake(); err != nil {
+		c.socket.Close()
+		log.Warning("[%s] Receiver connection failed: %s", c.remote, err)
+		c.audit(audit.EventClosed, err.Error())
+		return
+	}
+
+	c.audit(audit.EventAccepted, "")
+
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer func() {
+			wait.Done()
+		}()
+
+		c.sender()
+	}()
+
+	err := c.receive()
+
+	close(c.closed)
+	c.socket.Close()
+	wait.Wait()
+
+	if err != nil {
+		log.Warning("[%s] Receiver connection failed: %s", c.remote, err)
+		c.audit(audit.EventClosed, err.Error())
+		return
+	}
+
+	log.Notice("[%s] Receiver connection closed", c.remote)
+	c.audit(audit.EventClosed, "closed")
+}
+
+// handshake completes the TLS handshake of a TLS connection, so that the
+// negotiated parameters are available for the audit log before any messages
+// are read
+func (c *connection) handshake() error {
+	tlsSocket, ok := c.socket.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	tlsSocket.SetDeadline(time.Now().Add(c.receiver.config.Network.Timeout))
+	if err := tlsSocket.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake failure: %s", err)
+	}
+
+	return tlsSocket.SetDeadline(time.Time{})
+}
+
+// audit writes an entry for this connection to the audit log
+func (c *connection) audit(event string, reason string) {
+	entry := audit.NewEntry(event, c.socket)
+	entry.Listen = c.socket.LocalAddr().String()
+	entry.Reason = reason
+	if err := c.receiver.audit.Write(entry); err != nil {
+		log.Warning("[%s] Failed to write to audit log: %s", c.remote, err)
+	}
+}
+
+// send queues a message to be written to the remote
+func (c *connection) send(message []byte) {
+	select {
+	case <-c.closed:
+	case c.sendChan <- message:
+	}
+}
+
+// sender writes queued messages to the remote and periodically acknowledges
+// outstanding payloads again to keep the remote from timing out
+func (c *connection) sender() {
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+
+	failed := false
+
+	for {
+		var messages [][]byte
+
+		select {
+		case <-c.closed:
+			return
+		case <-c.receiver.shutdown:
+			c.socket.Close()
+			<-c.closed
+			return
+		case message := <-c.sendChan:
+			messages = [][]byte{message}
+		case <-ticker.C:
+			messages = c.keepalives()
+		}
+
+		// Once a write fails the socket is closed, so just discard until the
+		// receive loop notices
+		if failed {
+			continue
+		}
+
+		for _, message := range messages {
+			if err := c.write(message); err != nil {
+				log.Warning("[%s] Receiver failed to write to connection: %s", c.remote, err)
+				c.socket.Close()
+				failed = true
+				break
+			}
+		}
+	}
+}
+
+// write writes a message to the remote, compressing it if stream compression
+// has been negotiated
+func (c *connection) write(message []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.socket.SetWriteDeadline(time.Now().Add(c.receiver.config.Network.Timeout))
+	if c.compressor == nil {
+		_, err := c.socket.Write(message)
+		return err
+	}
+
+	if _, err := c.compressor.Write(message); err != nil {
+		return err
+	}
+
+	return c.compressor.Flush()
+}
+
+// keepalives returns acknowledgements for all outstanding payloads with their
+// current sequence
+func (c *connection) keepalives() [][]byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	messages := make([][]byte, 0, len(c.pending))
+	for stream := range c.pending {
+		messages = append(messages, stream.framing.EncodeAck(stream.nonce, stream.sequence))
+	}
+
+	return messages
+}
+
+// receive reads and handles messages from the remote until the connection is
+// closed
+func (c *connection) receive() error {
+	header := make([]byte, 8)
+	first := true
+
+	for {
+		if _, err := io.ReadFull(c.reader, header); err != nil {
+			return c.readError(err)
+		}
+
+		length := binary.BigEndian.Uint32(header[4:8])
+		if length > maxMessageSize {
+			return fmt.Errorf("Protocol error: Message too large (%d)", length)
+		}
+
+		message := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, message); err != nil {
+			return c.readError(err)
+		}
+
+		var err error
+		switch string(header[0:4]) {
+		case "CMPR":
+			if !first {
+				return fmt.Errorf("Protocol error: Compression requested after first message")
+			}
+			err = c.negotiateCompression(message)
+		case "META":
+			c.metadata = string(message)
+		case "PING":
+			c.send(c.pong())
+		case "JDAT", "JDAV":
+			err = c.processPayload(string(header[0:4]), message, true)
+		case "EVNT", "EVNV":
+			err = c.processPayload(string(header[0:4]), message, false)
+		default:
+			// Let the remote know so it can stop sending it, like the Logstash
+			// plugin does, rather than disconnecting
+			log.Warning("[%s] Receiver received unknown message: %q", c.remote, header[0:4])
+			c.send([]byte{'?', '?', '?', '?', 0, 0, 0, 0})
+		}
+
+		if err != nil {
+			return err
+		}
+
+		first = false
+	}
+}
+
+// negotiateCompression responds to a request to compress the connection. The
+// response is sent uncompressed, and everything after it in both directions is
+// compressed. Unsupported algorithms are declined with an empty response
+func (c *connection) negotiateCompression(algorithm []byte) error {
+	if string(algorithm) != streamCompressionZlib {
+		log.Warning("[%s] Receiver declined unsupported stream compression: %q", c.remote, algorithm)
+		return c.write([]byte{'C', 'M', 'P', 'R', 0, 0, 0, 0})
+	}
+
+	message := make([]byte, 8, 8+len(algorithm))
+	copy(message, "CMPR")
+	binary.BigEndian.PutUint32(message[4:8], uint32(len(algorithm)))
+	message = append(message, algorithm...)
+
+	// Hold the write lock across the response so nothing else is written
+	// uncompressed after it
+	c.writeMutex.Lock()
+	c.socket.SetWriteDeadline(time.Now().Add(c.receiver.config.Network.Timeout))
+	_, err := c.socket.Write(message)
+	if err == nil {
+		c.compressor = zlib.NewWriter(c.socket)
+	}
+	c.writeMutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	reader, err := zlib.NewReader(c.socket)
+	if err != nil {
+		return c.readError(err)
+	}
+
+	log.Info("[%s] Receiver using %s stream compression", c.remote, algorithm)
+	c.reader = reader
+	return nil
+}
+
+// pong returns the PONG message to respond to a PING with, which includes the
+// current time as nanoseconds since the Unix epoch if report time is enabled
+func (c *connection) pong() []byte {
+	if !c.receiver.receiverConfig.ReportTime {
+		return []byte{'P', 'O', 'N', 'G', 0, 0, 0, 0}
+	}
+
+	message := []byte{'P', 'O', 'N', 'G', 0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(message[8:], uint64(time.Now().UnixNano()))
+	return message
+}
+
+// readError returns the error to report for a failed read, which is nil if the
+// remote disconnected cleanly or we are shutting down
+func (c *connection) readError(err error) error {
+	select {
+	case <-c.receiver.shutdown:
+		return nil
+	default:
+	}
+
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
+
+// processPayload decodes the events in a payload and passes them to the
+// spooler. The events are prefixed by a nonce, or the versioned header and
+// nonce for JDAV and EVNV messages, and each is prefixed by its length, with
+// the events compressed for JDAT and JDAV messages
+func (c *connection) processPayload(messageType string, message []byte, compressed bool) error {
+	framing, nonce, data, err := transports.DecodePayloadHeader(messageType, message)
+	if err != nil {
+		return fmt.Errorf("Protocol error: %s", err)
+	}
+
+	var reader io.Reader = bytes.NewReader(data)
+	if compressed {
+		decompressor, err := zlib.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("Protocol error: Corrupt payload: %s", err)
+		}
+		defer decompressor.Close()
+		reader = decompressor
+	}
+
+	var events [][]byte
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("Protocol error: Corrupt payload: %s", err)
+		}
+
+		length := binary.BigEndian.Uint32(header)
+		if length > maxMessageSize {
+			return fmt.Errorf("Protocol error: Event too large (%d)", length)
+		}
+
+		event := make([]byte, length)
+		if _, err := io.ReadFull(reader, event); err != nil {
+			return fmt.Errorf("Protocol error: Corrupt payload: %s", err)
+		}
+
+		events = append(events, event)
+	}
+
+	metadata := c.metadata
+	c.metadata = ""
+
+	stream := &payloadStream{
+		conn:    c,
+		framing: framing,
+		nonce:   nonce,
+		length:  uint64(len(events)),
+	}
+
+	// Events are acknowledged by their 1-based position within the payload
+	descs := make([]*core.EventDescriptor, 0, len(events))
+	for i, event := range events {
+		if event = c.processEvent(event, metadata); event == nil {
+			continue
+		}
+
+		descs = append(descs, &core.EventDescriptor{
+			Stream: stream,
+			Offset: int64(i + 1),
+			Event:  event,
+		})
+	}
+
+	if len(descs) == 0 {
+		stream.Ack(int64(len(events)))
+		return nil
+	}
+
+	// Events dropped after the last one we forward are acknowledged with it
+	descs[len(descs)-1].Offset = int64(len(events))
+
+	c.mutex.Lock()
+	c.pending[stream] = struct{}{}
+	c.mutex.Unlock()
+
+	for _, desc := range descs {
+		select {
+		case <-c.receiver.shutdown:
+			return nil
+		case c.receiver.output <- desc:
+		}
+	}
+
+	return nil
+}
+
+// processEvent runs the processors over an event, returning the encoded event
+// or nil if a processor dropped it. If the payload had metadata and a metadata
+// field is configured, the metadata is stored in the field first
+func (c *connection) processEvent(data []byte, metadata string) []byte {
+	stringBigNumbers := c.receiver.receiverConfig.StringBigNumbers
+	metadataField := c.receiver.receiverConfig.MetadataField
+	if metadataField == "" {
+		metadata = ""
+	}
+
+	if len(c.processors) == 0 && !stringBigNumbers && metadata == "" {
+		return data
+	}
+
+	event, err := core.DecodeEvent(data)
+	if err != nil {
+		log.Warning("[%s] Relaying event without processing as it could not be decoded: %s", c.remote, err)
+		return data
+	}
+
+	if stringBigNumbers {
+		event.StringifyBigNumbers()
+	}
+
+	if metadata != "" {
+		event.SetPath(metadataField, metadata)
+	}
+
+	for _, processor := range c.processors {
+		if event = processor.Process(event); event == nil {
+			return nil
+		}
+	}
+
+	encoded, err := event.Encode(c.receiver.config.General.MessageField)
+	if err != nil {
+		log.Warning("[%s] Relaying event without processing as it could not be encoded: %s", c.remote, err)
+		return data
+	}
+
+	return encoded
+}
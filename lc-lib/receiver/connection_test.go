@@ -0,0 +1,462 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package receiver
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/audit"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/processors"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+func createTestReceiver() (*Receiver, chan *core.EventDescriptor, net.Conn) {
+	output := make(chan *core.EventDescriptor, 10)
+
+	cfg := config.NewConfig()
+	cfg.Network.Timeout = 5 * time.Second
+
+	receiver := &Receiver{
+		config:         cfg,
+		receiverConfig: &config.Receiver{},
+		audit:          cfg.Get("audit").(*audit.Config),
+		output:         output,
+		shutdown:       make(chan struct{}),
+	}
+
+	client, server := net.Pipe()
+	go newConnection(receiver, server).run()
+
+	return receiver, output, client
+}
+
+func writeTestPayload(t *testing.T, conn net.Conn, nonce string, events ...string) {
+	var data bytes.Buffer
+	compressor := zlib.NewWriter(&data)
+	for _, event := range events {
+		binary.Write(compressor, binary.BigEndian, uint32(len(event)))
+		compressor.Write([]byte(event))
+	}
+	compressor.Close()
+
+	message := make([]byte, 8, 8+16+data.Len())
+	copy(message[0:4], "JDAT")
+	binary.BigEndian.PutUint32(message[4:8], uint32(16+data.Len()))
+	message = append(message, nonce...)
+	message = append(message, data.Bytes()...)
+
+	if _, err := conn.Write(message); err != nil {
+		t.Fatalf("Failed to write payload: %s", err)
+	}
+}
+
+func readTestAck(t *testing.T, conn net.Conn, nonce string, sequence uint64) {
+	message := make([]byte, 28)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, message); err != nil {
+		t.Fatalf("Failed to read acknowledgement: %s", err)
+	}
+
+	if !bytes.Equal(message, transports.LegacyFraming.EncodeAck(nonce, sequence)) {
+		t.Errorf("Unexpected acknowledgement: %q", message)
+	}
+}
+
+func TestReceiverRelay(t *testing.T) {
+	receiver, output, conn := createTestReceiver()
+	defer close(receiver.shutdown)
+
+	nonce := "0123456789abcdef"
+	writeTestPayload(t, conn, nonce, `{"message":"one"}`, `{"message":"two"}`)
+
+	var descs []*core.EventDescriptor
+	for i := 0; i < 2; i++ {
+		select {
+		case desc := <-output:
+			descs = append(descs, desc)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for event")
+		}
+	}
+
+	if string(descs[0].Event) != `{"message":"one"}` || string(descs[1].Event) != `{"message":"two"}` {
+		t.Errorf("Unexpected events: %s %s", descs[0].Event, descs[1].Event)
+	}
+
+	// Acknowledgements must only be sent once the events are acknowledged
+	for _, desc := range descs {
+		stream, ok := desc.Stream.(core.AckStream)
+		if !ok {
+			t.Fatal("Event stream is not an AckStream")
+		}
+
+		stream.Ack(desc.Offset)
+		readTestAck(t, conn, nonce, uint64(desc.Offset))
+	}
+}
+
+func TestReceiverDroppedEvents(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Network.Timeout = 5 * time.Second
+
+	factory, err := processors.NewDropProcessorFactory(cfg, "", map[string]interface{}{
+		"patterns": []interface{}{"^drop"},
+	}, "drop", "message")
+	if err != nil {
+		t.Fatalf("Failed to create drop processor: %s", err)
+	}
+
+	output := make(chan *core.EventDescriptor, 10)
+	receiver := &Receiver{
+		config:         cfg,
+		receiverConfig: &config.Receiver{},
+		audit:          cfg.Get("audit").(*audit.Config),
+		output:         output,
+		shutdown:       make(chan struct{}),
+		processors:     []config.ProcessorStub{{Name: "drop", Factory: factory}},
+	}
+	defer close(receiver.shutdown)
+
+	conn, server := net.Pipe()
+	go newConnection(receiver, server).run()
+
+	// Every other event is dropped, including the last, which must be
+	// acknowledged along with the last event that is forwarded
+	nonce := "0123456789abcdef"
+	writeTestPayload(t, conn, nonce, `{"message":"keep one"}`, `{"message":"drop one"}`, `{"message":"keep two"}`, `{"message":"drop two"}`)
+
+	var descs []*core.EventDescriptor
+	for i := 0; i < 2; i++ {
+		select {
+		case desc := <-output:
+			descs = append(descs, desc)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for event")
+		}
+	}
+
+	if string(descs[0].Event) != `{"message":"keep one"}` || string(descs[1].Event) != `{"message":"keep two"}` {
+		t.Errorf("Unexpected events: %s %s", descs[0].Event, descs[1].Event)
+	}
+
+	for i, expected := range []int64{1, 4} {
+		if descs[i].Offset != expected {
+			t.Errorf("Unexpected offset for event %d: %d != %d", i, descs[i].Offset, expected)
+		}
+
+		descs[i].Stream.(core.AckStream).Ack(descs[i].Offset)
+		readTestAck(t, conn, nonce, uint64(expected))
+	}
+
+	// A payload where every event is dropped is acknowledged immediately
+	nonce = "fedcba9876543210"
+	writeTestPayload(t, conn, nonce, `{"message":"drop one"}`, `{"message":"drop two"}`)
+	readTestAck(t, conn, nonce, 2)
+
+	select {
+	case desc := <-output:
+		t.Errorf("Unexpected event: %s", desc.Event)
+	default:
+	}
+}
+
+func TestReceiverVersionedFraming(t *testing.T) {
+	receiver, output, conn := createTestReceiver()
+	defer close(receiver.shutdown)
+
+	nonce := "a longer nonce of 32 bytes......"
+	framing := transports.Framing{Versioned: true, SequenceWidth: 8}
+	header, err := framing.EncodePayloadHeader(nonce)
+	if err != nil {
+		t.Fatalf("Failed to encode payload header: %s", err)
+	}
+
+	event := `{"message":"one"}`
+	message := make([]byte, 8, 8+len(header)+4+len(event))
+	copy(message[0:4], "EVNV")
+	binary.BigEndian.PutUint32(message[4:8], uint32(len(header)+4+len(event)))
+	message = append(message, header...)
+	message = append(message, 0, 0, 0, byte(len(event)))
+	message = append(message, event...)
+
+	if _, err := conn.Write(message); err != nil {
+		t.Fatalf("Failed to write payload: %s", err)
+	}
+
+	var desc *core.EventDescriptor
+	select {
+	case desc = <-output:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+
+	if string(desc.Event) != event {
+		t.Errorf("Unexpected event: %s", desc.Event)
+	}
+
+	desc.Stream.(core.AckStream).Ack(desc.Offset)
+
+	// Acknowledgement must use the framing and sequence width of the payload
+	expected := framing.EncodeAck(nonce, 1)
+	ack := make([]byte, len(expected))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		t.Fatalf("Failed to read acknowledgement: %s", err)
+	}
+
+	if !bytes.Equal(ack, expected) || string(ack[0:4]) != "ACKV" {
+		t.Errorf("Unexpected acknowledgement: %q", ack)
+	}
+}
+
+func TestReceiverPing(t *testing.T) {
+	receiver, _, conn := createTestReceiver()
+	defer close(receiver.shutdown)
+
+	if _, err := conn.Write([]byte{'P', 'I', 'N', 'G', 0, 0, 0, 0}); err != nil {
+		t.Fatalf("Failed to write ping: %s", err)
+	}
+
+	message := make([]byte, 8)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, message); err != nil {
+		t.Fatalf("Failed to read pong: %s", err)
+	}
+
+	if string(message[0:4]) != "PONG" {
+		t.Errorf("Unexpected response: %q", message)
+	}
+}
+
+func TestReceiverPingReportTime(t *testing.T) {
+	receiver, _, conn := createTestReceiver()
+	defer close(receiver.shutdown)
+
+	receiver.receiverConfig.ReportTime = true
+
+	before := time.Now()
+	if _, err := conn.Write([]byte{'P', 'I', 'N', 'G', 0, 0, 0, 0}); err != nil {
+		t.Fatalf("Failed to write ping: %s", err)
+	}
+
+	message := make([]byte, 16)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, message); err != nil {
+		t.Fatalf("Failed to read pong: %s", err)
+	}
+
+	if string(message[0:4]) != "PONG" || binary.BigEndian.Uint32(message[4:8]) != 8 {
+		t.Fatalf("Unexpected response: %q", message)
+	}
+
+	remote := time.Unix(0, int64(binary.BigEndian.Uint64(message[8:])))
+	if remote.Before(before) || remote.After(time.Now()) {
+		t.Errorf("Unexpected time in pong: %s", remote)
+	}
+}
+
+func TestReceiverStreamCompression(t *testing.T) {
+	receiver, _, conn := createTestReceiver()
+	defer close(receiver.shutdown)
+
+	if _, err := conn.Write([]byte{'C', 'M', 'P', 'R', 0, 0, 0, 4, 'z', 'l', 'i', 'b'}); err != nil {
+		t.Fatalf("Failed to write compression request: %s", err)
+	}
+
+	message := make([]byte, 12)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, message); err != nil {
+		t.Fatalf("Failed to read compression response: %s", err)
+	}
+
+	if string(message) != "CMPR\x00\x00\x00\x04zlib" {
+		t.Fatalf("Unexpected response: %q", message)
+	}
+
+	compressor := zlib.NewWriter(conn)
+	compressor.Write([]byte{'P', 'I', 'N', 'G', 0, 0, 0, 0})
+	if err := compressor.Flush(); err != nil {
+		t.Fatalf("Failed to write ping: %s", err)
+	}
+
+	decompressor, err := zlib.NewReader(conn)
+	if err != nil {
+		t.Fatalf("Failed to read compressed response: %s", err)
+	}
+
+	message = make([]byte, 8)
+	if _, err := io.ReadFull(decompressor, message); err != nil {
+		t.Fatalf("Failed to read pong: %s", err)
+	}
+
+	if string(message[0:4]) != "PONG" {
+		t.Errorf("Unexpected response: %q", message)
+	}
+}
+
+func TestReceiverStreamCompressionDeclined(t *testing.T) {
+	receiver, _, conn := createTestReceiver()
+	defer close(receiver.shutdown)
+
+	if _, err := conn.Write([]byte{'C', 'M', 'P', 'R', 0, 0, 0, 4, 'z', 's', 't', 'd'}); err != nil {
+		t.Fatalf("Failed to write compression request: %s", err)
+	}
+
+	message := make([]byte, 8)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, message); err != nil {
+		t.Fatalf("Failed to read compression response: %s", err)
+	}
+
+	if string(message) != "CMPR\x00\x00\x00\x00" {
+		t.Fatalf("Unexpected response: %q", message)
+	}
+
+	// Connection continues uncompressed
+	if _, err := conn.Write([]byte{'P', 'I', 'N', 'G', 0, 0, 0, 0}); err != nil {
+		t.Fatalf("Failed to write ping: %s", err)
+	}
+
+	if _, err := io.ReadFull(conn, message); err != nil {
+		t.Fatalf("Failed to read pong: %s", err)
+	}
+
+	if string(message[0:4]) != "PONG" {
+		t.Errorf("Unexpected response: %q", message)
+	}
+}
+
+func TestReceiverStringBigNumbers(t *testing.T) {
+	receiver, output, conn := createTestReceiver()
+	defer close(receiver.shutdown)
+
+	receiver.receiverConfig.StringBigNumbers = true
+
+	writeTestPayload(t, conn, "0123456789abcdef", `{"id":1234567890123456789,"count":10}`)
+
+	select {
+	case desc := <-output:
+		if string(desc.Event) != `{"count":10,"id":"1234567890123456789"}` {
+			t.Errorf("Unexpected event: %s", desc.Event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+}
+
+func TestReceiverMetadata(t *testing.T) {
+	receiver, output, conn := createTestReceiver()
+	defer close(receiver.shutdown)
+
+	receiver.receiverConfig.MetadataField = "pipeline"
+
+	message := make([]byte, 8, 14)
+	copy(message[0:4], "META")
+	binary.BigEndian.PutUint32(message[4:8], 6)
+	message = append(message, "ingest"...)
+	if _, err := conn.Write(message); err != nil {
+		t.Fatalf("Failed to write metadata: %s", err)
+	}
+
+	// Metadata only applies to the payload that follows it
+	writeTestPayload(t, conn, "0123456789abcdef", `{"message":"one"}`)
+	writeTestPayload(t, conn, "fedcba9876543210", `{"message":"two"}`)
+
+	for _, expected := range []string{`{"message":"one","pipeline":"ingest"}`, `{"message":"two"}`} {
+		select {
+		case desc := <-output:
+			if string(desc.Event) != expected {
+				t.Errorf("Unexpected event: %s", desc.Event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for event")
+		}
+	}
+}
+
+func TestReceiverAudit(t *testing.T) {
+	file, err := ioutil.TempFile("", "audit")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	cfg := config.NewConfig()
+	cfg.Network.Timeout = 5 * time.Second
+
+	auditConfig := cfg.Get("audit").(*audit.Config)
+	auditConfig.Path = file.Name()
+	if err := auditConfig.Validate(); err != nil {
+		t.Fatalf("Failed to validate audit configuration: %s", err)
+	}
+
+	receiver := &Receiver{
+		config:         cfg,
+		receiverConfig: &config.Receiver{},
+		audit:          auditConfig,
+		output:         make(chan *core.EventDescriptor, 10),
+		shutdown:       make(chan struct{}),
+	}
+	defer close(receiver.shutdown)
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		newConnection(receiver, server).run()
+		close(done)
+	}()
+
+	client.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection did not close")
+	}
+
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Unexpected audit log: %s", data)
+	}
+
+	for i, expected := range []string{audit.EventAccepted, audit.EventClosed} {
+		var entry audit.Entry
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("Failed to decode audit entry: %s", err)
+		}
+		if entry.Event != expected || entry.Remote != "pipe" || entry.Timestamp == "" {
+			t.Errorf("Unexpected audit entry: %s", lines[i])
+		}
+	}
+}
@@ -0,0 +1,256 @@
+/*
+* Copyright 2014-2015 Jason Woods.
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+* http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package receiver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/audit"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/spooler"
+)
+
+// Receiver accepts connections from other Log Courier instances and passes the
+// events they send to the spooler so they are relayed to the network servers.
+// Events are only acknowledged to the remote once the network servers have
+// acknowledged them, so delivery is at-least-once end to end
+type Receiver struct {
+	core.PipelineSegment
+
+	config         *config.Config
+	receiverConfig *config.Receiver
+	adminConfig    *admin.Config
+	audit          *audit.Config
+	output         chan<- *core.EventDescriptor
+	listeners      []net.Listener
+
+	wait     sync.WaitGroup
+	shutdown chan struct{}
+
+	mutex      sync.Mutex
+	processors []config.ProcessorStub
+	rejected   uint64
+}
+
+// NewReceiver creates a new Receiver for the given receiver configuration and
+// starts listening on its addresses
+func NewReceiver(pipeline *core.Pipeline, config *config.Config, receiverConfig *config.Receiver, spoolerImp *spooler.Spooler) (*Receiver, error) {
+	ret := &Receiver{
+		config:         config,
+		receiverConfig: receiverConfig,
+		adminConfig:    config.Get("admin").(*admin.Config),
+		audit:          config.Get("audit").(*audit.Config),
+		output:         spoolerImp.Connect(),
+		shutdown:       make(chan struct{}),
+		processors:     receiverConfig.Processors,
+	}
+
+	var tlsConfig *tls.Config
+	if receiverConfig.Transport == "tls" {
+		var err error
+		if tlsConfig, err = ret.loadTLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, addr := range receiverConfig.ListenAddresses {
+		listener, err := net.Listen(addr.Network, addr.Address)
+		if err != nil {
+			ret.closeListeners()
+			return nil, fmt.Errorf("Failed to listen on %s (%s): %s", addr.Address, addr.Network, err)
+		}
+
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+
+		log.Notice("Receiver listening on %s (%s)", listener.Addr(), receiverConfig.Transport)
+		ret.listeners = append(ret.listeners, listener)
+	}
+
+	ret.initAPI()
+
+	pipeline.Register(ret)
+
+	return ret, nil
+}
+
+// ReloadProcessors replaces the processors that are run over the events of new
+// connections. Connections that are already established keep the processors
+// they were accepted with so that they are not disturbed
+func (r *Receiver) ReloadProcessors(processors []config.ProcessorStub) {
+	r.mutex.Lock()
+	r.processors = processors
+	r.mutex.Unlock()
+}
+
+// currentProcessors returns the processors to use for a new connection
+func (r *Receiver) currentProcessors() []config.ProcessorStub {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.processors
+}
+
+// loadTLSConfig loads the server certificate and, if one is configured, the
+// CA used to verify client certificates
+func (r *Receiver) loadTLSConfig() (*tls.Config, error) {
+	certificate, err := core.LoadX509KeyPair(r.receiverConfig.SSLCertificate, r.receiverConfig.SSLKey, core.ExpandSecret(r.receiverConfig.SSLKeyPassphrase))
+	if err == core.ErrIncorrectPassphrase {
+		return nil, fmt.Errorf("Failed loading receiver ssl certificate: ssl key passphrase is incorrect for %s", r.receiverConfig.SSLKey)
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed loading receiver ssl certificate: %s", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   r.receiverConfig.TLSMinVersion,
+		CipherSuites: r.receiverConfig.TLSCipherSuites,
+		Certificates: []tls.Certificate{certificate},
+	}
+
+	if r.receiverConfig.SSLClientCA != "" {
+		pemdata, err := ioutil.ReadFile(r.receiverConfig.SSLClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("Failure reading client CA certificate: %s", err)
+		}
+
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pemdata) {
+			return nil, fmt.Errorf("No certificates found in client CA: %s", r.receiverConfig.SSLClientCA)
+		}
+
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+		if r.receiverConfig.SSLVerifyUsage {
+			tlsConfig.VerifyPeerCertificate = core.VerifyExtKeyUsage(x509.ExtKeyUsageClientAuth)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// Run accepts connections until shutdown
+func (r *Receiver) Run() {
+	defer func() {
+		r.Done()
+	}()
+
+	for _, listener := range r.listeners {
+		r.wait.Add(1)
+		go r.acceptLoop(listener)
+	}
+
+	<-r.OnShutdown()
+
+	close(r.shutdown)
+	r.closeListeners()
+	r.wait.Wait()
+
+	log.Info("Receiver exiting")
+}
+
+// acceptLoop accepts connections from a single listener, starting a routine to
+// handle each one
+func (r *Receiver) acceptLoop(listener net.Listener) {
+	defer func() {
+		r.wait.Done()
+	}()
+
+	for {
+		socket, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-r.shutdown:
+				return
+			default:
+			}
+
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				log.Warning("Receiver failed to accept connection on %s: %s", listener.Addr(), err)
+				time.Sleep(time.Second)
+				continue
+			}
+
+			log.Errorf("Receiver stopped listening on %s: %s", listener.Addr(), err)
+			return
+		}
+
+		if !r.permitted(socket.RemoteAddr()) {
+			log.Warning("Receiver rejected connection from %s on %s as it is not permitted by the allow and deny lists", socket.RemoteAddr(), listener.Addr())
+			r.mutex.Lock()
+			r.rejected++
+			r.mutex.Unlock()
+			socket.Close()
+			continue
+		}
+
+		r.wait.Add(1)
+		go func() {
+			defer func() {
+				r.wait.Done()
+			}()
+
+			newConnection(r, socket).run()
+		}()
+	}
+}
+
+// permitted returns true if a connection from the given address is allowed. An
+// address matching any of the deny networks is always rejected, and if there
+// are any allow networks an address must match one of them
+func (r *Receiver) permitted(addr net.Addr) bool {
+	if len(r.receiverConfig.AllowNetworks) == 0 && len(r.receiverConfig.DenyNetworks) == 0 {
+		return true
+	}
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, network := range r.receiverConfig.DenyNetworks {
+		if network.Contains(tcpAddr.IP) {
+			return false
+		}
+	}
+
+	if len(r.receiverConfig.AllowNetworks) == 0 {
+		return true
+	}
+
+	for _, network := range r.receiverConfig.AllowNetworks {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// closeListeners closes all listening sockets
+func (r *Receiver) closeListeners() {
+	for _, listener := range r.listeners {
+		listener.Close()
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package receiver
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+func parseTestNetworks(t *testing.T, networks ...string) []*net.IPNet {
+	var ret []*net.IPNet
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			t.Fatalf("Failed to parse network: %s", err)
+		}
+		ret = append(ret, ipNet)
+	}
+	return ret
+}
+
+func checkPermitted(t *testing.T, r *Receiver, ip string, expected bool) {
+	if permitted := r.permitted(&net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}); permitted != expected {
+		t.Errorf("Unexpected result for %s: %t", ip, permitted)
+	}
+}
+
+func TestPermitted(t *testing.T) {
+	r := &Receiver{receiverConfig: &config.Receiver{}}
+	checkPermitted(t, r, "192.0.2.1", true)
+	checkPermitted(t, r, "2001:db8::1", true)
+
+	r.receiverConfig.AllowNetworks = parseTestNetworks(t, "10.0.0.0/8", "2001:db8::/32")
+	checkPermitted(t, r, "10.1.2.3", true)
+	checkPermitted(t, r, "::ffff:10.1.2.3", true)
+	checkPermitted(t, r, "2001:db8::1", true)
+	checkPermitted(t, r, "192.0.2.1", false)
+	checkPermitted(t, r, "2001:db9::1", false)
+
+	// Deny takes precedence over allow
+	r.receiverConfig.DenyNetworks = parseTestNetworks(t, "10.0.0.0/24", "2001:db8:1::/48")
+	checkPermitted(t, r, "10.0.0.5", false)
+	checkPermitted(t, r, "10.0.1.5", true)
+	checkPermitted(t, r, "2001:db8:1::1", false)
+	checkPermitted(t, r, "2001:db8:2::1", true)
+
+	// With only a deny list everything else is allowed
+	r.receiverConfig.AllowNetworks = nil
+	checkPermitted(t, r, "192.0.2.1", true)
+	checkPermitted(t, r, "10.0.0.5", false)
+}
+
+func TestAcceptLoopRejects(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	r := &Receiver{
+		config: config.NewConfig(),
+		receiverConfig: &config.Receiver{
+			DenyNetworks: parseTestNetworks(t, "127.0.0.0/8"),
+		},
+		shutdown: make(chan struct{}),
+	}
+
+	r.wait.Add(1)
+	go r.acceptLoop(listener)
+	defer func() {
+		close(r.shutdown)
+		listener.Close()
+		r.wait.Wait()
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Connection was not closed: %v", err)
+	}
+
+	r.mutex.Lock()
+	rejected := r.rejected
+	r.mutex.Unlock()
+	if rejected != 1 {
+		t.Errorf("Rejected connection was not counted: %d", rejected)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"github.com/driskell/log-courier/lc-lib/admin"
+)
+
+type apiStatus struct {
+	admin.APIKeyValue
+
+	r *Registrar
+}
+
+// Update updates the registrar status information
+func (a *apiStatus) Update() error {
+	// Update the values and pass through to node
+	a.r.Lock()
+	if a.r.persisting {
+		a.SetEntry("persisting", admin.APIString("yes"))
+	} else {
+		a.SetEntry("persisting", admin.APIString("no"))
+	}
+	a.SetEntry("writeFailures", admin.APINumber(a.r.writeFailures))
+	a.r.Unlock()
+
+	return nil
+}
+
+// initAPI sets up admin connectivity
+func (r *Registrar) initAPI() {
+	// Is admin loaded into the pipeline?
+	if !r.adminConfig.APIEnabled() {
+		return
+	}
+
+	registrarAPI := &admin.APINode{}
+	registrarAPI.SetEntry("status", &apiStatus{r: r})
+
+	r.adminConfig.SetEntry("registrar", registrarAPI)
+}
@@ -42,6 +42,12 @@ func (e *AckEvent) Process(state map[core.Stream]*FileState) {
 	}
 
 	for _, event := range e.events {
+		if ackStream, ok := event.Stream.(core.AckStream); ok {
+			// Relayed events are not persisted, the remote tracks its own offsets
+			ackStream.Ack(event.Offset)
+			continue
+		}
+
 		_, isFound := state[event.Stream]
 		if !isFound {
 			// This is probably stdin then or a deleted file we can't resume
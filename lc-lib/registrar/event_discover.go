@@ -45,5 +45,5 @@ func (e *DiscoverEvent) Process(state map[core.Stream]*FileState) {
 		Source: &e.source,
 		Offset: e.offset,
 	}
-	state[e.stream].PopulateFileIds(e.fileinfo)
+	state[e.stream].PopulateFileIds(e.source, e.fileinfo)
 }
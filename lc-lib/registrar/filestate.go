@@ -39,7 +39,7 @@ func NewFileInfo(fileinfo os.FileInfo) *FileInfo {
 	}
 }
 
-func (fs *FileInfo) SameAs(info os.FileInfo) bool {
+func (fs *FileInfo) SameAs(path string, info os.FileInfo) bool {
 	return os.SameFile(info, fs.fileinfo)
 }
 
@@ -60,8 +60,11 @@ func (fs *FileState) Update(fileinfo os.FileInfo, identity *FileIdentity) {
 	(*identity) = NewFileInfo(fileinfo)
 }
 
+// FileIdentity identifies a file so that it can be found again if renamed. The
+// path given to SameAs is where the information was obtained from, as some
+// platforms must open the file to determine its identity
 type FileIdentity interface {
-	SameAs(os.FileInfo) bool
+	SameAs(string, os.FileInfo) bool
 	Stat() os.FileInfo
 	Update(os.FileInfo, *FileIdentity)
 }
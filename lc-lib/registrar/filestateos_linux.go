@@ -29,14 +29,14 @@ type FileStateOS struct {
 	Device uint64 `json:"device,omitempty"`
 }
 
-func (fs *FileStateOS) PopulateFileIds(info os.FileInfo) {
+func (fs *FileStateOS) PopulateFileIds(path string, info os.FileInfo) {
 	fstat := info.Sys().(*syscall.Stat_t)
 	fs.Inode = fstat.Ino
 	fs.Device = fstat.Dev
 }
 
-func (fs *FileStateOS) SameAs(info os.FileInfo) bool {
+func (fs *FileStateOS) SameAs(path string, info os.FileInfo) bool {
 	state := &FileStateOS{}
-	state.PopulateFileIds(info)
+	state.PopulateFileIds(path, info)
 	return (fs.Inode == state.Inode && fs.Device == state.Device)
 }
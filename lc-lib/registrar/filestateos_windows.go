@@ -21,7 +21,7 @@ package registrar
 
 import (
 	"os"
-	"reflect"
+	"syscall"
 )
 
 type FileStateOS struct {
@@ -30,45 +30,54 @@ type FileStateOS struct {
 	IdxLo uint32 `json:"idxlo,omitempty"`
 }
 
-func (fs *FileStateOS) PopulateFileIds(info os.FileInfo) {
-	// For information on the following, see Go source: src/pkg/os/types_windows.go
-	// This is the only way we can get at the idxhi and idxlo
-	// Unix it is much easier as syscall.Stat_t is exposed and os.FileInfo interface has a Sys() method to get a syscall.Stat_t
-	// Unfortunately, the relevant Windows information is in a private struct so we have to dig inside
+// PopulateFileIds stores the volume serial number and file index of the file,
+// which together identify it on Windows. The os.FileInfo does not expose them,
+// so the file is opened to retrieve them. If the file can not be opened, such
+// as when it was deleted after it was found, the identity is left empty and
+// will not match any file
+func (fs *FileStateOS) PopulateFileIds(path string, info os.FileInfo) {
+	var err error
+	if fs.Vol, fs.IdxHi, fs.IdxLo, err = fileIds(path); err != nil {
+		log.Warning("Failed to determine the identity of %s, it will not be detected if it is renamed: %s", path, err)
+	}
+}
 
-	// NOTE: This WILL be prone to break if Go source changes, but I'd rather just fix it if it does or make it fail gracefully
+// SameAs returns true if the file at the given path is the same file. A file
+// that can not be opened, or an empty identity, is never the same
+func (fs *FileStateOS) SameAs(path string, info os.FileInfo) bool {
+	if fs.Vol == 0 && fs.IdxHi == 0 && fs.IdxLo == 0 {
+		return false
+	}
 
-	// info is os.FileInfo which is an interface to a
-	// - *os.fileStat (holding methods) which is a pointer to a
-	// - os.fileStat (holding data)
-	// ValueOf will pick up the interface contents immediately, so we need a single Elem()
+	vol, idxhi, idxlo, err := fileIds(path)
+	if err != nil {
+		return false
+	}
 
-	// Ensure that the numbers are loaded by calling os.SameFile
-	// os.SameFile will call sameFile (types_windows.go) which will call *os.fileStat's loadFileId
-	// Reflection panics if we try to call loadFileId directly as its a hidden method; regardless this is much safer and more reliable
-	os.SameFile(info, info)
+	return fs.Vol == vol && fs.IdxHi == idxhi && fs.IdxLo == idxlo
+}
 
-	// If any of the following fails, report the library has changed and recover and return 0s
-	defer func() {
-		if r := recover(); r != nil {
-			log.Error("BUG: File rotations that occur while Log Courier is not running will NOT be detected due to an incompatible change to the Go library used for compiling.")
-			fs.Vol = 0
-			fs.IdxHi = 0
-			fs.IdxLo = 0
-		}
-	}()
+// fileIds opens the file and returns its volume serial number and file index
+// using GetFileInformationByHandle. The file is opened without requesting any
+// access, and shared fully, so that it does not prevent the file being
+// written, renamed or deleted by others
+func fileIds(path string) (uint32, uint32, uint32, error) {
+	pathp, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
 
-	// Following makes fstat hold os.fileStat
-	fstat := reflect.ValueOf(info).Elem()
+	shareMode := uint32(syscall.FILE_SHARE_READ | syscall.FILE_SHARE_WRITE | syscall.FILE_SHARE_DELETE)
+	handle, err := syscall.CreateFile(pathp, 0, shareMode, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer syscall.CloseHandle(handle)
 
-	// To get the data, we need the os.fileStat that fstat points to, so one more Elem()
-	fs.Vol = uint32(fstat.FieldByName("vol").Uint())
-	fs.IdxHi = uint32(fstat.FieldByName("idxhi").Uint())
-	fs.IdxLo = uint32(fstat.FieldByName("idxlo").Uint())
-}
+	var data syscall.ByHandleFileInformation
+	if err = syscall.GetFileInformationByHandle(handle, &data); err != nil {
+		return 0, 0, 0, err
+	}
 
-func (fs *FileStateOS) SameAs(info os.FileInfo) bool {
-	state := &FileStateOS{}
-	state.PopulateFileIds(info)
-	return (fs.Vol == state.Vol && fs.IdxHi == state.IdxHi && fs.IdxLo == state.IdxLo)
+	return data.VolumeSerialNumber, data.FileIndexHigh, data.FileIndexLow, nil
 }
@@ -20,18 +20,28 @@
 package registrar
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/core"
+	"io"
 	"os"
+	"path/filepath"
 	"sync"
 )
 
+// gzipMagic is the header that identifies a gzip compressed state file
+var gzipMagic = []byte{0x1f, 0x8b}
+
 type LoadPreviousFunc func(string, *FileState) (core.Stream, error)
 
 type Registrator interface {
 	Connect() EventSpooler
 	LoadPrevious(LoadPreviousFunc) (bool, error)
+	Sequence() *Sequence
 }
 
 type Registrar struct {
@@ -42,18 +52,35 @@ type Registrar struct {
 	registrar_chan chan []EventProcessor
 	references     int
 	persistdir     string
+	compress       bool
+	strict         bool
 	statefile      string
 	state          map[core.Stream]*FileState
+	rawSize        int64
+	adminConfig    *admin.Config
+	persisting     bool
+	writeFailures  int64
+	sequence       *Sequence
 }
 
-func NewRegistrar(pipeline *core.Pipeline, persistdir string) *Registrar {
+func NewRegistrar(pipeline *core.Pipeline, config *config.Config) *Registrar {
 	ret := &Registrar{
 		registrar_chan: make(chan []EventProcessor, 16), // TODO: Make configurable?
-		persistdir:     persistdir,
+		persistdir:     config.General.PersistDir,
+		compress:       config.General.PersistCompress,
+		strict:         config.General.PersistMode == "strict",
 		statefile:      ".log-courier",
 		state:          make(map[core.Stream]*FileState),
+		adminConfig:    config.Get("admin").(*admin.Config),
+		persisting:     true,
+	}
+
+	if config.General.EventSequence {
+		ret.sequence = NewSequence(filepath.Join(ret.persistdir, ret.statefile+".sequence"))
 	}
 
+	ret.initAPI()
+
 	pipeline.Register(ret)
 
 	return ret
@@ -62,6 +89,22 @@ func NewRegistrar(pipeline *core.Pipeline, persistdir string) *Registrar {
 func (r *Registrar) LoadPrevious(callback_func LoadPreviousFunc) (have_previous bool, err error) {
 	data := make(map[string]*FileState)
 
+	// Check we can write to the persist directory before we start harvesting,
+	// so we do not discover we can not save state until it is too late
+	if err = r.checkWritable(); err != nil {
+		if r.strict {
+			return false, fmt.Errorf("Persist directory is not writable: %s", err)
+		}
+
+		r.disablePersistence(err)
+	}
+
+	if r.sequence != nil {
+		if err = r.sequence.load(); err != nil {
+			return false, fmt.Errorf("Event sequence load failed: %s", err)
+		}
+	}
+
 	// Load the previous state - opening RDWR ensures we can write too and fail early
 	// c_filename is what we will use to test create capability
 	filename := r.persistdir + string(os.PathSeparator) + ".log-courier"
@@ -92,7 +135,23 @@ func (r *Registrar) LoadPrevious(callback_func LoadPreviousFunc) (have_previous
 	log.Notice("Loading registrar data from %s", filename)
 	have_previous = true
 
-	decoder := json.NewDecoder(f)
+	// Detect compressed state so either form can be loaded regardless of the
+	// current configuration
+	var reader io.Reader
+	buffered := bufio.NewReader(f)
+	if magic, _ := buffered.Peek(len(gzipMagic)); string(magic) == string(gzipMagic) {
+		var gzipReader *gzip.Reader
+		if gzipReader, err = gzip.NewReader(buffered); err != nil {
+			f.Close()
+			return false, fmt.Errorf("Registry load failed: %s", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	} else {
+		reader = buffered
+	}
+
+	decoder := json.NewDecoder(reader)
 	decoder.Decode(&data)
 	f.Close()
 
@@ -107,13 +166,113 @@ func (r *Registrar) LoadPrevious(callback_func LoadPreviousFunc) (have_previous
 	}
 
 	// Test we can successfully save new states by attempting to save now
+	if !r.persisting {
+		return
+	}
+
 	if err = r.writeRegistry(); err != nil {
-		return false, fmt.Errorf("Registry write failed: %s", err)
+		if r.strict {
+			return false, fmt.Errorf("Registry write failed: %s", err)
+		}
+
+		r.disablePersistence(err)
+		return true, nil
+	}
+
+	if r.compress {
+		r.logCompression()
 	}
 
 	return
 }
 
+// checkWritable tests the persist directory is writable by creating and then
+// removing a probe file
+func (r *Registrar) checkWritable() error {
+	probe := filepath.Join(r.persistdir, r.statefile+".probe")
+
+	file, err := os.Create(probe)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write([]byte("probe\n"))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	os.Remove(probe)
+
+	return err
+}
+
+// disablePersistence switches to keeping state in memory only, used in lenient
+// mode when the persist directory is not writable
+func (r *Registrar) disablePersistence(err error) {
+	log.Error("Persist directory %s is not writable, continuing WITHOUT saving state: %s", r.persistdir, err)
+	log.Warning("All files will be harvested again from their start positions when Log Courier restarts")
+
+	r.Lock()
+	r.persisting = false
+	r.Unlock()
+
+	if r.sequence != nil {
+		r.sequence.disablePersistence()
+	}
+}
+
+// logCompression reports the size reduction achieved by compressing the state
+func (r *Registrar) logCompression() {
+	info, err := os.Stat(r.persistdir + string(os.PathSeparator) + r.statefile)
+	if err != nil || r.rawSize == 0 {
+		return
+	}
+
+	log.Notice("Registrar state compressed from %d to %d bytes (%.1f%% reduction)", r.rawSize, info.Size(), 100-float64(info.Size())*100/float64(r.rawSize))
+}
+
+// encodeRegistry writes the state to the given writer, compressing it if
+// configured to do so
+func (r *Registrar) encodeRegistry(w io.Writer) error {
+	counter := &countingWriter{}
+
+	if !r.compress {
+		counter.w = w
+		if err := json.NewEncoder(counter).Encode(r.toCanonical()); err != nil {
+			return err
+		}
+		r.rawSize = counter.n
+		return nil
+	}
+
+	gzipWriter := gzip.NewWriter(w)
+	counter.w = gzipWriter
+	if err := json.NewEncoder(counter).Encode(r.toCanonical()); err != nil {
+		gzipWriter.Close()
+		return err
+	}
+	r.rawSize = counter.n
+
+	return gzipWriter.Close()
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Sequence returns the event sequence, or nil if event sequence numbers are
+// not enabled
+func (r *Registrar) Sequence() *Sequence {
+	return r.sequence
+}
+
 func (r *Registrar) Connect() EventSpooler {
 	r.Lock()
 	defer r.Unlock()
@@ -161,11 +320,23 @@ RegistrarLoop:
 				event.Process(r.state)
 			}
 
+			if !r.persisting {
+				continue
+			}
+
 			if err := r.writeRegistry(); err != nil {
 				log.Error("Registry write failed: %s", err)
+
+				r.Lock()
+				r.writeFailures++
+				r.Unlock()
 			}
 		}
 	}
 
+	if r.sequence != nil {
+		r.sequence.close()
+	}
+
 	log.Info("Registrar exiting")
 }
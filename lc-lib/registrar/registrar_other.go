@@ -22,7 +22,6 @@
 package registrar
 
 import (
-	"encoding/json"
 	"os"
 	"path"
 )
@@ -37,8 +36,9 @@ func (r *Registrar) writeRegistry() error {
 	}
 	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.Encode(r.toCanonical())
+	if err = r.encodeRegistry(file); err != nil {
+		return err
+	}
 
 	return os.Rename(tname, fname)
 }
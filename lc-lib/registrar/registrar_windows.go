@@ -20,7 +20,6 @@
 package registrar
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path"
@@ -34,9 +33,11 @@ func (r *Registrar) writeRegistry() error {
 		return err
 	}
 
-	encoder := json.NewEncoder(file)
-	encoder.Encode(r.toCanonical())
+	err = r.encodeRegistry(file)
 	file.Close()
+	if err != nil {
+		return err
+	}
 
 	var d_err error
 	if _, err = os.Stat(fname); err == nil || !os.IsNotExist(err) {
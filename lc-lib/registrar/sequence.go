@@ -0,0 +1,145 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// sequenceBlock is how many sequence numbers are reserved each time the
+	// sequence state is saved, so that the state only needs to be written once
+	// per block rather than for every event
+	sequenceBlock = 10000
+)
+
+// Sequence issues monotonically increasing event sequence numbers that
+// continue across restarts. Numbers are reserved in blocks ahead of use, and
+// the reservation is saved before any number within it is issued, so a number
+// is never issued twice. After a crash the unused remainder of the block is
+// skipped, leaving a detectable gap
+type Sequence struct {
+	mutex sync.Mutex
+
+	// Accessed atomically
+	last     uint64
+	reserved uint64
+
+	file string
+}
+
+// NewSequence creates a new Sequence saving its state to the given file, or
+// keeping it only in memory if the file is empty
+func NewSequence(file string) *Sequence {
+	return &Sequence{
+		file: file,
+	}
+}
+
+// Next returns the next sequence number, and is safe to call concurrently
+func (s *Sequence) Next() uint64 {
+	next := atomic.AddUint64(&s.last, 1)
+	if next > atomic.LoadUint64(&s.reserved) {
+		s.reserve(next)
+	}
+	return next
+}
+
+// reserve saves a new block reservation covering the given number
+func (s *Sequence) reserve(next uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Another caller may have reserved while we waited
+	if next <= atomic.LoadUint64(&s.reserved) {
+		return
+	}
+
+	reserved := next + sequenceBlock
+	if err := s.save(reserved); err != nil {
+		log.Error("Failed to save event sequence state, numbering may repeat after a restart: %s", err)
+	}
+
+	atomic.StoreUint64(&s.reserved, reserved)
+}
+
+// load reads the saved state, continuing the sequence from it
+func (s *Sequence) load() error {
+	if s.file == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	last, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	atomic.StoreUint64(&s.last, last)
+	atomic.StoreUint64(&s.reserved, last)
+	s.mutex.Unlock()
+
+	log.Info("Continuing event sequence from %d", last+1)
+
+	return nil
+}
+
+// disablePersistence stops the state being saved
+func (s *Sequence) disablePersistence() {
+	s.mutex.Lock()
+	s.file = ""
+	s.mutex.Unlock()
+}
+
+// close saves the last issued number so that a clean restart continues the
+// sequence without a gap
+func (s *Sequence) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.save(atomic.LoadUint64(&s.last)); err != nil {
+		log.Error("Failed to save event sequence state: %s", err)
+	}
+}
+
+// save writes the given number as the state, replacing the file atomically so
+// a crash during the write does not lose it
+func (s *Sequence) save(value uint64) error {
+	if s.file == "" {
+		return nil
+	}
+
+	tname := s.file + ".new"
+	if err := ioutil.WriteFile(tname, []byte(strconv.FormatUint(value, 10)+"\n"), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tname, s.file)
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registrar
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSequenceConcurrent(t *testing.T) {
+	sequence := NewSequence("")
+
+	var (
+		mutex sync.Mutex
+		group sync.WaitGroup
+		seen  = make(map[uint64]bool)
+	)
+
+	for i := 0; i < 8; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for j := 0; j < 5000; j++ {
+				next := sequence.Next()
+				mutex.Lock()
+				seen[next] = true
+				mutex.Unlock()
+			}
+		}()
+	}
+	group.Wait()
+
+	if len(seen) != 40000 || !seen[1] || !seen[40000] {
+		t.Errorf("Sequence numbers were not unique and contiguous: %d issued", len(seen))
+	}
+}
+
+func TestSequencePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequence")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, ".log-courier.sequence")
+
+	sequence := NewSequence(file)
+	if err := sequence.load(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		sequence.Next()
+	}
+
+	// A crash leaves the reservation, so numbering skips the rest of the block
+	sequence = NewSequence(file)
+	if err := sequence.load(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if next := sequence.Next(); next != 1+sequenceBlock+1 {
+		t.Errorf("Unexpected sequence after crash: %d", next)
+	}
+
+	// A clean shutdown continues without a gap
+	sequence.close()
+	sequence = NewSequence(file)
+	if err := sequence.load(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if next := sequence.Next(); next != 1+sequenceBlock+2 {
+		t.Errorf("Unexpected sequence after clean shutdown: %d", next)
+	}
+}
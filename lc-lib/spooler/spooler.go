@@ -22,7 +22,9 @@ package spooler
 import (
 	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/core"
-	"github.com/driskell/log-courier/lc-lib/publisher"
+	"io"
+	"os"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,6 +33,12 @@ const (
 	event_header_size = 4
 )
 
+// Output is the next segment of the pipeline that spools are sent to, which is
+// the Publisher or, if enabled, the disk spool
+type Output interface {
+	Connect() chan<- []*core.EventDescriptor
+}
+
 type Spooler struct {
 	core.PipelineSegment
 	core.PipelineConfigReceiver
@@ -42,14 +50,19 @@ type Spooler struct {
 	output      chan<- []*core.EventDescriptor
 	timer_start time.Time
 	timer       *time.Timer
+	stdout      io.Writer
+	paused      int32
+	pauseChan   chan struct{}
 }
 
-func NewSpooler(pipeline *core.Pipeline, config *config.General, publisher_imp *publisher.Publisher) *Spooler {
+func NewSpooler(pipeline *core.Pipeline, config *config.General, output Output) *Spooler {
 	ret := &Spooler{
-		config: config,
-		spool:  make([]*core.EventDescriptor, 0, config.SpoolSize),
-		input:  make(chan *core.EventDescriptor, 16), // TODO: Make configurable?
-		output: publisher_imp.Connect(),
+		config:    config,
+		spool:     make([]*core.EventDescriptor, 0, config.SpoolSize),
+		input:     make(chan *core.EventDescriptor, config.SpoolInputBuffer),
+		output:    output.Connect(),
+		stdout:    os.Stdout,
+		pauseChan: make(chan struct{}, 1),
 	}
 
 	pipeline.Register(ret)
@@ -65,6 +78,29 @@ func (s *Spooler) Flush() {
 	s.input <- nil
 }
 
+// Pause stops the spooler taking new events once it has sent what it already
+// holds, so that harvesters and receivers block until Resume is called. It
+// never blocks
+func (s *Spooler) Pause() {
+	atomic.StoreInt32(&s.paused, 1)
+	s.notifyPause()
+}
+
+// Resume allows the spooler to take new events again after Pause
+func (s *Spooler) Resume() {
+	atomic.StoreInt32(&s.paused, 0)
+	s.notifyPause()
+}
+
+// notifyPause wakes the spooler to check whether it is paused, unless it is
+// already due to check
+func (s *Spooler) notifyPause() {
+	select {
+	case s.pauseChan <- struct{}{}:
+	default:
+	}
+}
+
 func (s *Spooler) Run() {
 	defer func() {
 		s.Done()
@@ -73,10 +109,13 @@ func (s *Spooler) Run() {
 	s.timer_start = time.Now()
 	s.timer = time.NewTimer(s.config.SpoolTimeout)
 
+	// Input is nil while paused so no new events are taken
+	var input <-chan *core.EventDescriptor = s.input
+
 SpoolerLoop:
 	for {
 		select {
-		case event := <-s.input:
+		case event := <-input:
 			// Nil event means flush
 			if event == nil {
 				if len(s.spool) > 0 {
@@ -90,6 +129,8 @@ SpoolerLoop:
 				continue
 			}
 
+			s.tee(event)
+
 			if len(s.spool) > 0 && int64(s.spool_size)+int64(len(event.Event))+event_header_size >= s.config.SpoolMaxBytes {
 				log.Debug("Spooler flushing %d events due to spool max bytes (%d/%d - next is %d)", len(s.spool), s.spool_size, s.config.SpoolMaxBytes, len(event.Event)+4)
 
@@ -108,8 +149,9 @@ SpoolerLoop:
 			s.spool_size += len(event.Event) + event_header_size
 			s.spool = append(s.spool, event)
 
-			// Flush if full
-			if len(s.spool) >= cap(s.spool) {
+			// Flush if full. The spool size is checked rather than the capacity of
+			// the spool so that a reduced size applies immediately after a reload
+			if len(s.spool) >= int(s.config.SpoolSize) {
 				log.Debug("Spooler flushing %d events due to spool size reached", len(s.spool))
 
 				if !s.sendSpool() {
@@ -129,6 +171,24 @@ SpoolerLoop:
 			}
 
 			s.resetTimer()
+		case <-s.pauseChan:
+			if atomic.LoadInt32(&s.paused) == 0 {
+				input = s.input
+				continue
+			}
+
+			// Send what we have so the pipeline can drain it while paused
+			if input != nil && len(s.spool) > 0 {
+				log.Debug("Spooler flushing %d events due to pause", len(s.spool))
+
+				if !s.sendSpool() {
+					break SpoolerLoop
+				}
+
+				s.resetTimer()
+			}
+
+			input = nil
 		case <-s.OnShutdown():
 			break SpoolerLoop
 		case config := <-s.OnConfig():
@@ -173,9 +233,10 @@ func (s *Spooler) resetTimer() {
 func (s *Spooler) reloadConfig(config *config.Config) bool {
 	s.config = &config.General
 
-	// Immediate flush?
+	// Immediate flush? This includes when the spool now exceeds a reduced spool
+	// size or spool max bytes, so that no spool ever exceeds the limits
 	passed := time.Now().Sub(s.timer_start)
-	if passed >= s.config.SpoolTimeout || len(s.spool) >= int(s.config.SpoolSize) {
+	if passed >= s.config.SpoolTimeout || len(s.spool) >= int(s.config.SpoolSize) || int64(s.spool_size) >= s.config.SpoolMaxBytes {
 		if !s.sendSpool() {
 			return false
 		}
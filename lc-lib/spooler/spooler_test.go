@@ -0,0 +1,238 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spooler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func TestReloadSpoolMaxBytes(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+
+	output := make(chan []*core.EventDescriptor, 1)
+	spooler := &Spooler{
+		config:      &cfg.General,
+		output:      output,
+		timer_start: time.Now(),
+		timer:       time.NewTimer(cfg.General.SpoolTimeout),
+	}
+
+	event := &core.EventDescriptor{Event: []byte(`{"message":"test"}`)}
+	spooler.spool = []*core.EventDescriptor{event, event}
+	spooler.spool_size = 2 * (len(event.Event) + event_header_size)
+
+	// The spool fits within the new limits, so is held
+	newCfg := config.NewConfig()
+	newCfg.General.InitDefaults()
+	if !spooler.reloadConfig(newCfg) {
+		t.Fatal("Reload failed")
+	}
+	if len(output) != 0 {
+		t.Fatal("Spool was flushed without exceeding the limits")
+	}
+
+	// A reduced spool max bytes flushes the spool immediately
+	newCfg = config.NewConfig()
+	newCfg.General.InitDefaults()
+	newCfg.General.SpoolMaxBytes = int64(spooler.spool_size)
+	if !spooler.reloadConfig(newCfg) {
+		t.Fatal("Reload failed")
+	}
+
+	select {
+	case spool := <-output:
+		if len(spool) != 2 {
+			t.Errorf("Unexpected spool length: %d", len(spool))
+		}
+	default:
+		t.Fatal("Spool was not flushed after spool max bytes was reduced")
+	}
+
+	if len(spooler.spool) != 0 || spooler.spool_size != 0 {
+		t.Errorf("Spool was not reset: %d events, %d bytes", len(spooler.spool), spooler.spool_size)
+	}
+}
+
+type testOutput struct {
+	output chan []*core.EventDescriptor
+}
+
+func (o *testOutput) Connect() chan<- []*core.EventDescriptor {
+	return o.output
+}
+
+func TestPause(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+
+	pipeline := core.NewPipeline()
+	output := make(chan []*core.EventDescriptor, 1)
+	spooler := NewSpooler(pipeline, &cfg.General, &testOutput{output})
+	pipeline.Start()
+	defer func() {
+		pipeline.Shutdown()
+		pipeline.Wait()
+	}()
+
+	event := &core.EventDescriptor{Event: []byte(`{"message":"test"}`)}
+	spooler.Connect() <- event
+
+	// Once taken from the input the event is in the spool
+	for len(spooler.input) != 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Pausing sends the events already spooled
+	spooler.Pause()
+
+	select {
+	case spool := <-output:
+		if len(spool) != 1 {
+			t.Errorf("Unexpected spool length: %d", len(spool))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Spool was not flushed on pause")
+	}
+
+	// While paused new events are not taken, so fill the input and check the
+	// next send blocks
+	input := spooler.Connect()
+	for i := 0; i < cap(spooler.input); i++ {
+		input <- event
+	}
+
+	select {
+	case input <- event:
+		t.Fatal("Spooler took an event while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	spooler.Resume()
+
+	select {
+	case input <- event:
+	case <-time.After(time.Second):
+		t.Fatal("Spooler did not take events after resume")
+	}
+}
+
+func TestFlushSpoolMaxBytes(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	cfg.General.SpoolMaxBytes = 1000
+	cfg.General.SpoolTimeout = time.Hour
+
+	pipeline := core.NewPipeline()
+	output := make(chan []*core.EventDescriptor, 10)
+	spooler := NewSpooler(pipeline, &cfg.General, &testOutput{output})
+	pipeline.Start()
+	defer func() {
+		pipeline.Shutdown()
+		pipeline.Wait()
+	}()
+
+	// Events of varying sizes are batched by their encoded length rather than
+	// their number
+	sizes := []int{100, 300, 500, 50, 900, 20, 20, 600}
+	for _, size := range sizes {
+		spooler.Connect() <- &core.EventDescriptor{Event: make([]byte, size)}
+	}
+	spooler.Flush()
+
+	var spools [][]int
+	for total := 0; total < len(sizes); {
+		select {
+		case spool := <-output:
+			var lengths []int
+			size := 0
+			for _, event := range spool {
+				lengths = append(lengths, len(event.Event))
+				size += len(event.Event) + event_header_size
+			}
+			if size > int(cfg.General.SpoolMaxBytes) {
+				t.Errorf("Spool of %d bytes exceeds spool max bytes: %v", size, lengths)
+			}
+			spools = append(spools, lengths)
+			total += len(spool)
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for spools, received: %v", spools)
+		}
+	}
+
+	expected := [][]int{{100, 300, 500, 50}, {900, 20, 20}, {600}}
+	if !reflect.DeepEqual(spools, expected) {
+		t.Errorf("Unexpected spools: %v != expected %v", spools, expected)
+	}
+}
+
+// benchmarkInputBuffer feeds events to the spooler from producers that send in
+// bursts, like harvesters reading a batch of new lines, while the output
+// periodically stalls, like a publisher waiting on the network
+func benchmarkInputBuffer(b *testing.B, buffer int64) {
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	cfg.General.SpoolInputBuffer = buffer
+	cfg.General.SpoolTimeout = time.Hour
+
+	pipeline := core.NewPipeline()
+	output := make(chan []*core.EventDescriptor, 1)
+	spooler := NewSpooler(pipeline, &cfg.General, &testOutput{output})
+	pipeline.Start()
+	defer func() {
+		pipeline.Shutdown()
+		pipeline.Wait()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		for received := 0; received < b.N; {
+			spool := <-output
+			received += len(spool)
+			time.Sleep(50 * time.Microsecond)
+		}
+		close(done)
+	}()
+
+	event := &core.EventDescriptor{Event: []byte(`{"message":"test"}`)}
+	input := spooler.Connect()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		input <- event
+		if i%256 == 255 {
+			time.Sleep(20 * time.Microsecond)
+		}
+	}
+	spooler.Flush()
+
+	<-done
+}
+
+func BenchmarkInputBuffer16(b *testing.B) {
+	benchmarkInputBuffer(b, 16)
+}
+
+func BenchmarkInputBuffer256(b *testing.B) {
+	benchmarkInputBuffer(b, 256)
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spooler
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+// tee writes a copy of a fully processed event to stdout as pretty JSON, if
+// enabled, so the events being shipped can be inspected when diagnosing
+// problems. Only the configured percentage of events is written so the
+// console is not flooded
+func (s *Spooler) tee(event *core.EventDescriptor) {
+	if !s.config.TeeStdout {
+		return
+	}
+
+	if s.config.TeeStdoutSample < 100 && rand.Int63n(100) >= s.config.TeeStdoutSample {
+		return
+	}
+
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, event.Event, "", "  "); err != nil {
+		pretty.Reset()
+		pretty.Write(event.Event)
+	}
+	pretty.WriteByte('\n')
+
+	if _, err := s.stdout.Write(pretty.Bytes()); err != nil {
+		log.Warning("Failed to write event to stdout: %s", err)
+	}
+}
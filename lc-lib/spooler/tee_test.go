@@ -0,0 +1,70 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package spooler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func createTeeSpooler(enabled bool, sample int64) (*Spooler, *bytes.Buffer) {
+	output := &bytes.Buffer{}
+	return &Spooler{
+		config: &config.General{
+			TeeStdout:       enabled,
+			TeeStdoutSample: sample,
+		},
+		stdout: output,
+	}, output
+}
+
+func TestTee(t *testing.T) {
+	spooler, output := createTeeSpooler(true, 100)
+
+	spooler.tee(&core.EventDescriptor{Event: []byte(`{"message":"test"}`)})
+
+	if output.String() != "{\n  \"message\": \"test\"\n}\n" {
+		t.Errorf("Unexpected output: %q", output.String())
+	}
+}
+
+func TestTeeDisabled(t *testing.T) {
+	spooler, output := createTeeSpooler(false, 100)
+
+	spooler.tee(&core.EventDescriptor{Event: []byte(`{"message":"test"}`)})
+
+	if output.Len() != 0 {
+		t.Errorf("Unexpected output when disabled: %q", output.String())
+	}
+}
+
+func TestTeeSample(t *testing.T) {
+	spooler, output := createTeeSpooler(true, 10)
+
+	for i := 0; i < 1000; i++ {
+		spooler.tee(&core.EventDescriptor{Event: []byte(`{}`)})
+	}
+
+	// Allow plenty of leeway so the test is not flaky
+	if count := strings.Count(output.String(), "\n"); count < 20 || count > 250 {
+		t.Errorf("Unexpected number of sampled events: %d", count)
+	}
+}
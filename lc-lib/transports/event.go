@@ -61,11 +61,11 @@ func (e *StatusEvent) StatusChange() StatusChange {
 type AckEvent struct {
 	observer Observer
 	nonce    string
-	sequence uint32
+	sequence uint64
 }
 
 // NewAckEvent generates a new AckEvent for the given Endpoint
-func NewAckEvent(observer Observer, nonce string, sequence uint32) *AckEvent {
+func NewAckEvent(observer Observer, nonce string, sequence uint64) *AckEvent {
 	return &AckEvent{
 		observer: observer,
 		nonce:    nonce,
@@ -74,10 +74,10 @@ func NewAckEvent(observer Observer, nonce string, sequence uint32) *AckEvent {
 }
 
 // NewAckEventWithBytes generates a new AckEvent using bytes, conveniently
-// converting them to string and uint32
+// converting them to string and uint64
 func NewAckEventWithBytes(observer Observer, nonce []byte, sequence []byte) *AckEvent {
 	stringNonce := string(nonce)
-	integerSequence := binary.BigEndian.Uint32(sequence)
+	integerSequence := uint64(binary.BigEndian.Uint32(sequence))
 	return NewAckEvent(observer, stringNonce, integerSequence)
 }
 
@@ -92,7 +92,7 @@ func (e *AckEvent) Nonce() string {
 }
 
 // Sequence returns the sequence value
-func (e *AckEvent) Sequence() uint32 {
+func (e *AckEvent) Sequence() uint64 {
 	return e.sequence
 }
 
@@ -0,0 +1,185 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	// LegacyNonceLength is the nonce length of the original JDAT, EVNT and ACKN
+	// messages
+	LegacyNonceLength = 16
+
+	// LegacySequenceWidth is the sequence width of the original ACKN message
+	LegacySequenceWidth = 4
+
+	// FramingVersion is the version of the versioned framing
+	FramingVersion = 1
+)
+
+// Framing describes how a payload was framed, so that the acknowledgements for
+// it can be framed the same way. The legacy framing has a fixed 16-byte nonce
+// and 4-byte sequence, whereas the versioned framing begins with a version
+// byte followed by the nonce length and sequence width in use
+type Framing struct {
+	Versioned     bool
+	SequenceWidth int
+}
+
+// LegacyFraming is the framing used by the original JDAT, EVNT and ACKN
+// messages
+var LegacyFraming = Framing{SequenceWidth: LegacySequenceWidth}
+
+// PayloadType returns the message type for a payload with this framing
+func (f Framing) PayloadType(compressed bool) string {
+	switch {
+	case f.Versioned && compressed:
+		return "JDAV"
+	case f.Versioned:
+		return "EVNV"
+	case compressed:
+		return "JDAT"
+	}
+	return "EVNT"
+}
+
+// FramingForNonce returns the framing required to transmit the given nonce,
+// which is the legacy framing where possible so that older receivers are
+// still supported
+func FramingForNonce(nonce string) Framing {
+	if len(nonce) == LegacyNonceLength {
+		return LegacyFraming
+	}
+	return Framing{Versioned: true, SequenceWidth: LegacySequenceWidth}
+}
+
+// EncodePayloadHeader returns the data that preceeds the events within a
+// payload message
+func (f Framing) EncodePayloadHeader(nonce string) ([]byte, error) {
+	if !f.Versioned {
+		if len(nonce) != LegacyNonceLength {
+			return nil, fmt.Errorf("Nonce length %d is not supported by legacy framing", len(nonce))
+		}
+		return []byte(nonce), nil
+	}
+
+	if len(nonce) == 0 || len(nonce) > math.MaxUint8 {
+		return nil, fmt.Errorf("Nonce length %d is not supported", len(nonce))
+	}
+
+	header := []byte{FramingVersion, byte(len(nonce)), byte(f.SequenceWidth)}
+	return append(header, nonce...), nil
+}
+
+// DecodePayloadHeader parses the data at the start of a payload message with
+// the given type, returning the framing and nonce, and the remaining data
+// containing the events
+func DecodePayloadHeader(messageType string, message []byte) (Framing, string, []byte, error) {
+	if messageType == "JDAT" || messageType == "EVNT" {
+		if len(message) < LegacyNonceLength {
+			return Framing{}, "", nil, fmt.Errorf("Payload too small (%d)", len(message))
+		}
+		return LegacyFraming, string(message[:LegacyNonceLength]), message[LegacyNonceLength:], nil
+	}
+
+	framing, nonceLength, err := decodeVersionedHeader(message)
+	if err != nil {
+		return Framing{}, "", nil, err
+	}
+
+	if len(message) < 3+nonceLength {
+		return Framing{}, "", nil, fmt.Errorf("Payload too small (%d)", len(message))
+	}
+
+	return framing, string(message[3 : 3+nonceLength]), message[3+nonceLength:], nil
+}
+
+// EncodeAck builds a complete acknowledgement message for the given nonce and
+// sequence using this framing
+func (f Framing) EncodeAck(nonce string, sequence uint64) []byte {
+	if !f.Versioned {
+		message := make([]byte, 8+LegacyNonceLength+LegacySequenceWidth)
+		copy(message[0:4], "ACKN")
+		binary.BigEndian.PutUint32(message[4:8], LegacyNonceLength+LegacySequenceWidth)
+		copy(message[8:8+LegacyNonceLength], nonce)
+		binary.BigEndian.PutUint32(message[8+LegacyNonceLength:], uint32(sequence))
+		return message
+	}
+
+	length := 3 + len(nonce) + f.SequenceWidth
+	message := make([]byte, 8, 8+length)
+	copy(message[0:4], "ACKV")
+	binary.BigEndian.PutUint32(message[4:8], uint32(length))
+	message = append(message, FramingVersion, byte(len(nonce)), byte(f.SequenceWidth))
+	message = append(message, nonce...)
+
+	// Sequence is big endian, right aligned within the sequence width
+	var encoded [8]byte
+	binary.BigEndian.PutUint64(encoded[:], sequence)
+	return append(message, encoded[8-f.SequenceWidth:]...)
+}
+
+// DecodeAck parses an acknowledgement message with the given type, returning
+// the nonce and sequence
+func DecodeAck(messageType string, message []byte) (string, uint64, error) {
+	if messageType == "ACKN" {
+		if len(message) != LegacyNonceLength+LegacySequenceWidth {
+			return "", 0, fmt.Errorf("Corrupt message (ACKN size %d != %d)", len(message), LegacyNonceLength+LegacySequenceWidth)
+		}
+		return string(message[:LegacyNonceLength]), uint64(binary.BigEndian.Uint32(message[LegacyNonceLength:])), nil
+	}
+
+	framing, nonceLength, err := decodeVersionedHeader(message)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if len(message) != 3+nonceLength+framing.SequenceWidth {
+		return "", 0, fmt.Errorf("Corrupt message (ACKV size %d != %d)", len(message), 3+nonceLength+framing.SequenceWidth)
+	}
+
+	var sequence uint64
+	for _, b := range message[3+nonceLength:] {
+		sequence = sequence<<8 | uint64(b)
+	}
+	return string(message[3 : 3+nonceLength]), sequence, nil
+}
+
+// decodeVersionedHeader parses the version, nonce length and sequence width at
+// the start of a versioned message
+func decodeVersionedHeader(message []byte) (Framing, int, error) {
+	if len(message) < 3 {
+		return Framing{}, 0, fmt.Errorf("Message too small (%d)", len(message))
+	}
+
+	if message[0] != FramingVersion {
+		return Framing{}, 0, fmt.Errorf("Unsupported framing version (%d)", message[0])
+	}
+
+	nonceLength, sequenceWidth := int(message[1]), int(message[2])
+	if nonceLength == 0 {
+		return Framing{}, 0, fmt.Errorf("Invalid nonce length (%d)", nonceLength)
+	}
+	if sequenceWidth < LegacySequenceWidth || sequenceWidth > 8 {
+		return Framing{}, 0, fmt.Errorf("Invalid sequence width (%d)", sequenceWidth)
+	}
+
+	return Framing{Versioned: true, SequenceWidth: sequenceWidth}, nonceLength, nil
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFramingForNonce(t *testing.T) {
+	if framing := FramingForNonce("0123456789abcdef"); framing != LegacyFraming {
+		t.Errorf("16-byte nonce did not use legacy framing: %v", framing)
+	}
+
+	if framing := FramingForNonce("0123456789abcdef0123"); !framing.Versioned {
+		t.Errorf("20-byte nonce did not use versioned framing: %v", framing)
+	}
+}
+
+func TestLegacyPayloadHeader(t *testing.T) {
+	nonce := "0123456789abcdef"
+	header, err := LegacyFraming.EncodePayloadHeader(nonce)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if string(header) != nonce {
+		t.Errorf("Unexpected legacy header: %q", header)
+	}
+
+	if LegacyFraming.PayloadType(true) != "JDAT" || LegacyFraming.PayloadType(false) != "EVNT" {
+		t.Error("Unexpected legacy payload types")
+	}
+
+	framing, decodedNonce, data, err := DecodePayloadHeader("JDAT", append(header, "data"...))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if framing != LegacyFraming || decodedNonce != nonce || string(data) != "data" {
+		t.Errorf("Unexpected decode: %v %q %q", framing, decodedNonce, data)
+	}
+
+	if _, err := LegacyFraming.EncodePayloadHeader("short"); err == nil {
+		t.Error("Legacy framing accepted a short nonce")
+	}
+	if _, _, _, err := DecodePayloadHeader("JDAT", []byte("short")); err == nil {
+		t.Error("Legacy framing decoded a short payload")
+	}
+}
+
+func TestVersionedPayloadHeader(t *testing.T) {
+	nonce := "0123456789abcdef0123456789abcdef"
+	framing := Framing{Versioned: true, SequenceWidth: 8}
+	header, err := framing.EncodePayloadHeader(nonce)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if !bytes.Equal(header[:3], []byte{FramingVersion, 32, 8}) {
+		t.Errorf("Unexpected versioned header: %q", header)
+	}
+
+	if framing.PayloadType(true) != "JDAV" || framing.PayloadType(false) != "EVNV" {
+		t.Error("Unexpected versioned payload types")
+	}
+
+	decoded, decodedNonce, data, err := DecodePayloadHeader("JDAV", append(header, "data"...))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if decoded != framing || decodedNonce != nonce || string(data) != "data" {
+		t.Errorf("Unexpected decode: %v %q %q", decoded, decodedNonce, data)
+	}
+
+	for _, invalid := range [][]byte{
+		{FramingVersion, 4},
+		{FramingVersion + 1, 4, 4, 'a', 'b', 'c', 'd'},
+		{FramingVersion, 0, 4},
+		{FramingVersion, 4, 9, 'a', 'b', 'c', 'd'},
+		{FramingVersion, 4, 4, 'a', 'b'},
+	} {
+		if _, _, _, err := DecodePayloadHeader("JDAV", invalid); err == nil {
+			t.Errorf("Invalid header decoded successfully: %v", invalid)
+		}
+	}
+}
+
+func TestLegacyAck(t *testing.T) {
+	nonce := "0123456789abcdef"
+	message := LegacyFraming.EncodeAck(nonce, 258)
+
+	expected := append([]byte("ACKN\x00\x00\x00\x14"+nonce), 0, 0, 1, 2)
+	if !bytes.Equal(message, expected) {
+		t.Errorf("Unexpected legacy ACKN: %q", message)
+	}
+
+	decodedNonce, sequence, err := DecodeAck("ACKN", message[8:])
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if decodedNonce != nonce || sequence != 258 {
+		t.Errorf("Unexpected decode: %q %d", decodedNonce, sequence)
+	}
+
+	if _, _, err := DecodeAck("ACKN", message[8:27]); err == nil {
+		t.Error("Short ACKN decoded successfully")
+	}
+}
+
+func TestVersionedAck(t *testing.T) {
+	nonce := "01234567"
+	framing := Framing{Versioned: true, SequenceWidth: 8}
+	message := framing.EncodeAck(nonce, 258)
+
+	expected := append([]byte("ACKV\x00\x00\x00\x13\x01\x08\x08"+nonce), 0, 0, 0, 0, 0, 0, 1, 2)
+	if !bytes.Equal(message, expected) {
+		t.Errorf("Unexpected versioned ACKV: %q", message)
+	}
+
+	decodedNonce, sequence, err := DecodeAck("ACKV", message[8:])
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if decodedNonce != nonce || sequence != 258 {
+		t.Errorf("Unexpected decode: %q %d", decodedNonce, sequence)
+	}
+
+	// Sequence must match the declared width exactly
+	if _, _, err := DecodeAck("ACKV", message[8:len(message)-1]); err == nil {
+		t.Error("Truncated ACKV decoded successfully")
+	}
+
+	// Sequence widths shorter than the legacy width are not supported
+	short := append([]byte{FramingVersion, 1, 2, 'a'}, 1, 2)
+	if _, _, err := DecodeAck("ACKV", short); err == nil {
+		t.Error("ACKV with a 2-byte sequence decoded successfully")
+	}
+}
+
+func TestVersionedAckWide(t *testing.T) {
+	nonce := "01234567"
+	framing := Framing{Versioned: true, SequenceWidth: 8}
+
+	var sequence uint64 = 0x0102030405060708
+	message := framing.EncodeAck(nonce, sequence)
+
+	expected := append([]byte("ACKV\x00\x00\x00\x13\x01\x08\x08"+nonce), 1, 2, 3, 4, 5, 6, 7, 8)
+	if !bytes.Equal(message, expected) {
+		t.Errorf("Unexpected versioned ACKV: %q", message)
+	}
+
+	decodedNonce, decoded, err := DecodeAck("ACKV", message[8:])
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if decodedNonce != nonce || decoded != sequence {
+		t.Errorf("Unexpected decode: %q %d", decodedNonce, decoded)
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"regexp"
 	"time"
 
+	"github.com/driskell/log-courier/lc-lib/audit"
 	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/core"
 	"github.com/driskell/log-courier/lc-lib/transports"
@@ -42,8 +43,33 @@ var (
 )
 
 const (
-	defaultNetworkReconnect    time.Duration = 0 * time.Second
-	defaultNetworkReconnectMax time.Duration = 300 * time.Second
+	// ProtocolCourier is the protocol name for the Log Courier protocol
+	ProtocolCourier = "courier"
+	// ProtocolNDJSON is the protocol name for newline delimited JSON
+	ProtocolNDJSON = "ndjson"
+	// ProtocolSyslog is the protocol name for RFC5424 syslog
+	ProtocolSyslog = "syslog"
+)
+
+const (
+	defaultNetworkClockSkew        bool          = false
+	defaultNetworkCompressStats    bool          = false
+	defaultNetworkMaxClockSkew     time.Duration = 1 * time.Second
+	defaultNetworkMinCompressBytes int64         = 0
+	defaultNetworkMinTLSVersion    string        = "1.2"
+	defaultNetworkNDJSONAck        bool          = false
+	defaultNetworkProtocol         string        = ProtocolCourier
+	defaultNetworkReconnect        time.Duration = 0 * time.Second
+	defaultNetworkReconnectMax     time.Duration = 300 * time.Second
+	defaultNetworkSSLSessionCache  int64         = 64
+	defaultNetworkSSLVerify        bool          = true
+	defaultNetworkSSLVerifyUsage   bool          = true
+	defaultNetworkStreamCompress   string        = StreamCompressionNone
+	defaultNetworkSyslogAppName    string        = "log-courier"
+	defaultNetworkSyslogFacility   string        = "user"
+	defaultNetworkSyslogHostField  string        = "host"
+	defaultNetworkSyslogMsgField   string        = "message"
+	defaultNetworkSyslogSeverity   string        = "info"
 )
 
 // TransportTCPFactory holds the configuration from the configuration file
@@ -51,14 +77,59 @@ const (
 type TransportTCPFactory struct {
 	transport string
 
-	Reconnect      time.Duration `config:"reconnect backoff"`
-	ReconnectMax   time.Duration `config:"reconnect backoff max"`
-	SSLCertificate string        `config:"ssl certificate"`
-	SSLKey         string        `config:"ssl key"`
-	SSLCA          string        `config:"ssl ca"`
+	CipherSuites     []string      `config:"cipher suites"`
+	ClockSkew        bool          `config:"clock skew"`
+	CompressStats    bool          `config:"compression stats"`
+	MaxClockSkew     time.Duration `config:"max clock skew"`
+	MinCompressBytes int64         `config:"min compress bytes"`
+	MinTLSVersion    string        `config:"min tls version"`
+	NDJSONAck        bool          `config:"ndjson ack"`
+	Protocol         string        `config:"protocol"`
+	Reconnect        time.Duration `config:"reconnect backoff"`
+	ReconnectMax     time.Duration `config:"reconnect backoff max"`
+	SSLCertificate   string        `config:"ssl certificate"`
+	SSLKey           string        `config:"ssl key"`
+	SSLKeyPassphrase string        `config:"ssl key passphrase"`
+	SSLCA            string        `config:"ssl ca"`
+	SSLServerName    string        `config:"ssl server name"`
+	SSLSessionCache  int64         `config:"ssl session cache"`
+	SSLVerify        bool          `config:"ssl verify"`
+	SSLVerifyUsage   bool          `config:"ssl verify key usage"`
+	StreamCompress   string        `config:"stream compression"`
+	KeepAlive        bool          `config:"tcp keepalive"`
+	KeepAlivePeriod  time.Duration `config:"tcp keepalive interval"`
+
+	// Options for the syslog protocol
+	SyslogAppName   string                 `config:"syslog app name"`
+	SyslogFacility  string                 `config:"syslog facility"`
+	SyslogHostField string                 `config:"syslog hostname field"`
+	SyslogMsgField  string                 `config:"syslog message field"`
+	SyslogSeverity  string                 `config:"syslog severity"`
+	SyslogSevField  string                 `config:"syslog severity field"`
+	SyslogSD        []syslogStructuredData `config:"syslog structured data"`
 
 	hostportRegexp  *regexp.Regexp
 	netConfig       *config.Network
+	audit           *audit.Config
+	certificate     *tls.Certificate
+	certificateList []*x509.Certificate
+	caList          []*x509.Certificate
+	servers         map[string]*transportTCPServer
+	tlsMinVersion   uint16
+	tlsCipherSuites []uint16
+	syslogFacility  int
+	syslogSeverity  int
+}
+
+// transportTCPServer holds the TLS configuration for a single network server
+// that overrides the transport level TLS configuration
+type transportTCPServer struct {
+	SSLCertificate   string `config:"ssl certificate"`
+	SSLKey           string `config:"ssl key"`
+	SSLKeyPassphrase string `config:"ssl key passphrase"`
+	SSLCA            string `config:"ssl ca"`
+	SSLServerName    string `config:"ssl server name"`
+
 	certificate     *tls.Certificate
 	certificateList []*x509.Certificate
 	caList          []*x509.Certificate
@@ -73,79 +144,277 @@ func NewTransportTCPFactory(config *config.Config, configPath string, unUsed map
 		transport:      name,
 		hostportRegexp: regexp.MustCompile(`^\[?([^]]+)\]?:([0-9]+)$`),
 		netConfig:      &config.Network,
+		audit:          config.Get("audit").(*audit.Config),
+	}
+
+	if err = config.PopulateConfig(ret, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if ret.KeepAlivePeriod < 0 {
+		return nil, errors.New("tcp keepalive interval can not be negative")
+	}
+
+	if ret.KeepAlivePeriod != 0 && !ret.KeepAlive {
+		return nil, errors.New("tcp keepalive interval is only valid when tcp keepalive is enabled")
+	}
+
+	if ret.MinCompressBytes < 0 {
+		return nil, errors.New("min compress bytes can not be negative")
+	}
+
+	switch ret.Protocol {
+	case ProtocolCourier:
+		if ret.NDJSONAck {
+			return nil, errors.New("ndjson ack is only valid when protocol is ndjson")
+		}
+	case ProtocolNDJSON, ProtocolSyslog:
+		if ret.Protocol == ProtocolSyslog && ret.NDJSONAck {
+			return nil, errors.New("ndjson ack is only valid when protocol is ndjson")
+		}
+		if ret.MinCompressBytes != 0 {
+			return nil, errors.New("min compress bytes is only valid when protocol is courier")
+		}
+		if ret.ClockSkew {
+			return nil, errors.New("clock skew is only valid when protocol is courier")
+		}
+		if ret.CompressStats {
+			return nil, errors.New("compression stats is only valid when protocol is courier")
+		}
+		if ret.StreamCompress != StreamCompressionNone {
+			return nil, errors.New("stream compression is only valid when protocol is courier")
+		}
+	default:
+		return nil, fmt.Errorf("protocol must be \"%s\", \"%s\" or \"%s\"", ProtocolCourier, ProtocolNDJSON, ProtocolSyslog)
+	}
+
+	if ret.Protocol == ProtocolSyslog {
+		if err = ret.initSyslog(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch ret.StreamCompress {
+	case StreamCompressionNone, StreamCompressionZlib:
+	default:
+		return nil, fmt.Errorf("stream compression must be \"%s\" or \"%s\"", StreamCompressionNone, StreamCompressionZlib)
 	}
 
 	// Only allow SSL configurations if using TLS
 	if name == TransportTCPTLS {
-		if err = config.PopulateConfig(ret, unUsed, configPath); err != nil {
+		if ret.SSLSessionCache < 0 {
+			return nil, errors.New("ssl session cache can not be negative")
+		}
+
+		if err = ret.initTLSOptions(); err != nil {
 			return nil, err
 		}
 
-		if len(ret.SSLCertificate) > 0 || len(ret.SSLKey) > 0 {
-			if len(ret.SSLCertificate) == 0 {
-				return nil, errors.New("ssl key is only valid with a matching ssl certificate")
+		if ret.certificate, ret.certificateList, err = loadClientCertificate(ret.SSLCertificate, ret.SSLKey, ret.SSLKeyPassphrase); err != nil {
+			return nil, err
+		}
+
+		if !ret.SSLVerify {
+			if len(ret.SSLCA) != 0 {
+				return nil, errors.New("ssl ca can not be specified when ssl verify is disabled")
 			}
 
-			if len(ret.SSLKey) == 0 {
-				return nil, errors.New("ssl key must be specified when a ssl certificate is provided")
+			log.Warning("Certificate verification is disabled by \"ssl verify\": the identity of endpoints will not be checked, which must never be used in production")
+		} else if len(ret.SSLCA) != 0 {
+			if ret.caList, err = loadCAList(ret.SSLCA); err != nil {
+				return nil, err
 			}
+		}
 
-			certificate, err := tls.LoadX509KeyPair(ret.SSLCertificate, ret.SSLKey)
-			if err != nil {
-				return nil, fmt.Errorf("Failed loading client ssl certificate: %s", err)
+		if err = ret.loadServers(config, configPath); err != nil {
+			return nil, err
+		}
+	} else if len(ret.SSLCertificate) > 0 || len(ret.SSLKey) > 0 || len(ret.SSLKeyPassphrase) > 0 || len(ret.SSLCA) > 0 || len(ret.SSLServerName) > 0 || !ret.SSLVerify {
+		return nil, errors.New("ssl options are only valid when transport is TLS")
+	} else if len(ret.CipherSuites) > 0 {
+		return nil, errors.New("cipher suites is only valid when transport is TLS")
+	} else if len(ret.netConfig.ServerOptions) != 0 {
+		return nil, errors.New("server options are only valid when transport is TLS")
+	}
+
+	return ret, nil
+}
+
+// initTLSOptions parses the minimum TLS version and the cipher suites
+func (f *TransportTCPFactory) initTLSOptions() error {
+	var err error
+
+	if f.tlsMinVersion, err = config.ParseTLSVersion(f.MinTLSVersion); err != nil {
+		return err
+	}
+
+	if f.tlsCipherSuites, err = config.ParseCipherSuites(f.CipherSuites); err != nil {
+		return fmt.Errorf("cipher suites is not valid: %s", err)
+	}
+
+	return nil
+}
+
+// loadServers loads the TLS configuration of each network server that has
+// options, falling back to the transport level configuration for anything not
+// overridden. Every server must end up with a CA to verify it against, unless
+// verification is disabled
+func (f *TransportTCPFactory) loadServers(config *config.Config, configPath string) error {
+	f.servers = make(map[string]*transportTCPServer)
+
+	if len(f.netConfig.Servers) == 0 && len(f.caList) == 0 && f.SSLVerify {
+		return errors.New("ssl ca is required when transport is TLS")
+	}
+
+	for _, server := range f.netConfig.Servers {
+		options, ok := f.netConfig.ServerOptions[server]
+		if !ok {
+			if len(f.caList) == 0 && f.SSLVerify {
+				return errors.New("ssl ca is required when transport is TLS")
 			}
+			continue
+		}
 
-			ret.certificate = &certificate
+		serverConfig := &transportTCPServer{}
+		if err := config.PopulateConfig(serverConfig, options, fmt.Sprintf("%sservers/%s/", configPath, server)); err != nil {
+			return err
+		}
 
-			for _, certBytes := range ret.certificate.Certificate {
-				thisCert, err := x509.ParseCertificate(certBytes)
-				if err != nil {
-					return nil, fmt.Errorf("Failed loading client ssl certificate: %s", err)
-				}
-				ret.certificateList = append(ret.certificateList, thisCert)
+		var err error
+		if len(serverConfig.SSLCertificate) > 0 || len(serverConfig.SSLKey) > 0 || len(serverConfig.SSLKeyPassphrase) > 0 {
+			if serverConfig.certificate, serverConfig.certificateList, err = loadClientCertificate(serverConfig.SSLCertificate, serverConfig.SSLKey, serverConfig.SSLKeyPassphrase); err != nil {
+				return fmt.Errorf("%s (server %s)", err, server)
 			}
+		} else {
+			serverConfig.certificate, serverConfig.certificateList = f.certificate, f.certificateList
 		}
 
-		if len(ret.SSLCA) == 0 {
-			return nil, errors.New("ssl ca is required when transport is TLS")
+		if len(serverConfig.SSLCA) > 0 {
+			if !f.SSLVerify {
+				return fmt.Errorf("ssl ca can not be specified when ssl verify is disabled (server %s)", server)
+			}
+			if serverConfig.caList, err = loadCAList(serverConfig.SSLCA); err != nil {
+				return fmt.Errorf("%s (server %s)", err, server)
+			}
+		} else if len(f.caList) == 0 && f.SSLVerify {
+			return fmt.Errorf("ssl ca is required when transport is TLS (server %s)", server)
+		} else {
+			serverConfig.caList = f.caList
 		}
 
-		pemdata, err := ioutil.ReadFile(ret.SSLCA)
+		f.servers[server] = serverConfig
+	}
+
+	return nil
+}
+
+// serverTLS returns the client certificate and CA list to use for the given
+// network server
+func (f *TransportTCPFactory) serverTLS(server string) (*tls.Certificate, []*x509.Certificate, []*x509.Certificate) {
+	if serverConfig, ok := f.servers[server]; ok {
+		return serverConfig.certificate, serverConfig.certificateList, serverConfig.caList
+	}
+	return f.certificate, f.certificateList, f.caList
+}
+
+// serverName returns the name to verify the certificate of the given network
+// server against, falling back to the host from the address pool when no
+// ssl server name is configured
+func (f *TransportTCPFactory) serverName(server string, host string) string {
+	if serverConfig, ok := f.servers[server]; ok && serverConfig.SSLServerName != "" {
+		return serverConfig.SSLServerName
+	}
+	if f.SSLServerName != "" {
+		return f.SSLServerName
+	}
+	return host
+}
+
+// loadClientCertificate loads a client certificate and its key, returning nil
+// if no certificate was configured
+func loadClientCertificate(certFile string, keyFile string, passphrase string) (*tls.Certificate, []*x509.Certificate, error) {
+	if len(certFile) == 0 && len(keyFile) == 0 && len(passphrase) == 0 {
+		return nil, nil, nil
+	}
+
+	if len(certFile) == 0 {
+		return nil, nil, errors.New("ssl key is only valid with a matching ssl certificate")
+	}
+
+	if len(keyFile) == 0 {
+		return nil, nil, errors.New("ssl key must be specified when a ssl certificate is provided")
+	}
+
+	certificate, err := core.LoadX509KeyPair(certFile, keyFile, core.ExpandSecret(passphrase))
+	if err == core.ErrIncorrectPassphrase {
+		return nil, nil, fmt.Errorf("Failed loading client ssl certificate: ssl key passphrase is incorrect for %s", keyFile)
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("Failed loading client ssl certificate: %s", err)
+	}
+
+	var certificateList []*x509.Certificate
+	for _, certBytes := range certificate.Certificate {
+		thisCert, err := x509.ParseCertificate(certBytes)
 		if err != nil {
-			return nil, fmt.Errorf("Failure reading CA certificate: %s\n", err)
-		}
-		rest := pemdata
-		var block *pem.Block
-		var pemBlockNum = 1
-		for {
-			block, rest = pem.Decode(rest)
-			if block != nil {
-				if block.Type != "CERTIFICATE" {
-					return nil, fmt.Errorf("Block %d does not contain a certificate: %s\n", pemBlockNum, ret.SSLCA)
-				}
-				cert, err := x509.ParseCertificate(block.Bytes)
-				if err != nil {
-					return nil, fmt.Errorf("Failed to parse CA certificate in block %d: %s\n", pemBlockNum, ret.SSLCA)
-				}
-				ret.caList = append(ret.caList, cert)
-				pemBlockNum++
-			} else {
-				break
-			}
+			return nil, nil, fmt.Errorf("Failed loading client ssl certificate: %s", err)
 		}
-	} else {
-		if err := config.ReportUnusedConfig(unUsed, configPath); err != nil {
-			return nil, err
+		certificateList = append(certificateList, thisCert)
+	}
+
+	return &certificate, certificateList, nil
+}
+
+// loadCAList loads the certificates from a CA file
+func loadCAList(caFile string) ([]*x509.Certificate, error) {
+	pemdata, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failure reading CA certificate: %s\n", err)
+	}
+
+	var caList []*x509.Certificate
+	rest := pemdata
+	var block *pem.Block
+	var pemBlockNum = 1
+	for {
+		block, rest = pem.Decode(rest)
+		if block != nil {
+			if block.Type != "CERTIFICATE" {
+				return nil, fmt.Errorf("Block %d does not contain a certificate: %s\n", pemBlockNum, caFile)
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse CA certificate in block %d: %s\n", pemBlockNum, caFile)
+			}
+			caList = append(caList, cert)
+			pemBlockNum++
+		} else {
+			break
 		}
 	}
 
-	return ret, nil
+	return caList, nil
 }
 
 // InitDefaults sets the default configuration values
 func (f *TransportTCPFactory) InitDefaults() {
+	f.ClockSkew = defaultNetworkClockSkew
+	f.CompressStats = defaultNetworkCompressStats
+	f.MaxClockSkew = defaultNetworkMaxClockSkew
+	f.MinCompressBytes = defaultNetworkMinCompressBytes
+	f.MinTLSVersion = defaultNetworkMinTLSVersion
+	f.NDJSONAck = defaultNetworkNDJSONAck
+	f.Protocol = defaultNetworkProtocol
 	f.Reconnect = defaultNetworkReconnect
 	f.ReconnectMax = defaultNetworkReconnectMax
+	f.SSLSessionCache = defaultNetworkSSLSessionCache
+	f.SSLVerify = defaultNetworkSSLVerify
+	f.SSLVerifyUsage = defaultNetworkSSLVerifyUsage
+	f.StreamCompress = defaultNetworkStreamCompress
+	f.SyslogAppName = defaultNetworkSyslogAppName
+	f.SyslogFacility = defaultNetworkSyslogFacility
+	f.SyslogHostField = defaultNetworkSyslogHostField
+	f.SyslogMsgField = defaultNetworkSyslogMsgField
+	f.SyslogSeverity = defaultNetworkSyslogSeverity
 }
 
 // NewTransport returns a new Transport interface using the settings from the
@@ -159,6 +428,12 @@ func (f *TransportTCPFactory) NewTransport(observer transports.Observer, finishO
 		backoff:        core.NewExpBackoff(observer.Pool().Server()+" Reconnect", f.Reconnect, f.ReconnectMax),
 	}
 
+	// Share a session cache across reconnects so they can resume the previous
+	// TLS session instead of performing a full handshake
+	if f.transport == TransportTCPTLS && f.SSLSessionCache != 0 {
+		ret.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(int(f.SSLSessionCache))
+	}
+
 	go ret.controller()
 
 	return ret
@@ -168,4 +443,7 @@ func (f *TransportTCPFactory) NewTransport(observer transports.Observer, finishO
 func init() {
 	config.RegisterTransport(TransportTCPTCP, NewTransportTCPFactory)
 	config.RegisterTransport(TransportTCPTLS, NewTransportTCPFactory)
+	config.RegisterTransportProtocol(ProtocolCourier, "Log Courier protocol with compression and acknowledgement of delivery (tcp, tls)")
+	config.RegisterTransportProtocol(ProtocolNDJSON, "newline delimited JSON, LOWER DURABILITY: events are acknowledged once written unless \"ndjson ack\" is enabled and supported by the remote (tcp, tls)")
+	config.RegisterTransportProtocol(ProtocolSyslog, "RFC5424 syslog with octet-counting framing, LOWER DURABILITY: events are acknowledged once written (tcp, tls)")
 }
@@ -0,0 +1,234 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+func writeTestCA(t *testing.T, dir string, name string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+
+	path := filepath.Join(dir, name+".crt")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %s", err)
+	}
+
+	return path
+}
+
+func createTestFactory(servers []string, serverOptions map[string]map[string]interface{}, options map[string]interface{}) (*TransportTCPFactory, error) {
+	cfg := config.NewConfig()
+	cfg.Network.Servers = servers
+	cfg.Network.ServerOptions = serverOptions
+
+	factory, err := NewTransportTCPFactory(cfg, "/network/", options, TransportTCPTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	return factory.(*TransportTCPFactory), nil
+}
+
+func TestServerTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "factory")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defaultCA := writeTestCA(t, dir, "default")
+	serverCA := writeTestCA(t, dir, "server")
+
+	factory, err := createTestFactory(
+		[]string{"first:1234", "second:1234"},
+		map[string]map[string]interface{}{"second:1234": {"ssl ca": serverCA}},
+		map[string]interface{}{"ssl ca": defaultCA},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if _, _, caList := factory.serverTLS("first:1234"); len(caList) != 1 || caList[0].Subject.CommonName != "default" {
+		t.Errorf("Server without options did not use the transport CA")
+	}
+
+	if _, _, caList := factory.serverTLS("second:1234"); len(caList) != 1 || caList[0].Subject.CommonName != "server" {
+		t.Errorf("Server with options did not use its own CA")
+	}
+}
+
+func TestServerTLSMissingCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "factory")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	serverCA := writeTestCA(t, dir, "server")
+
+	// Every server has its own CA so the transport does not need one
+	if _, err := createTestFactory(
+		[]string{"first:1234"},
+		map[string]map[string]interface{}{"first:1234": {"ssl ca": serverCA}},
+		map[string]interface{}{},
+	); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	if _, err := createTestFactory(
+		[]string{"first:1234", "second:1234"},
+		map[string]map[string]interface{}{"first:1234": {"ssl ca": serverCA}},
+		map[string]interface{}{},
+	); err == nil {
+		t.Errorf("Server without a CA was accepted")
+	}
+
+	if _, err := createTestFactory(
+		[]string{"first:1234"},
+		map[string]map[string]interface{}{"first:1234": {"ssl ca": serverCA, "ssl unknown": "x"}},
+		map[string]interface{}{},
+	); err == nil {
+		t.Errorf("Unknown server option was accepted")
+	}
+}
+
+func TestKeepAliveOptions(t *testing.T) {
+	cfg := config.NewConfig()
+
+	factory, err := NewTransportTCPFactory(cfg, "/network/", map[string]interface{}{
+		"tcp keepalive":          true,
+		"tcp keepalive interval": 30 * time.Second,
+	}, TransportTCPTCP)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if tcpFactory := factory.(*TransportTCPFactory); !tcpFactory.KeepAlive || tcpFactory.KeepAlivePeriod != 30*time.Second {
+		t.Errorf("Incorrect keepalive configuration: %v %v", tcpFactory.KeepAlive, tcpFactory.KeepAlivePeriod)
+	}
+
+	_, err = NewTransportTCPFactory(cfg, "/network/", map[string]interface{}{
+		"tcp keepalive interval": 30 * time.Second,
+	}, TransportTCPTCP)
+	if err == nil {
+		t.Error("Interval without keepalive enabled was accepted")
+	}
+}
+
+func TestTLSOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "factory")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := writeTestCA(t, dir, "default")
+
+	factory, err := createTestFactory([]string{"first:1234"}, nil, map[string]interface{}{"ssl ca": ca})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if factory.tlsMinVersion != tls.VersionTLS12 || factory.tlsCipherSuites != nil {
+		t.Errorf("Incorrect default TLS options: %d %v", factory.tlsMinVersion, factory.tlsCipherSuites)
+	}
+
+	factory, err = createTestFactory([]string{"first:1234"}, nil, map[string]interface{}{
+		"ssl ca":          ca,
+		"min tls version": "1.3",
+		"cipher suites":   []interface{}{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if factory.tlsMinVersion != tls.VersionTLS13 || len(factory.tlsCipherSuites) != 1 || factory.tlsCipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("Incorrect TLS options: %d %v", factory.tlsMinVersion, factory.tlsCipherSuites)
+	}
+
+	if _, err := createTestFactory([]string{"first:1234"}, nil, map[string]interface{}{"ssl ca": ca, "min tls version": "1.5"}); err == nil {
+		t.Error("Unknown TLS version was accepted")
+	}
+
+	if _, err := createTestFactory([]string{"first:1234"}, nil, map[string]interface{}{"ssl ca": ca, "cipher suites": []interface{}{"unknown"}}); err == nil {
+		t.Error("Unknown cipher suite was accepted")
+	}
+
+	if _, err := NewTransportTCPFactory(config.NewConfig(), "/network/", map[string]interface{}{"cipher suites": []interface{}{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}, TransportTCPTCP); err == nil {
+		t.Error("Cipher suites without TLS was accepted")
+	}
+}
+
+func TestSSLVerifyDisabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "factory")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := writeTestCA(t, dir, "default")
+
+	// No CA is required when verification is disabled
+	if _, err := createTestFactory([]string{"first:1234"}, nil, map[string]interface{}{"ssl verify": false}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	if _, err := createTestFactory([]string{"first:1234"}, nil, map[string]interface{}{"ssl verify": false, "ssl ca": ca}); err == nil {
+		t.Error("ssl ca was accepted with verification disabled")
+	}
+
+	if _, err := createTestFactory(
+		[]string{"first:1234"},
+		map[string]map[string]interface{}{"first:1234": {"ssl ca": ca}},
+		map[string]interface{}{"ssl verify": false},
+	); err == nil {
+		t.Error("Server ssl ca was accepted with verification disabled")
+	}
+
+	if _, err := NewTransportTCPFactory(config.NewConfig(), "/network/", map[string]interface{}{"ssl verify": false}, TransportTCPTCP); err == nil {
+		t.Error("ssl verify without TLS was accepted")
+	}
+}
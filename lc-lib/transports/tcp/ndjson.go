@@ -0,0 +1,200 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * This file is a modification of code from Logstash Forwarder.
+ * Copyright 2012-2013 Jordan Sissel and contributors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+const (
+	// ndjsonMaxAckLength is the longest acknowledgement line accepted from the
+	// remote before it is considered a protocol error
+	ndjsonMaxAckLength = 64
+)
+
+// ndjsonPending tracks a payload written under the ndjson protocol that is
+// awaiting line count acknowledgements from the remote
+type ndjsonPending struct {
+	nonce  string
+	events uint32
+	acked  uint32
+}
+
+// writeNDJSON queues the events to be written as newline delimited JSON. There
+// is no framing, compression or nonce. Unless the remote sends line count
+// acknowledgements the payload is acknowledged as soon as it is written
+func (t *TransportTCP) writeNDJSON(nonce string, events []*core.EventDescriptor) error {
+	var messageBuffer bytes.Buffer
+
+	for _, event := range events {
+		messageBuffer.Write(event.Event)
+		messageBuffer.WriteByte('\n')
+	}
+
+	if t.config.NDJSONAck {
+		t.ndjsonMutex.Lock()
+		t.ndjsonPending = append(t.ndjsonPending, &ndjsonPending{nonce: nonce, events: uint32(len(events))})
+		t.ndjsonMutex.Unlock()
+
+		t.sendChan <- &tcpMessage{data: messageBuffer.Bytes()}
+		return nil
+	}
+
+	t.sendChan <- &tcpMessage{
+		data:  messageBuffer.Bytes(),
+		event: transports.NewAckEvent(t.observer, nonce, uint64(len(events))),
+	}
+	return nil
+}
+
+// ndjsonReceiver handles socket reads under the ndjson and syslog protocols. If
+// line count acknowledgements are enabled each line received is the number of
+// events the remote has processed since its previous acknowledgement.
+// Otherwise anything received is discarded, and reading only serves to detect
+// disconnection
+func (t *TransportTCP) ndjsonReceiver() {
+	defer func() {
+		t.wait.Done()
+	}()
+
+	var err error
+	var shutdown bool
+	var line []byte
+
+	buffer := make([]byte, 4096)
+
+ReceiverLoop:
+	for {
+		var length int
+		if length, shutdown, err = t.receiverReadSome(buffer); shutdown || err != nil {
+			break
+		}
+
+		if !t.config.NDJSONAck {
+			continue
+		}
+
+		line = append(line, buffer[:length]...)
+		for {
+			end := bytes.IndexByte(line, '\n')
+			if end == -1 {
+				break
+			}
+
+			if err = t.ndjsonAck(bytes.TrimSpace(line[:end])); err != nil {
+				break ReceiverLoop
+			}
+
+			line = line[end+1:]
+		}
+
+		if len(line) > ndjsonMaxAckLength {
+			err = fmt.Errorf("Protocol error: Acknowledgement line too long")
+			break
+		}
+	}
+
+	if err != nil {
+		// Pass the error back and abort
+	FailLoop:
+		for {
+			select {
+			case <-t.recvControl:
+				// Shutdown
+				break FailLoop
+			case t.failChan <- err:
+			}
+		}
+	}
+}
+
+// ndjsonAck processes a line count acknowledgement, acknowledging the given
+// number of events across the pending payloads in the order they were written
+func (t *TransportTCP) ndjsonAck(line []byte) error {
+	count, err := strconv.ParseUint(string(line), 10, 32)
+	if err != nil {
+		return fmt.Errorf("Protocol error: Invalid acknowledgement: %q", line)
+	}
+
+	for count > 0 {
+		t.ndjsonMutex.Lock()
+		if len(t.ndjsonPending) == 0 {
+			t.ndjsonMutex.Unlock()
+			return fmt.Errorf("Protocol error: Acknowledgement for more events than were sent")
+		}
+
+		pending := t.ndjsonPending[0]
+		acked := pending.events - pending.acked
+		if uint64(acked) > count {
+			acked = uint32(count)
+		}
+		pending.acked += acked
+		count -= uint64(acked)
+
+		if pending.acked == pending.events {
+			t.ndjsonPending = t.ndjsonPending[1:]
+		}
+		t.ndjsonMutex.Unlock()
+
+		if t.sendEvent(t.recvControl, transports.NewAckEvent(t.observer, pending.nonce, uint64(pending.acked))) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// receiverReadSome reads from the socket into the given byte array, returning
+// as soon as any data is received
+func (t *TransportTCP) receiverReadSome(data []byte) (int, bool, error) {
+	for {
+		select {
+		case <-t.recvControl:
+			// Shutdown
+			return 0, true, nil
+		default:
+		}
+
+		// Timeout after socketIntervalSeconds, check for shutdown, and try again
+		t.socket.SetReadDeadline(time.Now().Add(socketIntervalSeconds * time.Second))
+
+		length, err := t.socket.Read(data)
+		if length > 0 {
+			return length, false, nil
+		}
+
+		if err == nil {
+			continue
+		}
+
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			continue
+		}
+
+		return 0, false, err
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+type testObserver struct {
+	eventChan chan transports.Event
+}
+
+func (o *testObserver) Pool() *addresspool.Pool {
+	return addresspool.NewPool("127.0.0.1:1234")
+}
+
+func (o *testObserver) EventChan() chan<- transports.Event {
+	return o.eventChan
+}
+
+func createNDJSONTransport(ack bool) *TransportTCP {
+	return &TransportTCP{
+		config:      &TransportTCPFactory{Protocol: ProtocolNDJSON, NDJSONAck: ack},
+		observer:    &testObserver{eventChan: make(chan transports.Event, 10)},
+		sendChan:    make(chan *tcpMessage, 10),
+		recvControl: make(chan int),
+	}
+}
+
+func ndjsonEvents(messages ...string) []*core.EventDescriptor {
+	var events []*core.EventDescriptor
+	for _, message := range messages {
+		encoded, _ := core.Event{"message": message}.Encode("message")
+		events = append(events, &core.EventDescriptor{Event: encoded})
+	}
+	return events
+}
+
+func checkNDJSONAck(t *testing.T, event transports.Event, nonce string, sequence uint64) {
+	ack, ok := event.(*transports.AckEvent)
+	if !ok {
+		t.Fatalf("Unexpected event: %v", event)
+	}
+	if ack.Nonce() != nonce || ack.Sequence() != sequence {
+		t.Errorf("Incorrect acknowledgement: %s/%d != %s/%d", ack.Nonce(), ack.Sequence(), nonce, sequence)
+	}
+}
+
+func TestNDJSONWrite(t *testing.T) {
+	transport := createNDJSONTransport(false)
+
+	if err := transport.Write("0123456789abcdef", "", ndjsonEvents("one", "two")); err != nil {
+		t.Fatalf("Failed to write events: %s", err)
+	}
+
+	message := <-transport.sendChan
+	if string(message.data) != "{\"message\":\"one\"}\n{\"message\":\"two\"}\n" {
+		t.Errorf("Unexpected data: %q", message.data)
+	}
+
+	// Acknowledged as soon as it is written
+	checkNDJSONAck(t, message.event, "0123456789abcdef", 2)
+}
+
+func TestNDJSONLineCountAck(t *testing.T) {
+	transport := createNDJSONTransport(true)
+	eventChan := transport.observer.(*testObserver).eventChan
+
+	transport.Write("aaaaaaaaaaaaaaaa", "", ndjsonEvents("one", "two", "three"))
+	transport.Write("bbbbbbbbbbbbbbbb", "", ndjsonEvents("four", "five"))
+
+	if message := <-transport.sendChan; message.event != nil {
+		t.Errorf("Payload was acknowledged on write: %v", message.event)
+	}
+
+	if err := transport.ndjsonAck([]byte("2")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	checkNDJSONAck(t, <-eventChan, "aaaaaaaaaaaaaaaa", 2)
+
+	// Acknowledgements span payloads
+	if err := transport.ndjsonAck([]byte("2")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	checkNDJSONAck(t, <-eventChan, "aaaaaaaaaaaaaaaa", 3)
+	checkNDJSONAck(t, <-eventChan, "bbbbbbbbbbbbbbbb", 1)
+
+	if err := transport.ndjsonAck([]byte("2")); err == nil {
+		t.Error("Acknowledgement of more events than were sent was accepted")
+	}
+
+	if err := transport.ndjsonAck([]byte("invalid")); err == nil {
+		t.Error("Invalid acknowledgement was accepted")
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// StreamCompressionNone disables stream compression
+	StreamCompressionNone = "none"
+
+	// StreamCompressionZlib compresses the entire connection with zlib
+	StreamCompressionZlib = "zlib"
+)
+
+var (
+	errStreamShutdown = errors.New("Shutdown requested")
+)
+
+// negotiateCompression asks the remote to compress the connection. It must be
+// called before the sender and receiver are started, as nothing else can be
+// sent until the remote responds. If the remote does not support compression
+// or declines, the connection continues uncompressed
+func (t *TransportTCP) negotiateCompression() error {
+	algorithm := t.config.StreamCompress
+
+	message := make([]byte, 8, 8+len(algorithm))
+	copy(message, "CMPR")
+	binary.BigEndian.PutUint32(message[4:8], uint32(len(algorithm)))
+	message = append(message, algorithm...)
+
+	t.socket.SetDeadline(time.Now().Add(t.config.netConfig.Timeout))
+	defer t.socket.SetDeadline(time.Time{})
+
+	if _, err := t.socket.Write(message); err != nil {
+		return err
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(t.socket, header); err != nil {
+		return err
+	}
+
+	length := binary.BigEndian.Uint32(header[4:8])
+	if length > 255 {
+		return fmt.Errorf("Protocol error: Compression response too large (%d)", length)
+	}
+
+	response := make([]byte, length)
+	if _, err := io.ReadFull(t.socket, response); err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.Equal(header[0:4], []byte("????")):
+		log.Warning("[%s] Remote does not support stream compression, continuing without it", t.observer.Pool().Server())
+		return nil
+	case !bytes.Equal(header[0:4], []byte("CMPR")):
+		return fmt.Errorf("Protocol error: Unexpected response to compression request: %s", header[0:4])
+	case string(response) != algorithm:
+		log.Warning("[%s] Remote declined %s stream compression, continuing without it", t.observer.Pool().Server(), algorithm)
+		return nil
+	}
+
+	log.Info("[%s] Using %s stream compression", t.observer.Pool().Server(), algorithm)
+
+	t.compressor = zlib.NewWriter(t.socket)
+	atomic.StoreInt32(&t.streamCompressed, 1)
+	return nil
+}
+
+// writeStream writes data to the socket, through the compressor if the
+// connection is compressed
+func (t *TransportTCP) writeStream(data []byte) error {
+	if t.compressor == nil {
+		_, err := t.socket.Write(data)
+		return err
+	}
+
+	if _, err := t.compressor.Write(data); err != nil {
+		return err
+	}
+
+	// Flush so that the message is sent immediately
+	return t.compressor.Flush()
+}
+
+// receiverReadCompressed fills the given byte array from the compressed
+// connection. The decompressor is created on the first read as it reads the
+// zlib header, which the remote only sends with its first message
+func (t *TransportTCP) receiverReadCompressed(data []byte) (bool, error) {
+	var err error
+	if t.decompressor == nil {
+		t.decompressor, err = zlib.NewReader(&transportTCPReader{transport: t})
+	}
+
+	if err == nil {
+		_, err = io.ReadFull(t.decompressor, data)
+	}
+
+	if err == errStreamShutdown {
+		return true, nil
+	}
+
+	return false, err
+}
+
+// transportTCPReader reads from the socket for the decompressor. Read timeouts
+// would permanently break the decompressor, so it retries them itself, checking
+// for shutdown each time
+type transportTCPReader struct {
+	transport *TransportTCP
+}
+
+func (r *transportTCPReader) Read(b []byte) (int, error) {
+	for {
+		// Timeout after socketIntervalSeconds, check for shutdown, and try again
+		r.transport.socket.SetReadDeadline(time.Now().Add(socketIntervalSeconds * time.Second))
+
+		n, err := r.transport.socket.Read(b)
+		if n != 0 {
+			return n, nil
+		}
+
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			select {
+			case <-r.transport.recvControl:
+				return 0, errStreamShutdown
+			default:
+				continue
+			}
+		}
+
+		return n, err
+	}
+}
@@ -0,0 +1,310 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+const (
+	// syslogNil is the NILVALUE of RFC5424, used for absent header fields
+	syslogNil = "-"
+
+	// syslogTimestamp is the RFC5424 timestamp layout, which allows at most 6
+	// digits of fractional seconds
+	syslogTimestamp = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+var (
+	// syslogFacilities maps the facility names to their codes
+	syslogFacilities = map[string]int{
+		"kern":     0,
+		"user":     1,
+		"mail":     2,
+		"daemon":   3,
+		"auth":     4,
+		"syslog":   5,
+		"lpr":      6,
+		"news":     7,
+		"uucp":     8,
+		"cron":     9,
+		"authpriv": 10,
+		"ftp":      11,
+		"ntp":      12,
+		"security": 13,
+		"console":  14,
+		"local0":   16,
+		"local1":   17,
+		"local2":   18,
+		"local3":   19,
+		"local4":   20,
+		"local5":   21,
+		"local6":   22,
+		"local7":   23,
+	}
+
+	// syslogSeverities maps severity names, and common log level names, to
+	// their codes
+	syslogSeverities = map[string]int{
+		"emerg":         0,
+		"emergency":     0,
+		"panic":         0,
+		"alert":         1,
+		"crit":          2,
+		"critical":      2,
+		"fatal":         2,
+		"err":           3,
+		"error":         3,
+		"warn":          4,
+		"warning":       4,
+		"notice":        5,
+		"info":          6,
+		"informational": 6,
+		"debug":         7,
+		"trace":         7,
+	}
+
+	// syslogParamEscaper escapes the characters that are special within a
+	// structured data parameter value
+	syslogParamEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "]", "\\]")
+)
+
+// syslogStructuredData holds the configuration of an RFC5424 structured data
+// element and the event fields that become its parameters
+type syslogStructuredData struct {
+	ID     string   `config:"id"`
+	Fields []string `config:"fields"`
+}
+
+// initSyslog validates the syslog protocol options
+func (f *TransportTCPFactory) initSyslog() error {
+	var ok bool
+	if f.syslogFacility, ok = syslogFacilities[strings.ToLower(f.SyslogFacility)]; !ok {
+		return fmt.Errorf("syslog facility \"%s\" is not known", f.SyslogFacility)
+	}
+
+	if f.syslogSeverity, ok = parseSyslogSeverity(f.SyslogSeverity); !ok {
+		return fmt.Errorf("syslog severity \"%s\" is not known", f.SyslogSeverity)
+	}
+
+	if f.SyslogMsgField == "" {
+		return fmt.Errorf("syslog message field can not be empty")
+	}
+
+	for i, element := range f.SyslogSD {
+		if !isSyslogName(element.ID) {
+			return fmt.Errorf("syslog structured data[%d] id \"%s\" is not a valid structured data ID", i, element.ID)
+		}
+
+		if len(element.Fields) == 0 {
+			return fmt.Errorf("syslog structured data[%d] fields must be specified", i)
+		}
+
+		for _, field := range element.Fields {
+			if !isSyslogName(field) {
+				return fmt.Errorf("syslog structured data[%d] field \"%s\" is not a valid parameter name", i, field)
+			}
+		}
+	}
+
+	return nil
+}
+
+// isSyslogName returns true if the name is valid as a structured data ID or
+// parameter name, which must be 1 to 32 printable characters other than '=',
+// space, ']' and '"'
+func isSyslogName(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		if name[i] < 33 || name[i] > 126 || name[i] == '=' || name[i] == ']' || name[i] == '"' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseSyslogSeverity returns the code of a severity given by name or number
+func parseSyslogSeverity(value string) (int, bool) {
+	if severity, ok := syslogSeverities[strings.ToLower(value)]; ok {
+		return severity, true
+	}
+
+	severity, err := strconv.Atoi(value)
+	if err != nil || severity < 0 || severity > 7 {
+		return 0, false
+	}
+
+	return severity, true
+}
+
+// writeSyslog queues the events to be written as RFC5424 syslog messages with
+// octet-counting framing, as described by RFC6587. There is no acknowledgement
+// in syslog so the payload is acknowledged as soon as it is written
+func (t *TransportTCP) writeSyslog(nonce string, events []*core.EventDescriptor) error {
+	var messageBuffer bytes.Buffer
+
+	for _, event := range events {
+		message := t.formatSyslog(event.Event)
+		messageBuffer.WriteString(strconv.Itoa(len(message)))
+		messageBuffer.WriteByte(' ')
+		messageBuffer.Write(message)
+	}
+
+	t.sendChan <- &tcpMessage{
+		data:  messageBuffer.Bytes(),
+		event: transports.NewAckEvent(t.observer, nonce, uint64(len(events))),
+	}
+	return nil
+}
+
+// formatSyslog formats an encoded event as an RFC5424 message. If the event
+// has no message field, the entire event is sent as the message
+func (t *TransportTCP) formatSyslog(data []byte) []byte {
+	event, err := core.DecodeEvent(data)
+	if err != nil || event == nil {
+		event = core.Event{}
+	}
+
+	severity := t.config.syslogSeverity
+	if t.config.SyslogSevField != "" {
+		if value, ok := event.GetPath(t.config.SyslogSevField); ok {
+			if parsed, ok := parseSyslogSeverity(fmt.Sprint(value)); ok {
+				severity = parsed
+			}
+		}
+	}
+
+	timestamp := time.Now()
+	if value, ok := event["@timestamp"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			timestamp = parsed
+		}
+	}
+
+	hostname, _ := event.GetPath(t.config.SyslogHostField)
+	hostnameString, _ := hostname.(string)
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "<%d>1 %s %s %s %s %s ",
+		t.config.syslogFacility*8+severity,
+		timestamp.Format(syslogTimestamp),
+		syslogHeaderField(hostnameString, 255),
+		syslogHeaderField(t.config.SyslogAppName, 48),
+		syslogNil,
+		syslogNil,
+	)
+
+	if !t.writeSyslogStructuredData(&message, event) {
+		message.WriteString(syslogNil)
+	}
+
+	if value, ok := event.GetPath(t.config.SyslogMsgField); ok {
+		if text, ok := value.(string); ok {
+			if text != "" {
+				message.WriteByte(' ')
+				message.WriteString(text)
+			}
+			return message.Bytes()
+		}
+	}
+
+	message.WriteByte(' ')
+	message.Write(data)
+	return message.Bytes()
+}
+
+// writeSyslogStructuredData writes each configured structured data element
+// that has at least one of its fields present in the event, returning false
+// if none were written
+func (t *TransportTCP) writeSyslogStructuredData(message *bytes.Buffer, event core.Event) bool {
+	written := false
+
+	for _, element := range t.config.SyslogSD {
+		started := false
+		for _, field := range element.Fields {
+			value, ok := event.GetPath(field)
+			if !ok || value == nil {
+				continue
+			}
+
+			if !started {
+				message.WriteByte('[')
+				message.WriteString(element.ID)
+				started = true
+			}
+
+			message.WriteByte(' ')
+			message.WriteString(field)
+			message.WriteString("=\"")
+			message.WriteString(syslogParamEscaper.Replace(syslogParamValue(value)))
+			message.WriteByte('"')
+		}
+
+		if started {
+			message.WriteByte(']')
+			written = true
+		}
+	}
+
+	return written
+}
+
+// syslogParamValue returns the text of a field value, encoding values that are
+// not strings as JSON
+func syslogParamValue(value interface{}) string {
+	if text, ok := value.(string); ok {
+		return text
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+// syslogHeaderField returns the value for a header field, replacing characters
+// that are not allowed with underscores and truncating it to the maximum
+// length. Empty values become the NILVALUE
+func syslogHeaderField(value string, max int) string {
+	if value == "" {
+		return syslogNil
+	}
+
+	if len(value) > max {
+		value = value[:max]
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+func createSyslogTransport(t *testing.T, options map[string]interface{}) *TransportTCP {
+	options["protocol"] = "syslog"
+
+	factory, err := NewTransportTCPFactory(config.NewConfig(), "/network/", options, TransportTCPTCP)
+	if err != nil {
+		t.Fatalf("Failed to create transport factory: %s", err)
+	}
+
+	return &TransportTCP{
+		config:   factory.(*TransportTCPFactory),
+		observer: &testObserver{eventChan: make(chan transports.Event, 10)},
+		sendChan: make(chan *tcpMessage, 10),
+	}
+}
+
+func TestSyslogWrite(t *testing.T) {
+	transport := createSyslogTransport(t, map[string]interface{}{
+		"syslog facility":       "local0",
+		"syslog severity field": "level",
+		"syslog structured data": []interface{}{
+			map[string]interface{}{"id": "origin@32473", "fields": []interface{}{"path", "offset"}},
+			map[string]interface{}{"id": "absent@32473", "fields": []interface{}{"missing"}},
+		},
+	})
+
+	events := []*core.EventDescriptor{
+		{Event: []byte(`{"@timestamp":"2020-01-02T03:04:05.123456789Z","host":"web 1","level":"error","message":"failed","path":"/var/log/a\"b]","offset":12}`)},
+		{Event: []byte(`{"level":"unknown","other":true}`)},
+	}
+	if err := transport.Write("0123456789abcdef", "", events); err != nil {
+		t.Fatalf("Failed to write events: %s", err)
+	}
+
+	message := <-transport.sendChan
+
+	first := `<131>1 2020-01-02T03:04:05.123456Z web_1 log-courier - - [origin@32473 path="/var/log/a\"b\]" offset="12"] failed`
+	framed := strconv.Itoa(len(first)) + " " + first
+	if !strings.HasPrefix(string(message.data), framed) {
+		t.Fatalf("Unexpected first message: %q", message.data)
+	}
+
+	// The second event has no timestamp, so the current time is used
+	second := strings.SplitN(string(message.data[len(framed):]), " ", 2)
+	if length, err := strconv.Atoi(second[0]); err != nil || length != len(second[1]) {
+		t.Errorf("Incorrect second message length: %s != %d", second[0], len(second[1]))
+	}
+	expected := ` - log-courier - - - {"level":"unknown","other":true}`
+	if !strings.HasPrefix(second[1], "<134>1 ") || !strings.HasSuffix(second[1], expected) {
+		t.Errorf("Unexpected second message: %q", second[1])
+	}
+
+	// Acknowledged as soon as it is written
+	checkNDJSONAck(t, message.event, "0123456789abcdef", 2)
+}
+
+func TestSyslogInvalidOptions(t *testing.T) {
+	invalid := []map[string]interface{}{
+		{"syslog facility": "unknown"},
+		{"syslog severity": "8"},
+		{"syslog structured data": []interface{}{map[string]interface{}{"id": "bad id", "fields": []interface{}{"path"}}}},
+		{"syslog structured data": []interface{}{map[string]interface{}{"id": "meta"}}},
+		{"ndjson ack": true},
+	}
+
+	for _, options := range invalid {
+		options["protocol"] = "syslog"
+		if _, err := NewTransportTCPFactory(config.NewConfig(), "/network/", options, TransportTCPTCP); err == nil {
+			t.Errorf("Invalid options were accepted: %v", options)
+		}
+	}
+}
@@ -26,10 +26,15 @@ import (
 	"crypto/x509"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/audit"
 	"github.com/driskell/log-courier/lc-lib/core"
 	"github.com/driskell/log-courier/lc-lib/transports"
 )
@@ -60,13 +65,58 @@ type TransportTCP struct {
 	sendControl chan int
 	recvControl chan int
 
-	sendChan chan []byte
+	sendChan chan *tcpMessage
+
+	// Payloads awaiting line count acknowledgement under the ndjson protocol
+	ndjsonMutex   sync.Mutex
+	ndjsonPending []*ndjsonPending
+
+	// Set by the receiver if the remote rejects uncompressed payloads
+	uncompressedRejected int32
+
+	// Set once payload metadata has been sent on the current connection, and by
+	// the receiver if the remote rejects it
+	metadataSent     int32
+	metadataRejected int32
+
+	// Stream compression, set before the sender and receiver start
+	streamCompressed int32
+	compressor       *zlib.Writer
+	decompressor     io.ReadCloser
+
+	// Compression counters, updated by Write when compression stats are enabled
+	// and read by the API
+	uncompressedBytes uint64
+	compressedBytes   uint64
+	compressedEvents  uint64
+
+	// Handshake counters, updated by the controller and read by the API
+	fullHandshakes    uint64
+	resumedHandshakes uint64
+
+	// Times PING messages were written, awaiting their PONG, and the number of
+	// PING messages sent by the transport itself to measure the clock skew
+	pingMutex sync.Mutex
+	pingTimes []time.Time
+	skewPings int
+
+	// Last measured clock skew, read by the API
+	clockSkew      int64
+	clockSkewKnown int32
 
 	// Use in receiver routine only
 	pongPending bool
 	pongTimer   *time.Timer
 }
 
+// tcpMessage is a message queued for the sender, along with an optional event
+// to send to the observer once it has been written
+type tcpMessage struct {
+	data  []byte
+	event transports.Event
+	ping  bool
+}
+
 // ReloadConfig returns true if the transport needs to be restarted in order
 // for the new configuration to apply
 func (t *TransportTCP) ReloadConfig(factoryInterface interface{}, finishOnFail bool) bool {
@@ -74,13 +124,22 @@ func (t *TransportTCP) ReloadConfig(factoryInterface interface{}, finishOnFail b
 	t.finishOnFail = finishOnFail
 
 	// TODO: Check timestamps of underlying certificate files to detect changes
-	if newConfig.SSLCertificate != t.config.SSLCertificate || newConfig.SSLKey != t.config.SSLKey || newConfig.SSLCA != t.config.SSLCA {
+	if newConfig.SSLCertificate != t.config.SSLCertificate || newConfig.SSLKey != t.config.SSLKey || newConfig.SSLKeyPassphrase != t.config.SSLKeyPassphrase || newConfig.SSLCA != t.config.SSLCA || newConfig.SSLServerName != t.config.SSLServerName || newConfig.SSLVerify != t.config.SSLVerify || newConfig.SSLSessionCache != t.config.SSLSessionCache || newConfig.SSLVerifyUsage != t.config.SSLVerifyUsage || newConfig.Protocol != t.config.Protocol || newConfig.NDJSONAck != t.config.NDJSONAck || newConfig.ClockSkew != t.config.ClockSkew || newConfig.StreamCompress != t.config.StreamCompress || newConfig.MinTLSVersion != t.config.MinTLSVersion || !reflect.DeepEqual(newConfig.CipherSuites, t.config.CipherSuites) {
+		return true
+	}
+
+	server := t.observer.Pool().Server()
+	if !reflect.DeepEqual(newConfig.netConfig.ServerOptions[server], t.config.netConfig.ServerOptions[server]) {
 		return true
 	}
 
 	// Only copy net config just in case something in the factory did change that
 	// we didn't account for which does require a restart
 	t.config.netConfig = newConfig.netConfig
+	t.config.MinCompressBytes = newConfig.MinCompressBytes
+	t.config.CompressStats = newConfig.CompressStats
+	t.config.KeepAlive = newConfig.KeepAlive
+	t.config.KeepAlivePeriod = newConfig.KeepAlivePeriod
 
 	return false
 }
@@ -100,7 +159,7 @@ func (t *TransportTCP) controller() {
 
 		shutdown, err = t.connect()
 		if shutdown {
-			t.disconnect()
+			t.disconnect("shutdown")
 			return
 		}
 		if err == nil {
@@ -111,7 +170,7 @@ func (t *TransportTCP) controller() {
 			// TODO: Handle configuration reload
 			case <-t.controllerChan:
 				// Shutdown request
-				t.disconnect()
+				t.disconnect("shutdown")
 				return
 			case err = <-t.failChan:
 				// If err is nil, it's a forced failure by publisher so we need not
@@ -125,17 +184,17 @@ func (t *TransportTCP) controller() {
 		if err != nil {
 			if t.finishOnFail {
 				log.Errorf("[%s] Transport error: %s", t.observer.Pool().Server(), err)
-				t.disconnect()
+				t.disconnect(err.Error())
 				return
 			}
 
 			log.Errorf("[%s] Transport error, reconnecting: %s", t.observer.Pool().Server(), err)
+			t.disconnect(err.Error())
 		} else {
 			log.Info("[%s] Transport reconnecting", t.observer.Pool().Server())
+			t.disconnect("reconnecting")
 		}
 
-		t.disconnect()
-
 		if t.sendEvent(t.controllerChan, transports.NewStatusEvent(t.observer, transports.Failed)) {
 			return
 		}
@@ -168,11 +227,32 @@ ReconnectWaitLoop:
 	return true
 }
 
+// setKeepAlive enables TCP keepalives on the socket so that dead peers are
+// detected by the operating system. The interval is left at the operating
+// system default unless one is configured
+func (t *TransportTCP) setKeepAlive(socket net.Conn) {
+	tcpsocket, ok := socket.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	if err := tcpsocket.SetKeepAlive(true); err != nil {
+		log.Warning("[%s] Failed to enable TCP keepalive: %s", t.observer.Pool().Server(), err)
+		return
+	}
+
+	if t.config.KeepAlivePeriod != 0 {
+		if err := tcpsocket.SetKeepAlivePeriod(t.config.KeepAlivePeriod); err != nil {
+			log.Warning("[%s] Failed to set TCP keepalive interval: %s", t.observer.Pool().Server(), err)
+		}
+	}
+}
+
 // connect connects the socket and starts the sender and receiver routines
 // Returns an error and also true if shutdown was detected
 func (t *TransportTCP) connect() (bool, error) {
 	if t.sendControl != nil {
-		t.disconnect()
+		t.disconnect("reconnecting")
 	}
 
 	addr, err := t.observer.Pool().Next()
@@ -184,36 +264,64 @@ func (t *TransportTCP) connect() (bool, error) {
 
 	log.Info("[%s] Attempting to connect to %s", t.observer.Pool().Server(), desc)
 
-	tcpsocket, err := net.DialTimeout("tcp", addr.String(), t.config.netConfig.Timeout)
+	dialer := &net.Dialer{Timeout: t.config.netConfig.Timeout}
+	if t.config.KeepAlive {
+		// Keepalives are configured after connecting rather than using the
+		// defaults of the dialer
+		dialer.KeepAlive = -1
+	}
+
+	tcpsocket, err := dialer.Dial("tcp", addr.String())
 	if err != nil {
+		entry := audit.NewEntry(audit.EventConnectFailed, nil)
+		entry.Remote, entry.Reason = addr.String(), err.Error()
+		t.audit(entry)
 		return false, fmt.Errorf("Failed to connect to %s: %s", desc, err)
 	}
 
+	if t.config.KeepAlive {
+		t.setKeepAlive(tcpsocket)
+	}
+
 	// Now wrap in TLS if this is the TLS transport
 	if t.config.transport == TransportTCPTLS {
-		// Disable SSLv3 (mitigate POODLE vulnerability)
-		t.tlsConfig.MinVersion = tls.VersionTLS10
+		t.tlsConfig.MinVersion = t.config.tlsMinVersion
+		t.tlsConfig.CipherSuites = t.config.tlsCipherSuites
+
+		// Servers can override the transport's certificate and CA
+		certificate, _, caList := t.config.serverTLS(t.observer.Pool().Server())
 
 		// Set the certificate if we set one
-		if t.config.certificate != nil {
-			t.tlsConfig.Certificates = []tls.Certificate{*t.config.certificate}
+		if certificate != nil {
+			t.tlsConfig.Certificates = []tls.Certificate{*certificate}
 		} else {
 			t.tlsConfig.Certificates = nil
 		}
 
-		// Set CA for server verification
+		// Set CA for server verification, unless it is disabled
+		t.tlsConfig.InsecureSkipVerify = !t.config.SSLVerify
 		t.tlsConfig.RootCAs = x509.NewCertPool()
-		for _, cert := range t.config.caList {
+		for _, cert := range caList {
 			t.tlsConfig.RootCAs.AddCert(cert)
 		}
 
 		// Set the tlsConfig server name for server validation (required since Go 1.3)
-		t.tlsConfig.ServerName = t.observer.Pool().Host()
+		t.tlsConfig.ServerName = t.config.serverName(t.observer.Pool().Server(), t.observer.Pool().Host())
+
+		// Require the server certificate to be intended for server authentication
+		if t.config.SSLVerifyUsage {
+			t.tlsConfig.VerifyPeerCertificate = core.VerifyExtKeyUsage(x509.ExtKeyUsageServerAuth)
+		} else {
+			t.tlsConfig.VerifyPeerCertificate = nil
+		}
 
 		t.tlsSocket = tls.Client(&transportTCPWrap{transport: t, tcpsocket: tcpsocket}, &t.tlsConfig)
 		t.tlsSocket.SetDeadline(time.Now().Add(t.config.netConfig.Timeout))
 		err = t.tlsSocket.Handshake()
 		if err != nil {
+			entry := audit.NewEntry(audit.EventConnectFailed, tcpsocket)
+			entry.Reason = err.Error()
+			t.audit(entry)
 			t.tlsSocket.Close()
 			tcpsocket.Close()
 			t.checkClientCertificates()
@@ -221,16 +329,58 @@ func (t *TransportTCP) connect() (bool, error) {
 		}
 
 		t.socket = t.tlsSocket
+
+		if t.tlsSocket.ConnectionState().DidResume {
+			atomic.AddUint64(&t.resumedHandshakes, 1)
+			log.Notice("[%s] Connected to %s (resumed TLS session)", t.observer.Pool().Server(), desc)
+		} else {
+			atomic.AddUint64(&t.fullHandshakes, 1)
+			log.Notice("[%s] Connected to %s", t.observer.Pool().Server(), desc)
+		}
 	} else {
 		t.socket = tcpsocket
+
+		log.Notice("[%s] Connected to %s", t.observer.Pool().Server(), desc)
 	}
 
-	log.Notice("[%s] Connected to %s", t.observer.Pool().Server(), desc)
+	t.audit(audit.NewEntry(audit.EventConnected, t.socket))
+
+	t.compressor, t.decompressor = nil, nil
+	atomic.StoreInt32(&t.streamCompressed, 0)
+	atomic.StoreInt32(&t.metadataSent, 0)
+	if t.config.StreamCompress != StreamCompressionNone {
+		if err = t.negotiateCompression(); err != nil {
+			t.socket.Close()
+			entry := audit.NewEntry(audit.EventDisconnected, t.socket)
+			entry.Reason = err.Error()
+			t.audit(entry)
+			return false, fmt.Errorf("Failed to negotiate stream compression with %s: %s", desc, err)
+		}
+	}
 
 	// Signal channels
 	t.sendControl = make(chan int, 1)
 	t.recvControl = make(chan int, 1)
-	t.sendChan = make(chan []byte, t.config.netConfig.MaxPendingPayloads)
+	t.sendChan = make(chan *tcpMessage, t.config.netConfig.MaxPendingPayloads)
+
+	// Payloads pending on the previous connection will be resent
+	t.ndjsonMutex.Lock()
+	t.ndjsonPending = nil
+	t.ndjsonMutex.Unlock()
+
+	// Measure the clock skew straight away rather than waiting for the first
+	// keepalive, without the PONG being reported to the publisher
+	t.pingMutex.Lock()
+	t.pingTimes = nil
+	t.skewPings = 0
+	if t.config.ClockSkew {
+		t.skewPings = 1
+	}
+	t.pingMutex.Unlock()
+
+	if t.config.ClockSkew {
+		t.sendChan <- &tcpMessage{data: []byte{'P', 'I', 'N', 'G', 0, 0, 0, 0}, ping: true}
+	}
 
 	// Failure channel - ensure we can fit 2 errors here, one from sender and one
 	// from receive - otherwise if both fail at the same time, disconnect blocks
@@ -245,7 +395,11 @@ func (t *TransportTCP) connect() (bool, error) {
 	// we don't have cross-platform poll, so they will need to block. Of course,
 	// we'll time out and check shutdown on occasion
 	go t.sender()
-	go t.receiver()
+	if t.config.Protocol != ProtocolCourier {
+		go t.ndjsonReceiver()
+	} else {
+		go t.receiver()
+	}
 
 	return false, nil
 }
@@ -253,7 +407,8 @@ func (t *TransportTCP) connect() (bool, error) {
 // checkClientCertificates logs a warning if it finds any certificates that are
 // not currently valid
 func (t *TransportTCP) checkClientCertificates() {
-	if t.config.certificateList == nil {
+	_, certificateList, _ := t.config.serverTLS(t.observer.Pool().Server())
+	if certificateList == nil {
 		// No certificates were specified, don't do anything
 		return
 	}
@@ -261,7 +416,7 @@ func (t *TransportTCP) checkClientCertificates() {
 	now := time.Now()
 	certIssues := false
 
-	for _, cert := range t.config.certificateList {
+	for _, cert := range certificateList {
 		if cert.NotBefore.After(now) {
 			log.Warning("The client certificate with common name '%s' is not valid until %s.", cert.Subject.CommonName, cert.NotBefore.Format("Jan 02 2006"))
 			certIssues = true
@@ -279,8 +434,8 @@ func (t *TransportTCP) checkClientCertificates() {
 }
 
 // disconnect shuts down the sender and receiver routines and disconnects the
-// socket
-func (t *TransportTCP) disconnect() {
+// socket, recording the reason in the audit log
+func (t *TransportTCP) disconnect(reason string) {
 	if t.sendControl == nil {
 		return
 	}
@@ -299,9 +454,21 @@ func (t *TransportTCP) disconnect() {
 
 	t.socket.Close()
 
+	entry := audit.NewEntry(audit.EventDisconnected, t.socket)
+	entry.Reason = reason
+	t.audit(entry)
+
 	log.Notice("[%s] Disconnected from %s", t.observer.Pool().Server(), t.observer.Pool().Desc())
 }
 
+// audit writes an entry for this transport's server to the audit log
+func (t *TransportTCP) audit(entry *audit.Entry) {
+	entry.Server = t.observer.Pool().Server()
+	if err := t.config.audit.Write(entry); err != nil {
+		log.Warning("[%s] Failed to write to audit log: %s", t.observer.Pool().Server(), err)
+	}
+}
+
 // sender handles socket writes
 func (t *TransportTCP) sender() {
 	defer func() {
@@ -320,19 +487,31 @@ SenderLoop:
 			// Shutdown
 			break SenderLoop
 		case msg := <-t.sendChan:
-			// Write deadline is managed by our net.Conn wrapper that TLS will call
-			// into and keeps retrying writes until timeout or error
-			_, err := t.socket.Write(msg)
-			if err != nil {
-				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-					// Shutdown will have been received by the wrapper
+			if msg.data != nil {
+				// Write deadline is managed by our net.Conn wrapper that TLS will call
+				// into and keeps retrying writes until timeout or error
+				err := t.writeStream(msg.data)
+				if err != nil {
+					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+						// Shutdown will have been received by the wrapper
+						break SenderLoop
+					}
+					// Fail the transport
+					select {
+					case <-t.sendControl:
+					case t.failChan <- err:
+					}
 					break SenderLoop
 				}
-				// Fail the transport
-				select {
-				case <-t.sendControl:
-				case t.failChan <- err:
+
+				if msg.ping {
+					t.pingMutex.Lock()
+					t.pingTimes = append(t.pingTimes, time.Now())
+					t.pingMutex.Unlock()
 				}
+			}
+
+			if msg.event != nil && t.sendEvent(t.sendControl, msg.event) {
 				break SenderLoop
 			}
 		}
@@ -378,18 +557,45 @@ ReceiverLoop:
 
 		switch {
 		case bytes.Compare(header[0:4], []byte("PONG")) == 0:
+			if t.processPong(message) {
+				// Response to our own clock skew measurement
+				continue
+			}
 			if t.sendEvent(t.recvControl, transports.NewPongEvent(t.observer)) {
 				break ReceiverLoop
 			}
-		case bytes.Compare(header[0:4], []byte("ACKN")) == 0:
-			if len(message) != 20 {
-				err = fmt.Errorf("Protocol error: Corrupt message (ACKN size %d != 20)", len(message))
+		case bytes.Compare(header[0:4], []byte("ACKN")) == 0, bytes.Compare(header[0:4], []byte("ACKV")) == 0:
+			var (
+				nonce    string
+				sequence uint64
+			)
+			if nonce, sequence, err = transports.DecodeAck(string(header[0:4]), message); err != nil {
+				err = fmt.Errorf("Protocol error: %s", err)
 				break ReceiverLoop
 			}
 
-			if t.sendEvent(t.recvControl, transports.NewAckEventWithBytes(t.observer, message[0:16], message[16:20])) {
+			if t.sendEvent(t.recvControl, transports.NewAckEvent(t.observer, nonce, sequence)) {
+				break ReceiverLoop
+			}
+		case bytes.Compare(header[0:4], []byte("????")) == 0:
+			// The remote does not understand a message we sent - the optional
+			// messages are META and EVNT, so stop sending them and reconnect so that
+			// the affected payloads are resent. META is newer so is assumed to be
+			// the cause if it was sent
+			if atomic.LoadInt32(&t.metadataSent) != 0 && atomic.CompareAndSwapInt32(&t.metadataRejected, 0, 1) {
+				log.Warning("[%s] Remote does not support payload metadata, payloads will be sent without it", t.observer.Pool().Server())
+				err = fmt.Errorf("Remote does not support payload metadata")
 				break ReceiverLoop
 			}
+
+			if t.config.MinCompressBytes == 0 || !atomic.CompareAndSwapInt32(&t.uncompressedRejected, 0, 1) {
+				err = fmt.Errorf("Protocol error: Remote reported an unknown message")
+				break ReceiverLoop
+			}
+
+			log.Warning("[%s] Remote does not support uncompressed payloads, disabling min compress bytes", t.observer.Pool().Server())
+			err = fmt.Errorf("Remote does not support uncompressed payloads")
+			break ReceiverLoop
 		default:
 			err = fmt.Errorf("Unexpected message code: %s", header[0:4])
 			break ReceiverLoop
@@ -410,9 +616,60 @@ ReceiverLoop:
 	}
 }
 
+// processPong measures the clock skew from the time the remote included in a
+// PONG message, if it included one, and returns true if the PONG was for a PING
+// sent by the transport itself rather than by the publisher
+func (t *TransportTCP) processPong(message []byte) bool {
+	now := time.Now()
+
+	t.pingMutex.Lock()
+	var sent time.Time
+	if len(t.pingTimes) != 0 {
+		sent = t.pingTimes[0]
+		t.pingTimes = t.pingTimes[1:]
+	}
+	internal := t.skewPings != 0
+	if internal {
+		t.skewPings--
+	}
+	t.pingMutex.Unlock()
+
+	if !t.config.ClockSkew || sent.IsZero() {
+		return internal
+	}
+
+	if len(message) != 8 {
+		log.Debug("[%s] Remote did not include its time in PONG, clock skew can not be measured", t.observer.Pool().Server())
+		return internal
+	}
+
+	// Assume the remote took the time half way through the round trip
+	remote := time.Unix(0, int64(binary.BigEndian.Uint64(message)))
+	skew := remote.Sub(sent.Add(now.Sub(sent) / 2))
+
+	atomic.StoreInt64(&t.clockSkew, int64(skew))
+	atomic.StoreInt32(&t.clockSkewKnown, 1)
+	core.SetClockSkew(skew)
+
+	if skew > t.config.MaxClockSkew || -skew > t.config.MaxClockSkew {
+		log.Warning("[%s] Clock skew of %v detected with %s (positive means the remote clock is ahead)", t.observer.Pool().Server(), skew, t.observer.Pool().Desc())
+	} else {
+		log.Debug("[%s] Clock skew of %v measured with %s", t.observer.Pool().Server(), skew, t.observer.Pool().Desc())
+	}
+
+	return internal
+}
+
 // receiverRead will repeatedly read from the socket until the given byte array
-// is filled.
+// is filled. A message may arrive split across any number of reads on a slow
+// link, and a read timeout part way through only means the rest has not yet
+// arrived, so only other errors are returned. TLS connections keep a partially
+// received record across read timeouts, so the same applies to them
 func (t *TransportTCP) receiverRead(data []byte) (bool, error) {
+	if atomic.LoadInt32(&t.streamCompressed) != 0 {
+		return t.receiverReadCompressed(data)
+	}
+
 	received := 0
 
 ReceiverReadLoop:
@@ -462,15 +719,48 @@ func (t *TransportTCP) sendEvent(controlChan <-chan int, event transports.Event)
 	return false
 }
 
-// Write a message to the transport
-func (t *TransportTCP) Write(nonce string, events []*core.EventDescriptor) error {
+// Write a message to the transport. Metadata is sent ahead of the payload in
+// a META message, unless it is empty or the remote does not support it
+func (t *TransportTCP) Write(nonce string, metadata string, events []*core.EventDescriptor) error {
+	switch t.config.Protocol {
+	case ProtocolNDJSON:
+		return t.writeNDJSON(nonce, events)
+	case ProtocolSyslog:
+		return t.writeSyslog(nonce, events)
+	}
+
 	var messageBuffer bytes.Buffer
 
+	if metadata != "" && atomic.LoadInt32(&t.metadataRejected) == 0 {
+		if err := t.writeMetadata(&messageBuffer, metadata); err != nil {
+			return err
+		}
+	}
+
+	// The payload message follows any META message in the same buffer
+	start := messageBuffer.Len()
+
+	// Small payloads are not worth compressing, so send them uncompressed if
+	// configured and the remote has not told us it does not support it. When
+	// the whole connection is compressed payloads are never compressed again
+	compress := true
+	if atomic.LoadInt32(&t.streamCompressed) != 0 {
+		compress = false
+	} else if t.config.MinCompressBytes != 0 && atomic.LoadInt32(&t.uncompressedRejected) == 0 {
+		var size int64
+		for _, event := range events {
+			size += 4 + int64(len(event.Event))
+		}
+		compress = size >= t.config.MinCompressBytes
+	}
+
 	// Encapsulate the data into the message
-	// 4-byte message header (JDAT = JSON Data, Compressed)
+	// 4-byte message header (JDAT = JSON Data, Compressed, EVNT = Uncompressed,
+	// or JDAV and EVNV when the nonce requires versioned framing)
 	// 4-byte uint32 data length
 	// Then the data
-	if _, err := messageBuffer.Write([]byte("JDAT")); err != nil {
+	framing := transports.FramingForNonce(nonce)
+	if _, err := messageBuffer.WriteString(framing.PayloadType(compress)); err != nil {
 		return err
 	}
 
@@ -479,47 +769,147 @@ func (t *TransportTCP) Write(nonce string, events []*core.EventDescriptor) error
 		return err
 	}
 
-	// Create the compressed data payload
-	// 16-byte Nonce, followed by the compressed event data
+	// Create the data payload
+	// 16-byte Nonce, or the versioned header and Nonce, followed by the event
+	// data, compressed for JDAT
 	// The event data is each event, prefixed with a 4-byte uint32 length, one
 	// after the other
-	if _, err := messageBuffer.Write([]byte(nonce)); err != nil {
+	payloadHeader, err := framing.EncodePayloadHeader(nonce)
+	if err != nil {
 		return err
 	}
 
-	compressor, err := zlib.NewWriterLevel(&messageBuffer, 3)
-	if err != nil {
+	if _, err := messageBuffer.Write(payloadHeader); err != nil {
 		return err
 	}
 
-	for _, event := range events {
-		if err := binary.Write(compressor, binary.BigEndian, uint32(len(event.Event))); err != nil {
+	if compress {
+		dataStart := messageBuffer.Len()
+		if err := t.writeCompressedEvents(&messageBuffer, events); err != nil {
 			return err
 		}
-
-		if _, err := compressor.Write(event.Event); err != nil {
-			return err
+		if t.config.CompressStats {
+			t.recordCompression(events, messageBuffer.Len()-dataStart)
 		}
+	} else if err := t.writeEvents(&messageBuffer, events); err != nil {
+		return err
 	}
 
-	compressor.Close()
-
 	// Fill in the size
 	// TODO: This prevents us bypassing buffer and just sending...
 	//       New JDA2? With FFFF size? Means stream message?
 	messageBytes := messageBuffer.Bytes()
-	binary.BigEndian.PutUint32(messageBytes[4:8], uint32(messageBuffer.Len()-8))
+	binary.BigEndian.PutUint32(messageBytes[start+4:start+8], uint32(messageBuffer.Len()-start-8))
+
+	t.sendChan <- &tcpMessage{data: messageBytes}
+	return nil
+}
+
+// writeMetadata writes a META message containing the metadata for the payload
+// that follows it
+func (t *TransportTCP) writeMetadata(writer io.Writer, metadata string) error {
+	if _, err := writer.Write([]byte("META")); err != nil {
+		return err
+	}
 
-	t.sendChan <- messageBytes
+	if err := binary.Write(writer, binary.BigEndian, uint32(len(metadata))); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(writer, metadata); err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&t.metadataSent, 1)
 	return nil
 }
 
+// writeCompressedEvents writes the events to the writer compressed. Closing
+// the compressor flushes the final block, so its error must be checked or the
+// payload may be truncated
+func (t *TransportTCP) writeCompressedEvents(writer io.Writer, events []*core.EventDescriptor) error {
+	compressor, err := zlib.NewWriterLevel(writer, 3)
+	if err != nil {
+		return err
+	}
+
+	if err := t.writeEvents(compressor, events); err != nil {
+		compressor.Close()
+		return err
+	}
+
+	return compressor.Close()
+}
+
+// recordCompression updates the compression counters for a payload and logs
+// its sizes, so the compression ratio can be monitored
+func (t *TransportTCP) recordCompression(events []*core.EventDescriptor, compressed int) {
+	var uncompressed int
+	for _, event := range events {
+		uncompressed += 4 + len(event.Event)
+	}
+
+	atomic.AddUint64(&t.uncompressedBytes, uint64(uncompressed))
+	atomic.AddUint64(&t.compressedBytes, uint64(compressed))
+	atomic.AddUint64(&t.compressedEvents, uint64(len(events)))
+
+	log.Debug("[%s] Compressed payload of %d events from %d to %d bytes", t.observer.Pool().Server(), len(events), uncompressed, compressed)
+}
+
+// writeEvents writes each event, prefixed with its length, to the writer
+func (t *TransportTCP) writeEvents(writer io.Writer, events []*core.EventDescriptor) error {
+	for _, event := range events {
+		if err := binary.Write(writer, binary.BigEndian, uint32(len(event.Event))); err != nil {
+			return err
+		}
+
+		if _, err := writer.Write(event.Event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// APIEncodable returns an admin API entry with the transport status
+func (t *TransportTCP) APIEncodable() admin.APIEncodable {
+	if t.config.transport != TransportTCPTLS && !t.config.ClockSkew && !t.config.CompressStats {
+		return nil
+	}
+
+	api := &admin.APIKeyValue{}
+	if t.config.transport == TransportTCPTLS {
+		api.SetEntry("fullHandshakes", admin.APINumber(atomic.LoadUint64(&t.fullHandshakes)))
+		api.SetEntry("resumedHandshakes", admin.APINumber(atomic.LoadUint64(&t.resumedHandshakes)))
+	}
+	if t.config.ClockSkew && atomic.LoadInt32(&t.clockSkewKnown) != 0 {
+		api.SetEntry("clockSkew", admin.APIFloat(time.Duration(atomic.LoadInt64(&t.clockSkew)).Seconds()))
+	}
+	if t.config.CompressStats {
+		uncompressed, compressed := atomic.LoadUint64(&t.uncompressedBytes), atomic.LoadUint64(&t.compressedBytes)
+		api.SetEntry("uncompressed_bytes", admin.APINumber(uncompressed))
+		api.SetEntry("compressed_bytes", admin.APINumber(compressed))
+		api.SetEntry("event_count", admin.APINumber(atomic.LoadUint64(&t.compressedEvents)))
+		if compressed != 0 {
+			api.SetEntry("compression_ratio", admin.APIFloat(float64(uncompressed)/float64(compressed)))
+		}
+	}
+	return api
+}
+
 // Ping the remote server
 func (t *TransportTCP) Ping() error {
+	// There is no ping under the ndjson and syslog protocols, so just PONG back
+	// once all prior writes have completed
+	if t.config.Protocol != ProtocolCourier {
+		t.sendChan <- &tcpMessage{event: transports.NewPongEvent(t.observer)}
+		return nil
+	}
+
 	// Encapsulate the ping into a message
 	// 4-byte message header (PING)
 	// 4-byte uint32 data length (0 length for PING)
-	t.sendChan <- []byte{'P', 'I', 'N', 'G', 0, 0, 0, 0}
+	t.sendChan <- &tcpMessage{data: []byte{'P', 'I', 'N', 'G', 0, 0, 0, 0}, ping: true}
 	return nil
 }
 
@@ -0,0 +1,588 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+func TestWriteInvalidEvent(t *testing.T) {
+	transport := &TransportTCP{
+		config:   &TransportTCPFactory{},
+		sendChan: make(chan *tcpMessage, 1),
+	}
+
+	var events []*core.EventDescriptor
+	for _, event := range []core.Event{
+		{"message": "invalid \xff\xfe utf-8"},
+		{"message": "unsupported value", "bad": math.NaN(), "nested": map[string]interface{}{"bad": math.Inf(1), "good": "kept"}},
+		{"message": "valid"},
+	} {
+		encoded, err := event.Encode("message")
+		if err != nil {
+			t.Fatalf("Failed to encode event: %s", err)
+		}
+		events = append(events, &core.EventDescriptor{Event: encoded})
+	}
+
+	if err := transport.Write("0123456789abcdef", "", events); err != nil {
+		t.Fatalf("Failed to write events: %s", err)
+	}
+
+	message := (<-transport.sendChan).data
+	if string(message[0:4]) != "JDAT" {
+		t.Fatalf("Unexpected message type: %q", message[0:4])
+	}
+	if length := binary.BigEndian.Uint32(message[4:8]); int(length) != len(message)-8 {
+		t.Fatalf("Incorrect message length: %d != %d", length, len(message)-8)
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(message[24:]))
+	if err != nil {
+		t.Fatalf("Failed to decompress: %s", err)
+	}
+
+	var decoded []map[string]interface{}
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, header); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Stream is not well-framed: %s", err)
+		}
+
+		data := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(reader, data); err != nil {
+			t.Fatalf("Stream is not well-framed: %s", err)
+		}
+
+		var event map[string]interface{}
+		if err := json.Unmarshal(data, &event); err != nil {
+			t.Fatalf("Event is not valid JSON: %s", err)
+		}
+		decoded = append(decoded, event)
+	}
+
+	if len(decoded) != 3 {
+		t.Fatalf("Unexpected number of events: %d", len(decoded))
+	}
+
+	if decoded[0]["message"] != "invalid \uFFFD\uFFFD utf-8" {
+		t.Errorf("Invalid UTF-8 was not replaced: %q", decoded[0]["message"])
+	}
+
+	if _, ok := decoded[1]["bad"]; ok {
+		t.Error("Unsupported value was not removed")
+	}
+	if nested, ok := decoded[1]["nested"].(map[string]interface{}); !ok || nested["good"] != "kept" || len(nested) != 1 {
+		t.Errorf("Nested field was not sanitized correctly: %v", decoded[1]["nested"])
+	}
+	if tags, ok := decoded[1]["tags"].([]interface{}); !ok || len(tags) != 1 || tags[0] != "_encodefailure" {
+		t.Errorf("Sanitized event was not tagged: %v", decoded[1]["tags"])
+	}
+
+	if decoded[2]["message"] != "valid" {
+		t.Errorf("Unexpected message: %v", decoded[2]["message"])
+	}
+}
+
+func TestWriteVersionedFraming(t *testing.T) {
+	transport := &TransportTCP{
+		config:   &TransportTCPFactory{},
+		sendChan: make(chan *tcpMessage, 1),
+	}
+
+	nonce := "0123456789abcdef0123"
+	if err := transport.Write(nonce, "", []*core.EventDescriptor{{Event: []byte(`{"message":"one"}`)}}); err != nil {
+		t.Fatalf("Failed to write events: %s", err)
+	}
+
+	message := (<-transport.sendChan).data
+	if string(message[0:4]) != "JDAV" {
+		t.Fatalf("Unexpected message type: %q", message[0:4])
+	}
+
+	framing, decodedNonce, _, err := transports.DecodePayloadHeader("JDAV", message[8:])
+	if err != nil {
+		t.Fatalf("Failed to decode payload header: %s", err)
+	}
+	if !framing.Versioned || framing.SequenceWidth != transports.LegacySequenceWidth || decodedNonce != nonce {
+		t.Errorf("Unexpected payload header: %v %q", framing, decodedNonce)
+	}
+}
+
+func TestWriteMetadata(t *testing.T) {
+	transport := &TransportTCP{
+		config:   &TransportTCPFactory{},
+		sendChan: make(chan *tcpMessage, 1),
+	}
+
+	nonce := "0123456789abcdef"
+	if err := transport.Write(nonce, "ingest", []*core.EventDescriptor{{Event: []byte(`{"message":"one"}`)}}); err != nil {
+		t.Fatalf("Failed to write events: %s", err)
+	}
+
+	message := (<-transport.sendChan).data
+	if string(message[0:4]) != "META" || binary.BigEndian.Uint32(message[4:8]) != 6 || string(message[8:14]) != "ingest" {
+		t.Fatalf("Unexpected metadata message: %q", message[0:14])
+	}
+
+	payload := message[14:]
+	if string(payload[0:4]) != "JDAT" {
+		t.Fatalf("Unexpected message type: %q", payload[0:4])
+	}
+	if length := binary.BigEndian.Uint32(payload[4:8]); int(length) != len(payload)-8 {
+		t.Fatalf("Incorrect message length: %d != %d", length, len(payload)-8)
+	}
+
+	// Once rejected metadata is no longer sent
+	transport.metadataRejected = 1
+	if err := transport.Write(nonce, "ingest", []*core.EventDescriptor{{Event: []byte(`{"message":"one"}`)}}); err != nil {
+		t.Fatalf("Failed to write events: %s", err)
+	}
+
+	if message := (<-transport.sendChan).data; string(message[0:4]) != "JDAT" {
+		t.Errorf("Metadata was sent after being rejected: %q", message[0:4])
+	}
+}
+
+func TestCompressionStats(t *testing.T) {
+	transport := &TransportTCP{
+		config:   &TransportTCPFactory{CompressStats: true},
+		observer: &testObserver{eventChan: make(chan transports.Event, 10)},
+		sendChan: make(chan *tcpMessage, 1),
+	}
+
+	event := []byte(`{"message":"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}`)
+	nonce := "0123456789abcdef"
+	if err := transport.Write(nonce, "", []*core.EventDescriptor{{Event: event}, {Event: event}}); err != nil {
+		t.Fatalf("Failed to write events: %s", err)
+	}
+
+	message := (<-transport.sendChan).data
+	compressed := uint64(len(message) - 8 - len(nonce))
+	if transport.uncompressedBytes != uint64(2*(4+len(event))) {
+		t.Errorf("Incorrect uncompressed bytes: %d", transport.uncompressedBytes)
+	}
+	if transport.compressedBytes != compressed {
+		t.Errorf("Incorrect compressed bytes: %d != %d", transport.compressedBytes, compressed)
+	}
+	if transport.compressedEvents != 2 {
+		t.Errorf("Incorrect event count: %d", transport.compressedEvents)
+	}
+
+	// Uncompressed payloads are not counted
+	transport.config.MinCompressBytes = 1000
+	if err := transport.Write(nonce, "", []*core.EventDescriptor{{Event: event}}); err != nil {
+		t.Fatalf("Failed to write events: %s", err)
+	}
+	<-transport.sendChan
+
+	if transport.compressedEvents != 2 {
+		t.Errorf("Uncompressed payload was counted: %d", transport.compressedEvents)
+	}
+}
+
+// failingWriter accepts a number of writes and then fails
+type failingWriter struct {
+	allowed int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.allowed == 0 {
+		return 0, errors.New("write failed")
+	}
+	w.allowed--
+	return len(p), nil
+}
+
+func TestWriteCompressedEventsFlushError(t *testing.T) {
+	transport := &TransportTCP{
+		config: &TransportTCPFactory{},
+	}
+
+	events := []*core.EventDescriptor{{Event: []byte(`{"message":"one"}`)}}
+
+	// The zlib header is written immediately, but the compressed data is only
+	// written when the compressor is closed
+	if err := transport.writeCompressedEvents(&failingWriter{allowed: 1}, events); err == nil {
+		t.Error("Error flushing the final compressed block was not returned")
+	}
+
+	if err := transport.writeCompressedEvents(&bytes.Buffer{}, events); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	transport := &TransportTCP{
+		config:   &TransportTCPFactory{ClockSkew: true, MaxClockSkew: time.Second},
+		observer: &testObserver{eventChan: make(chan transports.Event, 10)},
+	}
+
+	// PONG for the transport's own PING, from a remote one hour ahead
+	sent := time.Now()
+	transport.pingTimes = []time.Time{sent}
+	transport.skewPings = 1
+
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(sent.Add(time.Hour).UnixNano()))
+	if !transport.processPong(message) {
+		t.Error("PONG for clock skew measurement was not identified")
+	}
+
+	skew := time.Duration(transport.clockSkew)
+	if skew < 59*time.Minute || skew > time.Hour {
+		t.Errorf("Incorrect clock skew: %v", skew)
+	}
+
+	if global, ok := core.ClockSkew(); !ok || global != skew {
+		t.Errorf("Clock skew was not recorded globally: %v", global)
+	}
+
+	// PONG for a publisher PING without a time
+	transport.pingTimes = []time.Time{time.Now()}
+	if transport.processPong(nil) {
+		t.Error("PONG for publisher was treated as clock skew measurement")
+	}
+	if time.Duration(transport.clockSkew) != skew {
+		t.Error("Clock skew changed without a time in the PONG")
+	}
+}
+
+func checkNegotiateCompression(t *testing.T, response []byte, expected int32) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	transport := &TransportTCP{
+		config: &TransportTCPFactory{
+			StreamCompress: StreamCompressionZlib,
+			netConfig:      &config.Network{Timeout: 5 * time.Second},
+		},
+		observer: &testObserver{eventChan: make(chan transports.Event, 10)},
+		socket:   client,
+	}
+
+	go func() {
+		defer server.Close()
+		request := make([]byte, 12)
+		if _, err := io.ReadFull(server, request); err != nil || string(request) != "CMPR\x00\x00\x00\x04zlib" {
+			return
+		}
+		server.Write(response)
+	}()
+
+	if err := transport.negotiateCompression(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if transport.streamCompressed != expected {
+		t.Errorf("Incorrect stream compression state for response %q: %d", response, transport.streamCompressed)
+	}
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	checkNegotiateCompression(t, []byte("CMPR\x00\x00\x00\x04zlib"), 1)
+	checkNegotiateCompression(t, []byte("CMPR\x00\x00\x00\x00"), 0)
+	checkNegotiateCompression(t, []byte("????\x00\x00\x00\x00"), 0)
+}
+
+// slowConn delivers everything written to it one or two bytes at a time, as a
+// slow link splitting messages across many TCP segments would, pausing for
+// longer than the socket read interval once pauseAt bytes have been written
+type slowConn struct {
+	net.Conn
+
+	written int
+	pauseAt int
+}
+
+func (c *slowConn) Write(b []byte) (int, error) {
+	for i := 0; i < len(b); {
+		if c.pauseAt != 0 && c.written >= c.pauseAt {
+			c.pauseAt = 0
+			time.Sleep(socketIntervalSeconds*time.Second + 500*time.Millisecond)
+		}
+
+		end := i + 1 + c.written%2
+		if end > len(b) {
+			end = len(b)
+		}
+
+		n, err := c.Conn.Write(b[i:end])
+		i += n
+		c.written += n
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// createReceiverTestMessages returns an ACKN message followed by a PONG message
+func createReceiverTestMessages() []byte {
+	return []byte("ACKN\x00\x00\x00\x14abcdefghijklmnop\x00\x00\x00\x2aPONG\x00\x00\x00\x00")
+}
+
+// checkReceiver runs the receiver against the given socket and checks that the
+// messages from createReceiverTestMessages are received intact
+func checkReceiver(t *testing.T, transport *TransportTCP) {
+	observer := &testObserver{eventChan: make(chan transports.Event, 10)}
+	transport.config = &TransportTCPFactory{}
+	transport.observer = observer
+	transport.recvControl = make(chan int)
+	transport.failChan = make(chan error, 1)
+
+	transport.wait.Add(1)
+	go transport.receiver()
+	defer func() {
+		close(transport.recvControl)
+		transport.wait.Wait()
+	}()
+
+	for _, expected := range []string{"ACKN", "PONG"} {
+		select {
+		case event := <-observer.eventChan:
+			switch typed := event.(type) {
+			case *transports.AckEvent:
+				if expected != "ACKN" || typed.Nonce() != "abcdefghijklmnop" || typed.Sequence() != 42 {
+					t.Errorf("Unexpected acknowledgement: %q %d", typed.Nonce(), typed.Sequence())
+				}
+			case *transports.PongEvent:
+				if expected != "PONG" {
+					t.Error("Unexpected PONG")
+				}
+			default:
+				t.Errorf("Unexpected event: %T", event)
+			}
+		case err := <-transport.failChan:
+			t.Fatalf("Receiver failed: %s", err)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for %s", expected)
+		}
+	}
+}
+
+func TestReceiverPartialReads(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+		conn := &slowConn{Conn: server, pauseAt: 10}
+		conn.Write(createReceiverTestMessages())
+
+		// Hold the connection open until the test closes it
+		io.Copy(ioutil.Discard, server)
+	}()
+
+	checkReceiver(t, &TransportTCP{socket: client})
+}
+
+func createTestCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestReceiverPartialTLSRecords(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	slow := &slowConn{Conn: server}
+	tlsServer := tls.Server(slow, &tls.Config{Certificates: []tls.Certificate{createTestCertificate(t)}})
+
+	go func() {
+		defer server.Close()
+		if err := tlsServer.Handshake(); err != nil {
+			return
+		}
+
+		// Pause part way through the record containing the messages
+		slow.pauseAt = slow.written + 10
+		tlsServer.Write(createReceiverTestMessages())
+
+		// Hold the connection open until the test closes it
+		io.Copy(ioutil.Discard, tlsServer)
+	}()
+
+	transport := &TransportTCP{}
+	tlsClient := tls.Client(&transportTCPWrap{transport: transport, tcpsocket: client}, &tls.Config{InsecureSkipVerify: true})
+	if err := tlsClient.Handshake(); err != nil {
+		t.Fatalf("TLS handshake failed: %s", err)
+	}
+
+	transport.socket = tlsClient
+	checkReceiver(t, transport)
+}
+
+type testServerObserver struct {
+	pool      *addresspool.Pool
+	eventChan chan transports.Event
+}
+
+func (o *testServerObserver) Pool() *addresspool.Pool {
+	return o.pool
+}
+
+func (o *testServerObserver) EventChan() chan<- transports.Event {
+	return o.eventChan
+}
+
+func checkConnectServerName(t *testing.T, serverName string, expectSuccess bool) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	// The receiver certificate is only valid for a hostname, not its IP
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "receiver.example.com"},
+		DNSNames:     []string{"receiver.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}})
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if err := conn.(*tls.Conn).Handshake(); err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	dir, err := ioutil.TempDir("", "servername")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %s", err)
+	}
+
+	server := listener.Addr().String()
+	cfg := config.NewConfig()
+	cfg.Network.Servers = []string{server}
+	cfg.Network.Timeout = 5 * time.Second
+
+	factory, err := NewTransportTCPFactory(cfg, "/network/", map[string]interface{}{"ssl ca": caFile, "ssl server name": serverName}, TransportTCPTLS)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	transport := &TransportTCP{
+		config:         factory.(*TransportTCPFactory),
+		observer:       &testServerObserver{pool: addresspool.NewPool(server), eventChan: make(chan transports.Event, 10)},
+		controllerChan: make(chan int),
+	}
+
+	_, err = transport.connect()
+	transport.disconnect("test complete")
+
+	if expectSuccess && err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	} else if !expectSuccess && err == nil {
+		t.Error("Connection with mismatched server name was accepted")
+	}
+}
+
+func TestConnectServerName(t *testing.T) {
+	checkConnectServerName(t, "receiver.example.com", true)
+}
+
+func TestConnectServerNameMismatch(t *testing.T) {
+	checkConnectServerName(t, "other.example.com", false)
+
+	// Without a server name the IP address is verified
+	checkConnectServerName(t, "", false)
+}
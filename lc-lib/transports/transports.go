@@ -20,6 +20,7 @@ import (
 	"errors"
 
 	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/admin"
 	"github.com/driskell/log-courier/lc-lib/core"
 )
 
@@ -37,11 +38,12 @@ type Observer interface {
 
 // Transport is the generic interface that all transports implement
 type Transport interface {
+	APIEncodable() admin.APIEncodable
 	Fail()
 	Ping() error
 	ReloadConfig(interface{}, bool) bool
 	Shutdown()
-	Write(string, []*core.EventDescriptor) error
+	Write(string, string, []*core.EventDescriptor) error
 }
 
 // transportFactory is the interface that all transport factories implement. The
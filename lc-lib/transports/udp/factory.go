@@ -0,0 +1,104 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+var (
+	// TransportUDPUDP is the transport name for UDP
+	TransportUDPUDP = "udp"
+)
+
+const (
+	// minDatagramSize is the smallest datagram size allowed, which is the minimum
+	// that every IPv4 host must be able to reassemble, less the IP and UDP
+	// headers
+	minDatagramSize int64 = 548
+	// maxDatagramSize is the largest payload a UDP datagram can carry over IPv4
+	maxDatagramSize int64 = 65507
+)
+
+const (
+	// Default datagram size fits an ethernet MTU of 1500 once the 20 byte IPv4
+	// and 8 byte UDP headers are added, so datagrams are not fragmented
+	defaultNetworkMaxDatagramSize int64         = 1472
+	defaultNetworkReconnect       time.Duration = 0 * time.Second
+	defaultNetworkReconnectMax    time.Duration = 300 * time.Second
+)
+
+// TransportUDPFactory holds the configuration from the configuration file
+// It allows creation of TransportUDP instances that use this configuration
+type TransportUDPFactory struct {
+	MaxDatagramSize int64         `config:"max datagram size"`
+	Reconnect       time.Duration `config:"reconnect backoff"`
+	ReconnectMax    time.Duration `config:"reconnect backoff max"`
+
+	netConfig *config.Network
+}
+
+// NewTransportUDPFactory create a new TransportUDPFactory from the provided
+// configuration data, reporting back any configuration errors it discovers.
+func NewTransportUDPFactory(config *config.Config, configPath string, unUsed map[string]interface{}, name string) (interface{}, error) {
+	ret := &TransportUDPFactory{
+		netConfig: &config.Network,
+	}
+
+	if err := config.PopulateConfig(ret, unUsed, configPath); err != nil {
+		return nil, err
+	}
+
+	if ret.MaxDatagramSize < minDatagramSize || ret.MaxDatagramSize > maxDatagramSize {
+		return nil, fmt.Errorf("max datagram size must be between %d and %d", minDatagramSize, maxDatagramSize)
+	}
+
+	return ret, nil
+}
+
+// InitDefaults sets the default configuration values
+func (f *TransportUDPFactory) InitDefaults() {
+	f.MaxDatagramSize = defaultNetworkMaxDatagramSize
+	f.Reconnect = defaultNetworkReconnect
+	f.ReconnectMax = defaultNetworkReconnectMax
+}
+
+// NewTransport returns a new Transport interface using the settings from the
+// TransportUDPFactory.
+func (f *TransportUDPFactory) NewTransport(observer transports.Observer, finishOnFail bool) transports.Transport {
+	ret := &TransportUDP{
+		config:         f,
+		finishOnFail:   finishOnFail,
+		observer:       observer,
+		controllerChan: make(chan int),
+		backoff:        core.NewExpBackoff(observer.Pool().Server()+" Reconnect", f.Reconnect, f.ReconnectMax),
+	}
+
+	go ret.controller()
+
+	return ret
+}
+
+// Register the transport
+func init() {
+	config.RegisterTransport(TransportUDPUDP, NewTransportUDPFactory)
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+// TransportUDP implements a transport that sends newline delimited JSON in UDP
+// datagrams. There are no acknowledgements, so delivery is at-most-once: each
+// payload is acknowledged as soon as it is written, and events lost in transit
+// are never resent
+type TransportUDP struct {
+	config       *TransportUDPFactory
+	finishOnFail bool
+	socket       net.Conn
+	backoff      *core.ExpBackoff
+
+	controllerChan chan int
+	observer       transports.Observer
+	failChan       chan error
+
+	wait        sync.WaitGroup
+	sendControl chan int
+
+	sendChan chan *udpMessage
+
+	// Events dropped because they were too large to fit in a datagram
+	droppedEvents uint64
+}
+
+// udpMessage holds the datagrams for a payload along with the event to send to
+// the observer once they are written
+type udpMessage struct {
+	datagrams [][]byte
+	event     transports.Event
+}
+
+// ReloadConfig returns true if the transport needs to be restarted in order
+// for the new configuration to apply
+func (t *TransportUDP) ReloadConfig(factoryInterface interface{}, finishOnFail bool) bool {
+	newConfig := factoryInterface.(*TransportUDPFactory)
+	t.finishOnFail = finishOnFail
+
+	t.config.netConfig = newConfig.netConfig
+	t.config.MaxDatagramSize = newConfig.MaxDatagramSize
+
+	return false
+}
+
+// controller is the master routine which handles connection and reconnection
+// When reconnecting, the socket and sender are torn down and restarted
+func (t *TransportUDP) controller() {
+	defer func() {
+		t.sendEvent(nil, transports.NewStatusEvent(t.observer, transports.Finished))
+	}()
+
+	for {
+		err := t.connect()
+		if err == nil {
+			t.backoff.Reset()
+
+			select {
+			case <-t.controllerChan:
+				// Shutdown request
+				t.disconnect()
+				return
+			case err = <-t.failChan:
+				// If err is nil, it's a forced failure by publisher
+				if err == nil {
+					err = transports.ErrForcedFailure
+				}
+			}
+		}
+
+		if t.finishOnFail {
+			log.Errorf("[%s] Transport error: %s", t.observer.Pool().Server(), err)
+			t.disconnect()
+			return
+		}
+
+		log.Errorf("[%s] Transport error, reconnecting: %s", t.observer.Pool().Server(), err)
+		t.disconnect()
+
+		if t.sendEvent(t.controllerChan, transports.NewStatusEvent(t.observer, transports.Failed)) {
+			return
+		}
+
+		if !t.reconnectWait() {
+			return
+		}
+	}
+}
+
+// reconnectWait waits the reconnect timeout before attempting to reconnect,
+// returning false if shutdown was requested while waiting
+func (t *TransportUDP) reconnectWait() bool {
+	select {
+	case <-t.controllerChan:
+		return false
+	case <-time.After(t.backoff.Trigger()):
+	}
+
+	return true
+}
+
+// connect resolves the next address and starts the sender. No packets are
+// exchanged, so there is no way to know if the remote is listening
+func (t *TransportUDP) connect() error {
+	addr, err := t.observer.Pool().Next()
+	if err != nil {
+		return fmt.Errorf("Failed to select next address: %s", err)
+	}
+
+	socket, err := net.DialTimeout("udp", addr.String(), t.config.netConfig.Timeout)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to %s: %s", t.observer.Pool().Desc(), err)
+	}
+
+	t.socket = socket
+
+	log.Notice("[%s] Sending datagrams to %s", t.observer.Pool().Server(), t.observer.Pool().Desc())
+
+	t.sendControl = make(chan int, 1)
+	t.sendChan = make(chan *udpMessage, t.config.netConfig.MaxPendingPayloads)
+	t.failChan = make(chan error, 2)
+
+	t.wait.Add(1)
+	go t.sender()
+
+	return nil
+}
+
+// disconnect shuts down the sender and closes the socket
+func (t *TransportUDP) disconnect() {
+	if t.sendControl == nil {
+		return
+	}
+
+	close(t.sendControl)
+	t.wait.Wait()
+	t.sendControl = nil
+
+	t.socket.Close()
+}
+
+// sender handles socket writes
+func (t *TransportUDP) sender() {
+	defer func() {
+		t.wait.Done()
+	}()
+
+	// Send a started signal to say we're ready to receive events
+	if t.sendEvent(t.controllerChan, transports.NewStatusEvent(t.observer, transports.Started)) {
+		return
+	}
+
+	for {
+		select {
+		case <-t.sendControl:
+			return
+		case msg := <-t.sendChan:
+			for _, datagram := range msg.datagrams {
+				t.socket.SetWriteDeadline(time.Now().Add(t.config.netConfig.Timeout))
+				if _, err := t.socket.Write(datagram); err != nil {
+					select {
+					case <-t.sendControl:
+					case t.failChan <- err:
+					}
+					return
+				}
+			}
+
+			if msg.event != nil && t.sendEvent(t.sendControl, msg.event) {
+				return
+			}
+		}
+	}
+}
+
+// sendEvent ships an event structure to the observer whilst also monitoring for
+// any shutdown signal. Returns true if shutdown was signalled
+func (t *TransportUDP) sendEvent(controlChan <-chan int, event transports.Event) bool {
+	select {
+	case <-controlChan:
+		return true
+	case t.observer.EventChan() <- event:
+	}
+	return false
+}
+
+// Write queues the events to be sent as newline delimited JSON, packing as
+// many lines into each datagram as will fit. An event too large to fit in a
+// datagram by itself is dropped with a warning. The payload is acknowledged as
+// soon as the datagrams are written
+func (t *TransportUDP) Write(nonce string, metadata string, events []*core.EventDescriptor) error {
+	t.sendChan <- &udpMessage{
+		datagrams: t.buildDatagrams(events),
+		event:     transports.NewAckEvent(t.observer, nonce, uint64(len(events))),
+	}
+	return nil
+}
+
+// buildDatagrams splits the events into datagrams no larger than the maximum
+// datagram size
+func (t *TransportUDP) buildDatagrams(events []*core.EventDescriptor) [][]byte {
+	var datagrams [][]byte
+	var datagram []byte
+
+	maxSize := int(t.config.MaxDatagramSize)
+
+	for _, event := range events {
+		size := len(event.Event) + 1
+		if size > maxSize {
+			atomic.AddUint64(&t.droppedEvents, 1)
+			log.Warning("[%s] Dropping event of %d bytes as it exceeds the max datagram size of %d bytes", t.observer.Pool().Server(), size, maxSize)
+			continue
+		}
+
+		if len(datagram)+size > maxSize {
+			datagrams = append(datagrams, datagram)
+			datagram = nil
+		}
+
+		datagram = append(datagram, event.Event...)
+		datagram = append(datagram, '\n')
+	}
+
+	if datagram != nil {
+		datagrams = append(datagrams, datagram)
+	}
+
+	return datagrams
+}
+
+// APIEncodable returns an admin API entry with the transport status
+func (t *TransportUDP) APIEncodable() admin.APIEncodable {
+	api := &admin.APIKeyValue{}
+	api.SetEntry("dropped_events", admin.APINumber(atomic.LoadUint64(&t.droppedEvents)))
+	return api
+}
+
+// Ping the remote server. There is no ping over UDP, so just PONG back once all
+// prior writes have completed
+func (t *TransportUDP) Ping() error {
+	t.sendChan <- &udpMessage{event: transports.NewPongEvent(t.observer)}
+	return nil
+}
+
+// Fail the transport
+func (t *TransportUDP) Fail() {
+	t.failChan <- nil
+}
+
+// Shutdown the transport
+func (t *TransportUDP) Shutdown() {
+	close(t.controllerChan)
+}
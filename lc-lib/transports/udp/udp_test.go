@@ -0,0 +1,104 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transports
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/addresspool"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/transports"
+)
+
+type testObserver struct {
+	eventChan chan transports.Event
+}
+
+func (o *testObserver) Pool() *addresspool.Pool {
+	return addresspool.NewPool("127.0.0.1:1234")
+}
+
+func (o *testObserver) EventChan() chan<- transports.Event {
+	return o.eventChan
+}
+
+func createUDPTransport(maxSize int64) *TransportUDP {
+	return &TransportUDP{
+		config:   &TransportUDPFactory{MaxDatagramSize: maxSize},
+		observer: &testObserver{eventChan: make(chan transports.Event, 10)},
+		sendChan: make(chan *udpMessage, 10),
+	}
+}
+
+func udpEvents(messages ...string) []*core.EventDescriptor {
+	var events []*core.EventDescriptor
+	for _, message := range messages {
+		encoded, _ := core.Event{"message": message}.Encode("message")
+		events = append(events, &core.EventDescriptor{Event: encoded})
+	}
+	return events
+}
+
+func TestUDPWrite(t *testing.T) {
+	transport := createUDPTransport(1472)
+
+	if err := transport.Write("0123456789abcdef", "", udpEvents("one", "two")); err != nil {
+		t.Fatalf("Failed to write events: %s", err)
+	}
+
+	message := <-transport.sendChan
+	if len(message.datagrams) != 1 || string(message.datagrams[0]) != "{\"message\":\"one\"}\n{\"message\":\"two\"}\n" {
+		t.Errorf("Unexpected datagrams: %q", message.datagrams)
+	}
+
+	// Acknowledged as soon as it is written
+	ack, ok := message.event.(*transports.AckEvent)
+	if !ok {
+		t.Fatalf("Unexpected event: %v", message.event)
+	}
+	if ack.Nonce() != "0123456789abcdef" || ack.Sequence() != 2 {
+		t.Errorf("Incorrect acknowledgement: %s/%d", ack.Nonce(), ack.Sequence())
+	}
+}
+
+func TestUDPSplitDatagrams(t *testing.T) {
+	// Each encoded event is 18 bytes including the new line, so two fit
+	transport := createUDPTransport(40)
+
+	datagrams := transport.buildDatagrams(udpEvents("one", "two", "six", "ten", "ace"))
+	if len(datagrams) != 3 {
+		t.Fatalf("Unexpected number of datagrams: %q", datagrams)
+	}
+	if string(datagrams[0]) != "{\"message\":\"one\"}\n{\"message\":\"two\"}\n" {
+		t.Errorf("Unexpected first datagram: %q", datagrams[0])
+	}
+	if string(datagrams[2]) != "{\"message\":\"ace\"}\n" {
+		t.Errorf("Unexpected last datagram: %q", datagrams[2])
+	}
+}
+
+func TestUDPDropOversizedEvent(t *testing.T) {
+	transport := createUDPTransport(20)
+
+	datagrams := transport.buildDatagrams(udpEvents("one", "oversized", "two"))
+	if len(datagrams) != 2 || string(datagrams[0]) != "{\"message\":\"one\"}\n" || string(datagrams[1]) != "{\"message\":\"two\"}\n" {
+		t.Errorf("Unexpected datagrams: %q", datagrams)
+	}
+	if transport.droppedEvents != 1 {
+		t.Errorf("Unexpected dropped event count: %d", transport.droppedEvents)
+	}
+}
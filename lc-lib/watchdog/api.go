@@ -0,0 +1,43 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package watchdog
+
+import (
+	"github.com/driskell/log-courier/lc-lib/admin"
+)
+
+type apiStatus struct {
+	admin.APIKeyValue
+
+	w *MemoryWatchdog
+}
+
+// Update updates the watchdog status information
+func (a *apiStatus) Update() error {
+	a.w.mutex.RLock()
+	if a.w.paused {
+		a.SetEntry("status", admin.APIString("paused"))
+	} else {
+		a.SetEntry("status", admin.APIString("ok"))
+	}
+	a.SetEntry("memory_bytes", admin.APINumber(a.w.memory))
+	a.SetEntry("memory_limit", admin.APINumber(a.w.config.MemoryLimit))
+	a.SetEntry("memory_pauses", admin.APINumber(a.w.pauses))
+	a.w.mutex.RUnlock()
+
+	return nil
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package watchdog
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/admin"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+const (
+	// watchdogInterval is how often memory usage is checked
+	watchdogInterval = time.Second
+)
+
+var (
+	// readMemory returns the memory obtained from the operating system by the
+	// runtime that has not been returned, which approximates the resident size.
+	// It is a variable so tests can replace it
+	readMemory = func() int64 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return int64(stats.Sys - stats.HeapReleased)
+	}
+
+	// freeMemory forces a garbage collection and returns as much memory to the
+	// operating system as possible. It is a variable so tests can replace it
+	freeMemory = debug.FreeOSMemory
+)
+
+// Pausable is implemented by the pipeline segment that the watchdog pauses,
+// which is the Spooler
+type Pausable interface {
+	Pause()
+	Resume()
+}
+
+// MemoryWatchdog pauses harvesting when memory usage exceeds the memory limit,
+// and resumes it once usage falls below the low water mark. Harvesting is
+// paused by pausing the spooler, so events already spooled continue through
+// the pipeline and are acknowledged as normal while no new ones are read
+type MemoryWatchdog struct {
+	core.PipelineSegment
+	core.PipelineConfigReceiver
+
+	mutex sync.RWMutex
+
+	config      *config.General
+	adminConfig *admin.Config
+	target      Pausable
+
+	paused bool
+	memory int64
+	pauses uint64
+}
+
+// NewMemoryWatchdog creates a new memory watchdog on the pipeline that pauses
+// the given target
+func NewMemoryWatchdog(pipeline *core.Pipeline, config *config.Config, target Pausable) *MemoryWatchdog {
+	ret := &MemoryWatchdog{
+		config:      &config.General,
+		adminConfig: config.Get("admin").(*admin.Config),
+		target:      target,
+	}
+
+	ret.initAPI()
+
+	pipeline.Register(ret)
+
+	return ret
+}
+
+// Run checks memory usage at each interval until shutdown
+func (w *MemoryWatchdog) Run() {
+	defer func() {
+		w.Done()
+	}()
+
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+WatchdogLoop:
+	for {
+		select {
+		case <-w.OnShutdown():
+			break WatchdogLoop
+		case <-ticker.C:
+			w.check()
+		case config := <-w.OnConfig():
+			w.mutex.Lock()
+			w.config = &config.General
+			w.mutex.Unlock()
+			w.check()
+		}
+	}
+
+	log.Info("Memory watchdog exiting")
+}
+
+// check measures memory usage and pauses or resumes harvesting as required
+func (w *MemoryWatchdog) check() {
+	if w.paused {
+		// Nothing new is being allocated while paused, so garbage collection may
+		// not otherwise run to release the memory of events that have drained
+		freeMemory()
+
+		memory := readMemory()
+		w.setMemory(memory)
+		if w.config.MemoryLimit != 0 && memory > w.config.MemoryLowWater {
+			return
+		}
+
+		log.Notice("Memory usage has fallen to %d bytes, resuming harvesting", memory)
+		w.setPaused(false)
+		w.target.Resume()
+		return
+	}
+
+	memory := readMemory()
+	w.setMemory(memory)
+	if w.config.MemoryLimit == 0 || memory <= w.config.MemoryLimit {
+		return
+	}
+
+	log.Warning("Memory usage of %d bytes exceeds the memory limit of %d bytes, pausing harvesting until it falls below %d bytes", memory, w.config.MemoryLimit, w.config.MemoryLowWater)
+	w.setPaused(true)
+	w.target.Pause()
+	freeMemory()
+}
+
+// setMemory records the latest measurement of memory usage
+func (w *MemoryWatchdog) setMemory(memory int64) {
+	w.mutex.Lock()
+	w.memory = memory
+	w.mutex.Unlock()
+}
+
+// setPaused records whether harvesting is paused, counting each pause
+func (w *MemoryWatchdog) setPaused(paused bool) {
+	w.mutex.Lock()
+	w.paused = paused
+	if paused {
+		w.pauses++
+	}
+	w.mutex.Unlock()
+}
+
+// initAPI initialises the watchdog API entries
+func (w *MemoryWatchdog) initAPI() {
+	// Is admin loaded into the pipeline?
+	if !w.adminConfig.APIEnabled() {
+		return
+	}
+
+	w.adminConfig.SetEntry("watchdog", &apiStatus{w: w})
+}
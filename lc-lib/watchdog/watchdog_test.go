@@ -0,0 +1,89 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package watchdog
+
+import (
+	"testing"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+)
+
+type testTarget struct {
+	paused bool
+}
+
+func (t *testTarget) Pause() {
+	t.paused = true
+}
+
+func (t *testTarget) Resume() {
+	t.paused = false
+}
+
+func TestMemoryWatchdog(t *testing.T) {
+	memory, frees := int64(0), 0
+	defer func(origRead func() int64, origFree func()) {
+		readMemory, freeMemory = origRead, origFree
+	}(readMemory, freeMemory)
+	readMemory = func() int64 { return memory }
+	freeMemory = func() { frees++ }
+
+	cfg := config.NewConfig()
+	cfg.General.MemoryLimit = 1000
+	cfg.General.MemoryLowWater = 800
+
+	target := &testTarget{}
+	watchdog := &MemoryWatchdog{config: &cfg.General, target: target}
+
+	memory = 1000
+	watchdog.check()
+	if target.paused {
+		t.Fatal("Paused at the memory limit")
+	}
+
+	memory = 1001
+	watchdog.check()
+	if !target.paused || watchdog.pauses != 1 || frees != 1 {
+		t.Fatalf("Did not pause above the memory limit: paused=%v pauses=%d frees=%d", target.paused, watchdog.pauses, frees)
+	}
+
+	// Remains paused until below the low water mark, freeing memory each check
+	memory = 900
+	watchdog.check()
+	if !target.paused || frees != 2 {
+		t.Fatalf("Resumed above the low water mark: paused=%v frees=%d", target.paused, frees)
+	}
+
+	memory = 800
+	watchdog.check()
+	if target.paused || watchdog.pauses != 1 || watchdog.memory != 800 {
+		t.Fatalf("Did not resume at the low water mark: paused=%v pauses=%d memory=%d", target.paused, watchdog.pauses, watchdog.memory)
+	}
+
+	memory = 2000
+	watchdog.check()
+	if !target.paused || watchdog.pauses != 2 {
+		t.Fatalf("Did not pause again: paused=%v pauses=%d", target.paused, watchdog.pauses)
+	}
+
+	// Removing the limit resumes immediately
+	cfg.General.MemoryLimit = 0
+	watchdog.check()
+	if target.paused {
+		t.Fatal("Did not resume when the limit was removed")
+	}
+}
@@ -20,27 +20,36 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	stdlog "log"
 	"os"
+	"reflect"
 	"runtime"
-	"runtime/pprof"
+	"strings"
 	"time"
 
 	"github.com/driskell/log-courier/lc-lib/admin"
 	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/diskspool"
 	"github.com/driskell/log-courier/lc-lib/harvester"
+	"github.com/driskell/log-courier/lc-lib/hostmeta"
+	"github.com/driskell/log-courier/lc-lib/kafka"
 	"github.com/driskell/log-courier/lc-lib/prospector"
 	"github.com/driskell/log-courier/lc-lib/publisher"
+	"github.com/driskell/log-courier/lc-lib/receiver"
 	"github.com/driskell/log-courier/lc-lib/registrar"
 	"github.com/driskell/log-courier/lc-lib/spooler"
+	"github.com/driskell/log-courier/lc-lib/watchdog"
 	"gopkg.in/op/go-logging.v1"
 )
 
 import _ "github.com/driskell/log-courier/lc-lib/codecs"
+import _ "github.com/driskell/log-courier/lc-lib/processors"
 import _ "github.com/driskell/log-courier/lc-lib/transports/tcp"
+import _ "github.com/driskell/log-courier/lc-lib/transports/udp"
 
 // Generate platform-specific default configuration values
 //go:generate go run lc-lib/config/generate/platform.go platform main config.DefaultConfigurationFile config.DefaultGeneralPersistDir admin.DefaultAdminBind
@@ -48,7 +57,9 @@ import _ "github.com/driskell/log-courier/lc-lib/transports/tcp"
 //go:generate go run lc-lib/config/generate/platform.go lc-admin/platform main config.DefaultConfigurationFile config.DefaultGeneralPersistDir admin.DefaultAdminBind
 
 func main() {
-	newLogCourier().Run()
+	lc := newLogCourier()
+	lc.Run()
+	os.Exit(lc.exitCode)
 }
 
 // logCourier is the root structure for the log-courier binary
@@ -59,11 +70,19 @@ type logCourier struct {
 	reloadChan    chan os.Signal
 	configFile    string
 	stdin         bool
+	oneshot       bool
+	oneshotWait   time.Duration
 	fromBeginning bool
+	exitCode      int
 	harvester     *harvester.Harvester
+	oneshotRun    *oneshot
+	prospector    *prospector.Prospector
+	receivers     []*receiver.Receiver
+	consumers     []*kafka.Consumer
 	logFile       *DefaultLogBackend
 	lastSnapshot  time.Time
 	snapshot      *core.Snapshot
+	profiler      profiler
 }
 
 // newLogCourier creates a new LogCourier structure for the log-courier binary
@@ -77,45 +96,94 @@ func newLogCourier() *logCourier {
 // Run starts the log-courier binary
 func (lc *logCourier) Run() {
 	var harvesterWait <-chan *harvester.FinishStatus
+	var oneshotWait <-chan error
 	var registrarImp registrar.Registrator
 
 	lc.startUp()
 
 	log.Info("Log Courier version %s pipeline starting", core.LogCourierVersion)
 
-	// If reading from stdin, skip admin, and set up a null registrar
-	if lc.stdin {
-		registrarImp = newStdinRegistrar(lc.pipeline)
+	// Collect host metadata before anything is harvested so it is present on
+	// every event
+	hostmeta.NewCollector(lc.pipeline, lc.config)
+
+	// If reading from stdin or in oneshot mode, skip admin, and set up a null
+	// registrar
+	if lc.stdin || lc.oneshot {
+		registrarImp = newStdinRegistrar(lc.pipeline, lc.config)
 	} else {
-		if lc.config.Get("admin").(*admin.Config).Enabled {
+		adminConfig := lc.config.Get("admin").(*admin.Config)
+
+		if adminConfig.Enabled {
 			var err error
 
 			// TODO: Reload config and load config should be in core along with
 			// logging implementation
-			_, err = admin.NewServer(lc.pipeline, lc.config, func() error {
-				return lc.reloadConfig()
-			})
+			_, err = admin.NewServer(lc.pipeline, lc.config, lc.reloadAPI)
 			if err != nil {
 				log.Fatalf("Failed to initialise: %s", err)
 			}
 		}
 
-		registrarImp = registrar.NewRegistrar(lc.pipeline, lc.config.General.PersistDir)
+		if lc.config.General.StatsdAddress != "" {
+			adminConfig.InitAPI(lc.reloadAPI)
+			admin.NewStatsdExporter(lc.pipeline, lc.config)
+		}
+
+		registrarImp = registrar.NewRegistrar(lc.pipeline, lc.config)
 	}
 
 	publisherImp := publisher.NewPublisher(lc.pipeline, lc.config, registrarImp)
+	if lc.config.General.PersistPending && !lc.stdin && !lc.oneshot {
+		if err := publisherImp.RestorePending(); err != nil {
+			log.Fatalf("Failed to initialise: %s", err)
+		}
+	}
 
-	spoolerImp := spooler.NewSpooler(lc.pipeline, &lc.config.General, publisherImp)
+	var spoolerOutput spooler.Output = publisherImp
+	if lc.config.General.DiskSpool && !lc.stdin && !lc.oneshot {
+		diskSpoolImp, err := diskspool.NewDiskSpool(lc.pipeline, lc.config, registrarImp, publisherImp)
+		if err != nil {
+			log.Fatalf("Failed to initialise: %s", err)
+		}
+		spoolerOutput = diskSpoolImp
+	}
+
+	spoolerImp := spooler.NewSpooler(lc.pipeline, &lc.config.General, spoolerOutput)
+
+	if lc.config.General.MemoryLimit != 0 {
+		watchdog.NewMemoryWatchdog(lc.pipeline, lc.config, spoolerImp)
+	}
 
 	// If reading from stdin, don't start prospector, directly start a harvester
 	if lc.stdin {
-		lc.harvester = harvester.NewHarvester(nil, lc.config, &lc.config.Stdin, 0)
+		lc.harvester = harvester.NewHarvester(nil, lc.config, &lc.config.Stdin, registrarImp.Sequence(), 0)
 		lc.harvester.Start(spoolerImp.Connect())
 		harvesterWait = lc.harvester.OnFinish()
+	} else if lc.oneshot {
+		// In oneshot mode, read each matched file once and exit
+		lc.oneshotRun = newOneshot(lc.config, registrarImp.Sequence(), spoolerImp.Connect(), spoolerImp.Flush, lc.oneshotWait)
 	} else {
-		if _, err := prospector.NewProspector(lc.pipeline, lc.config, lc.fromBeginning, registrarImp, spoolerImp); err != nil {
+		var err error
+		if lc.prospector, err = prospector.NewProspector(lc.pipeline, lc.config, lc.fromBeginning, registrarImp, spoolerImp); err != nil {
 			log.Fatalf("Failed to initialise: %s", err)
 		}
+
+		for k := range lc.config.Receivers {
+			receiverImp, err := receiver.NewReceiver(lc.pipeline, lc.config, &lc.config.Receivers[k], spoolerImp)
+			if err != nil {
+				log.Fatalf("Failed to initialise: %s", err)
+			}
+			lc.receivers = append(lc.receivers, receiverImp)
+		}
+
+		for k := range lc.config.Kafka {
+			consumer, err := kafka.NewConsumer(lc.pipeline, lc.config, &lc.config.Kafka[k], spoolerImp)
+			if err != nil {
+				log.Fatalf("Failed to initialise: %s", err)
+			}
+			lc.consumers = append(lc.consumers, consumer)
+		}
 	}
 
 	// Start the pipeline
@@ -123,6 +191,11 @@ func (lc *logCourier) Run() {
 
 	log.Notice("Pipeline ready")
 
+	if lc.oneshotRun != nil {
+		lc.oneshotRun.Start()
+		oneshotWait = lc.oneshotRun.OnFinish()
+	}
+
 	lc.shutdownChan = make(chan os.Signal, 1)
 	lc.reloadChan = make(chan os.Signal, 1)
 	lc.registerSignals()
@@ -134,7 +207,9 @@ SignalLoop:
 			lc.cleanShutdown()
 			break SignalLoop
 		case <-lc.reloadChan:
-			lc.reloadConfig()
+			if err := lc.reloadConfig(); err != nil {
+				log.Warning("Configuration reload failed: %s", err)
+			}
 		case finished := <-harvesterWait:
 			if finished.Error != nil {
 				log.Notice("An error occurred reading from stdin at offset %d: %s", finished.LastReadOffset, finished.Error)
@@ -147,13 +222,26 @@ SignalLoop:
 			spoolerImp.Flush()
 
 			// Wait for StdinRegistrar to receive ACK for the last event we sent
-			registrarImp.(*StdinRegistrar).Wait(finished.LastEventOffset)
+			registrarImp.(*StdinRegistrar).Wait(finished.LastShippedOffset)
+
+			lc.cleanShutdown()
+			break SignalLoop
+		case err := <-oneshotWait:
+			if err != nil {
+				log.Error("Oneshot run failed: %s", err)
+				lc.exitCode = 1
+			} else {
+				log.Notice("Oneshot run complete, all events have been acknowledged")
+			}
+			lc.oneshotRun = nil
 
 			lc.cleanShutdown()
 			break SignalLoop
 		}
 	}
 
+	lc.profiler.shutdown()
+
 	log.Notice("Exiting")
 
 	if lc.logFile != nil {
@@ -167,14 +255,20 @@ func (lc *logCourier) startUp() {
 	var configTest bool
 	var listSupported bool
 	var cpuProfile string
+	var cpuProfileDuration time.Duration
 
 	flag.BoolVar(&version, "version", false, "show version information")
 	flag.BoolVar(&configTest, "config-test", false, "Test the configuration specified by -config and exit")
 	flag.BoolVar(&listSupported, "list-supported", false, "List supported transports and codecs")
 	flag.StringVar(&cpuProfile, "cpuprofile", "", "write cpu profile to file")
+	flag.DurationVar(&cpuProfileDuration, "cpuprofile-duration", 60*time.Second, "how long to run the cpu profiler for")
+	flag.StringVar(&lc.profiler.memProfile, "memprofile", "", "write memory profile to file on shutdown")
+	flag.StringVar(&lc.profiler.blockProfile, "blockprofile", "", "write block profile to file on shutdown")
 
 	flag.StringVar(&lc.configFile, "config", config.DefaultConfigurationFile, "The config file to load")
 	flag.BoolVar(&lc.stdin, "stdin", false, "Read from stdin instead of files listed in the config file")
+	flag.BoolVar(&lc.oneshot, "oneshot", false, "Read the files listed in the config file once, wait for all events to be acknowledged, then exit")
+	flag.DurationVar(&lc.oneshotWait, "oneshot-timeout", 5*time.Minute, "In oneshot mode, how long to wait for events to be acknowledged before exiting with a failure")
 	flag.BoolVar(&lc.fromBeginning, "from-beginning", false, "On first run, read new files from the beginning instead of the end")
 
 	flag.Parse()
@@ -190,13 +284,29 @@ func (lc *logCourier) startUp() {
 			fmt.Printf("  %s\n", transport)
 		}
 
+		fmt.Printf("Available transport protocols:\n")
+		for _, protocol := range config.AvailableTransportProtocols() {
+			fmt.Printf("  %s\n", protocol)
+		}
+
 		fmt.Printf("Available codecs:\n")
 		for _, codec := range config.AvailableCodecs() {
 			fmt.Printf("  %s\n", codec)
 		}
+
+		fmt.Printf("Available processors:\n")
+		for _, processor := range config.AvailableProcessors() {
+			fmt.Printf("  %s\n", processor)
+		}
 		os.Exit(0)
 	}
 
+	if lc.stdin && lc.oneshot {
+		fmt.Fprintf(os.Stderr, "The -stdin and -oneshot options can not be used together.\n\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
 	if lc.configFile == "" {
 		fmt.Fprintf(os.Stderr, "Please specify a configuration file with -config.\n\n")
 		flag.PrintDefaults()
@@ -205,6 +315,11 @@ func (lc *logCourier) startUp() {
 
 	err := lc.loadConfig()
 
+	// Only checked on startup, as files may legitimately disappear later
+	if err == nil && !lc.stdin {
+		err = lc.config.CheckMatchingFiles()
+	}
+
 	if configTest {
 		if err == nil {
 			fmt.Printf("Configuration OK\n")
@@ -225,19 +340,13 @@ func (lc *logCourier) startUp() {
 	}
 
 	if cpuProfile != "" {
-		log.Notice("Starting CPU profiler")
-		f, err := os.Create(cpuProfile)
-		if err != nil {
-			log.Fatal(err)
+		if err = lc.profiler.startCPUProfile(cpuProfile, cpuProfileDuration); err != nil {
+			log.Fatalf("Failed to start CPU profiler: %s", err)
 		}
-		pprof.StartCPUProfile(f)
-		go func() {
-			time.Sleep(60 * time.Second)
-			pprof.StopCPUProfile()
-			log.Panic("CPU profile completed")
-		}()
 	}
 
+	lc.profiler.start()
+
 	runtime.GOMAXPROCS(runtime.NumCPU())
 }
 
@@ -282,7 +391,7 @@ func (lc *logCourier) loadConfig() error {
 
 	if lc.stdin {
 		// TODO: Where to find stdin config for codec and fields?
-	} else if len(lc.config.Files) == 0 {
+	} else if len(lc.config.Files) == 0 && len(lc.config.Receivers) == 0 && len(lc.config.Kafka) == 0 {
 		log.Warning("No file groups were found in the configuration.")
 	}
 
@@ -293,10 +402,28 @@ func (lc *logCourier) loadConfig() error {
 // routines in the pipeline that are subscribed to it, so they may update their
 // runtime configuration
 func (lc *logCourier) reloadConfig() error {
+	previous := lc.config
 	if err := lc.loadConfig(); err != nil {
+		lc.config = previous
+		return err
+	}
+
+	changed, err := lc.checkImmutableSections(previous, lc.config)
+	if err != nil {
+		lc.config = previous
 		return err
 	}
 
+	lc.config.KeepSections(previous, changed)
+
+	// The immutable sections are decided at startup, so a reload can not make a
+	// section mutable again
+	if !reflect.DeepEqual(lc.config.General.ImmutableSections, previous.General.ImmutableSections) || lc.config.General.ImmutableAction != previous.General.ImmutableAction {
+		log.Warning("Changes to /general/immutable sections and /general/immutable sections action require a restart and have been ignored")
+		lc.config.General.ImmutableSections = previous.General.ImmutableSections
+		lc.config.General.ImmutableAction = previous.General.ImmutableAction
+	}
+
 	log.Notice("Configuration reload successful")
 
 	// Update the log level
@@ -314,6 +441,84 @@ func (lc *logCourier) reloadConfig() error {
 	return nil
 }
 
+// checkImmutableSections returns the immutable sections that have changed in
+// the new configuration, logging a warning that the changes will be ignored. If
+// changes to immutable sections are to be rejected an error is returned instead
+func (lc *logCourier) checkImmutableSections(previous *config.Config, newConfig *config.Config) ([]string, error) {
+	changed := newConfig.ChangedImmutableSections(previous)
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	if previous.General.ImmutableAction == "reject" {
+		return nil, fmt.Errorf("immutable sections have changed, a restart is required: %s", strings.Join(changed, ", "))
+	}
+
+	log.Warning("Ignoring changes to immutable sections, a restart is required to apply them: %s", strings.Join(changed, ", "))
+	return changed, nil
+}
+
+// reloadAPI handles a reload requested through the API
+func (lc *logCourier) reloadAPI(processorsOnly bool) error {
+	if processorsOnly {
+		return lc.reloadProcessors()
+	}
+	return lc.reloadConfig()
+}
+
+// reloadProcessors loads the configuration and replaces only the processors of
+// the file groups and receivers, leaving everything else untouched so that
+// files are not reopened and connections are not disturbed. If the new
+// configuration is invalid the existing processors remain in use
+func (lc *logCourier) reloadProcessors() error {
+	newConfig := config.NewConfig()
+	if err := newConfig.Load(lc.configFile, true); err != nil {
+		return err
+	}
+
+	if len(newConfig.Receivers) != len(lc.receivers) {
+		return errors.New("receivers have changed, a full reload is required")
+	}
+
+	if len(newConfig.Kafka) != len(lc.consumers) {
+		return errors.New("kafka consumers have changed, a full reload is required")
+	}
+
+	changed, err := lc.checkImmutableSections(lc.config, newConfig)
+	if err != nil {
+		return err
+	}
+
+	// Processors of immutable sections are part of those sections, so they are
+	// left alone if the sections have changed
+	keep := make(map[string]bool, len(changed))
+	for _, section := range changed {
+		keep[section] = true
+	}
+
+	if !keep["files"] {
+		if err := lc.prospector.ReloadProcessors(newConfig.Files); err != nil {
+			return err
+		}
+	}
+
+	if !keep["receivers"] {
+		for k, receiverImp := range lc.receivers {
+			receiverImp.ReloadProcessors(newConfig.Receivers[k].Processors)
+		}
+	}
+
+	if !keep["kafka"] {
+		for k, consumer := range lc.consumers {
+			consumer.ReloadProcessors(newConfig.Kafka[k].Processors)
+		}
+	}
+
+	log.Notice("Processor reload successful")
+
+	return nil
+}
+
 // cleanShutdown initiates a clean shutdown of log-courier
 func (lc *logCourier) cleanShutdown() {
 	log.Notice("Initiating shutdown")
@@ -324,6 +529,13 @@ func (lc *logCourier) cleanShutdown() {
 		log.Notice("Aborted reading from stdin at offset %d", finished.LastReadOffset)
 	}
 
+	if lc.oneshotRun != nil {
+		lc.oneshotRun.Stop()
+		<-lc.oneshotRun.OnFinish()
+		log.Notice("Aborted oneshot run")
+		lc.exitCode = 1
+	}
+
 	lc.pipeline.Shutdown()
 	lc.pipeline.Wait()
 }
@@ -0,0 +1,280 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/harvester"
+	"github.com/driskell/log-courier/lc-lib/registrar"
+)
+
+// oneshotStream is a file harvested by oneshot mode. Acknowledgements are
+// passed to it directly by the registrar, so it knows when every event read
+// from the file has been acknowledged
+type oneshotStream struct {
+	mutex sync.Mutex
+
+	path     string
+	fileinfo os.FileInfo
+	acked    int64
+	finished bool
+	target   int64
+	done     chan struct{}
+}
+
+// newOneshotStream creates a new oneshotStream for the given file
+func newOneshotStream(path string, fileinfo os.FileInfo) *oneshotStream {
+	return &oneshotStream{
+		path:     path,
+		fileinfo: fileinfo,
+		done:     make(chan struct{}),
+	}
+}
+
+// Info returns the path and file information of the file
+func (s *oneshotStream) Info() (string, os.FileInfo) {
+	return s.path, s.fileinfo
+}
+
+// Ack records that events up to the given offset have been acknowledged
+func (s *oneshotStream) Ack(offset int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.acked = offset
+	s.checkDone()
+}
+
+// finish records the offset of the end of the last event shipped from the
+// file, after which the stream is done once acknowledgements reach it
+func (s *oneshotStream) finish(offset int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.finished = true
+	s.target = offset
+	s.checkDone()
+}
+
+// checkDone signals done if every event has been acknowledged. The mutex must
+// be held
+func (s *oneshotStream) checkDone() {
+	if !s.finished || s.acked < s.target {
+		return
+	}
+
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+}
+
+// oneshot harvests every file matched by the file groups to the end, once,
+// and waits for all of the events read to be acknowledged. Nothing is
+// persisted, so every run ships the files in full
+type oneshot struct {
+	mutex sync.Mutex
+
+	config   *config.Config
+	sequence *registrar.Sequence
+	output   chan<- *core.EventDescriptor
+	flush    func()
+	timeout  time.Duration
+
+	harvester  *harvester.Harvester
+	stopChan   chan struct{}
+	stopOnce   sync.Once
+	resultChan chan error
+}
+
+// newOneshot creates a new oneshot harvest. Events are sent to output, and
+// flush is called once every file has been read so that the spooler does not
+// wait for its timeout. The timeout limits how long to wait for
+// acknowledgements once every file has been read
+func newOneshot(config *config.Config, sequence *registrar.Sequence, output chan<- *core.EventDescriptor, flush func(), timeout time.Duration) *oneshot {
+	return &oneshot{
+		config:     config,
+		sequence:   sequence,
+		output:     output,
+		flush:      flush,
+		timeout:    timeout,
+		stopChan:   make(chan struct{}),
+		resultChan: make(chan error, 1),
+	}
+}
+
+// Start begins harvesting and returns immediately
+func (o *oneshot) Start() {
+	go func() {
+		o.resultChan <- o.run()
+	}()
+}
+
+// OnFinish returns a channel which receives the result once harvesting has
+// completed and every event has been acknowledged, or has failed
+func (o *oneshot) OnFinish() <-chan error {
+	return o.resultChan
+}
+
+// Stop aborts harvesting
+func (o *oneshot) Stop() {
+	o.stopOnce.Do(func() {
+		close(o.stopChan)
+
+		o.mutex.Lock()
+		if o.harvester != nil {
+			o.harvester.Stop()
+		}
+		o.mutex.Unlock()
+	})
+}
+
+// run harvests each file in turn and then waits for the acknowledgements
+func (o *oneshot) run() error {
+	var streams []*oneshotStream
+	var failed []string
+
+	for _, match := range o.matchFiles() {
+		select {
+		case <-o.stopChan:
+			return errors.New("aborted")
+		default:
+		}
+
+		stream, err := o.harvest(match.path, match.fileinfo, match.stream)
+		if err != nil {
+			log.Error("Failed to harvest %s: %s", match.path, err)
+			failed = append(failed, match.path)
+		}
+		if stream != nil {
+			streams = append(streams, stream)
+		}
+	}
+
+	log.Notice("Finished reading %d files, waiting for all events to be acknowledged", len(streams))
+	o.flush()
+
+	timer := time.NewTimer(o.timeout)
+	defer timer.Stop()
+
+	for _, stream := range streams {
+		select {
+		case <-stream.done:
+		case <-timer.C:
+			return fmt.Errorf("timed out after %s waiting for events to be acknowledged", o.timeout)
+		case <-o.stopChan:
+			return errors.New("aborted")
+		}
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to harvest %d files", len(failed))
+	}
+
+	return nil
+}
+
+// oneshotMatch is a file matched by a file group
+type oneshotMatch struct {
+	path     string
+	fileinfo os.FileInfo
+	stream   *config.Stream
+}
+
+// matchFiles returns the files matched by each file group. A file matched by
+// more than one file group is harvested using the first
+func (o *oneshot) matchFiles() []oneshotMatch {
+	var matches []oneshotMatch
+	seen := make(map[string]bool)
+
+	for k := range o.config.Files {
+		for _, path := range o.config.Files[k].Paths {
+			paths, err := filepath.Glob(path)
+			if err != nil {
+				log.Error("Failed to match %s: %s", path, err)
+				continue
+			}
+
+			for _, file := range paths {
+				if seen[file] {
+					continue
+				}
+
+				fileinfo, err := os.Stat(file)
+				if err != nil {
+					log.Error("Failed to stat %s: %s", file, err)
+					continue
+				}
+
+				if fileinfo.IsDir() {
+					continue
+				}
+
+				seen[file] = true
+				matches = append(matches, oneshotMatch{path: file, fileinfo: fileinfo, stream: &o.config.Files[k].Stream})
+			}
+		}
+	}
+
+	return matches
+}
+
+// harvest reads a single file to the end. The stream is returned even if the
+// harvest failed, as events read before the failure must still be
+// acknowledged
+func (o *oneshot) harvest(path string, fileinfo os.FileInfo, streamConfig *config.Stream) (*oneshotStream, error) {
+	stream := newOneshotStream(path, fileinfo)
+
+	o.mutex.Lock()
+	select {
+	case <-o.stopChan:
+		o.mutex.Unlock()
+		return nil, errors.New("aborted")
+	default:
+	}
+
+	log.Info("Reading %s", path)
+	o.harvester = harvester.NewHarvester(stream, o.config, streamConfig, o.sequence, 0)
+	o.harvester.StopAtEOF()
+	o.harvester.Start(o.output)
+	finish := o.harvester.OnFinish()
+	o.mutex.Unlock()
+
+	status := <-finish
+
+	o.mutex.Lock()
+	o.harvester = nil
+	o.mutex.Unlock()
+
+	stream.finish(status.LastShippedOffset)
+
+	if status.Error != nil {
+		return stream, status.Error
+	}
+
+	log.Info("Finished reading %s at offset %d", path, status.LastReadOffset)
+	return stream, nil
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/driskell/log-courier/lc-lib/codecs"
+	"github.com/driskell/log-courier/lc-lib/config"
+	"github.com/driskell/log-courier/lc-lib/core"
+)
+
+func isOneshotStreamDone(stream *oneshotStream) bool {
+	select {
+	case <-stream.done:
+		return true
+	default:
+	}
+	return false
+}
+
+func TestOneshotStreamDone(t *testing.T) {
+	stream := newOneshotStream("test.log", nil)
+
+	stream.Ack(100)
+	if isOneshotStreamDone(stream) {
+		t.Fatal("Stream was done before it was finished")
+	}
+
+	stream.finish(200)
+	if isOneshotStreamDone(stream) {
+		t.Fatal("Stream was done before all events were acknowledged")
+	}
+
+	stream.Ack(200)
+	if !isOneshotStreamDone(stream) {
+		t.Fatal("Stream was not done after all events were acknowledged")
+	}
+
+	// Further acknowledgements must not close the channel again
+	stream.Ack(200)
+}
+
+func TestOneshotStreamEmpty(t *testing.T) {
+	stream := newOneshotStream("test.log", nil)
+
+	stream.finish(0)
+	if !isOneshotStreamDone(stream) {
+		t.Fatal("Stream with no events was not done when finished")
+	}
+}
+
+func TestOneshotMatchFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oneshot")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"app.log", "other.log"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte{}, 0600); err != nil {
+			t.Fatalf("Failed to write file: %s", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "dir.log"), 0700); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+
+	cfg := config.NewConfig()
+	cfg.Files = []config.File{
+		{Paths: []string{filepath.Join(dir, "app.log")}},
+		{Paths: []string{filepath.Join(dir, "*.log")}},
+	}
+
+	matches := newOneshot(cfg, nil, nil, nil, 0).matchFiles()
+	if len(matches) != 2 {
+		t.Fatalf("Incorrect number of matches: %d != 2", len(matches))
+	}
+
+	if matches[0].path != filepath.Join(dir, "app.log") || matches[0].stream != &cfg.Files[0].Stream {
+		t.Errorf("File matched by multiple file groups did not use the first: %s", matches[0].path)
+	}
+	if matches[1].path != filepath.Join(dir, "other.log") || matches[1].stream != &cfg.Files[1].Stream {
+		t.Errorf("Incorrect second match: %s", matches[1].path)
+	}
+}
+
+func TestOneshotDroppedFinalLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oneshot")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The final lines are skipped so no event carries the end of the file
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.log"), []byte("first\nsecond\n\n  \n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	plain, err := codecs.NewPlainCodecFactory(cfg, "", map[string]interface{}{}, "plain")
+	if err != nil {
+		t.Fatalf("Failed to create plain codec: %s", err)
+	}
+
+	cfg.Files = []config.File{{Paths: []string{filepath.Join(dir, "app.log")}}}
+	cfg.Files[0].Stream.InitDefaults()
+	cfg.Files[0].Stream.MessageField = "message"
+	cfg.Files[0].Stream.SkipEmptyLines = true
+	cfg.Files[0].Stream.Codecs = []config.CodecStub{{Name: "plain", Factory: plain}}
+
+	output := make(chan *core.EventDescriptor, 10)
+	o := newOneshot(cfg, nil, output, func() {}, 5*time.Second)
+	o.Start()
+	defer o.Stop()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case desc := <-output:
+			desc.Stream.(*oneshotStream).Ack(desc.Offset)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for event")
+		}
+	}
+
+	select {
+	case err := <-o.OnFinish():
+		if err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Oneshot did not finish once every event was acknowledged")
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2014-2015 Jason Woods.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// profiler manages the optional CPU, memory and block profiles requested on
+// the command line
+type profiler struct {
+	mutex sync.Mutex
+
+	cpuFile      *os.File
+	cpuTimer     *time.Timer
+	memProfile   string
+	blockProfile string
+}
+
+// startCPUProfile begins CPU profiling to the given file, stopping after the
+// given duration, or at shutdown if that happens first
+func (p *profiler) startCPUProfile(path string, duration time.Duration) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err = pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	log.Notice("Starting CPU profiler for %s", duration)
+
+	p.cpuFile = f
+	p.cpuTimer = time.AfterFunc(duration, p.stopCPUProfile)
+	return nil
+}
+
+// stopCPUProfile stops CPU profiling, if it is running, and writes the profile
+func (p *profiler) stopCPUProfile() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.cpuFile == nil {
+		return
+	}
+
+	p.cpuTimer.Stop()
+	pprof.StopCPUProfile()
+
+	if err := p.cpuFile.Close(); err != nil {
+		log.Errorf("Failed to write CPU profile to %s: %s", p.cpuFile.Name(), err)
+	} else {
+		log.Notice("CPU profile completed and written to %s", p.cpuFile.Name())
+	}
+
+	p.cpuFile = nil
+}
+
+// start begins recording of blocking events if a block profile was requested,
+// so that it can be written at shutdown
+func (p *profiler) start() {
+	if p.blockProfile != "" {
+		runtime.SetBlockProfileRate(1)
+	}
+}
+
+// shutdown stops any running CPU profile and writes the memory and block
+// profiles, if requested
+func (p *profiler) shutdown() {
+	p.stopCPUProfile()
+
+	if p.memProfile != "" {
+		// Collect garbage so the profile reflects live allocations
+		runtime.GC()
+		p.writeProfile("memory", p.memProfile, pprof.Lookup("heap"))
+	}
+
+	if p.blockProfile != "" {
+		p.writeProfile("block", p.blockProfile, pprof.Lookup("block"))
+	}
+}
+
+// writeProfile writes a single profile to the given path
+func (p *profiler) writeProfile(name string, path string, profile *pprof.Profile) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Errorf("Failed to write %s profile: %s", name, err)
+		return
+	}
+
+	err = profile.WriteTo(f, 0)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		log.Errorf("Failed to write %s profile to %s: %s", name, path, err)
+		return
+	}
+
+	log.Notice("Wrote %s profile to %s", name, path)
+}
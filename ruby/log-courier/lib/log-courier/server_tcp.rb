@@ -17,6 +17,7 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
+require 'ipaddr'
 require 'openssl'
 require 'socket'
 require 'thread'
@@ -25,6 +26,7 @@ module LogCourier
   # Wrap around TCPServer to grab last error for use in reporting which peer had an error
   class ExtendedTCPServer < TCPServer
     attr_reader :peer
+    attr_reader :peer_address
 
     def initialise
       reset_peer
@@ -42,11 +44,13 @@ module LogCourier
         peer = sock.peeraddr
       end
       @peer = "#{peer[2]}:#{peer[1]}"
+      @peer_address = peer[3]
       return sock
     end
 
     def reset_peer
       @peer = 'unknown'
+      @peer_address = nil
       return
     end
   end
@@ -54,6 +58,7 @@ module LogCourier
   # TLS transport implementation for server
   class ServerTcp
     attr_reader :port
+    attr_reader :rejected_connections
 
     # Create a new TLS transport endpoint
     def initialize(options = {})
@@ -70,9 +75,16 @@ module LogCourier
         ssl_verify_ca:         nil,
         max_packet_size:       10_485_760,
         add_peer_fields:       false,
+        allow:                 [],
+        deny:                  [],
+        on_reject:             nil,
       }.merge!(options)
 
       @logger = @options[:logger]
+      @rejected_connections = 0
+
+      @allow = parse_cidrs(:allow)
+      @deny = parse_cidrs(:deny)
 
       if @options[:transport] == 'tls'
         [:ssl_certificate, :ssl_key].each do |k|
@@ -155,6 +167,15 @@ module LogCourier
           next
         end
 
+        # Reject disallowed peers before any TLS handshake takes place
+        unless allowed?(@tcp_server.peer_address)
+          @rejected_connections += 1
+          @logger.warn 'Connection rejected by allow/deny list', :peer => @tcp_server.peer unless @logger.nil?
+          @options[:on_reject].call @tcp_server.peer unless @options[:on_reject].nil?
+          client.close rescue nil
+          next
+        end
+
     	  @logger.info 'New connection', :peer => @tcp_server.peer unless @logger.nil?
 
         # Clear up finished threads
@@ -187,6 +208,34 @@ module LogCourier
 
     private
 
+    def parse_cidrs(key)
+      Array(@options[key]).map do |cidr|
+        begin
+          IPAddr.new(cidr)
+        rescue ArgumentError => e
+          raise "input/courier: '#{key}' contains an invalid address or CIDR: #{cidr} (#{e})"
+        end
+      end
+    end
+
+    # Deny takes precedence over allow, and an empty allow list allows all
+    def allowed?(address)
+      return true if @allow.empty? && @deny.empty?
+      return false if address.nil?
+
+      begin
+        ip = IPAddr.new(address)
+      rescue ArgumentError
+        return false
+      end
+
+      # Compare IPv4-mapped IPv6 peers using their IPv4 address
+      ip = ip.native if ip.ipv4_mapped?
+
+      return false if @deny.any? { |cidr| cidr.include?(ip) }
+      @allow.empty? || @allow.any? { |cidr| cidr.include?(ip) }
+    end
+
     def run_thread(client, peer, &block)
       begin
         # Perform the handshake inside the new thread so we don't block TCP accept
@@ -0,0 +1,103 @@
+# encoding: utf-8
+
+# Copyright 2014 Jason Woods.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+require 'cabin'
+require 'socket'
+require 'timeout'
+require 'lib/common'
+
+require 'log-courier/server_tcp'
+
+describe 'log-courier tcp server' do
+  def create_server(options = {})
+    logger = Cabin::Channel.new
+    logger.subscribe STDOUT
+    logger.level = :debug
+
+    LogCourier::ServerTcp.new(
+      {
+        transport: 'tcp',
+        address:   '127.0.0.1',
+        logger:    logger
+      }.merge!(options)
+    )
+  end
+
+  def allowed?(server, address)
+    server.send :allowed?, address
+  end
+
+  it 'should allow all addresses by default' do
+    server = create_server
+    expect(allowed?(server, '192.0.2.1')).to eq true
+    expect(allowed?(server, '2001:db8::1')).to eq true
+  end
+
+  it 'should only allow addresses in the allow list' do
+    server = create_server allow: ['192.0.2.0/24', '2001:db8::/32']
+    expect(allowed?(server, '192.0.2.1')).to eq true
+    expect(allowed?(server, '2001:db8::1')).to eq true
+    expect(allowed?(server, '198.51.100.1')).to eq false
+    expect(allowed?(server, '2001:db9::1')).to eq false
+  end
+
+  it 'should reject addresses in the deny list' do
+    server = create_server deny: ['192.0.2.1', '2001:db8::/32']
+    expect(allowed?(server, '192.0.2.1')).to eq false
+    expect(allowed?(server, '2001:db8::1')).to eq false
+    expect(allowed?(server, '192.0.2.2')).to eq true
+  end
+
+  it 'should give the deny list precedence over the allow list' do
+    server = create_server allow: ['192.0.2.0/24'], deny: ['192.0.2.128/25']
+    expect(allowed?(server, '192.0.2.1')).to eq true
+    expect(allowed?(server, '192.0.2.200')).to eq false
+  end
+
+  it 'should match IPv4-mapped peers against IPv4 ranges' do
+    server = create_server allow: ['192.0.2.0/24'], deny: ['192.0.2.128/25']
+    expect(allowed?(server, '::ffff:192.0.2.1')).to eq true
+    expect(allowed?(server, '::ffff:192.0.2.200')).to eq false
+    expect(allowed?(server, '::ffff:198.51.100.1')).to eq false
+  end
+
+  it 'should fail to start with an invalid address' do
+    expect { create_server allow: ['not an address'] }.to raise_error(/invalid address/)
+  end
+
+  it 'should count rejected connections' do
+    rejected = []
+    server = create_server deny: ['127.0.0.1'], on_reject: lambda { |peer| rejected << peer }
+    thread = Thread.new do
+      server.run do
+        fail 'Rejected connection was accepted'
+      end
+    end
+
+    socket = TCPSocket.new('127.0.0.1', server.port)
+    Timeout.timeout(10) do
+      # The server closes the connection without reading from it
+      expect(socket.read).to eq ''
+    end
+    socket.close
+
+    expect(server.rejected_connections).to eq 1
+    expect(rejected.length).to eq 1
+
+    thread.raise LogCourier::ShutdownSignal
+    thread.join
+  end
+end
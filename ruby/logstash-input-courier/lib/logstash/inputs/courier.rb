@@ -89,6 +89,18 @@ module LogStash
       # using client certificates
       config :add_peer_fields, validate: :boolean
 
+      # Addresses or CIDR ranges, IPv4 or IPv6, that are allowed to connect
+      #
+      # An empty list allows all addresses not in the deny list. This setting
+      # is only effective with the tcp and tls transports
+      config :allow, validate: :array
+
+      # Addresses or CIDR ranges, IPv4 or IPv6, that are not allowed to connect
+      #
+      # This takes precedence over the allow list. This setting is only
+      # effective with the tcp and tls transports
+      config :deny, validate: :array
+
       public
 
       def register
@@ -128,6 +140,7 @@ module LogStash
         }
 
         add_plugin_options result
+        add_metric_options result
         add_override_options result
       end
 
@@ -141,9 +154,19 @@ module LogStash
         end
       end
 
+      def add_metric_options(result)
+        # Plugin metrics are only available in Logstash >= 5.0.0
+        return unless respond_to?(:metric)
+        result[:on_reject] = lambda do |_peer|
+          metric.increment :rejected_connections
+        end
+      end
+
       def add_override_options(result)
         # Honour the defaults in the LogCourier gem
-        [:max_packet_size, :peer_recv_queue, :add_peer_fields].each do |k|
+        [
+          :max_packet_size, :peer_recv_queue, :add_peer_fields, :allow, :deny
+        ].each do |k|
           result[k] = send(k) unless send(k).nil?
         end
         result
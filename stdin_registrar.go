@@ -20,6 +20,7 @@
 package main
 
 import (
+	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/core"
 	"github.com/driskell/log-courier/lc-lib/registrar"
 	"sync"
@@ -36,14 +37,20 @@ type StdinRegistrar struct {
 	references     int
 	wait_offset    *int64
 	last_offset    int64
+	sequence       *registrar.Sequence
 }
 
-func newStdinRegistrar(pipeline *core.Pipeline) *StdinRegistrar {
+func newStdinRegistrar(pipeline *core.Pipeline, config *config.Config) *StdinRegistrar {
 	ret := &StdinRegistrar{
 		registrar_chan: make(chan []registrar.EventProcessor, 16),
 		signal_chan:    make(chan int64, 1),
 	}
 
+	// Nothing is persisted when reading stdin, so the sequence starts afresh
+	if config.General.EventSequence {
+		ret.sequence = registrar.NewSequence("")
+	}
+
 	ret.group.Add(1)
 
 	pipeline.Register(ret)
@@ -67,7 +74,7 @@ RegistrarLoop:
 			r.wait_offset = new(int64)
 			*r.wait_offset = signal
 
-			if r.last_offset == signal {
+			if r.last_offset >= signal {
 				break RegistrarLoop
 			}
 
@@ -103,6 +110,10 @@ func (r *StdinRegistrar) Wait(offset int64) {
 	r.group.Wait()
 }
 
+func (r *StdinRegistrar) Sequence() *registrar.Sequence {
+	return r.sequence
+}
+
 func (r *StdinRegistrar) LoadPrevious(registrar.LoadPreviousFunc) (bool, error) {
 	return false, nil
 }
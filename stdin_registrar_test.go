@@ -20,15 +20,20 @@
 package main
 
 import (
+	"github.com/driskell/log-courier/lc-lib/codecs"
+	"github.com/driskell/log-courier/lc-lib/config"
 	"github.com/driskell/log-courier/lc-lib/core"
+	"github.com/driskell/log-courier/lc-lib/harvester"
 	"github.com/driskell/log-courier/lc-lib/registrar"
+	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 )
 
 func newTestStdinRegistrar() (*core.Pipeline, *StdinRegistrar) {
 	pipeline := core.NewPipeline()
-	return pipeline, newStdinRegistrar(pipeline)
+	return pipeline, newStdinRegistrar(pipeline, config.NewConfig())
 }
 
 func newEventSpool(offset int64) []*core.EventDescriptor {
@@ -76,3 +81,72 @@ func TestStdinRegistrarWait(t *testing.T) {
 		t.Error("Wait offset was incorrect: ", r.wait_offset)
 	}
 }
+
+func TestStdinRegistrarWaitSkippedLines(t *testing.T) {
+	file, err := ioutil.TempFile("", "stdin")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(file.Name())
+
+	// Stdin ends with lines that are skipped, so no event carries its end
+	if _, err := file.WriteString("first\n\n  \n"); err != nil {
+		t.Fatalf("Failed to write temporary file: %s", err)
+	}
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		t.Fatalf("Failed to seek temporary file: %s", err)
+	}
+
+	stdin := os.Stdin
+	os.Stdin = file
+	defer func() {
+		os.Stdin = stdin
+	}()
+
+	cfg := config.NewConfig()
+	cfg.General.InitDefaults()
+	plain, err := codecs.NewPlainCodecFactory(cfg, "", map[string]interface{}{}, "plain")
+	if err != nil {
+		t.Fatalf("Failed to create plain codec: %s", err)
+	}
+
+	streamConfig := &config.Stream{}
+	streamConfig.InitDefaults()
+	streamConfig.MessageField = "message"
+	streamConfig.SkipEmptyLines = true
+	streamConfig.Codecs = []config.CodecStub{{Name: "plain", Factory: plain}}
+
+	output := make(chan *core.EventDescriptor, 10)
+	h := harvester.NewHarvester(nil, cfg, streamConfig, nil, 0)
+	h.Start(output)
+	defer h.Stop()
+
+	var finished *harvester.FinishStatus
+	select {
+	case finished = <-h.OnFinish():
+	case <-time.After(10 * time.Second):
+		t.Fatal("Harvester did not stop at the end of stdin")
+	}
+
+	p, r := newTestStdinRegistrar()
+	go func() {
+		r.Run()
+	}()
+
+	c := r.Connect()
+	c.Add(registrar.NewAckEvent([]*core.EventDescriptor{<-output}))
+	c.Send()
+
+	wait := make(chan int)
+	go func() {
+		r.Wait(finished.LastShippedOffset)
+		p.Wait()
+		wait <- 1
+	}()
+
+	select {
+	case <-wait:
+	case <-time.After(5 * time.Second):
+		t.Error("Timeout waiting for stdin registrar shutdown")
+	}
+}